@@ -0,0 +1,7 @@
+"""
+DevServer HTTP API.
+
+An optional component that lets internal portals and bots create, list,
+extend and connect to DevServers over HTTP, authenticated with OIDC, without
+handing out Kubernetes credentials. Run it with `python -m devservers.api`.
+"""
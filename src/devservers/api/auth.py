@@ -0,0 +1,141 @@
+"""
+OIDC bearer token authentication for the DevServer API.
+
+Tokens are JWTs signed with RS256 by the configured issuer. Its signing keys
+are discovered through `<issuer>/.well-known/openid-configuration` and cached,
+and refetched when a token is signed with a key that is not known yet (i.e.
+after the issuer rotated its keys), at most every `KEYS_REFETCH_INTERVAL`
+seconds, so that tokens with made-up key IDs cannot flood the issuer.
+"""
+import asyncio
+import base64
+import json
+import time
+from typing import Any, Dict, List, Optional
+
+import aiohttp
+import rsa
+
+# Tolerated clock skew between the issuer and the API server, in seconds
+CLOCK_SKEW_SECONDS = 60
+# The least time between two fetches of the issuer's signing keys, in seconds
+KEYS_REFETCH_INTERVAL = 60
+
+
+class AuthenticationError(Exception):
+    """The bearer token is missing, malformed, expired or not trusted."""
+
+
+def _b64url_decode(data: str) -> bytes:
+    return base64.urlsafe_b64decode(data + "=" * (-len(data) % 4))
+
+
+def _b64url_to_int(data: str) -> int:
+    return int.from_bytes(_b64url_decode(data), "big")
+
+
+def _is_number(value: Any) -> bool:
+    return isinstance(value, (int, float)) and not isinstance(value, bool)
+
+
+def _public_key(jwk: Dict[str, Any]) -> rsa.PublicKey:
+    return rsa.PublicKey(_b64url_to_int(jwk["n"]), _b64url_to_int(jwk["e"]))
+
+
+class OIDCVerifier:
+    """Verifies OIDC ID tokens (or JWT access tokens) issued for an audience."""
+
+    def __init__(self, issuer: str, audience: str, username_claim: str = "preferred_username"):
+        self.issuer = issuer.rstrip("/")
+        self.audience = audience
+        self.username_claim = username_claim
+        self._keys: Dict[str, Dict[str, Any]] = {}
+        self._fetched_at: Optional[float] = None
+        self._lock = asyncio.Lock()
+
+    async def _fetch_keys(self) -> List[Dict[str, Any]]:
+        """Fetch the issuer's JSON Web Key Set."""
+        async with aiohttp.ClientSession(raise_for_status=True) as session:
+            discovery_url = f"{self.issuer}/.well-known/openid-configuration"
+            async with session.get(discovery_url) as response:
+                discovery = await response.json()
+            async with session.get(discovery["jwks_uri"]) as response:
+                return (await response.json()).get("keys", [])
+
+    async def _get_key(self, kid: Optional[str]) -> Dict[str, Any]:
+        async with self._lock:
+            now = time.monotonic()
+            if kid not in self._keys and (
+                self._fetched_at is None or now - self._fetched_at >= KEYS_REFETCH_INTERVAL
+            ):
+                self._fetched_at = now
+                try:
+                    keys = await self._fetch_keys()
+                except (aiohttp.ClientError, KeyError, ValueError) as e:
+                    raise AuthenticationError(f"Could not fetch the issuer's signing keys: {e}")
+                self._keys = {key.get("kid"): key for key in keys if key.get("kty") == "RSA"}
+            if kid not in self._keys:
+                raise AuthenticationError("Token is signed with an unknown key.")
+            return self._keys[kid]
+
+    async def verify(self, token: str) -> Dict[str, Any]:
+        """
+        Verify a token's signature, issuer, audience and validity period.
+
+        Returns:
+            The token's claims.
+
+        Raises:
+            AuthenticationError: If the token cannot be trusted.
+        """
+        try:
+            encoded_header, encoded_payload, encoded_signature = token.split(".")
+            header = json.loads(_b64url_decode(encoded_header))
+            claims = json.loads(_b64url_decode(encoded_payload))
+            signature = _b64url_decode(encoded_signature)
+        except ValueError:
+            raise AuthenticationError("Malformed token.")
+        # Valid JSON, but not necessarily what a JWT holds
+        if not isinstance(header, dict) or not isinstance(claims, dict):
+            raise AuthenticationError("Malformed token.")
+        if not isinstance(header.get("kid"), (str, type(None))):
+            raise AuthenticationError("Malformed token.")
+
+        if header.get("alg") != "RS256":
+            raise AuthenticationError(f"Unsupported token algorithm '{header.get('alg')}'.")
+
+        key = await self._get_key(header.get("kid"))
+        try:
+            hash_method = rsa.verify(
+                f"{encoded_header}.{encoded_payload}".encode(), signature, _public_key(key)
+            )
+        except rsa.VerificationError:
+            raise AuthenticationError("Invalid token signature.")
+        # rsa accepts signatures with any hash, but RS256 is SHA-256
+        if hash_method != "SHA-256":
+            raise AuthenticationError("Invalid token signature.")
+
+        issuer = claims.get("iss", "")
+        if not isinstance(issuer, str) or issuer.rstrip("/") != self.issuer:
+            raise AuthenticationError("Token was not issued by the trusted issuer.")
+        audiences = claims.get("aud", [])
+        if isinstance(audiences, str):
+            audiences = [audiences]
+        if not isinstance(audiences, list) or self.audience not in audiences:
+            raise AuthenticationError("Token was not issued for this API.")
+        expires_at, not_before = claims.get("exp", 0), claims.get("nbf", 0)
+        if not all(_is_number(value) for value in (expires_at, not_before)):
+            raise AuthenticationError("Token has an invalid validity period.")
+        now = time.time()
+        if expires_at < now - CLOCK_SKEW_SECONDS:
+            raise AuthenticationError("Token has expired.")
+        if not_before > now + CLOCK_SKEW_SECONDS:
+            raise AuthenticationError("Token is not valid yet.")
+        return claims
+
+    def username(self, claims: Dict[str, Any]) -> str:
+        """Return the user a verified token was issued to."""
+        username = claims.get(self.username_claim)
+        if not username or not isinstance(username, str):
+            raise AuthenticationError(f"Token has no '{self.username_claim}' claim.")
+        return username
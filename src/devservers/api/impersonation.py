@@ -0,0 +1,27 @@
+"""
+Kubernetes clients that act as the users DevServers are created for.
+
+The API creates DevServers by impersonating their owner, rather than as its
+own service account, so that the operator's owner identity webhook checks the
+owner against the user, and the user's own RBAC applies. The impersonated user
+name is the owner prefixed with `DEVSERVER_API_IMPERSONATION_PREFIX`, for
+clusters whose RBAC names OIDC users with the API server's
+`--oidc-username-prefix`.
+"""
+import os
+
+from kubernetes import client
+
+IMPERSONATION_PREFIX = os.environ.get("DEVSERVER_API_IMPERSONATION_PREFIX", "")
+
+
+def impersonated_user(owner: str) -> str:
+    """The cluster user the API acts as for an owner."""
+    return f"{IMPERSONATION_PREFIX}{owner}"
+
+
+def custom_objects_api_as(owner: str) -> client.CustomObjectsApi:
+    """A CustomObjectsApi whose requests are made as the owner."""
+    api_client = client.ApiClient()
+    api_client.set_default_header("Impersonate-User", impersonated_user(owner))
+    return client.CustomObjectsApi(api_client)
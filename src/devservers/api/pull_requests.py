@@ -0,0 +1,466 @@
+"""
+Ephemeral DevServers for pull requests.
+
+GitHub and GitLab webhooks of pull (merge) requests are received at
+`/webhooks/github` and `/webhooks/gitlab`. Adding the `DEVSERVER_PR_LABEL`
+label to a pull request creates a DevServer for whoever added it, in their
+`dev-<user>` namespace, with the pull request's branch cloned into
+`~/<repository>`, and comments how to connect on the pull request. Merging
+or closing the pull request, or removing the label, deletes it again.
+
+Forge logins are not identities of the cluster, so the DevServer's owner is
+the DevServerUser whose `spec.forgeLogins` claims the login, and pull
+requests labeled by anyone else are ignored. The DevServer is created as the
+owner, impersonating them.
+
+The DevServers are labeled with their pull request, so that they are found
+again regardless of who closes it. Webhooks are verified with the
+`X-Hub-Signature-256` of GitHub and the `X-Gitlab-Token` of GitLab, and an
+endpoint whose secret is not configured does not exist.
+"""
+import asyncio
+import hashlib
+import hmac
+import json
+import logging
+import os
+import re
+from typing import Any, Dict, List, NamedTuple, Optional, Tuple
+
+import aiohttp
+from aiohttp import web
+from kubernetes import client
+
+from .impersonation import custom_objects_api_as
+from ..crds.base import ObjectMeta
+from ..crds.const import (
+    CRD_GROUP,
+    CRD_PLURAL_DEVSERVER,
+    CRD_PLURAL_DEVSERVERUSER,
+    CRD_VERSION,
+    DEFAULT_HOME_MOUNT_PATH,
+    MAX_DEVSERVER_NAME_LENGTH,
+)
+from ..crds.devserver import DevServer
+from ..utils.users import compute_user_namespace, owner_to_dns_label
+
+PR_LABEL = os.environ.get("DEVSERVER_PR_LABEL", "devserver")
+PR_TIME_TO_LIVE = os.environ.get("DEVSERVER_PR_TIME_TO_LIVE", "3d")
+PR_FLAVOR = os.environ.get("DEVSERVER_PR_FLAVOR")
+PR_TEMPLATE = os.environ.get("DEVSERVER_PR_TEMPLATE")
+PR_GIT_IMAGE = os.environ.get("DEVSERVER_PR_GIT_IMAGE", "alpine/git:2.45.2")
+# A Secret in the users' namespaces whose `token` key can clone private repositories
+PR_GIT_TOKEN_SECRET = os.environ.get("DEVSERVER_PR_GIT_TOKEN_SECRET")
+
+GITHUB_WEBHOOK_SECRET = os.environ.get("DEVSERVER_GITHUB_WEBHOOK_SECRET", "")
+GITHUB_TOKEN = os.environ.get("DEVSERVER_GITHUB_TOKEN", "")
+GITHUB_API_URL = os.environ.get("DEVSERVER_GITHUB_API_URL", "https://api.github.com")
+GITLAB_WEBHOOK_TOKEN = os.environ.get("DEVSERVER_GITLAB_WEBHOOK_TOKEN", "")
+GITLAB_TOKEN = os.environ.get("DEVSERVER_GITLAB_TOKEN", "")
+GITLAB_API_URL = os.environ.get("DEVSERVER_GITLAB_API_URL", "https://gitlab.com/api/v4")
+
+# The dev user's UID/GID when the owner's DevServerUser pins none, as on the operator
+DEFAULT_DEV_ID = 1000
+# Where the clone container mounts the home volume. The clone lands in the
+# volume, so the DevServer finds it in its home directory wherever its
+# spec.homeMountPath, e.g. from DEVSERVER_PR_TEMPLATE, mounts it.
+CLONE_HOME_PATH = DEFAULT_HOME_MOUNT_PATH
+
+PULL_REQUEST_LABEL = "devserver.io/pull-request"
+PULL_REQUEST_URL_ANNOTATION = "devserver.io/pull-request-url"
+COMMENT_TIMEOUT_SECONDS = 10
+
+# Branch, URL and directory come from the webhook, so they are passed as
+# environment variables rather than interpolated into the script
+CLONE_SCRIPT = """\
+set -e
+test -d "$DEST/.git" && exit 0
+if [ -n "$GIT_TOKEN" ]; then
+  AUTH=$(printf '%s:%s' "$GIT_USER" "$GIT_TOKEN" | base64 | tr -d '\\n')
+  exec git -c http.extraHeader="Authorization: Basic $AUTH" \\
+    clone --branch "$BRANCH" -- "$URL" "$DEST"
+fi
+exec git clone --branch "$BRANCH" -- "$URL" "$DEST"
+"""
+
+# The user name that goes with an access token over HTTPS
+GIT_TOKEN_USERS = {"github": "x-access-token", "gitlab": "oauth2"}
+
+OPEN = "open"
+CLOSE = "close"
+
+logger = logging.getLogger(__name__)
+
+
+class PullRequest(NamedTuple):
+    provider: str
+    repository_id: str
+    repository: str
+    number: int
+    branch: str
+    clone_url: str
+    url: str
+    user: str
+
+
+def _dns_label(value: str) -> str:
+    return re.sub(r"-{2,}", "-", re.sub(r"[^a-z0-9-]+", "-", value.lower())).strip("-")
+
+
+def devserver_name(pr: PullRequest) -> str:
+    """The DevServer of a pull request, e.g. `pr-devserver-42`."""
+    suffix = f"-{pr.number}"
+    repository = _dns_label(pr.repository.rsplit("/", 1)[-1])
+    prefix = f"pr-{repository}"[: MAX_DEVSERVER_NAME_LENGTH - len(suffix)].rstrip("-")
+    return prefix + suffix
+
+
+def pull_request_label(pr: PullRequest) -> str:
+    """The value of the label that finds a pull request's DevServer."""
+    return f"{pr.provider}-{pr.repository_id}-{pr.number}"
+
+
+def find_owner(pr: PullRequest, users: List[Dict[str, Any]]) -> Optional[Dict[str, Any]]:
+    """
+    The DevServerUser with the pull request's user among its
+    `spec.forgeLogins`. Logins are case-insensitive on GitHub and GitLab.
+
+    Raises:
+        ValueError: If several DevServerUsers claim the login.
+    """
+    owners = [
+        user
+        for user in users
+        if (user.get("spec", {}).get("forgeLogins", {}).get(pr.provider) or "").lower()
+        == pr.user.lower()
+    ]
+    if len(owners) > 1:
+        usernames = ", ".join(sorted(user["spec"]["username"] for user in owners))
+        raise ValueError(f"{pr.provider} login '{pr.user}' is claimed by {usernames}.")
+    return owners[0] if owners else None
+
+
+def owner_ids(user: Dict[str, Any]) -> Tuple[int, int]:
+    """The UID and GID of the dev user of the DevServerUser's DevServers."""
+    posix = user.get("spec", {}).get("posix") or {}
+    uid = posix.get("uid", DEFAULT_DEV_ID)
+    return uid, posix.get("gid", uid)
+
+
+def clone_directory(pr: PullRequest) -> str:
+    """Where in the home directory the pull request's branch is cloned, e.g. `devserver`."""
+    return _dns_label(pr.repository.rsplit("/", 1)[-1]) or "repository"
+
+
+def clone_container(pr: PullRequest, user: Dict[str, Any]) -> Dict[str, Any]:
+    """
+    The init container that clones the pull request's branch into the home
+    directory, as the owner's dev user.
+    """
+    env: List[Dict[str, Any]] = [
+        {"name": "URL", "value": pr.clone_url},
+        {"name": "BRANCH", "value": pr.branch},
+        {"name": "DEST", "value": f"{CLONE_HOME_PATH}/{clone_directory(pr)}"},
+    ]
+    if PR_GIT_TOKEN_SECRET:
+        env.append({"name": "GIT_USER", "value": GIT_TOKEN_USERS[pr.provider]})
+        env.append(
+            {
+                "name": "GIT_TOKEN",
+                "valueFrom": {
+                    "secretKeyRef": {
+                        "name": PR_GIT_TOKEN_SECRET,
+                        "key": "token",
+                        "optional": True,
+                    }
+                },
+            }
+        )
+    uid, gid = owner_ids(user)
+    return {
+        "name": "clone-pull-request",
+        "image": PR_GIT_IMAGE,
+        "command": ["sh", "-c", CLONE_SCRIPT],
+        "env": env,
+        "securityContext": {"runAsUser": uid, "runAsGroup": gid},
+        "volumeMounts": [{"name": "home", "mountPath": CLONE_HOME_PATH}],
+    }
+
+
+def build_devserver(pr: PullRequest, user: Dict[str, Any]) -> DevServer:
+    owner = user["spec"]["username"]
+    spec: Dict[str, Any] = {
+        "owner": owner,
+        "ssh": {},
+        "enableSSH": True,
+        "lifecycle": {"timeToLive": PR_TIME_TO_LIVE},
+        "initContainers": [clone_container(pr, user)],
+    }
+    if PR_FLAVOR:
+        spec["flavor"] = PR_FLAVOR
+    if PR_TEMPLATE:
+        spec["template"] = PR_TEMPLATE
+    return DevServer(
+        metadata=ObjectMeta(
+            name=devserver_name(pr),
+            namespace=compute_user_namespace(owner_to_dns_label(owner)),
+            labels={PULL_REQUEST_LABEL: pull_request_label(pr)},
+            annotations={PULL_REQUEST_URL_ANNOTATION: pr.url},
+        ),
+        spec=spec,
+        api=custom_objects_api_as(owner),
+    )
+
+
+def comment(devserver: DevServer, pr: PullRequest) -> str:
+    """The comment with the connection info of a pull request's DevServer."""
+    name, namespace = devserver.metadata.name, devserver.metadata.namespace
+    return (
+        f"DevServer `{name}` is starting for @{pr.user} with `{pr.branch}` "
+        f"cloned into `~/{clone_directory(pr)}`. Connect once it is ready with:\n\n"
+        f"```\ndevctl ssh {name} -n {namespace}\n```\n\n"
+        f"It expires in {PR_TIME_TO_LIVE}, and is deleted when this pull request "
+        f"is merged or closed, or the `{PR_LABEL}` label is removed."
+    )
+
+
+def parse_github(payload: Dict[str, Any]) -> PullRequest:
+    pr = payload["pull_request"]
+    repository = payload["repository"]
+    # The head repository of a fork is gone once the fork is deleted
+    head_repository = pr["head"].get("repo") or repository
+    return PullRequest(
+        provider="github",
+        repository_id=str(repository["id"]),
+        repository=repository["full_name"],
+        number=pr["number"],
+        branch=pr["head"]["ref"],
+        clone_url=head_repository["clone_url"],
+        url=pr["html_url"],
+        user=payload["sender"]["login"],
+    )
+
+
+def github_action(payload: Dict[str, Any]) -> Optional[str]:
+    """Whether a pull_request webhook opens or closes the pull request's DevServer."""
+    action = payload.get("action")
+    label = (payload.get("label") or {}).get("name")
+    labels = [label["name"] for label in payload["pull_request"].get("labels", [])]
+    if action == "closed" or (action == "unlabeled" and label == PR_LABEL):
+        return CLOSE
+    if (action == "labeled" and label == PR_LABEL) or (
+        action == "reopened" and PR_LABEL in labels
+    ):
+        return OPEN
+    return None
+
+
+def parse_gitlab(payload: Dict[str, Any]) -> PullRequest:
+    attributes = payload["object_attributes"]
+    project = payload["project"]
+    return PullRequest(
+        provider="gitlab",
+        repository_id=str(project["id"]),
+        repository=project["path_with_namespace"],
+        number=attributes["iid"],
+        branch=attributes["source_branch"],
+        clone_url=attributes["source"]["git_http_url"],
+        url=attributes["url"],
+        user=payload["user"]["username"],
+    )
+
+
+def gitlab_action(payload: Dict[str, Any]) -> Optional[str]:
+    """Whether a merge request webhook opens or closes the merge request's DevServer."""
+    action = payload["object_attributes"].get("action")
+    labels = [label["title"] for label in payload.get("labels", [])]
+    changes = payload.get("changes", {}).get("labels", {})
+    previous = [label["title"] for label in changes.get("previous") or []]
+    current = [label["title"] for label in changes.get("current") or []]
+    if action in ("close", "merge") or (PR_LABEL in previous and PR_LABEL not in current):
+        return CLOSE
+    if (PR_LABEL in current and PR_LABEL not in previous) or (
+        action in ("open", "reopen") and PR_LABEL in labels
+    ):
+        return OPEN
+    return None
+
+
+async def _post_comment(pr: PullRequest, body: str) -> None:
+    if pr.provider == "github":
+        if not GITHUB_TOKEN:
+            return
+        url = f"{GITHUB_API_URL}/repos/{pr.repository}/issues/{pr.number}/comments"
+        headers = {
+            "Authorization": f"Bearer {GITHUB_TOKEN}",
+            "Accept": "application/vnd.github+json",
+        }
+    else:
+        if not GITLAB_TOKEN:
+            return
+        url = f"{GITLAB_API_URL}/projects/{pr.repository_id}/merge_requests/{pr.number}/notes"
+        headers = {"PRIVATE-TOKEN": GITLAB_TOKEN}
+    timeout = aiohttp.ClientTimeout(total=COMMENT_TIMEOUT_SECONDS)
+    try:
+        async with aiohttp.ClientSession(timeout=timeout) as session:
+            async with session.post(url, json={"body": body}, headers=headers) as response:
+                response.raise_for_status()
+    except (aiohttp.ClientError, asyncio.TimeoutError) as e:
+        logger.warning(f"Failed to comment on {pr.url}: {e}")
+
+
+async def open_devserver(pr: PullRequest) -> web.Response:
+    api = client.CustomObjectsApi()
+    users = await asyncio.to_thread(
+        api.list_cluster_custom_object,
+        group=CRD_GROUP,
+        version=CRD_VERSION,
+        plural=CRD_PLURAL_DEVSERVERUSER,
+    )
+    try:
+        user = find_owner(pr, users["items"])
+    except ValueError as e:
+        logger.warning(f"Not creating a DevServer for {pr.url}: {e}")
+        return web.json_response({"ignored": True, "reason": str(e)})
+    if user is None:
+        logger.info(f"Not creating a DevServer for {pr.url}: no DevServerUser of '{pr.user}'.")
+        return web.json_response({"ignored": True, "reason": f"Unknown user '{pr.user}'."})
+
+    owner = user["spec"]["username"]
+    devserver = build_devserver(pr, user)
+    try:
+        await asyncio.to_thread(
+            devserver.api.create_namespaced_custom_object,
+            group=CRD_GROUP,
+            version=CRD_VERSION,
+            plural=CRD_PLURAL_DEVSERVER,
+            namespace=devserver.metadata.namespace,
+            body=devserver.to_dict(),
+        )
+    except client.ApiException as e:
+        if e.status == 403:
+            message = f"'{owner}' may not create DevServers: {e.reason}"
+            logger.warning(f"Cannot create a DevServer for {pr.url}: {message}")
+            return web.json_response({"error": message}, status=403)
+        if e.status == 409:
+            return await _existing_devserver(devserver, pr)
+        if e.status == 404:
+            # The user has no DevServerUser, so no namespace to create it in
+            message = f"Namespace '{devserver.metadata.namespace}' not found."
+            logger.warning(f"Cannot create a DevServer for {pr.url}: {message}")
+            return web.json_response({"error": message}, status=404)
+        if e.status == 422:
+            logger.warning(f"Invalid DevServer for {pr.url}: {e.reason}")
+            return web.json_response({"error": f"Invalid DevServer: {e.reason}"}, status=422)
+        raise
+    logger.info(f"Created DevServer '{devserver.metadata.name}' for {pr.url}.")
+    await _post_comment(pr, comment(devserver, pr))
+    return web.json_response({"devserver": devserver.metadata.name, "created": True}, status=201)
+
+
+async def _existing_devserver(devserver: DevServer, pr: PullRequest) -> web.Response:
+    """
+    Answer for a DevServer of the pull request's name that already exists,
+    which is only its own if it is labeled with it and has the same owner.
+    """
+    name, namespace = devserver.metadata.name, devserver.metadata.namespace
+    existing = await asyncio.to_thread(
+        devserver.api.get_namespaced_custom_object,
+        group=CRD_GROUP,
+        version=CRD_VERSION,
+        plural=CRD_PLURAL_DEVSERVER,
+        namespace=namespace,
+        name=name,
+    )
+    labels = existing.get("metadata", {}).get("labels") or {}
+    if (
+        labels.get(PULL_REQUEST_LABEL) == pull_request_label(pr)
+        and existing.get("spec", {}).get("owner") == devserver.spec["owner"]
+    ):
+        return web.json_response({"devserver": name, "created": False})
+    message = f"DevServer '{name}' in namespace '{namespace}' is not the one of {pr.url}."
+    logger.warning(message)
+    return web.json_response({"error": message}, status=409)
+
+
+async def close_devserver(pr: PullRequest) -> web.Response:
+    api = client.CustomObjectsApi()
+    result = await asyncio.to_thread(
+        api.list_cluster_custom_object,
+        group=CRD_GROUP,
+        version=CRD_VERSION,
+        plural=CRD_PLURAL_DEVSERVER,
+        label_selector=f"{PULL_REQUEST_LABEL}={pull_request_label(pr)}",
+    )
+    deleted = []
+    for item in result["items"]:
+        try:
+            await asyncio.to_thread(
+                api.delete_namespaced_custom_object,
+                group=CRD_GROUP,
+                version=CRD_VERSION,
+                plural=CRD_PLURAL_DEVSERVER,
+                namespace=item["metadata"]["namespace"],
+                name=item["metadata"]["name"],
+            )
+        except client.ApiException as e:
+            if e.status != 404:
+                raise
+        deleted.append(item["metadata"]["name"])
+        logger.info(f"Deleted DevServer '{item['metadata']['name']}' of {pr.url}.")
+    return web.json_response({"deleted": deleted})
+
+
+async def _handle(action: Optional[str], pr: PullRequest) -> web.Response:
+    if action == OPEN:
+        return await open_devserver(pr)
+    if action == CLOSE:
+        return await close_devserver(pr)
+    return web.json_response({"ignored": True})
+
+
+def _payload(body: bytes) -> Dict[str, Any]:
+    try:
+        payload = json.loads(body)
+    except ValueError:
+        raise web.HTTPBadRequest(text="The webhook body must be JSON.")
+    if not isinstance(payload, dict):
+        raise web.HTTPBadRequest(text="The webhook body must be a JSON object.")
+    return payload
+
+
+def github_signature(secret: str, body: bytes) -> str:
+    """The `X-Hub-Signature-256` GitHub sends with a webhook."""
+    return "sha256=" + hmac.new(secret.encode(), body, hashlib.sha256).hexdigest()
+
+
+async def github_webhook(request: web.Request) -> web.Response:
+    if not GITHUB_WEBHOOK_SECRET:
+        raise web.HTTPNotFound()
+    body = await request.read()
+    expected = github_signature(GITHUB_WEBHOOK_SECRET, body)
+    if not hmac.compare_digest(request.headers.get("X-Hub-Signature-256", ""), expected):
+        raise web.HTTPUnauthorized(text="Invalid X-Hub-Signature-256.")
+    if request.headers.get("X-GitHub-Event") != "pull_request":
+        return web.json_response({"ignored": True})
+    payload = _payload(body)
+    try:
+        action, pr = github_action(payload), parse_github(payload)
+    except (KeyError, TypeError):
+        raise web.HTTPBadRequest(text="Not a pull_request webhook.")
+    return await _handle(action, pr)
+
+
+async def gitlab_webhook(request: web.Request) -> web.Response:
+    if not GITLAB_WEBHOOK_TOKEN:
+        raise web.HTTPNotFound()
+    if not hmac.compare_digest(request.headers.get("X-Gitlab-Token", ""), GITLAB_WEBHOOK_TOKEN):
+        raise web.HTTPUnauthorized(text="Invalid X-Gitlab-Token.")
+    if request.headers.get("X-Gitlab-Event") != "Merge Request Hook":
+        return web.json_response({"ignored": True})
+    payload = _payload(await request.read())
+    try:
+        action, pr = gitlab_action(payload), parse_gitlab(payload)
+    except (KeyError, TypeError):
+        raise web.HTTPBadRequest(text="Not a merge request webhook.")
+    return await _handle(action, pr)
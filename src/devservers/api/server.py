@@ -0,0 +1,414 @@
+"""
+HTTP API for managing DevServers without Kubernetes credentials.
+
+Every `/api/v1` request must carry an OIDC bearer token. The token's user
+owns the DevServers created through the API, and only ever sees the
+DevServers they own in their own namespace (`dev-<user>`, as for
+DevServerUsers). Users whose names map to the same namespace, e.g.
+`alice.smith` and `alice-smith`, do not see each other's DevServers.
+The API server itself talks to the cluster with its service account, but
+creates DevServers as their owner, impersonating them.
+
+`/` serves a web dashboard built on the same API, and `/webhooks/` the
+GitHub and GitLab webhooks that create DevServers for pull requests.
+"""
+import asyncio
+import json
+import logging
+import os
+import re
+import sys
+from datetime import datetime
+from typing import Any, Dict, Type
+
+from aiohttp import web
+from kubernetes import client, config
+
+from . import pull_requests
+from .auth import AuthenticationError, OIDCVerifier
+from .impersonation import custom_objects_api_as
+from ..crds.base import ObjectMeta
+from ..crds.const import (
+    CRD_GROUP,
+    CRD_PLURAL_DEVSERVER,
+    CRD_VERSION,
+    MAX_DEVSERVER_NAME_LENGTH,
+    MAX_TIME_TO_LIVE,
+)
+from ..crds.devserver import DevServer
+from ..utils.flavors import get_default_flavor
+from ..utils.templates import list_templates as list_template_summaries
+from ..utils.time import parse_duration
+from ..utils.users import compute_user_namespace, owner_to_dns_label
+
+API_PORT = int(os.environ.get("DEVSERVER_API_PORT", 8081))
+OIDC_ISSUER = os.environ.get("DEVSERVER_API_OIDC_ISSUER")
+OIDC_AUDIENCE = os.environ.get("DEVSERVER_API_OIDC_AUDIENCE")
+OIDC_USERNAME_CLAIM = os.environ.get("DEVSERVER_API_OIDC_USERNAME_CLAIM", "preferred_username")
+
+DEFAULT_TIME_TO_LIVE = "4h"
+DEFAULT_PERSISTENT_HOME_SIZE = "10Gi"
+
+# The web dashboard, a single page that drives the API from the browser
+DASHBOARD_PATH = os.path.join(os.path.dirname(__file__), "dashboard.html")
+
+_DNS_LABEL = re.compile(r"^[a-z0-9]([-a-z0-9]*[a-z0-9])?$")
+GENERATED_NAME_SUFFIX_LENGTH = 5
+
+VERIFIER_KEY = web.AppKey("verifier", OIDCVerifier)
+
+logger = logging.getLogger(__name__)
+
+
+def _error(error_class: Type[web.HTTPException], message: str) -> web.HTTPException:
+    """Build an HTTP error with a JSON body."""
+    return error_class(text=json.dumps({"error": message}), content_type="application/json")
+
+
+def user_namespace(user: str) -> str:
+    """The namespace holding a user's DevServers."""
+    return compute_user_namespace(owner_to_dns_label(user))
+
+
+def summarize(devserver: Dict[str, Any]) -> Dict[str, Any]:
+    """The fields of a DevServer that API clients care about."""
+    spec = devserver.get("spec", {})
+    status = devserver.get("status", {})
+    return {
+        "name": devserver["metadata"]["name"],
+        "namespace": devserver["metadata"]["namespace"],
+        "owner": spec.get("owner"),
+        "flavor": spec.get("flavor"),
+        "image": spec.get("image"),
+        "hibernated": spec.get("hibernated", False),
+        "timeToLive": spec.get("lifecycle", {}).get("timeToLive"),
+        "phase": status.get("phase"),
+        "ready": status.get("ready", False),
+        "message": status.get("message"),
+        "expiresAt": status.get("expiresAt"),
+        "expiresIn": status.get("expiresIn"),
+    }
+
+
+def ssh_info(devserver: Dict[str, Any]) -> Dict[str, Any]:
+    """What a client needs to connect to a DevServer over SSH."""
+    status = devserver.get("status", {})
+    return {
+        "name": devserver["metadata"]["name"],
+        "namespace": devserver["metadata"]["namespace"],
+        "ready": status.get("ready", False),
+        "user": "dev",
+        "sshEndpoint": status.get("sshEndpoint"),
+        "sshConfig": status.get("sshConfig"),
+        "sshHostKeys": status.get("sshHostKeys", []),
+        "sshGatewayHost": status.get("sshGatewayHost"),
+        "moshPortRange": status.get("moshPortRange"),
+    }
+
+
+@web.middleware
+async def authenticate(request: web.Request, handler) -> web.StreamResponse:
+    """Resolve the user of `/api/` requests from their OIDC bearer token."""
+    if not request.path.startswith("/api/"):
+        return await handler(request)
+
+    scheme, _, token = request.headers.get("Authorization", "").partition(" ")
+    if scheme.lower() != "bearer" or not token:
+        raise _error(web.HTTPUnauthorized, "A bearer token is required.")
+    verifier = request.app[VERIFIER_KEY]
+    try:
+        request["user"] = verifier.username(await verifier.verify(token))
+    except AuthenticationError as e:
+        raise _error(web.HTTPUnauthorized, str(e))
+    return await handler(request)
+
+
+async def _read_json(request: web.Request) -> Dict[str, Any]:
+    try:
+        body = await request.json()
+    except ValueError:
+        raise _error(web.HTTPBadRequest, "The request body must be JSON.")
+    if not isinstance(body, dict):
+        raise _error(web.HTTPBadRequest, "The request body must be a JSON object.")
+    return body
+
+
+def _owned_by(devserver: Dict[str, Any], user: str) -> bool:
+    return devserver.get("spec", {}).get("owner") == user
+
+
+async def _get_devserver(request: web.Request) -> Dict[str, Any]:
+    """Get a DevServer of the user, as if those of others did not exist."""
+    name = request.match_info["name"]
+    namespace = user_namespace(request["user"])
+    try:
+        devserver = await asyncio.to_thread(
+            client.CustomObjectsApi().get_namespaced_custom_object,
+            group=CRD_GROUP,
+            version=CRD_VERSION,
+            plural=CRD_PLURAL_DEVSERVER,
+            namespace=namespace,
+            name=name,
+        )
+    except client.ApiException as e:
+        if e.status == 404:
+            raise _error(web.HTTPNotFound, f"DevServer '{name}' not found.")
+        raise
+    if not _owned_by(devserver, request["user"]):
+        raise _error(web.HTTPNotFound, f"DevServer '{name}' not found.")
+    return devserver
+
+
+async def list_devservers(request: web.Request) -> web.Response:
+    result = await asyncio.to_thread(
+        client.CustomObjectsApi().list_namespaced_custom_object,
+        group=CRD_GROUP,
+        version=CRD_VERSION,
+        plural=CRD_PLURAL_DEVSERVER,
+        namespace=user_namespace(request["user"]),
+    )
+    items = [item for item in result["items"] if _owned_by(item, request["user"])]
+    return web.json_response({"items": [summarize(item) for item in items]})
+
+
+async def create_devserver(request: web.Request) -> web.Response:
+    """
+    Create a DevServer from `name` (or a `generateName` prefix), `sshPublicKey`
+    and optionally `flavor`, `image`, `timeToLive` and `persistentHomeSize`.
+    """
+    body = await _read_json(request)
+    name = body.get("name")
+    generate_name = body.get("generateName")
+    if generate_name is not None and name is None:
+        # The API server appends 5 random characters to the prefix
+        max_length = MAX_DEVSERVER_NAME_LENGTH - GENERATED_NAME_SUFFIX_LENGTH
+        if (
+            not isinstance(generate_name, str)
+            or not _DNS_LABEL.match(generate_name.rstrip("-"))
+            or len(generate_name) > max_length
+        ):
+            raise _error(
+                web.HTTPBadRequest,
+                f"'generateName' must be a lowercase DNS label of at most {max_length} "
+                "characters.",
+            )
+        name = ""
+    else:
+        generate_name = None
+        if (
+            not isinstance(name, str)
+            or not _DNS_LABEL.match(name)
+            or len(name) > MAX_DEVSERVER_NAME_LENGTH
+        ):
+            raise _error(
+                web.HTTPBadRequest,
+                f"'name' must be a lowercase DNS label of at most {MAX_DEVSERVER_NAME_LENGTH} "
+                "characters.",
+            )
+    ssh_public_key = body.get("sshPublicKey")
+    if not ssh_public_key:
+        raise _error(web.HTTPBadRequest, "'sshPublicKey' is required.")
+
+    # DevServers created from a template take its TTL and flavor by default
+    template = body.get("template")
+    time_to_live = body.get("timeToLive", None if template else DEFAULT_TIME_TO_LIVE)
+    if time_to_live is not None:
+        try:
+            ttl = parse_duration(time_to_live)
+        except (TypeError, ValueError):
+            raise _error(web.HTTPBadRequest, f"Invalid 'timeToLive' '{time_to_live}'.")
+        if not ttl or ttl > MAX_TIME_TO_LIVE:
+            raise _error(web.HTTPBadRequest, "'timeToLive' must be positive and at most 7d.")
+
+    flavor = body.get("flavor")
+    if not flavor and not template and not body.get("profile"):
+        default_flavor = await get_default_flavor(user_namespace(request["user"]))
+        if default_flavor is None:
+            raise _error(web.HTTPBadRequest, "'flavor' is required, there is no default flavor.")
+        flavor = default_flavor["metadata"]["name"]
+
+    spec: Dict[str, Any] = {
+        "owner": request["user"],
+        "ssh": {"publicKey": ssh_public_key},
+        "enableSSH": True,
+        "persistentHome": {
+            "enabled": True,
+            "size": body.get("persistentHomeSize", DEFAULT_PERSISTENT_HOME_SIZE),
+        },
+    }
+    if flavor:
+        spec["flavor"] = flavor
+    if time_to_live is not None:
+        spec["lifecycle"] = {"timeToLive": time_to_live}
+    if template:
+        spec["template"] = template
+    if body.get("profile"):
+        spec["profile"] = body["profile"]
+    if body.get("image"):
+        spec["image"] = body["image"]
+
+    devserver = DevServer(
+        metadata=ObjectMeta(
+            name=name, namespace=user_namespace(request["user"]), generateName=generate_name
+        ),
+        spec=spec,
+        # So that the owner identity webhook and RBAC see the user creating it
+        api=custom_objects_api_as(request["user"]),
+    )
+    try:
+        created = await asyncio.to_thread(
+            devserver.api.create_namespaced_custom_object,
+            group=CRD_GROUP,
+            version=CRD_VERSION,
+            plural=CRD_PLURAL_DEVSERVER,
+            namespace=devserver.metadata.namespace,
+            body=devserver.to_dict(),
+        )
+    except client.ApiException as e:
+        if e.status == 403:
+            raise _error(web.HTTPForbidden, f"Cannot create the DevServer: {e.reason}")
+        if e.status == 409:
+            raise _error(web.HTTPConflict, f"DevServer '{name or generate_name}' already exists.")
+        if e.status == 422:
+            raise _error(web.HTTPUnprocessableEntity, f"Invalid DevServer: {e.reason}")
+        raise
+    logger.info(f"User '{request['user']}' created DevServer '{created['metadata']['name']}'.")
+    return web.json_response(summarize(created), status=201)
+
+
+async def get_devserver(request: web.Request) -> web.Response:
+    return web.json_response(summarize(await _get_devserver(request)))
+
+
+async def get_ssh_info(request: web.Request) -> web.Response:
+    return web.json_response(ssh_info(await _get_devserver(request)))
+
+
+async def extend_devserver(request: web.Request) -> web.Response:
+    """Extend a DevServer's TTL by `duration`, e.g. `{"duration": "24h"}`."""
+    body = await _read_json(request)
+    obj = await _get_devserver(request)
+    try:
+        extension = parse_duration(body.get("duration", ""))
+    except (TypeError, ValueError):
+        extension = None
+    if not extension:
+        raise _error(web.HTTPBadRequest, "'duration' must be a positive duration, e.g. '24h'.")
+
+    devserver = DevServer(
+        metadata=ObjectMeta.from_dict(obj["metadata"]),
+        spec=obj["spec"],
+        status=obj.get("status", {}),
+        api=client.CustomObjectsApi(),
+    )
+    try:
+        new_ttl = devserver.extended_time_to_live(extension)
+    except ValueError as e:
+        raise _error(web.HTTPUnprocessableEntity, str(e))
+
+    patched = await asyncio.to_thread(
+        devserver.api.patch_namespaced_custom_object,
+        group=CRD_GROUP,
+        version=CRD_VERSION,
+        plural=CRD_PLURAL_DEVSERVER,
+        namespace=devserver.metadata.namespace,
+        name=devserver.metadata.name,
+        body={"spec": {"lifecycle": {"timeToLive": new_ttl}}},
+    )
+    summary = summarize(patched)
+    # The status is only refreshed by the operator, so compute the new expiry here
+    created_at = datetime.fromisoformat(obj["metadata"]["creationTimestamp"].replace("Z", "+00:00"))
+    summary["expiresAt"] = (created_at + parse_duration(new_ttl)).strftime("%Y-%m-%dT%H:%M:%SZ")
+    summary["expiresIn"] = None
+    logger.info(f"User '{request['user']}' extended DevServer '{devserver.metadata.name}' to {new_ttl}.")
+    return web.json_response(summary)
+
+
+async def _set_hibernated(request: web.Request, hibernated: bool) -> web.Response:
+    obj = await _get_devserver(request)
+    patched = await asyncio.to_thread(
+        client.CustomObjectsApi().patch_namespaced_custom_object,
+        group=CRD_GROUP,
+        version=CRD_VERSION,
+        plural=CRD_PLURAL_DEVSERVER,
+        namespace=obj["metadata"]["namespace"],
+        name=obj["metadata"]["name"],
+        body={"spec": {"hibernated": hibernated}},
+    )
+    action = "hibernated" if hibernated else "resumed"
+    logger.info(f"User '{request['user']}' {action} DevServer '{obj['metadata']['name']}'.")
+    return web.json_response(summarize(patched))
+
+
+async def hibernate_devserver(request: web.Request) -> web.Response:
+    return await _set_hibernated(request, True)
+
+
+async def resume_devserver(request: web.Request) -> web.Response:
+    return await _set_hibernated(request, False)
+
+
+async def delete_devserver(request: web.Request) -> web.Response:
+    obj = await _get_devserver(request)
+    await asyncio.to_thread(
+        client.CustomObjectsApi().delete_namespaced_custom_object,
+        group=CRD_GROUP,
+        version=CRD_VERSION,
+        plural=CRD_PLURAL_DEVSERVER,
+        namespace=obj["metadata"]["namespace"],
+        name=obj["metadata"]["name"],
+    )
+    logger.info(f"User '{request['user']}' deleted DevServer '{obj['metadata']['name']}'.")
+    return web.Response(status=204)
+
+
+async def list_templates(request: web.Request) -> web.Response:
+    """List the DevServerTemplates the platform team offers, with cost hints."""
+    return web.json_response({"items": await asyncio.to_thread(list_template_summaries)})
+
+
+async def get_user(request: web.Request) -> web.Response:
+    return web.json_response(
+        {"user": request["user"], "namespace": user_namespace(request["user"])}
+    )
+
+
+async def _handle_healthz(request: web.Request) -> web.Response:
+    return web.Response(text="ok")
+
+
+async def _handle_dashboard(request: web.Request) -> web.FileResponse:
+    return web.FileResponse(DASHBOARD_PATH)
+
+
+def create_app(verifier: OIDCVerifier) -> web.Application:
+    app = web.Application(middlewares=[authenticate])
+    app[VERIFIER_KEY] = verifier
+    app.router.add_get("/", _handle_dashboard)
+    app.router.add_get("/healthz", _handle_healthz)
+    app.router.add_get("/api/v1/user", get_user)
+    app.router.add_get("/api/v1/templates", list_templates)
+    app.router.add_get("/api/v1/devservers", list_devservers)
+    app.router.add_post("/api/v1/devservers", create_devserver)
+    app.router.add_get("/api/v1/devservers/{name}", get_devserver)
+    app.router.add_delete("/api/v1/devservers/{name}", delete_devserver)
+    app.router.add_get("/api/v1/devservers/{name}/ssh", get_ssh_info)
+    app.router.add_post("/api/v1/devservers/{name}/extend", extend_devserver)
+    app.router.add_post("/api/v1/devservers/{name}/hibernate", hibernate_devserver)
+    app.router.add_post("/api/v1/devservers/{name}/resume", resume_devserver)
+    app.router.add_post("/webhooks/github", pull_requests.github_webhook)
+    app.router.add_post("/webhooks/gitlab", pull_requests.gitlab_webhook)
+    return app
+
+
+def main() -> None:
+    logging.basicConfig(level=logging.INFO, format="%(asctime)s %(levelname)s %(message)s")
+    if not OIDC_ISSUER or not OIDC_AUDIENCE:
+        logger.error("DEVSERVER_API_OIDC_ISSUER and DEVSERVER_API_OIDC_AUDIENCE must be set.")
+        sys.exit(1)
+    try:
+        config.load_incluster_config()
+    except config.ConfigException:
+        config.load_kube_config()
+    verifier = OIDCVerifier(OIDC_ISSUER, OIDC_AUDIENCE, OIDC_USERNAME_CLAIM)
+    web.run_app(create_app(verifier), port=API_PORT)
@@ -13,6 +13,7 @@ DEFAULT_CONFIG: Dict[str, Any] = {
         "public_key_file": "",
         "private_key_file": "",
         "forward_agent": False,
+        "websocket_gateway_url": "",
     },
     "devctl-ssh-config-dir": str(DEFAULT_CONFIG_DIR / "ssh/"),
 }
@@ -74,6 +75,11 @@ class Configuration:
             "forward_agent", False
         )
 
+    @property
+    def ssh_websocket_gateway_url(self) -> Optional[str]:
+        """Base URL of the SSH-over-WebSocket gateway, e.g. wss://devservers.example.com."""
+        return self._config.get("ssh", {}).get("websocket_gateway_url") or None
+
 
 def get_default_config_path() -> Path:
     return DEFAULT_CONFIG_PATH
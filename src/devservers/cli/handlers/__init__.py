@@ -4,19 +4,32 @@ This module contains the handler functions for the CLI commands.
 from .create import create_devserver
 from .delete import delete_devserver
 from .describe import describe_devserver
-from .list import list_devservers, list_flavors
+from .extend import extend_devserver
+from .hibernate import hibernate_devserver, resume_devserver
+from .list import list_devservers, list_flavors, list_templates
 from .ssh import ssh_devserver
+from .status import status_devserver
 from .ssh_proxy import ssh_proxy_devserver
+from .ssh_ws_proxy import ssh_ws_proxy_devserver
+from .transfer import copy_files, sync_files
 from .user import create_user, delete_user, list_users, generate_user_kubeconfig
 
 __all__ = [
     "create_devserver",
     "delete_devserver",
     "describe_devserver",
+    "extend_devserver",
+    "hibernate_devserver",
+    "resume_devserver",
     "list_devservers",
     "list_flavors",
+    "list_templates",
     "ssh_devserver",
+    "status_devserver",
     "ssh_proxy_devserver",
+    "ssh_ws_proxy_devserver",
+    "copy_files",
+    "sync_files",
     "create_user",
     "delete_user",
     "list_users",
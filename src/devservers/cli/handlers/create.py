@@ -12,6 +12,7 @@ from ..utils import get_current_context
 from ...crds.devserver import DevServer
 from ...crds.base import ObjectMeta
 from ...utils.flavors import get_default_flavor
+from ...utils.time import parse_duration
 
 
 def _wait_for_crd_running(devserver: DevServer, status: Status) -> None:
@@ -22,6 +23,11 @@ def _wait_for_crd_running(devserver: DevServer, status: Status) -> None:
             phase = devserver_obj["status"]["phase"]
             if phase == "Running":
                 return
+            message = devserver_obj["status"].get("message", "")
+            if phase == "Failed":
+                raise RuntimeError(
+                    f"DevServer '{devserver.metadata.name}' failed to start: {message}"
+                )
             status.update(f"DevServer '{devserver.metadata.name}' is in phase: {phase}")
 
 
@@ -69,7 +75,7 @@ def _wait_for_pod_ready(pod_name: str, namespace: str, status: Status) -> None:
         status.update(message)
 
 
-def _wait_for_devserver_ready(devserver: DevServer, console: Console) -> None:
+def wait_for_devserver_ready(devserver: DevServer, console: Console) -> None:
     """Waits for the DevServer to become ready by watching the CRD and the pod."""
     pod_name = f"{devserver.metadata.name}-0"
     with Status(
@@ -93,21 +99,54 @@ def create_devserver(
     image: Optional[str] = None,
     ssh_public_key_file: Optional[str] = None,
     namespace: Optional[str] = None,
-    time_to_live: str = "4h",
+    time_to_live: Optional[str] = None,
     wait: bool = False,
     persistent_home_size: str = "10Gi",
+    storage_class: Optional[str] = None,
+    from_snapshot: Optional[str] = None,
+    from_backup: Optional[str] = None,
+    from_devserver: Optional[str] = None,
+    clone: Optional[str] = None,
+    template: Optional[str] = None,
+    profile: Optional[str] = None,
+    generate_name: bool = False,
 ) -> None:
     """Creates a new DevServer resource."""
     console = Console()
 
-    _, target_namespace = get_current_context()
+    user, target_namespace = get_current_context()
     if namespace:
         target_namespace = namespace
 
-    # If flavor is not specified, try to find the default flavor
-    if not flavor:
+    if len([source for source in (from_snapshot, from_backup, from_devserver) if source]) > 1:
+        console.print(
+            "Error: Only one of --from-snapshot, --from-backup and --from-devserver can be given."
+        )
+        sys.exit(1)
+
+    if clone and (from_snapshot or from_backup or from_devserver):
+        console.print(
+            "Error: --clone already copies the home directory, it cannot be given with --from-*."
+        )
+        sys.exit(1)
+
+    # The operator defaults the TTL of DevServers created from a template to its own
+    if time_to_live is None and not template:
+        time_to_live = "4h"
+    if time_to_live is not None:
+        try:
+            parse_duration(time_to_live)
+        except ValueError:
+            console.print(
+                f"Error: Invalid TTL '{time_to_live}'. Use a duration like '4h', '1h30m' or '2d'."
+            )
+            sys.exit(1)
+
+    # If flavor is not specified, try to find the default flavor, unless the clone,
+    # template or profile provides it
+    if not flavor and not clone and not template and not profile:
         console.print("No flavor specified, searching for a default flavor...")
-        default_flavor = asyncio.run(get_default_flavor())
+        default_flavor = asyncio.run(get_default_flavor(target_namespace))
         if default_flavor:
             flavor = default_flavor["metadata"]["name"]
             console.print(f"Using default flavor: '{flavor}'")
@@ -131,28 +170,60 @@ def create_devserver(
 
     # Construct the DevServer manifest
     spec: Dict[str, Any] = {
-        "flavor": flavor,
         "ssh": {"publicKey": ssh_public_key},
-        "lifecycle": {"timeToLive": time_to_live},
         "enableSSH": True,
     }
-
-    spec["persistentHome"] = {
-        "enabled": True,
-        "size": persistent_home_size,
-    }
+    if time_to_live is not None:
+        spec["lifecycle"] = {"timeToLive": time_to_live}
+    if flavor:
+        spec["flavor"] = flavor
+    if template:
+        spec["template"] = template
+    if profile:
+        spec["profile"] = profile
+    # Lets `devctl list --owner me` find the DevServers you created
+    if user:
+        spec["owner"] = user
+
+    # The operator copies the rest of the spec, including the persistent home
+    if clone:
+        spec["cloneFrom"] = {"name": clone}
+    else:
+        spec["persistentHome"] = {
+            "enabled": True,
+            "size": persistent_home_size,
+        }
+        if storage_class:
+            spec["persistentHome"]["storageClassName"] = storage_class
+    if from_snapshot:
+        spec["homeSource"] = {"snapshotRef": {"name": from_snapshot}}
+    elif from_backup:
+        # A URL restores an archive uploaded from anywhere, e.g. another cluster
+        backup_ref = {"url" if "://" in from_backup else "name": from_backup}
+        spec["homeSource"] = {"backupRef": backup_ref}
+    elif from_devserver:
+        spec["homeSource"] = {"fromDevServer": {"name": from_devserver}}
 
     # If an image is provided, use it, otherwise use the default from the operator
     if image:
         spec["image"] = image
 
     try:
-        metadata = ObjectMeta(name=name, namespace=target_namespace)
+        if generate_name:
+            # e.g. 'dev-x7k2p', which never collides with an existing DevServer
+            metadata = ObjectMeta(name="", namespace=target_namespace, generateName=f"{name}-")
+        else:
+            metadata = ObjectMeta(name=name, namespace=target_namespace)
         devserver = DevServer.create(metadata=metadata, spec=spec)
+        name = devserver.metadata.name
         console.print(f"DevServer '{name}' created successfully in namespace '{target_namespace}'.")
         if wait:
             assert target_namespace is not None
-            _wait_for_devserver_ready(devserver, console)
+            try:
+                wait_for_devserver_ready(devserver, console)
+            except RuntimeError as e:
+                console.print(f"Error: {e}")
+                sys.exit(1)
     except client.ApiException as e:
         if e.status == 409:  # Conflict
             console.print(f"Error: DevServer '{name}' already exists.")
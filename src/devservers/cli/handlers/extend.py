@@ -0,0 +1,68 @@
+import sys
+from datetime import datetime
+from typing import Optional
+
+from kubernetes import client
+from rich.console import Console
+
+from ..utils import get_current_context
+from ...crds.devserver import DevServer
+from ...utils.time import parse_duration
+
+
+def extend_devserver(name: str, duration: str, namespace: Optional[str] = None) -> None:
+    """
+    Extend a DevServer's lifetime by bumping its spec.lifecycle.timeToLive.
+
+    The TTL is counted from the DevServer's creation, so it cannot be
+    extended past MAX_TIME_TO_LIVE in total.
+    """
+    console = Console()
+
+    _, target_namespace = get_current_context()
+    if namespace:
+        target_namespace = namespace
+
+    assert target_namespace is not None
+
+    try:
+        extension = parse_duration(duration)
+    except ValueError:
+        console.print(f"Error: Invalid duration '{duration}'. Use a duration like '4h' or '1d'.")
+        sys.exit(1)
+    if not extension:
+        console.print("Error: The duration to extend by must be positive.")
+        sys.exit(1)
+
+    try:
+        devserver = DevServer.get(name=name, namespace=target_namespace)
+        try:
+            new_ttl_str = devserver.extended_time_to_live(extension)
+        except ValueError as e:
+            console.print(f"Error: {e}")
+            sys.exit(1)
+
+        devserver.patch({"spec": {"lifecycle": {"timeToLive": new_ttl_str}}})
+        console.print(f"DevServer '{name}' extended by {duration}, its TTL is now {new_ttl_str}.")
+
+        # The operator derives expiresAt from the creation time and the TTL
+        expires_at = devserver.status.get("expiresAt")
+        if expires_at:
+            new_expires_at = (
+                datetime.strptime(expires_at, "%Y-%m-%dT%H:%M:%SZ") + extension
+            ).strftime("%Y-%m-%dT%H:%M:%SZ")
+            console.print(f"It will now expire at {new_expires_at}.")
+    except client.ApiException as e:
+        if e.status == 404:
+            console.print(
+                f"Error: DevServer '{name}' not found in namespace '{target_namespace}'."
+            )
+        elif e.status == 403:
+            console.print(
+                f"Error: You are not allowed to patch DevServer '{name}' in namespace "
+                f"'{target_namespace}'. Ask an administrator for the 'patch' permission "
+                "on devservers."
+            )
+        else:
+            console.print(f"An error occurred: {e.reason}")
+        sys.exit(1)
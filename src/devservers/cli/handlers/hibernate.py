@@ -0,0 +1,67 @@
+import sys
+from typing import Optional
+
+from kubernetes import client
+from rich.console import Console
+
+from ..utils import get_current_context
+from ...crds.devserver import DevServer
+from .create import wait_for_devserver_ready
+
+
+def _set_hibernated(
+    console: Console, name: str, namespace: Optional[str], hibernated: bool
+) -> Optional[DevServer]:
+    """Patch spec.hibernated, returning the DevServer or None if it was not found."""
+    _, target_namespace = get_current_context()
+    if namespace:
+        target_namespace = namespace
+
+    assert target_namespace is not None
+
+    try:
+        devserver = DevServer.get(name=name, namespace=target_namespace)
+        devserver.patch({"spec": {"hibernated": hibernated}})
+        return devserver
+    except client.ApiException as e:
+        if e.status == 404:
+            console.print(
+                f"Error: DevServer '{name}' not found in namespace '{target_namespace}'."
+            )
+        elif e.status == 403:
+            console.print(
+                f"Error: You are not allowed to patch DevServer '{name}' in namespace "
+                f"'{target_namespace}'. Ask an administrator for the 'patch' permission "
+                "on devservers."
+            )
+        else:
+            console.print(f"An error occurred: {e.reason}")
+        return None
+
+
+def hibernate_devserver(name: str, namespace: Optional[str] = None) -> None:
+    """Scale a DevServer down to zero pods, keeping its home directory."""
+    console = Console()
+
+    if _set_hibernated(console, name, namespace, True) is None:
+        sys.exit(1)
+    console.print(
+        f"DevServer '{name}' is hibernating. Run 'devctl resume {name}' to start it again."
+    )
+
+
+def resume_devserver(name: str, namespace: Optional[str] = None, wait: bool = False) -> None:
+    """Resume a hibernated DevServer, optionally waiting for its pod to be ready."""
+    console = Console()
+
+    devserver = _set_hibernated(console, name, namespace, False)
+    if devserver is None:
+        sys.exit(1)
+    console.print(f"DevServer '{name}' is resuming.")
+
+    if wait:
+        try:
+            wait_for_devserver_ready(devserver, console)
+        except RuntimeError as e:
+            console.print(f"Error: {e}")
+            sys.exit(1)
@@ -1,8 +1,7 @@
-import yaml
 from kubernetes import client
 from rich.console import Console
 from rich.table import Table
-from typing import Optional
+from typing import Any, Dict, Optional
 
 from ..utils import get_current_context
 from ...crds.const import (
@@ -11,55 +10,105 @@ from ...crds.const import (
     CRD_PLURAL_DEVSERVERFLAVOR,
 )
 from ...crds.devserver import DevServer
+from ...utils.flavors import get_flavor_gpus, get_flavor_resources, resolve_flavor
+from ...utils.templates import list_templates as list_template_summaries
 
 
-def list_devservers(namespace: Optional[str] = None) -> None:
-    """Lists all DevServers in a given namespace."""
+def list_devservers(
+    namespace: Optional[str] = None,
+    owner: Optional[str] = None,
+    all_namespaces: bool = False,
+) -> None:
+    """
+    Lists DevServers in a given namespace, or across all namespaces.
+
+    `owner` filters on `spec.owner`; the special value "me" stands for the
+    user of the current kubeconfig context.
+    """
     console = Console()
 
-    _, target_namespace = get_current_context()
+    user, target_namespace = get_current_context()
     if namespace:
         target_namespace = namespace
 
+    if owner == "me":
+        if not user:
+            console.print("Error: Could not determine the current user from the kubeconfig context.")
+            return
+        owner = user
+
     assert target_namespace is not None
 
     try:
-        devservers = DevServer.list(namespace=target_namespace)
+        if owner:
+            devservers = DevServer.list_by_owner(
+                owner, namespace=target_namespace, all_namespaces=all_namespaces
+            )
+        else:
+            devservers = DevServer.list(namespace=target_namespace, all_namespaces=all_namespaces)
+
+        scope = "all namespaces" if all_namespaces else f"namespace '{target_namespace}'"
+        if not devservers:
+            suffix = f" owned by '{owner}'" if owner else ""
+            console.print(f"No DevServers{suffix} found in {scope}.")
+            return
 
-        table = Table(title=f"DevServers in namespace [bold]{target_namespace}[/bold]")
+        if all_namespaces:
+            table = Table(title="DevServers in all namespaces")
+        else:
+            table = Table(title=f"DevServers in namespace [bold]{target_namespace}[/bold]")
+        if all_namespaces:
+            table.add_column("Namespace", style="blue")
         table.add_column("Name", style="cyan")
+        table.add_column("Owner")
         table.add_column("Status", style="green")
         table.add_column("Image", style="magenta")
         table.add_column("Flavor", style="yellow")
         table.add_column("TTL", style="red")
+        table.add_column("Expires In", style="red")
 
-        if not devservers:
-            console.print(f"No DevServers found in namespace '{target_namespace}'.")
-            return
-
-        for devserver in devservers:
+        for devserver in sorted(
+            devservers, key=lambda d: (d.metadata.namespace or "", d.metadata.name)
+        ):
             status = devserver.status
-            table.add_row(
+            row = [
                 devserver.metadata.name,
+                devserver.spec.get("owner", "-"),
                 status.get("phase", "Unknown"),
                 devserver.spec.get("image", "default"),
-                devserver.spec["flavor"],
-                devserver.spec["lifecycle"]["timeToLive"],
-            )
+                devserver.spec.get("flavor", "-"),
+                devserver.spec.get("lifecycle", {}).get("timeToLive", "-"),
+                status.get("expiresIn") or "-",
+            ]
+            if all_namespaces:
+                row.insert(0, devserver.metadata.namespace or "")
+            table.add_row(*row)
         console.print(table)
 
     except client.ApiException as e:
         console.print(f"An error occurred: {e.reason}")
 
 
+def _format_resources(resources: Dict[str, Any]) -> str:
+    """Formats a flavor's resource requests (or limits) like 'cpu=4 memory=16Gi'."""
+    quantities = resources.get("requests") or resources.get("limits") or {}
+    return " ".join(f"{key}={value}" for key, value in quantities.items()) or "-"
+
+
 def list_flavors() -> None:
-    """Lists all DevServerFlavors from the cluster."""
+    """
+    Lists all DevServerFlavors from the cluster, with the usage, capacity and
+    schedulability that the operator keeps in their status.
+    """
     custom_objects_api = client.CustomObjectsApi()
     console = Console()
     table = Table(show_header=True, header_style="bold magenta")
-    table.add_column("NAME", width=20)
-    table.add_column("RESOURCES", width=60)
-    table.add_column("SCHEDULABLE", width=20)
+    table.add_column("NAME")
+    table.add_column("RESOURCES")
+    table.add_column("GPUS")
+    table.add_column("IN USE", justify="right")
+    table.add_column("CAPACITY", justify="right")
+    table.add_column("SCHEDULABLE")
 
     try:
         flavors = custom_objects_api.list_cluster_custom_object(
@@ -73,9 +122,18 @@ def list_flavors() -> None:
             console.print("To add flavors, create a DevServerFlavor YAML file and apply it with `kubectl apply -f <your-flavor-file>.yaml` or ask your administrator to do so.")
             return
 
-        for flavor in flavors["items"]:
+        flavors_by_name = {flavor["metadata"]["name"]: flavor for flavor in flavors["items"]}
+        for flavor in sorted(flavors["items"], key=lambda f: f["metadata"]["name"]):
             name = flavor["metadata"]["name"]
-            resources = flavor["spec"]["resources"]
+            try:
+                flavor = resolve_flavor(flavor, flavors_by_name)
+            except ValueError:
+                # Shown as defined; the operator reports the broken base flavor
+                pass
+            if flavor["spec"].get("default", False):
+                name += " (default)"
+            if flavor["spec"].get("deprecated", False):
+                name += " (deprecated)"
             status = flavor.get("status", {})
             schedulability = status.get("schedulable", "Unknown")
 
@@ -85,8 +143,59 @@ def list_flavors() -> None:
             elif schedulability == "No":
                 color = "red"
 
-            table.add_row(f"[cyan]{name}[/cyan]", yaml.dump(resources), f"[{color}]{schedulability}[/{color}]")
+            gpus, gpu_type = get_flavor_gpus(flavor)
+            gpu_description = "-"
+            if gpus:
+                gpu_description = f"{gpus}x {gpu_type}" if gpu_type else str(gpus)
+
+            table.add_row(
+                f"[cyan]{name}[/cyan]",
+                _format_resources(get_flavor_resources(flavor)),
+                gpu_description,
+                str(status.get("inUse", "-")),
+                str(status.get("capacity", "-")),
+                f"[{color}]{schedulability}[/{color}]",
+            )
 
         console.print(table)
+        console.print(
+            "[dim]CAPACITY is how many more DevServers fit on the existing nodes; "
+            "AUTOSCALED flavors can get new nodes on demand.[/dim]"
+        )
     except client.ApiException as e:
         console.print(f"Error listing DevServerFlavors: {e.reason}")
+
+
+def list_templates() -> None:
+    """
+    Lists the DevServerTemplates the platform team offers, with the hourly
+    cost of their default flavor.
+    """
+    console = Console()
+    table = Table(show_header=True, header_style="bold magenta")
+    table.add_column("NAME")
+    table.add_column("DESCRIPTION")
+    table.add_column("FLAVOR")
+    table.add_column("IMAGE")
+    table.add_column("COST/HOUR", justify="right")
+
+    try:
+        templates = list_template_summaries()
+    except client.ApiException as e:
+        console.print(f"Error listing DevServerTemplates: {e.reason}")
+        return
+
+    if not templates:
+        console.print("No DevServerTemplates found in the cluster.")
+        return
+
+    for template in templates:
+        hourly_cost = template["hourlyCost"]
+        table.add_row(
+            f"[cyan]{template['name']}[/cyan]",
+            template["description"] or "-",
+            template["defaultFlavor"] or "-",
+            template["image"] or "-",
+            f"{hourly_cost:.2f}" if hourly_cost is not None else "-",
+        )
+    console.print(table)
@@ -1,7 +1,7 @@
 import subprocess
 import sys
 from pathlib import Path
-from typing import Optional
+from typing import Optional, Tuple
 import os
 
 from kubernetes import client
@@ -11,10 +11,12 @@ from ..ssh_config import (
     create_ssh_config_for_devserver,
     remove_ssh_config_for_devserver,
 )
-from ...utils.network import PortForwardError, kubernetes_port_forward
+from ...utils.network import PortForwardError, is_tcp_reachable, kubernetes_port_forward
 from ..config import Configuration
 from ..utils import get_current_context
+from ...crds.base import KUBE_CONTEXT_ENV_VAR
 from ...crds.devserver import DevServer
+from .create import wait_for_devserver_ready
 
 
 def warn_if_agent_forwarding_is_disabled(configuration: Configuration):
@@ -24,6 +26,17 @@ def warn_if_agent_forwarding_is_disabled(configuration: Configuration):
         console.print("[yellow]   Modify the value ssh.forward_agent to true in your config file to enable it.[/yellow]")
 
 
+def _reachable_ssh_endpoint(devserver: DevServer) -> Optional[Tuple[str, int]]:
+    """
+    Returns the DevServer's external SSH endpoint if it can be reached from
+    here, so the port-forward through the API server can be skipped.
+    """
+    endpoint = devserver.external_ssh_endpoint
+    if endpoint and is_tcp_reachable(*endpoint):
+        return endpoint
+    return None
+
+
 def ssh_devserver(
     configuration: Configuration,
     name: str,
@@ -45,10 +58,25 @@ def ssh_devserver(
 
     try:
         # Check if DevServer exists
-        DevServer.get(name=name, namespace=target_namespace)
+        devserver = DevServer.get(name=name, namespace=target_namespace)
+        if devserver.spec.get("hibernated", False):
+            console.print(
+                f"[yellow]DevServer '{name}' is hibernated. "
+                f"Run 'devctl resume {name} --wait' first.[/yellow]"
+            )
+            sys.exit(1)
+        if devserver.status.get("phase") != "Running":
+            try:
+                wait_for_devserver_ready(devserver, console)
+            except RuntimeError as e:
+                console.print(f"[red]Error: {e}[/red]")
+                sys.exit(1)
+            # Pick up the SSH endpoint published once the pod was scheduled
+            devserver.refresh()
 
         # TODO: The pod name should be dynamically retrieved
         pod_name = f"{name}-0"
+        ssh_endpoint = _reachable_ssh_endpoint(devserver)
 
         if not no_proxy:
             kubeconfig_path = os.environ.get("KUBECONFIG")
@@ -59,8 +87,12 @@ def ssh_devserver(
                 user=user,
                 namespace=target_namespace,
                 kubeconfig_path=kubeconfig_path,
+                kube_context=os.environ.get(KUBE_CONTEXT_ENV_VAR),
                 ssh_forward_agent=configuration.ssh_forward_agent,
                 assume_yes=assume_yes,
+                host_keys=devserver.status.get("sshHostKeys"),
+                websocket_gateway_url=configuration.ssh_websocket_gateway_url,
+                ssh_endpoint=ssh_endpoint,
             )
             if use_include:
                 console.print(f"Connecting to devserver '{name}' via SSH config...")
@@ -71,36 +103,28 @@ def ssh_devserver(
                 subprocess.run(ssh_command, check=False)
                 return
             else:
-                console.print("SSH Include not enabled. Using port-forward to connect.")
+                console.print("SSH Include not enabled. Connecting without the SSH config.")
                 console.print("Run 'devctl config ssh-include enable' to simplify this.")
 
+        key_path = Path(key_path_str).expanduser()
+        if not key_path.is_file():
+            console.print(f"[red]Error: SSH private key file not found at '{key_path}'[/red]")
+            sys.exit(1)
+
+        if ssh_endpoint:
+            host, port = ssh_endpoint
+            console.print(f"Connecting to devserver '{name}' at {host}:{port}...")
+            _run_ssh(configuration, key_path, host, port, remote_command)
+            return
+
         with kubernetes_port_forward(
-            pod_name=pod_name, namespace=target_namespace, pod_port=22
+            pod_name=pod_name, namespace=target_namespace, pod_port=devserver.ssh_port
         ) as local_port:
             # Interactive port-forward flow
             console.print(
                 f"Connecting to devserver '{name}' via port-forward on localhost:{local_port}..."
             )
-            key_path = Path(key_path_str).expanduser()
-            if not key_path.is_file():
-                console.print(
-                    f"[red]Error: SSH private key file not found at '{key_path}'[/red]"
-                )
-                sys.exit(1)
-
-            ssh_command = [
-                "ssh",
-                "-A" if configuration.ssh_forward_agent else "",
-                "-i", str(key_path),
-                "-p", str(local_port),
-                "-o", "StrictHostKeyChecking=no",
-                "-o", "UserKnownHostsFile=/dev/null",
-                "dev@localhost",
-            ]
-            warn_if_agent_forwarding_is_disabled(configuration)
-            if remote_command:
-                ssh_command.extend(remote_command)
-            subprocess.run(ssh_command, check=False)
+            _run_ssh(configuration, key_path, "localhost", local_port, remote_command)
 
     except client.ApiException as e:
         if e.status == 404:
@@ -121,4 +145,27 @@ def ssh_devserver(
         console.print(f"[red]An unexpected error occurred: {e}[/red]")
         sys.exit(1)
     finally:
-        console.print("\n[green]SSH session ended. Closing port-forward.[/green]")
+        console.print("\n[green]SSH session ended.[/green]")
+
+
+def _run_ssh(
+    configuration: Configuration,
+    key_path: Path,
+    host: str,
+    port: int,
+    remote_command: tuple[str, ...],
+) -> None:
+    ssh_command = ["ssh"]
+    if configuration.ssh_forward_agent:
+        ssh_command.append("-A")
+    ssh_command += [
+        "-i", str(key_path),
+        "-p", str(port),
+        "-o", "StrictHostKeyChecking=no",
+        "-o", "UserKnownHostsFile=/dev/null",
+        f"dev@{host}",
+    ]
+    warn_if_agent_forwarding_is_disabled(configuration)
+    if remote_command:
+        ssh_command.extend(remote_command)
+    subprocess.run(ssh_command, check=False)
@@ -1,3 +1,4 @@
+import os
 import sys
 import socket
 import select
@@ -8,6 +9,7 @@ from kubernetes import config
 
 from ...utils.network import kubernetes_port_forward
 from ..utils import get_current_context
+from ...crds.base import KUBE_CONTEXT_ENV_VAR
 from ...crds.devserver import DevServer
 
 
@@ -15,9 +17,15 @@ def ssh_proxy_devserver(
     name: str,
     namespace: Optional[str] = None,
     kubeconfig_path: Optional[str] = None,
+    kube_context: Optional[str] = None,
 ) -> None:
     """Proxy SSH connection to a DevServer."""
-    config.load_kube_config(config_file=kubeconfig_path)
+    # The DevServer lookup below loads the kubeconfig again from the environment
+    if kubeconfig_path:
+        os.environ["KUBECONFIG"] = kubeconfig_path
+    if kube_context:
+        os.environ[KUBE_CONTEXT_ENV_VAR] = kube_context
+    config.load_kube_config(config_file=kubeconfig_path, context=kube_context)
 
     _, target_namespace = get_current_context()
     if namespace:
@@ -27,13 +35,16 @@ def ssh_proxy_devserver(
 
     try:
         # Check if DevServer exists
-        DevServer.get(name=name, namespace=target_namespace)
+        devserver = DevServer.get(name=name, namespace=target_namespace)
 
         # TODO: The pod name should be dynamically retrieved
         pod_name = f"{name}-0"
 
         with kubernetes_port_forward(
-            pod_name=pod_name, namespace=target_namespace, pod_port=22, silent=True
+            pod_name=pod_name,
+            namespace=target_namespace,
+            pod_port=devserver.ssh_port,
+            silent=True,
         ) as local_port:
             # Proxy mode shuttles data for SSH ProxyCommand
             # Validate that stdin/stdout have buffer attributes
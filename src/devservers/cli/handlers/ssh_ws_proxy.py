@@ -0,0 +1,59 @@
+import io
+import sys
+import threading
+from typing import cast
+
+import websocket
+
+
+def _pump_websocket_to_stdout(
+    ws: websocket.WebSocket, stdout_buffer: io.BufferedIOBase, done: threading.Event
+) -> None:
+    try:
+        while True:
+            data = ws.recv()
+            if not data:
+                break
+            stdout_buffer.write(data if isinstance(data, bytes) else data.encode())
+            stdout_buffer.flush()
+    except (websocket.WebSocketException, OSError):
+        pass  # Expected on disconnect
+    finally:
+        done.set()
+
+
+def ssh_ws_proxy_devserver(url: str) -> None:
+    """
+    Proxy an SSH connection over the WebSocket gateway, for SSH ProxyCommand.
+
+    HTTP(S) proxies are picked up from the `https_proxy`/`http_proxy`
+    environment variables.
+    """
+    # Validate that stdin/stdout have buffer attributes
+    if not hasattr(sys.stdin, "buffer") or not hasattr(sys.stdout, "buffer"):
+        sys.exit(1)  # Silent failure for SSH ProxyCommand
+
+    stdin_buffer = cast(io.BufferedIOBase, sys.stdin.buffer)
+    stdout_buffer = cast(io.BufferedIOBase, sys.stdout.buffer)
+
+    try:
+        ws = websocket.create_connection(url, enable_multithread=True)
+    except Exception as e:
+        print(f"Could not connect to {url}: {e}", file=sys.stderr)
+        sys.exit(1)
+
+    done = threading.Event()
+    reader = threading.Thread(
+        target=_pump_websocket_to_stdout, args=(ws, stdout_buffer, done), daemon=True
+    )
+    reader.start()
+    try:
+        while not done.is_set():
+            data = stdin_buffer.read1(4096)
+            if not data:
+                break
+            ws.send_binary(data)
+    except (websocket.WebSocketException, BrokenPipeError, ConnectionResetError, OSError):
+        pass  # Expected on disconnect - fail silently for SSH ProxyCommand
+    finally:
+        ws.close()
@@ -0,0 +1,190 @@
+import sys
+from datetime import datetime, timezone
+from typing import Any, List, Optional
+
+from kubernetes import client
+from rich.console import Console
+from rich.table import Table
+
+from ..utils import get_current_context
+from ...crds.devserver import DevServer
+from ...utils.time import format_duration
+
+DEFAULT_EVENTS_LIMIT = 10
+
+
+def _age(timestamp: Optional[Any]) -> str:
+    """Format how long ago a timestamp (datetime or RFC 3339 string) was, like kubectl."""
+    if not timestamp:
+        return "-"
+    if isinstance(timestamp, str):
+        timestamp = datetime.strptime(timestamp, "%Y-%m-%dT%H:%M:%SZ").replace(tzinfo=timezone.utc)
+    return format_duration(datetime.now(timezone.utc) - timestamp)
+
+
+def _event_time(event: client.CoreV1Event) -> datetime:
+    timestamp = event.last_timestamp or event.event_time or event.metadata.creation_timestamp
+    return timestamp or datetime.min.replace(tzinfo=timezone.utc)
+
+
+def get_recent_events(
+    core_v1_api: client.CoreV1Api, namespace: str, object_names: List[str], limit: int
+) -> List[client.CoreV1Event]:
+    """
+    Return the most recent events about the given objects, oldest first.
+
+    The DevServer, its StatefulSet, pod and PVC share the DevServer's name
+    (or are derived from it), so events are matched by object name.
+    """
+    events = core_v1_api.list_namespaced_event(namespace=namespace).items
+    relevant = [e for e in events if e.involved_object.name in object_names]
+    return sorted(relevant, key=_event_time)[-limit:] if limit > 0 else []
+
+
+def _container_state(container_status: client.V1ContainerStatus) -> str:
+    state = container_status.state
+    if state.running:
+        return "Running"
+    if state.waiting:
+        return f"Waiting: {state.waiting.reason}"
+    if state.terminated:
+        return f"Terminated: {state.terminated.reason} (exit code {state.terminated.exit_code})"
+    return "Unknown"
+
+
+def _print_summary(console: Console, devserver: DevServer) -> None:
+    spec, status = devserver.spec, devserver.status
+    phase = status.get("phase", "Unknown")
+    color = {"Running": "green", "Failed": "red"}.get(phase, "yellow")
+    console.print(f"[bold]DevServer[/bold] [cyan]{devserver.metadata.name}[/cyan] "
+                  f"in namespace [bold]{devserver.metadata.namespace}[/bold]")
+    console.print(f"  Phase:    [{color}]{phase}[/{color}]")
+    if status.get("message"):
+        console.print(f"  Message:  {status['message']}")
+    console.print(f"  Owner:    {spec.get('owner', '-')}")
+    console.print(f"  Flavor:   {spec.get('flavor', '-')}")
+    console.print(f"  Image:    {spec.get('image', 'default')}")
+    if status.get("expiresAt"):
+        console.print(f"  Expires:  {status['expiresAt']} (in {status.get('expiresIn') or '-'})")
+    if status.get("sshEndpoint"):
+        console.print(f"  SSH:      {status['sshEndpoint']}")
+
+
+def _print_conditions(console: Console, devserver: DevServer) -> None:
+    conditions = devserver.status.get("conditions", [])
+    if not conditions:
+        return
+    table = Table(title="Conditions", title_justify="left")
+    table.add_column("Type", style="cyan")
+    table.add_column("Status")
+    table.add_column("Reason")
+    table.add_column("Message")
+    table.add_column("Since")
+    for condition in conditions:
+        status_color = "green" if condition.get("status") == "True" else "red"
+        table.add_row(
+            condition.get("type", ""),
+            f"[{status_color}]{condition.get('status', '')}[/{status_color}]",
+            condition.get("reason", ""),
+            condition.get("message", ""),
+            _age(condition.get("lastTransitionTime")),
+        )
+    console.print(table)
+
+
+def _print_workload(console: Console, name: str, namespace: str) -> None:
+    apps_v1_api = client.AppsV1Api()
+    core_v1_api = client.CoreV1Api()
+
+    try:
+        statefulset = apps_v1_api.read_namespaced_stateful_set(name=name, namespace=namespace)
+        console.print(
+            f"[bold]StatefulSet[/bold] {name}: "
+            f"{statefulset.status.ready_replicas or 0}/{statefulset.spec.replicas} ready"
+        )
+    except client.ApiException as e:
+        if e.status != 404:
+            raise
+        console.print(f"[bold]StatefulSet[/bold] {name}: [yellow]not created[/yellow]")
+
+    # TODO: The pod name should be dynamically retrieved
+    pod_name = f"{name}-0"
+    try:
+        pod = core_v1_api.read_namespaced_pod(name=pod_name, namespace=namespace)
+    except client.ApiException as e:
+        if e.status != 404:
+            raise
+        console.print(f"[bold]Pod[/bold] {pod_name}: [yellow]not created[/yellow]")
+        return
+
+    console.print(
+        f"[bold]Pod[/bold] {pod_name}: {pod.status.phase}, "
+        f"node {pod.spec.node_name or '-'}, IP {pod.status.pod_ip or '-'}, "
+        f"age {_age(pod.metadata.creation_timestamp)}"
+    )
+    statuses = (pod.status.init_container_statuses or []) + (pod.status.container_statuses or [])
+    for container_status in statuses:
+        console.print(
+            f"  {container_status.name}: {_container_state(container_status)}, "
+            f"{'ready' if container_status.ready else 'not ready'}, "
+            f"{container_status.restart_count} restarts"
+        )
+
+
+def _print_events(console: Console, name: str, namespace: str, limit: int) -> None:
+    object_names = [name, f"{name}-0", f"home-{name}-0"]
+    events = get_recent_events(client.CoreV1Api(), namespace, object_names, limit)
+    if not events:
+        console.print("No recent events.")
+        return
+    table = Table(title="Recent Events", title_justify="left")
+    table.add_column("Age")
+    table.add_column("Type")
+    table.add_column("Object")
+    table.add_column("Reason")
+    table.add_column("Message")
+    for event in events:
+        style = "yellow" if event.type == "Warning" else None
+        count = f" (x{event.count})" if event.count and event.count > 1 else ""
+        table.add_row(
+            _age(_event_time(event)),
+            event.type,
+            f"{event.involved_object.kind}/{event.involved_object.name}",
+            event.reason,
+            f"{event.message}{count}",
+            style=style,
+        )
+    console.print(table)
+
+
+def status_devserver(
+    name: str, namespace: Optional[str] = None, events: int = DEFAULT_EVENTS_LIMIT
+) -> None:
+    """
+    Show a DevServer's phase and conditions, its StatefulSet and pod, and the
+    recent events about them in one report.
+    """
+    console = Console()
+
+    _, target_namespace = get_current_context()
+    if namespace:
+        target_namespace = namespace
+
+    assert target_namespace is not None
+
+    try:
+        devserver = DevServer.get(name=name, namespace=target_namespace)
+        _print_summary(console, devserver)
+        console.print()
+        _print_conditions(console, devserver)
+        _print_workload(console, name, target_namespace)
+        console.print()
+        _print_events(console, name, target_namespace, events)
+    except client.ApiException as e:
+        if e.status == 404:
+            console.print(
+                f"Error: DevServer '{name}' not found in namespace '{target_namespace}'."
+            )
+        else:
+            console.print(f"An error occurred: {e.reason}")
+        sys.exit(1)
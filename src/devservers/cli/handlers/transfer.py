@@ -0,0 +1,330 @@
+import base64
+import contextlib
+import io
+import os
+import posixpath
+import shlex
+import shutil
+import subprocess
+import sys
+import tarfile
+import tempfile
+from pathlib import Path
+from typing import Iterator, List, Optional, Tuple
+
+from kubernetes import client
+from kubernetes.stream import stream
+from rich.console import Console
+
+from ..config import Configuration
+from ..utils import get_current_context
+from ...crds.const import DEFAULT_HOME_MOUNT_PATH
+from ...crds.devserver import DevServer
+from ...utils.network import PortForwardError, kubernetes_port_forward
+from .ssh import _reachable_ssh_endpoint
+
+# The uid/gid of the `dev` user created by the startup script
+DEV_UID = 1000
+DEV_GID = 1000
+# The container that the startup script runs in
+DEVSERVER_CONTAINER = "devserver"
+STDIN_CHUNK_SIZE = 64 * 1024
+
+
+class TransferError(Exception):
+    """A file transfer to or from a DevServer failed."""
+
+
+def parse_remote_path(path: str) -> Tuple[Optional[str], str]:
+    """
+    Split a `<devserver>:<path>` argument, as used by scp.
+
+    Returns:
+        The DevServer name, or None for local paths, and the path. A colon
+        after a slash is part of a local path, e.g. `./a:b`.
+    """
+    name, colon, remote_path = path.partition(":")
+    if not colon or not name or "/" in name:
+        return None, path
+    return name, remote_path
+
+
+def resolve_remote_path(path: str, home: str = DEFAULT_HOME_MOUNT_PATH) -> str:
+    """Resolve a remote path relative to the home directory of the `dev` user."""
+    if path in ("", "~"):
+        return home
+    if path.startswith("~/"):
+        path = path[2:]
+    return posixpath.normpath(posixpath.join(home, path))
+
+
+def _exec(
+    namespace: str, pod_name: str, command: List[str], stdin: Optional[io.BufferedIOBase] = None
+) -> str:
+    """
+    Run a command in the DevServer's container, returning its stdout.
+
+    Raises:
+        TransferError: If the command exits with a non-zero status.
+    """
+    resp = stream(
+        client.CoreV1Api().connect_get_namespaced_pod_exec,
+        pod_name,
+        namespace,
+        container=DEVSERVER_CONTAINER,
+        command=command,
+        stderr=True,
+        stdin=stdin is not None,
+        stdout=True,
+        tty=False,
+        _preload_content=False,
+    )
+    stdout: List[str] = []
+    stderr: List[str] = []
+    try:
+        while resp.is_open():
+            if stdin is not None:
+                chunk = stdin.read(STDIN_CHUNK_SIZE)
+                if chunk:
+                    resp.write_stdin(chunk)
+                else:
+                    stdin = None
+            resp.update(timeout=0 if stdin is not None else 1)
+            if resp.peek_stdout():
+                stdout.append(resp.read_stdout())
+            if resp.peek_stderr():
+                stderr.append(resp.read_stderr())
+    finally:
+        resp.close()
+    if resp.returncode:
+        raise TransferError("".join(stderr).strip() or f"'{command[0]}' failed in the DevServer.")
+    return "".join(stdout)
+
+
+def _remote_has_rsync(namespace: str, pod_name: str) -> bool:
+    try:
+        _exec(namespace, pod_name, ["sh", "-c", "command -v rsync"])
+        return True
+    except TransferError:
+        return False
+
+
+def _dev_owned(tarinfo: tarfile.TarInfo) -> tarfile.TarInfo:
+    """Make uploaded files owned by `dev`, as tar runs as root in the DevServer."""
+    tarinfo.uid, tarinfo.gid = DEV_UID, DEV_GID
+    tarinfo.uname = tarinfo.gname = "dev"
+    return tarinfo
+
+
+def _tar_upload(namespace: str, pod_name: str, local_path: str, remote_dir: str, contents: bool) -> None:
+    with tempfile.TemporaryFile() as archive:
+        with tarfile.open(fileobj=archive, mode="w") as tar:
+            if contents:
+                for entry in sorted(os.listdir(local_path)):
+                    tar.add(os.path.join(local_path, entry), arcname=entry, filter=_dev_owned)
+            else:
+                arcname = os.path.basename(os.path.normpath(local_path))
+                tar.add(local_path, arcname=arcname, filter=_dev_owned)
+        size = archive.tell()
+        archive.seek(0)
+        # exec cannot close stdin, so tar is only fed the archive's bytes
+        script = (
+            '[ -d "$1" ] || { mkdir -p "$1" && chown dev:dev "$1"; } && '
+            'head -c "$2" | tar xf - -C "$1"'
+        )
+        _exec(namespace, pod_name, ["sh", "-c", script, "sh", remote_dir, str(size)], stdin=archive)
+
+
+def _tar_download(namespace: str, pod_name: str, remote_path: str, local_dir: str, contents: bool) -> None:
+    if contents:
+        directory, entry = remote_path, "."
+    else:
+        directory, entry = posixpath.split(remote_path.rstrip("/") or "/")
+    # exec output is decoded as text, so the archive is base64 encoded
+    encoded = _exec(
+        namespace,
+        pod_name,
+        ["sh", "-c", 'tar cf - -C "$1" "$2" | base64', "sh", directory, entry],
+    )
+    os.makedirs(local_dir, exist_ok=True)
+    with tarfile.open(fileobj=io.BytesIO(base64.b64decode(encoded)), mode="r") as tar:
+        if hasattr(tarfile, "data_filter"):
+            tar.extractall(local_dir, filter="data")
+        else:
+            tar.extractall(local_dir)
+
+
+@contextlib.contextmanager
+def _ssh_endpoint(devserver: DevServer, pod_name: str) -> Iterator[Tuple[str, int]]:
+    """Yield the DevServer's external SSH endpoint, or a port-forward to it."""
+    endpoint = _reachable_ssh_endpoint(devserver)
+    if endpoint:
+        yield endpoint
+        return
+    with kubernetes_port_forward(
+        pod_name=pod_name,
+        namespace=devserver.metadata.namespace,
+        pod_port=devserver.ssh_port,
+        silent=True,
+    ) as local_port:
+        yield "localhost", local_port
+
+
+def _rsync(
+    devserver: DevServer,
+    pod_name: str,
+    key_path: Path,
+    source: str,
+    destination: str,
+    upload: bool,
+    rsync_args: List[str],
+) -> None:
+    with _ssh_endpoint(devserver, pod_name) as (host, port):
+        ssh_command = shlex.join([
+            "ssh",
+            "-i", str(key_path),
+            "-p", str(port),
+            "-o", "StrictHostKeyChecking=no",
+            "-o", "UserKnownHostsFile=/dev/null",
+            "-o", "LogLevel=ERROR",
+        ])
+        if upload:
+            destination = f"dev@{host}:{destination}"
+        else:
+            source = f"dev@{host}:{source}"
+        command = ["rsync", "-a", *rsync_args, "-e", ssh_command, source, destination]
+        if subprocess.run(command, check=False).returncode != 0:
+            raise TransferError("rsync failed.")
+
+
+def _transfer(
+    configuration: Configuration,
+    source: str,
+    destination: str,
+    namespace: Optional[str],
+    ssh_private_key_file: Optional[str],
+    contents: bool,
+    rsync_args: List[str],
+) -> None:
+    """
+    Transfer files between the local machine and a DevServer's home volume.
+
+    Uses rsync over SSH when both sides have rsync, and tar over
+    `kubectl exec` otherwise.
+
+    Args:
+        contents: Transfer the contents of the source directory, rather than
+            the directory itself, into the destination directory.
+    """
+    console = Console()
+
+    source_name, source_path = parse_remote_path(source)
+    destination_name, destination_path = parse_remote_path(destination)
+    if (source_name is None) == (destination_name is None):
+        console.print(
+            "[red]Error: Exactly one of the source and destination must be a DevServer "
+            "path like 'mydev:path'.[/red]"
+        )
+        sys.exit(1)
+    upload = destination_name is not None
+    name = destination_name if upload else source_name
+    assert name is not None
+
+    if upload:
+        local_path, remote_path = source_path, destination_path
+        if not os.path.exists(local_path):
+            console.print(f"[red]Error: '{local_path}' does not exist.[/red]")
+            sys.exit(1)
+        if contents and not os.path.isdir(local_path):
+            console.print(f"[red]Error: '{local_path}' is not a directory.[/red]")
+            sys.exit(1)
+    else:
+        local_path, remote_path = destination_path, source_path
+
+    _, target_namespace = get_current_context()
+    if namespace:
+        target_namespace = namespace
+
+    assert target_namespace is not None
+
+    # TODO: The pod name should be dynamically retrieved
+    pod_name = f"{name}-0"
+    key_path = Path(ssh_private_key_file or configuration.ssh_private_key_file).expanduser()
+
+    try:
+        devserver = DevServer.get(name=name, namespace=target_namespace)
+        if devserver.spec.get("hibernated", False):
+            console.print(
+                f"[yellow]DevServer '{name}' is hibernated. "
+                f"Run 'devctl resume {name} --wait' first.[/yellow]"
+            )
+            sys.exit(1)
+        if devserver.status.get("phase") != "Running":
+            console.print(f"[red]Error: DevServer '{name}' is not running yet.[/red]")
+            sys.exit(1)
+        remote_path = resolve_remote_path(
+            remote_path, devserver.spec.get("homeMountPath", DEFAULT_HOME_MOUNT_PATH)
+        )
+
+        if shutil.which("rsync") and key_path.is_file() and _remote_has_rsync(target_namespace, pod_name):
+            # rsync copies a directory's contents when its path ends with a slash
+            rsync_source = source_path if upload else remote_path
+            rsync_source = rsync_source.rstrip("/") + "/" if contents else rsync_source.rstrip("/")
+            rsync_destination = (remote_path if upload else local_path).rstrip("/") + "/"
+            _rsync(devserver, pod_name, key_path, rsync_source, rsync_destination, upload, rsync_args)
+        else:
+            console.print("[dim]rsync is not available, copying with tar over exec.[/dim]")
+            if "--delete" in rsync_args:
+                console.print("[yellow]Warning: --delete needs rsync, no files are deleted.[/yellow]")
+            if upload:
+                _tar_upload(target_namespace, pod_name, local_path, remote_path, contents)
+            else:
+                _tar_download(target_namespace, pod_name, remote_path, local_path, contents)
+    except client.ApiException as e:
+        if e.status == 404:
+            console.print(
+                f"Error: DevServer '{name}' not found in namespace '{target_namespace}'."
+            )
+        else:
+            console.print(f"An error occurred: {e.reason}")
+        sys.exit(1)
+    except (TransferError, PortForwardError, tarfile.TarError) as e:
+        console.print(f"[red]Error: Could not transfer files: {e}[/red]")
+        sys.exit(1)
+
+
+def copy_files(
+    configuration: Configuration,
+    source: str,
+    destination: str,
+    namespace: Optional[str] = None,
+    ssh_private_key_file: Optional[str] = None,
+) -> None:
+    """Copy a file or directory into a directory on, or from, a DevServer."""
+    _transfer(
+        configuration, source, destination, namespace, ssh_private_key_file,
+        contents=False, rsync_args=[],
+    )
+    Console().print(f"Copied '{source}' to '{destination}'.")
+
+
+def sync_files(
+    configuration: Configuration,
+    source: str,
+    destination: str,
+    namespace: Optional[str] = None,
+    ssh_private_key_file: Optional[str] = None,
+    delete: bool = False,
+) -> None:
+    """
+    Make a directory mirror another one, on or from a DevServer. Only files
+    that changed are transferred when rsync is available.
+    """
+    rsync_args = ["--compress", "--partial"]
+    if delete:
+        rsync_args.append("--delete")
+    _transfer(
+        configuration, source, destination, namespace, ssh_private_key_file,
+        contents=True, rsync_args=rsync_args,
+    )
+    Console().print(f"Synced '{source}' to '{destination}'.")
@@ -1,4 +1,5 @@
 import click
+from click.core import ParameterSource
 from rich.console import Console
 from rich.prompt import Confirm
 from pathlib import Path
@@ -8,6 +9,7 @@ import os
 from kubernetes import config as kube_config
 
 from . import handlers
+from ..crds.base import KUBE_CONTEXT_ENV_VAR
 from .ssh_config import ensure_ssh_config_include, set_ssh_config_permission
 from .config import (
     load_config,
@@ -27,10 +29,29 @@ from .config import (
 @click.option(
     "--assume-yes", is_flag=True, help="Automatically answer yes to all prompts."
 )
+@click.option(
+    "--kubeconfig",
+    type=click.Path(dir_okay=False),
+    default=None,
+    help="Path to the kubeconfig file, instead of $KUBECONFIG or ~/.kube/config.",
+)
+@click.option(
+    "--context",
+    "kube_context",
+    type=str,
+    default=None,
+    help="The kubeconfig context to use, instead of the current one.",
+)
 @click.pass_context
-def main(ctx, config_path, assume_yes) -> None:
+def main(ctx, config_path, assume_yes, kubeconfig, kube_context) -> None:
     """A CLI to manage DevServers."""
     ctx.ensure_object(dict)
+    # Exported so that handlers, and the ssh-proxy ProxyCommand spawned by
+    # ssh, pick up the same cluster
+    if kubeconfig:
+        os.environ["KUBECONFIG"] = kubeconfig
+    if kube_context:
+        os.environ[KUBE_CONTEXT_ENV_VAR] = kube_context
     console = Console()
 
     default_config_path = get_default_config_path()
@@ -50,11 +71,33 @@ def main(ctx, config_path, assume_yes) -> None:
 
     ctx.obj["CONFIG"] = load_config(effective_config_path)
     ctx.obj["ASSUME_YES"] = assume_yes
-    kube_config.load_kube_config()
+    kube_config.load_kube_config(
+        config_file=os.environ.get("KUBECONFIG"),
+        context=os.environ.get(KUBE_CONTEXT_ENV_VAR),
+    )
+
+
+def _namespace_option(func):
+    return click.option(
+        "-n",
+        "--namespace",
+        type=str,
+        default=None,
+        help="The namespace to use, defaults to the current kubeconfig context's.",
+    )(func)
+
+
+def _name_argument(func):
+    """Accept the DevServer name positionally, as an alternative to --name."""
+    func = click.argument("name_argument", metavar="[NAME]", required=False)(func)
+    return click.option("--name", type=str, default="dev", help="The name of the DevServer.")(
+        func
+    )
 
 
 @main.command(help="Create a new DevServer.")
-@click.option("--name", type=str, default="dev", help="The name of the DevServer.")
+@_name_argument
+@_namespace_option
 @click.option("--flavor", type=str, required=False, help="The flavor of the DevServer.")
 @click.option("--image", type=str, help="The container image to use.")
 @click.option(
@@ -68,8 +111,9 @@ def main(ctx, config_path, assume_yes) -> None:
     "--ttl",
     "time_to_live",
     type=str,
-    default="4h",
-    help="The time to live for the DevServer.",
+    default=None,
+    help="The time to live for the DevServer, e.g. '4h', '1h30m' or '2d'. Defaults to the "
+    "template's, or '4h'.",
 )
 @click.option(
     "--wait",
@@ -82,49 +126,176 @@ def main(ctx, config_path, assume_yes) -> None:
     default="10Gi",
     help="The size of the persistent home directory.",
 )
+@click.option(
+    "--storage-class",
+    type=str,
+    default=None,
+    help="The StorageClass of the persistent home directory, instead of the flavor's default.",
+)
+@click.option(
+    "--from-snapshot",
+    type=str,
+    default=None,
+    help="Restore the home directory from a DevServerSnapshot in the same namespace.",
+)
+@click.option(
+    "--from-backup",
+    type=str,
+    default=None,
+    help="Restore the home directory from a DevServerBackup name or an s3:// or gs:// archive URL.",
+)
+@click.option(
+    "--from-devserver",
+    type=str,
+    default=None,
+    help="Clone the home directory of another DevServer in the same namespace.",
+)
+@click.option(
+    "--clone",
+    type=str,
+    default=None,
+    help="Clone another DevServer in the same namespace: its spec and home directory.",
+)
+@click.option(
+    "--template",
+    type=str,
+    default=None,
+    help="Create the DevServer from a DevServerTemplate, see 'devctl templates'.",
+)
+@click.option(
+    "--profile",
+    type=str,
+    default=None,
+    help="A DevServerProfile presetting the flavor, image, shared volumes and env.",
+)
+@click.option(
+    "--generate-name",
+    is_flag=True,
+    help="Use the name as a prefix, with a random suffix appended by the cluster.",
+)
 @click.pass_context
 def create(
     ctx,
     name: str,
+    name_argument: Optional[str],
+    namespace: Optional[str],
     flavor: str,
     image: str,
     ssh_public_key_file: str,
-    time_to_live: str,
+    time_to_live: Optional[str],
     wait: bool,
     persistent_home_size: str,
+    storage_class: Optional[str],
+    from_snapshot: Optional[str],
+    from_backup: Optional[str],
+    from_devserver: Optional[str],
+    clone: Optional[str],
+    template: Optional[str],
+    profile: Optional[str],
+    generate_name: bool,
 ) -> None:
     """Create a new DevServer."""
     handlers.create_devserver(
         configuration=ctx.obj["CONFIG"],
-        name=name,
+        name=name_argument or name,
+        namespace=namespace,
         flavor=flavor,
         image=image,
         ssh_public_key_file=ssh_public_key_file,
         time_to_live=time_to_live,
         wait=wait,
         persistent_home_size=persistent_home_size,
+        storage_class=storage_class,
+        from_snapshot=from_snapshot,
+        from_backup=from_backup,
+        from_devserver=from_devserver,
+        clone=clone,
+        template=template,
+        profile=profile,
+        generate_name=generate_name,
     )
 
 
 @main.command(help="Delete a DevServer.")
-@click.option("--name", type=str, default="dev", help="The name of the DevServer.")
+@_name_argument
+@_namespace_option
 @click.pass_context
-def delete(ctx, name: str) -> None:
+def delete(ctx, name: str, name_argument: Optional[str], namespace: Optional[str]) -> None:
     """Delete a DevServer."""
-    handlers.delete_devserver(configuration=ctx.obj["CONFIG"], name=name)
+    handlers.delete_devserver(
+        configuration=ctx.obj["CONFIG"], name=name_argument or name, namespace=namespace
+    )
 
 
 @main.command(help="Describe a DevServer.")
-@click.option("--name", type=str, default="dev", help="The name of the DevServer.")
-def describe(name: str) -> None:
+@_name_argument
+@_namespace_option
+def describe(name: str, name_argument: Optional[str], namespace: Optional[str]) -> None:
     """Describe a DevServer."""
-    handlers.describe_devserver(name=name)
+    handlers.describe_devserver(name=name_argument or name, namespace=namespace)
+
+
+@main.command(
+    help="Show a DevServer's phase and conditions, its StatefulSet and pod, and "
+    "recent events about them."
+)
+@_name_argument
+@_namespace_option
+@click.option(
+    "--events",
+    type=int,
+    default=10,
+    show_default=True,
+    help="The number of recent events to show.",
+)
+def status(name: str, name_argument: Optional[str], namespace: Optional[str], events: int) -> None:
+    """Show an aggregated status report of a DevServer."""
+    handlers.status_devserver(name=name_argument or name, namespace=namespace, events=events)
+
+
+@main.command(help="Extend a DevServer's time to live, e.g. `devctl extend mydev 24h`.")
+@click.argument("name", type=str)
+@click.argument("duration", type=str)
+@_namespace_option
+def extend(name: str, duration: str, namespace: Optional[str]) -> None:
+    """Extend a DevServer's time to live."""
+    handlers.extend_devserver(name=name, duration=duration, namespace=namespace)
+
 
+@main.command(help="Hibernate a DevServer, stopping its pod but keeping its home directory.")
+@_name_argument
+@_namespace_option
+def hibernate(name: str, name_argument: Optional[str], namespace: Optional[str]) -> None:
+    """Hibernate a DevServer."""
+    handlers.hibernate_devserver(name=name_argument or name, namespace=namespace)
 
-@main.command(name="list", help="List all DevServers.")
-def list_command() -> None:
-    """List all DevServers."""
-    handlers.list_devservers()
+
+@main.command(help="Resume a hibernated DevServer.")
+@_name_argument
+@_namespace_option
+@click.option("--wait", is_flag=True, help="Wait for the DevServer to be ready.")
+def resume(name: str, name_argument: Optional[str], namespace: Optional[str], wait: bool) -> None:
+    """Resume a hibernated DevServer."""
+    handlers.resume_devserver(name=name_argument or name, namespace=namespace, wait=wait)
+
+
+@main.command(name="list", help="List DevServers.")
+@_namespace_option
+@click.option(
+    "--owner",
+    type=str,
+    default=None,
+    help="Only list DevServers owned by this user, or 'me' for the current user.",
+)
+@click.option(
+    "-A",
+    "--all-namespaces",
+    is_flag=True,
+    help="List DevServers across all namespaces.",
+)
+def list_command(namespace: Optional[str], owner: Optional[str], all_namespaces: bool) -> None:
+    """List DevServers."""
+    handlers.list_devservers(namespace=namespace, owner=owner, all_namespaces=all_namespaces)
 
 
 @main.command(name="flavors", help="List all DevServer flavors.")
@@ -133,7 +304,16 @@ def flavors() -> None:
     handlers.list_flavors()
 
 
-@main.command(help="SSH into a DevServer.")
+@main.command(name="templates", help="List the DevServer templates on offer.")
+def templates() -> None:
+    """List the DevServer templates on offer."""
+    handlers.list_templates()
+
+
+@main.command(
+    help="SSH into a DevServer, waiting for it to be ready. Connects to its external "
+    "SSH endpoint when reachable and through a port-forward otherwise."
+)
 @click.option("--name", type=str, default="dev", help="The name of the DevServer.")
 @click.option(
     "-i",
@@ -156,7 +336,7 @@ def flavors() -> None:
     is_flag=True,
     help="Connect directly to the DevServer without using SSH config.",
 )
-@click.argument("remote_command", nargs=-1)
+@click.argument("remote_command", metavar="[NAME] [-- COMMAND...]", nargs=-1)
 @click.pass_context
 def ssh(
     ctx,
@@ -167,6 +347,9 @@ def ssh(
     remote_command: tuple[str, ...],
 ) -> None:
     """SSH into a DevServer."""
+    # Without --name, the first argument names the DevServer: `devctl ssh mydev -- ls`
+    if remote_command and ctx.get_parameter_source("name") == ParameterSource.DEFAULT:
+        name, remote_command = remote_command[0], remote_command[1:]
     handlers.ssh_devserver(
         configuration=ctx.obj["CONFIG"],
         name=name,
@@ -178,6 +361,76 @@ def ssh(
     )
 
 
+@main.command(
+    help="Copy a file or directory into a directory on, or from, a DevServer, e.g. "
+    "`devctl cp ./data mydev:work` or `devctl cp mydev:work/out .`. DevServer paths "
+    "are relative to its home directory."
+)
+@click.argument("source", type=str)
+@click.argument("destination", type=str)
+@_namespace_option
+@click.option(
+    "-i",
+    "--identity-file",
+    "ssh_private_key_file",
+    type=str,
+    default=None,
+    help="Path to the SSH private key file.",
+)
+@click.pass_context
+def cp(
+    ctx, source: str, destination: str, namespace: Optional[str], ssh_private_key_file: Optional[str]
+) -> None:
+    """Copy files to or from a DevServer."""
+    handlers.copy_files(
+        configuration=ctx.obj["CONFIG"],
+        source=source,
+        destination=destination,
+        namespace=namespace,
+        ssh_private_key_file=ssh_private_key_file,
+    )
+
+
+@main.command(
+    help="Make a directory mirror another one, on or from a DevServer, e.g. "
+    "`devctl sync ./project mydev:project`. Only changed files are transferred."
+)
+@click.argument("source", type=str)
+@click.argument("destination", type=str)
+@_namespace_option
+@click.option(
+    "-i",
+    "--identity-file",
+    "ssh_private_key_file",
+    type=str,
+    default=None,
+    help="Path to the SSH private key file.",
+)
+@click.option(
+    "--delete",
+    is_flag=True,
+    help="Delete files in the destination that are not in the source.",
+)
+@click.pass_context
+def sync(
+    ctx,
+    source: str,
+    destination: str,
+    namespace: Optional[str],
+    ssh_private_key_file: Optional[str],
+    delete: bool,
+) -> None:
+    """Sync a directory to or from a DevServer."""
+    handlers.sync_files(
+        configuration=ctx.obj["CONFIG"],
+        source=source,
+        destination=destination,
+        namespace=namespace,
+        ssh_private_key_file=ssh_private_key_file,
+        delete=delete,
+    )
+
+
 @main.command(name="ssh-proxy", help="Run in proxy mode for SSH ProxyCommand.", hidden=True)
 @click.option("--name", type=str, default="dev", help="The name of the DevServer.")
 @click.option(
@@ -195,9 +448,37 @@ def ssh(
     help="Path to the kubeconfig file.",
     hidden=True,
 )
-def ssh_proxy(name: str, namespace: Optional[str], kubeconfig_path: Optional[str]) -> None:
+@click.option(
+    "--kube-context",
+    type=str,
+    default=None,
+    help="The kubeconfig context to use.",
+    hidden=True,
+)
+def ssh_proxy(
+    name: str,
+    namespace: Optional[str],
+    kubeconfig_path: Optional[str],
+    kube_context: Optional[str],
+) -> None:
     """Run in proxy mode for SSH ProxyCommand."""
-    handlers.ssh_proxy_devserver(name=name, namespace=namespace, kubeconfig_path=kubeconfig_path)
+    handlers.ssh_proxy_devserver(
+        name=name,
+        namespace=namespace,
+        kubeconfig_path=kubeconfig_path,
+        kube_context=kube_context,
+    )
+
+
+@main.command(
+    name="ssh-ws-proxy",
+    help="Tunnel SSH over the WebSocket gateway, for SSH ProxyCommand.",
+    hidden=True,
+)
+@click.option("--url", type=str, required=True, help="The DevServer's WebSocket tunnel URL.")
+def ssh_ws_proxy(url: str) -> None:
+    """Tunnel SSH over the WebSocket gateway, for SSH ProxyCommand."""
+    handlers.ssh_ws_proxy_devserver(url=url)
 
 
 @main.group()
@@ -1,6 +1,6 @@
 import sys
 from pathlib import Path
-from typing import Optional
+from typing import List, Optional, Tuple
 
 from rich.console import Console
 from rich.prompt import Confirm
@@ -118,6 +118,11 @@ def set_ssh_config_permission(
     permission_file.write_text("yes" if enabled else "no")
 
 
+def devserver_tunnel_url(gateway_url: str, name: str, namespace: str) -> str:
+    """Returns the URL of a DevServer's SSH tunnel on the WebSocket gateway."""
+    return f"{gateway_url.rstrip('/')}/ssh/{namespace}/{name}"
+
+
 def create_ssh_config_for_devserver(
     ssh_config_dir: Path,
     name: str,
@@ -125,8 +130,12 @@ def create_ssh_config_for_devserver(
     user: Optional[str] = None,
     namespace: Optional[str] = None,
     kubeconfig_path: Optional[str] = None,
+    kube_context: Optional[str] = None,
     ssh_forward_agent: bool = False,
     assume_yes: bool = False,
+    host_keys: Optional[List[str]] = None,
+    websocket_gateway_url: Optional[str] = None,
+    ssh_endpoint: Optional[Tuple[str, int]] = None,
 ) -> tuple[Path, bool, str]:
     """
     Creates an SSH config file for a devserver.
@@ -138,8 +147,18 @@ def create_ssh_config_for_devserver(
         user: The user associated with the devserver.
         namespace: The namespace of the devserver.
         kubeconfig_path: Optional path to the kubeconfig file.
+        kube_context: Optional kubeconfig context, if not the current one.
         ssh_forward_agent: If True, forward the SSH agent. Default is False.
         assume_yes: If True, automatically grant permission without prompting.
+        host_keys: Public host keys published in the DevServer status. When
+            given, they are pinned in a known_hosts file next to the config
+            and strict host key checking is enabled.
+        websocket_gateway_url: Optional base URL of the SSH-over-WebSocket
+            gateway. When given, SSH is tunnelled through the gateway instead
+            of a Kubernetes port-forward.
+        ssh_endpoint: Optional (host, port) the DevServer is reachable on
+            from outside the cluster. When given, SSH connects to it directly
+            instead of through a proxy.
 
     Returns:
         A tuple containing the path to the config file, a boolean indicating
@@ -153,6 +172,7 @@ def create_ssh_config_for_devserver(
     key_path = Path(ssh_private_key_file).expanduser()
     config_filename = f"{user}-{name}.sshconfig" if user else f"{name}.sshconfig"
     config_path = ssh_config_dir / config_filename
+    known_hosts_path = config_path.with_suffix(".known_hosts")
 
     python_executable = Path(sys.executable)
 
@@ -160,14 +180,19 @@ def create_ssh_config_for_devserver(
         str(python_executable),
         "-m",
         "devservers.cli.main",
-        "ssh-proxy",
-        "--name",
-        name,
     ]
-    if namespace:
-        proxy_command_parts.extend(["--namespace", namespace])
-    if kubeconfig_path:
-        proxy_command_parts.extend(["--kubeconfig-path", kubeconfig_path])
+    if websocket_gateway_url and namespace:
+        proxy_command_parts.extend(
+            ["ssh-ws-proxy", "--url", devserver_tunnel_url(websocket_gateway_url, name, namespace)]
+        )
+    else:
+        proxy_command_parts.extend(["ssh-proxy", "--name", name])
+        if namespace:
+            proxy_command_parts.extend(["--namespace", namespace])
+        if kubeconfig_path:
+            proxy_command_parts.extend(["--kubeconfig-path", kubeconfig_path])
+        if kube_context:
+            proxy_command_parts.extend(["--kube-context", kube_context])
 
     proxy_command = " ".join(proxy_command_parts)
 
@@ -177,15 +202,33 @@ def create_ssh_config_for_devserver(
     else:
         hostname = f"devserver-{name}"
 
+    if host_keys:
+        known_hosts_path.write_text("".join(f"{hostname} {key}\n" for key in host_keys))
+        known_hosts_path.chmod(0o600)
+        host_key_options = f"""    StrictHostKeyChecking yes
+    UserKnownHostsFile {known_hosts_path}"""
+    else:
+        # Older operators do not publish host keys, so there is nothing to pin
+        known_hosts_path.unlink(missing_ok=True)
+        host_key_options = """    StrictHostKeyChecking no
+    UserKnownHostsFile /dev/null"""
+
+    if ssh_endpoint:
+        # Host keys are pinned under the alias, not the endpoint's address
+        connect_options = f"""    HostName {ssh_endpoint[0]}
+    Port {ssh_endpoint[1]}
+    HostKeyAlias {hostname}"""
+    else:
+        connect_options = f"    ProxyCommand sh -c '{proxy_command}'"
+
     config_content = f"""
 Host {hostname}
     User dev
-    ProxyCommand sh -c '{proxy_command}'
+{connect_options}
     IdentityFile {key_path}
     IdentityAgent SSH_AUTH_SOCK
     ForwardAgent {"yes" if ssh_forward_agent else "no"}
-    StrictHostKeyChecking no
-    UserKnownHostsFile /dev/null
+{host_key_options}
 """
     config_path.write_text(config_content)
     config_path.chmod(0o600)
@@ -205,3 +248,4 @@ def remove_ssh_config_for_devserver(
     config_path = ssh_config_dir / config_filename
     if config_path.exists():
         config_path.unlink()
+    config_path.with_suffix(".known_hosts").unlink(missing_ok=True)
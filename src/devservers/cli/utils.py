@@ -2,18 +2,24 @@ import os
 from kubernetes import config
 from typing import Tuple, Optional
 
+from ..crds.base import KUBE_CONTEXT_ENV_VAR
+
 
 def get_current_context() -> Tuple[Optional[str], Optional[str]]:
     """
     Returns the current user and namespace from the active kubeconfig context.
-    Respects the KUBECONFIG environment variable.
+    Respects the KUBECONFIG environment variable, and the context selected
+    with `--context`.
     """
     try:
         contexts, active_context = config.list_kube_config_contexts(
             config_file=os.environ.get("KUBECONFIG")
         )
+        context_name = os.environ.get(KUBE_CONTEXT_ENV_VAR)
+        if context_name:
+            active_context = next(c for c in contexts if c["name"] == context_name)
         context_data = active_context.get("context", {})
         return context_data.get("user"), context_data.get("namespace", "default")
-    except (config.ConfigException, IndexError):
+    except (config.ConfigException, IndexError, StopIteration):
         # Fallback if no config is found or context is incomplete
         return None, "default"
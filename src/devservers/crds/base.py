@@ -1,5 +1,6 @@
 from dataclasses import asdict, dataclass, field, fields
 from typing import Any, Dict, List, Optional, Type, TypeVar, Generator
+import os
 import time
 from kubernetes import client, config, watch
 
@@ -8,6 +9,10 @@ from .errors import KubeConfigError
 # A generic type for BaseCustomResource subclasses
 T = TypeVar("T", bound="BaseCustomResource")
 
+# Selects a kubeconfig context other than the current one, e.g. from
+# `devctl --context` or `kubectl devserver --context`
+KUBE_CONTEXT_ENV_VAR = "DEVCTL_KUBE_CONTEXT"
+
 
 def _get_k8s_api() -> client.CustomObjectsApi:
     """
@@ -17,7 +22,10 @@ def _get_k8s_api() -> client.CustomObjectsApi:
     Kubernetes configuration cannot be loaded.
     """
     try:
-        config.load_kube_config()
+        config.load_kube_config(
+            config_file=os.environ.get("KUBECONFIG"),
+            context=os.environ.get(KUBE_CONTEXT_ENV_VAR),
+        )
     except config.ConfigException as e:
         # Re-raise with a more user-friendly message
         raise KubeConfigError(
@@ -34,6 +42,9 @@ class ObjectMeta:
     namespace: Optional[str] = None
     labels: Dict[str, str] = field(default_factory=dict)
     annotations: Dict[str, str] = field(default_factory=dict)
+    # Named like the API's field, so that it round-trips through to_dict and from_dict.
+    # The API server appends a random suffix to it when the name is empty.
+    generateName: Optional[str] = None
 
     @classmethod
     def from_dict(cls, data: Dict[str, Any]) -> "ObjectMeta":
@@ -127,6 +138,9 @@ class BaseCustomResource:
                 body=resource.to_dict(),
             )
 
+        if not metadata.name:
+            # Generated by the API server from generateName
+            resource.metadata.name = created_obj["metadata"]["name"]
         resource.status = created_obj.get("status", {})
         return resource
 
@@ -135,11 +149,26 @@ class BaseCustomResource:
         cls: Type[T],
         namespace: Optional[str] = None,
         api: Optional[client.CustomObjectsApi] = None,
+        all_namespaces: bool = False,
+        field_selector: Optional[str] = None,
     ) -> List[T]:
-        """Lists all custom resources."""
+        """
+        Lists all custom resources, or those matching `field_selector`.
+
+        Namespaced resources are listed across the whole cluster when
+        `all_namespaces` is set, instead of in `namespace`.
+        """
         api_instance = api or _get_k8s_api()
+        selector_kwargs = {"field_selector": field_selector} if field_selector else {}
 
-        if cls.namespaced:
+        if cls.namespaced and all_namespaces:
+            result = api_instance.list_cluster_custom_object(
+                group=cls.group,
+                version=cls.version,
+                plural=cls.plural,
+                **selector_kwargs,
+            )
+        elif cls.namespaced:
             if not namespace:
                 raise ValueError("Namespace is required for namespaced resources")
             result = api_instance.list_namespaced_custom_object(
@@ -147,6 +176,7 @@ class BaseCustomResource:
                 version=cls.version,
                 namespace=namespace,
                 plural=cls.plural,
+                **selector_kwargs,
             )
         else:
             if namespace:
@@ -155,6 +185,7 @@ class BaseCustomResource:
                 group=cls.group,
                 version=cls.version,
                 plural=cls.plural,
+                **selector_kwargs,
             )
 
         return [
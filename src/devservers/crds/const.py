@@ -1,6 +1,31 @@
+from datetime import timedelta
+
 CRD_GROUP = "devserver.io"
 CRD_VERSION = "v1"
 
 CRD_PLURAL_DEVSERVER = "devservers"
 CRD_PLURAL_DEVSERVERFLAVOR = "devserverflavors"
 CRD_PLURAL_DEVSERVERUSER = "devserverusers"
+CRD_PLURAL_DEVSERVERSNAPSHOT = "devserversnapshots"
+CRD_PLURAL_DEVSERVERBACKUP = "devserverbackups"
+CRD_PLURAL_DEVSERVERSET = "devserversets"
+CRD_PLURAL_DEVSERVERCLAIM = "devserverclaims"
+CRD_PLURAL_DEVSERVERTEMPLATE = "devservertemplates"
+CRD_PLURAL_DEVSERVERPROFILE = "devserverprofiles"
+CRD_PLURAL_DEVSERVERCALENDAR = "devservercalendars"
+
+# Port sshd listens on inside a DevServer pod, unless spec.ssh.port is set
+DEFAULT_SSH_PORT = 22
+
+# Where a DevServer's home volume is mounted, unless spec.homeMountPath is set
+DEFAULT_HOME_MOUNT_PATH = "/home/dev"
+
+# Type of a DevServer's SSH Service, unless spec.ssh.serviceType is set
+DEFAULT_SSH_SERVICE_TYPE = "NodePort"
+
+# Longest spec.lifecycle.timeToLive a DevServer may have, counted from its creation
+MAX_TIME_TO_LIVE = timedelta(days=7)
+
+# Longest DevServer name: its StatefulSet's pods get a controller-revision-hash
+# label of the name plus an 11 character suffix, which must fit in 63 characters
+MAX_DEVSERVER_NAME_LENGTH = 52
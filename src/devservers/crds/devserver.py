@@ -1,8 +1,17 @@
-from dataclasses import dataclass, field, asdict
-from typing import Any, Dict, Optional
+from dataclasses import dataclass, field
+from datetime import timedelta
+from typing import Any, Dict, List, Optional, Tuple
 from kubernetes import client
 from .base import BaseCustomResource, ObjectMeta
-from .const import CRD_GROUP, CRD_VERSION, CRD_PLURAL_DEVSERVER
+from .const import (
+    CRD_GROUP,
+    CRD_VERSION,
+    CRD_PLURAL_DEVSERVER,
+    DEFAULT_SSH_PORT,
+    DEFAULT_SSH_SERVICE_TYPE,
+    MAX_TIME_TO_LIVE,
+)
+from ..utils.time import format_duration, parse_duration
 
 
 @dataclass
@@ -11,6 +20,14 @@ class PersistentHomeSpec:
 
     enabled: bool
     size: str = "10Gi"
+    storage_class_name: Optional[str] = None
+
+
+def _escape_field_selector_value(value: str) -> str:
+    """Escape the separators in a field selector value with backslashes."""
+    for char in ("\\", ",", "=", "!"):
+        value = value.replace(char, f"\\{char}")
+    return value
 
 
 @dataclass
@@ -36,6 +53,81 @@ class DevServer(BaseCustomResource):
         self.spec = spec
         self.status = status or {}
 
+    @classmethod
+    def list_by_owner(
+        cls,
+        owner: str,
+        namespace: Optional[str] = None,
+        api: Optional[client.CustomObjectsApi] = None,
+        all_namespaces: bool = False,
+    ) -> List["DevServer"]:
+        """
+        Lists the DevServers of an owner.
+
+        The API server filters on `spec.owner`, a selectable field of the CRD,
+        so that only the owner's DevServers are sent. API servers that do not
+        support selectable fields (before Kubernetes 1.31) reject the field
+        selector, in which case all DevServers are listed and filtered here.
+        """
+        field_selector = f"spec.owner={_escape_field_selector_value(owner)}"
+        try:
+            return cls.list(namespace, api, all_namespaces, field_selector=field_selector)
+        except client.ApiException as e:
+            if e.status != 400:
+                raise
+        devservers = cls.list(namespace, api, all_namespaces)
+        return [devserver for devserver in devservers if devserver.spec.get("owner") == owner]
+
+    @property
+    def ssh_port(self) -> int:
+        """The port sshd listens on inside the DevServer's pod."""
+        return int(self.spec.get("ssh", {}).get("port", DEFAULT_SSH_PORT))
+
+    @property
+    def external_ssh_endpoint(self) -> Optional[Tuple[str, int]]:
+        """
+        The (host, port) the SSH Service is exposed on outside the cluster.
+
+        Returns:
+            The endpoint published in the status for NodePort and LoadBalancer
+            Services, or None for ClusterIP Services and while it is unknown.
+        """
+        service_type = self.spec.get("ssh", {}).get("serviceType", DEFAULT_SSH_SERVICE_TYPE)
+        endpoint = self.status.get("sshEndpoint")
+        if service_type == "ClusterIP" or not endpoint:
+            return None
+        host, _, port = endpoint.rpartition(":")
+        return host, int(port)
+
+    def extended_time_to_live(self, extension: timedelta) -> str:
+        """
+        Compute the spec.lifecycle.timeToLive that extends the DevServer's
+        lifetime by `extension`.
+
+        Raises:
+            ValueError: If the DevServer has no TTL, its template allows no
+                more extensions, or the extended TTL would exceed
+                MAX_TIME_TO_LIVE.
+        """
+        ttl = self.spec.get("lifecycle", {}).get("timeToLive")
+        if not ttl:
+            raise ValueError(f"DevServer '{self.metadata.name}' has no TTL and never expires.")
+        limits = self.status.get("lifecycleLimits", {})
+        if limits.get("extensionsRemaining") == 0:
+            raise ValueError(
+                f"DevServer '{self.metadata.name}' has used all the extensions "
+                f"template '{limits.get('template')}' allows."
+            )
+
+        new_ttl = parse_duration(ttl) + extension
+        if new_ttl > MAX_TIME_TO_LIVE:
+            headroom = format_duration(MAX_TIME_TO_LIVE - parse_duration(ttl), max_units=4)
+            raise ValueError(
+                f"DevServers cannot live longer than {format_duration(MAX_TIME_TO_LIVE)}. "
+                f"'{self.metadata.name}' can be extended by at most {headroom or '0s'}."
+            )
+        return format_duration(new_ttl, max_units=4)
+
     @property
     def persistent_home(self) -> Optional[PersistentHomeSpec]:
         """
@@ -46,7 +138,11 @@ class DevServer(BaseCustomResource):
         """
         persistent_home_data = self.spec.get("persistentHome")
         if persistent_home_data:
-            return PersistentHomeSpec(**persistent_home_data)
+            return PersistentHomeSpec(
+                enabled=persistent_home_data.get("enabled", False),
+                size=persistent_home_data.get("size", "10Gi"),
+                storage_class_name=persistent_home_data.get("storageClassName"),
+            )
         return None
 
     @persistent_home.setter
@@ -55,6 +151,9 @@ class DevServer(BaseCustomResource):
         Sets the persistentHome spec from a PersistentHomeSpec object.
         """
         if value:
-            self.spec["persistentHome"] = asdict(value)
+            persistent_home: Dict[str, Any] = {"enabled": value.enabled, "size": value.size}
+            if value.storage_class_name:
+                persistent_home["storageClassName"] = value.storage_class_name
+            self.spec["persistentHome"] = persistent_home
         elif "persistentHome" in self.spec:
             del self.spec["persistentHome"]
@@ -0,0 +1,7 @@
+"""
+SSH-over-WebSocket gateway.
+
+An optional component that tunnels SSH to DevServers over WebSocket, so users
+behind proxies that block port 22 can connect through a single HTTPS ingress.
+Run it with `python -m devservers.gateway`.
+"""
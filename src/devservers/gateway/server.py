@@ -0,0 +1,163 @@
+"""
+WebSocket server tunnelling SSH connections to DevServer pods.
+
+A DevServer is addressed either by path, `/ssh/<namespace>/<name>`, or, when
+`DEVSERVER_WS_GATEWAY_DOMAIN` is set, by subdomain: a request for `/ssh` on
+`<name>.<namespace>.<domain>`. Binary WebSocket messages are relayed verbatim
+to the sshd of the DevServer's pod, so authentication is left to SSH itself.
+"""
+import asyncio
+import logging
+import os
+from typing import Optional, Tuple
+
+from aiohttp import WSMsgType, web
+from kubernetes import client, config
+
+from ..crds.const import CRD_GROUP, CRD_PLURAL_DEVSERVER, CRD_VERSION, DEFAULT_SSH_PORT
+
+GATEWAY_PORT = int(os.environ.get("DEVSERVER_WS_GATEWAY_PORT", 8080))
+GATEWAY_DOMAIN = os.environ.get("DEVSERVER_WS_GATEWAY_DOMAIN")
+
+READ_CHUNK_SIZE = 64 * 1024
+
+logger = logging.getLogger(__name__)
+
+
+class TargetNotFound(Exception):
+    """The requested DevServer, or its pod, does not exist."""
+
+
+def parse_subdomain(host: str, domain: str) -> Optional[Tuple[str, str]]:
+    """
+    Extract the DevServer name and namespace from a `<name>.<namespace>.<domain>` host.
+
+    Returns:
+        A `(namespace, name)` tuple, or None if the host is not under the domain.
+    """
+    hostname = host.split(":", 1)[0].lower()
+    suffix = f".{domain.lower().strip('.')}"
+    if not hostname.endswith(suffix):
+        return None
+    labels = hostname[: -len(suffix)].split(".")
+    if len(labels) != 2 or not all(labels):
+        return None
+    name, namespace = labels
+    return namespace, name
+
+
+async def resolve_target(namespace: str, name: str) -> Tuple[str, int]:
+    """
+    Find the address of a DevServer's sshd.
+
+    Raises:
+        TargetNotFound: If the DevServer or its pod does not exist or has no IP yet.
+    """
+    custom_objects_api = client.CustomObjectsApi()
+    core_v1 = client.CoreV1Api()
+    try:
+        devserver = await asyncio.to_thread(
+            custom_objects_api.get_namespaced_custom_object,
+            group=CRD_GROUP,
+            version=CRD_VERSION,
+            plural=CRD_PLURAL_DEVSERVER,
+            namespace=namespace,
+            name=name,
+        )
+        # TODO: The pod name should be dynamically retrieved
+        pod = await asyncio.to_thread(
+            core_v1.read_namespaced_pod, name=f"{name}-0", namespace=namespace
+        )
+    except client.ApiException as e:
+        if e.status == 404:
+            raise TargetNotFound(f"DevServer '{namespace}/{name}' not found.")
+        raise
+
+    if not pod.status or not pod.status.pod_ip:
+        raise TargetNotFound(f"DevServer '{namespace}/{name}' has no running pod.")
+    port = int(devserver["spec"].get("ssh", {}).get("port", DEFAULT_SSH_PORT))
+    return pod.status.pod_ip, port
+
+
+async def _pump_websocket_to_tcp(ws: web.WebSocketResponse, writer: asyncio.StreamWriter) -> None:
+    async for msg in ws:
+        if msg.type == WSMsgType.BINARY:
+            writer.write(msg.data)
+            await writer.drain()
+        elif msg.type in (WSMsgType.CLOSE, WSMsgType.ERROR):
+            break
+    writer.close()
+
+
+async def _pump_tcp_to_websocket(reader: asyncio.StreamReader, ws: web.WebSocketResponse) -> None:
+    while True:
+        data = await reader.read(READ_CHUNK_SIZE)
+        if not data:
+            break
+        await ws.send_bytes(data)
+    await ws.close()
+
+
+async def tunnel(request: web.Request, namespace: str, name: str) -> web.StreamResponse:
+    """Upgrade the request to a WebSocket and relay it to the DevServer's sshd."""
+    try:
+        host, port = await resolve_target(namespace, name)
+    except TargetNotFound as e:
+        raise web.HTTPNotFound(text=str(e))
+
+    try:
+        reader, writer = await asyncio.open_connection(host, port)
+    except OSError as e:
+        logger.warning(f"Could not connect to DevServer '{namespace}/{name}' at {host}:{port}: {e}")
+        raise web.HTTPBadGateway(text=f"DevServer '{namespace}/{name}' is not accepting SSH.")
+
+    ws = web.WebSocketResponse(heartbeat=30)
+    await ws.prepare(request)
+    logger.info(f"Tunnelling {request.remote} to DevServer '{namespace}/{name}'.")
+
+    tasks = [
+        asyncio.create_task(_pump_websocket_to_tcp(ws, writer)),
+        asyncio.create_task(_pump_tcp_to_websocket(reader, ws)),
+    ]
+    try:
+        # Either side closing ends the session
+        _, pending = await asyncio.wait(tasks, return_when=asyncio.FIRST_COMPLETED)
+        for task in pending:
+            task.cancel()
+    finally:
+        writer.close()
+        await ws.close()
+    logger.info(f"Closed tunnel from {request.remote} to DevServer '{namespace}/{name}'.")
+    return ws
+
+
+async def _handle_path(request: web.Request) -> web.StreamResponse:
+    return await tunnel(request, request.match_info["namespace"], request.match_info["name"])
+
+
+async def _handle_subdomain(request: web.Request) -> web.StreamResponse:
+    target = parse_subdomain(request.host, GATEWAY_DOMAIN) if GATEWAY_DOMAIN else None
+    if target is None:
+        raise web.HTTPNotFound(text="Use /ssh/<namespace>/<name> or a DevServer subdomain.")
+    return await tunnel(request, *target)
+
+
+async def _handle_healthz(request: web.Request) -> web.Response:
+    return web.Response(text="ok")
+
+
+def create_app() -> web.Application:
+    app = web.Application()
+    app.router.add_get("/healthz", _handle_healthz)
+    app.router.add_get("/ssh", _handle_subdomain)
+    app.router.add_get("/ssh/{namespace}/{name}", _handle_path)
+    return app
+
+
+def main() -> None:
+    logging.basicConfig(level=logging.INFO, format="%(asctime)s %(levelname)s %(message)s")
+    try:
+        config.load_incluster_config()
+    except config.ConfigException:
+        config.load_kube_config()
+    web.run_app(create_app(), port=GATEWAY_PORT)
@@ -0,0 +1,219 @@
+"""
+Alerts to the operator's on-call about systemic failures.
+
+Unlike the notifications sent to the owners of DevServers, these are about
+the operator and the cluster rather than a single DevServer:
+
+- `ReconcileErrors`: more than `DEVSERVER_ALERT_RECONCILE_ERRORS`
+  reconciles failed with unexpected errors within `DEVSERVER_ALERT_WINDOW`
+  seconds, e.g. because the API server is down or throttles the operator.
+- `CapacityCheckFailures`: a flavor had no capacity for new DevServers more
+  than `DEVSERVER_ALERT_CAPACITY_FAILURES` times within the window.
+- `FlavorMissing`: a DevServerFlavor that DevServers were provisioned with
+  was deleted.
+
+Alerts are sent to PagerDuty, Opsgenie and a webhook, whichever are
+configured, and the same alert is only sent again after
+`DEVSERVER_ALERT_COOLDOWN` seconds. Their keys double as PagerDuty dedup
+keys and Opsgenie aliases, so that repeated alerts are grouped into one
+incident. Failing to send an alert is logged, and never fails a reconcile.
+"""
+import asyncio
+import collections
+import logging
+import os
+import time
+from typing import Any, Awaitable, Callable, Deque, Dict, List, NamedTuple
+
+import aiohttp
+
+ALERT_PAGERDUTY_ROUTING_KEY = os.environ.get("DEVSERVER_ALERT_PAGERDUTY_ROUTING_KEY", "")
+ALERT_PAGERDUTY_URL = os.environ.get(
+    "DEVSERVER_ALERT_PAGERDUTY_URL", "https://events.pagerduty.com/v2/enqueue"
+)
+ALERT_OPSGENIE_API_KEY = os.environ.get("DEVSERVER_ALERT_OPSGENIE_API_KEY", "")
+ALERT_OPSGENIE_URL = os.environ.get(
+    "DEVSERVER_ALERT_OPSGENIE_URL", "https://api.opsgenie.com/v2/alerts"
+)
+ALERT_WEBHOOK_URL = os.environ.get("DEVSERVER_ALERT_WEBHOOK_URL", "")
+ALERT_WINDOW = float(os.environ.get("DEVSERVER_ALERT_WINDOW", 600))
+ALERT_RECONCILE_ERRORS = int(os.environ.get("DEVSERVER_ALERT_RECONCILE_ERRORS", 20))
+ALERT_CAPACITY_FAILURES = int(os.environ.get("DEVSERVER_ALERT_CAPACITY_FAILURES", 10))
+ALERT_COOLDOWN = float(os.environ.get("DEVSERVER_ALERT_COOLDOWN", 3600))
+
+SEND_TIMEOUT_SECONDS = 10
+SOURCE = "devserver-operator"
+
+SEVERITY_CRITICAL = "critical"
+SEVERITY_ERROR = "error"
+SEVERITY_WARNING = "warning"
+
+# Opsgenie has priorities rather than severities
+OPSGENIE_PRIORITIES = {SEVERITY_CRITICAL: "P1", SEVERITY_ERROR: "P2", SEVERITY_WARNING: "P3"}
+
+logger = logging.getLogger(__name__)
+
+
+class Alert(NamedTuple):
+    name: str
+    key: str
+    summary: str
+    severity: str
+    details: Dict[str, Any]
+
+
+Sink = Callable[[aiohttp.ClientSession, Alert], Awaitable[None]]
+
+
+async def send_to_pagerduty(session: aiohttp.ClientSession, alert: Alert) -> None:
+    """Trigger a PagerDuty incident through the Events API v2."""
+    event = {
+        "routing_key": ALERT_PAGERDUTY_ROUTING_KEY,
+        "event_action": "trigger",
+        "dedup_key": alert.key,
+        "payload": {
+            "summary": alert.summary,
+            "source": SOURCE,
+            "severity": alert.severity,
+            "component": alert.name,
+            "custom_details": alert.details,
+        },
+    }
+    async with session.post(ALERT_PAGERDUTY_URL, json=event) as response:
+        response.raise_for_status()
+
+
+async def send_to_opsgenie(session: aiohttp.ClientSession, alert: Alert) -> None:
+    """Create an Opsgenie alert."""
+    body = {
+        "message": alert.summary[:130],
+        "alias": alert.key,
+        "description": alert.summary,
+        "priority": OPSGENIE_PRIORITIES.get(alert.severity, "P3"),
+        "source": SOURCE,
+        "tags": [alert.name],
+        "details": {name: str(value) for name, value in alert.details.items()},
+    }
+    headers = {"Authorization": f"GenieKey {ALERT_OPSGENIE_API_KEY}"}
+    async with session.post(ALERT_OPSGENIE_URL, json=body, headers=headers) as response:
+        response.raise_for_status()
+
+
+async def send_to_webhook(session: aiohttp.ClientSession, alert: Alert) -> None:
+    """POST the alert as JSON, e.g. to Alertmanager-compatible glue or chat-ops."""
+    async with session.post(ALERT_WEBHOOK_URL, json=alert._asdict()) as response:
+        response.raise_for_status()
+
+
+def configured_sinks() -> List[Sink]:
+    sinks: List[Sink] = []
+    if ALERT_PAGERDUTY_ROUTING_KEY:
+        sinks.append(send_to_pagerduty)
+    if ALERT_OPSGENIE_API_KEY:
+        sinks.append(send_to_opsgenie)
+    if ALERT_WEBHOOK_URL:
+        sinks.append(send_to_webhook)
+    return sinks
+
+
+class Alerter:
+    """Counts failures and sends alerts when they add up."""
+
+    def __init__(
+        self,
+        sinks: List[Sink],
+        window_seconds: float = ALERT_WINDOW,
+        cooldown_seconds: float = ALERT_COOLDOWN,
+        reconcile_errors: int = ALERT_RECONCILE_ERRORS,
+        capacity_failures: int = ALERT_CAPACITY_FAILURES,
+        clock: Callable[[], float] = time.monotonic,
+    ) -> None:
+        self.sinks = sinks
+        self.window_seconds = window_seconds
+        self.cooldown_seconds = cooldown_seconds
+        self.reconcile_errors = reconcile_errors
+        self.capacity_failures = capacity_failures
+        self._clock = clock
+        self._failures: Dict[str, Deque[float]] = collections.defaultdict(collections.deque)
+        self._sent: Dict[str, float] = {}
+
+    def _count(self, key: str) -> int:
+        """Record a failure, returning the number of them within the window."""
+        now = self._clock()
+        failures = self._failures[key]
+        failures.append(now)
+        while failures[0] <= now - self.window_seconds:
+            failures.popleft()
+        return len(failures)
+
+    async def fire(self, alert: Alert) -> bool:
+        """Send an alert to every sink, unless it was sent within the cooldown."""
+        if not self.sinks:
+            return False
+        now = self._clock()
+        sent = self._sent.get(alert.key)
+        if sent is not None and now - sent < self.cooldown_seconds:
+            return False
+        self._sent[alert.key] = now
+        logger.warning(f"Alert {alert.name}: {alert.summary}")
+        timeout = aiohttp.ClientTimeout(total=SEND_TIMEOUT_SECONDS)
+        async with aiohttp.ClientSession(timeout=timeout) as session:
+            results = await asyncio.gather(
+                *(sink(session, alert) for sink in self.sinks), return_exceptions=True
+            )
+        for sink, result in zip(self.sinks, results):
+            if isinstance(result, BaseException):
+                logger.error(f"Failed to send alert {alert.name} with {sink.__name__}: {result}")
+        return True
+
+    async def reconcile_failed(self, handler: str, error: BaseException) -> None:
+        """Record a reconcile that failed with an unexpected error."""
+        count = self._count("ReconcileErrors")
+        if count > self.reconcile_errors:
+            await self.fire(
+                Alert(
+                    name="ReconcileErrors",
+                    key="devserver-operator/ReconcileErrors",
+                    summary=(
+                        f"{count} reconciles failed in the last {self.window_seconds:g}s, "
+                        f"most recently {handler}: {error}"
+                    ),
+                    severity=SEVERITY_CRITICAL,
+                    details={"count": count, "handler": handler, "error": str(error)},
+                )
+            )
+
+    async def capacity_check_failed(self, flavor: str, shortage: str) -> None:
+        """Record a capacity preflight that found no room for a DevServer of a flavor."""
+        count = self._count(f"CapacityCheckFailures/{flavor}")
+        if count > self.capacity_failures:
+            await self.fire(
+                Alert(
+                    name="CapacityCheckFailures",
+                    key=f"devserver-operator/CapacityCheckFailures/{flavor}",
+                    summary=(
+                        f"DevServerFlavor '{flavor}' had no capacity {count} times in the "
+                        f"last {self.window_seconds:g}s: {shortage}"
+                    ),
+                    severity=SEVERITY_WARNING,
+                    details={"flavor": flavor, "count": count, "shortage": shortage},
+                )
+            )
+
+    async def flavor_missing(self, flavor: str, devservers: List[str]) -> None:
+        """Report a deleted DevServerFlavor that DevServers were provisioned with."""
+        await self.fire(
+            Alert(
+                name="FlavorMissing",
+                key=f"devserver-operator/FlavorMissing/{flavor}",
+                summary=(
+                    f"DevServerFlavor '{flavor}' no longer exists, but "
+                    f"{len(devservers)} DevServer(s) use it."
+                ),
+                severity=SEVERITY_ERROR,
+                details={"flavor": flavor, "devservers": devservers[:20]},
+            )
+        )
+
+
+ALERTER = Alerter(configured_sinks())
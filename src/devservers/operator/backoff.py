@@ -0,0 +1,79 @@
+"""
+Retry backoff and rate limiting of reconciles.
+
+Like the rate limiter of a client-go workqueue, a reconcile that fails with an
+unexpected error, e.g. because the API server throttles the operator, is
+retried after an exponentially growing delay: `DEVSERVER_RETRY_BASE_DELAY`
+seconds, doubled on every retry up to `DEVSERVER_RETRY_MAX_DELAY`. Reconciles,
+retries included, can also be limited to `DEVSERVER_RECONCILE_QPS` per second
+overall, so that many resources failing at once, e.g. after a flavor they
+use is deleted, do not turn into a storm of retries.
+
+Handlers' own kopf.TemporaryErrors keep their delays, and kopf.PermanentErrors
+are not retried. Unexpected errors are also counted towards the operator's
+`ReconcileErrors` alert.
+"""
+import asyncio
+import functools
+from typing import Any, Awaitable, Callable, Optional, TypeVar
+
+import kopf
+from kubernetes import client
+
+from .alerting import ALERTER
+from .ratelimit import TokenBucket
+
+DEFAULT_RETRY_BASE_DELAY = 5.0
+DEFAULT_RETRY_MAX_DELAY = 300.0
+
+F = TypeVar("F", bound=Callable[..., Awaitable[Any]])
+
+_base_delay = DEFAULT_RETRY_BASE_DELAY
+_max_delay = DEFAULT_RETRY_MAX_DELAY
+_bucket: Optional[TokenBucket] = None
+
+
+def configure_backoff(base_delay: float, max_delay: float, qps: float = 0, burst: int = 1) -> None:
+    """Set the retry delays, and limit reconciles to `qps` per second unless it is 0."""
+    global _base_delay, _max_delay, _bucket
+    _base_delay = base_delay
+    _max_delay = max(max_delay, base_delay)
+    _bucket = TokenBucket(qps, max(burst, 1)) if qps > 0 else None
+
+
+def retry_delay(retry: int, error: Optional[BaseException] = None) -> float:
+    """
+    Seconds to wait before the given retry (0 for the first) of a failed
+    reconcile. A Retry-After of a throttled API request is honoured.
+    """
+    delay = min(_base_delay * 2**retry, _max_delay)
+    if isinstance(error, client.ApiException) and error.status == 429:
+        retry_after = (error.headers or {}).get("Retry-After", "")
+        if retry_after.isdigit():
+            delay = max(delay, float(retry_after))
+    return delay
+
+
+def with_backoff(fn: F) -> F:
+    """
+    Rate limit an async kopf handler and retry its unexpected errors with
+    exponential backoff. `functools.wraps` keeps the handler id kopf derives
+    from the function unchanged.
+    """
+
+    @functools.wraps(fn)
+    async def wrapper(*args: Any, retry: int = 0, **kwargs: Any) -> Any:
+        if _bucket is not None:
+            await asyncio.sleep(_bucket.reserve())
+        try:
+            return await fn(*args, retry=retry, **kwargs)
+        except (kopf.PermanentError, kopf.TemporaryError):
+            raise
+        except Exception as e:
+            delay = retry_delay(retry, e)
+            if "logger" in kwargs:
+                kwargs["logger"].exception(f"Reconcile failed, retrying in {delay:g}s.")
+            await ALERTER.reconcile_failed(fn.__name__, e)
+            raise kopf.TemporaryError(str(e), delay=delay) from e
+
+    return wrapper  # type: ignore[return-value]
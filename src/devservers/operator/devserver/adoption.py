@@ -0,0 +1,49 @@
+"""
+Adoption of child objects that exist without their DevServer as owner.
+
+Children left behind by a reinstall of the operator, or created by hand,
+have the names the operator would give them. Rather than failing to create
+them or leaving them unmanaged, the operator adopts them: it adds the
+DevServer's owner reference and reconciles them like its other children.
+Objects controlled by anything else are never taken over.
+"""
+from typing import Any, Mapping, Optional
+
+import kopf
+
+# How long to wait before checking again whether a conflicting child was released
+ADOPTION_CONFLICT_RETRY_INTERVAL = 60
+
+
+def controller_reference(obj: Mapping[str, Any]) -> Optional[Mapping[str, Any]]:
+    """The owner reference of the object's controller, if it has one."""
+    for reference in obj.get("metadata", {}).get("ownerReferences") or []:
+        if reference.get("controller"):
+            return reference
+    return None
+
+
+def needs_adoption(existing: Optional[Mapping[str, Any]], body: Mapping[str, Any]) -> bool:
+    """
+    Whether the live object exists without the controller the body gives it.
+
+    Args:
+        existing: The live object in its serialized form, or None
+        body: The object as the operator would apply it
+
+    Raises:
+        kopf.TemporaryError: If the object is controlled by something else.
+    """
+    owner = controller_reference(body)
+    if existing is None or owner is None:
+        return False
+    controller = controller_reference(existing)
+    if controller is None:
+        return True
+    if controller.get("uid") == owner["uid"]:
+        return False
+    raise kopf.TemporaryError(
+        f"{body['kind']} '{body['metadata']['name']}' already exists and is controlled "
+        f"by {controller['kind']} '{controller['name']}'.",
+        delay=ADOPTION_CONFLICT_RETRY_INTERVAL,
+    )
@@ -0,0 +1,193 @@
+"""
+The operator's side of the in-pod devserver-agent.
+
+When `DEVSERVER_AGENT_ENABLED` is true, every DevServer's pod runs the agent,
+which reports the SSH sessions, terminals and GPU utilization it sees into
+the DevServer's `<name>-agent` ConfigMap, and requests self-service actions,
+such as `devserver extend 12h`, through it. A timer folds the reports into
+`status.activity` and carries out the requests with the same checks as
+`devctl extend`, answering in the ConfigMap's `extendResult`. DevServers
+with `spec.lifecycle.idleTimeout` are hibernated once the agent has reported
+no activity for that long.
+"""
+import asyncio
+import logging
+import os
+from datetime import datetime, timezone
+from typing import Any, Dict, Mapping, Optional, Tuple
+
+from kubernetes import client
+
+from .lifecycle import get_expiration_time
+from .resources.agent import agent_name
+from .status import CONDITION_READY, PHASE_RUNNING
+from ...crds.base import ObjectMeta
+from ...crds.const import CRD_GROUP, CRD_PLURAL_DEVSERVER, CRD_VERSION
+from ...crds.devserver import DevServer
+from ...utils.time import parse_duration
+
+AGENT_ENABLED = os.environ.get("DEVSERVER_AGENT_ENABLED", "false").lower() == "true"
+# How often the agents' reports and requests are picked up
+AGENT_SYNC_INTERVAL = int(os.environ.get("DEVSERVER_AGENT_SYNC_INTERVAL", 30))
+
+
+def _int(value: Optional[str]) -> Optional[int]:
+    try:
+        return int(value) if value else None
+    except ValueError:
+        return None
+
+
+def _timestamp(epoch: int) -> str:
+    return datetime.fromtimestamp(epoch, timezone.utc).strftime("%Y-%m-%dT%H:%M:%SZ")
+
+
+def activity_status(
+    report: Mapping[str, str], previous: Optional[Mapping[str, Any]] = None
+) -> Optional[Dict[str, Any]]:
+    """
+    The `status.activity` of an agent's report, or None before its first
+    one. `lastActivity` never moves backwards, e.g. when the terminals it was
+    last seen on are closed.
+    """
+    if not report.get("reportedAt"):
+        return None
+    previous = previous or {}
+    activity: Dict[str, Any] = {
+        "sshSessions": _int(report.get("sshSessions")) or 0,
+        "ttys": _int(report.get("ttys")) or 0,
+        "gpuUtilization": _int(report.get("gpuUtilization")),
+        "lastActivity": previous.get("lastActivity"),
+    }
+    last_activity = _int(report.get("lastActivity"))
+    if last_activity:
+        reported = _timestamp(last_activity)
+        # The timestamps sort like the times they stand for
+        if not activity["lastActivity"] or reported > activity["lastActivity"]:
+            activity["lastActivity"] = reported
+    return activity
+
+
+def pending_extend_request(report: Mapping[str, str]) -> Optional[Tuple[str, str]]:
+    """The ID and duration of an extension the agent requested and was not answered yet."""
+    request_id, _, duration = report.get("extendRequest", "").partition(" ")
+    if not request_id or report.get("extendResult", "").startswith(f"{request_id} "):
+        return None
+    return request_id, duration
+
+
+def idle_timeout_elapsed(
+    spec: Mapping[str, Any], meta: Mapping[str, Any], status: Mapping[str, Any], now: datetime
+) -> bool:
+    """
+    Whether a running DevServer has been idle for its `spec.lifecycle.idleTimeout`.
+    Idle time only counts since it last became ready, so that a resumed
+    DevServer is not hibernated again right away.
+    """
+    idle_timeout = spec.get("lifecycle", {}).get("idleTimeout")
+    if not idle_timeout or status.get("phase") != PHASE_RUNNING:
+        return False
+    since = [meta["creationTimestamp"]]
+    for condition in status.get("conditions") or []:
+        if condition.get("type") == CONDITION_READY and condition.get("lastTransitionTime"):
+            since.append(condition["lastTransitionTime"])
+    if (status.get("activity") or {}).get("lastActivity"):
+        since.append(status["activity"]["lastActivity"])
+    last_activity = max(datetime.fromisoformat(time.replace("Z", "+00:00")) for time in since)
+    return now - last_activity >= parse_duration(idle_timeout)
+
+
+def extend_time_to_live(
+    name: str,
+    spec: Mapping[str, Any],
+    meta: Mapping[str, Any],
+    duration: str,
+    status: Optional[Mapping[str, Any]] = None,
+) -> Tuple[str, str]:
+    """
+    The extended `spec.lifecycle.timeToLive` of a DevServer, and the message
+    telling the agent's user when it now expires.
+
+    Raises:
+        ValueError: If the duration is invalid, the DevServer has no TTL or
+            extensions left, or the extended TTL would exceed MAX_TIME_TO_LIVE.
+    """
+    extension = parse_duration(duration)
+    if not extension:
+        raise ValueError(f"'{duration}' is not a positive duration, e.g. '12h'.")
+    devserver = DevServer(
+        metadata=ObjectMeta.from_dict({**meta, "name": name}),
+        spec=dict(spec),
+        status=dict(status or {}),
+    )
+    new_ttl = devserver.extended_time_to_live(extension)
+    lifecycle = {**spec.get("lifecycle", {}), "timeToLive": new_ttl}
+    expires_at = get_expiration_time(
+        {"spec": {**spec, "lifecycle": lifecycle}, "metadata": dict(meta)}
+    )
+    assert expires_at is not None
+    return new_ttl, (
+        f"DevServer '{name}' extended by {duration}, "
+        f"it now expires at {expires_at.strftime('%Y-%m-%dT%H:%M:%SZ')}."
+    )
+
+
+async def sync_agent(
+    name: str,
+    namespace: str,
+    spec: Mapping[str, Any],
+    meta: Mapping[str, Any],
+    status: Mapping[str, Any],
+    logger: logging.Logger,
+) -> Optional[Dict[str, Any]]:
+    """
+    Pick up the agent's report and requests of a DevServer. Extensions are
+    applied before they are answered, so the agent's user is only told about
+    those that took effect.
+
+    Returns:
+        The changed `status.activity`, if any.
+    """
+    core_v1 = client.CoreV1Api()
+    try:
+        configmap = await asyncio.to_thread(
+            core_v1.read_namespaced_config_map, name=agent_name(name), namespace=namespace
+        )
+    except client.ApiException as e:
+        if e.status != 404:
+            raise
+        return None
+    report = configmap.data or {}
+
+    activity = activity_status(report, status.get("activity"))
+    if activity == status.get("activity"):
+        activity = None
+
+    request = pending_extend_request(report)
+    if request is not None:
+        request_id, duration = request
+        try:
+            new_ttl, message = extend_time_to_live(name, spec, meta, duration, status)
+            await asyncio.to_thread(
+                client.CustomObjectsApi().patch_namespaced_custom_object,
+                group=CRD_GROUP,
+                version=CRD_VERSION,
+                plural=CRD_PLURAL_DEVSERVER,
+                namespace=namespace,
+                name=name,
+                body={"spec": {"lifecycle": {"timeToLive": new_ttl}}},
+            )
+            result = f"{request_id} ok {message}"
+            logger.info(f"Agent of DevServer '{name}' extended its TTL to {new_ttl}.")
+        except ValueError as e:
+            result = f"{request_id} error {e}"
+            logger.warning(f"Agent of DevServer '{name}' could not extend it: {e}")
+        # The agent reads the answer without a JSON parser
+        result = result.replace('"', "'")
+        await asyncio.to_thread(
+            core_v1.patch_namespaced_config_map,
+            name=agent_name(name),
+            namespace=namespace,
+            body={"data": {"extendResult": result}},
+        )
+    return activity
@@ -0,0 +1,103 @@
+"""
+Server-side apply of the objects the operator manages.
+
+Objects are applied under a dedicated field manager, so that the API server
+tracks which fields the operator owns. Fields set by others, e.g. replicas
+scaled by an HPA or containers added by a sidecar injector, are left alone
+rather than overwritten on every reconcile.
+
+Objects that are already as the operator would apply them are not applied
+again, so that reconciles without changes do not bump their resourceVersion
+and re-trigger watches.
+"""
+import asyncio
+import hashlib
+import json
+from typing import Any, Callable, Dict, Mapping, Optional
+
+from kubernetes.utils import parse_quantity
+
+from ...crds.const import CRD_GROUP
+
+FIELD_MANAGER = "devserver-operator"
+APPLY_PATCH_CONTENT_TYPE = "application/apply-patch+yaml"
+# Hash of the object as last applied, which reveals fields the operator stopped setting
+APPLIED_HASH_ANNOTATION = f"{CRD_GROUP}/applied-hash"
+
+
+async def server_side_apply(
+    patch: Callable[..., Any], body: Mapping[str, Any], **kwargs: Any
+) -> Any:
+    """
+    Create or update an object with one of the client's patch calls, e.g.
+    `CoreV1Api.patch_namespaced_service`. The body must be a complete object
+    with its apiVersion and kind; fields the operator applied before and left
+    out of it are removed.
+    """
+    return await asyncio.to_thread(
+        patch,
+        name=body["metadata"]["name"],
+        body=body,
+        field_manager=FIELD_MANAGER,
+        # Takes over fields the operator set with plain patches before
+        force=True,
+        _content_type=APPLY_PATCH_CONTENT_TYPE,
+        **kwargs,
+    )
+
+
+def with_applied_hash(body: Mapping[str, Any]) -> Dict[str, Any]:
+    """Annotate the object to apply with a hash of itself."""
+    digest = hashlib.sha256(json.dumps(body, sort_keys=True).encode()).hexdigest()
+    metadata = {**body["metadata"]}
+    metadata["annotations"] = {**metadata.get("annotations", {}), APPLIED_HASH_ANNOTATION: digest}
+    return {**body, "metadata": metadata}
+
+
+def _same_value(actual: Any, desired: Any) -> bool:
+    if actual == desired:
+        return True
+    # The API server normalizes quantities, e.g. "1000m" CPU to "1"
+    if isinstance(actual, (str, int)) and isinstance(desired, (str, int)):
+        try:
+            return parse_quantity(actual) == parse_quantity(desired)
+        except ValueError:
+            return False
+    return False
+
+
+def semantically_contains(actual: Any, desired: Any) -> bool:
+    """
+    Whether the live object has every field of the desired one, with an equal
+    value. Fields only on the live object, e.g. ones defaulted by the API
+    server or set by other controllers, are ignored.
+    """
+    if isinstance(desired, Mapping):
+        return isinstance(actual, Mapping) and all(
+            # The API server drops unset and empty fields
+            not actual.get(key) if value in (None, {}, [])
+            else key in actual and semantically_contains(actual[key], value)
+            for key, value in desired.items()
+        )
+    if isinstance(desired, list):
+        return (
+            isinstance(actual, list)
+            and len(actual) == len(desired)
+            and all(semantically_contains(a, d) for a, d in zip(actual, desired))
+        )
+    return _same_value(actual, desired)
+
+
+def is_up_to_date(existing: Optional[Mapping[str, Any]], body: Mapping[str, Any]) -> bool:
+    """
+    Whether the live object needs no apply of the body, which must carry its
+    applied hash: it was last applied from the same body and nobody has
+    changed the fields the operator sets since.
+    """
+    if existing is None:
+        return False
+    applied_hash = body["metadata"]["annotations"][APPLIED_HASH_ANNOTATION]
+    annotations = existing.get("metadata", {}).get("annotations") or {}
+    if annotations.get(APPLIED_HASH_ANNOTATION) != applied_hash:
+        return False
+    return semantically_contains(existing, body)
@@ -0,0 +1,132 @@
+"""
+Per-namespace SSH bastion, kept in sync with the namespace's DevServers.
+
+When `DEVSERVER_BASTION_ENABLED` is true, every namespace with DevServers gets
+a `devserver-bastion` Deployment and Service. Its authorized_keys, and the
+ssh_config/known_hosts published for users, are regenerated whenever a
+DevServer in the namespace is reconciled or deleted. The bastion is removed
+once the last DevServer of the namespace is gone; its host keys are kept so it
+keeps its identity if it comes back.
+"""
+import asyncio
+import logging
+import os
+from typing import Any, Dict, List, Optional
+
+from kubernetes import client
+
+from .apply import server_side_apply
+from .host_keys import ensure_host_keys_secret, host_keys_secret_name, public_host_keys
+from .resources.bastion import (
+    BASTION_NAME,
+    build_bastion_configmap,
+    build_bastion_deployment,
+    build_bastion_script_configmap,
+    build_bastion_service,
+)
+from .status import compute_ssh_endpoint
+from ..tracing import span
+from ...crds.const import CRD_GROUP, CRD_PLURAL_DEVSERVER, CRD_VERSION
+
+BASTION_ENABLED = os.environ.get("DEVSERVER_BASTION_ENABLED", "false").lower() == "true"
+BASTION_SERVICE_TYPE = os.environ.get("DEVSERVER_BASTION_SERVICE_TYPE", "LoadBalancer")
+
+
+async def _list_live_devservers(namespace: str) -> List[Dict[str, Any]]:
+    custom_objects_api = client.CustomObjectsApi()
+    devservers = await asyncio.to_thread(
+        custom_objects_api.list_namespaced_custom_object,
+        group=CRD_GROUP,
+        version=CRD_VERSION,
+        plural=CRD_PLURAL_DEVSERVER,
+        namespace=namespace,
+    )
+    return [ds for ds in devservers["items"] if not ds["metadata"].get("deletionTimestamp")]
+
+
+async def _with_host_keys(
+    core_v1: client.CoreV1Api, devserver: Dict[str, Any]
+) -> Dict[str, Any]:
+    """
+    Fill in `status.sshHostKeys` from the DevServer's host key Secret.
+
+    The Secret is authoritative: the status of a DevServer that is being
+    created does not carry its host keys yet.
+    """
+    try:
+        secret = await asyncio.to_thread(
+            core_v1.read_namespaced_secret,
+            name=host_keys_secret_name(devserver["metadata"]["name"]),
+            namespace=devserver["metadata"]["namespace"],
+        )
+    except client.ApiException as e:
+        if e.status != 404:
+            raise
+        return devserver
+    status = dict(devserver.get("status") or {})
+    status["sshHostKeys"] = public_host_keys(secret.data or {})
+    return {**devserver, "status": status}
+
+
+async def _delete(delete, name: str, namespace: str) -> None:
+    try:
+        await asyncio.to_thread(delete, name=name, namespace=namespace)
+    except client.ApiException as e:
+        if e.status != 404:
+            raise
+
+
+async def _bastion_endpoint(core_v1: client.CoreV1Api, namespace: str) -> Optional[str]:
+    try:
+        service = await asyncio.to_thread(
+            core_v1.read_namespaced_service, name=BASTION_NAME, namespace=namespace
+        )
+    except client.ApiException as e:
+        if e.status != 404:
+            raise
+        return None
+    endpoint = compute_ssh_endpoint(service, None)
+    # A ClusterIP endpoint is not reachable by users, so there is nothing to publish
+    return endpoint if service.spec.type == "LoadBalancer" else None
+
+
+async def reconcile_bastion(namespace: str, logger: logging.Logger) -> None:
+    """Bring the namespace's bastion in line with its live DevServers."""
+    core_v1 = client.CoreV1Api()
+    apps_v1 = client.AppsV1Api()
+
+    devservers = await _list_live_devservers(namespace)
+    if not devservers:
+        with span("delete bastion"):
+            await _delete(apps_v1.delete_namespaced_deployment, BASTION_NAME, namespace)
+            await _delete(core_v1.delete_namespaced_service, BASTION_NAME, namespace)
+            await _delete(core_v1.delete_namespaced_config_map, BASTION_NAME, namespace)
+            await _delete(
+                core_v1.delete_namespaced_config_map, f"{BASTION_NAME}-script", namespace
+            )
+        logger.info(f"Removed SSH bastion from namespace '{namespace}'.")
+        return
+
+    with span("reconcile bastion"):
+        devservers = [await _with_host_keys(core_v1, ds) for ds in devservers]
+        bastion_host_keys = await ensure_host_keys_secret(BASTION_NAME, namespace, None, logger)
+
+        script_path = os.path.join(os.path.dirname(__file__), "resources", "bastion.sh")
+        with open(script_path, "r") as f:
+            script_content = f.read()
+
+        service = build_bastion_service(namespace, BASTION_SERVICE_TYPE)
+        await server_side_apply(core_v1.patch_namespaced_service, service, namespace=namespace)
+        configmap = build_bastion_configmap(
+            namespace,
+            devservers,
+            await _bastion_endpoint(core_v1, namespace),
+            bastion_host_keys,
+        )
+        for body in (configmap, build_bastion_script_configmap(namespace, script_content)):
+            await server_side_apply(core_v1.patch_namespaced_config_map, body, namespace=namespace)
+        deployment = build_bastion_deployment(namespace)
+        await server_side_apply(apps_v1.patch_namespaced_deployment, deployment, namespace=namespace)
+    logger.info(
+        f"SSH bastion in namespace '{namespace}' synced with {len(devservers)} DevServer(s)."
+    )
@@ -0,0 +1,139 @@
+"""
+Capacity preflight for new DevServers.
+
+Before a DevServer's StatefulSet is first created, the operator checks that a
+node matching its flavor has room for it, or that a Karpenter NodePool can add
+one. Otherwise the DevServer waits in the `WaitingForCapacity` phase and is
+retried, instead of leaving users with a pending pod. Once it has waited for
+`spec.flavorFallbackTimeout`, the first of its `spec.flavorFallbacks` with
+room is used instead.
+"""
+import asyncio
+import logging
+import os
+from datetime import datetime, timedelta, timezone
+from typing import Any, Dict, Mapping, Optional, Tuple
+
+import kopf
+from kubernetes import client
+
+from devservers.utils.flavors import get_flavor
+from devservers.utils.time import format_duration, parse_duration
+from .kueue import get_queue_name
+from .validation import (
+    validate_allowed_images,
+    validate_host_access,
+    validate_priority_class,
+    validate_team_node_pool,
+)
+from ..devserverflavor.reconciler import DevServerFlavorReconciler
+
+CAPACITY_PREFLIGHT_ENABLED = (
+    os.environ.get("DEVSERVER_CAPACITY_PREFLIGHT_ENABLED", "true").lower() == "true"
+)
+# How long a DevServer waiting for capacity waits before it is checked again
+CAPACITY_RETRY_INTERVAL = int(os.environ.get("DEVSERVER_CAPACITY_RETRY_INTERVAL", 60))
+
+DEFAULT_FLAVOR_FALLBACK_TIMEOUT = "10m"
+
+
+async def _statefulset_exists(name: str, namespace: str) -> bool:
+    try:
+        await asyncio.to_thread(
+            client.AppsV1Api().read_namespaced_stateful_set, name=name, namespace=namespace
+        )
+    except client.ApiException as e:
+        if e.status == 404:
+            return False
+        raise
+    return True
+
+
+async def find_capacity_shortage(
+    name: str, namespace: str, flavor: Dict[str, Any], logger: logging.Logger
+) -> Optional[str]:
+    """
+    Check whether a new DevServer of the flavor can be scheduled.
+
+    DevServers that already have a StatefulSet are never held back, so that
+    running and resuming DevServers keep their place, and neither are those
+    queued by Kueue, which admits them once there is room.
+
+    Returns:
+        Why the DevServer has to wait, or None if it can be created.
+    """
+    if not CAPACITY_PREFLIGHT_ENABLED or get_queue_name(flavor):
+        return None
+    if await _statefulset_exists(name, namespace):
+        return None
+    reconciler = DevServerFlavorReconciler(logger)
+    schedulability = await asyncio.to_thread(reconciler.get_flavor_schedulability, flavor)
+    if schedulability != "No":
+        return None
+    return (
+        f"No node matching flavor '{flavor['metadata']['name']}' has room for the DevServer "
+        f"and no autoscaler can add one; checking again in {CAPACITY_RETRY_INTERVAL}s."
+    )
+
+
+async def _usable_fallback(
+    name: str, namespace: str, spec: Mapping[str, Any], flavor_name: str, logger: logging.Logger
+) -> Optional[Dict[str, Any]]:
+    try:
+        flavor = await get_flavor(flavor_name)
+    except (client.ApiException, ValueError) as e:
+        logger.warning(f"Skipping fallback flavor '{flavor_name}': {e}")
+        return None
+    if flavor["spec"].get("deprecated", False):
+        logger.info(f"Skipping fallback flavor '{flavor_name}', which is deprecated.")
+        return None
+    # Report policy violations against the fallback, not the primary flavor
+    fallback_spec = {**spec, "flavor": flavor_name}
+    try:
+        validate_host_access(fallback_spec, flavor, logger)
+        validate_priority_class(fallback_spec, flavor, logger)
+        validate_team_node_pool(namespace, fallback_spec, flavor, logger)
+        validate_allowed_images(fallback_spec, flavor, logger)
+    except kopf.PermanentError:
+        return None
+    if await find_capacity_shortage(name, namespace, flavor, logger):
+        return None
+    return flavor
+
+
+async def choose_flavor(
+    name: str,
+    namespace: str,
+    spec: Mapping[str, Any],
+    meta: Mapping[str, Any],
+    flavor: Dict[str, Any],
+    logger: logging.Logger,
+    now: Optional[datetime] = None,
+) -> Tuple[Dict[str, Any], Optional[str]]:
+    """
+    Choose the flavor to provision a DevServer with, falling back to the
+    flavors in `spec.flavorFallbacks`, in order, once the DevServer has waited
+    for `spec.flavorFallbackTimeout` for its own flavor to have capacity.
+
+    Returns:
+        The flavor to use, and why the DevServer has to wait if none has room.
+    """
+    shortage = await find_capacity_shortage(name, namespace, flavor, logger)
+    fallbacks = spec.get("flavorFallbacks", [])
+    if shortage is None or not fallbacks:
+        return flavor, shortage
+
+    timeout = parse_duration(spec.get("flavorFallbackTimeout", DEFAULT_FLAVOR_FALLBACK_TIMEOUT))
+    created = datetime.fromisoformat(meta["creationTimestamp"])
+    remaining = created + timeout - (now or datetime.now(timezone.utc))
+    if remaining > timedelta(0):
+        return flavor, f"{shortage} Falling back to another flavor in {format_duration(remaining)}."
+
+    for fallback_name in fallbacks:
+        if fallback_name == flavor["metadata"]["name"]:
+            continue
+        fallback = await _usable_fallback(name, namespace, spec, fallback_name, logger)
+        if fallback is not None:
+            logger.info(f"Falling back from flavor '{flavor['metadata']['name']}' to '{fallback_name}'.")
+            return fallback, None
+    return flavor, f"{shortage} None of the fallback flavors has room either."
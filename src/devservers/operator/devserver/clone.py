@@ -0,0 +1,107 @@
+"""
+Cloning another DevServer with `spec.cloneFrom`.
+
+When a DevServer with `spec.cloneFrom` is created, the fields of the source
+DevServer's spec that the clone does not set itself are copied onto it, once,
+so that later changes to the source do not leak into the clone, and its
+`status.clonedFrom` records that they were. Its home volume is cloned like
+with `spec.homeSource.fromDevServer`, which the clone keeps deriving from
+`cloneFrom`, as homeSource cannot be added after creation.
+"""
+import asyncio
+import logging
+from typing import Any, Dict, Mapping
+
+import kopf
+from kubernetes import client
+
+from ..events import EventRecorder
+from .owner_rbac import OWNER_RBAC_ENABLED
+from ...crds.const import CRD_GROUP, CRD_PLURAL_DEVSERVER, CRD_VERSION
+
+# Fields that belong to the DevServer itself, rather than to its environment
+CLONE_EXCLUDED_FIELDS = frozenset(
+    ["owner", "ssh", "lifecycle", "cloneFrom", "homeSource", "backup", "hibernated"]
+)
+# Lets other owners clone a DevServer when owner RBAC hides it from them
+ALLOW_CLONE_ANNOTATION = f"{CRD_GROUP}/allow-clone"
+
+
+def cloned_fields(spec: Mapping[str, Any], source_spec: Mapping[str, Any]) -> Dict[str, Any]:
+    """The fields of the source's spec to copy onto the clone, which wins on conflicts."""
+    return {
+        field: value
+        for field, value in source_spec.items()
+        if field not in CLONE_EXCLUDED_FIELDS and field not in spec
+    }
+
+
+def with_cloned_home_source(spec: Mapping[str, Any]) -> Mapping[str, Any]:
+    """The spec with the clone's home volume seeded from the source's, if it has one."""
+    clone_from = spec.get("cloneFrom")
+    if (
+        not clone_from
+        or "homeSource" in spec
+        or not spec.get("persistentHome", {}).get("enabled", False)
+    ):
+        return spec
+    return {**spec, "homeSource": {"fromDevServer": {"name": clone_from["name"]}}}
+
+
+async def resolve_clone(
+    name: str,
+    namespace: str,
+    spec: Mapping[str, Any],
+    logger: logging.Logger,
+    recorder: EventRecorder,
+    reference: Dict[str, Any],
+) -> Dict[str, Any]:
+    """
+    Read the DevServer of `spec.cloneFrom`, and return the fields to copy
+    from its spec.
+
+    Raises:
+        kopf.PermanentError: If the source does not exist, is the DevServer
+            itself, or, with owner RBAC, belongs to another owner who does
+            not allow cloning it.
+    """
+    source_name = spec["cloneFrom"]["name"]
+    if source_name == name:
+        raise kopf.PermanentError("A DevServer cannot be cloned from itself.")
+    try:
+        source = await asyncio.to_thread(
+            client.CustomObjectsApi().get_namespaced_custom_object,
+            group=CRD_GROUP,
+            version=CRD_VERSION,
+            plural=CRD_PLURAL_DEVSERVER,
+            namespace=namespace,
+            name=source_name,
+        )
+    except client.ApiException as e:
+        if e.status == 404:
+            message = f"DevServer '{source_name}' to clone from not found."
+            logger.error(message)
+            await recorder.warning(reference, "CloneSourceNotFound", message)
+            raise kopf.PermanentError(message)
+        raise
+
+    source_spec = source.get("spec", {})
+    annotations = source.get("metadata", {}).get("annotations") or {}
+    if (
+        OWNER_RBAC_ENABLED
+        and source_spec.get("owner") != spec.get("owner")
+        and annotations.get(ALLOW_CLONE_ANNOTATION) != "true"
+    ):
+        message = (
+            f"DevServer '{source_name}' belongs to another owner, who has not allowed "
+            f"cloning it with the {ALLOW_CLONE_ANNOTATION} annotation."
+        )
+        logger.error(message)
+        await recorder.warning(reference, "CloneNotAllowed", message)
+        raise kopf.PermanentError(message)
+
+    fields = cloned_fields(spec, source_spec)
+    logger.info(
+        f"Cloning DevServer '{source_name}', copying {', '.join(sorted(fields)) or 'nothing'}."
+    )
+    return fields
@@ -0,0 +1,49 @@
+"""
+Collaborators sharing a DevServer with its owner.
+
+`spec.collaborators` lists people, identified like `spec.owner`, who may
+connect to the DevServer without anyone sharing a private key:
+
+- Their conventional `<collaborator>-ssh-keys` Secrets are mounted next to
+  the owner's, and their keys are authorized at startup.
+- With owner RBAC, their per-owner Role also lets them get and connect to it,
+  but not update or delete it.
+- Adding or removing them records an event on the DevServer, and the login
+  banner lists them.
+"""
+from typing import Any, List, Mapping, Optional, Tuple
+
+
+def get_collaborators(spec: Mapping[str, Any]) -> List[str]:
+    """The DevServer's collaborators, in the order they were given."""
+    return list(spec.get("collaborators") or [])
+
+
+def collaborator_changes(
+    old_spec: Optional[Mapping[str, Any]], spec: Mapping[str, Any]
+) -> Tuple[List[str], List[str]]:
+    """The collaborators added and removed since the old spec."""
+    old = get_collaborators(old_spec or {})
+    new = get_collaborators(spec)
+    return [c for c in new if c not in old], [c for c in old if c not in new]
+
+
+def validate_user_collaborators(spec: Mapping[str, Any]) -> None:
+    """
+    Check the DevServer's collaborators.
+
+    Raises:
+        ValueError: If one is empty, listed twice, or is the owner, all
+            compared case-insensitively, as owners are.
+    """
+    owner = (spec.get("owner") or "").lower()
+    seen = set()
+    for collaborator in get_collaborators(spec):
+        key = collaborator.strip().lower()
+        if not key:
+            raise ValueError("collaborators cannot be empty.")
+        if key == owner:
+            raise ValueError(f"'{collaborator}' owns the DevServer and cannot collaborate on it.")
+        if key in seen:
+            raise ValueError(f"'{collaborator}' is listed more than once.")
+        seen.add(key)
@@ -0,0 +1,192 @@
+"""
+Cost estimation for DevServers.
+
+Flavors may carry an hourly cost, either as `spec.costPerHour` or via the
+`devserver.io/hourly-cost` annotation. From it the operator derives, for each
+DevServer, the cost accumulated so far and the cost projected over its full
+TTL. Both are written into `status.cost` and exported as metrics so that
+chargeback does not require any external tooling.
+"""
+import asyncio
+import logging
+from datetime import datetime, timezone
+from typing import Any, Dict, Mapping, Optional
+
+from kubernetes import client
+
+from .lifecycle import get_expiration_time
+from ..metrics import gauge
+from ...crds.const import (
+    CRD_GROUP,
+    CRD_VERSION,
+    CRD_PLURAL_DEVSERVER,
+    CRD_PLURAL_DEVSERVERFLAVOR,
+)
+from ...utils.flavors import (
+    HOURLY_COST_ANNOTATION,
+    current_flavor_name,
+    get_hourly_cost,
+    resolve_flavor,
+)
+
+_COST_LABELS = ("namespace", "name", "owner", "flavor")
+
+COST_HOURLY_RATE = gauge(
+    "devserver_cost_hourly_rate",
+    "Hourly cost of a DevServer, taken from its flavor.",
+    _COST_LABELS,
+)
+COST_ACCUMULATED = gauge(
+    "devserver_cost_accumulated",
+    "Cost accumulated by a DevServer since it was created.",
+    _COST_LABELS,
+)
+COST_PROJECTED = gauge(
+    "devserver_cost_projected",
+    "Cost a DevServer will have accumulated when its TTL elapses.",
+    _COST_LABELS,
+)
+
+
+def _format_cost(value: float) -> str:
+    return f"{value:.2f}"
+
+
+def compute_cost_status(
+    devserver: Mapping[str, Any], hourly_cost: float, now: Optional[datetime] = None
+) -> Dict[str, Any]:
+    """
+    Compute the `status.cost` block of a DevServer.
+
+    Args:
+        devserver: The DevServer object
+        hourly_cost: Hourly cost of the DevServer's flavor
+        now: Current time, for testing
+
+    Returns:
+        A dictionary with `hourlyRate`, `accumulated` and, when the DevServer
+        has a TTL, `projected` cost, each formatted with two decimals.
+    """
+    now = now or datetime.now(timezone.utc)
+    created = datetime.fromisoformat(devserver["metadata"]["creationTimestamp"])
+    elapsed_hours = max((now - created).total_seconds(), 0) / 3600
+
+    cost = {
+        "hourlyRate": _format_cost(hourly_cost),
+        "accumulated": _format_cost(hourly_cost * elapsed_hours),
+    }
+
+    expiration_time = get_expiration_time(devserver)
+    if expiration_time is not None:
+        ttl_hours = (expiration_time - created).total_seconds() / 3600
+        cost["projected"] = _format_cost(hourly_cost * ttl_hours)
+    return cost
+
+
+def _metric_labels(devserver: Mapping[str, Any]) -> Dict[str, str]:
+    return {
+        "namespace": devserver["metadata"]["namespace"],
+        "name": devserver["metadata"]["name"],
+        "owner": devserver["spec"].get("owner", ""),
+        "flavor": devserver["spec"].get("flavor", ""),
+    }
+
+
+def forget_devserver_cost(devserver: Mapping[str, Any]) -> None:
+    """Drop the cost metrics of a deleted DevServer."""
+    labels = _metric_labels(devserver)
+    for metric in (COST_HOURLY_RATE, COST_ACCUMULATED, COST_PROJECTED):
+        metric.remove(**labels)
+
+
+async def update_devserver_costs(
+    custom_objects_api: client.CustomObjectsApi, logger: logging.Logger
+) -> None:
+    """
+    Recompute the cost of every DevServer in a single pass.
+
+    Flavors are listed once per pass; DevServers whose flavor has no cost are
+    skipped.
+    """
+    flavors = await asyncio.to_thread(
+        custom_objects_api.list_cluster_custom_object,
+        group=CRD_GROUP,
+        version=CRD_VERSION,
+        plural=CRD_PLURAL_DEVSERVERFLAVOR,
+    )
+    flavors_by_name = {flavor["metadata"]["name"]: flavor for flavor in flavors["items"]}
+    hourly_costs = {}
+    for flavor_name, flavor in flavors_by_name.items():
+        try:
+            flavor = resolve_flavor(flavor, flavors_by_name)
+        except ValueError:
+            # A broken base flavor chain only loses the inherited cost
+            pass
+        hourly_costs[flavor_name] = get_hourly_cost(flavor)
+
+    devservers = await asyncio.to_thread(
+        custom_objects_api.list_cluster_custom_object,
+        group=CRD_GROUP,
+        version=CRD_VERSION,
+        plural=CRD_PLURAL_DEVSERVER,
+    )
+    for ds in devservers["items"]:
+        if ds["metadata"].get("deletionTimestamp"):
+            continue
+        hourly_cost = hourly_costs.get(current_flavor_name(ds["spec"], ds.get("status", {})))
+        if hourly_cost is None:
+            continue
+
+        try:
+            cost = compute_cost_status(ds, hourly_cost)
+        except (KeyError, TypeError, ValueError) as e:
+            logger.error(f"Error computing cost for DevServer '{ds['metadata']['name']}': {e}")
+            continue
+
+        labels = _metric_labels(ds)
+        COST_HOURLY_RATE.set(hourly_cost, **labels)
+        COST_ACCUMULATED.set(float(cost["accumulated"]), **labels)
+        if "projected" in cost:
+            COST_PROJECTED.set(float(cost["projected"]), **labels)
+
+        if ds.get("status", {}).get("cost") == cost:
+            continue
+        try:
+            await asyncio.to_thread(
+                custom_objects_api.patch_namespaced_custom_object,
+                group=CRD_GROUP,
+                version=CRD_VERSION,
+                plural=CRD_PLURAL_DEVSERVER,
+                namespace=ds["metadata"]["namespace"],
+                name=ds["metadata"]["name"],
+                body={"status": {"cost": cost}},
+            )
+        except client.ApiException as e:
+            if e.status != 404:
+                raise
+
+
+async def track_devserver_costs_periodically(
+    custom_objects_api: client.CustomObjectsApi,
+    logger: logging.Logger,
+    interval_seconds: int = 300,
+) -> None:
+    """
+    Periodically recompute DevServer costs.
+
+    Args:
+        custom_objects_api: Kubernetes custom objects API client
+        logger: Logger instance
+        interval_seconds: How often to recompute costs (default: 300s)
+    """
+    while True:
+        try:
+            await update_devserver_costs(custom_objects_api, logger)
+        except client.ApiException as e:
+            logger.error(f"API error during cost tracking: {e}")
+        except Exception as e:
+            logger.error(
+                f"An unexpected error occurred during cost tracking: {e}",
+                exc_info=True,
+            )
+        await asyncio.sleep(interval_seconds)
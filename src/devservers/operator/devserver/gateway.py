@@ -0,0 +1,102 @@
+"""
+Operator-level configuration for exposing SSH through a shared Gateway.
+
+When `DEVSERVER_SSH_GATEWAY` names a Gateway (as `<namespace>/<name>`), every
+DevServer with `enableSSH` gets a Gateway API route to its SSH Service, so SSH
+is reachable without one LoadBalancer per DevServer.
+"""
+import os
+from dataclasses import dataclass
+from typing import Any, Mapping, Optional
+
+from .resources.gateway import (
+    ROUTE_KIND_TCP,
+    ROUTE_KIND_TLS,
+    ROUTE_PLURALS,
+    build_ssh_route,
+    ssh_route_hostname,
+)
+
+
+@dataclass(frozen=True)
+class SSHGatewayConfig:
+    gateway_name: str
+    gateway_namespace: str
+    route_kind: str = ROUTE_KIND_TCP
+    section_name: Optional[str] = None
+    domain: Optional[str] = None
+
+    @property
+    def route_plural(self) -> str:
+        return ROUTE_PLURALS[self.route_kind]
+
+    def build_route(self, name: str, namespace: str) -> dict:
+        return build_ssh_route(
+            name,
+            namespace,
+            self.route_kind,
+            self.gateway_name,
+            self.gateway_namespace,
+            section_name=self.section_name,
+            domain=self.domain,
+        )
+
+    def hostname(self, name: str, namespace: str) -> Optional[str]:
+        """The SNI hostname of a DevServer, for TLSRoutes only."""
+        if self.route_kind != ROUTE_KIND_TLS or not self.domain:
+            return None
+        return ssh_route_hostname(name, namespace, self.domain)
+
+
+def load_ssh_gateway_config(
+    environ: Mapping[str, str] = os.environ,
+) -> Optional[SSHGatewayConfig]:
+    """
+    Read the SSH gateway configuration from the environment.
+
+    Returns:
+        The configuration, or None if no gateway is configured.
+
+    Raises:
+        ValueError: If the configuration is invalid.
+    """
+    gateway = environ.get("DEVSERVER_SSH_GATEWAY")
+    if not gateway:
+        return None
+
+    gateway_namespace, _, gateway_name = gateway.rpartition("/")
+    if not gateway_namespace or not gateway_name:
+        raise ValueError(
+            f"DEVSERVER_SSH_GATEWAY must be '<namespace>/<name>', got '{gateway}'."
+        )
+
+    route_kind = environ.get("DEVSERVER_SSH_GATEWAY_ROUTE_KIND", ROUTE_KIND_TCP)
+    if route_kind not in ROUTE_PLURALS:
+        raise ValueError(
+            f"DEVSERVER_SSH_GATEWAY_ROUTE_KIND must be one of {sorted(ROUTE_PLURALS)}, "
+            f"got '{route_kind}'."
+        )
+
+    domain = environ.get("DEVSERVER_SSH_GATEWAY_DOMAIN") or None
+    if route_kind == ROUTE_KIND_TLS and not domain:
+        raise ValueError("DEVSERVER_SSH_GATEWAY_DOMAIN is required for TLSRoutes.")
+
+    return SSHGatewayConfig(
+        gateway_name=gateway_name,
+        gateway_namespace=gateway_namespace,
+        route_kind=route_kind,
+        section_name=environ.get("DEVSERVER_SSH_GATEWAY_LISTENER") or None,
+        domain=domain,
+    )
+
+
+SSH_GATEWAY = load_ssh_gateway_config()
+
+
+def ssh_gateway_hostname(devserver: Mapping[str, Any]) -> Optional[str]:
+    """The hostname a DevServer is reachable under through the SSH gateway, if any."""
+    if SSH_GATEWAY is None or not devserver["spec"].get("enableSSH", False):
+        return None
+    return SSH_GATEWAY.hostname(
+        devserver["metadata"]["name"], devserver["metadata"]["namespace"]
+    )
@@ -1,23 +1,126 @@
 import asyncio
 import logging
-from typing import Any, Dict
+import os
+import time
+from datetime import datetime, timezone
+from typing import Any, Dict, Optional
 
 import kopf
 from kubernetes import client
 
-from .validation import validate_and_normalize_ttl
+from .. import notifications
+from ..alerting import ALERTER
+from .agent import AGENT_ENABLED, AGENT_SYNC_INTERVAL, idle_timeout_elapsed, sync_agent
+from .backup import run_scheduled_backup
+from .bastion import BASTION_ENABLED, reconcile_bastion
+from .capacity import CAPACITY_RETRY_INTERVAL, choose_flavor
+from .clone import resolve_clone, with_cloned_home_source
+from .collaborators import collaborator_changes, get_collaborators
+from .cost import forget_devserver_cost
+from .validation import (
+    validate_affinity,
+    validate_allowed_images,
+    validate_and_normalize_ttl,
+    validate_backup,
+    validate_capacity_type,
+    validate_collaborators,
+    validate_containers,
+    validate_credential_bundles,
+    validate_dotfiles_repo,
+    validate_env,
+    validate_flavor_deprecation,
+    validate_home_encryption,
+    validate_home_mount_path,
+    validate_host_access,
+    validate_lifecycle_limits,
+    validate_mosh,
+    validate_name,
+    validate_pod_security,
+    validate_prewarm,
+    validate_priority_class,
+    validate_scratch,
+    validate_security_profiles,
+    validate_service_account,
+    validate_sshd_config_overrides,
+    validate_team_node_pool,
+    validate_template_lifecycle,
+    validate_uptime_schedule,
+    validate_volumes,
+)
+from .home_source import validate_home_source, prepare_home_source
+from .home_volume import expand_home_volume
+from .naming import NAMING_POLICY, validate_user_name
+from .owner_identity import (
+    OWNER_ADMIN_GROUPS,
+    OWNER_IDENTITY_ENABLED,
+    requester_owners,
+    resolve_owner,
+)
+from .owner_ids import resolve_owner_ids
+from .owner_rbac import OWNER_RBAC_ENABLED, reconcile_owner_rbac
+from .pod_security import RESTRICTED_POD_SECURITY
+from .predicates import devserver_changed
+from .resync import MIN_REQUEUE_AFTER, requeue_after
+from .spot import (
+    CAPACITY_TYPE_ON_DEMAND,
+    CAPACITY_TYPE_SPOT,
+    CAPACITY_TYPE_SPOT_WITH_FALLBACK,
+    current_capacity_type,
+    delete_reclaimed_pod,
+    find_spot_reclamation,
+)
+from .profile import get_profile, with_profile
+from .template import extension_limits, get_template, lifecycle_limits, template_fields
+from .uptime import get_calendar, run_uptime_schedule
 from .host_keys import ensure_host_keys_secret
+from .ide import ensure_ide_password_secret, ide_requested
 from .reconciler import reconcile_devserver
+from .resources.pod_security import RESTRICTED_SSH_PORT
+from .resources.statefulset import (
+    HOST_ACCESS_FIELDS,
+    validate_user_host_access,
+    validate_user_priority_class,
+)
+from .webhook import WEBHOOK_ENABLED
+from .teardown import cancel_backups, release_home_volume, release_ssh_service
+from .status import (
+    PHASE_FAILED,
+    PHASE_HIBERNATED,
+    PHASE_RUNNING,
+    PHASE_WAITING_FOR_CAPACITY,
+    observe_devserver_status,
+    set_condition_transition_times,
+    waiting_for_capacity_status,
+)
+from ..backoff import with_backoff
+from ..events import EventRecorder, object_reference
+from ..tracing import span, traced
+from ...utils.flavors import (
+    current_flavor_name,
+    get_default_flavor,
+    get_flavor,
+    resolve_base_flavors,
+)
 from ...crds.const import (
     CRD_GROUP,
     CRD_VERSION,
     CRD_PLURAL_DEVSERVER,
     CRD_PLURAL_DEVSERVERFLAVOR,
+    CRD_PLURAL_DEVSERVERUSER,
 )
 
+# How often the observed pod state is folded back into the DevServer status
+STATUS_CHECK_INTERVAL = int(os.environ.get("DEVSERVER_STATUS_CHECK_INTERVAL", 10))
+# How often backup schedules are checked; the finest schedule granularity is a minute
+BACKUP_CHECK_INTERVAL = int(os.environ.get("DEVSERVER_BACKUP_CHECK_INTERVAL", 60))
+# How often uptime schedules are checked
+UPTIME_CHECK_INTERVAL = int(os.environ.get("DEVSERVER_UPTIME_CHECK_INTERVAL", 60))
+
 
 @kopf.on.create(CRD_GROUP, CRD_VERSION, CRD_PLURAL_DEVSERVER)
-@kopf.on.update(CRD_GROUP, CRD_VERSION, CRD_PLURAL_DEVSERVER)
+@kopf.on.update(CRD_GROUP, CRD_VERSION, CRD_PLURAL_DEVSERVER, when=devserver_changed)
+@with_backoff
+@traced("reconcile DevServer")
 async def create_or_update_devserver(
     spec: Dict[str, Any],
     name: str,
@@ -25,39 +128,150 @@ async def create_or_update_devserver(
     logger: logging.Logger,
     patch: Dict[str, Any],
     meta: Dict[str, Any],
+    body: Dict[str, Any],
     **kwargs: Any,
 ) -> None:
     """
     Handle the creation or update of a DevServer resource.
 
     This handler orchestrates:
-    1. TTL validation and normalization
-    2. Flavor fetching
+    0. Copying the spec of the DevServer it is cloned from, and of the
+       template it is created from, if any, and resolving its profile
+    1. Name and spec validation, e.g. of the TTL, home source, volumes and env
+    2. Flavor defaulting and fetching, and validation against the flavor's policy
     3. SSH host key generation
-    4. Kubernetes resource creation
-    5. Status updates
+    4. A capacity preflight for new DevServers, Kubernetes resource creation,
+       and expansion of the home volume
+    5. Status updates (the phase only becomes Running once the pod is ready)
+    6. SSH bastion sync, if the bastion is enabled
     """
     logger.info(f"Reconciling DevServer '{name}' in namespace '{namespace}'...")
+    recorder = EventRecorder(logger)
+    reference = object_reference(body)
+    status = body.get("status", {})
+    # Only kopf's create handling passes the reason. Resyncs and spot fallbacks
+    # reconcile without it, and without an old spec, as nothing changed.
+    creating = kwargs.get("reason") == kopf.Reason.CREATE
+    if kwargs.get("old") is not None:
+        old_spec = kwargs["old"].get("spec") or {}
+    else:
+        old_spec = {} if creating else spec
+    # Status fields recorded once, on creation, along with the observed status
+    recorded: Dict[str, Any] = {}
+
+    # Step 0: Copy the spec of the DevServer it is cloned from, once, on creation.
+    # Persisted, so that later changes to the source do not reach the clone.
+    if spec.get("cloneFrom"):
+        if creating and not status.get("clonedFrom"):
+            with span("resolve clone source"):
+                cloned = await resolve_clone(name, namespace, spec, logger, recorder, reference)
+            spec = {**spec, **cloned}
+            patch.setdefault("spec", {}).update(cloned)
+            recorded["clonedFrom"] = spec["cloneFrom"]["name"]
+        spec = with_cloned_home_source(spec)
 
-    # Step 1: Validate TTL
+    # Likewise copy the spec and lifecycle defaults of its template, and record
+    # the template's lifecycle limits, which later template changes do not affect
+    if spec.get("template") and creating and not status.get("lifecycleLimits"):
+        with span("get DevServerTemplate"):
+            template = await get_template(spec["template"], logger, recorder, reference)
+        defaults = template_fields(spec, template)
+        spec = {**spec, **defaults}
+        patch.setdefault("spec", {}).update(defaults)
+        validate_template_lifecycle(spec, template, logger)
+        recorded["lifecycleLimits"] = lifecycle_limits(template)
+
+    # Resolve its profile on every reconcile, without persisting it, so that
+    # changes to the profile reach the DevServer
+    if spec.get("profile"):
+        with span("get DevServerProfile"):
+            profile = await get_profile(spec["profile"], logger, recorder, reference)
+        spec = with_profile(spec, profile)
+
+    # Step 1: Validate the name, which cannot change later, and the spec
+    if creating:
+        validate_name(name, spec, NAMING_POLICY, logger)
     ttl_str = spec.get("lifecycle", {}).get("timeToLive")
     validate_and_normalize_ttl(ttl_str, logger)
+    validate_lifecycle_limits(spec, status, logger)
+    validate_uptime_schedule(spec, logger)
+    calendar_name = spec.get("lifecycle", {}).get("uptimeSchedule", {}).get("calendar")
+    if calendar_name and await get_calendar(calendar_name, logger) is None:
+        await recorder.warning(
+            reference,
+            "CalendarNotFound",
+            f"DevServerCalendar '{calendar_name}' not found; the uptime schedule ignores holidays.",
+        )
+    validate_sshd_config_overrides(spec, logger)
+    validate_mosh(spec, logger)
+    validate_home_source(spec, logger)
+    validate_backup(spec, logger)
+    validate_home_mount_path(spec, logger)
+    validate_volumes(spec, logger)
+    validate_credential_bundles(spec, logger)
+    validate_scratch(spec, logger)
+    validate_env(spec, logger)
+    validate_containers(spec, logger)
+    validate_dotfiles_repo(spec, logger)
+    validate_prewarm(spec, logger)
+    validate_service_account(spec, logger)
+    validate_collaborators(spec, logger)
+
+    # sshd cannot bind port 22 without root, so restricted pods default to
+    # another one. Persisted, so that clients can read it from the spec.
+    if RESTRICTED_POD_SECURITY and "port" not in spec.get("ssh", {}):
+        spec = {**spec, "ssh": {**spec.get("ssh", {}), "port": RESTRICTED_SSH_PORT}}
+        patch.setdefault("spec", {})["ssh"] = {"port": RESTRICTED_SSH_PORT}
+    validate_pod_security(spec, RESTRICTED_POD_SECURITY, logger)
 
-    # Step 2: Get the DevServerFlavor
+    # Step 2: Get the DevServerFlavor, defaulting it for the namespace
+    if not spec.get("flavor"):
+        default_flavor = await get_default_flavor(namespace)
+        if default_flavor is None:
+            logger.error("No flavor given and there is no default flavor.")
+            await recorder.warning(
+                reference, "FlavorNotFound", "No flavor given and there is no default flavor."
+            )
+            raise kopf.PermanentError("No flavor given and there is no default flavor.")
+        # Persisted, so that a later change of the default does not move the DevServer
+        spec = {**spec, "flavor": default_flavor["metadata"]["name"]}
+        patch.setdefault("spec", {})["flavor"] = spec["flavor"]
+        logger.info(f"Using the default flavor '{spec['flavor']}'.")
+    # A DevServer provisioned with a fallback flavor keeps it
+    flavor_name = current_flavor_name(spec, body.get("status", {}))
     custom_objects_api = client.CustomObjectsApi()
     try:
-        flavor = await asyncio.to_thread(
-            custom_objects_api.get_cluster_custom_object,
-            group=CRD_GROUP,
-            version=CRD_VERSION,
-            plural=CRD_PLURAL_DEVSERVERFLAVOR,
-            name=spec["flavor"],
-        )
+        with span("get DevServerFlavor", {"devserver.flavor": flavor_name}):
+            flavor = await asyncio.to_thread(
+                custom_objects_api.get_cluster_custom_object,
+                group=CRD_GROUP,
+                version=CRD_VERSION,
+                plural=CRD_PLURAL_DEVSERVERFLAVOR,
+                name=flavor_name,
+            )
     except client.ApiException as e:
         if e.status == 404:
-            logger.error(f"DevServerFlavor '{spec['flavor']}' not found.")
-            raise kopf.PermanentError(f"Flavor '{spec['flavor']}' not found.")
+            logger.error(f"DevServerFlavor '{flavor_name}' not found.")
+            await recorder.warning(
+                reference, "FlavorNotFound", f"DevServerFlavor '{flavor_name}' not found."
+            )
+            raise kopf.PermanentError(f"Flavor '{flavor_name}' not found.")
         raise
+    try:
+        flavor = await resolve_base_flavors(flavor)
+    except ValueError as e:
+        logger.error(f"Invalid flavor: {e}")
+        raise kopf.PermanentError(f"Invalid flavor: {e}")
+    if flavor_name == spec["flavor"]:
+        validate_flavor_deprecation(spec, old_spec, flavor, logger)
+    validate_host_access({**spec, "flavor": flavor_name}, flavor, logger)
+    validate_priority_class({**spec, "flavor": flavor_name}, flavor, logger)
+    validate_security_profiles(flavor, RESTRICTED_POD_SECURITY, logger)
+    validate_home_encryption(spec, flavor, logger)
+    validate_affinity(spec, flavor, logger)
+    validate_capacity_type(spec, flavor, logger)
+    validate_team_node_pool(namespace, spec, flavor, logger)
+    validate_allowed_images({**spec, "flavor": flavor_name}, flavor, logger)
 
     # Step 3: Ensure SSH host keys exist
     # Build owner reference metadata for proper garbage collection
@@ -67,33 +281,651 @@ async def create_or_update_devserver(
         "name": name,
         "uid": meta["uid"],
     }
-    await ensure_host_keys_secret(name, namespace, owner_meta, logger)
+    with span("ensure host keys Secret"):
+        host_keys = await ensure_host_keys_secret(name, namespace, owner_meta, logger)
+    # code-server reads its password from a Secret that must exist before the pod starts
+    if ide_requested(spec):
+        with span("ensure IDE Secret"):
+            await ensure_ide_password_secret(name, namespace, owner_meta, logger)
 
-    # Step 4: Reconcile all Kubernetes resources
-    status_message = await reconcile_devserver(name, namespace, spec, flavor, logger)
+    # Step 4: Reconcile all Kubernetes resources, once the flavor, or one of its
+    # fallbacks, has capacity for a new DevServer and the home source can be restored
+    if not spec.get("hibernated", False):
+        with span("check capacity"):
+            flavor, capacity_shortage = await choose_flavor(
+                name, namespace, spec, meta, flavor, logger
+            )
+        if capacity_shortage:
+            logger.info(capacity_shortage)
+            await ALERTER.capacity_check_failed(flavor_name, capacity_shortage)
+            if body.get("status", {}).get("phase") != PHASE_WAITING_FOR_CAPACITY:
+                await recorder.warning(reference, "WaitingForCapacity", capacity_shortage)
+            patch["status"] = {**waiting_for_capacity_status(capacity_shortage), **recorded}
+            set_condition_transition_times(
+                body.get("status", {}).get("conditions"), patch["status"]["conditions"]
+            )
+            raise kopf.TemporaryError(capacity_shortage, delay=CAPACITY_RETRY_INTERVAL)
+    with span("prepare home source"):
+        await prepare_home_source(name, namespace, spec, logger, recorder, reference)
+    with span("resolve owner IDs"):
+        owner_ids = await resolve_owner_ids(spec.get("owner"), logger)
+    # A DevServer that fell back from spot capacity stays on on-demand capacity
+    capacity_type = current_capacity_type(spec, body.get("status", {}))
+    try:
+        status_message = await reconcile_devserver(
+            name,
+            namespace,
+            spec,
+            flavor,
+            logger,
+            recorder,
+            reference,
+            owner_ids,
+            meta,
+            capacity_type=capacity_type,
+        )
+    except client.ApiException as e:
+        await recorder.warning(
+            reference,
+            "ProvisioningFailed",
+            f"Failed to reconcile resources: {e.status} {e.reason}",
+        )
+        raise
+
+    logger.info(status_message)
+
+    # The StatefulSet's claim template is immutable, so a larger home is
+    # requested on the PVC itself
+    with span("expand home volume"):
+        await expand_home_volume(name, namespace, spec, logger, recorder, reference)
+
+    # Step 5: Update status from the observed state of the pod
+    with span("observe status"):
+        patch["status"] = await observe_devserver_status(body)
+    # Published so clients can pin the host keys instead of trusting on first use
+    patch["status"]["sshHostKeys"] = host_keys
+    patch["status"]["flavor"] = flavor["metadata"]["name"]
+    patch["status"]["capacityType"] = capacity_type
+    patch["status"].update(recorded)
+    if creating:
+        await notifications.notify(
+            body, notifications.EVENT_CREATED, "DevServer was created.", logger
+        )
+
+    # Step 6: Let the namespace's bastion through to this DevServer
+    if BASTION_ENABLED:
+        await _sync_bastion(namespace, logger)
+
+    # Step 7: Let the owner manage it and its collaborators connect to it,
+    # and tell them about the collaborators who came and went
+    if OWNER_RBAC_ENABLED:
+        owners = [spec.get("owner"), old_spec.get("owner")]
+        owners += get_collaborators(spec) + get_collaborators(old_spec)
+        for owner in dict.fromkeys(owners):
+            if owner:
+                await _sync_owner_rbac(namespace, owner, logger)
+    added, removed = collaborator_changes(old_spec, spec)
+    if added or removed:
+        changes = [f"added {', '.join(added)}"] if added else []
+        changes += [f"removed {', '.join(removed)}"] if removed else []
+        await recorder.normal(
+            reference, "CollaboratorsChanged", f"Collaborators {' and '.join(changes)}."
+        )
+
+
+@kopf.on.update(
+    CRD_GROUP, CRD_VERSION, CRD_PLURAL_DEVSERVER, field="spec.lifecycle.timeToLive"
+)
+async def count_devserver_extension(
+    name: str,
+    old: Any,
+    new: Any,
+    status: Dict[str, Any],
+    body: Dict[str, Any],
+    patch: Dict[str, Any],
+    logger: logging.Logger,
+    **kwargs: Any,
+) -> None:
+    """
+    Count TTL extensions against the limit of the DevServer's template, and
+    undo those it does not allow, however they were made.
+    """
+    try:
+        limits = extension_limits(old, new, status)
+    except ValueError as e:
+        logger.warning(f"Undoing the extension of DevServer '{name}' to {new}: {e}")
+        await EventRecorder(logger).warning(object_reference(body), "ExtensionRejected", str(e))
+        patch.setdefault("spec", {})["lifecycle"] = {"timeToLive": old}
+        return
+    if limits is not None:
+        # The reconcile handler may have already set other status fields
+        patch.setdefault("status", {})["lifecycleLimits"] = limits
+
+
+async def _sync_bastion(namespace: str, logger: logging.Logger) -> None:
+    """Sync the namespace's bastion; a broken bastion must not block DevServers."""
+    try:
+        await reconcile_bastion(namespace, logger)
+    except client.ApiException as e:
+        logger.error(f"Failed to sync the SSH bastion in namespace '{namespace}': {e.reason}")
+
+
+async def _sync_owner_rbac(namespace: str, owner: str, logger: logging.Logger) -> None:
+    """Sync the owner's RBAC; failing to must not block DevServers."""
+    try:
+        await reconcile_owner_rbac(namespace, owner, logger)
+    except client.ApiException as e:
+        logger.error(
+            f"Failed to sync the RBAC of owner '{owner}' in namespace '{namespace}': {e.reason}"
+        )
+
+
+async def admit_devserver_owner(
+    spec: Dict[str, Any],
+    patch: Dict[str, Any],
+    userinfo: Dict[str, Any],
+    operation: str,
+    logger: logging.Logger,
+    **kwargs: Any,
+) -> None:
+    """
+    Fill in the owner of a DevServer from the identity of the user creating
+    it, and reject owners that the user may not give it.
+    """
+    if operation not in ("CREATE", "UPDATE"):
+        return
+    users = await asyncio.to_thread(
+        client.CustomObjectsApi().list_cluster_custom_object,
+        group=CRD_GROUP,
+        version=CRD_VERSION,
+        plural=CRD_PLURAL_DEVSERVERUSER,
+    )
+    old_owner = ((kwargs.get("old") or {}).get("spec") or {}).get("owner")
+    try:
+        owner = resolve_owner(
+            spec.get("owner"),
+            userinfo or {},
+            users["items"],
+            old_owner=old_owner,
+            updating=operation == "UPDATE",
+        )
+    except ValueError as e:
+        logger.warning(f"Rejected the owner of a DevServer: {e}")
+        raise kopf.AdmissionError(str(e), code=403)
+    if owner:
+        patch.setdefault("spec", {})["owner"] = owner
+
+
+async def _admission_flavor(
+    spec: Dict[str, Any], namespace: str, checking: str, logger: logging.Logger
+) -> Optional[Dict[str, Any]]:
+    """
+    The flavor a DevServer being admitted uses, or None if it is only known
+    when reconciling, which then checks the DevServer against it.
+    """
+    # The flavor of its profile is only known when reconciling
+    if not spec.get("flavor") and spec.get("profile"):
+        return None
+    try:
+        if spec.get("flavor"):
+            return await get_flavor(spec["flavor"])
+        default_flavor = await get_default_flavor(namespace)
+        if default_flavor is None:
+            return None
+        return await resolve_base_flavors(default_flavor)
+    except (client.ApiException, ValueError) as e:
+        # Reported by the reconcile handler
+        logger.warning(f"Cannot check the {checking} of a DevServer: {e}")
+        return None
+
+
+async def admit_devserver_priority_class(
+    spec: Dict[str, Any],
+    namespace: str,
+    operation: str,
+    logger: logging.Logger,
+    **kwargs: Any,
+) -> None:
+    """Reject PriorityClasses that the DevServer's flavor does not permit."""
+    if operation not in ("CREATE", "UPDATE") or not spec.get("priorityClassName"):
+        return
+    old_spec = (kwargs.get("old") or {}).get("spec") or {}
+    if all(old_spec.get(field) == spec.get(field) for field in ("priorityClassName", "flavor")):
+        return
+    flavor = await _admission_flavor(spec, namespace, "PriorityClass", logger)
+    if flavor is None:
+        return
+    try:
+        validate_user_priority_class({**spec, "flavor": flavor["metadata"]["name"]}, flavor)
+    except ValueError as e:
+        logger.warning(f"Rejected the PriorityClass of a DevServer: {e}")
+        raise kopf.AdmissionError(str(e), code=422)
+
+
+async def admit_devserver_host_access(
+    spec: Dict[str, Any],
+    namespace: str,
+    userinfo: Dict[str, Any],
+    operation: str,
+    logger: logging.Logger,
+    **kwargs: Any,
+) -> None:
+    """
+    Reject host namespaces and privileged containers that the DevServer's
+    flavor does not permit, or only permits to users other than the one
+    making the request.
+    """
+    if operation not in ("CREATE", "UPDATE"):
+        return
+    if not any(spec.get(field) for field in (*HOST_ACCESS_FIELDS, "sidecars", "initContainers")):
+        return
+    # E.g. the operator updating the DevServer, which may not be an allowed owner
+    old_spec = (kwargs.get("old") or {}).get("spec") or {}
+    fields = ("flavor", *HOST_ACCESS_FIELDS, "sidecars", "initContainers")
+    if operation == "UPDATE" and all(old_spec.get(f) == spec.get(f) for f in fields):
+        return
+    flavor = await _admission_flavor(spec, namespace, "host access", logger)
+    if flavor is None:
+        return
+    users = await asyncio.to_thread(
+        client.CustomObjectsApi().list_cluster_custom_object,
+        group=CRD_GROUP,
+        version=CRD_VERSION,
+        plural=CRD_PLURAL_DEVSERVERUSER,
+    )
+    requesters = requester_owners(userinfo or {}, users["items"])
+    # Admins may create DevServers for others, who must be allowed themselves
+    if set((userinfo or {}).get("groups") or []) & set(OWNER_ADMIN_GROUPS):
+        requesters.append(spec.get("owner", ""))
+    try:
+        validate_user_host_access(
+            {**spec, "flavor": flavor["metadata"]["name"]}, flavor, requesters
+        )
+    except ValueError as e:
+        logger.warning(f"Rejected the host access of a DevServer: {e}")
+        raise kopf.AdmissionError(str(e), code=403)
+
+
+async def admit_devserver_flavor(
+    spec: Dict[str, Any],
+    namespace: str,
+    operation: str,
+    logger: logging.Logger,
+    **kwargs: Any,
+) -> None:
+    """
+    Reject new DevServers, and DevServers switching flavors, that use a
+    deprecated flavor. DevServers already using it may keep it.
+    """
+    # The default flavor cannot be deprecated
+    if operation not in ("CREATE", "UPDATE") or not spec.get("flavor"):
+        return
+    old_spec = (kwargs.get("old") or {}).get("spec") or {}
+    if operation == "UPDATE" and old_spec.get("flavor") == spec["flavor"]:
+        return
+    flavor = await _admission_flavor(spec, namespace, "flavor", logger)
+    if flavor is None:
+        return
+    try:
+        validate_flavor_deprecation(spec, old_spec, flavor, logger)
+    except kopf.PermanentError as e:
+        raise kopf.AdmissionError(str(e), code=422)
+
+
+async def admit_devserver_images(
+    spec: Dict[str, Any],
+    namespace: str,
+    operation: str,
+    logger: logging.Logger,
+    **kwargs: Any,
+) -> None:
+    """Reject images that the DevServer's flavor does not allow."""
+    if operation not in ("CREATE", "UPDATE"):
+        return
+    old_spec = (kwargs.get("old") or {}).get("spec") or {}
+    fields = ("flavor", "image", "sidecars", "initContainers")
+    if operation == "UPDATE" and all(old_spec.get(f) == spec.get(f) for f in fields):
+        return
+    flavor = await _admission_flavor(spec, namespace, "images", logger)
+    if flavor is None:
+        return
+    try:
+        validate_allowed_images({**spec, "flavor": flavor["metadata"]["name"]}, flavor, logger)
+    except kopf.PermanentError as e:
+        raise kopf.AdmissionError(str(e), code=422)
+
+
+async def admit_devserver_name(
+    name: str,
+    spec: Dict[str, Any],
+    operation: str,
+    logger: logging.Logger,
+    **kwargs: Any,
+) -> None:
+    """
+    Reject names that do not fit the DevServer's children or the naming
+    policy. Names from generateName are already generated at this point.
+    """
+    if operation != "CREATE":
+        return
+    try:
+        validate_user_name(name, spec, NAMING_POLICY)
+    except ValueError as e:
+        logger.warning(f"Rejected the name of a DevServer: {e}")
+        raise kopf.AdmissionError(str(e), code=422)
+
+
+# Only registered when enabled, as kopf complains about webhooks without a server
+if OWNER_IDENTITY_ENABLED:
+    kopf.on.mutate(CRD_GROUP, CRD_VERSION, CRD_PLURAL_DEVSERVER, id="owner")(
+        admit_devserver_owner
+    )
+if WEBHOOK_ENABLED:
+    kopf.on.validate(CRD_GROUP, CRD_VERSION, CRD_PLURAL_DEVSERVER, id="priority-class")(
+        admit_devserver_priority_class
+    )
+    kopf.on.validate(CRD_GROUP, CRD_VERSION, CRD_PLURAL_DEVSERVER, id="name")(
+        admit_devserver_name
+    )
+    kopf.on.validate(CRD_GROUP, CRD_VERSION, CRD_PLURAL_DEVSERVER, id="host-access")(
+        admit_devserver_host_access
+    )
+    kopf.on.validate(CRD_GROUP, CRD_VERSION, CRD_PLURAL_DEVSERVER, id="flavor")(
+        admit_devserver_flavor
+    )
+    kopf.on.validate(CRD_GROUP, CRD_VERSION, CRD_PLURAL_DEVSERVER, id="images")(
+        admit_devserver_images
+    )
+
+
+@kopf.timer(CRD_GROUP, CRD_VERSION, CRD_PLURAL_DEVSERVER, interval=STATUS_CHECK_INTERVAL)
+@traced("refresh DevServer status")
+async def refresh_devserver_status(
+    name: str,
+    namespace: str,
+    spec: Dict[str, Any],
+    body: Dict[str, Any],
+    status: Dict[str, Any],
+    patch: Dict[str, Any],
+    logger: logging.Logger,
+    **kwargs: Any,
+) -> None:
+    """
+    Periodically fold the StatefulSet and pod state into the DevServer status.
+
+    Only changed fields are patched so that an idle DevServer does not
+    generate a write on every tick. A `spot-with-fallback` DevServer whose
+    spot node was reclaimed is moved to on-demand capacity instead.
+    """
+    if (
+        spec.get("capacityType") == CAPACITY_TYPE_SPOT_WITH_FALLBACK
+        and current_capacity_type(spec, status) == CAPACITY_TYPE_SPOT
+    ):
+        reclamation = await find_spot_reclamation(name, namespace)
+        if reclamation:
+            await _fall_back_to_on_demand(
+                name, namespace, spec, body, patch, logger, reclamation, **kwargs
+            )
+            return
+    observed = await observe_devserver_status(body)
+    changes = {key: value for key, value in observed.items() if status.get(key) != value}
+    if changes:
+        if "phase" in changes:
+            logger.info(
+                f"DevServer '{name}' phase changed from "
+                f"'{status.get('phase')}' to '{observed['phase']}': {observed['message']}"
+            )
+            await _record_phase_change(body, observed, logger)
+        patch["status"] = changes
+
+
+async def _fall_back_to_on_demand(
+    name: str,
+    namespace: str,
+    spec: Dict[str, Any],
+    body: Dict[str, Any],
+    patch: Dict[str, Any],
+    logger: logging.Logger,
+    reclamation: str,
+    **kwargs: Any,
+) -> None:
+    """
+    Move a DevServer whose spot node was reclaimed to on-demand capacity. The
+    pod is recreated from the updated StatefulSet and keeps its home PVC.
+    """
+    message = f"Spot node reclaimed, moving the DevServer to on-demand capacity: {reclamation}"
+    logger.warning(message)
+    await EventRecorder(logger).warning(object_reference(body), "SpotReclaimed", message)
+    status = {**body.get("status", {}), "capacityType": CAPACITY_TYPE_ON_DEMAND}
+    await create_or_update_devserver(
+        spec=spec,
+        name=name,
+        namespace=namespace,
+        logger=logger,
+        patch=patch,
+        body={**body, "status": status},
+        **kwargs,
+    )
+    await delete_reclaimed_pod(name, namespace, logger)
+
+
+@kopf.timer(CRD_GROUP, CRD_VERSION, CRD_PLURAL_DEVSERVER, interval=MIN_REQUEUE_AFTER)
+async def resync_devserver(
+    meta: Dict[str, Any],
+    memo: kopf.Memo,
+    logger: logging.Logger,
+    **kwargs: Any,
+) -> None:
+    """
+    Reconcile the DevServer again once its requeue interval has passed since
+    the last resync, putting back resources that drifted from its spec.
+    """
+    now = time.monotonic()
+    # The first resync is a full interval after the operator starts, rather
+    # than of every DevServer at once
+    last_resync = memo.setdefault("last_resync", now)
+    if now - last_resync < requeue_after(meta, logger):
+        return
+    logger.info("Resyncing DevServer.")
+    await create_or_update_devserver(meta=meta, memo=memo, logger=logger, **kwargs)
+    # Only after it succeeded, so that a failed resync is retried
+    memo["last_resync"] = now
+
+
+def _has_backup_schedule(spec: Dict[str, Any], **_: Any) -> bool:
+    return bool(spec.get("backup", {}).get("schedule"))
+
+
+@kopf.timer(
+    CRD_GROUP,
+    CRD_VERSION,
+    CRD_PLURAL_DEVSERVER,
+    interval=BACKUP_CHECK_INTERVAL,
+    when=_has_backup_schedule,
+)
+@traced("run scheduled backup")
+async def run_devserver_backup_schedule(
+    name: str,
+    namespace: str,
+    spec: Dict[str, Any],
+    meta: Dict[str, Any],
+    body: Dict[str, Any],
+    status: Dict[str, Any],
+    patch: Dict[str, Any],
+    logger: logging.Logger,
+    **kwargs: Any,
+) -> None:
+    """Snapshot the home volume whenever `spec.backup.schedule` fires."""
+    try:
+        validate_backup(spec, logger)
+    except kopf.PermanentError:
+        # Reported by the reconcile handler
+        return
+    backup_status = await run_scheduled_backup(
+        name, namespace, spec, meta, status, logger, EventRecorder(logger), object_reference(body)
+    )
+    if backup_status != status.get("backup"):
+        patch["status"] = {"backup": backup_status}
+
+
+def _has_uptime_schedule(spec: Dict[str, Any], **_: Any) -> bool:
+    return bool(spec.get("lifecycle", {}).get("uptimeSchedule"))
+
+
+@kopf.timer(
+    CRD_GROUP,
+    CRD_VERSION,
+    CRD_PLURAL_DEVSERVER,
+    interval=UPTIME_CHECK_INTERVAL,
+    when=_has_uptime_schedule,
+)
+@traced("run uptime schedule")
+async def run_devserver_uptime_schedule(
+    name: str,
+    spec: Dict[str, Any],
+    meta: Dict[str, Any],
+    body: Dict[str, Any],
+    status: Dict[str, Any],
+    patch: Dict[str, Any],
+    logger: logging.Logger,
+    **kwargs: Any,
+) -> None:
+    """
+    Hibernate and resume the DevServer as `spec.lifecycle.uptimeSchedule`
+    fires, except on the holidays of its calendar.
+    """
+    try:
+        validate_uptime_schedule(spec, logger)
+    except kopf.PermanentError:
+        # Reported by the reconcile handler
+        return
+    calendar_name = spec["lifecycle"]["uptimeSchedule"].get("calendar")
+    calendar = await get_calendar(calendar_name, logger) if calendar_name else None
+    hibernated, holiday, uptime_status = run_uptime_schedule(spec, meta, status, calendar)
+
+    recorder = EventRecorder(logger)
+    if holiday is not None and spec.get("hibernated", False):
+        message = f"Not resuming the DevServer on {holiday}."
+        logger.info(message)
+        await recorder.normal(object_reference(body), "HolidaySkipped", message)
+    if hibernated is not None and hibernated != spec.get("hibernated", False):
+        reason = "ScheduledHibernation" if hibernated else "ScheduledResume"
+        message = (
+            "Hibernating the DevServer after working hours."
+            if hibernated
+            else "Resuming the DevServer for working hours."
+        )
+        logger.info(message)
+        await recorder.normal(object_reference(body), reason, message)
+        patch["spec"] = {"hibernated": hibernated}
+    patch["status"] = {"uptimeSchedule": uptime_status}
+
+
+def _agent_enabled(**_: Any) -> bool:
+    return AGENT_ENABLED
+
+
+@kopf.timer(
+    CRD_GROUP,
+    CRD_VERSION,
+    CRD_PLURAL_DEVSERVER,
+    interval=AGENT_SYNC_INTERVAL,
+    when=_agent_enabled,
+)
+@traced("sync DevServer agent")
+async def sync_devserver_agent(
+    name: str,
+    namespace: str,
+    spec: Dict[str, Any],
+    meta: Dict[str, Any],
+    status: Dict[str, Any],
+    body: Dict[str, Any],
+    patch: Dict[str, Any],
+    logger: logging.Logger,
+    **kwargs: Any,
+) -> None:
+    """
+    Fold the in-pod agent's activity reports into the status, carry out its
+    requests, and hibernate the DevServer once it was idle for its idle timeout.
+    """
+    if spec.get("hibernated", False):
+        return
+    activity = await sync_agent(name, namespace, spec, meta, status, logger)
+    if activity is not None:
+        patch["status"] = {"activity": activity}
+        status = {**status, "activity": activity}
+    if idle_timeout_elapsed(spec, meta, status, datetime.now(timezone.utc)):
+        message = f"DevServer was idle for {spec['lifecycle']['idleTimeout']}; hibernating it."
+        logger.info(message)
+        await EventRecorder(logger).normal(object_reference(body), "IdleHibernated", message)
+        await notifications.notify(body, notifications.EVENT_IDLE_HIBERNATED, message, logger)
+        patch["spec"] = {"hibernated": True}
+
+
+async def _record_phase_change(
+    body: Dict[str, Any], observed: Dict[str, Any], logger: logging.Logger
+) -> None:
+    """
+    Record an event for phase transitions users need to know about, and
+    send notifications about them.
+    """
+    recorder = EventRecorder(logger)
+    if observed["phase"] == PHASE_RUNNING:
+        await recorder.normal(object_reference(body), "Ready", observed["message"])
+        await notifications.notify(body, notifications.EVENT_READY, observed["message"], logger)
+    elif observed["phase"] == PHASE_FAILED:
+        await recorder.warning(object_reference(body), "ProvisioningFailed", observed["message"])
+        await notifications.notify(body, notifications.EVENT_FAILED, observed["message"], logger)
+    elif observed["phase"] == PHASE_HIBERNATED:
+        await recorder.normal(object_reference(body), "Hibernated", observed["message"])
+        await notifications.notify(
+            body, notifications.EVENT_HIBERNATED, observed["message"], logger
+        )
 
-    # Step 5: Update status
-    patch["status"] = {
-        "phase": "Running",
-        "message": status_message,
-    }
 
 @kopf.on.delete(CRD_GROUP, CRD_VERSION, CRD_PLURAL_DEVSERVER)
+@with_backoff
+@traced("delete DevServer")
 async def delete_devserver(
-    name: str, namespace: str, logger: logging.Logger, **kwargs: Any
+    name: str,
+    namespace: str,
+    spec: Dict[str, Any],
+    meta: Dict[str, Any],
+    body: Dict[str, Any],
+    logger: logging.Logger,
+    retry: int = 0,
+    **kwargs: Any,
 ) -> None:
     """
     Handle the deletion of a DevServer resource.
 
-    The StatefulSet and Services are owned by the DevServer via owner
-    references and will be garbage collected automatically.
-
-    Note: PVCs from StatefulSets are NOT automatically deleted to prevent
-    data loss. Administrators may need to clean them up manually.
+    The finalizer is only removed once teardown has released the DevServer's
+    resources: its in-progress backups are cancelled, its SSH Service is
+    deleted, and its home PVC is retained, deleted or snapshotted and deleted
+    according to `spec.persistentHome.deletionPolicy`. The StatefulSet and the
+    other resources owned by the DevServer are then garbage collected.
     """
-    #TODO: Make a snapshot of the container
+    recorder = EventRecorder(logger)
+    reference = object_reference(body)
     logger.info(f"DevServer '{name}' in namespace '{namespace}' is being deleted.")
+    if not retry:
+        await recorder.normal(reference, "Deleting", "DevServer is being deleted.")
+        await notifications.notify(
+            body, notifications.EVENT_DELETED, "DevServer is being deleted.", logger
+        )
+
+    with span("cancel backups"):
+        await cancel_backups(name, namespace, logger)
+    with span("release SSH Service"):
+        await release_ssh_service(name, namespace, logger)
+    with span("release home volume"):
+        await release_home_volume(name, namespace, spec, meta, logger, recorder, reference)
+
+    forget_devserver_cost(body)
+    if BASTION_ENABLED:
+        await _sync_bastion(namespace, logger)
+    if OWNER_RBAC_ENABLED:
+        for owner in dict.fromkeys([spec.get("owner"), *get_collaborators(spec)]):
+            if owner:
+                await _sync_owner_rbac(namespace, owner, logger)
     logger.info("Associated StatefulSet and Services will be garbage collected.")
-    logger.warning(
-        f"PersistentVolumeClaim for '{name}' will NOT be deleted automatically."
-    )
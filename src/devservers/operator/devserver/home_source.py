@@ -0,0 +1,287 @@
+"""
+Seeding a new DevServer's home volume from an existing source.
+
+`spec.homeSource` only applies when the home PVC is created, so sources are
+only checked until the PVC exists. It can be one of:
+
+- `snapshotRef`: a DevServerSnapshot, restored by the CSI driver.
+- `fromDevServer`: another DevServer's home PVC, cloned by the CSI driver.
+- `backupRef`: a DevServerBackup, or the URL of any backup archive, restored
+  by an init container.
+"""
+import asyncio
+import base64
+import logging
+from typing import Any, Dict, Mapping, Optional, Tuple
+
+import kopf
+from kubernetes import client
+
+from ..events import EventRecorder
+from .home_volume import home_pvc_name
+from .resources.object_storage import (
+    home_source_secret_name,
+    parse_backup_url,
+    rclone_credentials_env,
+    rclone_remote_env,
+)
+from ...crds.const import (
+    CRD_GROUP,
+    CRD_VERSION,
+    CRD_PLURAL_DEVSERVER,
+    CRD_PLURAL_DEVSERVERBACKUP,
+    CRD_PLURAL_DEVSERVERSNAPSHOT,
+)
+
+HOME_SOURCE_KINDS = ("snapshotRef", "backupRef", "fromDevServer")
+
+# How long to wait for a source that is not ready yet before retrying
+SOURCE_NOT_READY_DELAY = 10
+
+
+def validate_home_source(spec: Mapping[str, Any], logger: logging.Logger) -> None:
+    """
+    Validate spec.homeSource.
+    Raises a PermanentError if it is invalid.
+    """
+    home_source = spec.get("homeSource")
+    if not home_source:
+        return
+
+    try:
+        if not spec.get("persistentHome", {}).get("enabled", False):
+            raise ValueError("it requires spec.persistentHome.enabled.")
+        kinds = [kind for kind in HOME_SOURCE_KINDS if kind in home_source]
+        if len(kinds) != 1:
+            raise ValueError(f"exactly one of {', '.join(HOME_SOURCE_KINDS)} must be set.")
+        backup_ref = home_source.get("backupRef")
+        if backup_ref is not None:
+            if ("name" in backup_ref) == ("url" in backup_ref):
+                raise ValueError("backupRef needs exactly one of name and url.")
+            if "url" in backup_ref:
+                parse_backup_url(backup_ref["url"])
+
+    except ValueError as e:
+        logger.error(f"Invalid spec.homeSource: {e}")
+        raise kopf.PermanentError(f"Invalid spec.homeSource: {e}")
+
+
+async def _home_pvc_exists(name: str, namespace: str) -> bool:
+    try:
+        await asyncio.to_thread(
+            client.CoreV1Api().read_namespaced_persistent_volume_claim,
+            name=home_pvc_name(name),
+            namespace=namespace,
+        )
+        return True
+    except client.ApiException as e:
+        if e.status == 404:
+            return False
+        raise
+
+
+async def _get_source(
+    plural: str,
+    kind: str,
+    source_name: str,
+    namespace: str,
+    logger: logging.Logger,
+    recorder: EventRecorder,
+    reference: Dict[str, Any],
+) -> Dict[str, Any]:
+    try:
+        return await asyncio.to_thread(
+            client.CustomObjectsApi().get_namespaced_custom_object,
+            group=CRD_GROUP,
+            version=CRD_VERSION,
+            plural=plural,
+            namespace=namespace,
+            name=source_name,
+        )
+    except client.ApiException as e:
+        if e.status == 404:
+            message = f"{kind} '{source_name}' not found."
+            logger.error(message)
+            await recorder.warning(reference, "HomeSourceNotFound", message)
+            raise kopf.PermanentError(message)
+        raise
+
+
+async def _check_snapshot(
+    snapshot_name: str,
+    namespace: str,
+    logger: logging.Logger,
+    recorder: EventRecorder,
+    reference: Dict[str, Any],
+) -> None:
+    snapshot = await _get_source(
+        CRD_PLURAL_DEVSERVERSNAPSHOT, "DevServerSnapshot", snapshot_name, namespace,
+        logger, recorder, reference,
+    )
+    phase = snapshot.get("status", {}).get("phase")
+    if phase == "Failed":
+        message = f"DevServerSnapshot '{snapshot_name}' failed and cannot be restored."
+        await recorder.warning(reference, "SnapshotFailed", message)
+        raise kopf.PermanentError(message)
+    if phase != "Ready":
+        raise kopf.TemporaryError(
+            f"Waiting for DevServerSnapshot '{snapshot_name}' to be ready.",
+            delay=SOURCE_NOT_READY_DELAY,
+        )
+    logger.info(f"Restoring the home volume from DevServerSnapshot '{snapshot_name}'.")
+
+
+async def _check_devserver(
+    source_name: str,
+    namespace: str,
+    logger: logging.Logger,
+    recorder: EventRecorder,
+    reference: Dict[str, Any],
+) -> None:
+    source = await _get_source(
+        CRD_PLURAL_DEVSERVER, "DevServer", source_name, namespace, logger, recorder, reference
+    )
+    if not source["spec"].get("persistentHome", {}).get("enabled", False):
+        raise kopf.PermanentError(
+            f"DevServer '{source_name}' has no persistent home volume to clone."
+        )
+    if not await _home_pvc_exists(source_name, namespace):
+        raise kopf.TemporaryError(
+            f"Waiting for the home volume of DevServer '{source_name}' to be created.",
+            delay=SOURCE_NOT_READY_DELAY,
+        )
+    logger.info(f"Cloning the home volume of DevServer '{source_name}'.")
+
+
+async def _resolve_backup(
+    backup_ref: Mapping[str, Any],
+    namespace: str,
+    logger: logging.Logger,
+    recorder: EventRecorder,
+    reference: Dict[str, Any],
+) -> Tuple[Dict[str, Any], str, Optional[Mapping[str, Any]]]:
+    """
+    Resolve a backupRef into the archive's destination and `<bucket>/<key>`,
+    and the Secret holding the credentials to read it.
+    """
+    if "url" in backup_ref:
+        destination, object_path = parse_backup_url(backup_ref["url"])
+        provider = next(iter(destination))
+        for option in ("region", "endpoint"):
+            if backup_ref.get(option):
+                destination[provider][option] = backup_ref[option]
+        return destination, object_path, backup_ref.get("credentialsSecretRef")
+
+    backup_name = backup_ref["name"]
+    backup = await _get_source(
+        CRD_PLURAL_DEVSERVERBACKUP, "DevServerBackup", backup_name, namespace,
+        logger, recorder, reference,
+    )
+    status = backup.get("status", {})
+    if status.get("phase") == "Failed":
+        message = f"DevServerBackup '{backup_name}' failed and cannot be restored."
+        await recorder.warning(reference, "BackupFailed", message)
+        raise kopf.PermanentError(message)
+    if status.get("phase") != "Completed":
+        raise kopf.TemporaryError(
+            f"Waiting for DevServerBackup '{backup_name}' to complete.",
+            delay=SOURCE_NOT_READY_DELAY,
+        )
+    _, object_path = parse_backup_url(status["url"])
+    return backup["spec"]["destination"], object_path, backup["spec"].get("credentialsSecretRef")
+
+
+async def _read_credentials(secret_name: str, namespace: str) -> Dict[str, str]:
+    try:
+        secret = await asyncio.to_thread(
+            client.CoreV1Api().read_namespaced_secret, name=secret_name, namespace=namespace
+        )
+    except client.ApiException as e:
+        if e.status == 404:
+            raise kopf.PermanentError(f"Credentials Secret '{secret_name}' not found.")
+        raise
+    return {key: base64.b64decode(value).decode() for key, value in (secret.data or {}).items()}
+
+
+async def _ensure_restore_secret(
+    name: str,
+    namespace: str,
+    backup_ref: Mapping[str, Any],
+    logger: logging.Logger,
+    recorder: EventRecorder,
+    reference: Dict[str, Any],
+) -> None:
+    """
+    Write the restore-home init container's configuration, including the
+    credentials to read the backup, into the DevServer's home source Secret.
+    """
+    destination, object_path, credentials_secret_ref = await _resolve_backup(
+        backup_ref, namespace, logger, recorder, reference
+    )
+    env = rclone_remote_env(destination, env_auth=not credentials_secret_ref)
+    if credentials_secret_ref:
+        credentials = await _read_credentials(credentials_secret_ref["name"], namespace)
+        try:
+            env.update(rclone_credentials_env(destination, credentials))
+        except KeyError as e:
+            raise kopf.PermanentError(
+                f"Credentials Secret '{credentials_secret_ref['name']}' has no key {e}."
+            )
+    env["BACKUP_OBJECT_PATH"] = object_path
+
+    secret = {
+        "apiVersion": "v1",
+        "kind": "Secret",
+        "metadata": {"name": home_source_secret_name(name), "namespace": namespace},
+        "stringData": env,
+    }
+    kopf.adopt(secret)
+    core_v1 = client.CoreV1Api()
+    try:
+        await asyncio.to_thread(
+            core_v1.create_namespaced_secret, namespace=namespace, body=secret
+        )
+    except client.ApiException as e:
+        if e.status != 409:
+            raise
+        await asyncio.to_thread(
+            core_v1.replace_namespaced_secret,
+            name=home_source_secret_name(name),
+            namespace=namespace,
+            body=secret,
+        )
+    logger.info(f"Restoring the home directory from backup '{object_path}'.")
+
+
+async def prepare_home_source(
+    name: str,
+    namespace: str,
+    spec: Mapping[str, Any],
+    logger: logging.Logger,
+    recorder: EventRecorder,
+    reference: Dict[str, Any],
+) -> None:
+    """
+    Hold off creating the home PVC until its source can be restored, and
+    configure the restore of backups.
+
+    Raises:
+        kopf.TemporaryError: While the source is not ready yet.
+        kopf.PermanentError: If the source does not exist or failed.
+    """
+    home_source = spec.get("homeSource")
+    if not home_source or await _home_pvc_exists(name, namespace):
+        return
+
+    if "snapshotRef" in home_source:
+        await _check_snapshot(
+            home_source["snapshotRef"]["name"], namespace, logger, recorder, reference
+        )
+    elif "fromDevServer" in home_source:
+        await _check_devserver(
+            home_source["fromDevServer"]["name"], namespace, logger, recorder, reference
+        )
+    elif "backupRef" in home_source:
+        await _ensure_restore_secret(
+            name, namespace, home_source["backupRef"], logger, recorder, reference
+        )
@@ -0,0 +1,191 @@
+"""
+Online expansion of a DevServer's persistent home volume.
+
+The home PVC is created from the StatefulSet's volume claim template, which is
+immutable. Growing `spec.persistentHome.size` therefore patches the PVC
+directly, which only works when its StorageClass allows volume expansion.
+PVCs can never shrink.
+"""
+import asyncio
+import logging
+from typing import Any, Dict, List, Mapping, Optional
+
+from kubernetes import client
+from kubernetes.utils import parse_quantity
+
+from ..events import EventRecorder
+
+CONDITION_HOME_VOLUME_RESIZED = "HomeVolumeResized"
+
+REASON_RESIZED = "Resized"
+REASON_RESIZING = "Resizing"
+REASON_FILE_SYSTEM_RESIZE_PENDING = "FileSystemResizePending"
+REASON_EXPANSION_NOT_SUPPORTED = "ExpansionNotSupported"
+REASON_SHRINK_NOT_SUPPORTED = "ShrinkNotSupported"
+
+
+def home_pvc_name(name: str) -> str:
+    """The name of the PVC created from the StatefulSet's `home` claim template."""
+    return f"home-{name}-0"
+
+
+def _requested_size(spec: Mapping[str, Any]) -> Optional[str]:
+    persistent_home = spec.get("persistentHome", {})
+    if not persistent_home.get("enabled", False):
+        return None
+    return persistent_home.get("size", "10Gi")
+
+
+def _pvc_request(pvc: client.V1PersistentVolumeClaim) -> Optional[str]:
+    requests = (pvc.spec.resources and pvc.spec.resources.requests) or {}
+    return requests.get("storage")
+
+
+def _pvc_capacity(pvc: client.V1PersistentVolumeClaim) -> Optional[str]:
+    capacity = (pvc.status and pvc.status.capacity) or {}
+    return capacity.get("storage")
+
+
+async def expand_home_volume(
+    name: str,
+    namespace: str,
+    spec: Mapping[str, Any],
+    logger: logging.Logger,
+    recorder: EventRecorder,
+    reference: Dict[str, Any],
+) -> None:
+    """
+    Grow the home PVC to `spec.persistentHome.size` if it was increased.
+
+    Does nothing until the PVC exists. Expansion that the StorageClass does
+    not support, and shrinking, are reported as Warning events and in the
+    `HomeVolumeResized` condition rather than failing the reconcile.
+    """
+    requested = _requested_size(spec)
+    if requested is None:
+        return
+
+    core_v1 = client.CoreV1Api()
+    pvc_name = home_pvc_name(name)
+    try:
+        pvc = await asyncio.to_thread(
+            core_v1.read_namespaced_persistent_volume_claim, name=pvc_name, namespace=namespace
+        )
+    except client.ApiException as e:
+        if e.status == 404:
+            return
+        raise
+
+    current = _pvc_request(pvc)
+    if current is None or parse_quantity(requested) == parse_quantity(current):
+        return
+    if parse_quantity(requested) < parse_quantity(current):
+        logger.warning(f"Cannot shrink PVC '{pvc_name}' from {current} to {requested}.")
+        await recorder.warning(
+            reference,
+            REASON_SHRINK_NOT_SUPPORTED,
+            f"The home volume cannot shrink from {current} to {requested}.",
+        )
+        return
+
+    storage_class_name = pvc.spec.storage_class_name
+    if storage_class_name:
+        storage_class = await asyncio.to_thread(
+            client.StorageV1Api().read_storage_class, name=storage_class_name
+        )
+        if not storage_class.allow_volume_expansion:
+            logger.warning(f"StorageClass '{storage_class_name}' does not allow volume expansion.")
+            await recorder.warning(
+                reference,
+                REASON_EXPANSION_NOT_SUPPORTED,
+                f"StorageClass '{storage_class_name}' does not allow expanding the home "
+                f"volume from {current} to {requested}.",
+            )
+            return
+
+    try:
+        await asyncio.to_thread(
+            core_v1.patch_namespaced_persistent_volume_claim,
+            name=pvc_name,
+            namespace=namespace,
+            body={"spec": {"resources": {"requests": {"storage": requested}}}},
+        )
+    except client.ApiException as e:
+        # e.g. statically provisioned volumes, which cannot be expanded
+        if e.status not in (403, 422):
+            raise
+        logger.warning(f"Could not expand PVC '{pvc_name}': {e.reason}")
+        await recorder.warning(
+            reference,
+            REASON_EXPANSION_NOT_SUPPORTED,
+            f"The home volume cannot be expanded to {requested}: {e.reason}",
+        )
+        return
+
+    logger.info(f"Expanding PVC '{pvc_name}' from {current} to {requested}.")
+    await recorder.normal(
+        reference, REASON_RESIZING, f"Expanding the home volume from {current} to {requested}."
+    )
+
+
+def compute_home_volume_condition(
+    spec: Mapping[str, Any],
+    pvc: Optional[client.V1PersistentVolumeClaim],
+    previous_conditions: Optional[List[Dict[str, Any]]] = None,
+) -> Optional[Dict[str, Any]]:
+    """
+    Compute the `HomeVolumeResized` condition, which tracks the progress of
+    growing the home volume.
+
+    It only appears once the requested size differs from the PVC's capacity,
+    and stays (as True) after the resize completed.
+    """
+    requested = _requested_size(spec)
+    if requested is None or pvc is None:
+        return None
+    capacity = _pvc_capacity(pvc)
+    current = _pvc_request(pvc)
+    if capacity is None or current is None:
+        return None
+
+    def condition(status: bool, reason: str, message: str) -> Dict[str, Any]:
+        return {
+            "type": CONDITION_HOME_VOLUME_RESIZED,
+            "status": "True" if status else "False",
+            "reason": reason,
+            "message": message,
+        }
+
+    if parse_quantity(capacity) >= parse_quantity(requested):
+        if parse_quantity(requested) < parse_quantity(current):
+            return condition(
+                False,
+                REASON_SHRINK_NOT_SUPPORTED,
+                f"The home volume is {capacity} and cannot shrink to {requested}.",
+            )
+        previously_resizing = any(
+            c.get("type") == CONDITION_HOME_VOLUME_RESIZED for c in previous_conditions or []
+        )
+        if not previously_resizing:
+            return None
+        return condition(True, REASON_RESIZED, f"The home volume was expanded to {capacity}.")
+
+    if parse_quantity(current) < parse_quantity(requested):
+        return condition(
+            False,
+            REASON_EXPANSION_NOT_SUPPORTED,
+            f"The home volume is {capacity} and its StorageClass "
+            f"'{pvc.spec.storage_class_name}' does not allow expanding it to {requested}.",
+        )
+
+    pvc_condition_types = {c.type for c in (pvc.status.conditions or []) if c.status == "True"}
+    if REASON_FILE_SYSTEM_RESIZE_PENDING in pvc_condition_types:
+        return condition(
+            False,
+            REASON_FILE_SYSTEM_RESIZE_PENDING,
+            f"The home volume was expanded to {requested}; its file system is resized "
+            "once the node mounts it again, or on the next restart of the pod.",
+        )
+    return condition(
+        False, REASON_RESIZING, f"Expanding the home volume from {capacity} to {requested}."
+    )
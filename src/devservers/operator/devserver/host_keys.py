@@ -1,34 +1,50 @@
 """
 SSH host key generation and management.
+
+Host keys are generated once per DevServer and stored in the
+`<name>-host-keys` Secret, which is mounted into the pod on every (re)start.
+This keeps the server's identity stable when the pod is rescheduled, and the
+public keys are published in the DevServer status so clients can pin them.
 """
 import asyncio
 import base64
 import logging
 import os
 import tempfile
-from typing import Dict, Any
+from typing import Dict, Any, List, Mapping, Optional
 
 from kubernetes import client
 
+from .adoption import needs_adoption
+
+HOST_KEY_TYPES = ["rsa", "ecdsa", "ed25519"]
+
+
+def host_keys_secret_name(name: str) -> str:
+    return f"{name}-host-keys"
+
+
+def _private_key_name(key_type: str) -> str:
+    return f"ssh_host_{key_type}_key"
 
-async def generate_host_keys() -> Dict[str, str]:
+
+async def generate_host_keys(key_types: Optional[List[str]] = None) -> Dict[str, str]:
     """
     Generate SSH host keys in a temporary directory.
 
+    Args:
+        key_types: Key types to generate, defaults to all of HOST_KEY_TYPES.
+
     Returns:
-        A dictionary mapping key names to base64-encoded key contents.
-        Keys include both private keys and public keys (.pub suffix).
+        A dictionary mapping key names to key contents, suitable for a
+        Secret's `stringData`. Keys include both private keys and public
+        keys (.pub suffix).
     """
-    # TODO: Consider using the `stringData` field in the Secret spec instead
-    # of manually base64-encoding. Kubernetes will automatically encode
-    # stringData values, avoiding potential double-encoding issues.
-
     with tempfile.TemporaryDirectory() as temp_dir:
-        key_types = ["rsa", "ecdsa", "ed25519"]
         key_data = {}
 
-        for key_type in key_types:
-            private_key_path = os.path.join(temp_dir, f"ssh_host_{key_type}_key")
+        for key_type in key_types or HOST_KEY_TYPES:
+            private_key_path = os.path.join(temp_dir, _private_key_name(key_type))
             public_key_path = f"{private_key_path}.pub"
 
             process = await asyncio.create_subprocess_exec(
@@ -37,77 +53,152 @@ async def generate_host_keys() -> Dict[str, str]:
             await process.wait()
 
             with open(private_key_path, "r") as f:
-                key_data[f"ssh_host_{key_type}_key"] = base64.b64encode(
-                    f.read().encode("utf-8")
-                ).decode("utf-8")
+                key_data[_private_key_name(key_type)] = f.read()
             with open(public_key_path, "r") as f:
-                key_data[f"ssh_host_{key_type}_key.pub"] = base64.b64encode(
-                    f.read().encode("utf-8")
-                ).decode("utf-8")
+                key_data[f"{_private_key_name(key_type)}.pub"] = f.read()
 
     return key_data
 
 
+def _missing_key_types(data: Mapping[str, str]) -> List[str]:
+    return [
+        key_type
+        for key_type in HOST_KEY_TYPES
+        if _private_key_name(key_type) not in data
+        or f"{_private_key_name(key_type)}.pub" not in data
+    ]
+
+
+def public_host_keys(data: Mapping[str, str]) -> List[str]:
+    """
+    Extract the public host keys from a host key Secret's (base64) data.
+
+    Returns:
+        A list of `<type> <base64-key>` strings, without the key comment,
+        in the format used by known_hosts files.
+    """
+    keys = []
+    for key_type in HOST_KEY_TYPES:
+        encoded = data.get(f"{_private_key_name(key_type)}.pub")
+        if not encoded:
+            continue
+        fields = base64.b64decode(encoded).decode("utf-8").split()
+        if len(fields) >= 2:
+            keys.append(f"{fields[0]} {fields[1]}")
+    return keys
+
+
 async def ensure_host_keys_secret(
     name: str,
     namespace: str,
-    owner_meta: Dict[str, Any],
+    owner_meta: Optional[Dict[str, Any]],
     logger: logging.Logger,
-) -> None:
+) -> List[str]:
     """
     Ensure that a Secret containing SSH host keys exists.
-    If it does not exist, generate keys and create the Secret.
+
+    If it does not exist, generate keys and create the Secret. If it exists
+    but is missing some key types, only the missing keys are generated; keys
+    that already exist are never rotated.
 
     Args:
-        name: Name of the DevServer (used to generate secret name)
+        name: Name of the DevServer, or other owner (used to generate secret name)
         namespace: Namespace for the secret
-        owner_meta: Metadata of the parent DevServer for owner reference
+        owner_meta: Metadata of the parent DevServer for owner reference, or
+            None for a Secret that outlives its user (e.g. the bastion's)
         logger: Logger instance
+
+    Returns:
+        The public host keys, as returned by `public_host_keys`.
     """
     # TODO: This function should be called early in the reconciliation flow,
     # after all validation passes. Currently it's called after TTL validation
     # and flavor fetching, which means if key generation fails, we've already
     # done unnecessary work. Consider reordering operations.
 
-    secret_name = f"{name}-host-keys"
+    secret_name = host_keys_secret_name(name)
     core_v1 = client.CoreV1Api()
 
     try:
-        await asyncio.to_thread(
+        secret = await asyncio.to_thread(
             core_v1.read_namespaced_secret, name=secret_name, namespace=namespace
         )
-        logger.info(f"Host key Secret '{secret_name}' already exists.")
-        return
     except client.ApiException as e:
         if e.status != 404:
             raise
+        secret = None
+
+    owner_references = []
+    if owner_meta:
+        owner_references = [
+            {
+                "apiVersion": f"{owner_meta['apiVersion']}",
+                "kind": owner_meta["kind"],
+                "name": owner_meta["name"],
+                "uid": owner_meta["uid"],
+                "controller": True,
+                "blockOwnerDeletion": True,
+            }
+        ]
+
+    if secret is not None:
+        existing = core_v1.api_client.sanitize_for_serialization(secret)
+        body = {
+            "kind": "Secret",
+            "metadata": {"name": secret_name, "ownerReferences": owner_references},
+        }
+        if needs_adoption(existing, body):
+            # Left behind, e.g. by a reinstall; its keys are kept so the host identity is unchanged
+            logger.info(f"Adopting existing host key Secret '{secret_name}'.")
+            secret = await asyncio.to_thread(
+                core_v1.patch_namespaced_secret,
+                name=secret_name,
+                namespace=namespace,
+                body={
+                    "metadata": {
+                        "ownerReferences": [
+                            *(existing["metadata"].get("ownerReferences") or []),
+                            *owner_references,
+                        ]
+                    }
+                },
+            )
+
+        data = secret.data or {}
+        missing = _missing_key_types(data)
+        if not missing:
+            logger.info(f"Host key Secret '{secret_name}' already exists.")
+            return public_host_keys(data)
+
+        logger.info(f"Host key Secret '{secret_name}' is missing {missing} keys. Generating...")
+        key_data = await generate_host_keys(missing)
+        patched = await asyncio.to_thread(
+            core_v1.patch_namespaced_secret,
+            name=secret_name,
+            namespace=namespace,
+            body={"stringData": key_data},
+        )
+        return public_host_keys(patched.data or {})
 
     logger.info(f"Host key Secret '{secret_name}' not found. Generating keys...")
 
     key_data = await generate_host_keys()
 
-    secret_body = {
+    secret_body: Dict[str, Any] = {
         "apiVersion": "v1",
         "kind": "Secret",
         "metadata": {
             "name": secret_name,
             "namespace": namespace,
-            "ownerReferences": [
-                {
-                    "apiVersion": f"{owner_meta['apiVersion']}",
-                    "kind": owner_meta["kind"],
-                    "name": owner_meta["name"],
-                    "uid": owner_meta["uid"],
-                    "controller": True,
-                    "blockOwnerDeletion": True,
-                }
-            ],
         },
         "type": "Opaque",
-        "data": key_data,
+        "stringData": key_data,
     }
+    if owner_references:
+        secret_body["metadata"]["ownerReferences"] = owner_references
 
-    await asyncio.to_thread(
+    created = await asyncio.to_thread(
         core_v1.create_namespaced_secret, namespace=namespace, body=secret_body
     )
     logger.info(f"Host key Secret '{secret_name}' created.")
+    return public_host_keys(created.data or {})
@@ -0,0 +1,155 @@
+"""
+Browser-based editing of DevServers with code-server.
+
+`spec.ide: code-server` adds a code-server sidecar sharing the home volume,
+behind the `<name>-ide` Service. When `DEVSERVER_IDE_DOMAIN` is set, an
+Ingress exposes it at `<name>.<namespace>.<domain>`, and its URL is published
+in `status.ide`. code-server asks for the password the operator generates in
+the `<name>-ide` Secret; the Ingress's annotations can add single sign-on.
+"""
+import asyncio
+import json
+import logging
+import os
+import secrets
+from dataclasses import dataclass, field
+from typing import Any, Dict, List, Mapping, Optional
+
+from kubernetes import client
+
+from .resources.ide import (
+    IDE_CODE_SERVER,
+    IDE_PASSWORD_KEY,
+    build_ide_ingress,
+    ide_hostname,
+    ide_name,
+)
+
+DEFAULT_CODE_SERVER_IMAGE = "codercom/code-server:4.93.1"
+
+
+def _split(value: str) -> List[str]:
+    return [item.strip() for item in value.split(",") if item.strip()]
+
+
+@dataclass(frozen=True)
+class IDEConfig:
+    code_server_image: str = DEFAULT_CODE_SERVER_IMAGE
+    domain: Optional[str] = None
+    ingress_class_name: Optional[str] = None
+    ingress_annotations: Dict[str, str] = field(default_factory=dict)
+    tls_secret_name: Optional[str] = None
+    # Namespaces of the ingress controller, let through isolated DevServers' NetworkPolicies
+    ingress_namespaces: List[str] = field(default_factory=list)
+
+    def build_ingress(self, name: str, namespace: str) -> Optional[Dict[str, Any]]:
+        """The Ingress of a DevServer's IDE, or None without a domain."""
+        if not self.domain:
+            return None
+        return build_ide_ingress(
+            name,
+            namespace,
+            ide_hostname(name, namespace, self.domain),
+            ingress_class_name=self.ingress_class_name,
+            annotations=self.ingress_annotations,
+            tls_secret_name=self.tls_secret_name,
+        )
+
+    def url(self, name: str, namespace: str) -> Optional[str]:
+        """The URL of a DevServer's IDE, or None without a domain."""
+        if not self.domain:
+            return None
+        scheme = "https" if self.tls_secret_name else "http"
+        return f"{scheme}://{ide_hostname(name, namespace, self.domain)}/"
+
+
+def load_ide_config(environ: Mapping[str, str] = os.environ) -> IDEConfig:
+    """
+    Read the IDE configuration from the environment.
+
+    Raises:
+        ValueError: If `DEVSERVER_IDE_INGRESS_ANNOTATIONS` is not a JSON object.
+    """
+    annotations = json.loads(environ.get("DEVSERVER_IDE_INGRESS_ANNOTATIONS") or "{}")
+    if not isinstance(annotations, dict):
+        raise ValueError("DEVSERVER_IDE_INGRESS_ANNOTATIONS must be a JSON object.")
+    return IDEConfig(
+        code_server_image=environ.get("DEVSERVER_CODE_SERVER_IMAGE", DEFAULT_CODE_SERVER_IMAGE),
+        domain=environ.get("DEVSERVER_IDE_DOMAIN") or None,
+        ingress_class_name=environ.get("DEVSERVER_IDE_INGRESS_CLASS") or None,
+        ingress_annotations={str(k): str(v) for k, v in annotations.items()},
+        tls_secret_name=environ.get("DEVSERVER_IDE_TLS_SECRET") or None,
+        ingress_namespaces=_split(environ.get("DEVSERVER_IDE_INGRESS_NAMESPACES", "")),
+    )
+
+
+IDE_CONFIG = load_ide_config()
+
+
+def ide_requested(spec: Mapping[str, Any]) -> bool:
+    """Whether the DevServer gets a code-server sidecar."""
+    return spec.get("ide") == IDE_CODE_SERVER
+
+
+def ide_status(devserver: Mapping[str, Any]) -> Optional[Dict[str, Any]]:
+    """Where the DevServer's IDE is reachable, and its password Secret, if it has one."""
+    if not ide_requested(devserver["spec"]):
+        return None
+    name = devserver["metadata"]["name"]
+    return {
+        "url": IDE_CONFIG.url(name, devserver["metadata"]["namespace"]),
+        "service": ide_name(name),
+        "passwordSecret": ide_name(name),
+    }
+
+
+async def ensure_ide_password_secret(
+    name: str,
+    namespace: str,
+    owner_meta: Dict[str, Any],
+    logger: logging.Logger,
+) -> None:
+    """
+    Create the Secret holding the code-server password of a DevServer, if it
+    does not exist yet. An existing password is never rotated.
+    """
+    secret_name = ide_name(name)
+    core_v1 = client.CoreV1Api()
+    try:
+        await asyncio.to_thread(
+            core_v1.read_namespaced_secret, name=secret_name, namespace=namespace
+        )
+        return
+    except client.ApiException as e:
+        if e.status != 404:
+            raise
+
+    logger.info(f"IDE Secret '{secret_name}' not found. Generating a password...")
+    secret_body = {
+        "apiVersion": "v1",
+        "kind": "Secret",
+        "metadata": {
+            "name": secret_name,
+            "namespace": namespace,
+            "ownerReferences": [
+                {
+                    "apiVersion": owner_meta["apiVersion"],
+                    "kind": owner_meta["kind"],
+                    "name": owner_meta["name"],
+                    "uid": owner_meta["uid"],
+                    "controller": True,
+                    "blockOwnerDeletion": True,
+                }
+            ],
+        },
+        "type": "Opaque",
+        "stringData": {IDE_PASSWORD_KEY: secrets.token_urlsafe(24)},
+    }
+    try:
+        await asyncio.to_thread(
+            core_v1.create_namespaced_secret, namespace=namespace, body=secret_body
+        )
+    except client.ApiException as e:
+        # Created by a concurrent reconcile
+        if e.status != 409:
+            raise
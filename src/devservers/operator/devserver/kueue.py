@@ -0,0 +1,119 @@
+"""
+Admission queueing of DevServers through Kueue.
+
+DevServers of a flavor with `spec.kueue.queueName` have their StatefulSet
+labeled with the LocalQueue, so that Kueue's StatefulSet integration gates
+their pods and creates a Workload for them. The pods are only scheduled once
+Kueue admits the Workload, which shares the queue's quota fairly among the
+DevServers and other workloads waiting for it. Until then, the Workload's
+position in the queue is reflected into the DevServer's status.
+"""
+import asyncio
+from typing import Any, Dict, Mapping, Optional
+
+from kubernetes import client
+
+KUEUE_GROUP = "kueue.x-k8s.io"
+KUEUE_VERSION = "v1beta1"
+KUEUE_VISIBILITY_GROUP = "visibility.kueue.x-k8s.io"
+KUEUE_VISIBILITY_VERSION = "v1beta1"
+QUEUE_NAME_LABEL = f"{KUEUE_GROUP}/queue-name"
+
+
+def get_queue_name(flavor: Mapping[str, Any]) -> Optional[str]:
+    """The LocalQueue DevServers of the flavor are queued in, if any."""
+    return flavor.get("spec", {}).get("kueue", {}).get("queueName")
+
+
+def _owned_by_devserver(workload: Mapping[str, Any], name: str) -> bool:
+    return any(
+        (owner.get("kind"), owner.get("name")) in (("Pod", f"{name}-0"), ("StatefulSet", name))
+        for owner in workload.get("metadata", {}).get("ownerReferences", [])
+    )
+
+
+def workload_admitted(workload: Mapping[str, Any]) -> bool:
+    """Whether Kueue admitted the Workload, letting its pods be scheduled."""
+    return any(
+        condition.get("type") == "Admitted" and condition.get("status") == "True"
+        for condition in workload.get("status", {}).get("conditions", [])
+    )
+
+
+async def find_workload(name: str, namespace: str) -> Optional[Dict[str, Any]]:
+    """The Workload Kueue created for the DevServer's pod, if any."""
+    try:
+        workloads = await asyncio.to_thread(
+            client.CustomObjectsApi().list_namespaced_custom_object,
+            group=KUEUE_GROUP,
+            version=KUEUE_VERSION,
+            namespace=namespace,
+            plural="workloads",
+        )
+    except client.ApiException as e:
+        # Kueue is not installed
+        if e.status == 404:
+            return None
+        raise
+    return next((w for w in workloads["items"] if _owned_by_devserver(w, name)), None)
+
+
+def _list_pending_workloads(namespace: str, queue_name: str) -> Dict[str, Any]:
+    api_client = client.CustomObjectsApi().api_client
+    return api_client.call_api(
+        f"/apis/{KUEUE_VISIBILITY_GROUP}/{KUEUE_VISIBILITY_VERSION}"
+        "/namespaces/{namespace}/localqueues/{name}/pendingworkloads",
+        "GET",
+        path_params={"namespace": namespace, "name": queue_name},
+        header_params={"Accept": "application/json"},
+        response_type="object",
+        auth_settings=["BearerToken"],
+        _return_http_data_only=True,
+    )
+
+
+async def get_queue_positions(
+    namespace: str, queue_name: str, workload_name: str
+) -> Dict[str, Optional[int]]:
+    """
+    The Workload's positions in its LocalQueue and ClusterQueue, from Kueue's
+    visibility API. They are None if the API is not served or the Workload is
+    not pending.
+    """
+    positions: Dict[str, Optional[int]] = {
+        "positionInLocalQueue": None,
+        "positionInClusterQueue": None,
+    }
+    try:
+        pending = await asyncio.to_thread(_list_pending_workloads, namespace, queue_name)
+    except client.ApiException as e:
+        if e.status in (403, 404, 503):
+            return positions
+        raise
+    for item in pending.get("items", []):
+        if item.get("metadata", {}).get("name") == workload_name:
+            for key in positions:
+                positions[key] = item.get(key)
+    return positions
+
+
+async def observe_queue(name: str, namespace: str, queue_name: str) -> Dict[str, Any]:
+    """
+    The DevServer's place in its Kueue queue: the LocalQueue, the Workload,
+    whether it is admitted and, while it is pending, its positions.
+    """
+    queue: Dict[str, Any] = {
+        "queueName": queue_name,
+        "workload": None,
+        "admitted": False,
+        "positionInLocalQueue": None,
+        "positionInClusterQueue": None,
+    }
+    workload = await find_workload(name, namespace)
+    if workload is None:
+        return queue
+    queue["workload"] = workload["metadata"]["name"]
+    queue["admitted"] = workload_admitted(workload)
+    if not queue["admitted"]:
+        queue.update(await get_queue_positions(namespace, queue_name, queue["workload"]))
+    return queue
@@ -3,20 +3,31 @@ DevServer lifecycle management, including TTL expiration handling.
 """
 import asyncio
 import logging
-from datetime import datetime, timezone
+from datetime import datetime, timedelta, timezone
+from typing import Optional
 
 from kubernetes import client
 
-from devservers.utils.time import parse_duration
+from devservers.utils.time import format_duration, parse_duration
+from .. import notifications
+from ..events import EventRecorder, object_reference
 from ...crds.const import CRD_GROUP, CRD_VERSION, CRD_PLURAL_DEVSERVER
 
 
 async def check_and_expire_devservers(
-    custom_objects_api: client.CustomObjectsApi, logger: logging.Logger
+    custom_objects_api: client.CustomObjectsApi,
+    logger: logging.Logger,
+    recorder: Optional[EventRecorder] = None,
+    expiry_warning_seconds: int = 0,
+    interval_seconds: int = 60,
 ) -> int:
     """
     Scans for and deletes expired DevServers in a single pass.
 
+    If a recorder is given, an `ExpiringSoon` event is recorded once for
+    each DevServer as it crosses into the last `expiry_warning_seconds` of
+    its TTL, and an `Expired` event right before it is deleted.
+
     Returns:
         The number of expired DevServers that were deleted.
     """
@@ -33,8 +44,12 @@ async def check_and_expire_devservers(
 
     for ds in devservers["items"]:
         if is_expired(ds, logger):
-            delete_tasks.append(_delete_devserver(ds, custom_objects_api, logger))
+            delete_tasks.append(_delete_devserver(ds, custom_objects_api, logger, recorder))
             expired_count += 1
+        elif recorder is not None and expiry_warning_seconds > 0:
+            await _warn_if_expiring_soon(
+                ds, recorder, expiry_warning_seconds, interval_seconds, logger
+            )
 
     if delete_tasks:
         await asyncio.gather(*delete_tasks)
@@ -47,6 +62,8 @@ async def cleanup_expired_devservers(
     custom_objects_api: client.CustomObjectsApi,
     logger: logging.Logger,
     interval_seconds: int = 60,
+    recorder: Optional[EventRecorder] = None,
+    expiry_warning_seconds: int = 0,
 ) -> None:
     """
     Periodically scan for and delete expired DevServers.
@@ -57,6 +74,8 @@ async def cleanup_expired_devservers(
         custom_objects_api: Kubernetes custom objects API client
         logger: Logger instance
         interval_seconds: How often to run expiration checks (default: 60s)
+        recorder: Optional event recorder for expiry events
+        expiry_warning_seconds: How long before expiry to warn (0 disables)
     """
     # TODO: This polling-based approach lists ALL DevServers cluster-wide every
     # 60 seconds. This doesn't scale well. Consider alternatives:
@@ -75,7 +94,13 @@ async def cleanup_expired_devservers(
 
     while True:
         try:
-            await check_and_expire_devservers(custom_objects_api, logger)
+            await check_and_expire_devservers(
+                custom_objects_api,
+                logger,
+                recorder=recorder,
+                expiry_warning_seconds=expiry_warning_seconds,
+                interval_seconds=interval_seconds,
+            )
         except client.ApiException as e:
             logger.error(f"API error during expiration check: {e}")
         except Exception as e:
@@ -87,6 +112,27 @@ async def cleanup_expired_devservers(
         await asyncio.sleep(interval_seconds)
 
 
+def get_expiration_time(devserver: dict) -> Optional[datetime]:
+    """
+    Compute when a DevServer expires based on its creation time and TTL.
+
+    Args:
+        devserver: The DevServer object from the Kubernetes API.
+
+    Returns:
+        The expiration time, or None if the DevServer has no TTL.
+
+    Raises:
+        KeyError, TypeError, ValueError: If the object is malformed.
+    """
+    ttl_str = devserver["spec"].get("lifecycle", {}).get("timeToLive")
+    if not ttl_str:
+        return None
+
+    creation_timestamp = datetime.fromisoformat(devserver["metadata"]["creationTimestamp"])
+    return creation_timestamp + parse_duration(ttl_str)
+
+
 def is_expired(devserver: dict, logger: logging.Logger) -> bool:
     """
     Check if a DevServer has expired based on its TTL.
@@ -99,15 +145,9 @@ def is_expired(devserver: dict, logger: logging.Logger) -> bool:
         True if the DevServer is expired, False otherwise.
     """
     try:
-        creation_timestamp_str = devserver["metadata"]["creationTimestamp"]
-        ttl_str = devserver["spec"].get("lifecycle", {}).get("timeToLive")
-
-        if not ttl_str:
+        expiration_time = get_expiration_time(devserver)
+        if expiration_time is None:
             return False
-
-        creation_timestamp = datetime.fromisoformat(creation_timestamp_str)
-        ttl_delta = parse_duration(ttl_str)
-        expiration_time = creation_timestamp + ttl_delta
         return datetime.now(timezone.utc) > expiration_time
 
     except (KeyError, TypeError, ValueError) as e:
@@ -116,8 +156,50 @@ def is_expired(devserver: dict, logger: logging.Logger) -> bool:
         return False
 
 
+def _devserver_reference(ds: dict) -> dict:
+    """Build an event reference for a DevServer list item."""
+    return object_reference(
+        {"apiVersion": f"{CRD_GROUP}/{CRD_VERSION}", "kind": "DevServer", **ds}
+    )
+
+
+async def _warn_if_expiring_soon(
+    ds: dict,
+    recorder: EventRecorder,
+    expiry_warning_seconds: int,
+    interval_seconds: int,
+    logger: logging.Logger,
+) -> None:
+    """
+    Record an `ExpiringSoon` event when a DevServer enters its warning window,
+    and notify its owner.
+
+    The check is stateless: the event is only recorded on the pass where the
+    remaining time first drops below `expiry_warning_seconds`.
+    """
+    try:
+        expiration_time = get_expiration_time(ds)
+    except (KeyError, TypeError, ValueError):
+        return
+    if expiration_time is None:
+        return
+
+    remaining = expiration_time - datetime.now(timezone.utc)
+    window = timedelta(seconds=expiry_warning_seconds)
+    if window - timedelta(seconds=interval_seconds) < remaining <= window:
+        message = (
+            f"DevServer will expire in {format_duration(remaining)} "
+            f"(at {expiration_time.strftime('%Y-%m-%dT%H:%M:%SZ')})."
+        )
+        await recorder.warning(_devserver_reference(ds), "ExpiringSoon", message)
+        await notifications.notify(ds, notifications.EVENT_EXPIRING_SOON, message, logger)
+
+
 async def _delete_devserver(
-    ds: dict, custom_objects_api: client.CustomObjectsApi, logger: logging.Logger
+    ds: dict,
+    custom_objects_api: client.CustomObjectsApi,
+    logger: logging.Logger,
+    recorder: Optional[EventRecorder] = None,
 ) -> None:
     """
     Delete an expired DevServer.
@@ -126,10 +208,10 @@ async def _delete_devserver(
         ds: The DevServer custom object
         custom_objects_api: Kubernetes custom objects API client
         logger: Logger instance
+        recorder: Optional event recorder for the `Expired` event
     """
     # TODO: Consider updating the DevServer status to "Expiring" before deletion
-    # to give users visibility into why it was deleted. Could also emit a
-    # Kubernetes Event for audit trail.
+    # to give users visibility into why it was deleted.
 
     # TODO: Add graceful deletion options:
     #   - Allow users to configure grace periods
@@ -143,6 +225,10 @@ async def _delete_devserver(
     logger.info(
         f"DevServer '{name}' in namespace '{namespace}' has expired. Deleting."
     )
+    if recorder is not None:
+        await recorder.normal(
+            _devserver_reference(ds), "Expired", "DevServer TTL has elapsed; deleting it."
+        )
 
     try:
         await asyncio.to_thread(
@@ -0,0 +1,77 @@
+"""
+Operator-level policy for the names of DevServers.
+
+A DevServer's name is reused for its children, some of which are stricter
+than the DevServer itself: its Services need a DNS-1035 label, and the
+StatefulSet's pods get a `<name>-<hash>` controller-revision-hash label,
+which leaves 52 characters for the name. Names, including those generated
+from `metadata.generateName`, are checked against these limits when the
+DevServer is created, and against the operator's policy:
+
+- `DEVSERVER_NAME_MAX_LENGTH`: a maximum length shorter than 52.
+- `DEVSERVER_NAME_OWNER_PREFIX`: names must start with the owner's short
+  name, e.g. `alice-` for alice@example.com, so that owners sharing a
+  namespace cannot collide.
+"""
+import os
+import re
+from dataclasses import dataclass
+from typing import Any, Mapping
+
+from ...crds.const import MAX_DEVSERVER_NAME_LENGTH
+from ...utils.users import owner_to_dns_label
+
+# Services' names must be DNS-1035 labels, which start with a letter
+NAME_REGEX = re.compile(r"^[a-z]([-a-z0-9]*[a-z0-9])?$")
+
+
+@dataclass(frozen=True)
+class NamingPolicy:
+    max_length: int = MAX_DEVSERVER_NAME_LENGTH
+    owner_prefix: bool = False
+
+
+def load_naming_policy(environ: Mapping[str, str] = os.environ) -> NamingPolicy:
+    """Read the operator's naming policy from the environment."""
+    max_length = int(environ.get("DEVSERVER_NAME_MAX_LENGTH", MAX_DEVSERVER_NAME_LENGTH))
+    if not 0 < max_length <= MAX_DEVSERVER_NAME_LENGTH:
+        raise ValueError(
+            f"DEVSERVER_NAME_MAX_LENGTH must be between 1 and {MAX_DEVSERVER_NAME_LENGTH}, "
+            f"not {max_length}."
+        )
+    return NamingPolicy(
+        max_length=max_length,
+        owner_prefix=environ.get("DEVSERVER_NAME_OWNER_PREFIX", "false").lower() == "true",
+    )
+
+
+NAMING_POLICY = load_naming_policy()
+
+
+def owner_short_name(owner: str) -> str:
+    """The short name of an owner, e.g. 'alice' for 'alice@example.com'."""
+    return owner_to_dns_label(owner.split("@")[0])
+
+
+def validate_user_name(name: str, spec: Mapping[str, Any], policy: NamingPolicy) -> None:
+    """
+    Check that a new DevServer's name fits its children and the naming policy.
+
+    Raises:
+        ValueError: If it is not a DNS-1035 label, is too long, or does not
+            start with the owner's short name when the policy asks for it.
+    """
+    if not NAME_REGEX.match(name):
+        raise ValueError(
+            f"'{name}' must consist of lowercase letters, digits and '-', start with a letter "
+            "and end with a letter or digit."
+        )
+    if len(name) > policy.max_length:
+        raise ValueError(
+            f"'{name}' is {len(name)} characters long, at most {policy.max_length} are allowed."
+        )
+    owner = spec.get("owner")
+    if policy.owner_prefix and owner:
+        prefix = f"{owner_short_name(owner)}-"
+        if not name.startswith(prefix):
+            raise ValueError(f"'{name}' must start with '{prefix}', after its owner '{owner}'.")
@@ -0,0 +1,73 @@
+"""
+Operator-level defaults for isolating DevServers with NetworkPolicies.
+
+With `DEVSERVER_NETWORK_POLICY_ENABLED`, or `spec.networkPolicy.enabled` on a
+single DevServer, its pod gets a NetworkPolicy that only lets SSH in from
+approved sources and only lets traffic out to DNS, the internet and the
+namespaces listed for east-west traffic. Each DevServer can override the
+operator's defaults for these in `spec.networkPolicy`.
+"""
+import os
+from dataclasses import dataclass, field
+from typing import Any, Dict, List, Mapping, Optional
+
+from .bastion import BASTION_ENABLED
+from .ide import IDE_CONFIG, ide_requested
+from .resources.bastion import BASTION_NAME
+from .resources.configmap import get_ssh_port
+from .resources.ide import CODE_SERVER_PORT
+from .resources.network_policy import build_network_policy
+from .resources.services import get_mosh_ports
+
+
+def _split(value: str) -> List[str]:
+    return [item.strip() for item in value.split(",") if item.strip()]
+
+
+@dataclass(frozen=True)
+class NetworkPolicyDefaults:
+    enabled: bool = False
+    ssh_cidrs: List[str] = field(default_factory=list)
+    ssh_namespaces: List[str] = field(default_factory=list)
+    egress_namespaces: List[str] = field(default_factory=list)
+
+
+def load_network_policy_defaults(environ: Mapping[str, str] = os.environ) -> NetworkPolicyDefaults:
+    """Read the operator's NetworkPolicy defaults from the environment."""
+    return NetworkPolicyDefaults(
+        enabled=environ.get("DEVSERVER_NETWORK_POLICY_ENABLED", "false").lower() == "true",
+        ssh_cidrs=_split(environ.get("DEVSERVER_NETWORK_POLICY_SSH_CIDRS", "")),
+        ssh_namespaces=_split(environ.get("DEVSERVER_NETWORK_POLICY_SSH_NAMESPACES", "")),
+        egress_namespaces=_split(environ.get("DEVSERVER_NETWORK_POLICY_EGRESS_NAMESPACES", "")),
+    )
+
+
+NETWORK_POLICY_DEFAULTS = load_network_policy_defaults()
+
+
+def build_devserver_network_policy(
+    name: str,
+    namespace: str,
+    spec: Mapping[str, Any],
+    defaults: NetworkPolicyDefaults = NETWORK_POLICY_DEFAULTS,
+) -> Optional[Dict[str, Any]]:
+    """
+    Builds the DevServer's NetworkPolicy, or returns None if it is not isolated.
+    Fields of `spec.networkPolicy` take precedence over the operator's defaults.
+    """
+    overrides = spec.get("networkPolicy", {})
+    if not overrides.get("enabled", defaults.enabled):
+        return None
+
+    return build_network_policy(
+        name,
+        namespace,
+        get_ssh_port(spec),
+        get_mosh_ports(spec),
+        overrides.get("sshCIDRs", defaults.ssh_cidrs),
+        overrides.get("sshNamespaces", defaults.ssh_namespaces),
+        overrides.get("egressNamespaces", defaults.egress_namespaces),
+        bastion_name=BASTION_NAME if BASTION_ENABLED else "",
+        ide_port=CODE_SERVER_PORT if ide_requested(spec) else None,
+        ide_namespaces=IDE_CONFIG.ingress_namespaces,
+    )
@@ -0,0 +1,132 @@
+"""
+Fills and checks `spec.owner` from the identity of the user creating a DevServer.
+
+When `DEVSERVER_OWNER_IDENTITY_ENABLED` is true, the operator serves a
+mutating admission webhook for DevServers. The API server tells it who makes
+each request, as authenticated by the cluster's OIDC/SSO, so that:
+
+- a DevServer created without an owner is owned by its creator,
+- a DevServer cannot be created for, or handed over to, someone else.
+
+This makes `spec.owner` trustworthy for per-owner RBAC and quotas. Members of
+`DEVSERVER_OWNER_ADMIN_GROUPS`, and the trusted requesters, i.e. the operator's
+own ServiceAccount and `DEVSERVER_OWNER_TRUSTED_REQUESTERS`, may set any owner,
+e.g. to create DevServers on behalf of others or bind claims.
+"""
+import base64
+import json
+import os
+from typing import Any, Dict, Iterable, List, Mapping, Optional
+
+from .owner_ids import find_owner_user
+
+
+def _split(value: str) -> List[str]:
+    return [item.strip() for item in value.split(",") if item.strip()]
+
+
+OWNER_IDENTITY_ENABLED = (
+    os.environ.get("DEVSERVER_OWNER_IDENTITY_ENABLED", "false").lower() == "true"
+)
+# The API server's --oidc-username-prefix, stripped to get the user's name
+OIDC_USERNAME_PREFIX = os.environ.get("DEVSERVER_OIDC_USERNAME_PREFIX", "")
+OWNER_ADMIN_GROUPS = _split(os.environ.get("DEVSERVER_OWNER_ADMIN_GROUPS", "system:masters"))
+
+SERVICE_ACCOUNT_TOKEN_FILE = "/var/run/secrets/kubernetes.io/serviceaccount/token"
+
+
+def operator_service_account(token_file: str = SERVICE_ACCOUNT_TOKEN_FILE) -> Optional[str]:
+    """
+    The user name the operator authenticates as in the cluster, e.g.
+    `system:serviceaccount:devservers:operator`, taken from the subject of its
+    ServiceAccount token. None when it does not run in a pod.
+    """
+    try:
+        with open(token_file) as f:
+            payload = f.read().strip().split(".")[1]
+        claims = json.loads(base64.urlsafe_b64decode(payload + "=" * (-len(payload) % 4)))
+    except (OSError, IndexError, ValueError):
+        return None
+    subject = claims.get("sub") if isinstance(claims, dict) else None
+    return subject if isinstance(subject, str) else None
+
+
+# Users that create DevServers for others themselves, e.g. for claims and sets
+OWNER_TRUSTED_REQUESTERS = [
+    requester
+    for requester in [
+        operator_service_account(),
+        *_split(os.environ.get("DEVSERVER_OWNER_TRUSTED_REQUESTERS", "")),
+    ]
+    if requester
+]
+
+
+def _find_subject_user(username: str, users: Iterable[Dict[str, Any]]) -> Optional[Dict[str, Any]]:
+    """The DevServerUser that lists the cluster user among its `rbac.subjects`."""
+    for user in users:
+        subjects = user.get("spec", {}).get("rbac", {}).get("subjects", [])
+        if any(s.get("kind") == "User" and s.get("name") == username for s in subjects):
+            return user
+    return None
+
+
+def requester_owners(
+    userinfo: Mapping[str, Any],
+    users: Iterable[Dict[str, Any]],
+    username_prefix: str = OIDC_USERNAME_PREFIX,
+) -> List[str]:
+    """
+    The owners the requesting user may create DevServers for, the one their
+    DevServers are given by default first. These are their DevServerUser's
+    username and email, and their own user name.
+    """
+    users = list(users)
+    username = userinfo.get("username", "")
+    name = username[len(username_prefix):] if username.startswith(username_prefix) else username
+    user = _find_subject_user(username, users) or find_owner_user(name, users)
+    owners = []
+    if user is not None:
+        owners += [user["spec"]["username"], user["spec"].get("email", "")]
+    owners.append(name)
+    return [owner for owner in dict.fromkeys(owners) if owner]
+
+
+def resolve_owner(
+    owner: Optional[str],
+    userinfo: Mapping[str, Any],
+    users: Iterable[Dict[str, Any]],
+    old_owner: Optional[str] = None,
+    updating: bool = False,
+    admin_groups: Iterable[str] = OWNER_ADMIN_GROUPS,
+    username_prefix: str = OIDC_USERNAME_PREFIX,
+    trusted_requesters: Iterable[str] = OWNER_TRUSTED_REQUESTERS,
+) -> Optional[str]:
+    """
+    Check the owner of a DevServer being created or updated against the
+    requesting user.
+
+    Returns:
+        The owner to fill in, or None to leave `spec.owner` as it is.
+
+    Raises:
+        ValueError: If the user may not give the DevServer this owner.
+    """
+    if set(userinfo.get("groups") or []) & set(admin_groups):
+        return None
+    if userinfo.get("username") in set(trusted_requesters):
+        return None
+    # Updates, e.g. by the operator or the owner, may not hand it to someone else
+    if updating:
+        if (owner or "").lower() != (old_owner or "").lower():
+            raise ValueError(f"spec.owner cannot be changed from '{old_owner or ''}'.")
+        return None
+
+    owners = requester_owners(userinfo, users, username_prefix)
+    if not owner:
+        return owners[0] if owners else None
+    if owner.lower() not in {o.lower() for o in owners}:
+        raise ValueError(
+            f"user '{userinfo.get('username', '')}' cannot create DevServers owned by '{owner}'."
+        )
+    return None
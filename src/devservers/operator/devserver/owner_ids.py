@@ -0,0 +1,68 @@
+"""
+POSIX user and group IDs of a DevServer's owner.
+
+A DevServerUser can pin the UID/GID its owner's files are created with, so
+that files on shared volumes such as EFS have the same ownership across all
+of that user's DevServers. DevServers are matched to a DevServerUser by
+`spec.owner`, which is compared to the user's `username` and `email`.
+"""
+import asyncio
+import logging
+from typing import Any, Dict, Iterable, NamedTuple, Optional
+
+from kubernetes import client
+
+from ...crds.const import CRD_GROUP, CRD_VERSION, CRD_PLURAL_DEVSERVERUSER
+
+
+class PosixIds(NamedTuple):
+    uid: int
+    gid: int
+
+
+# The dev user's IDs when the owner has none configured
+DEFAULT_POSIX_IDS = PosixIds(uid=1000, gid=1000)
+
+
+def find_owner_user(owner: str, users: Iterable[Dict[str, Any]]) -> Optional[Dict[str, Any]]:
+    """The owner's DevServerUser, matched by its username or email."""
+    owner = owner.lower()
+    for user in users:
+        user_spec = user.get("spec", {})
+        identities = {user_spec.get("username", "").lower(), user_spec.get("email", "").lower()}
+        if owner in identities:
+            return user
+    return None
+
+
+def find_owner_ids(owner: str, users: Iterable[Dict[str, Any]]) -> Optional[PosixIds]:
+    """The IDs configured by the owner's DevServerUser, if it sets them."""
+    user = find_owner_user(owner, users)
+    posix = (user or {}).get("spec", {}).get("posix")
+    if posix:
+        return PosixIds(uid=posix["uid"], gid=posix.get("gid", posix["uid"]))
+    return None
+
+
+async def resolve_owner_ids(
+    owner: Optional[str], logger: logging.Logger
+) -> Optional[PosixIds]:
+    """
+    Look up the IDs of a DevServer's owner.
+
+    Returns:
+        The owner's IDs, or None if the DevServer has no owner or the owner
+        has no IDs configured, in which case the defaults apply.
+    """
+    if not owner:
+        return None
+    users = await asyncio.to_thread(
+        client.CustomObjectsApi().list_cluster_custom_object,
+        group=CRD_GROUP,
+        version=CRD_VERSION,
+        plural=CRD_PLURAL_DEVSERVERUSER,
+    )
+    ids = find_owner_ids(owner, users["items"])
+    if ids is not None:
+        logger.debug(f"Owner '{owner}' runs as UID {ids.uid} and GID {ids.gid}.")
+    return ids
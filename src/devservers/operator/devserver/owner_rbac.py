@@ -0,0 +1,127 @@
+"""
+Per-owner RBAC, kept in sync with the owner's DevServers.
+
+When `DEVSERVER_OWNER_RBAC_ENABLED` is true, every owner with DevServers in a
+namespace gets a `devserver-owner-<owner>` Role and RoleBinding there, so that
+they can get, delete and SSH into their own DevServers and no one else's, and
+get and SSH into those listing them in `spec.collaborators`. They are
+regenerated whenever one of these DevServers is reconciled or deleted, and
+removed with the last of them in the namespace.
+
+`spec.owner` is mapped to the identities the owner authenticates to the
+cluster as through their DevServerUser's `spec.rbac.subjects`. Owners without
+them are bound as the user `DEVSERVER_OWNER_RBAC_USER_PREFIX` + owner, e.g.
+`oidc:alice@example.com`.
+"""
+import asyncio
+import logging
+import os
+from typing import Any, Dict, Iterable, List, Tuple
+
+from kubernetes import client
+
+from .apply import server_side_apply
+from .owner_ids import find_owner_user
+from .resources.owner_rbac import build_owner_role, build_owner_role_binding, owner_role_name
+from ..tracing import span
+from ...crds.const import (
+    CRD_GROUP,
+    CRD_PLURAL_DEVSERVER,
+    CRD_PLURAL_DEVSERVERUSER,
+    CRD_VERSION,
+)
+from ...utils.users import compute_user_namespace
+
+OWNER_RBAC_ENABLED = os.environ.get("DEVSERVER_OWNER_RBAC_ENABLED", "false").lower() == "true"
+OWNER_RBAC_USER_PREFIX = os.environ.get("DEVSERVER_OWNER_RBAC_USER_PREFIX", "")
+RBAC_API_GROUP = "rbac.authorization.k8s.io"
+
+
+def owner_subjects(
+    owner: str, users: Iterable[Dict[str, Any]], user_prefix: str = OWNER_RBAC_USER_PREFIX
+) -> List[Dict[str, Any]]:
+    """The RBAC subjects the owner of a DevServer authenticates as."""
+    default = [{"kind": "User", "apiGroup": RBAC_API_GROUP, "name": user_prefix + owner}]
+    user = find_owner_user(owner, users)
+    if user is None:
+        return default
+
+    user_spec = user["spec"]
+    subjects = [
+        {"apiGroup": RBAC_API_GROUP, **subject}
+        for subject in user_spec.get("rbac", {}).get("subjects", [])
+    ] or default
+    # The user's ServiceAccount, as bound to their default Role
+    subjects.append(
+        {
+            "kind": "ServiceAccount",
+            "name": f"{user_spec['username']}-sa",
+            "namespace": compute_user_namespace(user_spec["username"]),
+        }
+    )
+    return subjects
+
+
+async def _list_owned_devservers(namespace: str, owner: str) -> Tuple[List[str], List[str]]:
+    """The names of the DevServers the owner owns, and of those they collaborate on."""
+    custom_objects_api = client.CustomObjectsApi()
+    devservers = await asyncio.to_thread(
+        custom_objects_api.list_namespaced_custom_object,
+        group=CRD_GROUP,
+        version=CRD_VERSION,
+        plural=CRD_PLURAL_DEVSERVER,
+        namespace=namespace,
+    )
+    # Owners match case-insensitively, as they do their DevServerUser
+    owned, shared = [], []
+    for ds in devservers["items"]:
+        if ds["metadata"].get("deletionTimestamp"):
+            continue
+        spec = ds.get("spec", {})
+        if spec.get("owner", "").lower() == owner.lower():
+            owned.append(ds["metadata"]["name"])
+        elif owner.lower() in (c.lower() for c in spec.get("collaborators") or []):
+            shared.append(ds["metadata"]["name"])
+    return owned, shared
+
+
+async def _delete(delete, name: str, namespace: str) -> None:
+    try:
+        await asyncio.to_thread(delete, name=name, namespace=namespace)
+    except client.ApiException as e:
+        if e.status != 404:
+            raise
+
+
+async def reconcile_owner_rbac(namespace: str, owner: str, logger: logging.Logger) -> None:
+    """Bring the owner's Role and RoleBinding in line with their live DevServers."""
+    rbac_v1 = client.RbacAuthorizationV1Api()
+    name = owner_role_name(owner)
+
+    devserver_names, shared_names = await _list_owned_devservers(namespace, owner)
+    if not devserver_names and not shared_names:
+        with span("delete owner RBAC"):
+            await _delete(rbac_v1.delete_namespaced_role_binding, name, namespace)
+            await _delete(rbac_v1.delete_namespaced_role, name, namespace)
+        logger.info(f"Removed RBAC of owner '{owner}' from namespace '{namespace}'.")
+        return
+
+    with span("reconcile owner RBAC"):
+        users = await asyncio.to_thread(
+            client.CustomObjectsApi().list_cluster_custom_object,
+            group=CRD_GROUP,
+            version=CRD_VERSION,
+            plural=CRD_PLURAL_DEVSERVERUSER,
+        )
+        role = build_owner_role(namespace, owner, devserver_names, shared_names)
+        await server_side_apply(rbac_v1.patch_namespaced_role, role, namespace=namespace)
+        role_binding = build_owner_role_binding(
+            namespace, owner, owner_subjects(owner, users["items"])
+        )
+        await server_side_apply(
+            rbac_v1.patch_namespaced_role_binding, role_binding, namespace=namespace
+        )
+    logger.info(
+        f"RBAC of owner '{owner}' in namespace '{namespace}' synced with "
+        f"{len(devserver_names)} owned and {len(shared_names)} shared DevServer(s)."
+    )
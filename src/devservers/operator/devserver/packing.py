@@ -0,0 +1,31 @@
+"""
+Operator-level preference for packing DevServers onto shared nodes.
+
+The scheduler spreads pods across nodes by default, which leaves small CPU
+DevServers scattered over, and so keeping alive, expensive GPU nodes. With
+`DEVSERVER_PACKING_ENABLED`, pods of flavors without GPUs prefer the nodes of
+other such DevServers instead, so the autoscaler can remove the nodes they
+leave free. A flavor can opt in or out with `spec.packing`.
+"""
+import os
+from dataclasses import dataclass
+from typing import Mapping
+
+
+@dataclass(frozen=True)
+class PackingDefaults:
+    enabled: bool = False
+    topology_key: str = "kubernetes.io/hostname"
+    weight: int = 100
+
+
+def load_packing_defaults(environ: Mapping[str, str] = os.environ) -> PackingDefaults:
+    """Read the operator's packing preference from the environment."""
+    return PackingDefaults(
+        enabled=environ.get("DEVSERVER_PACKING_ENABLED", "false").lower() == "true",
+        topology_key=environ.get("DEVSERVER_PACKING_TOPOLOGY_KEY", "kubernetes.io/hostname"),
+        weight=int(environ.get("DEVSERVER_PACKING_WEIGHT", 100)),
+    )
+
+
+PACKING_DEFAULTS = load_packing_defaults()
@@ -0,0 +1,14 @@
+"""
+Operator-level switch for generating pods that comply with the restricted
+Pod Security Standard.
+
+With `DEVSERVER_RESTRICTED_POD_SECURITY`, every DevServer pod runs as its
+dev user, without privilege escalation or capabilities and under the
+runtime's default seccomp profile, so DevServers can live in namespaces that
+enforce `pod-security.kubernetes.io/enforce: restricted`.
+"""
+import os
+
+RESTRICTED_POD_SECURITY = (
+    os.environ.get("DEVSERVER_RESTRICTED_POD_SECURITY", "false").lower() == "true"
+)
@@ -0,0 +1,27 @@
+"""
+Filters for the DevServer update handler.
+
+Kopf already ignores changes to the status, which the operator makes itself.
+These filters also skip updates that cannot change a DevServer's resources,
+so that they do not cost a full reconcile and its API requests.
+"""
+from typing import Any, Mapping, Optional
+
+from .resources.metadata import propagated_metadata
+
+
+def devserver_changed(
+    old: Optional[Mapping[str, Any]], new: Optional[Mapping[str, Any]], **_: Any
+) -> bool:
+    """
+    Whether an update changes the DevServer's spec, or the labels and
+    annotations that `spec.metadataPropagation` copies onto its resources.
+    Other labels and annotations, e.g. ones set by other tools, are ignored.
+    """
+    old, new = old or {}, new or {}
+    if old.get("spec") != new.get("spec"):
+        return True
+    spec = new.get("spec") or {}
+    return propagated_metadata(spec, old.get("metadata") or {}) != propagated_metadata(
+        spec, new.get("metadata") or {}
+    )
@@ -0,0 +1,81 @@
+"""
+Named presets of a DevServer's environment with `spec.profile`.
+
+A DevServerProfile is a cluster-scoped preset that admins maintain of a
+flavor, image, shared volumes and env, so that a DevServer can ask for e.g.
+`pytorch-nightly-a100` with one field instead of four coordinated ones.
+
+Unlike templates, profiles are not copied onto the DevServer: they are
+resolved on every reconcile, so that changes to a profile reach its
+DevServers the next time they are reconciled. The DevServer's own fields
+override the profile's.
+"""
+import asyncio
+import logging
+from typing import Any, Dict, Mapping
+
+import kopf
+from kubernetes import client
+
+from ..events import EventRecorder
+from ...crds.const import CRD_GROUP, CRD_PLURAL_DEVSERVERPROFILE, CRD_VERSION
+
+
+def with_profile(spec: Mapping[str, Any], profile: Mapping[str, Any]) -> Dict[str, Any]:
+    """
+    The spec with the profile's fields resolved. The DevServer's flavor and
+    image replace the profile's, its env vars those with the same name, and
+    its shared volumes those at the same mountPath.
+    """
+    profile_spec = profile.get("spec", {})
+    resolved = dict(spec)
+    for field in ("flavor", "image"):
+        if not spec.get(field) and profile_spec.get(field):
+            resolved[field] = profile_spec[field]
+
+    if profile_spec.get("env"):
+        names = {env_var["name"] for env_var in spec.get("env", [])}
+        resolved["env"] = [
+            env_var for env_var in profile_spec["env"] if env_var["name"] not in names
+        ] + list(spec.get("env", []))
+
+    if profile_spec.get("sharedVolumes"):
+        mount_paths = {
+            shared_volume["mountPath"].rstrip("/")
+            for shared_volume in spec.get("sharedVolumes", [])
+        }
+        resolved["sharedVolumes"] = [
+            shared_volume
+            for shared_volume in profile_spec["sharedVolumes"]
+            if shared_volume["mountPath"].rstrip("/") not in mount_paths
+        ] + list(spec.get("sharedVolumes", []))
+    return resolved
+
+
+async def get_profile(
+    name: str,
+    logger: logging.Logger,
+    recorder: EventRecorder,
+    reference: Dict[str, Any],
+) -> Dict[str, Any]:
+    """
+    Read the DevServerProfile of `spec.profile`.
+
+    Raises:
+        kopf.PermanentError: If the profile does not exist.
+    """
+    try:
+        return await asyncio.to_thread(
+            client.CustomObjectsApi().get_cluster_custom_object,
+            group=CRD_GROUP,
+            version=CRD_VERSION,
+            plural=CRD_PLURAL_DEVSERVERPROFILE,
+            name=name,
+        )
+    except client.ApiException as e:
+        if e.status == 404:
+            message = f"DevServerProfile '{name}' not found."
+            logger.error(message)
+            await recorder.warning(reference, "ProfileNotFound", message)
+            raise kopf.PermanentError(message)
+        raise
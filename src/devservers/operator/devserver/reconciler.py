@@ -4,28 +4,99 @@ Kubernetes resource reconciliation for DevServer resources.
 import asyncio
 import logging
 import os
-from typing import Any, Dict
+from typing import Any, Callable, Dict, Mapping, Optional
 
 import kopf
 from kubernetes import client
 
+from ..events import EventRecorder
+from ..tracing import span
+
+from .adoption import needs_adoption
+from .agent import AGENT_ENABLED
+from .apply import is_up_to_date, server_side_apply, with_applied_hash
+from .gateway import SSH_GATEWAY
+from .ide import IDE_CONFIG, ide_requested
+from .lifecycle import get_expiration_time
+from .network_policy import build_devserver_network_policy
+from .owner_ids import PosixIds
+from .packing import PACKING_DEFAULTS
+from .pod_security import RESTRICTED_POD_SECURITY
+from .resources.agent import (
+    build_agent_report_configmap,
+    build_agent_role,
+    build_agent_role_binding,
+    build_agent_script_configmap,
+)
+from .resources.encryption import (
+    build_encrypted_storage_class,
+    encrypted_storage_class_name,
+    get_kms_key_id,
+    home_encryption_requested,
+)
 from .resources.configmap import build_configmap, build_startup_configmap, build_login_configmap
 from .resources.services import build_headless_service, build_ssh_service
+from .resources.gateway import GATEWAY_API_GROUP, GATEWAY_API_VERSION
+from .resources.ide import build_ide_service, ide_name
+from .resources.metadata import apply_metadata, propagated_metadata
+from .resources.motd import build_motd_configmap
+from .resources.network_policy import network_policy_name
+from .resources.service_account import (
+    build_role_binding,
+    build_service_account,
+    get_service_account_annotations,
+    service_account_name,
+)
+from .service_account import get_role_template
+from .spot import capacity_type_scheduling
 from .resources.statefulset import build_statefulset
 
+# Seconds before checking again for a missing encrypted StorageClass
+ENCRYPTED_STORAGE_CLASS_RETRY_INTERVAL = 60
+
 
 class DevServerReconciler:
     """
     Handles the creation and management of Kubernetes resources for DevServer.
     """
 
-    def __init__(self, name: str, namespace: str, spec: Dict[str, Any], flavor: Dict[str, Any]):
+    def __init__(
+        self,
+        name: str,
+        namespace: str,
+        spec: Dict[str, Any],
+        flavor: Dict[str, Any],
+        recorder: Optional[EventRecorder] = None,
+        reference: Optional[Dict[str, Any]] = None,
+        owner_ids: Optional[PosixIds] = None,
+        meta: Optional[Mapping[str, Any]] = None,
+        capacity_type: Optional[str] = None,
+    ):
         self.name = name
         self.namespace = namespace
         self.spec = spec
         self.flavor = flavor
+        self.owner_ids = owner_ids
+        self.meta = meta or {}
+        self.recorder = recorder
+        self.reference = reference
+        self.ssh_gateway = SSH_GATEWAY
+        self.restricted = RESTRICTED_POD_SECURITY
+        self.capacity_scheduling = capacity_type_scheduling(capacity_type)
+        self.packing = PACKING_DEFAULTS
+        self.ide = IDE_CONFIG
+        self.agent = AGENT_ENABLED
         self.core_v1 = client.CoreV1Api()
         self.apps_v1 = client.AppsV1Api()
+        self.networking_v1 = client.NetworkingV1Api()
+        self.rbac_v1 = client.RbacAuthorizationV1Api()
+        self.storage_v1 = client.StorageV1Api()
+        self.custom_objects_api = client.CustomObjectsApi()
+
+    async def _record_normal(self, reason: str, message: str) -> None:
+        """Record a Normal event against the DevServer, if a recorder is set."""
+        if self.recorder is not None and self.reference is not None:
+            await self.recorder.normal(self.reference, reason, message)
 
     def build_resources(self) -> Dict[str, Any]:
         """
@@ -36,13 +107,26 @@ class DevServerReconciler:
         """
         # Build services
         headless_service = build_headless_service(self.name, self.namespace)
-        ssh_service = build_ssh_service(self.name, self.namespace)
+        ssh_service = build_ssh_service(self.name, self.namespace, self.spec)
 
         # Build StatefulSet
-        statefulset = build_statefulset(self.name, self.namespace, self.spec, self.flavor)
+        statefulset = build_statefulset(
+            self.name,
+            self.namespace,
+            self.spec,
+            self.flavor,
+            self.owner_ids,
+            restricted=self.restricted,
+            capacity_scheduling=self.capacity_scheduling,
+            packing=self.packing,
+            ide=self.ide,
+            agent=self.agent,
+        )
 
         # Build ConfigMaps
-        sshd_configmap = build_configmap(self.name, self.namespace)
+        sshd_configmap = build_configmap(
+            self.name, self.namespace, self.spec, restricted=self.restricted
+        )
 
         script_path = os.path.join(os.path.dirname(__file__), "resources", "startup.sh")
         with open(script_path, "r") as f:
@@ -56,15 +140,80 @@ class DevServerReconciler:
         user_login_script_configmap = build_login_configmap(
             self.name, self.namespace, user_login_script_content
         )
-        return {
+        # The login banner, refreshed in the pod on every reconcile, e.g. of an extended TTL
+        expires_at = None
+        if self.meta.get("creationTimestamp"):
+            expires_at = get_expiration_time({"spec": self.spec, "metadata": self.meta})
+        motd_configmap = build_motd_configmap(
+            self.name,
+            self.namespace,
+            self.flavor.get("metadata", {}).get("name", self.spec.get("flavor", "")),
+            self.spec.get("owner"),
+            expires_at,
+            self.spec.get("collaborators") or [],
+        )
+        resources = {
+            "service_account": build_service_account(
+                self.name,
+                self.namespace,
+                get_service_account_annotations(self.spec, self.flavor),
+            ),
             "headless_service": headless_service,
             "ssh_service": ssh_service,
             "statefulset": statefulset,
             "sshd_configmap": sshd_configmap,
             "startup_script_configmap": startup_script_configmap,
             "user_login_script_configmap": user_login_script_configmap,
+            "motd_configmap": motd_configmap,
         }
 
+        # Route SSH through the shared Gateway, if the operator has one configured
+        if self.ssh_gateway is not None:
+            resources["ssh_route"] = self.ssh_gateway.build_route(self.name, self.namespace)
+
+        # Expose the DevServer's IDE, through an Ingress if the operator has a domain for it
+        if ide_requested(self.spec):
+            resources["ide_service"] = build_ide_service(self.name, self.namespace)
+            ide_ingress = self.ide.build_ingress(self.name, self.namespace)
+            if ide_ingress is not None:
+                resources["ide_ingress"] = ide_ingress
+
+        # The in-pod agent, and the ConfigMap it reports into and may patch
+        if self.agent:
+            script_path = os.path.join(os.path.dirname(__file__), "resources", "agent.sh")
+            with open(script_path, "r") as f:
+                agent_script_content = f.read()
+            resources["agent_script_configmap"] = build_agent_script_configmap(
+                self.name, self.namespace, agent_script_content
+            )
+            resources["agent_report_configmap"] = build_agent_report_configmap(
+                self.name, self.namespace
+            )
+            resources["agent_role"] = build_agent_role(self.name, self.namespace)
+            resources["agent_role_binding"] = build_agent_role_binding(self.name, self.namespace)
+
+        # Grant the DevServer's ServiceAccount the permissions of its role template
+        role_template = get_role_template(self.spec)
+        if role_template is not None:
+            resources["role_binding"] = build_role_binding(
+                self.name, self.namespace, role_template
+            )
+
+        # Isolate the pod, if the operator or the DevServer asks for it
+        network_policy = build_devserver_network_policy(self.name, self.namespace, self.spec)
+        if network_policy is not None:
+            resources["network_policy"] = network_policy
+
+        # Copy the DevServer's labels and annotations selected by spec.metadataPropagation,
+        # e.g. for cost allocation, onto its children, including its pod and home PVC
+        labels, annotations = propagated_metadata(self.spec, self.meta)
+        for resource in resources.values():
+            apply_metadata(resource, labels, annotations)
+        apply_metadata(statefulset["spec"]["template"], labels, annotations)
+        for claim_template in statefulset["spec"].get("volumeClaimTemplates", []):
+            apply_metadata(claim_template, labels, annotations)
+        return resources
+
     def adopt_resources(self, resources: Dict[str, Any]) -> None:
         """
         Set owner references on all resources using kopf.adopt.
@@ -84,102 +233,317 @@ class DevServerReconciler:
             logger: Logger instance
         """
         # Reconcile ConfigMaps
-        await self._reconcile_configmap(resources["sshd_configmap"], logger)
-        await self._reconcile_configmap(resources["startup_script_configmap"], logger)
-        await self._reconcile_configmap(resources["user_login_script_configmap"], logger)
+        with span("reconcile ConfigMaps"):
+            await self._reconcile_configmap(resources["sshd_configmap"], logger)
+            await self._reconcile_configmap(resources["startup_script_configmap"], logger)
+            await self._reconcile_configmap(resources["user_login_script_configmap"], logger)
+            await self._reconcile_configmap(resources["motd_configmap"], logger)
+
+        # Reconcile the ServiceAccount the pod runs as, and its permissions
+        with span("reconcile ServiceAccount"):
+            await self._reconcile_service_account(resources["service_account"], logger)
+            await self._reconcile_role_binding(resources.get("role_binding"), logger)
+
+        # Reconcile the agent's objects before the pod that mounts them
+        if "agent_script_configmap" in resources:
+            with span("reconcile agent"):
+                await self._reconcile_agent(resources, logger)
 
         # Reconcile Services
-        await self._reconcile_service(resources["headless_service"], logger)
+        with span("reconcile Services"):
+            await self._reconcile_service(resources["headless_service"], logger)
 
-        if self.spec.get("enableSSH", False):
-            await self._reconcile_service(resources["ssh_service"], logger)
-        else:
-            # TODO: Handle disabling SSH on an existing DevServer by deleting the service
-            pass
+            if self.spec.get("enableSSH", False):
+                await self._reconcile_service(resources["ssh_service"], logger)
+                if "ssh_route" in resources:
+                    await self._reconcile_route(resources["ssh_route"], logger)
+            else:
+                # TODO: Handle disabling SSH on an existing DevServer by deleting the service
+                pass
+            await self._reconcile_ide(
+                resources.get("ide_service"), resources.get("ide_ingress"), logger
+            )
+
+        # Reconcile the NetworkPolicy before the pod it applies to
+        with span("reconcile NetworkPolicy"):
+            await self._reconcile_network_policy(resources.get("network_policy"), logger)
+
+        # The home PVC's StorageClass must exist before the StatefulSet creates it
+        with span("reconcile encrypted StorageClass"):
+            await self._reconcile_encrypted_storage_class(logger)
 
         # Reconcile StatefulSet
-        await self._reconcile_statefulset(resources["statefulset"], logger)
+        with span("reconcile StatefulSet"):
+            await self._reconcile_statefulset(resources["statefulset"], logger)
 
-    async def _reconcile_configmap(self, configmap: Dict[str, Any], logger: logging.Logger) -> None:
-        """Create or update a ConfigMap."""
-        name = configmap["metadata"]["name"]
+    async def _read(
+        self, read: Callable[..., Any], api_client: client.ApiClient, name: str, **kwargs: Any
+    ) -> Optional[Dict[str, Any]]:
+        """Read a child object in its serialized form, or None if it does not exist."""
         try:
-            await asyncio.to_thread(
-                self.core_v1.read_namespaced_config_map, name=name, namespace=self.namespace
-            )
-            # It exists, so we patch it
-            await asyncio.to_thread(
-                self.core_v1.patch_namespaced_config_map,
-                name=name,
-                namespace=self.namespace,
-                body=configmap,
-            )
-            logger.info(f"ConfigMap '{name}' patched.")
+            existing = await asyncio.to_thread(read, name=name, **kwargs)
         except client.ApiException as e:
             if e.status == 404:
-                # It does not exist, so we create it
-                await asyncio.to_thread(
-                    self.core_v1.create_namespaced_config_map,
-                    namespace=self.namespace,
-                    body=configmap,
-                )
-                logger.info(f"ConfigMap '{name}' created.")
-            else:
-                raise
+                return None
+            raise
+        return api_client.sanitize_for_serialization(existing)
+
+    async def _adopt(
+        self, existing: Optional[Dict[str, Any]], body: Dict[str, Any], logger: logging.Logger
+    ) -> None:
+        """
+        Report taking over a child object that exists without the DevServer as
+        its owner, e.g. after a reinstall of the operator. Applying the body
+        then sets the owner reference and reconciles the rest of the object.
+        """
+        if not needs_adoption(existing, body):
+            return
+        message = f"Adopting existing {body['kind']} '{body['metadata']['name']}'."
+        logger.info(message)
+        await self._record_normal("Adopted", message)
+
+    async def _apply(
+        self,
+        read: Callable[..., Any],
+        patch: Callable[..., Any],
+        api_client: client.ApiClient,
+        body: Dict[str, Any],
+        logger: logging.Logger,
+        **kwargs: Any,
+    ) -> None:
+        """Create or update a child object, unless it is already up to date."""
+        name = body["metadata"]["name"]
+        body = with_applied_hash(body)
+        existing = await self._read(read, api_client, name, **kwargs)
+        await self._adopt(existing, body, logger)
+        if is_up_to_date(existing, body):
+            logger.debug(f"{body['kind']} '{name}' is up to date.")
+            return
+        await server_side_apply(patch, body, **kwargs)
+        logger.info(f"{body['kind']} '{name}' applied.")
+
+    async def _reconcile_configmap(self, configmap: Dict[str, Any], logger: logging.Logger) -> None:
+        """Create or update a ConfigMap."""
+        await self._apply(
+            self.core_v1.read_namespaced_config_map,
+            self.core_v1.patch_namespaced_config_map,
+            self.core_v1.api_client,
+            configmap,
+            logger,
+            namespace=self.namespace,
+        )
 
     async def _reconcile_service(self, service: Dict[str, Any], logger: logging.Logger) -> None:
         """Create or update a Service."""
-        name = service["metadata"]["name"]
-        try:
-            await asyncio.to_thread(
-                self.core_v1.read_namespaced_service, name=name, namespace=self.namespace
+        await self._apply(
+            self.core_v1.read_namespaced_service,
+            self.core_v1.patch_namespaced_service,
+            self.core_v1.api_client,
+            service,
+            logger,
+            namespace=self.namespace,
+        )
+
+    async def _reconcile_route(self, route: Dict[str, Any], logger: logging.Logger) -> None:
+        """Create or update a Gateway API route."""
+        assert self.ssh_gateway is not None
+        await self._apply(
+            self.custom_objects_api.get_namespaced_custom_object,
+            self.custom_objects_api.patch_namespaced_custom_object,
+            self.custom_objects_api.api_client,
+            route,
+            logger,
+            group=GATEWAY_API_GROUP,
+            version=GATEWAY_API_VERSION,
+            plural=self.ssh_gateway.route_plural,
+            namespace=self.namespace,
+        )
+
+    async def _reconcile_ide(
+        self,
+        service: Optional[Dict[str, Any]],
+        ingress: Optional[Dict[str, Any]],
+        logger: logging.Logger,
+    ) -> None:
+        """Create or update the IDE's Service and Ingress, or delete those no longer needed."""
+        if service is not None:
+            await self._reconcile_service(service, logger)
+        else:
+            await self._delete(
+                self.core_v1.delete_namespaced_service, "Service", ide_name(self.name), logger
             )
-            # It exists, so we patch it
-            await asyncio.to_thread(
-                self.core_v1.patch_namespaced_service,
-                name=name,
+        if ingress is not None:
+            await self._apply(
+                self.networking_v1.read_namespaced_ingress,
+                self.networking_v1.patch_namespaced_ingress,
+                self.networking_v1.api_client,
+                ingress,
+                logger,
                 namespace=self.namespace,
-                body=service,
             )
-            logger.info(f"Service '{name}' patched.")
+        else:
+            await self._delete(
+                self.networking_v1.delete_namespaced_ingress, "Ingress", ide_name(self.name), logger
+            )
+
+    async def _reconcile_network_policy(
+        self, network_policy: Optional[Dict[str, Any]], logger: logging.Logger
+    ) -> None:
+        """Create or update the NetworkPolicy, or delete it once isolation is turned off."""
+        if network_policy is not None:
+            await self._apply(
+                self.networking_v1.read_namespaced_network_policy,
+                self.networking_v1.patch_namespaced_network_policy,
+                self.networking_v1.api_client,
+                network_policy,
+                logger,
+                namespace=self.namespace,
+            )
+            return
+        await self._delete(
+            self.networking_v1.delete_namespaced_network_policy,
+            "NetworkPolicy",
+            network_policy_name(self.name),
+            logger,
+        )
+
+    async def _reconcile_encrypted_storage_class(self, logger: logging.Logger) -> None:
+        """
+        Create the copy of the flavor's encrypted StorageClass that uses the
+        DevServer's KMS key, if it needs one. It is shared by all DevServers
+        using the key, and its parameters are immutable, so an existing one is
+        left as it is.
+        """
+        kms_key_id = get_kms_key_id(self.spec, self.flavor)
+        if not home_encryption_requested(self.spec, self.flavor) or not kms_key_id:
+            return
+        base_name = self.flavor["spec"]["homeEncryption"]["storageClassName"]
+        name = encrypted_storage_class_name(base_name, kms_key_id)
+        read = self.storage_v1.read_storage_class
+        if await self._read(read, self.storage_v1.api_client, name) is not None:
+            return
+        base = await self._read(read, self.storage_v1.api_client, base_name)
+        if base is None:
+            raise kopf.TemporaryError(
+                f"Encrypted StorageClass '{base_name}' not found.",
+                delay=ENCRYPTED_STORAGE_CLASS_RETRY_INTERVAL,
+            )
+        try:
+            storage_class = build_encrypted_storage_class(base, kms_key_id)
+        except ValueError as e:
+            raise kopf.PermanentError(f"Invalid home encryption: {e}")
+        await server_side_apply(self.storage_v1.patch_storage_class, storage_class)
+        logger.info(f"StorageClass '{name}' applied.")
+
+    async def _reconcile_service_account(
+        self, service_account: Dict[str, Any], logger: logging.Logger
+    ) -> None:
+        """Create or update the ServiceAccount."""
+        await self._apply(
+            self.core_v1.read_namespaced_service_account,
+            self.core_v1.patch_namespaced_service_account,
+            self.core_v1.api_client,
+            service_account,
+            logger,
+            namespace=self.namespace,
+        )
+
+    async def _reconcile_role_binding(
+        self, role_binding: Optional[Dict[str, Any]], logger: logging.Logger
+    ) -> None:
+        """Create or update the RoleBinding, or delete it once no role template is set."""
+        name = service_account_name(self.name)
+        delete = self.rbac_v1.delete_namespaced_role_binding
+        if role_binding is None:
+            await self._delete(delete, "RoleBinding", name, logger)
+            return
+        existing = await self._read(
+            self.rbac_v1.read_namespaced_role_binding,
+            self.rbac_v1.api_client,
+            name,
+            namespace=self.namespace,
+        )
+        # roleRef is immutable, so a RoleBinding to another role is replaced
+        if existing is not None and existing.get("roleRef") != role_binding["roleRef"]:
+            await self._delete(delete, "RoleBinding", name, logger)
+        await self._apply(
+            self.rbac_v1.read_namespaced_role_binding,
+            self.rbac_v1.patch_namespaced_role_binding,
+            self.rbac_v1.api_client,
+            role_binding,
+            logger,
+            namespace=self.namespace,
+        )
+
+    async def _reconcile_agent(self, resources: Dict[str, Any], logger: logging.Logger) -> None:
+        """Create or update the agent's script, report ConfigMap and permissions."""
+        await self._reconcile_configmap(resources["agent_script_configmap"], logger)
+        await self._reconcile_configmap(resources["agent_report_configmap"], logger)
+        await self._apply(
+            self.rbac_v1.read_namespaced_role,
+            self.rbac_v1.patch_namespaced_role,
+            self.rbac_v1.api_client,
+            resources["agent_role"],
+            logger,
+            namespace=self.namespace,
+        )
+        await self._apply(
+            self.rbac_v1.read_namespaced_role_binding,
+            self.rbac_v1.patch_namespaced_role_binding,
+            self.rbac_v1.api_client,
+            resources["agent_role_binding"],
+            logger,
+            namespace=self.namespace,
+        )
+
+    async def _delete(
+        self, delete: Callable[..., Any], kind: str, name: str, logger: logging.Logger
+    ) -> None:
+        """Delete a child object the DevServer no longer needs, if it exists."""
+        try:
+            await asyncio.to_thread(delete, name=name, namespace=self.namespace)
         except client.ApiException as e:
-            if e.status == 404:
-                # It does not exist, so we create it
-                await asyncio.to_thread(
-                    self.core_v1.create_namespaced_service,
-                    namespace=self.namespace,
-                    body=service,
-                )
-                logger.info(f"Service '{name}' created.")
-            else:
+            if e.status != 404:
                 raise
+            return
+        logger.info(f"{kind} '{name}' deleted.")
 
     async def _reconcile_statefulset(self, statefulset: Dict[str, Any], logger: logging.Logger) -> None:
         """Create or update a StatefulSet."""
         name = statefulset["metadata"]["name"]
-        try:
-            await asyncio.to_thread(
-                self.apps_v1.read_namespaced_stateful_set, name=name, namespace=self.namespace
-            )
-            # It exists, so we patch it
-            await asyncio.to_thread(
-                self.apps_v1.patch_namespaced_stateful_set,
-                name=name,
-                namespace=self.namespace,
-                body=statefulset,
-            )
-            logger.info(f"StatefulSet '{name}' patched.")
-        except client.ApiException as e:
-            if e.status == 404:
-                # It does not exist, so we create it
-                await asyncio.to_thread(
-                    self.apps_v1.create_namespaced_stateful_set,
-                    body=statefulset,
+        existing = await self._read(
+            self.apps_v1.read_namespaced_stateful_set,
+            self.apps_v1.api_client,
+            name,
+            namespace=self.namespace,
+        )
+        if existing is None:
+            with span("create StatefulSet"):
+                await server_side_apply(
+                    self.apps_v1.patch_namespaced_stateful_set,
+                    with_applied_hash(statefulset),
                     namespace=self.namespace,
                 )
-                logger.info(f"StatefulSet '{name}' created for DevServer.")
-            else:
-                raise
+            logger.info(f"StatefulSet '{name}' created for DevServer.")
+            await self._record_normal("Created", f"Created StatefulSet '{name}'.")
+            return
+        await self._adopt(existing, statefulset, logger)
+
+        # volumeClaimTemplates are immutable, so changes to them (e.g. the home
+        # storage class) only apply to new PVCs. The existing ones are applied
+        # as they are, since leaving them out would remove them.
+        spec = {k: v for k, v in statefulset["spec"].items() if k != "volumeClaimTemplates"}
+        if existing["spec"].get("volumeClaimTemplates"):
+            spec["volumeClaimTemplates"] = existing["spec"]["volumeClaimTemplates"]
+        body = with_applied_hash({**statefulset, "spec": spec})
+        if is_up_to_date(existing, body):
+            logger.debug(f"StatefulSet '{name}' is up to date.")
+            return
+        with span("apply StatefulSet"):
+            await server_side_apply(
+                self.apps_v1.patch_namespaced_stateful_set, body, namespace=self.namespace
+            )
+        logger.info(f"StatefulSet '{name}' applied.")
 
 
 async def reconcile_devserver(
@@ -188,6 +552,11 @@ async def reconcile_devserver(
     spec: Dict[str, Any],
     flavor: Dict[str, Any],
     logger: logging.Logger,
+    recorder: Optional[EventRecorder] = None,
+    reference: Optional[Dict[str, Any]] = None,
+    owner_ids: Optional[PosixIds] = None,
+    meta: Optional[Mapping[str, Any]] = None,
+    capacity_type: Optional[str] = None,
 ) -> str:
     """
     Reconcile all Kubernetes resources for a DevServer.
@@ -198,11 +567,18 @@ async def reconcile_devserver(
         spec: DevServer spec
         flavor: DevServerFlavor object
         logger: Logger instance
+        recorder: Optional event recorder
+        reference: Event reference to the DevServer, required with a recorder
+        owner_ids: The owner's UID/GID from their DevServerUser, if any
+        meta: DevServer metadata, whose labels and annotations may be propagated
+        capacity_type: The spot or on-demand capacity to run on, if any
 
     Returns:
         Status message indicating success
     """
-    reconciler = DevServerReconciler(name, namespace, spec, flavor)
+    reconciler = DevServerReconciler(
+        name, namespace, spec, flavor, recorder, reference, owner_ids, meta, capacity_type
+    )
 
     # Build all resources
     resources = reconciler.build_resources()
@@ -0,0 +1,93 @@
+from typing import Any, Dict, Mapping, Optional
+
+from devservers.utils.flavors import get_flavor_gpus
+
+# Selects the pods of DevServers that are packed together
+PACKED_LABEL = "devserver.io/packed"
+
+
+def get_affinity(spec: Mapping[str, Any]) -> Optional[Dict[str, Any]]:
+    """Returns the DevServer's `spec.affinity`, if any."""
+    affinity = spec.get("affinity")
+    return dict(affinity) if affinity else None
+
+
+def _expression_allows(expression: Mapping[str, Any], value: str) -> bool:
+    """Whether a node labeled with the value can match the expression."""
+    operator = expression.get("operator")
+    if operator == "In":
+        return value in expression.get("values", [])
+    if operator == "NotIn":
+        return value not in expression.get("values", [])
+    if operator == "DoesNotExist":
+        return False
+    # Exists, and Gt/Lt which are not worth resolving here
+    return True
+
+
+def validate_user_affinity(spec: Mapping[str, Any], node_selector: Mapping[str, str]) -> None:
+    """
+    Check that `spec.affinity` leaves nodes the flavor's node selector allows.
+    Kubernetes requires both, so a required node affinity that contradicts the
+    node selector leaves the pod pending forever.
+
+    Raises:
+        ValueError: If no required node selector term can match a node the
+            flavor allows.
+    """
+    required = (
+        (get_affinity(spec) or {})
+        .get("nodeAffinity", {})
+        .get("requiredDuringSchedulingIgnoredDuringExecution")
+    )
+    if not required:
+        return
+    terms = required.get("nodeSelectorTerms", [])
+
+    def term_allowed(term: Mapping[str, Any]) -> bool:
+        return all(
+            _expression_allows(expression, node_selector[expression["key"]])
+            for expression in term.get("matchExpressions", [])
+            if expression.get("key") in node_selector
+        )
+
+    if terms and not any(term_allowed(term) for term in terms):
+        selector = ", ".join(f"{key}={value}" for key, value in sorted(node_selector.items()))
+        raise ValueError(
+            f"the required node affinity excludes the flavor's nodes ({selector})."
+        )
+
+
+def packing_requested(flavor: Dict[str, Any], packing: bool) -> bool:
+    """
+    Whether the flavor's DevServers are packed onto shared nodes: those of
+    flavors without GPUs when the operator packs, unless the flavor's
+    `spec.packing` says otherwise.
+    """
+    flavor_packing = flavor["spec"].get("packing")
+    if flavor_packing is not None:
+        return bool(flavor_packing)
+    return packing and get_flavor_gpus(flavor)[0] == 0
+
+
+def apply_packing(template: Dict[str, Any], topology_key: str, weight: int) -> None:
+    """
+    Prefer to schedule the pod next to other packed DevServers, in any
+    namespace, keeping the pod affinity the DevServer sets itself.
+    """
+    template["metadata"]["labels"][PACKED_LABEL] = "true"
+    pod_spec = template["spec"]
+    affinity = dict(pod_spec.get("affinity") or {})
+    pod_affinity = dict(affinity.get("podAffinity") or {})
+    pod_affinity["preferredDuringSchedulingIgnoredDuringExecution"] = [
+        *pod_affinity.get("preferredDuringSchedulingIgnoredDuringExecution", []),
+        {
+            "weight": weight,
+            "podAffinityTerm": {
+                "labelSelector": {"matchLabels": {PACKED_LABEL: "true"}},
+                "namespaceSelector": {},
+                "topologyKey": topology_key,
+            },
+        },
+    ]
+    pod_spec["affinity"] = {**affinity, "podAffinity": pod_affinity}
@@ -0,0 +1,146 @@
+"""
+The in-pod devserver-agent and the objects it talks to the operator through.
+
+The agent script is mounted from the `<name>-agent-script` ConfigMap. It
+reports the pod's activity into, and requests self-service actions through,
+the `<name>-agent` ConfigMap, the only object the DevServer's ServiceAccount
+may patch for it. The operator folds the reports into the DevServer's status
+and carries out the requests, so the pod never changes the DevServer itself.
+"""
+from typing import Any, Dict, List
+
+from .service_account import service_account_name
+
+AGENT_SCRIPT_VOLUME_NAME = "agent"
+AGENT_TOKEN_VOLUME_NAME = "agent-token"
+AGENT_MOUNT_PATH = "/devserver-agent"
+AGENT_TOKEN_MOUNT_PATH = "/var/run/secrets/devserver-agent"
+AGENT_LABEL = "devserver.io/agent-report"
+# Tokens are refreshed by the kubelet well before they expire
+AGENT_TOKEN_EXPIRATION_SECONDS = 3600
+
+
+def agent_name(name: str) -> str:
+    """The name of the DevServer's agent report ConfigMap, Role and RoleBinding."""
+    return f"{name}-agent"
+
+
+def build_agent_script_configmap(name: str, namespace: str, script_content: str) -> Dict[str, Any]:
+    """Builds the ConfigMap holding the devserver-agent script."""
+    return {
+        "apiVersion": "v1",
+        "kind": "ConfigMap",
+        "metadata": {"name": f"{name}-agent-script", "namespace": namespace},
+        "data": {"devserver-agent": script_content},
+    }
+
+
+def build_agent_report_configmap(name: str, namespace: str) -> Dict[str, Any]:
+    """
+    Builds the ConfigMap the agent reports into. It is applied without data,
+    so that the operator does not take over the fields the agent writes.
+    """
+    return {
+        "apiVersion": "v1",
+        "kind": "ConfigMap",
+        "metadata": {
+            "name": agent_name(name),
+            "namespace": namespace,
+            "labels": {AGENT_LABEL: name},
+        },
+    }
+
+
+def build_agent_role(name: str, namespace: str) -> Dict[str, Any]:
+    """Builds the Role letting the agent read and patch its report ConfigMap only."""
+    return {
+        "apiVersion": "rbac.authorization.k8s.io/v1",
+        "kind": "Role",
+        "metadata": {"name": agent_name(name), "namespace": namespace},
+        "rules": [
+            {
+                "apiGroups": [""],
+                "resources": ["configmaps"],
+                "resourceNames": [agent_name(name)],
+                "verbs": ["get", "patch"],
+            }
+        ],
+    }
+
+
+def build_agent_role_binding(name: str, namespace: str) -> Dict[str, Any]:
+    """Builds the RoleBinding granting the agent's Role to the DevServer's ServiceAccount."""
+    return {
+        "apiVersion": "rbac.authorization.k8s.io/v1",
+        "kind": "RoleBinding",
+        "metadata": {"name": agent_name(name), "namespace": namespace},
+        "subjects": [
+            {
+                "kind": "ServiceAccount",
+                "name": service_account_name(name),
+                "namespace": namespace,
+            }
+        ],
+        "roleRef": {
+            "apiGroup": "rbac.authorization.k8s.io",
+            "kind": "Role",
+            "name": agent_name(name),
+        },
+    }
+
+
+def build_agent_volumes(name: str) -> List[Dict[str, Any]]:
+    """
+    Builds the volumes of the agent script and of its ServiceAccount token,
+    which is projected even when the pod does not automount one.
+    """
+    return [
+        {
+            "name": AGENT_SCRIPT_VOLUME_NAME,
+            "configMap": {"name": f"{name}-agent-script", "defaultMode": 0o755},
+        },
+        {
+            "name": AGENT_TOKEN_VOLUME_NAME,
+            "projected": {
+                # Readable by the dev user, who runs `devserver extend`
+                "defaultMode": 0o444,
+                "sources": [
+                    {
+                        "serviceAccountToken": {
+                            "path": "token",
+                            "expirationSeconds": AGENT_TOKEN_EXPIRATION_SECONDS,
+                        }
+                    },
+                    {
+                        "configMap": {
+                            "name": "kube-root-ca.crt",
+                            "items": [{"key": "ca.crt", "path": "ca.crt"}],
+                        }
+                    },
+                    {
+                        "downwardAPI": {
+                            "items": [
+                                {
+                                    "path": "namespace",
+                                    "fieldRef": {"fieldPath": "metadata.namespace"},
+                                },
+                                # The pod's app label is the DevServer's name
+                                {
+                                    "path": "name",
+                                    "fieldRef": {"fieldPath": "metadata.labels['app']"},
+                                },
+                            ]
+                        }
+                    },
+                ],
+            },
+        },
+    ]
+
+
+def build_agent_volume_mounts() -> List[Dict[str, Any]]:
+    """Builds the DevServer container's mounts of the agent's volumes."""
+    return [
+        {"name": AGENT_SCRIPT_VOLUME_NAME, "mountPath": AGENT_MOUNT_PATH, "readOnly": True},
+        {"name": AGENT_TOKEN_VOLUME_NAME, "mountPath": AGENT_TOKEN_MOUNT_PATH, "readOnly": True},
+    ]
@@ -0,0 +1,160 @@
+#!/bin/sh
+#
+# devserver-agent: reports the DevServer's activity to the operator and offers
+# self-service commands from inside the DevServer. startup.sh runs
+# `devserver-agent run` in the background and links it as `devserver`.
+#
+# It only talks to the `<name>-agent` ConfigMap, with the ServiceAccount token
+# projected into AGENT_DIR; the operator picks reports and requests up from it.
+
+set -e
+
+AGENT_DIR="${DEVSERVER_AGENT_DIR:-/var/run/secrets/devserver-agent}"
+MOTD_DIR="${DEVSERVER_MOTD_DIR:-/devserver-motd}"
+# Seconds between activity reports
+INTERVAL="${DEVSERVER_AGENT_INTERVAL:-60}"
+# GPU utilization, in percent, from which the GPUs count as busy
+GPU_THRESHOLD="${DEVSERVER_AGENT_GPU_THRESHOLD:-5}"
+# Seconds `devserver extend` waits for the operator
+EXTEND_TIMEOUT=90
+
+API="https://${KUBERNETES_SERVICE_HOST:-kubernetes.default.svc}:${KUBERNETES_SERVICE_PORT:-443}"
+
+die() {
+    echo "devserver: $1" >&2
+    exit 1
+}
+
+report_url() {
+    NAMESPACE=$(cat "$AGENT_DIR/namespace")
+    NAME=$(cat "$AGENT_DIR/name")
+    echo "$API/api/v1/namespaces/$NAMESPACE/configmaps/$NAME-agent"
+}
+
+# Calls the API server with the agent's token, which the kubelet rotates
+api() {
+    METHOD="$1"
+    shift
+    curl -sS --fail --max-time 10 --cacert "$AGENT_DIR/ca.crt" \
+        -H "Authorization: Bearer $(cat "$AGENT_DIR/token")" \
+        -X "$METHOD" "$@" "$(report_url)"
+}
+
+patch_report() {
+    api PATCH -H "Content-Type: application/merge-patch+json" --data "$1" > /dev/null
+}
+
+# Reads a field of the report ConfigMap, e.g. the operator's extendResult
+report_field() {
+    api GET | tr -d '\n' | sed -n "s/.*\"$1\": *\"\([^\"]*\)\".*/\1/p"
+}
+
+check_agent() {
+    command -v curl > /dev/null 2>&1 || die "curl is required, but the image has none."
+    [ -r "$AGENT_DIR/token" ] || die "the agent is not enabled for this DevServer."
+}
+
+# SSH sessions are the sshd processes named after their user and tty
+ssh_sessions() {
+    PATTERN="$1"
+    COUNT=0
+    for CMDLINE in /proc/[0-9]*/cmdline; do
+        case "$(tr '\0' ' ' < "$CMDLINE" 2>/dev/null)" in
+            "sshd: "*@$PATTERN*) COUNT=$((COUNT + 1)) ;;
+        esac
+    done
+    echo "$COUNT"
+}
+
+# Terminals are modified on every input and output, so their latest
+# modification time is the last interactive activity
+tty_activity() {
+    COUNT=0
+    LATEST=0
+    for TTY in /dev/pts/[0-9]*; do
+        [ -e "$TTY" ] || continue
+        COUNT=$((COUNT + 1))
+        MODIFIED=$(stat -c %Y "$TTY" 2>/dev/null || echo 0)
+        if [ "$MODIFIED" -gt "$LATEST" ]; then
+            LATEST=$MODIFIED
+        fi
+    done
+    echo "$COUNT $LATEST"
+}
+
+# The utilization of the busiest GPU, or nothing without GPUs
+gpu_utilization() {
+    if command -v nvidia-smi > /dev/null 2>&1; then
+        nvidia-smi --query-gpu=utilization.gpu --format=csv,noheader,nounits 2>/dev/null \
+            | tr -d ' ' | sort -n | tail -n 1
+    fi
+}
+
+report() {
+    NOW=$(date +%s)
+    SESSIONS=$(ssh_sessions "")
+    set -- $(tty_activity)
+    TTYS=$1
+    LAST_ACTIVITY=$2
+    # Sessions without a terminal, e.g. editors' remote servers, are active while open
+    if [ "$(ssh_sessions notty)" -gt 0 ]; then
+        LAST_ACTIVITY=$NOW
+    fi
+    GPU=$(gpu_utilization)
+    if [ -n "$GPU" ] && [ "$GPU" -ge "$GPU_THRESHOLD" ]; then
+        LAST_ACTIVITY=$NOW
+    fi
+    patch_report "{\"data\":{\"sshSessions\":\"$SESSIONS\",\"ttys\":\"$TTYS\",\
+\"gpuUtilization\":\"$GPU\",\"lastActivity\":\"$LAST_ACTIVITY\",\"reportedAt\":\"$NOW\"}}"
+}
+
+run() {
+    check_agent
+    echo "devserver-agent: reporting activity every ${INTERVAL}s."
+    while true; do
+        report || echo "devserver-agent: failed to report activity." >&2
+        sleep "$INTERVAL"
+    done
+}
+
+extend() {
+    check_agent
+    DURATION="$1"
+    echo "$DURATION" | grep -Eq '^([0-9]+[smhd])+$' \
+        || die "usage: devserver extend <duration>, e.g. devserver extend 12h"
+    REQUEST="$(date +%s)-$$"
+    patch_report "{\"data\":{\"extendRequest\":\"$REQUEST $DURATION\"}}" \
+        || die "failed to request the extension."
+    echo "Requested to extend the DevServer by $DURATION, waiting for the operator..."
+    WAITED=0
+    while [ "$WAITED" -lt "$EXTEND_TIMEOUT" ]; do
+        sleep 3
+        WAITED=$((WAITED + 3))
+        RESULT=$(report_field extendResult || true)
+        case "$RESULT" in
+            "$REQUEST ok "*) echo "${RESULT#"$REQUEST ok "}"; return 0 ;;
+            "$REQUEST error "*) die "${RESULT#"$REQUEST error "}" ;;
+        esac
+    done
+    die "the operator did not answer in time; the extension may still be applied."
+}
+
+status() {
+    for FIELD in name namespace flavor owner expiresAt; do
+        if [ -r "$MOTD_DIR/$FIELD" ]; then
+            printf "%-10s %s\n" "$FIELD:" "$(cat "$MOTD_DIR/$FIELD")"
+        fi
+    done
+}
+
+case "${1:-help}" in
+    run) run ;;
+    extend) extend "$2" ;;
+    status) status ;;
+    *)
+        echo "usage: devserver <command>"
+        echo
+        echo "  status            Show this DevServer's flavor, owner and expiry."
+        echo "  extend <duration> Extend this DevServer's time to live, e.g. 12h."
+        ;;
+esac
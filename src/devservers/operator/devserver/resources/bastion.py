@@ -0,0 +1,245 @@
+"""
+Builders for the per-namespace SSH bastion.
+
+The bastion is a single, audited entry point for SSH: users ProxyJump through
+it to their DevServers. Its ConfigMap is regenerated from the live DevServers
+of the namespace, so access follows DevServers as they come and go.
+"""
+from collections import OrderedDict
+from typing import Any, Dict, List, Mapping, Optional, Sequence
+
+from devservers.crds.const import DEFAULT_SSH_PORT
+
+BASTION_NAME = "devserver-bastion"
+BASTION_USER = "bastion"
+BASTION_IMAGE = "debian:stable-slim"
+BASTION_CONFIG_MOUNT_PATH = "/opt/ssh/config"
+
+BASTION_SSHD_CONFIG = f"""# This file is managed by the devserver operator
+
+Port 22
+HostKey /etc/ssh/ssh_host_rsa_key
+HostKey /etc/ssh/ssh_host_ecdsa_key
+HostKey /etc/ssh/ssh_host_ed25519_key
+AuthorizedKeysFile {BASTION_CONFIG_MOUNT_PATH}/authorized_keys
+AllowUsers {BASTION_USER}
+# authorized_keys is an operator-managed, read-only ConfigMap whose volume
+# directory does not pass sshd's ownership checks
+StrictModes no
+PermitRootLogin no
+PasswordAuthentication no
+KbdInteractiveAuthentication no
+# Only relay connections (ssh -J); never hand out a shell
+ForceCommand /bin/false
+PermitTTY no
+AllowTcpForwarding local
+AllowAgentForwarding no
+X11Forwarding no
+PermitTunnel no
+GatewayPorts no
+# Logs key fingerprints and every forwarded connection, for auditing
+LogLevel VERBOSE
+"""
+
+
+def devserver_ssh_target(devserver: Mapping[str, Any]) -> str:
+    """The in-cluster `host:port` of a DevServer's sshd, as reached from the bastion."""
+    name = devserver["metadata"]["name"]
+    namespace = devserver["metadata"]["namespace"]
+    port = int(devserver["spec"].get("ssh", {}).get("port", DEFAULT_SSH_PORT))
+    return f"{devserver_ssh_host(name, namespace)}:{port}"
+
+
+def devserver_ssh_host(name: str, namespace: str) -> str:
+    """The stable DNS name of a DevServer's pod, via its headless Service."""
+    return f"{name}-0.{name}-headless.{namespace}.svc.cluster.local"
+
+
+def render_authorized_keys(devservers: Sequence[Mapping[str, Any]]) -> str:
+    """
+    Render the bastion's authorized_keys.
+
+    Each public key may only open (`permitopen`) the DevServers it is
+    configured on, so one user cannot jump to another user's DevServer.
+    """
+    permitted: "OrderedDict[str, List[str]]" = OrderedDict()
+    for devserver in devservers:
+        public_key = devserver["spec"].get("ssh", {}).get("publicKey", "").strip()
+        if not public_key:
+            continue
+        permitted.setdefault(public_key, []).append(devserver_ssh_target(devserver))
+
+    lines = []
+    for public_key, targets in permitted.items():
+        options = ",".join(
+            ["restrict", "port-forwarding"] + [f'permitopen="{target}"' for target in targets]
+        )
+        lines.append(f"{options} {public_key}")
+    return "".join(f"{line}\n" for line in lines)
+
+
+def render_client_config(
+    namespace: str,
+    devservers: Sequence[Mapping[str, Any]],
+    bastion_endpoint: Optional[str],
+) -> str:
+    """Render an ssh_config users can include to reach DevServers through the bastion."""
+    bastion_alias = f"{BASTION_NAME}-{namespace}"
+    lines = [f"Host {bastion_alias}", f"    User {BASTION_USER}"]
+    if bastion_endpoint:
+        host, _, port = bastion_endpoint.rpartition(":")
+        lines += [f"    HostName {host}", f"    Port {port}"]
+    else:
+        lines.append("    # HostName is filled in once the bastion Service has an address")
+
+    for devserver in devservers:
+        name = devserver["metadata"]["name"]
+        host, _, port = devserver_ssh_target(devserver).rpartition(":")
+        lines += [
+            "",
+            f"Host devserver-{namespace}-{name}",
+            f"    HostName {host}",
+            f"    Port {port}",
+            "    User dev",
+            f"    ProxyJump {bastion_alias}",
+        ]
+    return "\n".join(lines) + "\n"
+
+
+def _known_hosts_pattern(endpoint: str) -> str:
+    host, _, port = endpoint.rpartition(":")
+    return host if int(port) == 22 else f"[{host}]:{port}"
+
+
+def render_known_hosts(
+    devservers: Sequence[Mapping[str, Any]],
+    bastion_endpoint: Optional[str] = None,
+    bastion_host_keys: Sequence[str] = (),
+) -> str:
+    """Render known_hosts entries for the bastion and the DevServers behind it."""
+    lines = []
+    if bastion_endpoint:
+        lines += [f"{_known_hosts_pattern(bastion_endpoint)} {key}" for key in bastion_host_keys]
+    for devserver in devservers:
+        pattern = _known_hosts_pattern(devserver_ssh_target(devserver))
+        for key in devserver.get("status", {}).get("sshHostKeys") or []:
+            lines.append(f"{pattern} {key}")
+    return "".join(f"{line}\n" for line in lines)
+
+
+def build_bastion_configmap(
+    namespace: str,
+    devservers: Sequence[Mapping[str, Any]],
+    bastion_endpoint: Optional[str] = None,
+    bastion_host_keys: Sequence[str] = (),
+) -> Dict[str, Any]:
+    """
+    Builds the bastion's ConfigMap.
+
+    `sshd_config` and `authorized_keys` configure the bastion itself;
+    `ssh_config` and `known_hosts` are for users.
+    """
+    return {
+        "apiVersion": "v1",
+        "kind": "ConfigMap",
+        "metadata": {"name": BASTION_NAME, "namespace": namespace},
+        "data": {
+            "sshd_config": BASTION_SSHD_CONFIG,
+            "authorized_keys": render_authorized_keys(devservers),
+            "ssh_config": render_client_config(namespace, devservers, bastion_endpoint),
+            "known_hosts": render_known_hosts(devservers, bastion_endpoint, bastion_host_keys),
+        },
+    }
+
+
+def build_bastion_script_configmap(namespace: str, script_content: str) -> Dict[str, Any]:
+    """Builds the ConfigMap for the bastion's entry point."""
+    return {
+        "apiVersion": "v1",
+        "kind": "ConfigMap",
+        "metadata": {"name": f"{BASTION_NAME}-script", "namespace": namespace},
+        "data": {"bastion.sh": script_content},
+    }
+
+
+def build_bastion_deployment(namespace: str) -> Dict[str, Any]:
+    """Builds the bastion Deployment, which runs a locked-down sshd."""
+    return {
+        "apiVersion": "apps/v1",
+        "kind": "Deployment",
+        "metadata": {"name": BASTION_NAME, "namespace": namespace},
+        "spec": {
+            "replicas": 1,
+            "selector": {"matchLabels": {"app": BASTION_NAME}},
+            "template": {
+                "metadata": {"labels": {"app": BASTION_NAME}},
+                "spec": {
+                    "initContainers": [
+                        {
+                            "name": "install-sshd",
+                            "image": "seemethere/devserver-static-dependencies:latest",
+                            "imagePullPolicy": "Always",
+                            "command": ["/bin/sh", "-c"],
+                            "args": ["cp /usr/local/bin/sshd /opt/bin/ && chmod +x /opt/bin/sshd"],
+                            "volumeMounts": [{"name": "bin", "mountPath": "/opt/bin"}],
+                        }
+                    ],
+                    "containers": [
+                        {
+                            "name": "sshd",
+                            "image": BASTION_IMAGE,
+                            "command": ["/bin/sh", "/opt/bastion/bastion.sh"],
+                            "ports": [{"name": "ssh", "containerPort": 22, "protocol": "TCP"}],
+                            "readinessProbe": {"tcpSocket": {"port": "ssh"}},
+                            "resources": {
+                                "requests": {"cpu": "50m", "memory": "64Mi"},
+                                "limits": {"memory": "256Mi"},
+                            },
+                            "volumeMounts": [
+                                {"name": "bin", "mountPath": "/opt/bin"},
+                                {"name": "script", "mountPath": "/opt/bastion", "readOnly": True},
+                                # Mounted as a directory (not subPath) so that
+                                # authorized_keys updates reach the running sshd.
+                                {
+                                    "name": "config",
+                                    "mountPath": BASTION_CONFIG_MOUNT_PATH,
+                                    "readOnly": True,
+                                },
+                                {
+                                    "name": "host-keys",
+                                    "mountPath": "/opt/ssh/hostkeys",
+                                    "readOnly": True,
+                                },
+                            ],
+                        }
+                    ],
+                    "volumes": [
+                        {"name": "bin", "emptyDir": {}},
+                        {"name": "script", "configMap": {"name": f"{BASTION_NAME}-script"}},
+                        {"name": "config", "configMap": {"name": BASTION_NAME}},
+                        {
+                            "name": "host-keys",
+                            "secret": {
+                                "secretName": f"{BASTION_NAME}-host-keys",
+                                "defaultMode": 0o600,
+                            },
+                        },
+                    ],
+                },
+            },
+        },
+    }
+
+
+def build_bastion_service(namespace: str, service_type: str) -> Dict[str, Any]:
+    """Builds the Service exposing the bastion."""
+    return {
+        "apiVersion": "v1",
+        "kind": "Service",
+        "metadata": {"name": BASTION_NAME, "namespace": namespace},
+        "spec": {
+            "type": service_type,
+            "selector": {"app": BASTION_NAME},
+            "ports": [{"name": "ssh", "port": 22, "targetPort": "ssh", "protocol": "TCP"}],
+        },
+    }
@@ -0,0 +1,31 @@
+#!/bin/sh
+# Entry point of the namespace's SSH bastion. The bastion only relays
+# ProxyJump connections: no shells, no agent forwarding, and every key may
+# only open the DevServers it belongs to (see authorized_keys).
+
+set -e
+
+echo "==> Configuring bastion"
+
+if ! getent group sshd >/dev/null; then
+    groupadd -r sshd
+fi
+if ! getent passwd sshd >/dev/null; then
+    useradd -r -g sshd -c 'sshd privsep' -d /var/empty -s /sbin/nologin sshd
+fi
+if ! getent passwd bastion >/dev/null; then
+    useradd -m -s /bin/sh bastion
+fi
+# An account without a password is locked on some distributions, which
+# makes sshd reject it even for public key authentication.
+usermod -p "$(head -c 32 /dev/urandom | tr -dc 'a-zA-Z0-9')" bastion
+
+mkdir -p /var/empty /etc/ssh
+cp -L /opt/ssh/hostkeys/* /etc/ssh/
+chmod 600 /etc/ssh/ssh_host_*_key
+chmod 644 /etc/ssh/ssh_host_*_key.pub
+
+/opt/bin/sshd -t -f /opt/ssh/config/sshd_config
+
+echo "==> Starting sshd"
+exec /opt/bin/sshd -D -e -f /opt/ssh/config/sshd_config
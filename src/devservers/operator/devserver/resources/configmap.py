@@ -1,25 +1,96 @@
-from typing import Any, Dict
+import hashlib
+from typing import Any, Dict, List, Mapping, Optional, Tuple
+
+from devservers.crds.const import DEFAULT_HOME_MOUNT_PATH, DEFAULT_SSH_PORT
+from .pod_security import RESTRICTED_SSHD_DIR
+
+# Where a root sshd keeps its config and host keys
+SSHD_DIR = "/etc/ssh"
+
+# Directives the operator relies on; they cannot be overridden via
+# spec.ssh.sshdConfig. The port is configured with spec.ssh.port instead.
+MANAGED_SSHD_DIRECTIVES = frozenset(
+    {"port", "hostkey", "authorizedkeysfile", "forcecommand", "subsystem"}
+)
+
+# Values are formatted with the dev user's home directory and sshd's directory
+_DEFAULT_SSHD_DIRECTIVES: List[Tuple[str, str]] = [
+    ("PermitRootLogin", "no"),
+    ("PasswordAuthentication", "no"),
+    ("ChallengeResponseAuthentication", "no"),
+    ("PrintMotd", "no"),
+    ("ForceCommand", "/devserver-login/user_login.sh"),
+    ("Subsystem", "sftp /opt/bin/sftp-server"),
+    ("AuthorizedKeysFile", "{home}/.ssh/authorized_keys"),
+    ("HostKey", "{sshd_dir}/ssh_host_rsa_key"),
+    ("HostKey", "{sshd_dir}/ssh_host_ecdsa_key"),
+    ("HostKey", "{sshd_dir}/ssh_host_ed25519_key"),
+    ("AllowAgentForwarding", "yes"),
+]
+
+
+def get_ssh_port(spec: Mapping[str, Any]) -> int:
+    """Returns the port sshd listens on inside the pod."""
+    return int(spec.get("ssh", {}).get("port", DEFAULT_SSH_PORT))
+
+
+def get_home_mount_path(spec: Mapping[str, Any]) -> str:
+    """Returns the dev user's home directory, where the home volume is mounted."""
+    return spec.get("homeMountPath", DEFAULT_HOME_MOUNT_PATH).rstrip("/")
 
-def build_configmap(name: str, namespace: str) -> Dict[str, Any]:
-    """Builds the ConfigMap for the DevServer's sshd_config."""
 
-    sshd_config = """
-# This file is managed by the devserver operator
-
-Port 22
-PermitRootLogin no
-PasswordAuthentication no
-ChallengeResponseAuthentication no
-PrintMotd no
-ForceCommand /devserver-login/user_login.sh
-Subsystem sftp /opt/bin/sftp-server
-AuthorizedKeysFile /home/dev/.ssh/authorized_keys
-HostKey /etc/ssh/ssh_host_rsa_key
-HostKey /etc/ssh/ssh_host_ecdsa_key
-HostKey /etc/ssh/ssh_host_ed25519_key
-AllowAgentForwarding yes
+def get_managed_sshd_overrides(spec: Mapping[str, Any]) -> List[str]:
+    """Returns the keys of spec.ssh.sshdConfig that the operator manages itself."""
+    overrides = spec.get("ssh", {}).get("sshdConfig") or {}
+    return sorted(key for key in overrides if key.lower() in MANAGED_SSHD_DIRECTIVES)
+
+
+def render_sshd_config(
+    spec: Optional[Mapping[str, Any]] = None, restricted: bool = False
+) -> str:
     """
+    Renders the sshd_config for a DevServer.
+
+    Overrides from spec.ssh.sshdConfig replace the default value of a
+    directive (matched case-insensitively, as sshd does) or are appended.
+    With `restricted`, sshd runs as the dev user and keeps its files in a
+    writable directory.
+    """
+    spec = spec or {}
+    sshd_dir = RESTRICTED_SSHD_DIR if restricted else SSHD_DIR
+    overrides = dict(spec.get("ssh", {}).get("sshdConfig") or {})
+    overridden = {key.lower(): key for key in overrides}
+
+    lines = [
+        "# This file is managed by the devserver operator",
+        "",
+        f"Port {get_ssh_port(spec)}",
+    ]
+    for key, value in _DEFAULT_SSHD_DIRECTIVES:
+        if key.lower() in overridden:
+            continue
+        lines.append(
+            f"{key} {value.format(home=get_home_mount_path(spec), sshd_dir=sshd_dir)}"
+        )
+    if restricted and "pidfile" not in overridden:
+        lines.append(f"PidFile {sshd_dir}/sshd.pid")
+    for key, value in overrides.items():
+        lines.append(f"{key} {value}")
+    return "\n".join(lines) + "\n"
 
+
+def sshd_config_checksum(sshd_config: str) -> str:
+    """Checksum of the sshd_config, used to restart the pod when it changes."""
+    return hashlib.sha256(sshd_config.encode()).hexdigest()
+
+
+def build_configmap(
+    name: str,
+    namespace: str,
+    spec: Optional[Mapping[str, Any]] = None,
+    restricted: bool = False,
+) -> Dict[str, Any]:
+    """Builds the ConfigMap for the DevServer's sshd_config."""
     return {
         "apiVersion": "v1",
         "kind": "ConfigMap",
@@ -28,7 +99,7 @@ AllowAgentForwarding yes
             "namespace": namespace,
         },
         "data": {
-            "sshd_config": sshd_config,
+            "sshd_config": render_sshd_config(spec, restricted),
         },
     }
 
@@ -0,0 +1,67 @@
+import re
+from typing import Any, Dict, List, Mapping
+
+# Each bundle is mounted read-only at <CREDENTIALS_MOUNT_PATH>/<name>
+CREDENTIALS_MOUNT_PATH = "/etc/devserver/credentials"
+CREDENTIALS_VOLUME_NAME_PREFIX = "credentials-"
+SECRETS_STORE_CSI_DRIVER = "secrets-store.csi.k8s.io"
+
+# Leaves room for the volume name prefix within 63 characters
+_BUNDLE_NAME_RE = re.compile(r"^[a-z0-9]([-a-z0-9]{0,48}[a-z0-9])?$")
+
+
+def get_credential_bundles(spec: Mapping[str, Any]) -> List[Dict[str, Any]]:
+    """Returns the DevServer's `spec.credentialBundles`."""
+    return list(spec.get("credentialBundles") or [])
+
+
+def validate_user_credential_bundles(spec: Mapping[str, Any]) -> None:
+    """
+    Check `spec.credentialBundles`.
+
+    Raises:
+        ValueError: If a bundle's name is invalid or used more than once, or
+            it does not have exactly one source.
+    """
+    names = set()
+    for bundle in get_credential_bundles(spec):
+        name = bundle["name"]
+        if not _BUNDLE_NAME_RE.match(name):
+            raise ValueError(
+                f"credential bundle name '{name}' must be a DNS label of at most 50 characters."
+            )
+        if name in names:
+            raise ValueError(f"credential bundle '{name}' is defined more than once.")
+        names.add(name)
+        sources = {"secret", "csi"} & set(bundle)
+        if len(sources) != 1:
+            raise ValueError(f"credential bundle '{name}' must have exactly one of csi, secret.")
+
+
+def build_credential_volume(bundle: Mapping[str, Any]) -> Dict[str, Any]:
+    """
+    Builds the volume of a credential bundle: a Secret, e.g. one that External
+    Secrets syncs from a vault, or a SecretProviderClass of the Secrets Store
+    CSI driver, which fetches the secrets when the pod starts.
+    """
+    volume: Dict[str, Any] = {"name": f"{CREDENTIALS_VOLUME_NAME_PREFIX}{bundle['name']}"}
+    if "secret" in bundle:
+        volume["secret"] = {"secretName": bundle["secret"]["secretName"]}
+        if bundle["secret"].get("items"):
+            volume["secret"]["items"] = list(bundle["secret"]["items"])
+    else:
+        volume["csi"] = {
+            "driver": bundle["csi"].get("driver", SECRETS_STORE_CSI_DRIVER),
+            "readOnly": True,
+            "volumeAttributes": {"secretProviderClass": bundle["csi"]["secretProviderClass"]},
+        }
+    return volume
+
+
+def build_credential_mount(bundle: Mapping[str, Any]) -> Dict[str, Any]:
+    """Mounts a credential bundle read-only at its conventional path."""
+    return {
+        "name": f"{CREDENTIALS_VOLUME_NAME_PREFIX}{bundle['name']}",
+        "mountPath": f"{CREDENTIALS_MOUNT_PATH}/{bundle['name']}",
+        "readOnly": True,
+    }
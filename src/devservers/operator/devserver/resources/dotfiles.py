@@ -0,0 +1,91 @@
+"""
+Bootstrapping of the home directory from the user's dotfiles repository.
+
+`spec.dotfilesRepo` names a git repository that the `init-dotfiles` init
+container clones into `~/.dotfiles` of a fresh home directory, then runs its
+install script from, as the dev user and with the DevServer's image, so that
+the script finds the tools of the distribution the user logs into.
+"""
+from typing import Any, Dict, Mapping, Optional
+
+DOTFILES_CONTAINER_NAME = "init-dotfiles"
+
+# Runs once per home directory: an existing clone means the dotfiles are
+# installed. Failures are logged but do not keep the DevServer from starting;
+# the clone is removed, so that the next restart tries again.
+INIT_DOTFILES_SCRIPT = """
+set -u
+DIR="$HOME/.dotfiles"
+if [ -e "$DIR" ]; then
+  echo "[DOTFILES] Dotfiles already installed."
+  exit 0
+fi
+fail() {
+  echo "[DOTFILES] $1, starting without dotfiles."
+  rm -rf "$DIR"
+  exit 0
+}
+command -v git > /dev/null || fail "The image has no git"
+echo "[DOTFILES] Cloning $DOTFILES_URL..."
+git clone --quiet -- "$DOTFILES_URL" "$DIR" || fail "Cloning failed"
+if [ -n "$DOTFILES_REF" ]; then
+  git -C "$DIR" checkout --quiet "$DOTFILES_REF" || fail "Checking out $DOTFILES_REF failed"
+fi
+if [ -n "$DOTFILES_INSTALL_SCRIPT" ]; then
+  echo "[DOTFILES] Running $DOTFILES_INSTALL_SCRIPT..."
+  cd "$DIR" || fail "Entering $DIR failed"
+  if [ -x "$DOTFILES_INSTALL_SCRIPT" ]; then
+    "./$DOTFILES_INSTALL_SCRIPT" || fail "$DOTFILES_INSTALL_SCRIPT failed"
+  else
+    sh "$DOTFILES_INSTALL_SCRIPT" || fail "$DOTFILES_INSTALL_SCRIPT failed"
+  fi
+fi
+echo "[DOTFILES] Dotfiles installed."
+"""
+
+
+def get_dotfiles_repo(spec: Mapping[str, Any]) -> Optional[Dict[str, Any]]:
+    """Returns the DevServer's `spec.dotfilesRepo`, if any."""
+    repo = spec.get("dotfilesRepo")
+    return dict(repo) if repo else None
+
+
+def validate_user_dotfiles_repo(spec: Mapping[str, Any]) -> None:
+    """
+    Check `spec.dotfilesRepo`.
+
+    Raises:
+        ValueError: If the URL is not an https:// URL, the ref looks like an
+            option, or the install script is not a path within the repository.
+    """
+    repo = get_dotfiles_repo(spec)
+    if repo is None:
+        return
+    # The init container has no SSH keys, so only public or token URLs work
+    if not repo["url"].startswith("https://"):
+        raise ValueError(f"url '{repo['url']}' is not an https:// URL.")
+    if repo.get("ref", "").startswith("-"):
+        raise ValueError(f"ref '{repo['ref']}' is not a branch, tag or commit.")
+    script = repo.get("installScript", "")
+    if script.startswith("/") or ".." in script.split("/"):
+        raise ValueError(f"installScript '{script}' must be a path within the repository.")
+
+
+def build_dotfiles_container(
+    repo: Mapping[str, Any], image: str, home: str, uid: int, gid: int
+) -> Dict[str, Any]:
+    """Builds the init container cloning and installing the dotfiles as the dev user."""
+    return {
+        "name": DOTFILES_CONTAINER_NAME,
+        "image": image,
+        "command": ["/bin/sh", "-c"],
+        "args": [INIT_DOTFILES_SCRIPT],
+        "env": [
+            {"name": "HOME", "value": home},
+            {"name": "DOTFILES_URL", "value": repo["url"]},
+            {"name": "DOTFILES_REF", "value": repo.get("ref", "")},
+            {"name": "DOTFILES_INSTALL_SCRIPT", "value": repo.get("installScript", "")},
+        ],
+        "securityContext": {"runAsUser": uid, "runAsGroup": gid},
+        "volumeMounts": [{"name": "home", "mountPath": home}],
+    }
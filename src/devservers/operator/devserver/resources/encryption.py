@@ -0,0 +1,130 @@
+"""
+Encrypted home volumes.
+
+Encryption is a property of the StorageClass the home PVC is provisioned
+with: the flavor's `homeEncryption.storageClassName` names one that encrypts
+its volumes. A customer-managed KMS key is set through the StorageClass's
+parameters, so a DevServer or flavor with a `kmsKeyID` gets a copy of that
+class, managed by the operator, that also sets the key.
+"""
+import hashlib
+from typing import Any, Dict, Mapping, Optional
+
+from devservers.crds.const import CRD_GROUP
+
+# The StorageClass parameters that set the KMS key, by CSI driver
+KMS_KEY_PARAMETERS = {
+    "ebs.csi.aws.com": ("kmsKeyId", {"encrypted": "true"}),
+    "pd.csi.storage.gke.io": ("disk-encryption-kms-key", {}),
+    "disk.csi.azure.com": ("diskEncryptionSetID", {}),
+}
+KMS_KEY_ANNOTATION = f"{CRD_GROUP}/kms-key-id"
+MANAGED_LABEL = f"{CRD_GROUP}/managed"
+
+
+def home_encryption_requested(spec: Mapping[str, Any], flavor: Mapping[str, Any]) -> bool:
+    """Whether the home volume is encrypted, as the DevServer asks or the flavor requires."""
+    flavor_encryption = flavor.get("spec", {}).get("homeEncryption", {})
+    encryption = spec.get("persistentHome", {}).get("encryption", {})
+    return bool(encryption.get("enabled") or flavor_encryption.get("required"))
+
+
+def get_kms_key_id(spec: Mapping[str, Any], flavor: Mapping[str, Any]) -> Optional[str]:
+    """The DevServer's KMS key, defaulting to the flavor's."""
+    encryption = spec.get("persistentHome", {}).get("encryption", {})
+    flavor_encryption = flavor.get("spec", {}).get("homeEncryption", {})
+    return encryption.get("kmsKeyID") or flavor_encryption.get("kmsKeyID")
+
+
+def encrypted_storage_class_name(base: str, kms_key_id: str) -> str:
+    """The name of the copy of an encrypted StorageClass that uses the KMS key."""
+    digest = hashlib.sha256(kms_key_id.encode()).hexdigest()[:10]
+    return f"{base}-{digest}"
+
+
+def home_storage_class_name(spec: Mapping[str, Any], flavor: Mapping[str, Any]) -> Optional[str]:
+    """
+    The StorageClass of the home PVC: the encrypted one when encryption is
+    requested, and otherwise the DevServer's, then the flavor's.
+    """
+    if home_encryption_requested(spec, flavor):
+        base = flavor["spec"]["homeEncryption"]["storageClassName"]
+        kms_key_id = get_kms_key_id(spec, flavor)
+        return encrypted_storage_class_name(base, kms_key_id) if kms_key_id else base
+    # The flavor's storage class is a default, e.g. local NVMe for GPU flavors
+    return spec.get("persistentHome", {}).get("storageClassName") or flavor["spec"].get(
+        "storageClassName"
+    )
+
+
+def validate_user_home_encryption(spec: Mapping[str, Any], flavor: Mapping[str, Any]) -> None:
+    """
+    Check that the home volume can be encrypted as requested.
+
+    Raises:
+        ValueError: If encryption is requested without a persistent home, an
+            encrypted StorageClass in the flavor, or with a StorageClass of
+            the DevServer's own.
+    """
+    if not home_encryption_requested(spec, flavor):
+        if spec.get("persistentHome", {}).get("encryption", {}).get("kmsKeyID"):
+            raise ValueError("persistentHome.encryption.kmsKeyID requires encryption.enabled.")
+        return
+    persistent_home = spec.get("persistentHome", {})
+    if not persistent_home.get("enabled", False):
+        raise ValueError("home encryption requires persistentHome.enabled.")
+    if not flavor.get("spec", {}).get("homeEncryption", {}).get("storageClassName"):
+        raise ValueError(
+            f"flavor '{flavor.get('metadata', {}).get('name', '')}' has no encrypted "
+            "StorageClass in homeEncryption.storageClassName."
+        )
+    if persistent_home.get("storageClassName"):
+        raise ValueError("an encrypted home cannot have persistentHome.storageClassName.")
+    if "fromDevServer" in spec.get("homeSource", {}):
+        # A CSI clone keeps its source's StorageClass, and so its encryption
+        raise ValueError("an encrypted home cannot be cloned from another DevServer.")
+
+
+def build_encrypted_storage_class(
+    base: Mapping[str, Any], kms_key_id: str
+) -> Dict[str, Any]:
+    """
+    Builds the copy of an encrypted StorageClass, in its serialized form,
+    that encrypts volumes with the KMS key.
+
+    Raises:
+        ValueError: If the operator does not know how the StorageClass's
+            provisioner takes a KMS key.
+    """
+    provisioner = base["provisioner"]
+    if provisioner not in KMS_KEY_PARAMETERS:
+        raise ValueError(
+            f"StorageClass '{base['metadata']['name']}' uses provisioner '{provisioner}', "
+            "which does not take a KMS key."
+        )
+    key_parameter, extra_parameters = KMS_KEY_PARAMETERS[provisioner]
+    storage_class: Dict[str, Any] = {
+        "apiVersion": "storage.k8s.io/v1",
+        "kind": "StorageClass",
+        "metadata": {
+            "name": encrypted_storage_class_name(base["metadata"]["name"], kms_key_id),
+            "labels": {MANAGED_LABEL: "true"},
+            "annotations": {KMS_KEY_ANNOTATION: kms_key_id},
+        },
+        "provisioner": provisioner,
+        "parameters": {
+            **base.get("parameters", {}),
+            **extra_parameters,
+            key_parameter: kms_key_id,
+        },
+    }
+    for field in (
+        "reclaimPolicy",
+        "volumeBindingMode",
+        "allowVolumeExpansion",
+        "allowedTopologies",
+        "mountOptions",
+    ):
+        if base.get(field) is not None:
+            storage_class[field] = base[field]
+    return storage_class
@@ -0,0 +1,49 @@
+from typing import Any, Dict, Optional
+
+GATEWAY_API_GROUP = "gateway.networking.k8s.io"
+GATEWAY_API_VERSION = "v1alpha2"
+
+ROUTE_KIND_TCP = "TCPRoute"
+ROUTE_KIND_TLS = "TLSRoute"
+ROUTE_PLURALS = {ROUTE_KIND_TCP: "tcproutes", ROUTE_KIND_TLS: "tlsroutes"}
+
+
+def ssh_route_hostname(name: str, namespace: str, domain: str) -> str:
+    """The SNI hostname a DevServer is reachable under through a TLS gateway."""
+    return f"{name}.{namespace}.{domain}"
+
+
+def build_ssh_route(
+    name: str,
+    namespace: str,
+    kind: str,
+    gateway_name: str,
+    gateway_namespace: str,
+    section_name: Optional[str] = None,
+    domain: Optional[str] = None,
+) -> Dict[str, Any]:
+    """
+    Builds a Gateway API route sending SSH traffic to the DevServer's SSH Service.
+
+    A TCPRoute takes all traffic of the Gateway listener it attaches to; a
+    TLSRoute matches on the SNI hostname `<name>.<namespace>.<domain>`, so
+    many DevServers can share a single listener.
+    """
+    parent_ref: Dict[str, Any] = {"name": gateway_name, "namespace": gateway_namespace}
+    if section_name:
+        parent_ref["sectionName"] = section_name
+
+    route_spec: Dict[str, Any] = {
+        "parentRefs": [parent_ref],
+        "rules": [{"backendRefs": [{"name": f"{name}-ssh", "port": 22}]}],
+    }
+    if kind == ROUTE_KIND_TLS:
+        assert domain, "TLSRoutes require a domain"
+        route_spec["hostnames"] = [ssh_route_hostname(name, namespace, domain)]
+
+    return {
+        "apiVersion": f"{GATEWAY_API_GROUP}/{GATEWAY_API_VERSION}",
+        "kind": kind,
+        "metadata": {"name": f"{name}-ssh", "namespace": namespace},
+        "spec": route_spec,
+    }
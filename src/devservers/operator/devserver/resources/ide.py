@@ -0,0 +1,123 @@
+from typing import Any, Dict, Mapping, Optional
+
+IDE_CODE_SERVER = "code-server"
+CODE_SERVER_CONTAINER_NAME = "code-server"
+CODE_SERVER_PORT = 8080
+# Key of the code-server password in the DevServer's IDE Secret
+IDE_PASSWORD_KEY = "password"
+
+
+def ide_name(name: str) -> str:
+    """The name of the DevServer's IDE Service, Ingress and password Secret."""
+    return f"{name}-ide"
+
+
+def ide_hostname(name: str, namespace: str, domain: str) -> str:
+    """The hostname the DevServer's IDE is reachable under through its Ingress."""
+    return f"{name}.{namespace}.{domain}"
+
+
+def build_code_server_container(
+    name: str, image: str, home: str, uid: int, gid: int
+) -> Dict[str, Any]:
+    """
+    Builds the code-server sidecar. It runs as the dev user on the home
+    volume, so files it edits belong to the user and its extensions and
+    settings persist in the home directory.
+    """
+    return {
+        "name": CODE_SERVER_CONTAINER_NAME,
+        "image": image,
+        "args": [
+            "--bind-addr", f"0.0.0.0:{CODE_SERVER_PORT}",
+            "--auth", "password",
+            "--disable-telemetry",
+            home,
+        ],
+        "env": [
+            {"name": "HOME", "value": home},
+            {
+                "name": "PASSWORD",
+                "valueFrom": {
+                    "secretKeyRef": {"name": ide_name(name), "key": IDE_PASSWORD_KEY}
+                },
+            },
+        ],
+        "ports": [
+            {
+                "name": CODE_SERVER_CONTAINER_NAME,
+                "containerPort": CODE_SERVER_PORT,
+                "protocol": "TCP",
+            }
+        ],
+        "securityContext": {"runAsUser": uid, "runAsGroup": gid},
+        "volumeMounts": [{"name": "home", "mountPath": home}],
+    }
+
+
+def build_ide_service(name: str, namespace: str) -> Dict[str, Any]:
+    """Builds the ClusterIP Service in front of the DevServer's IDE."""
+    return {
+        "apiVersion": "v1",
+        "kind": "Service",
+        "metadata": {"name": ide_name(name), "namespace": namespace},
+        "spec": {
+            "type": "ClusterIP",
+            "selector": {"app": name},
+            "ports": [
+                {
+                    "name": "http",
+                    "port": 80,
+                    "targetPort": CODE_SERVER_CONTAINER_NAME,
+                    "protocol": "TCP",
+                }
+            ],
+        },
+    }
+
+
+def build_ide_ingress(
+    name: str,
+    namespace: str,
+    host: str,
+    ingress_class_name: Optional[str] = None,
+    annotations: Optional[Mapping[str, str]] = None,
+    tls_secret_name: Optional[str] = None,
+) -> Dict[str, Any]:
+    """
+    Builds the Ingress exposing the DevServer's IDE under its hostname.
+
+    Annotations can put an authenticating proxy, e.g. oauth2-proxy, in front
+    of code-server's own password.
+    """
+    metadata: Dict[str, Any] = {"name": ide_name(name), "namespace": namespace}
+    if annotations:
+        metadata["annotations"] = dict(annotations)
+    ingress_spec: Dict[str, Any] = {
+        "rules": [
+            {
+                "host": host,
+                "http": {
+                    "paths": [
+                        {
+                            "path": "/",
+                            "pathType": "Prefix",
+                            "backend": {
+                                "service": {"name": ide_name(name), "port": {"name": "http"}}
+                            },
+                        }
+                    ]
+                },
+            }
+        ],
+    }
+    if ingress_class_name:
+        ingress_spec["ingressClassName"] = ingress_class_name
+    if tls_secret_name:
+        ingress_spec["tls"] = [{"hosts": [host], "secretName": tls_secret_name}]
+    return {
+        "apiVersion": "networking.k8s.io/v1",
+        "kind": "Ingress",
+        "metadata": metadata,
+        "spec": ingress_spec,
+    }
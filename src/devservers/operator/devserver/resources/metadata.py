@@ -0,0 +1,43 @@
+from typing import Any, Dict, Mapping, Tuple
+
+# Metadata written by tools rather than users, never propagated
+UNPROPAGATED_PREFIXES = ("kubectl.kubernetes.io/", "kopf.zalando.org/")
+
+PROPAGATE_ALL = "*"
+
+
+def _select(values: Mapping[str, str], keys: Any) -> Dict[str, str]:
+    if not keys:
+        return {}
+    return {
+        key: value
+        for key, value in values.items()
+        if (PROPAGATE_ALL in keys or key in keys) and not key.startswith(UNPROPAGATED_PREFIXES)
+    }
+
+
+def propagated_metadata(
+    spec: Mapping[str, Any], meta: Mapping[str, Any]
+) -> Tuple[Dict[str, str], Dict[str, str]]:
+    """
+    The DevServer's labels and annotations that `spec.metadataPropagation`
+    copies onto its child resources.
+
+    Returns:
+        The labels and the annotations.
+    """
+    policy = spec.get("metadataPropagation", {})
+    labels = _select(meta.get("labels") or {}, policy.get("labels"))
+    annotations = _select(meta.get("annotations") or {}, policy.get("annotations"))
+    return labels, annotations
+
+
+def apply_metadata(
+    resource: Dict[str, Any], labels: Mapping[str, str], annotations: Mapping[str, str]
+) -> None:
+    """Add labels and annotations to a resource, keeping those it already sets."""
+    metadata = resource.setdefault("metadata", {})
+    if labels:
+        metadata["labels"] = {**labels, **metadata.get("labels", {})}
+    if annotations:
+        metadata["annotations"] = {**annotations, **metadata.get("annotations", {})}
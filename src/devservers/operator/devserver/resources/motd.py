@@ -0,0 +1,50 @@
+"""
+Login banner with the DevServer's metadata.
+
+The `<name>-motd` ConfigMap holds the DevServer's flavor, owner, collaborators
+and expiry, one file each, and how to extend it. It is mounted as a directory,
+so that the kubelet refreshes it in the running pod whenever the operator
+updates it, e.g. after `devctl extend`. user_login.sh shows it, with a
+countdown to the expiry, at every interactive login.
+"""
+from datetime import datetime
+from typing import Any, Dict, Optional, Sequence
+
+MOTD_VOLUME_NAME = "motd"
+MOTD_MOUNT_PATH = "/devserver-motd"
+
+
+def motd_configmap_name(name: str) -> str:
+    """The name of the DevServer's login banner ConfigMap."""
+    return f"{name}-motd"
+
+
+def build_motd_configmap(
+    name: str,
+    namespace: str,
+    flavor_name: str,
+    owner: Optional[str],
+    expires_at: Optional[datetime],
+    collaborators: Sequence[str] = (),
+) -> Dict[str, Any]:
+    """
+    Builds the ConfigMap of the DevServer's login banner. The expiry is also
+    given in seconds since the epoch, which the login script counts down from
+    with only `date +%s`.
+    """
+    data = {
+        "name": name,
+        "namespace": namespace,
+        "flavor": flavor_name,
+        "owner": owner or "",
+        "collaborators": ", ".join(collaborators),
+        "expiresAt": expires_at.strftime("%Y-%m-%dT%H:%M:%SZ") if expires_at else "",
+        "expiresAtEpoch": str(int(expires_at.timestamp())) if expires_at else "",
+        "extendCommand": f"devctl extend {name} <duration> -n {namespace}",
+    }
+    return {
+        "apiVersion": "v1",
+        "kind": "ConfigMap",
+        "metadata": {"name": motd_configmap_name(name), "namespace": namespace},
+        "data": data,
+    }
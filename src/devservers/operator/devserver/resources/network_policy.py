@@ -0,0 +1,72 @@
+from typing import Any, Dict, List, Optional, Sequence
+
+# Private ranges left out of the internet egress rule, so that traffic to
+# pods and Services has to be allowed by namespace
+PRIVATE_CIDRS = ["10.0.0.0/8", "172.16.0.0/12", "192.168.0.0/16"]
+
+
+def network_policy_name(name: str) -> str:
+    return f"{name}-isolation"
+
+
+def _namespace_peer(namespace: str) -> Dict[str, Any]:
+    return {"namespaceSelector": {"matchLabels": {"kubernetes.io/metadata.name": namespace}}}
+
+
+def build_network_policy(
+    name: str,
+    namespace: str,
+    ssh_port: int,
+    mosh_ports: Sequence[int],
+    ssh_cidrs: Sequence[str],
+    ssh_namespaces: Sequence[str],
+    egress_namespaces: Sequence[str],
+    bastion_name: str = "",
+    ide_port: Optional[int] = None,
+    ide_namespaces: Sequence[str] = (),
+) -> Dict[str, Any]:
+    """
+    Builds the NetworkPolicy isolating the DevServer's pod.
+
+    Ingress is limited to SSH, and mosh when enabled, from the given CIDRs and
+    namespaces and from the namespace's bastion, if any, and to the IDE's
+    port from the ingress controller's namespaces. Egress is limited to DNS,
+    the internet, and pods in the given namespaces.
+    """
+    sources: List[Dict[str, Any]] = [{"ipBlock": {"cidr": cidr}} for cidr in ssh_cidrs]
+    sources += [_namespace_peer(peer) for peer in ssh_namespaces]
+    if bastion_name:
+        sources.append({"podSelector": {"matchLabels": {"app": bastion_name}}})
+    ports = [{"protocol": "TCP", "port": ssh_port}]
+    ports += [{"protocol": "UDP", "port": port} for port in mosh_ports]
+
+    ingress: List[Dict[str, Any]] = []
+    # Without sources, nothing may connect to the DevServer
+    if sources:
+        ingress.append({"from": sources, "ports": ports})
+    if ide_port is not None and ide_namespaces:
+        ingress.append(
+            {
+                "from": [_namespace_peer(peer) for peer in ide_namespaces],
+                "ports": [{"protocol": "TCP", "port": ide_port}],
+            }
+        )
+
+    egress: List[Dict[str, Any]] = [
+        {"ports": [{"protocol": "UDP", "port": 53}, {"protocol": "TCP", "port": 53}]},
+        {"to": [{"ipBlock": {"cidr": "0.0.0.0/0", "except": PRIVATE_CIDRS}}]},
+    ]
+    if egress_namespaces:
+        egress.append({"to": [_namespace_peer(peer) for peer in egress_namespaces]})
+
+    return {
+        "apiVersion": "networking.k8s.io/v1",
+        "kind": "NetworkPolicy",
+        "metadata": {"name": network_policy_name(name), "namespace": namespace},
+        "spec": {
+            "podSelector": {"matchLabels": {"app": name}},
+            "policyTypes": ["Ingress", "Egress"],
+            "ingress": ingress,
+            "egress": egress,
+        },
+    }
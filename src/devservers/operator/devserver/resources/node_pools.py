@@ -0,0 +1,65 @@
+"""
+Dedicated node pools of teams.
+
+A flavor's `spec.teamNodePools` maps the namespaces and owners of teams to the
+node pools billed to them. DevServers of a team get the node selector of its
+pool, so they only land on its nodes, and tolerate the taints that keep other
+teams' pods off them.
+"""
+from typing import Any, Dict, Mapping, Optional
+
+from devservers.utils.flavors import get_flavor_node_selector
+
+
+def find_team_node_pool(
+    namespace: str, spec: Mapping[str, Any], flavor: Mapping[str, Any]
+) -> Optional[Dict[str, Any]]:
+    """The first of the flavor's team node pools that the DevServer's namespace or owner is in."""
+    owner = spec.get("owner", "").lower()
+    for pool in flavor.get("spec", {}).get("teamNodePools", []):
+        owners = {o.lower() for o in pool.get("owners", [])}
+        if namespace in pool.get("namespaces", []) or (owner and owner in owners):
+            return dict(pool)
+    return None
+
+
+def team_node_pool_scheduling(pool: Optional[Mapping[str, Any]]) -> Dict[str, Any]:
+    """The node selector and tolerations that put a pod on the team's node pool."""
+    if pool is None:
+        return {"nodeSelector": {}, "tolerations": []}
+    return {
+        "nodeSelector": dict(pool.get("nodeSelector", {})),
+        "tolerations": list(pool.get("tolerations", [])),
+    }
+
+
+def validate_user_team_node_pool(
+    namespace: str, spec: Mapping[str, Any], flavor: Dict[str, Any]
+) -> None:
+    """
+    Check that the DevServer can run on its team's node pool.
+
+    Raises:
+        ValueError: If the flavor requires a team node pool and none matches
+            the DevServer, or the pool's node selector contradicts the
+            flavor's.
+    """
+    pool = find_team_node_pool(namespace, spec, flavor)
+    if pool is None:
+        if flavor["spec"].get("teamNodePoolRequired", False):
+            raise ValueError(
+                f"no team node pool of flavor '{flavor.get('metadata', {}).get('name', '')}' "
+                f"matches namespace '{namespace}' or owner '{spec.get('owner', '')}'."
+            )
+        return
+    flavor_node_selector = get_flavor_node_selector(flavor)
+    conflicts = sorted(
+        key
+        for key, value in pool.get("nodeSelector", {}).items()
+        if key in flavor_node_selector and flavor_node_selector[key] != value
+    )
+    if conflicts:
+        raise ValueError(
+            f"the nodeSelector of team node pool '{pool['name']}' contradicts the flavor's "
+            f"on {', '.join(conflicts)}."
+        )
@@ -0,0 +1,168 @@
+from typing import Any, Dict, List, Mapping, Optional, Tuple
+
+# rclone streams to S3 and GCS alike, and its Alpine base has tar
+RCLONE_IMAGE = "rclone/rclone:1.68"
+
+# rclone remote configured through RCLONE_CONFIG_<REMOTE>_* variables
+RCLONE_REMOTE = "backup"
+
+GCS_CREDENTIALS_KEY = "credentials.json"
+GCS_CREDENTIALS_MOUNT_PATH = "/var/run/secrets/devserver.io/gcs"
+
+
+def backup_object_path(
+    destination: Mapping[str, Any], namespace: str, devserver_name: str, backup_name: str
+) -> str:
+    """The `<bucket>/<key>` of a DevServerBackup's archive."""
+    location = destination.get("s3") or destination["gcs"]
+    prefix = location.get("prefix", "").strip("/")
+    key = f"{namespace}/{devserver_name}/{backup_name}.tar.gz"
+    return "/".join(part for part in (location["bucket"], prefix, key) if part)
+
+
+def backup_object_url(destination: Mapping[str, Any], object_path: str) -> str:
+    """The s3:// or gs:// URL of an archive, as reported in DevServerBackup statuses."""
+    scheme = "s3" if "s3" in destination else "gs"
+    return f"{scheme}://{object_path}"
+
+
+def parse_backup_url(url: str) -> Tuple[Dict[str, Any], str]:
+    """
+    Split an s3:// or gs:// archive URL into a destination and the archive's
+    `<bucket>/<key>`.
+
+    Raises:
+        ValueError: If the URL is not an s3:// or gs:// URL with a key.
+    """
+    scheme, sep, object_path = url.partition("://")
+    bucket, _, key = object_path.partition("/")
+    if not sep or scheme not in ("s3", "gs") or not bucket or not key:
+        raise ValueError(f"'{url}' is not an s3:// or gs:// URL of an archive.")
+    provider = "s3" if scheme == "s3" else "gcs"
+    return {provider: {"bucket": bucket}}, object_path
+
+
+def rclone_remote_env(destination: Mapping[str, Any], env_auth: bool) -> Dict[str, str]:
+    """
+    The RCLONE_CONFIG_* variables configuring the rclone remote for a destination.
+
+    Args:
+        env_auth: Take the credentials from the environment, e.g.
+            AWS_ACCESS_KEY_ID, IRSA on EKS or Workload Identity on GKE.
+    """
+    prefix = f"RCLONE_CONFIG_{RCLONE_REMOTE.upper()}"
+    s3 = destination.get("s3")
+    if s3:
+        env = {
+            f"{prefix}_TYPE": "s3",
+            f"{prefix}_PROVIDER": "Other" if s3.get("endpoint") else "AWS",
+            # S3 credentials are always passed as AWS_* variables
+            f"{prefix}_ENV_AUTH": "true",
+        }
+        if s3.get("region"):
+            env[f"{prefix}_REGION"] = s3["region"]
+        if s3.get("endpoint"):
+            env[f"{prefix}_ENDPOINT"] = s3["endpoint"]
+        return env
+    env = {
+        f"{prefix}_TYPE": "google cloud storage",
+        # Needed for uploads to buckets with uniform bucket-level access
+        f"{prefix}_BUCKET_POLICY_ONLY": "true",
+    }
+    if env_auth:
+        env[f"{prefix}_ENV_AUTH"] = "true"
+    return env
+
+
+def rclone_credentials_env(
+    destination: Mapping[str, Any], credentials: Mapping[str, str]
+) -> Dict[str, str]:
+    """Inline the (decoded) data of a credentials Secret as rclone variables."""
+    if "s3" in destination:
+        return {
+            key: credentials[key]
+            for key in ("AWS_ACCESS_KEY_ID", "AWS_SECRET_ACCESS_KEY", "AWS_SESSION_TOKEN")
+            if key in credentials
+        }
+    prefix = f"RCLONE_CONFIG_{RCLONE_REMOTE.upper()}"
+    return {f"{prefix}_SERVICE_ACCOUNT_CREDENTIALS": credentials[GCS_CREDENTIALS_KEY]}
+
+
+def rclone_config(
+    destination: Mapping[str, Any], credentials_secret_ref: Optional[Mapping[str, Any]]
+) -> Tuple[List[Dict[str, Any]], List[Dict[str, Any]], List[Dict[str, Any]]]:
+    """
+    Configure the rclone remote for a destination.
+
+    Without a credentials Secret, credentials come from the environment,
+    e.g. IRSA on EKS or Workload Identity on GKE.
+
+    Returns:
+        The container's env and env sources, and the pod volumes it needs.
+    """
+    remote_env = rclone_remote_env(destination, env_auth=not credentials_secret_ref)
+    env: List[Dict[str, Any]] = [{"name": k, "value": v} for k, v in remote_env.items()]
+    env_from: List[Dict[str, Any]] = []
+    volumes: List[Dict[str, Any]] = []
+    if credentials_secret_ref and "s3" in destination:
+        env_from.append({"secretRef": {"name": credentials_secret_ref["name"]}})
+    elif credentials_secret_ref:
+        env.append({
+            "name": f"RCLONE_CONFIG_{RCLONE_REMOTE.upper()}_SERVICE_ACCOUNT_FILE",
+            "value": f"{GCS_CREDENTIALS_MOUNT_PATH}/{GCS_CREDENTIALS_KEY}",
+        })
+        volumes.append({
+            "name": "gcs-credentials",
+            "secret": {
+                "secretName": credentials_secret_ref["name"],
+                "items": [{"key": GCS_CREDENTIALS_KEY, "path": GCS_CREDENTIALS_KEY}],
+            },
+        })
+    return env, env_from, volumes
+
+
+def rclone_volume_mounts(volumes: List[Dict[str, Any]]) -> List[Dict[str, Any]]:
+    """Mounts for the volumes returned by `rclone_config`."""
+    return [
+        {"name": volume["name"], "mountPath": GCS_CREDENTIALS_MOUNT_PATH, "readOnly": True}
+        for volume in volumes
+    ]
+
+
+def home_source_secret_name(name: str) -> str:
+    """The Secret configuring the restore of a DevServer's home from a backup."""
+    return f"{name}-home-source"
+
+
+# Restores only into a home that was never restored, so restarts keep the user's changes
+RESTORE_HOME_SCRIPT = f"""
+set -euo pipefail
+if [ -e /home/dev/.devserver-restored ]; then
+  echo "[INIT] Home directory already restored."
+  exit 0
+fi
+: "${{BACKUP_OBJECT_PATH:?the backup to restore was not resolved}}"
+echo "[INIT] Restoring the home directory from $BACKUP_OBJECT_PATH..."
+rclone cat "{RCLONE_REMOTE}:$BACKUP_OBJECT_PATH" | tar -xzf - -C /home/dev
+touch /home/dev/.devserver-restored
+echo "[INIT] Home directory restored."
+"""
+
+
+def build_restore_home_container(name: str) -> Dict[str, Any]:
+    """
+    Builds the init container restoring a DevServer's home from a backup.
+
+    Its configuration is read from a Secret so that the pod template does
+    not change once the backup was resolved.
+    """
+    return {
+        "name": "restore-home",
+        "image": RCLONE_IMAGE,
+        "command": ["/bin/sh", "-c"],
+        "args": [RESTORE_HOME_SCRIPT],
+        "envFrom": [
+            {"secretRef": {"name": home_source_secret_name(name), "optional": True}}
+        ],
+        "volumeMounts": [{"name": "home", "mountPath": "/home/dev"}],
+    }
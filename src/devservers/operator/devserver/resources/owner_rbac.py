@@ -0,0 +1,111 @@
+"""
+Builders for the per-owner Role and RoleBinding of a namespace.
+
+RBAC cannot select objects by their fields or labels, so the Role names each
+of the owner's DevServers, those shared with them as a collaborator, and their
+pods and SSH Services, in `resourceNames`. It is rebuilt whenever they change.
+"""
+from typing import Any, Dict, List, Sequence
+
+from devservers.crds.const import CRD_GROUP, CRD_PLURAL_DEVSERVER
+from devservers.utils.users import owner_to_dns_label
+
+OWNER_ROLE_PREFIX = "devserver-owner"
+OWNER_LABEL = f"{CRD_GROUP}/owner"
+OWNER_ANNOTATION = f"{CRD_GROUP}/owner"
+
+
+def owner_role_name(owner: str) -> str:
+    """Returns the name of the owner's Role and RoleBinding."""
+    return f"{OWNER_ROLE_PREFIX}-{owner_to_dns_label(owner)}"
+
+
+def _metadata(namespace: str, owner: str) -> Dict[str, Any]:
+    return {
+        "name": owner_role_name(owner),
+        "namespace": namespace,
+        "labels": {OWNER_LABEL: owner_to_dns_label(owner)},
+        # The label is lossy, e.g. for email addresses
+        "annotations": {OWNER_ANNOTATION: owner},
+    }
+
+
+def build_owner_role(
+    namespace: str,
+    owner: str,
+    devserver_names: Sequence[str],
+    shared_names: Sequence[str] = (),
+) -> Dict[str, Any]:
+    """
+    Builds the Role that lets the owner get, update and delete their
+    DevServers, and connect to them with SSH through a port-forward or exec.
+    DevServers they collaborate on can be read and connected to, but not
+    changed, so that collaborators cannot take them over.
+    """
+    names = sorted(devserver_names)
+    shared = sorted(shared_names)
+    pods = [f"{name}-0" for name in names + shared]
+    rules: List[Dict[str, Any]] = []
+    if names:
+        rules.append(
+            {
+                "apiGroups": [CRD_GROUP],
+                "resources": [CRD_PLURAL_DEVSERVER],
+                "resourceNames": names,
+                "verbs": ["get", "update", "patch", "delete"],
+            }
+        )
+    if shared:
+        rules.append(
+            {
+                "apiGroups": [CRD_GROUP],
+                "resources": [CRD_PLURAL_DEVSERVER],
+                "resourceNames": shared,
+                "verbs": ["get"],
+            }
+        )
+    rules += [
+        {"apiGroups": [""], "resources": ["pods"], "resourceNames": pods, "verbs": ["get"]},
+        {
+            "apiGroups": [""],
+            "resources": ["pods/portforward"],
+            "resourceNames": pods,
+            "verbs": ["get", "create"],
+        },
+        {
+            "apiGroups": [""],
+            "resources": ["pods/exec"],
+            "resourceNames": pods,
+            "verbs": ["create"],
+        },
+        # For the SSH endpoint, when it is reachable without a port-forward
+        {
+            "apiGroups": [""],
+            "resources": ["services"],
+            "resourceNames": [f"{name}-ssh" for name in names + shared],
+            "verbs": ["get"],
+        },
+    ]
+    return {
+        "apiVersion": "rbac.authorization.k8s.io/v1",
+        "kind": "Role",
+        "metadata": _metadata(namespace, owner),
+        "rules": rules,
+    }
+
+
+def build_owner_role_binding(
+    namespace: str, owner: str, subjects: Sequence[Dict[str, Any]]
+) -> Dict[str, Any]:
+    """Builds the RoleBinding granting the owner's Role to their cluster identities."""
+    return {
+        "apiVersion": "rbac.authorization.k8s.io/v1",
+        "kind": "RoleBinding",
+        "metadata": _metadata(namespace, owner),
+        "subjects": list(subjects),
+        "roleRef": {
+            "apiGroup": "rbac.authorization.k8s.io",
+            "kind": "Role",
+            "name": owner_role_name(owner),
+        },
+    }
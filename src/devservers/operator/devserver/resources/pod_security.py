@@ -0,0 +1,100 @@
+from typing import Any, Dict, List, Mapping
+
+# The flavor's securityProfiles keys, and the securityContext fields they set
+SECURITY_PROFILE_FIELDS = {"seccomp": "seccompProfile", "appArmor": "appArmorProfile"}
+
+# sshd cannot bind privileged ports without root
+RESTRICTED_SSH_PORT = 2222
+# Where a non-root sshd keeps its config, host keys and PID file, as /etc/ssh
+# is not writable
+RESTRICTED_SSHD_DIR = "/tmp/devserver-ssh"
+
+
+def get_flavor_security_exceptions(flavor: Mapping[str, Any]) -> Dict[str, Any]:
+    """The flavor's exceptions to the restricted Pod Security Standard, e.g. for GPU devices."""
+    return flavor.get("spec", {}).get("podSecurityExceptions", {})
+
+
+def get_flavor_security_profiles(flavor: Mapping[str, Any]) -> Dict[str, Dict[str, Any]]:
+    """The flavor's seccomp and AppArmor profiles, keyed by their securityContext field."""
+    profiles = flavor.get("spec", {}).get("securityProfiles", {})
+    return {
+        field: dict(profiles[key])
+        for key, field in SECURITY_PROFILE_FIELDS.items()
+        if key in profiles
+    }
+
+
+def validate_user_security_profiles(flavor: Mapping[str, Any], restricted: bool = False) -> None:
+    """
+    Check the flavor's seccomp and AppArmor profiles.
+
+    Raises:
+        ValueError: If a Localhost profile does not name its profile, another
+            type names one, or a profile is Unconfined while pods must comply
+            with the restricted Pod Security Standard.
+    """
+    for field, profile in get_flavor_security_profiles(flavor).items():
+        profile_type = profile.get("type")
+        if profile_type == "Localhost" and not profile.get("localhostProfile"):
+            raise ValueError(f"{field} of type Localhost needs a localhostProfile.")
+        if profile_type != "Localhost" and profile.get("localhostProfile"):
+            raise ValueError(f"{field} of type {profile_type} cannot have a localhostProfile.")
+        if restricted and profile_type == "Unconfined":
+            raise ValueError(f"{field} cannot be Unconfined with restricted pods.")
+
+
+def apply_security_profiles(pod_spec: Dict[str, Any], flavor: Mapping[str, Any]) -> None:
+    """
+    Applies the flavor's seccomp and AppArmor profiles to the pod. They are
+    enforced: containers cannot override them in their own securityContext.
+    """
+    profiles = get_flavor_security_profiles(flavor)
+    if not profiles:
+        return
+
+    pod_spec.setdefault("securityContext", {}).update(profiles)
+    for container in [*pod_spec.get("initContainers", []), *pod_spec["containers"]]:
+        security_context = container.get("securityContext", {})
+        for field in profiles:
+            security_context.pop(field, None)
+
+
+def restrict_pod_spec(
+    pod_spec: Dict[str, Any], uid: int, gid: int, flavor: Mapping[str, Any]
+) -> None:
+    """
+    Makes the pod comply with the restricted Pod Security Standard: every
+    container runs as the dev user, without privilege escalation and
+    capabilities, under the runtime's default seccomp profile.
+
+    The flavor's `podSecurityExceptions` can add supplementary groups, e.g.
+    `video` or `render` for /dev/dri, which the standard allows, and
+    capabilities, e.g. IPC_LOCK for RDMA, which it does not.
+    """
+    exceptions = get_flavor_security_exceptions(flavor)
+    pod_security_context: Dict[str, Any] = {
+        "runAsNonRoot": True,
+        "runAsUser": uid,
+        "runAsGroup": gid,
+        "fsGroup": gid,
+        # Avoids walking large volumes on every start
+        "fsGroupChangePolicy": "OnRootMismatch",
+        "seccompProfile": {"type": "RuntimeDefault"},
+    }
+    if exceptions.get("supplementalGroups"):
+        pod_security_context["supplementalGroups"] = list(exceptions["supplementalGroups"])
+    pod_spec["securityContext"] = pod_security_context
+
+    for container in [*pod_spec.get("initContainers", []), *pod_spec["containers"]]:
+        capabilities: Dict[str, List[str]] = {"drop": ["ALL"]}
+        if exceptions.get("capabilities"):
+            capabilities["add"] = list(exceptions["capabilities"])
+        security_context = container.setdefault("securityContext", {})
+        # The operator's containers otherwise run as root
+        for field in ("runAsUser", "runAsGroup"):
+            if security_context.get(field) == 0:
+                del security_context[field]
+        security_context.update(
+            {"allowPrivilegeEscalation": False, "capabilities": capabilities}
+        )
@@ -0,0 +1,146 @@
+"""
+Prewarming of heavyweight environments before the first login.
+
+`spec.prewarm` lists conda, pip and apt packages that the `prewarm` init
+container installs with the DevServer's image into a cache on the home
+volume: a conda environment and a virtualenv, which the DevServer's PATH
+picks up, and the apt packages' archives, which startup.sh installs without
+downloading them again. The cache is only rebuilt when the lists change.
+"""
+import hashlib
+import json
+import re
+from typing import Any, Dict, List, Mapping, Optional
+
+PREWARM_CONTAINER_NAME = "prewarm"
+# Relative to the home directory, so that the cache persists with it
+PREWARM_CACHE_DIR = ".cache/devserver-prewarm"
+PREWARM_PACKAGE_MANAGERS = ("conda", "pip", "apt")
+
+# Package specs are passed to the package managers as separate words, so they
+# cannot contain whitespace or start like an option
+_PACKAGE_RE = re.compile(r"^[A-Za-z0-9][^\s]*$")
+
+# Runs as root, for apt, unless the pod is restricted. Failures are logged but
+# do not keep the DevServer from starting; the cache is removed, so that the
+# next restart tries again.
+PREWARM_SCRIPT = """
+set -u
+set -f
+DIR="$PREWARM_DIR"
+if [ -f "$DIR/checksum" ] && [ "$(cat "$DIR/checksum")" = "$PREWARM_CHECKSUM" ]; then
+  echo "[PREWARM] Environment already prewarmed."
+  exit 0
+fi
+fail() {
+  echo "[PREWARM] $1, starting without the prewarmed environment."
+  rm -rf "$DIR"
+  exit 0
+}
+rm -rf "$DIR"
+mkdir -p "$DIR" || fail "Creating $DIR failed"
+if [ -n "$PREWARM_APT" ] && [ "$(id -u)" != 0 ]; then
+  echo "[PREWARM] Skipping apt packages, which need root: $PREWARM_APT"
+elif [ -n "$PREWARM_APT" ]; then
+  echo "[PREWARM] Downloading apt packages: $PREWARM_APT"
+  mkdir -p "$DIR/apt/partial"
+  apt-get update -q || fail "apt-get update failed"
+  apt-get install -y -q --download-only -o Dir::Cache::archives="$DIR/apt" $PREWARM_APT \\
+    || fail "Downloading apt packages failed"
+  rm -rf "$DIR/apt/partial" "$DIR/apt/lock"
+fi
+if [ -n "$PREWARM_CONDA" ]; then
+  echo "[PREWARM] Installing conda packages: $PREWARM_CONDA"
+  CONDA=$(command -v mamba || command -v conda) || fail "The image has no conda"
+  "$CONDA" create -y -q -p "$DIR/conda" $PREWARM_CONDA \\
+    || fail "Installing conda packages failed"
+fi
+if [ -n "$PREWARM_PIP" ]; then
+  echo "[PREWARM] Installing pip packages: $PREWARM_PIP"
+  if [ -x "$DIR/conda/bin/python" ]; then
+    PYTHON="$DIR/conda/bin/python"
+  else
+    PYTHON=$(command -v python3) || fail "The image has no python3"
+  fi
+  "$PYTHON" -m venv --system-site-packages "$DIR/venv" || fail "Creating the virtualenv failed"
+  "$DIR/venv/bin/pip" install -q --no-cache-dir $PREWARM_PIP \\
+    || fail "Installing pip packages failed"
+fi
+echo "$PREWARM_CHECKSUM" > "$DIR/checksum"
+if [ "$(id -u)" = 0 ]; then
+  chown "$DEV_UID:$DEV_GID" "$(dirname "$DIR")"
+  chown -R "$DEV_UID:$DEV_GID" "$DIR"
+fi
+echo "[PREWARM] Environment prewarmed."
+"""
+
+
+def prewarm_dir(home: str) -> str:
+    """Where the prewarmed environment is cached."""
+    return f"{home}/{PREWARM_CACHE_DIR}"
+
+
+def get_prewarm_packages(spec: Mapping[str, Any]) -> Dict[str, List[str]]:
+    """The packages of each package manager in `spec.prewarm` that has any."""
+    prewarm = spec.get("prewarm") or {}
+    return {
+        manager: list(prewarm[manager])
+        for manager in PREWARM_PACKAGE_MANAGERS
+        if prewarm.get(manager)
+    }
+
+
+def prewarm_checksum(packages: Mapping[str, List[str]]) -> str:
+    """A checksum of the package lists, which the cache is rebuilt for when it changes."""
+    return hashlib.sha256(json.dumps(packages, sort_keys=True).encode()).hexdigest()[:16]
+
+
+def validate_user_prewarm(spec: Mapping[str, Any]) -> None:
+    """
+    Check the package specs of `spec.prewarm`.
+
+    Raises:
+        ValueError: If a package spec contains whitespace or does not start
+            with a letter or digit.
+    """
+    for manager, packages in get_prewarm_packages(spec).items():
+        for package in packages:
+            if not _PACKAGE_RE.match(package):
+                raise ValueError(f"{manager} package '{package}' is not a valid package spec.")
+
+
+def build_prewarm_container(
+    spec: Mapping[str, Any], image: str, home: str, env: List[Dict[str, str]]
+) -> Optional[Dict[str, Any]]:
+    """
+    Builds the init container prewarming the DevServer's packages, or returns
+    None without any. It runs the DevServer's image, so that the packages
+    match its distribution and Python.
+
+    Args:
+        env: The DEV_UID and DEV_GID variables, whom the cache is handed to.
+    """
+    packages = get_prewarm_packages(spec)
+    if not packages:
+        return None
+    return {
+        "name": PREWARM_CONTAINER_NAME,
+        "image": image,
+        "command": ["/bin/sh", "-c"],
+        "args": [PREWARM_SCRIPT],
+        "env": [
+            {"name": "HOME", "value": home},
+            *env,
+            {"name": "PREWARM_DIR", "value": prewarm_dir(home)},
+            {"name": "PREWARM_CHECKSUM", "value": prewarm_checksum(packages)},
+            *(
+                {
+                    "name": f"PREWARM_{manager.upper()}",
+                    "value": " ".join(packages.get(manager, [])),
+                }
+                for manager in PREWARM_PACKAGE_MANAGERS
+            ),
+        ],
+        "securityContext": {"runAsUser": 0},
+        "volumeMounts": [{"name": "home", "mountPath": home}],
+    }
@@ -0,0 +1,63 @@
+import copy
+from typing import Any, Dict, Mapping
+
+from kubernetes.utils import parse_quantity
+
+SCRATCH_VOLUME_NAME = "scratch"
+SCRATCH_MOUNT_PATH = "/scratch"
+
+MEDIUM_DISK = "Disk"
+MEDIUM_MEMORY = "Memory"
+
+
+def build_scratch_volume(scratch: Mapping[str, Any]) -> Dict[str, Any]:
+    """
+    Builds the `/scratch` volume: a generic ephemeral volume when a
+    StorageClass is given, otherwise an emptyDir on the node's disk or in
+    memory. Either way it is deleted with the pod.
+    """
+    size = scratch["size"]
+    if scratch.get("storageClassName"):
+        return {
+            "name": SCRATCH_VOLUME_NAME,
+            "ephemeral": {
+                "volumeClaimTemplate": {
+                    "spec": {
+                        "accessModes": ["ReadWriteOnce"],
+                        "storageClassName": scratch["storageClassName"],
+                        "resources": {"requests": {"storage": size}},
+                    }
+                }
+            },
+        }
+    empty_dir: Dict[str, Any] = {"sizeLimit": size}
+    if scratch.get("medium") == MEDIUM_MEMORY:
+        empty_dir["medium"] = "Memory"
+    return {"name": SCRATCH_VOLUME_NAME, "emptyDir": empty_dir}
+
+
+def _add_quantity(values: Dict[str, Any], resource: str, size: str) -> None:
+    total = parse_quantity(values.get(resource, "0")) + parse_quantity(size)
+    values[resource] = str(int(total))
+
+
+def scratch_resources(
+    resources: Mapping[str, Any], scratch: Mapping[str, Any]
+) -> Dict[str, Any]:
+    """
+    Account for an emptyDir scratch volume in the container's resources.
+
+    A disk emptyDir counts towards the pod's ephemeral-storage, and a memory
+    one towards its memory, so the scheduler reserves room for it and the
+    flavor's limits still apply to the workload itself. Unset limits stay
+    unset; the volume's sizeLimit enforces the size then.
+    """
+    resources = copy.deepcopy(dict(resources))
+    if scratch.get("storageClassName"):
+        # Backed by its own PVC, which does not use the node's resources
+        return resources
+    resource = "memory" if scratch.get("medium") == MEDIUM_MEMORY else "ephemeral-storage"
+    _add_quantity(resources.setdefault("requests", {}), resource, scratch["size"])
+    if resource in resources.get("limits", {}):
+        _add_quantity(resources["limits"], resource, scratch["size"])
+    return resources
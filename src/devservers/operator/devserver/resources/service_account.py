@@ -0,0 +1,60 @@
+from typing import Any, Dict, Mapping, Optional
+
+
+def service_account_name(name: str) -> str:
+    return f"{name}-devserver"
+
+
+def get_service_account_annotations(
+    spec: Mapping[str, Any], flavor: Mapping[str, Any]
+) -> Dict[str, str]:
+    """
+    The ServiceAccount's annotations, e.g. eks.amazonaws.com/role-arn or
+    iam.gke.io/gcp-service-account for a cloud workload identity. The
+    DevServer's take precedence over its flavor's.
+    """
+    return {
+        **flavor.get("spec", {}).get("serviceAccountAnnotations", {}),
+        **spec.get("serviceAccount", {}).get("annotations", {}),
+    }
+
+
+def build_service_account(
+    name: str, namespace: str, annotations: Optional[Mapping[str, str]] = None
+) -> Dict[str, Any]:
+    """
+    Builds the DevServer's own ServiceAccount. Whether its token is mounted is
+    decided by the pod, so the ServiceAccount leaves it to the default.
+    """
+    metadata: Dict[str, Any] = {"name": service_account_name(name), "namespace": namespace}
+    if annotations:
+        metadata["annotations"] = dict(annotations)
+    return {
+        "apiVersion": "v1",
+        "kind": "ServiceAccount",
+        "metadata": metadata,
+    }
+
+
+def build_role_binding(name: str, namespace: str, role_template: str) -> Dict[str, Any]:
+    """
+    Builds the RoleBinding granting the DevServer's ServiceAccount the
+    permissions of a role template, a ClusterRole, in the DevServer's namespace only.
+    """
+    return {
+        "apiVersion": "rbac.authorization.k8s.io/v1",
+        "kind": "RoleBinding",
+        "metadata": {"name": service_account_name(name), "namespace": namespace},
+        "subjects": [
+            {
+                "kind": "ServiceAccount",
+                "name": service_account_name(name),
+                "namespace": namespace,
+            }
+        ],
+        "roleRef": {
+            "apiGroup": "rbac.authorization.k8s.io",
+            "kind": "ClusterRole",
+            "name": role_template,
+        },
+    }
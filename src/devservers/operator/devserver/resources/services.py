@@ -1,4 +1,39 @@
-from typing import Any, Dict
+from typing import Any, Dict, List, Mapping, Optional
+
+from devservers.crds.const import DEFAULT_SSH_SERVICE_TYPE
+
+DEFAULT_MOSH_PORT_START = 60000
+DEFAULT_MOSH_PORT_END = 60009
+# Services cannot expose port ranges, so every port is listed individually
+MAX_MOSH_PORTS = 20
+
+
+def get_mosh_ports(spec: Mapping[str, Any]) -> List[int]:
+    """Returns the UDP ports reserved for mosh-server, or [] if mosh is disabled."""
+    mosh = spec.get("mosh", {})
+    if not mosh.get("enabled", False):
+        return []
+    port_range = mosh.get("portRange", {})
+    start = int(port_range.get("start", DEFAULT_MOSH_PORT_START))
+    end = int(port_range.get("end", DEFAULT_MOSH_PORT_END))
+    return list(range(start, end + 1))
+
+
+def _mosh_service_ports(spec: Mapping[str, Any], service_type: str) -> List[Dict[str, Any]]:
+    ports = []
+    for port in get_mosh_ports(spec):
+        service_port: Dict[str, Any] = {
+            "name": f"mosh-{port}",
+            "port": port,
+            "targetPort": port,
+            "protocol": "UDP",
+        }
+        # mosh connects to the same port on the client side as on the server,
+        # so the node port has to match the container port.
+        if service_type == "NodePort":
+            service_port["nodePort"] = port
+        ports.append(service_port)
+    return ports
 
 
 def build_headless_service(name: str, namespace: str) -> Dict[str, Any]:
@@ -14,15 +49,34 @@ def build_headless_service(name: str, namespace: str) -> Dict[str, Any]:
     }
 
 
-def build_ssh_service(name: str, namespace: str) -> Dict[str, Any]:
-    """Builds the NodePort Service for SSH access."""
+def build_ssh_service(
+    name: str, namespace: str, spec: Optional[Mapping[str, Any]] = None
+) -> Dict[str, Any]:
+    """
+    Builds the Service for SSH access.
+
+    The type defaults to NodePort and can be changed with spec.ssh.serviceType;
+    spec.ssh.serviceAnnotations are passed through, e.g. to configure a cloud
+    load balancer. When mosh is enabled its UDP ports are exposed as well.
+    """
+    spec = spec or {}
+    ssh = spec.get("ssh", {})
+    service_type = ssh.get("serviceType", DEFAULT_SSH_SERVICE_TYPE)
+    metadata: Dict[str, Any] = {"name": f"{name}-ssh", "namespace": namespace}
+    if ssh.get("serviceAnnotations"):
+        metadata["annotations"] = dict(ssh["serviceAnnotations"])
+
     return {
         "apiVersion": "v1",
         "kind": "Service",
-        "metadata": {"name": f"{name}-ssh", "namespace": namespace},
+        "metadata": metadata,
         "spec": {
-            "type": "NodePort",
+            "type": service_type,
             "selector": {"app": name},
-            "ports": [{"port": 22, "targetPort": 22, "protocol": "TCP"}],
+            "ports": [
+                # Targets the container's named port, which follows spec.ssh.port
+                {"name": "ssh", "port": 22, "targetPort": "ssh", "protocol": "TCP"},
+                *_mosh_service_ports(spec, service_type),
+            ],
         },
     }
@@ -27,110 +27,242 @@ log_error() {
 
 log_info "Configuring container..."
 
-log_step "Ensuring 'dev' user and group exist with UID/GID 1000"
-
-# --- Group management ---
-# Check if a group with GID 1000 exists
-if getent group 1000 >/dev/null 2>&1; then
-    # Group with GID 1000 exists, check its name
-    GROUP_NAME=$(getent group 1000 | cut -d: -f1)
-    if [ "$GROUP_NAME" != "dev" ]; then
-        log_step "Group with GID 1000 exists as '$GROUP_NAME'. Renaming to 'dev'."
-        groupmod -n dev "$GROUP_NAME"
-    else
-        log_step "Group 'dev' with GID 1000 already exists."
-    fi
-# Check if group with name 'dev' exists but with different GID
-elif getent group dev >/dev/null 2>&1; then
-    log_error "Group 'dev' exists but with a different GID. This is an unsupported configuration."
-    exit 1
-# Create the group
+# The owner's UID/GID from their DevServerUser, if it sets them
+DEV_UID="${DEV_UID:-1000}"
+DEV_GID="${DEV_GID:-1000}"
+# Where the home volume is mounted, from spec.homeMountPath
+HOME_DIR="${DEVSERVER_HOME:-/home/dev}"
+# Where sshd's config and host keys go, a writable directory when not root
+SSHD_DIR="${DEVSERVER_SSHD_DIR:-/etc/ssh}"
+AS_ROOT=$([ "$(id -u)" = 0 ] && echo true || echo false)
+
+if [ "$AS_ROOT" != "true" ]; then
+    # Under the restricted Pod Security Standard the container runs as the dev
+    # user, which the image has to provide, and cannot manage users or doas
+    log_step "Running as UID $(id -u), skipping user, doas and sshd user setup."
 else
-    log_step "Creating group 'dev' with GID 1000."
-    groupadd --gid 1000 dev
-fi
+    log_step "Ensuring 'dev' user and group exist with UID $DEV_UID and GID $DEV_GID"
 
-# --- User management ---
-# Check if a user with UID 1000 exists
-if getent passwd 1000 >/dev/null 2>&1; then
-    # User with UID 1000 exists, check its name
-    USER_NAME=$(getent passwd 1000 | cut -d: -f1)
-    if [ "$USER_NAME" != "dev" ]; then
-        log_step "User with UID 1000 exists as '$USER_NAME'. Renaming to 'dev'."
-        # kill processes of the user before renaming
-        pkill -u "$USER_NAME" || true
-        sleep 1
-        usermod -l dev "$USER_NAME"
+    # --- Group management ---
+    # Check if a group with GID $DEV_GID exists
+    if getent group "$DEV_GID" >/dev/null 2>&1; then
+        # Group with GID $DEV_GID exists, check its name
+        GROUP_NAME=$(getent group "$DEV_GID" | cut -d: -f1)
+        if [ "$GROUP_NAME" != "dev" ]; then
+            log_step "Group with GID $DEV_GID exists as '$GROUP_NAME'. Renaming to 'dev'."
+            groupmod -n dev "$GROUP_NAME"
+        else
+            log_step "Group 'dev' with GID $DEV_GID already exists."
+        fi
+    # Check if group with name 'dev' exists but with different GID
+    elif getent group dev >/dev/null 2>&1; then
+        log_step "Group 'dev' exists with a different GID. Changing it to $DEV_GID."
+        groupmod -g "$DEV_GID" dev
+    # Create the group
     else
-        log_step "User 'dev' with UID 1000 already exists."
+        log_step "Creating group 'dev' with GID $DEV_GID."
+        groupadd --gid "$DEV_GID" dev
     fi
-# Check if user with name 'dev' exists but with different UID
-elif getent passwd dev >/dev/null 2>&1; then
-    log_error "User 'dev' exists but with a different UID. This is an unsupported configuration."
-    exit 1
-# Create the user
-else
-    log_step "Creating user 'dev' with UID 1000."
-    useradd --uid 1000 --gid 1000 -m --home-dir /home/dev --shell /bin/bash dev
-fi
 
-# --- Final configuration ---
-# Ensure user's primary group is 'dev' and home directory is correct
-usermod -g dev -d /home/dev dev
-# Ensure home directory exists and has correct permissions
-mkdir -p /home/dev
-chown -R dev:dev /home/dev
-chmod 755 /home/dev
-
-log_info "Unlocking user's account to allow SSH access"
-# Unlock the user's account to allow SSH access
-# On some systems (like Fedora), an account created without a password is locked
-RANDOM_PASSWORD=$(head -c 32 /dev/urandom | tr -dc 'a-zA-Z0-9')
-(
-    set -x
-    usermod -p "${RANDOM_PASSWORD}" dev
-)
-
-log_info "Configuring doas access for 'dev' user"
-# Check if static doas binary exists
-if test -f /opt/bin/doas; then
-    log_step "Creating doas configuration"
-    # Configure passwordless doas for dev user
-    mkdir -p /etc
-    echo "permit nopass dev" > /etc/doas.conf
-    chmod 600 /etc/doas.conf
-
-    # Create sudo symlink to doas for compatibility if sudo doesn't already exist
-    if test -f /usr/local/bin/sudo >/dev/null 2>&1; then
-      log_info "/usr/local/bin/sudo already exists, replacing it with doas..."
+    # --- User management ---
+    # Check if a user with UID $DEV_UID exists
+    if getent passwd "$DEV_UID" >/dev/null 2>&1; then
+        # User with UID $DEV_UID exists, check its name
+        USER_NAME=$(getent passwd "$DEV_UID" | cut -d: -f1)
+        if [ "$USER_NAME" != "dev" ]; then
+            log_step "User with UID $DEV_UID exists as '$USER_NAME'. Renaming to 'dev'."
+            # kill processes of the user before renaming
+            pkill -u "$USER_NAME" || true
+            sleep 1
+            usermod -l dev "$USER_NAME"
+        else
+            log_step "User 'dev' with UID $DEV_UID already exists."
+        fi
+    # Check if user with name 'dev' exists but with different UID
+    elif getent passwd dev >/dev/null 2>&1; then
+        log_step "User 'dev' exists with a different UID. Changing it to $DEV_UID."
+        usermod -u "$DEV_UID" dev
+    # Create the user
+    else
+        log_step "Creating user 'dev' with UID $DEV_UID."
+        useradd --uid "$DEV_UID" --gid "$DEV_GID" -m --home-dir "$HOME_DIR" --shell /bin/bash dev
     fi
-    log_step "Creating sudo symlinks to doas"
+
+    # --- Final configuration ---
+    # Ensure user's primary group is 'dev' and home directory is correct
+    usermod -g dev -d "$HOME_DIR" dev
+    # Ensure home directory exists and has correct permissions; its contents are
+    # handed to the dev user by the init-home init container on first boot
+    mkdir -p "$HOME_DIR"
+    chown dev:dev "$HOME_DIR"
+    chmod 755 "$HOME_DIR"
+
+    log_info "Unlocking user's account to allow SSH access"
+    # Unlock the user's account to allow SSH access
+    # On some systems (like Fedora), an account created without a password is locked
+    RANDOM_PASSWORD=$(head -c 32 /dev/urandom | tr -dc 'a-zA-Z0-9')
     (
-      set -x
-      ln -sf /opt/bin/doas /usr/local/bin/sudo 2>/dev/null || true
-      ln -sf /opt/bin/doas /usr/bin/sudo 2>/dev/null || true
+        set -x
+        usermod -p "${RANDOM_PASSWORD}" dev
     )
-else
-    log_step "Warning: /opt/bin/doas not found, skipping doas configuration"
+
+    log_info "Configuring doas access for 'dev' user"
+    # Check if static doas binary exists
+    if test -f /opt/bin/doas; then
+        log_step "Creating doas configuration"
+        # Configure passwordless doas for dev user
+        mkdir -p /etc
+        echo "permit nopass dev" > /etc/doas.conf
+        chmod 600 /etc/doas.conf
+
+        # Create sudo symlink to doas for compatibility if sudo doesn't already exist
+        if test -f /usr/local/bin/sudo >/dev/null 2>&1; then
+          log_info "/usr/local/bin/sudo already exists, replacing it with doas..."
+        fi
+        log_step "Creating sudo symlinks to doas"
+        (
+          set -x
+          ln -sf /opt/bin/doas /usr/local/bin/sudo 2>/dev/null || true
+          ln -sf /opt/bin/doas /usr/bin/sudo 2>/dev/null || true
+        )
+    else
+        log_step "Warning: /opt/bin/doas not found, skipping doas configuration"
+    fi
+
+    # --- sshd user ---
+    if ! getent group sshd >/dev/null; then
+        groupadd -r sshd
+    fi
+    if ! getent passwd sshd >/dev/null; then
+        useradd -r -g sshd -c 'sshd privsep' -d /var/empty -s /sbin/nologin sshd
+    fi
 fi
 
-# --- sshd user ---
-if ! getent group sshd >/dev/null; then
-    groupadd -r sshd
+if [ -n "$DEVSERVER_PREWARM_DIR" ] && [ -f "$DEVSERVER_PREWARM_DIR/checksum" ]; then
+    log_info "Using the prewarmed environment"
+    # The prewarm init container only downloaded the apt packages, as it
+    # cannot install them into this container
+    if [ "$AS_ROOT" = "true" ] && ls "$DEVSERVER_PREWARM_DIR"/apt/*.deb >/dev/null 2>&1; then
+        log_step "Installing prewarmed apt packages"
+        dpkg -i "$DEVSERVER_PREWARM_DIR"/apt/*.deb >/dev/null \
+            || log_step "Warning: failed to install the prewarmed apt packages."
+    fi
+    # The virtualenv comes first, then the conda environment
+    for PREWARM_BIN in "$DEVSERVER_PREWARM_DIR/conda/bin" "$DEVSERVER_PREWARM_DIR/venv/bin"; do
+        if [ -d "$PREWARM_BIN" ]; then
+            PATH="$PREWARM_BIN:$PATH"
+        fi
+    done
+    export PATH
 fi
-if ! getent passwd sshd >/dev/null; then
-    useradd -r -g sshd -c 'sshd privsep' -d /var/empty -s /sbin/nologin sshd
+
+log_info "Exporting the container's environment to SSH sessions"
+# sshd starts sessions with a clean environment, so spec.env, spec.envFrom and
+# the image's variables (e.g. PATH) are written to a profile script that
+# login shells and user_login.sh source.
+mkdir -p /etc/profile.d
+ENV_SCRIPT=/etc/profile.d/devserver-env.sh
+: > "$ENV_SCRIPT"
+for name in $(env | sed -n 's/^\([A-Za-z_][A-Za-z0-9_]*\)=.*/\1/p' | sort -u); do
+    case "$name" in
+        HOME|HOSTNAME|PWD|OLDPWD|SHLVL|TERM|USER|_|SSH_PUBLIC_KEY|DEVSERVER_*) continue ;;
+    esac
+    # Skip lines of multi-line values that merely look like assignments
+    eval "[ -n \"\${$name+x}\" ]" || continue
+    eval "value=\${$name}"
+    printf "export %s='%s'\n" "$name" "$(printf '%s' "$value" | sed "s/'/'\\\\''/g")" >> "$ENV_SCRIPT"
+done
+# Only readable by the dev user, as it may hold tokens
+if [ "$AS_ROOT" = "true" ]; then
+    chown root:dev "$ENV_SCRIPT"
+    chmod 640 "$ENV_SCRIPT"
+else
+    chmod 600 "$ENV_SCRIPT"
 fi
 
 log_step "Setting up SSH for 'dev' user"
 # Set up SSH for the 'dev' user
-mkdir -p /home/dev/.ssh
-echo "${SSH_PUBLIC_KEY}" > /home/dev/.ssh/authorized_keys
-chown -R dev:dev /home/dev/.ssh
-chmod 700 /home/dev/.ssh
-chmod 600 /home/dev/.ssh/authorized_keys
+mkdir -p "$HOME_DIR/.ssh"
+echo "${SSH_PUBLIC_KEY}" > "$HOME_DIR/.ssh/authorized_keys"
+# Append keys mounted from the owner's Secret, if any
+if [ -f /opt/ssh/authorized_keys.d/authorized_keys ]; then
+    log_step "Adding keys from mounted authorized_keys Secret"
+    cat /opt/ssh/authorized_keys.d/authorized_keys >> "$HOME_DIR/.ssh/authorized_keys"
+fi
+# And those of the collaborators it is shared with
+for keys in /opt/ssh/authorized_keys.d/collaborators/*; do
+    if [ -f "$keys" ]; then
+        log_step "Adding keys of collaborator $(basename "$keys")"
+        cat "$keys" >> "$HOME_DIR/.ssh/authorized_keys"
+    fi
+done
+if [ "$AS_ROOT" = "true" ]; then
+    chown -R dev:dev "$HOME_DIR/.ssh"
+fi
+chmod 700 "$HOME_DIR/.ssh"
+chmod 600 "$HOME_DIR/.ssh/authorized_keys"
 # Create the privilege separation directory
-mkdir -p /var/empty
+if [ "$AS_ROOT" = "true" ]; then
+    mkdir -p /var/empty
+fi
+
+if [ -n "$DEVSERVER_BOOTSTRAP_SCRIPT" ]; then
+    log_info "Running the bootstrap script"
+    # The marker records the checksum of the script that last succeeded, so
+    # that it runs once per home directory, and again when it changes
+    BOOTSTRAP_MARKER="$HOME_DIR/.devserver-bootstrapped"
+    BOOTSTRAP_CHECKSUM=$(cksum < "$DEVSERVER_BOOTSTRAP_SCRIPT" | cut -d' ' -f1)
+    if [ -f "$BOOTSTRAP_MARKER" ] && [ "$(cat "$BOOTSTRAP_MARKER")" = "$BOOTSTRAP_CHECKSUM" ]; then
+        log_step "Bootstrap script already ran."
+    elif (cd "$HOME_DIR" && HOME="$HOME_DIR" "$DEVSERVER_BOOTSTRAP_SCRIPT"); then
+        echo "$BOOTSTRAP_CHECKSUM" > "$BOOTSTRAP_MARKER"
+        if [ "$AS_ROOT" = "true" ]; then
+            chown dev:dev "$BOOTSTRAP_MARKER"
+        fi
+        log_step "Bootstrap script succeeded."
+    else
+        # sshd still starts, so that the failure can be debugged
+        log_error "Bootstrap script failed, it runs again on the next restart"
+    fi
+fi
+
+if [ "$DEVSERVER_MOSH_ENABLED" = "true" ]; then
+    log_info "Ensuring mosh-server is installed"
+    if command -v mosh-server >/dev/null 2>&1; then
+        log_step "mosh-server already installed."
+    elif [ -n "$DEVSERVER_TEST_MODE" ]; then
+        log_step "Test mode: skipping mosh installation."
+    elif command -v apt-get >/dev/null 2>&1; then
+        (apt-get update && DEBIAN_FRONTEND=noninteractive apt-get install -y --no-install-recommends mosh locales) \
+            || log_step "Warning: failed to install mosh with apt-get."
+    elif command -v dnf >/dev/null 2>&1; then
+        dnf install -y mosh || log_step "Warning: failed to install mosh with dnf."
+    elif command -v yum >/dev/null 2>&1; then
+        yum install -y mosh || log_step "Warning: failed to install mosh with yum."
+    elif command -v apk >/dev/null 2>&1; then
+        apk add --no-cache mosh-server || log_step "Warning: failed to install mosh with apk."
+    else
+        log_step "Warning: no supported package manager found, mosh will not be available."
+    fi
+fi
+
+if [ -n "$DEVSERVER_AGENT" ]; then
+    log_info "Starting the devserver-agent"
+    if [ "$AS_ROOT" = "true" ]; then
+        # Offers e.g. `devserver extend 12h` in every session
+        ln -sf "$DEVSERVER_AGENT" /usr/local/bin/devserver
+    else
+        log_step "Not running as root, the agent's commands are only at $DEVSERVER_AGENT."
+    fi
+    if [ -n "$DEVSERVER_TEST_MODE" ]; then
+        log_step "Test mode: skipping the agent."
+    elif ! command -v curl >/dev/null 2>&1; then
+        log_step "Warning: curl not found, the agent will not report the DevServer's activity."
+    else
+        # Outlives this script, which execs sshd or the main command
+        "$DEVSERVER_AGENT" run >/tmp/devserver-agent.log 2>&1 &
+    fi
+fi
 
 log_info "Configuring sshd..."
 if [ -n "$DEVSERVER_TEST_MODE" ]; then
@@ -139,17 +271,17 @@ else
     log_step "Copying sshd_config and host keys and setting permissions"
     (
         set -x
-        mkdir -p /etc/ssh
-        cp /opt/ssh/sshd_config /etc/ssh/sshd_config
+        mkdir -p "$SSHD_DIR"
+        cp /opt/ssh/sshd_config "$SSHD_DIR/sshd_config"
         if [ -d "/opt/ssh/hostkeys" ] && [ -n "$(ls -A /opt/ssh/hostkeys)" ]; then
-            cp -L /opt/ssh/hostkeys/* /etc/ssh/
+            cp -L /opt/ssh/hostkeys/* "$SSHD_DIR/"
         else
             log_step "Warning: /opt/ssh/hostkeys is empty or not a directory, host keys will be missing."
         fi
 
-        chmod 644 /etc/ssh/sshd_config
-        chmod 600 /etc/ssh/ssh_host_*_key
-        chmod 644 /etc/ssh/ssh_host_*_key.pub
+        chmod 644 "$SSHD_DIR/sshd_config"
+        chmod 600 "$SSHD_DIR"/ssh_host_*_key
+        chmod 644 "$SSHD_DIR"/ssh_host_*_key.pub
     )
 fi
 
@@ -160,7 +292,19 @@ if [ -n "$DEVSERVER_TEST_MODE" ]; then
 fi
 
 if test -f /opt/bin/sshd; then
-    exec /opt/bin/sshd -D -e -f /etc/ssh/sshd_config
+    # Fail loudly on a broken sshd_config (e.g. a bad spec.ssh.sshdConfig override)
+    if ! /opt/bin/sshd -t -f "$SSHD_DIR/sshd_config"; then
+        log_error "sshd_config is invalid, see the error above"
+        exit 1
+    fi
+    if [ "$#" -gt 0 ]; then
+        # spec.command is the container's main process, with sshd alongside it
+        /opt/bin/sshd -e -f "$SSHD_DIR/sshd_config"
+        log_info "Starting $1..."
+        exec "$@"
+    fi
+    # sshd is the container's main process: the pod lives and dies with it
+    exec /opt/bin/sshd -D -e -f "$SSHD_DIR/sshd_config"
 else
     log_error "sshd binary not found in /opt/bin/sshd"
     exit 1
@@ -1,13 +1,570 @@
-from typing import Any, Dict
+from typing import Any, Dict, Iterable, List, Optional
+
+from devservers.utils.flavors import get_flavor_node_selector, get_flavor_resources
+from devservers.utils.users import owner_ssh_keys_secret_name, owner_to_dns_label
+from .affinity import apply_packing, get_affinity, packing_requested
+from .configmap import (
+    get_home_mount_path,
+    get_ssh_port,
+    render_sshd_config,
+    sshd_config_checksum,
+)
+from .credentials import (
+    CREDENTIALS_MOUNT_PATH,
+    CREDENTIALS_VOLUME_NAME_PREFIX,
+    build_credential_mount,
+    build_credential_volume,
+    get_credential_bundles,
+)
+from .agent import (
+    AGENT_MOUNT_PATH,
+    AGENT_SCRIPT_VOLUME_NAME,
+    AGENT_TOKEN_MOUNT_PATH,
+    AGENT_TOKEN_VOLUME_NAME,
+    build_agent_volume_mounts,
+    build_agent_volumes,
+)
+from .dotfiles import DOTFILES_CONTAINER_NAME, build_dotfiles_container, get_dotfiles_repo
+from .encryption import home_storage_class_name
+from .ide import CODE_SERVER_CONTAINER_NAME, IDE_CODE_SERVER, build_code_server_container
+from .motd import MOTD_MOUNT_PATH, MOTD_VOLUME_NAME, motd_configmap_name
+from .node_pools import find_team_node_pool, team_node_pool_scheduling
+from .prewarm import PREWARM_CONTAINER_NAME, build_prewarm_container, prewarm_dir
+from .pod_security import RESTRICTED_SSHD_DIR, apply_security_profiles, restrict_pod_spec
+from .services import get_mosh_ports
+from .service_account import service_account_name
+from .object_storage import build_restore_home_container
+from .scratch import (
+    SCRATCH_MOUNT_PATH,
+    SCRATCH_VOLUME_NAME,
+    build_scratch_volume,
+    scratch_resources,
+)
+from .volume_snapshot import home_volume_snapshot_data_source
+from ..home_volume import home_pvc_name
+from ..ide import IDEConfig
+from ..kueue import QUEUE_NAME_LABEL, get_queue_name
+from ..packing import PackingDefaults
+from ..owner_ids import DEFAULT_POSIX_IDS, PosixIds
 
 DEFAULT_DEVSERVER_IMAGE = "seemethere/devserver-base:latest"
 
+AUTHORIZED_KEYS_MOUNT_PATH = "/opt/ssh/authorized_keys.d"
+DEFAULT_AUTHORIZED_KEYS_SECRET_KEY = "authorized_keys"
+
+# startup.sh runs the script of spec.bootstrap once per home directory
+BOOTSTRAP_MOUNT_PATH = "/devserver-bootstrap"
+DEFAULT_BOOTSTRAP_CONFIGMAP_KEY = "bootstrap.sh"
+
+# Changing the sshd_config ConfigMap alone does not restart sshd, so the pod
+# template carries a checksum of it to roll the pod when it changes.
+SSHD_CONFIG_CHECKSUM_ANNOTATION = "devserver.io/sshd-config-checksum"
+# Selects the pods of a flavor's DevServers for its topology spread constraints
+FLAVOR_LABEL = "devserver.io/flavor"
+
+# Volumes and mount paths of the generated pod that spec.volumes cannot replace
+RESERVED_VOLUME_NAMES = frozenset(
+    ["bin", "home", "startup-script", "login-script", "sshd-config", "host-keys",
+     "authorized-keys", "bootstrap-script", "shared", MOTD_VOLUME_NAME, SCRATCH_VOLUME_NAME,
+     AGENT_SCRIPT_VOLUME_NAME, AGENT_TOKEN_VOLUME_NAME]
+)
+SHARED_VOLUME_NAME_PREFIX = "shared-"
+LEGACY_SHARED_MOUNT_PATH = "/shared"
+RESERVED_MOUNT_PATHS = (
+    "/opt/bin", "/opt/ssh", "/devserver", "/devserver-login", BOOTSTRAP_MOUNT_PATH,
+    CREDENTIALS_MOUNT_PATH, MOTD_MOUNT_PATH, AGENT_MOUNT_PATH, AGENT_TOKEN_MOUNT_PATH,
+)
+
+# Volume types users may add; node-level ones like hostPath, or nfs, which the
+# kubelet mounts from any server, are not allowed. Admins expose NFS as PVCs.
+ALLOWED_VOLUME_SOURCES = frozenset(
+    ["persistentVolumeClaim", "configMap", "secret", "csi", "emptyDir", "ephemeral",
+     "projected", "downwardAPI"]
+)
+
+
+# Runs as root before the DevServer container. A fresh PVC is root-owned and
+# empty, so on first boot it is seeded from the image's /etc/skel and handed
+# to the dev user; later boots keep the user's files as they are, unless the
+# owner's UID/GID changed. The marker records the IDs the files belong to.
+INIT_HOME_SCRIPT = """
+set -eu
+MARKER=/home/dev/.devserver-initialized
+# Without root, e.g. under the restricted Pod Security Standard, fsGroup hands
+# the volume to the dev user instead
+AS_ROOT=$([ "$(id -u)" = 0 ] && echo true || echo false)
+if [ -e "$MARKER" ] && [ "$(cat "$MARKER")" = "$DEV_UID:$DEV_GID" ]; then
+  echo "[INIT] Home directory already initialized."
+  if $AS_ROOT; then
+    chown "$DEV_UID:$DEV_GID" /home/dev
+  fi
+  exit 0
+fi
+if [ ! -e "$MARKER" ]; then
+  echo "[INIT] Initializing the home directory..."
+  if [ -d /etc/skel ]; then
+    cp -Rn /etc/skel/. /home/dev/ || true
+  fi
+  mkdir -p /home/dev/.ssh
+  chmod 700 /home/dev/.ssh
+fi
+if $AS_ROOT; then
+  echo "[INIT] Handing the home directory to UID $DEV_UID and GID $DEV_GID..."
+  chown -R "$DEV_UID:$DEV_GID" /home/dev
+  chmod 755 /home/dev
+fi
+echo "$DEV_UID:$DEV_GID" > "$MARKER"
+echo "[INIT] Home directory initialized."
+"""
+
+
+def _posix_ids_env(posix_ids: PosixIds) -> List[Dict[str, str]]:
+    return [
+        {"name": "DEV_UID", "value": str(posix_ids.uid)},
+        {"name": "DEV_GID", "value": str(posix_ids.gid)},
+    ]
+
+
+def build_init_home_container(image: str, posix_ids: PosixIds) -> Dict[str, Any]:
+    """
+    Builds the init container preparing the home directory. It uses the
+    DevServer's image, so that its skeleton matches the distribution.
+    """
+    return {
+        "name": "init-home",
+        "image": image,
+        "command": ["/bin/sh", "-c"],
+        "args": [INIT_HOME_SCRIPT],
+        "env": _posix_ids_env(posix_ids),
+        "securityContext": {"runAsUser": 0},
+        "volumeMounts": [{"name": "home", "mountPath": "/home/dev"}],
+    }
+
+
+def build_authorized_keys_volume(spec: Dict[str, Any]) -> Optional[Dict[str, Any]]:
+    """
+    Builds the volume holding the user's authorized_keys, if there is one.
+
+    An explicit `spec.ssh.authorizedKeysSecretRef` must exist. Otherwise, the
+    conventional `<owner>-ssh-keys` Secret is mounted if it happens to exist.
+    The `<collaborator>-ssh-keys` Secrets of collaborators are projected next
+    to it, as `collaborators/<collaborator>`, if they exist.
+    """
+    secret_ref = spec.get("ssh", {}).get("authorizedKeysSecretRef")
+    if secret_ref:
+        secret_name = secret_ref["name"]
+        key = secret_ref.get("key", DEFAULT_AUTHORIZED_KEYS_SECRET_KEY)
+        optional = False
+    elif spec.get("owner"):
+        secret_name = owner_ssh_keys_secret_name(spec["owner"])
+        key = DEFAULT_AUTHORIZED_KEYS_SECRET_KEY
+        optional = True
+    else:
+        secret_name = None
+
+    collaborators = spec.get("collaborators") or []
+    if not collaborators:
+        if secret_name is None:
+            return None
+        return {
+            "name": "authorized-keys",
+            "secret": {
+                "secretName": secret_name,
+                "items": [{"key": key, "path": "authorized_keys"}],
+                "optional": optional,
+            },
+        }
+
+    # A projected volume only when needed, so that existing pods are not rolled
+    sources = []
+    if secret_name is not None:
+        sources.append(
+            {
+                "secret": {
+                    "name": secret_name,
+                    "items": [{"key": key, "path": "authorized_keys"}],
+                    "optional": optional,
+                }
+            }
+        )
+    for collaborator in collaborators:
+        path = f"collaborators/{owner_to_dns_label(collaborator)}"
+        sources.append(
+            {
+                "secret": {
+                    "name": owner_ssh_keys_secret_name(collaborator),
+                    "items": [{"key": DEFAULT_AUTHORIZED_KEYS_SECRET_KEY, "path": path}],
+                    "optional": True,
+                }
+            }
+        )
+    return {"name": "authorized-keys", "projected": {"sources": sources}}
+
+
+def _mount_path_conflict(mount_path: str, home: str) -> Optional[str]:
+    """The reserved path a user mount would shadow or be mounted into, if any."""
+    mount_path = mount_path.rstrip("/")
+    # Mounting into the home directory is fine, into the operator's paths is not
+    if mount_path == home or home.startswith(mount_path + "/"):
+        return home
+    for reserved in RESERVED_MOUNT_PATHS:
+        if mount_path == reserved or reserved.startswith(mount_path + "/"):
+            return reserved
+        if mount_path.startswith(reserved + "/"):
+            return reserved
+    return None
+
+
+def validate_user_home_mount_path(spec: Dict[str, Any]) -> None:
+    """
+    Check that `spec.homeMountPath` stays clear of the operator's paths.
+
+    Raises:
+        ValueError: If the home directory is the root directory or would
+            shadow or be mounted into a path managed by the operator.
+    """
+    home = get_home_mount_path(spec)
+    if home == "":
+        raise ValueError("the home directory cannot be '/'.")
+    if not home.startswith("/"):
+        raise ValueError(f"'{home}' is not an absolute path.")
+    for reserved in (*RESERVED_MOUNT_PATHS, SCRATCH_MOUNT_PATH):
+        if home == reserved or reserved.startswith(home + "/") or home.startswith(reserved + "/"):
+            raise ValueError(
+                f"'{home}' conflicts with '{reserved}', which is managed by the operator."
+            )
+
+
+def get_shared_volumes(spec: Dict[str, Any]) -> List[Dict[str, Any]]:
+    """
+    The shared PVCs to mount, as `{"volumeName", "claimName", "mountPath", "readOnly"}`.
+
+    The legacy `spec.sharedVolumeClaimName` keeps its `shared` volume at
+    /shared, so existing pods are not rolled.
+    """
+    shared_volumes = []
+    if spec.get("sharedVolumeClaimName"):
+        shared_volumes.append(
+            {
+                "volumeName": "shared",
+                "claimName": spec["sharedVolumeClaimName"],
+                "mountPath": LEGACY_SHARED_MOUNT_PATH,
+                "readOnly": False,
+            }
+        )
+    for index, shared_volume in enumerate(spec.get("sharedVolumes", [])):
+        shared_volumes.append(
+            {
+                "volumeName": f"{SHARED_VOLUME_NAME_PREFIX}{index}",
+                "claimName": shared_volume["claimName"],
+                "mountPath": shared_volume["mountPath"],
+                "readOnly": shared_volume.get("readOnly", False),
+            }
+        )
+    return shared_volumes
+
+
+def validate_user_volumes(spec: Dict[str, Any]) -> None:
+    """
+    Check `spec.sharedVolumes`, `spec.volumes` and `spec.volumeMounts`
+    against the generated pod.
+
+    Raises:
+        ValueError: If a volume or mount conflicts with the operator's own or
+            another one, uses a disallowed volume type or references an
+            undefined volume.
+    """
+    home = get_home_mount_path(spec)
+    mount_paths = {SCRATCH_MOUNT_PATH} if spec.get("scratch") else set()
+    for shared_volume in get_shared_volumes(spec):
+        mount_path = shared_volume["mountPath"].rstrip("/")
+        conflict = _mount_path_conflict(mount_path, home)
+        if conflict:
+            raise ValueError(
+                f"shared volume mountPath '{mount_path}' conflicts with '{conflict}', "
+                "which is managed by the operator."
+            )
+        if mount_path in mount_paths:
+            raise ValueError(f"mountPath '{mount_path}' is used more than once.")
+        mount_paths.add(mount_path)
+
+    names = set()
+    for volume in spec.get("volumes", []):
+        name = volume["name"]
+        if name in RESERVED_VOLUME_NAMES or name.startswith(
+            (SHARED_VOLUME_NAME_PREFIX, CREDENTIALS_VOLUME_NAME_PREFIX)
+        ):
+            raise ValueError(f"volume name '{name}' is reserved by the operator.")
+        if name in names:
+            raise ValueError(f"volume '{name}' is defined more than once.")
+        names.add(name)
+        sources = set(volume) - {"name"}
+        if len(sources) != 1 or not sources <= ALLOWED_VOLUME_SOURCES:
+            raise ValueError(
+                f"volume '{name}' must have exactly one of "
+                f"{', '.join(sorted(ALLOWED_VOLUME_SOURCES))}."
+            )
+
+    for mount in spec.get("volumeMounts", []):
+        if mount["name"] not in names:
+            raise ValueError(
+                f"volumeMount '{mount['mountPath']}' references undefined volume '{mount['name']}'."
+            )
+        conflict = _mount_path_conflict(mount["mountPath"], home)
+        if conflict:
+            raise ValueError(
+                f"volumeMount '{mount['mountPath']}' conflicts with '{conflict}', "
+                "which is managed by the operator."
+            )
+        if mount["mountPath"].rstrip("/") in mount_paths:
+            raise ValueError(f"mountPath '{mount['mountPath']}' is used more than once.")
+        mount_paths.add(mount["mountPath"].rstrip("/"))
+
+
+# Containers of the generated pod that spec.sidecars and spec.initContainers cannot replace
+RESERVED_CONTAINER_NAMES = frozenset(
+    ["devserver", "install-sshd", "restore-home", "init-home", PREWARM_CONTAINER_NAME,
+     DOTFILES_CONTAINER_NAME, CODE_SERVER_CONTAINER_NAME]
+)
+
+
+def validate_user_containers(spec: Dict[str, Any]) -> None:
+    """
+    Check that `spec.sidecars` and `spec.initContainers` have unique names
+    not used by the operator.
+
+    Raises:
+        ValueError: If a container's name is reserved or used more than once.
+    """
+    names = set()
+    for container in [*spec.get("sidecars", []), *spec.get("initContainers", [])]:
+        name = container["name"]
+        if name in RESERVED_CONTAINER_NAMES:
+            raise ValueError(f"container name '{name}' is reserved by the operator.")
+        if name in names:
+            raise ValueError(f"container '{name}' is defined more than once.")
+        names.add(name)
+
+
+# Environment variables startup.sh relies on, which spec.env cannot set
+RESERVED_ENV_NAMES = frozenset(["SSH_PUBLIC_KEY", "DEV_UID", "DEV_GID"])
+RESERVED_ENV_PREFIX = "DEVSERVER_"
+
+
+def validate_user_env(spec: Dict[str, Any]) -> None:
+    """
+    Check that `spec.env` does not set variables the operator relies on.
+
+    Raises:
+        ValueError: If a variable is reserved by the operator.
+    """
+    for env_var in spec.get("env", []):
+        name = env_var["name"]
+        if name in RESERVED_ENV_NAMES or name.startswith(RESERVED_ENV_PREFIX):
+            raise ValueError(f"environment variable '{name}' is reserved by the operator.")
+
+
+HOST_ACCESS_FIELDS = ("hostNetwork", "hostIPC")
+
+
+def is_privileged_container(container: Dict[str, Any]) -> bool:
+    """
+    Whether a user container asks for more than an unprivileged pod gets:
+    privileged mode, privilege escalation, added capabilities, root or host ports.
+    """
+    security_context = container.get("securityContext") or {}
+    return bool(
+        security_context.get("privileged")
+        or security_context.get("allowPrivilegeEscalation")
+        or (security_context.get("capabilities") or {}).get("add")
+        or security_context.get("runAsUser") == 0
+        or security_context.get("runAsNonRoot") is False
+        or any(port.get("hostPort") for port in container.get("ports", []))
+    )
+
+
+def validate_user_host_access(
+    spec: Dict[str, Any],
+    flavor: Dict[str, Any],
+    requesters: Optional[Iterable[str]] = None,
+    owner_identity: bool = False,
+) -> None:
+    """
+    Check that the flavor's `hostAccess` policy allows the host namespaces
+    and privileged containers the DevServer requests, and that whoever asks
+    for them may use them.
+
+    Args:
+        requesters: The identities of the user making the request, checked
+            against `allowedOwners`. Without them, `spec.owner` is, which is
+            only trustworthy with `owner_identity`.
+
+    Raises:
+        ValueError: If the flavor does not allow a requested host namespace
+            or privileged container, or restricts it to other users.
+    """
+    policy = flavor["spec"].get("hostAccess", {})
+    requested = [field for field in HOST_ACCESS_FIELDS if spec.get(field, False)]
+    # The operator creates the pod, so user containers must not get around the
+    # policy by asking for host privileges themselves.
+    privileged = [
+        container["name"]
+        for container in [*spec.get("sidecars", []), *spec.get("initContainers", [])]
+        if is_privileged_container(container)
+    ]
+    if privileged:
+        if not policy.get("privilegedContainers", False):
+            raise ValueError(
+                f"flavor '{spec.get('flavor')}' does not allow privileged containers "
+                f"({', '.join(privileged)})."
+            )
+        requested.append("privilegedContainers")
+    for field in requested:
+        if not policy.get(field, False):
+            raise ValueError(f"flavor '{spec.get('flavor')}' does not allow {field}.")
+    allowed_owners = {owner.lower() for owner in policy.get("allowedOwners", [])}
+    if not requested or not allowed_owners:
+        return
+    if requesters is None:
+        # Anyone can put an allowed owner's name into spec.owner otherwise
+        if not owner_identity:
+            raise ValueError(
+                f"flavor '{spec.get('flavor')}' restricts {' and '.join(requested)} to "
+                "allowedOwners, which requires DEVSERVER_OWNER_IDENTITY_ENABLED."
+            )
+        requesters = [spec.get("owner", "")]
+    requesters = list(requesters)
+    if not {requester.lower() for requester in requesters} & allowed_owners:
+        raise ValueError(
+            f"'{requesters[0] if requesters else ''}' may not use {' or '.join(requested)} "
+            f"with flavor '{spec.get('flavor')}'."
+        )
+
+
+def validate_user_priority_class(spec: Dict[str, Any], flavor: Dict[str, Any]) -> None:
+    """
+    Check that the flavor permits the PriorityClass the DevServer picks.
+
+    Raises:
+        ValueError: If it is neither the flavor's own PriorityClass nor one of
+            its `allowedPriorityClasses`.
+    """
+    priority_class = spec.get("priorityClassName")
+    if not priority_class or priority_class == flavor["spec"].get("priorityClassName"):
+        return
+    allowed = flavor["spec"].get("allowedPriorityClasses", [])
+    if priority_class not in allowed:
+        hint = f" Allowed: {', '.join(allowed)}." if allowed else ""
+        raise ValueError(
+            f"flavor '{spec.get('flavor')}' does not allow priorityClassName "
+            f"'{priority_class}'.{hint}"
+        )
+
+
+def validate_user_restricted_pod_security(spec: Dict[str, Any]) -> None:
+    """
+    Check that the DevServer can run under the restricted Pod Security Standard.
+
+    Raises:
+        ValueError: If it requests host namespaces, or sshd would need root to
+            bind its port.
+    """
+    requested = [field for field in HOST_ACCESS_FIELDS if spec.get(field, False)]
+    if requested:
+        raise ValueError(f"{' and '.join(requested)} cannot be used with restricted pods.")
+    port = get_ssh_port(spec)
+    if port < 1024:
+        raise ValueError(
+            f"ssh.port {port} is privileged; restricted pods need a port of 1024 or more."
+        )
+
+
+def _startup_args(main_command: List[str]) -> List[str]:
+    if not main_command:
+        return ["/devserver/startup.sh"]
+    # sh -c passes the words after the script name on as "$@"
+    return ['exec /devserver/startup.sh "$@"', "startup.sh", *main_command]
+
+
+def get_main_command(spec: Dict[str, Any], flavor: Dict[str, Any]) -> List[str]:
+    """
+    The command startup.sh runs as the container's main process instead of
+    sshd, if any.
+
+    `spec.command` and `spec.args` take precedence over the flavor's
+    defaults; like a container's command and args, the DevServer's args are
+    passed to the flavor's command, but its command drops the flavor's args.
+    """
+    flavor_spec = flavor["spec"]
+    if spec.get("command"):
+        return [*spec["command"], *spec.get("args", [])]
+    command = flavor_spec.get("command", [])
+    args = spec["args"] if "args" in spec else flavor_spec.get("args", [])
+    return [*command, *args]
+
+
+PROBE_FIELDS = ("readinessProbe", "livenessProbe", "startupProbe")
+
+
+def build_probes(spec: Dict[str, Any], flavor: Dict[str, Any]) -> Dict[str, Any]:
+    """
+    The DevServer container's probes. Each probe is taken from the DevServer,
+    then its flavor; an empty probe disables it.
+
+    By default, the pod is only ready once sshd accepts connections, so that
+    its Services never route to it before.
+    """
+    defaults: Dict[str, Any] = {}
+    if spec.get("enableSSH", False):
+        defaults["readinessProbe"] = {
+            "tcpSocket": {"port": get_ssh_port(spec)},
+            "periodSeconds": 5,
+            "failureThreshold": 3,
+        }
+    probes = {}
+    for field in PROBE_FIELDS:
+        if field in spec:
+            probe = spec[field]
+        elif field in flavor["spec"]:
+            probe = flavor["spec"][field]
+        else:
+            probe = defaults.get(field)
+        if probe:
+            probes[field] = probe
+    return probes
+
 
 def build_statefulset(
-    name: str, namespace: str, spec: Dict[str, Any], flavor: Dict[str, Any]
+    name: str,
+    namespace: str,
+    spec: Dict[str, Any],
+    flavor: Dict[str, Any],
+    owner_ids: Optional[PosixIds] = None,
+    restricted: bool = False,
+    capacity_scheduling: Optional[Dict[str, Any]] = None,
+    packing: Optional[PackingDefaults] = None,
+    ide: Optional[IDEConfig] = None,
+    agent: bool = False,
 ) -> Dict[str, Any]:
-    """Builds the StatefulSet for the DevServer."""
+    """
+    Builds the StatefulSet for the DevServer.
+
+    Args:
+        owner_ids: The owner's UID/GID from their DevServerUser, if any.
+        restricted: Whether the pod must comply with the restricted Pod
+            Security Standard, running everything as the dev user.
+        capacity_scheduling: The node selector and tolerations of the spot
+            or on-demand capacity the DevServer runs on, if it picks one.
+        packing: The operator's preference for packing DevServers onto
+            shared nodes, if any.
+        ide: The operator's IDE configuration, e.g. the code-server image.
+        agent: Whether the pod runs the devserver-agent.
+    """
     image = spec.get("image", DEFAULT_DEVSERVER_IMAGE)
+    home = get_home_mount_path(spec)
+    posix_ids = owner_ids or DEFAULT_POSIX_IDS
+    capacity_scheduling = capacity_scheduling or {}
+    team_scheduling = team_node_pool_scheduling(find_team_node_pool(namespace, spec, flavor))
 
     # Get the public key from the spec
     ssh_public_key = spec.get("ssh", {}).get("publicKey", "")
@@ -15,16 +572,42 @@ def build_statefulset(
     persistent_home = spec.get("persistentHome", {})
     persistent_home_enabled = persistent_home.get("enabled", False)
     persistent_home_size = persistent_home.get("size", "10Gi")
+    storage_class_name = home_storage_class_name(spec, flavor)
 
     statefulset_spec = {
-        "replicas": 1,
+        # Hibernated DevServers keep their StatefulSet, and so their PVC, but no pod
+        "replicas": 0 if spec.get("hibernated", False) else 1,
         "serviceName": f"{name}-headless",
         "selector": {"matchLabels": {"app": name}},
         "template": {
-            "metadata": {"labels": {"app": name}},
+            "metadata": {
+                # The operator's own labels and annotations take precedence
+                "labels": {**spec.get("podLabels", {}), "app": name},
+                "annotations": {
+                    **spec.get("podAnnotations", {}),
+                    SSHD_CONFIG_CHECKSUM_ANNOTATION: sshd_config_checksum(
+                        render_sshd_config(spec, restricted)
+                    ),
+                },
+            },
             "spec": {
-                "nodeSelector": flavor["spec"].get("nodeSelector"),
-                "tolerations": flavor["spec"].get("tolerations"),
+                "serviceAccountName": service_account_name(name),
+                # Only DevServers that use the Kubernetes API get a token
+                "automountServiceAccountToken": spec.get("serviceAccount", {}).get(
+                    "automountToken", False
+                ),
+                "nodeSelector": {
+                    **get_flavor_node_selector(flavor),
+                    **capacity_scheduling.get("nodeSelector", {}),
+                    **team_scheduling["nodeSelector"],
+                },
+                "tolerations": [
+                    *(flavor["spec"].get("tolerations") or []),
+                    *capacity_scheduling.get("tolerations", []),
+                    *team_scheduling["tolerations"],
+                ],
+                # Narrows scheduling within the flavor's nodes, e.g. to a zone
+                "affinity": get_affinity(spec),
                 "initContainers": [
                     {
                         "name": "install-sshd",
@@ -48,6 +631,7 @@ def build_statefulset(
                         ],
                         "volumeMounts": [{"name": "bin", "mountPath": "/opt/bin"}],
                     },
+                    build_init_home_container(image, posix_ids),
                 ],
                 "containers": [
                     {
@@ -55,10 +639,12 @@ def build_statefulset(
                         "image": image,
                         "imagePullPolicy": "Always",
                         "command": ["/bin/sh", "-c"],
-                        "args": ["/devserver/startup.sh"],
-                        "ports": [{"containerPort": 22}],
+                        "args": _startup_args(get_main_command(spec, flavor)),
+                        "ports": [
+                            {"name": "ssh", "containerPort": get_ssh_port(spec), "protocol": "TCP"}
+                        ],
                         "volumeMounts": [
-                            {"name": "home", "mountPath": "/home/dev"},
+                            {"name": "home", "mountPath": home},
                             {"name": "bin", "mountPath": "/opt/bin"},
                             {
                                 "name": "startup-script",
@@ -72,6 +658,12 @@ def build_statefulset(
                                 "subPath": "user_login.sh",
                                 "readOnly": True,
                             },
+                            # Not a subPath, so that updates reach the running pod
+                            {
+                                "name": MOTD_VOLUME_NAME,
+                                "mountPath": MOTD_MOUNT_PATH,
+                                "readOnly": True,
+                            },
                             {
                                 "name": "sshd-config",
                                 "mountPath": "/opt/ssh/sshd_config",
@@ -84,12 +676,14 @@ def build_statefulset(
                                 "readOnly": True,
                             },
                         ],
-                        "resources": flavor["spec"]["resources"],
+                        "resources": get_flavor_resources(flavor),
                         "env": [
                             {
                                 "name": "SSH_PUBLIC_KEY",
                                 "value": ssh_public_key,
                             },
+                            *_posix_ids_env(posix_ids),
+                            {"name": "DEVSERVER_HOME", "value": home},
                         ],
                     }
                 ],
@@ -109,6 +703,10 @@ def build_statefulset(
                             "defaultMode": 0o755,
                         },
                     },
+                    {
+                        "name": MOTD_VOLUME_NAME,
+                        "configMap": {"name": motd_configmap_name(name), "optional": True},
+                    },
                     {
                         "name": "sshd-config",
                         "configMap": {"name": f"{name}-sshd-config"},
@@ -133,17 +731,142 @@ def build_statefulset(
     assert isinstance(volumes, list)
 
     if persistent_home_enabled:
+        home_claim_spec: Dict[str, Any] = {
+            "accessModes": ["ReadWriteOnce"],
+            "resources": {"requests": {"storage": persistent_home_size}},
+        }
+        # Without a storage class, the cluster's default one is used
+        if storage_class_name:
+            home_claim_spec["storageClassName"] = storage_class_name
+        # Seeds a new home PVC; existing PVCs are never overwritten
+        home_source = spec.get("homeSource", {})
+        if "snapshotRef" in home_source:
+            home_claim_spec["dataSource"] = home_volume_snapshot_data_source(
+                home_source["snapshotRef"]["name"]
+            )
+        elif "fromDevServer" in home_source:
+            # A CSI clone, which needs the source's StorageClass and namespace
+            home_claim_spec["dataSource"] = {
+                "kind": "PersistentVolumeClaim",
+                "name": home_pvc_name(home_source["fromDevServer"]["name"]),
+            }
+        elif "backupRef" in home_source:
+            init_containers = pod_spec["initContainers"]
+            assert isinstance(init_containers, list)
+            init_containers.insert(0, build_restore_home_container(name))
         statefulset_spec["volumeClaimTemplates"] = [
+            {"metadata": {"name": "home"}, "spec": home_claim_spec}
+        ]
+    else:
+        volumes.append({"name": "home", "emptyDir": {}})
+
+    authorized_keys_volume = build_authorized_keys_volume(spec)
+    if authorized_keys_volume:
+        volumes.append(authorized_keys_volume)
+        containers = pod_spec.get("containers")
+        assert isinstance(containers, list)
+        containers[0]["volumeMounts"].append(
+            {
+                "name": "authorized-keys",
+                "mountPath": AUTHORIZED_KEYS_MOUNT_PATH,
+                "readOnly": True,
+            }
+        )
+
+    # Runs inside the DevServer container, once the home directory is mounted
+    config_map_ref = spec.get("bootstrap", {}).get("configMapRef")
+    if config_map_ref:
+        key = config_map_ref.get("key", DEFAULT_BOOTSTRAP_CONFIGMAP_KEY)
+        volumes.append(
             {
-                "metadata": {"name": "home"},
-                "spec": {
-                    "accessModes": ["ReadWriteOnce"],
-                    "resources": {"requests": {"storage": persistent_home_size}},
+                "name": "bootstrap-script",
+                "configMap": {
+                    "name": config_map_ref["name"],
+                    "items": [{"key": key, "path": "bootstrap.sh"}],
+                    "defaultMode": 0o755,
                 },
             }
+        )
+        containers = pod_spec.get("containers")
+        assert isinstance(containers, list)
+        containers[0]["volumeMounts"].append(
+            {"name": "bootstrap-script", "mountPath": BOOTSTRAP_MOUNT_PATH, "readOnly": True}
+        )
+        containers[0]["env"].append(
+            {"name": "DEVSERVER_BOOTSTRAP_SCRIPT", "value": f"{BOOTSTRAP_MOUNT_PATH}/bootstrap.sh"}
+        )
+
+    mosh_ports = get_mosh_ports(spec)
+    if mosh_ports:
+        containers = pod_spec.get("containers")
+        assert isinstance(containers, list)
+        containers[0]["ports"].extend(
+            {"name": f"mosh-{port}", "containerPort": port, "protocol": "UDP"}
+            for port in mosh_ports
+        )
+        # Tells startup.sh to make sure mosh-server is installed; mosh-server
+        # refuses to start without a UTF-8 locale.
+        containers[0]["env"].extend(
+            [
+                {"name": "DEVSERVER_MOSH_ENABLED", "value": "true"},
+                {"name": "LANG", "value": "C.UTF-8"},
+            ]
+        )
+
+    containers = pod_spec.get("containers")
+    assert isinstance(containers, list)
+    containers[0].update(build_probes(spec, flavor))
+
+    # Containers the user adds run as the owner, so that their files on shared
+    # volumes get the owner's IDs; the operator's containers need root, e.g. for sshd.
+    if owner_ids is not None and not restricted:
+        pod_spec["securityContext"] = {
+            "runAsUser": owner_ids.uid,
+            "runAsGroup": owner_ids.gid,
+            "fsGroup": owner_ids.gid,
+            # Avoids walking large volumes on every start
+            "fsGroupChangePolicy": "OnRootMismatch",
+        }
+        containers = pod_spec.get("containers")
+        init_containers = pod_spec.get("initContainers")
+        assert isinstance(containers, list) and isinstance(init_containers, list)
+        for container in [*init_containers, *containers]:
+            container.setdefault("securityContext", {}).update({"runAsUser": 0, "runAsGroup": 0})
+
+    # Node namespaces for RDMA/NCCL, allowed by the flavor's hostAccess policy
+    if spec.get("hostNetwork", False):
+        pod_spec["hostNetwork"] = True
+        # Keeps resolving cluster Services from the node's network namespace
+        pod_spec["dnsPolicy"] = "ClusterFirstWithHostNet"
+    if spec.get("hostIPC", False):
+        pod_spec["hostIPC"] = True
+
+    # Ranks DevServers of the flavor against other workloads, e.g. batch training jobs
+    for field in ("priorityClassName", "preemptionPolicy"):
+        if flavor["spec"].get(field):
+            pod_spec[field] = flavor["spec"][field]
+    # One of the flavor's permitted PriorityClasses, e.g. for on-call debugging,
+    # whose own preemption policy then applies
+    if spec.get("priorityClassName") and spec["priorityClassName"] != pod_spec.get(
+        "priorityClassName"
+    ):
+        pod_spec["priorityClassName"] = spec["priorityClassName"]
+        pod_spec.pop("preemptionPolicy", None)
+
+    # Spreads the pods of the flavor's DevServers, e.g. across zones, or packs
+    # them with a scheduler whose profile scores the most allocated nodes higher
+    if flavor["spec"].get("schedulerName"):
+        pod_spec["schedulerName"] = flavor["spec"]["schedulerName"]
+    constraints = flavor["spec"].get("topologySpreadConstraints")
+    if constraints:
+        flavor_name = flavor.get("metadata", {}).get("name", "")
+        template["metadata"]["labels"][FLAVOR_LABEL] = flavor_name
+        pod_spec["topologySpreadConstraints"] = [
+            {"labelSelector": {"matchLabels": {FLAVOR_LABEL: flavor_name}}, **constraint}
+            for constraint in constraints
         ]
-    else:
-        volumes.append({"name": "home", "emptyDir": {}})
+    if packing is not None and packing_requested(flavor, packing.enabled):
+        apply_packing(template, packing.topology_key, packing.weight)
 
     # Remove nodeSelector if it is None
     if not pod_spec.get("nodeSelector"):
@@ -153,33 +876,135 @@ def build_statefulset(
     if not pod_spec.get("tolerations"):
         pod_spec.pop("tolerations", None)
 
-    # Add shared volume if specified
-    if "sharedVolumeClaimName" in spec:
-        pvc_name = spec["sharedVolumeClaimName"]
+    if not pod_spec.get("affinity"):
+        pod_spec.pop("affinity", None)
 
-        volumes = pod_spec.get("volumes")
-        assert isinstance(volumes, list)
-        # Add the volume that points to the existing PVC
-        volumes.append(
-            {"name": "shared", "persistentVolumeClaim": {"claimName": pvc_name}}
+    scratch = spec.get("scratch")
+    if scratch:
+        containers = pod_spec.get("containers")
+        assert isinstance(containers, list)
+        volumes.append(build_scratch_volume(scratch))
+        containers[0]["volumeMounts"].append(
+            {"name": SCRATCH_VOLUME_NAME, "mountPath": SCRATCH_MOUNT_PATH}
         )
+        containers[0]["resources"] = scratch_resources(containers[0]["resources"], scratch)
 
+    # Mount shared PVCs, e.g. EFS or Filestore volumes of a team
+    shared_volumes = get_shared_volumes(spec)
+    if shared_volumes:
         containers = pod_spec.get("containers")
         assert isinstance(containers, list)
-        container = containers[0]
-        assert isinstance(container, dict)
-
-        volume_mounts = container.get("volumeMounts")
+        volume_mounts = containers[0].get("volumeMounts")
         assert isinstance(volume_mounts, list)
-        # Mount the volume into the container
-        volume_mounts.append({"name": "shared", "mountPath": "/shared"})
+        for shared_volume in shared_volumes:
+            claim: Dict[str, Any] = {"claimName": shared_volume["claimName"]}
+            mount: Dict[str, Any] = {
+                "name": shared_volume["volumeName"],
+                "mountPath": shared_volume["mountPath"],
+            }
+            if shared_volume["readOnly"]:
+                claim["readOnly"] = True
+                mount["readOnly"] = True
+            volumes.append({"name": shared_volume["volumeName"], "persistentVolumeClaim": claim})
+            volume_mounts.append(mount)
+
+    # Org-managed credentials, e.g. git tokens or kubeconfigs, at a conventional path
+    credential_bundles = get_credential_bundles(spec)
+    if credential_bundles:
+        containers = pod_spec.get("containers")
+        assert isinstance(containers, list)
+        for bundle in credential_bundles:
+            volumes.append(build_credential_volume(bundle))
+            containers[0]["volumeMounts"].append(build_credential_mount(bundle))
+        containers[0]["env"].append(
+            {"name": "DEVSERVER_CREDENTIALS_DIR", "value": CREDENTIALS_MOUNT_PATH}
+        )
+
+    # Appended, so that the operator's variables can be referenced, e.g. $(SSH_PUBLIC_KEY)
+    if spec.get("env") or spec.get("envFrom"):
+        containers = pod_spec.get("containers")
+        assert isinstance(containers, list)
+        containers[0]["env"].extend(spec.get("env", []))
+        if spec.get("envFrom"):
+            containers[0]["envFrom"] = list(spec["envFrom"])
+
+    # Installs the packages of spec.prewarm into a cache on the home volume,
+    # which startup.sh puts on the PATH
+    prewarm_container = build_prewarm_container(spec, image, home, _posix_ids_env(posix_ids))
+    if prewarm_container:
+        init_containers = pod_spec.get("initContainers")
+        containers = pod_spec.get("containers")
+        assert isinstance(init_containers, list) and isinstance(containers, list)
+        init_containers.append(prewarm_container)
+        containers[0]["env"].append({"name": "DEVSERVER_PREWARM_DIR", "value": prewarm_dir(home)})
+
+    # Added after the operator's containers are made root, as the dotfiles
+    # belong to the dev user
+    dotfiles_repo = get_dotfiles_repo(spec)
+    if dotfiles_repo:
+        init_containers = pod_spec.get("initContainers")
+        assert isinstance(init_containers, list)
+        init_containers.append(
+            build_dotfiles_container(dotfiles_repo, image, home, posix_ids.uid, posix_ids.gid)
+        )
+
+    # User init containers run once the home directory is ready
+    if spec.get("initContainers"):
+        init_containers = pod_spec.get("initContainers")
+        assert isinstance(init_containers, list)
+        init_containers.extend(spec["initContainers"])
+
+    # Browser-based editing of the home directory, as the dev user
+    if spec.get("ide") == IDE_CODE_SERVER:
+        containers = pod_spec.get("containers")
+        assert isinstance(containers, list)
+        containers.append(
+            build_code_server_container(
+                name, (ide or IDEConfig()).code_server_image, home, posix_ids.uid, posix_ids.gid
+            )
+        )
+
+    # startup.sh starts the agent, which reports the pod's activity
+    if agent:
+        volumes.extend(build_agent_volumes(name))
+        containers = pod_spec.get("containers")
+        assert isinstance(containers, list)
+        containers[0]["volumeMounts"].extend(build_agent_volume_mounts())
+        containers[0]["env"].append(
+            {"name": "DEVSERVER_AGENT", "value": f"{AGENT_MOUNT_PATH}/devserver-agent"}
+        )
+
+    # Sidecars can mount the pod's volumes, e.g. to ship logs from the home directory
+    if spec.get("sidecars"):
+        containers = pod_spec.get("containers")
+        assert isinstance(containers, list)
+        containers.extend(spec["sidecars"])
+
+    # User volumes are only mounted into the DevServer container
+    if spec.get("volumes"):
+        volumes.extend(spec["volumes"])
+    if spec.get("volumeMounts"):
+        containers = pod_spec.get("containers")
+        assert isinstance(containers, list)
+        containers[0]["volumeMounts"].extend(spec["volumeMounts"])
+
+    # Last, so that it covers the user's containers as well
+    if restricted:
+        restrict_pod_spec(pod_spec, posix_ids.uid, posix_ids.gid, flavor)
+        containers = pod_spec.get("containers")
+        assert isinstance(containers, list)
+        containers[0]["env"].append({"name": "DEVSERVER_SSHD_DIR", "value": RESTRICTED_SSHD_DIR})
+    apply_security_profiles(pod_spec, flavor)
+
+    metadata: Dict[str, Any] = {"name": name, "namespace": namespace}
+    # Kueue gates the pod until its Workload is admitted from the queue
+    queue_name = get_queue_name(flavor)
+    if queue_name:
+        metadata["labels"] = {QUEUE_NAME_LABEL: queue_name}
 
     return {
         "apiVersion": "apps/v1",
         "kind": "StatefulSet",
-        "metadata": {
-            "name": name,
-            "namespace": namespace,
-        },
+        "metadata": metadata,
         "spec": statefulset_spec,
     }
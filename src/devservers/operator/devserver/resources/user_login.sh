@@ -88,13 +88,74 @@ display_devserver_banner() {
     echo
 }
 
+# Reads a field of the DevServer's metadata, refreshed by the operator
+motd_field() {
+    cat "${DEVSERVER_MOTD_DIR}/$1" 2>/dev/null || true
+}
+
+display_devserver_info() {
+    if [ ! -d "${DEVSERVER_MOTD_DIR}" ]; then
+        return 0
+    fi
+    NAME=$(motd_field name)
+    FLAVOR=$(motd_field flavor)
+    OWNER=$(motd_field owner)
+    EXPIRES_AT=$(motd_field expiresAt)
+    EXPIRES_AT_EPOCH=$(motd_field expiresAtEpoch)
+
+    printf "${C_BLUE}${C_BOLD}%-8s${C_RESET}: %s\n" "Server" "${NAME}.$(motd_field namespace)"
+    printf "${C_BLUE}${C_BOLD}%-8s${C_RESET}: %s\n" "Flavor" "${FLAVOR}"
+    if [ -n "${OWNER}" ]; then
+        printf "${C_BLUE}${C_BOLD}%-8s${C_RESET}: %s\n" "Owner" "${OWNER}"
+    fi
+    COLLABORATORS=$(motd_field collaborators)
+    if [ -n "${COLLABORATORS}" ]; then
+        printf "${C_BLUE}${C_BOLD}%-8s${C_RESET}: %s\n" "Shared" "${COLLABORATORS}"
+    fi
+    if [ -z "${EXPIRES_AT_EPOCH}" ]; then
+        printf "${C_BLUE}${C_BOLD}%-8s${C_RESET}: %s\n" "Expires" "never"
+        echo
+        return 0
+    fi
+    REMAINING=$((EXPIRES_AT_EPOCH - $(date +%s)))
+    if [ "${REMAINING}" -le 0 ]; then
+        COUNTDOWN="expired, about to be deleted"
+    else
+        DAYS=$((REMAINING / 86400))
+        HOURS=$((REMAINING % 86400 / 3600))
+        MINUTES=$((REMAINING % 3600 / 60))
+        if [ "${DAYS}" -gt 0 ]; then
+            COUNTDOWN="in ${DAYS}d ${HOURS}h"
+        else
+            COUNTDOWN="in ${HOURS}h ${MINUTES}m"
+        fi
+    fi
+    # Less than an hour left stands out
+    if [ "${REMAINING}" -lt 3600 ]; then
+        EXPIRES_COLOR="${C_RED}${C_BOLD}"
+    else
+        EXPIRES_COLOR=""
+    fi
+    printf "${C_BLUE}${C_BOLD}%-8s${C_RESET}: ${EXPIRES_COLOR}%s${C_RESET}\n" \
+        "Expires" "${COUNTDOWN} (${EXPIRES_AT})"
+    printf "${C_BLUE}${C_BOLD}%-8s${C_RESET}: %s\n" "Extend" "$(motd_field extendCommand)"
+    echo
+}
+
+# The container's environment, e.g. spec.env, exported by startup.sh
+if [ -r /etc/profile.d/devserver-env.sh ]; then
+    . /etc/profile.d/devserver-env.sh
+fi
+
 COMMAND_TO_EXECUTE="${SSH_ORIGINAL_COMMAND}"
 DISPLAY_BANNER=${DISPLAY_BANNER:-true}
+DEVSERVER_MOTD_DIR=${DEVSERVER_MOTD_DIR:-/devserver-motd}
 case $SSH_ORIGINAL_COMMAND in
     "" | "bash" | "sh" | "zsh")
         # Only display banner if we think it's a login shell
         if [ "${DISPLAY_BANNER}" = "true" ]; then
             display_devserver_banner
+            display_devserver_info
         fi
         ;;
     *)
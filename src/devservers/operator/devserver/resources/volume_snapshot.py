@@ -0,0 +1,38 @@
+from typing import Any, Dict, Optional
+
+VOLUME_SNAPSHOT_API_GROUP = "snapshot.storage.k8s.io"
+VOLUME_SNAPSHOT_API_VERSION = "v1"
+VOLUME_SNAPSHOT_PLURAL = "volumesnapshots"
+
+
+def home_volume_snapshot_data_source(snapshot_name: str) -> Dict[str, Any]:
+    """
+    The `dataSource` of a home PVC restored from a DevServerSnapshot.
+
+    The VolumeSnapshot of a DevServerSnapshot has the same name, see
+    `build_volume_snapshot`.
+    """
+    return {
+        "apiGroup": VOLUME_SNAPSHOT_API_GROUP,
+        "kind": "VolumeSnapshot",
+        "name": snapshot_name,
+    }
+
+
+def build_volume_snapshot(
+    name: str,
+    namespace: str,
+    pvc_name: str,
+    volume_snapshot_class_name: Optional[str] = None,
+) -> Dict[str, Any]:
+    """Builds the CSI VolumeSnapshot of a PVC backing a DevServerSnapshot."""
+    spec: Dict[str, Any] = {"source": {"persistentVolumeClaimName": pvc_name}}
+    # Without a class, the cluster's default VolumeSnapshotClass is used
+    if volume_snapshot_class_name:
+        spec["volumeSnapshotClassName"] = volume_snapshot_class_name
+    return {
+        "apiVersion": f"{VOLUME_SNAPSHOT_API_GROUP}/{VOLUME_SNAPSHOT_API_VERSION}",
+        "kind": "VolumeSnapshot",
+        "metadata": {"name": name, "namespace": namespace},
+        "spec": spec,
+    }
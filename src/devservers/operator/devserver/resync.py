@@ -0,0 +1,45 @@
+"""
+Periodic resync of DevServers, which corrects drift of their resources.
+
+Every DevServer is reconciled again every `DEVSERVER_RESYNC_INTERVAL` seconds
+(30 minutes by default), even when it has not changed, so that resources
+edited or deleted behind the operator's back are put back. The
+`devserver.io/requeue-after` annotation overrides the interval of a single
+DevServer, e.g. `5m` while debugging it, within the bounds the operator sets.
+"""
+import logging
+import os
+from typing import Any, Mapping
+
+from ...crds.const import CRD_GROUP
+from ...utils.time import parse_duration
+
+REQUEUE_AFTER_ANNOTATION = f"{CRD_GROUP}/requeue-after"
+
+RESYNC_INTERVAL = int(os.environ.get("DEVSERVER_RESYNC_INTERVAL", 1800))
+# Bounds of the requeue-after annotation. The minimum is also how often
+# DevServers are checked for being due a resync.
+MIN_REQUEUE_AFTER = int(os.environ.get("DEVSERVER_MIN_REQUEUE_AFTER", 60))
+MAX_REQUEUE_AFTER = int(os.environ.get("DEVSERVER_MAX_REQUEUE_AFTER", 86400))
+
+
+def requeue_after(meta: Mapping[str, Any], logger: logging.Logger) -> float:
+    """Seconds between resyncs of the DevServer."""
+    value = (meta.get("annotations") or {}).get(REQUEUE_AFTER_ANNOTATION)
+    if not value:
+        return RESYNC_INTERVAL
+    try:
+        seconds = parse_duration(value).total_seconds()
+    except ValueError:
+        logger.warning(
+            f"Ignoring invalid {REQUEUE_AFTER_ANNOTATION} annotation '{value}', "
+            f"resyncing every {RESYNC_INTERVAL}s."
+        )
+        return RESYNC_INTERVAL
+    bounded = min(max(seconds, MIN_REQUEUE_AFTER), MAX_REQUEUE_AFTER)
+    if bounded != seconds:
+        logger.warning(
+            f"{REQUEUE_AFTER_ANNOTATION} annotation '{value}' is outside "
+            f"{MIN_REQUEUE_AFTER}s-{MAX_REQUEUE_AFTER}s, resyncing every {bounded:g}s."
+        )
+    return bounded
@@ -0,0 +1,37 @@
+"""
+Operator-level policy for the permissions of DevServers' ServiceAccounts.
+
+Every DevServer runs as its own ServiceAccount, which has no permissions and
+whose token is not mounted unless `spec.serviceAccount.automountToken` is set.
+`spec.serviceAccount.roleTemplate` binds it to a ClusterRole in the
+DevServer's namespace, e.g. for in-pod kubectl access; only the ClusterRoles
+listed in `DEVSERVER_ROLE_TEMPLATES` may be used, so that creating a
+DevServer does not grant arbitrary permissions.
+"""
+import os
+from typing import Any, List, Mapping, Optional
+
+ROLE_TEMPLATES: List[str] = [
+    template.strip()
+    for template in os.environ.get("DEVSERVER_ROLE_TEMPLATES", "").split(",")
+    if template.strip()
+]
+
+
+def get_role_template(spec: Mapping[str, Any]) -> Optional[str]:
+    return spec.get("serviceAccount", {}).get("roleTemplate") or None
+
+
+def validate_user_role_template(
+    spec: Mapping[str, Any], role_templates: List[str] = ROLE_TEMPLATES
+) -> None:
+    """Raises a ValueError if the DevServer's role template is not allowed."""
+    role_template = get_role_template(spec)
+    if role_template is None or role_template in role_templates:
+        return
+    if not role_templates:
+        raise ValueError("the operator allows no role templates.")
+    raise ValueError(
+        f"'{role_template}' is not one of the allowed role templates: "
+        f"{', '.join(role_templates)}."
+    )
@@ -0,0 +1,151 @@
+"""
+Spot capacity for DevServers.
+
+`spec.capacityType` runs a DevServer on spot or on-demand nodes, which the
+operator tells apart by the node labels in `DEVSERVER_SPOT_NODE_SELECTOR` and
+`DEVSERVER_ON_DEMAND_NODE_SELECTOR`, Karpenter's by default. Spot nodes that
+are tainted, e.g. GKE's, are tolerated with `DEVSERVER_SPOT_TOLERATIONS`.
+
+With `spot-with-fallback`, a DevServer whose spot node is reclaimed is moved
+to on-demand capacity, keeping its home PVC. The capacity it runs on is
+recorded in `status.capacityType`, and it goes back to spot once hibernated.
+"""
+import asyncio
+import logging
+import os
+from typing import Any, Dict, List, Mapping, Optional
+
+from kubernetes import client
+
+CAPACITY_TYPE_SPOT = "spot"
+CAPACITY_TYPE_ON_DEMAND = "on-demand"
+CAPACITY_TYPE_SPOT_WITH_FALLBACK = "spot-with-fallback"
+
+# Why a pod is disrupted when its node goes away, as opposed to e.g. a drain
+RECLAIMED_DISRUPTION_REASONS = {"TerminationByKubelet", "DeletionByTaintManager", "DeletionByPodGC"}
+# Set on pods terminated by the kubelet's graceful node shutdown
+RECLAIMED_POD_REASONS = {"Shutdown", "NodeShutdown", "Terminated"}
+
+
+def _parse_node_selector(value: str) -> Dict[str, str]:
+    """Parses `key=value` pairs separated by commas."""
+    selector = {}
+    for item in value.split(","):
+        if item.strip():
+            key, _, label = item.strip().partition("=")
+            selector[key] = label
+    return selector
+
+
+def _parse_tolerations(value: str) -> List[Dict[str, Any]]:
+    """Parses `key[=value]:effect` taints separated by commas into tolerations."""
+    tolerations: List[Dict[str, Any]] = []
+    for item in value.split(","):
+        if not item.strip():
+            continue
+        taint, _, effect = item.strip().rpartition(":")
+        key, has_value, taint_value = taint.partition("=")
+        toleration: Dict[str, Any] = {"key": key, "operator": "Equal" if has_value else "Exists"}
+        if has_value:
+            toleration["value"] = taint_value
+        if effect:
+            toleration["effect"] = effect
+        tolerations.append(toleration)
+    return tolerations
+
+
+SPOT_NODE_SELECTOR = _parse_node_selector(
+    os.environ.get("DEVSERVER_SPOT_NODE_SELECTOR", "karpenter.sh/capacity-type=spot")
+)
+ON_DEMAND_NODE_SELECTOR = _parse_node_selector(
+    os.environ.get("DEVSERVER_ON_DEMAND_NODE_SELECTOR", "karpenter.sh/capacity-type=on-demand")
+)
+SPOT_TOLERATIONS = _parse_tolerations(os.environ.get("DEVSERVER_SPOT_TOLERATIONS", ""))
+
+
+def current_capacity_type(spec: Mapping[str, Any], status: Mapping[str, Any]) -> Optional[str]:
+    """
+    The capacity a DevServer runs on, or None if it runs wherever its flavor
+    does. A `spot-with-fallback` DevServer stays on on-demand capacity once it
+    fell back to it, until it is hibernated.
+    """
+    capacity_type = spec.get("capacityType")
+    if capacity_type != CAPACITY_TYPE_SPOT_WITH_FALLBACK:
+        return capacity_type
+    if spec.get("hibernated", False):
+        return CAPACITY_TYPE_SPOT
+    return status.get("capacityType") or CAPACITY_TYPE_SPOT
+
+
+def capacity_type_scheduling(capacity_type: Optional[str]) -> Dict[str, Any]:
+    """The node selector and tolerations that put a pod on the capacity."""
+    if capacity_type == CAPACITY_TYPE_SPOT:
+        return {"nodeSelector": dict(SPOT_NODE_SELECTOR), "tolerations": list(SPOT_TOLERATIONS)}
+    if capacity_type == CAPACITY_TYPE_ON_DEMAND:
+        return {"nodeSelector": dict(ON_DEMAND_NODE_SELECTOR), "tolerations": []}
+    return {"nodeSelector": {}, "tolerations": []}
+
+
+def validate_user_capacity_type(
+    spec: Mapping[str, Any], flavor_node_selector: Mapping[str, str]
+) -> None:
+    """
+    Check that the DevServer's flavor leaves the capacity type to it.
+
+    Raises:
+        ValueError: If the flavor's node selector already picks spot or
+            on-demand nodes.
+    """
+    if not spec.get("capacityType"):
+        return
+    pinned = sorted(
+        set(flavor_node_selector) & (set(SPOT_NODE_SELECTOR) | set(ON_DEMAND_NODE_SELECTOR))
+    )
+    if pinned:
+        raise ValueError(
+            f"the flavor's nodeSelector already sets {', '.join(pinned)}, "
+            "so spec.capacityType cannot be set."
+        )
+
+
+def spot_reclamation(pod: client.V1Pod) -> Optional[str]:
+    """Returns why the pod is gone with its reclaimed node, or None if it is not."""
+    for condition in (pod.status and pod.status.conditions) or []:
+        if (
+            condition.type == "DisruptionTarget"
+            and condition.status == "True"
+            and condition.reason in RECLAIMED_DISRUPTION_REASONS
+        ):
+            return condition.message or condition.reason
+    if pod.status and pod.status.reason in RECLAIMED_POD_REASONS:
+        return pod.status.message or pod.status.reason
+    return None
+
+
+async def find_spot_reclamation(name: str, namespace: str) -> Optional[str]:
+    """Returns why the DevServer's pod was reclaimed, or None if it was not."""
+    try:
+        pod = await asyncio.to_thread(
+            client.CoreV1Api().read_namespaced_pod, name=f"{name}-0", namespace=namespace
+        )
+    except client.ApiException as e:
+        if e.status == 404:
+            return None
+        raise
+    return spot_reclamation(pod)
+
+
+async def delete_reclaimed_pod(name: str, namespace: str, logger: logging.Logger) -> None:
+    """
+    Delete the pod of a DevServer that fell back to on-demand capacity, so
+    that the StatefulSet recreates it from its updated template instead of
+    waiting for the reclaimed one to become ready.
+    """
+    try:
+        await asyncio.to_thread(
+            client.CoreV1Api().delete_namespaced_pod, name=f"{name}-0", namespace=namespace
+        )
+    except client.ApiException as e:
+        if e.status != 404:
+            raise
+    logger.info(f"Deleted reclaimed pod '{name}-0'.")
@@ -0,0 +1,492 @@
+"""
+Status computation for DevServer resources.
+
+The DevServer phase is derived from the observed state of its StatefulSet and
+pod rather than from the success of the last reconcile, so that `Running`
+only ever means "the pod is up and ready to accept connections".
+"""
+import asyncio
+from datetime import datetime, timedelta, timezone
+from typing import Any, Dict, List, Mapping, Optional
+
+from kubernetes import client
+
+from devservers.utils.time import format_duration
+from .gateway import ssh_gateway_hostname
+from .ide import ide_status
+from .home_volume import compute_home_volume_condition, home_pvc_name
+from .kueue import QUEUE_NAME_LABEL, observe_queue
+from .lifecycle import get_expiration_time
+from .resources.services import get_mosh_ports
+
+PHASE_PENDING = "Pending"
+PHASE_RUNNING = "Running"
+PHASE_FAILED = "Failed"
+PHASE_HIBERNATED = "Hibernated"
+PHASE_WAITING_FOR_CAPACITY = "WaitingForCapacity"
+
+_MINUTE = timedelta(minutes=1)
+
+# Container waiting reasons that will not resolve without user intervention
+# (e.g. fixing the image reference). These flip the DevServer into `Failed`
+# instead of leaving it in `Pending` forever.
+FAILED_WAITING_REASONS = {
+    "ImagePullBackOff",
+    "ErrImagePull",
+    "InvalidImageName",
+    "CrashLoopBackOff",
+    "CreateContainerConfigError",
+    "CreateContainerError",
+}
+
+
+CONDITION_SCHEDULED = "Scheduled"
+CONDITION_READY = "Ready"
+
+# Reasons set on the Ready condition, in addition to container waiting
+# reasons (e.g. ImagePullBackOff) which are passed through as-is.
+REASON_STATEFULSET_NOT_FOUND = "StatefulSetNotFound"
+REASON_POD_NOT_CREATED = "PodNotCreated"
+REASON_QUOTA_EXCEEDED = "QuotaExceeded"
+REASON_FAILED_CREATE = "FailedCreate"
+REASON_UNSCHEDULABLE = "Unschedulable"
+REASON_CONTAINERS_NOT_READY = "ContainersNotReady"
+REASON_POD_READY = "PodReady"
+REASON_HIBERNATED = "Hibernated"
+REASON_WAITING_FOR_CAPACITY = "WaitingForCapacity"
+REASON_QUEUED = "Queued"
+
+
+def _condition(condition_type: str, status: bool, reason: str, message: str) -> Dict[str, Any]:
+    return {
+        "type": condition_type,
+        "status": "True" if status else "False",
+        "reason": reason,
+        "message": message,
+    }
+
+
+def _build_status(
+    phase: str,
+    reason: str,
+    message: str,
+    scheduled: Optional[Dict[str, Any]] = None,
+) -> Dict[str, Any]:
+    conditions = [scheduled] if scheduled else []
+    conditions.append(_condition(CONDITION_READY, phase == PHASE_RUNNING, reason, message))
+    return {
+        "phase": phase,
+        "ready": phase == PHASE_RUNNING,
+        "message": message,
+        "conditions": conditions,
+    }
+
+
+def waiting_for_capacity_status(message: str) -> Dict[str, Any]:
+    """The status of a new DevServer held back until its flavor has capacity."""
+    return _build_status(PHASE_WAITING_FOR_CAPACITY, REASON_WAITING_FOR_CAPACITY, message)
+
+
+def _get_pod_condition(pod: client.V1Pod, condition_type: str) -> Optional[Any]:
+    for condition in (pod.status and pod.status.conditions) or []:
+        if condition.type == condition_type:
+            return condition
+    return None
+
+
+def _container_statuses(pod: client.V1Pod) -> List[Any]:
+    if not pod.status:
+        return []
+    return (pod.status.init_container_statuses or []) + (pod.status.container_statuses or [])
+
+
+def _get_waiting_container(pod: client.V1Pod, failed_only: bool) -> Optional[Any]:
+    """Return the first (init) container in a waiting state."""
+    for container_status in _container_statuses(pod):
+        waiting = container_status.state and container_status.state.waiting
+        if waiting and waiting.reason and (
+            not failed_only or waiting.reason in FAILED_WAITING_REASONS
+        ):
+            return container_status
+    return None
+
+
+def _scheduled_condition(pod: client.V1Pod) -> Optional[Dict[str, Any]]:
+    scheduled = _get_pod_condition(pod, "PodScheduled")
+    if scheduled is None:
+        return None
+    return _condition(
+        CONDITION_SCHEDULED,
+        scheduled.status == "True",
+        scheduled.reason or ("Scheduled" if scheduled.status == "True" else "Pending"),
+        scheduled.message or "",
+    )
+
+
+def compute_devserver_status(
+    name: str,
+    statefulset: Optional[client.V1StatefulSet],
+    pod: Optional[client.V1Pod],
+    create_failure: Optional[str] = None,
+    capacity_shortage: Optional[str] = None,
+    queue: Optional[Mapping[str, Any]] = None,
+) -> Dict[str, Any]:
+    """
+    Compute the DevServer status from its StatefulSet and pod.
+
+    Besides the coarse `phase`, the status carries a `Ready` condition (and a
+    `Scheduled` condition once a pod exists) whose reason says *why* the
+    DevServer is not ready, e.g. `ImagePullBackOff`, `Unschedulable` or
+    `QuotaExceeded`, so users know whether to fix their spec or ask for
+    capacity.
+
+    Args:
+        name: Name of the DevServer
+        statefulset: The DevServer's StatefulSet, or None if it does not exist
+        pod: The DevServer's pod (`<name>-0`), or None if it does not exist
+        create_failure: Message of the StatefulSet's latest `FailedCreate`
+            event, if the pod could not be created
+        capacity_shortage: Why the StatefulSet is not created yet, if the
+            DevServer is waiting for capacity
+        queue: The DevServer's place in its Kueue queue, as returned by
+            `observe_queue`, if its pod is waiting to be scheduled
+
+    Returns:
+        A status dictionary with `phase`, `ready`, `message` and `conditions` keys.
+    """
+    pod_name = f"{name}-0"
+
+    if statefulset is None:
+        if capacity_shortage:
+            return waiting_for_capacity_status(capacity_shortage)
+        return _build_status(
+            PHASE_PENDING,
+            REASON_STATEFULSET_NOT_FOUND,
+            f"Waiting for StatefulSet '{name}' to be created.",
+        )
+
+    if statefulset.spec is not None and statefulset.spec.replicas == 0:
+        message = (
+            f"DevServer is hibernated; pod '{pod_name}' is shutting down."
+            if pod is not None
+            else "DevServer is hibernated."
+        )
+        return _build_status(PHASE_HIBERNATED, REASON_HIBERNATED, message)
+
+    if pod is None:
+        if create_failure and "exceeded quota" in create_failure:
+            return _build_status(
+                PHASE_PENDING,
+                REASON_QUOTA_EXCEEDED,
+                f"Pod '{pod_name}' cannot be created: {create_failure}",
+            )
+        if create_failure:
+            return _build_status(
+                PHASE_FAILED,
+                REASON_FAILED_CREATE,
+                f"Pod '{pod_name}' cannot be created: {create_failure}",
+            )
+        return _build_status(
+            PHASE_PENDING,
+            REASON_POD_NOT_CREATED,
+            f"Waiting for pod '{pod_name}' to be created.",
+        )
+
+    scheduled = _scheduled_condition(pod)
+    if queue is not None and not queue["admitted"]:
+        position = queue.get("positionInLocalQueue")
+        at = f" at position {position + 1}" if position is not None else ""
+        return _build_status(
+            PHASE_PENDING,
+            REASON_QUEUED,
+            f"Pod '{pod_name}' is queued in LocalQueue '{queue['queueName']}'{at}.",
+            scheduled,
+        )
+
+    if scheduled is not None and scheduled["reason"] == REASON_UNSCHEDULABLE:
+        return _build_status(
+            PHASE_PENDING,
+            REASON_UNSCHEDULABLE,
+            f"Pod '{pod_name}' is unschedulable: {scheduled['message']}",
+            scheduled,
+        )
+
+    failed_container = _get_waiting_container(pod, failed_only=True)
+    if failed_container is not None:
+        waiting = failed_container.state.waiting
+        detail = f": {waiting.message}" if waiting.message else ""
+        return _build_status(
+            PHASE_FAILED,
+            waiting.reason,
+            f"Container '{failed_container.name}' is in {waiting.reason}{detail}",
+            scheduled,
+        )
+
+    desired_replicas = (statefulset.spec and statefulset.spec.replicas) or 1
+    available_replicas = (statefulset.status and statefulset.status.available_replicas) or 0
+    pod_ready = _get_pod_condition(pod, "Ready")
+    if available_replicas >= desired_replicas and pod_ready is not None and pod_ready.status == "True":
+        return _build_status(PHASE_RUNNING, REASON_POD_READY, f"Pod '{pod_name}' is ready.", scheduled)
+
+    # Surface transient waiting reasons such as ContainerCreating or
+    # PodInitializing while the pod starts up.
+    waiting_container = _get_waiting_container(pod, failed_only=False)
+    reason = (
+        waiting_container.state.waiting.reason
+        if waiting_container is not None
+        else REASON_CONTAINERS_NOT_READY
+    )
+    return _build_status(
+        PHASE_PENDING, reason, f"Waiting for pod '{pod_name}' to become ready.", scheduled
+    )
+
+
+def set_condition_transition_times(
+    previous: Optional[List[Dict[str, Any]]],
+    conditions: List[Dict[str, Any]],
+    now: Optional[datetime] = None,
+) -> None:
+    """
+    Stamp `lastTransitionTime` on conditions, in place.
+
+    The previous transition time is kept for conditions whose status did not
+    change, so the timestamp reflects when the condition last flipped.
+    """
+    now_str = (now or datetime.now(timezone.utc)).strftime("%Y-%m-%dT%H:%M:%SZ")
+    previous_by_type = {condition["type"]: condition for condition in previous or []}
+    for condition in conditions:
+        old = previous_by_type.get(condition["type"])
+        if old is not None and old.get("status") == condition["status"] and old.get(
+            "lastTransitionTime"
+        ):
+            condition["lastTransitionTime"] = old["lastTransitionTime"]
+        else:
+            condition["lastTransitionTime"] = now_str
+
+
+def compute_ssh_endpoint(
+    service: Optional[client.V1Service], pod: Optional[client.V1Pod]
+) -> Optional[str]:
+    """
+    Compute the address users can reach the DevServer's SSH service on.
+
+    Returns:
+        A `host:port` string, or None if the endpoint is not known yet.
+    """
+    if service is None or not service.spec or not service.spec.ports:
+        return None
+
+    port = service.spec.ports[0]
+    if service.spec.type == "LoadBalancer":
+        ingress = (
+            service.status
+            and service.status.load_balancer
+            and service.status.load_balancer.ingress
+        )
+        if not ingress:
+            return None
+        host = ingress[0].hostname or ingress[0].ip
+        return f"{host}:{port.port}"
+
+    if service.spec.type == "NodePort":
+        host_ip = pod and pod.status and pod.status.host_ip
+        if not host_ip or not port.node_port:
+            return None
+        return f"{host_ip}:{port.node_port}"
+
+    return f"{service.metadata.name}.{service.metadata.namespace}.svc:{port.port}"
+
+
+def compute_ssh_config(
+    devserver: Mapping[str, Any],
+    service: Optional[client.V1Service],
+    ssh_endpoint: Optional[str],
+) -> str:
+    """
+    Render an ssh_config Host block for the DevServer.
+
+    The block connects directly when the SSH Service is reachable from outside
+    the cluster (NodePort or LoadBalancer) and through `devctl ssh-proxy`, i.e. a
+    Kubernetes port-forward, otherwise. It is meant to be appended to
+    ~/.ssh/config verbatim.
+    """
+    name = devserver["metadata"]["name"]
+    namespace = devserver["metadata"]["namespace"]
+
+    lines = [f"Host devserver-{namespace}-{name}", "    User dev"]
+    service_type = service.spec.type if service is not None and service.spec else None
+    if ssh_endpoint and service_type in ("NodePort", "LoadBalancer"):
+        host, _, port = ssh_endpoint.rpartition(":")
+        lines += [f"    HostName {host}", f"    Port {port}"]
+    else:
+        lines.append(f"    ProxyCommand devctl ssh-proxy --name {name} --namespace {namespace}")
+    lines += [
+        "    # Replace with the key matching spec.ssh.publicKey",
+        "    IdentityFile ~/.ssh/id_ed25519",
+        "    IdentitiesOnly yes",
+    ]
+    return "\n".join(lines) + "\n"
+
+
+def compute_expiration_status(
+    devserver: Mapping[str, Any], now: Optional[datetime] = None
+) -> Dict[str, Any]:
+    """
+    Compute the `expiresAt` and `expiresIn` status fields of a DevServer.
+
+    `expiresIn` is a coarse, human-readable countdown for `kubectl get`.
+    """
+    expiration_time = get_expiration_time(devserver)
+    if expiration_time is None:
+        return {"expiresAt": None, "expiresIn": None}
+
+    now = now or datetime.now(timezone.utc)
+    remaining = expiration_time - now
+    if remaining.total_seconds() <= 0:
+        expires_in = "expired"
+    elif remaining < _MINUTE:
+        expires_in = "<1m"
+    else:
+        # Truncate to whole minutes so the countdown only changes (and
+        # patches the status) once a minute.
+        expires_in = format_duration(remaining - remaining % _MINUTE)
+
+    return {
+        "expiresAt": expiration_time.strftime("%Y-%m-%dT%H:%M:%SZ"),
+        "expiresIn": expires_in,
+    }
+
+
+async def _get_create_failure(
+    core_v1: client.CoreV1Api, name: str, namespace: str, statefulset: Any
+) -> Optional[str]:
+    """
+    Return the message of the StatefulSet's most recent FailedCreate event,
+    unless it may predate the StatefulSet's current spec.
+    """
+    metadata = statefulset.metadata
+    observed_generation = statefulset.status and statefulset.status.observed_generation
+    # The controller has not tried to create a pod for the current spec yet
+    if metadata and metadata.generation and (observed_generation or 0) < metadata.generation:
+        return None
+
+    events = await asyncio.to_thread(
+        core_v1.list_namespaced_event,
+        namespace=namespace,
+        field_selector=(
+            f"involvedObject.kind=StatefulSet,involvedObject.name={name},reason=FailedCreate"
+        ),
+    )
+
+    def _timestamp(event: Any) -> datetime:
+        return (
+            event.last_timestamp
+            or event.event_time
+            or event.metadata.creation_timestamp
+            or datetime.min.replace(tzinfo=timezone.utc)
+        )
+
+    # Events outlive their object, so those of a deleted StatefulSet of the
+    # same name are not this one's
+    created_at = metadata and metadata.creation_timestamp
+    events = [
+        event for event in events.items if not created_at or _timestamp(event) >= created_at
+    ]
+    if not events:
+        return None
+    return max(events, key=_timestamp).message
+
+
+async def observe_devserver_status(devserver: Mapping[str, Any]) -> Dict[str, Any]:
+    """
+    Read the DevServer's StatefulSet, pod, home PVC and SSH Service and
+    compute its status.
+
+    Args:
+        devserver: The DevServer object
+
+    Returns:
+        A status dictionary as returned by `compute_devserver_status`, extended
+        with the SSH endpoint and ssh_config snippet, SSH gateway hostname,
+        mosh port range and expiration fields.
+    """
+    name = devserver["metadata"]["name"]
+    namespace = devserver["metadata"]["namespace"]
+    apps_v1 = client.AppsV1Api()
+    core_v1 = client.CoreV1Api()
+
+    statefulset = None
+    pod = None
+    try:
+        statefulset = await asyncio.to_thread(
+            apps_v1.read_namespaced_stateful_set, name=name, namespace=namespace
+        )
+    except client.ApiException as e:
+        if e.status != 404:
+            raise
+
+    try:
+        pod = await asyncio.to_thread(
+            core_v1.read_namespaced_pod, name=f"{name}-0", namespace=namespace
+        )
+    except client.ApiException as e:
+        if e.status != 404:
+            raise
+
+    service = None
+    if devserver["spec"].get("enableSSH", False):
+        try:
+            service = await asyncio.to_thread(
+                core_v1.read_namespaced_service, name=f"{name}-ssh", namespace=namespace
+            )
+        except client.ApiException as e:
+            if e.status != 404:
+                raise
+
+    pvc = None
+    if devserver["spec"].get("persistentHome", {}).get("enabled", False):
+        try:
+            pvc = await asyncio.to_thread(
+                core_v1.read_namespaced_persistent_volume_claim,
+                name=home_pvc_name(name),
+                namespace=namespace,
+            )
+        except client.ApiException as e:
+            if e.status != 404:
+                raise
+
+    # Only looked up until the pod is scheduled, to spare Kueue's API
+    queue = None
+    labels = (statefulset and statefulset.metadata and statefulset.metadata.labels) or {}
+    queue_name = labels.get(QUEUE_NAME_LABEL)
+    if queue_name and pod is not None and not (pod.spec and pod.spec.node_name):
+        queue = await observe_queue(name, namespace, queue_name)
+
+    create_failure = None
+    if statefulset is not None and pod is None:
+        create_failure = await _get_create_failure(core_v1, name, namespace, statefulset)
+
+    previous_status = devserver.get("status", {})
+    previous_conditions = previous_status.get("conditions")
+    # Set by the reconcile handler, which re-checks the capacity on its retries
+    capacity_shortage = None
+    if previous_status.get("phase") == PHASE_WAITING_FOR_CAPACITY:
+        capacity_shortage = previous_status.get("message")
+    status = compute_devserver_status(
+        name, statefulset, pod, create_failure, capacity_shortage, queue
+    )
+    home_volume_condition = compute_home_volume_condition(
+        devserver["spec"], pvc, previous_conditions
+    )
+    if home_volume_condition is not None:
+        status["conditions"].append(home_volume_condition)
+    set_condition_transition_times(previous_conditions, status["conditions"])
+    status["sshEndpoint"] = compute_ssh_endpoint(service, pod)
+    status["sshConfig"] = compute_ssh_config(devserver, service, status["sshEndpoint"])
+    status["sshGatewayHost"] = ssh_gateway_hostname(devserver)
+    status["ide"] = ide_status(devserver)
+    mosh_ports = get_mosh_ports(devserver["spec"])
+    status["moshPortRange"] = f"{mosh_ports[0]}:{mosh_ports[-1]}" if mosh_ports else None
+    status.update(compute_expiration_status(devserver))
+    status["queue"] = queue
+    return status
@@ -0,0 +1,204 @@
+"""
+Teardown of a DevServer's resources before its finalizer is removed.
+
+Most of a DevServer's resources are owned by it and garbage collected once it
+is gone. Teardown releases the rest, and those worth releasing first:
+in-progress backups are cancelled, the SSH Service is deleted so that its
+load balancer and any DNS records pointing at it are released, and the home
+PVC, which outlives the StatefulSet, is retained, deleted or snapshotted and
+then deleted, according to `spec.persistentHome.deletionPolicy`.
+"""
+import asyncio
+import logging
+from typing import Any, Dict, Mapping
+
+import kopf
+from kubernetes import client
+
+from .home_volume import home_pvc_name
+from ..devserverbackup.reconciler import (
+    PHASE_FAILED as BACKUP_PHASE_FAILED,
+    PHASE_PENDING as BACKUP_PHASE_PENDING,
+    PHASE_RUNNING as BACKUP_PHASE_RUNNING,
+    backup_job_name,
+)
+from ..devserversnapshot.reconciler import (
+    PHASE_FAILED as SNAPSHOT_PHASE_FAILED,
+    PHASE_READY as SNAPSHOT_PHASE_READY,
+)
+from ..events import EventRecorder
+from ...crds.const import (
+    CRD_GROUP,
+    CRD_VERSION,
+    CRD_PLURAL_DEVSERVERBACKUP,
+    CRD_PLURAL_DEVSERVERSNAPSHOT,
+)
+
+DELETION_POLICY_RETAIN = "Retain"
+DELETION_POLICY_DELETE = "Delete"
+DELETION_POLICY_SNAPSHOT = "Snapshot"
+
+# How often teardown checks whether the final snapshot is ready
+FINAL_SNAPSHOT_CHECK_INTERVAL = 10
+
+
+def final_snapshot_name(name: str, uid: str) -> str:
+    """
+    Name of the DevServerSnapshot taken before the home PVC is deleted. The
+    UID keeps it apart from those of earlier DevServers of the same name.
+    """
+    return f"{name}-final-{uid[:8]}"
+
+
+async def _delete_ignoring_missing(delete: Any, **kwargs: Any) -> bool:
+    """Delete an object, returning whether it existed."""
+    try:
+        await asyncio.to_thread(delete, **kwargs)
+    except client.ApiException as e:
+        if e.status == 404:
+            return False
+        raise
+    return True
+
+
+async def cancel_backups(name: str, namespace: str, logger: logging.Logger) -> None:
+    """Cancel the DevServer's backups that are still in progress."""
+    custom_objects_api = client.CustomObjectsApi()
+    backups = await asyncio.to_thread(
+        custom_objects_api.list_namespaced_custom_object,
+        group=CRD_GROUP,
+        version=CRD_VERSION,
+        plural=CRD_PLURAL_DEVSERVERBACKUP,
+        namespace=namespace,
+    )
+    for backup in backups["items"]:
+        phase = backup.get("status", {}).get("phase")
+        if backup["spec"].get("devServerName") != name:
+            continue
+        if phase not in (BACKUP_PHASE_PENDING, BACKUP_PHASE_RUNNING):
+            continue
+        backup_name = backup["metadata"]["name"]
+        await _delete_ignoring_missing(
+            client.BatchV1Api().delete_namespaced_job,
+            name=backup_job_name(backup_name),
+            namespace=namespace,
+            propagation_policy="Background",
+        )
+        await asyncio.to_thread(
+            custom_objects_api.patch_namespaced_custom_object_status,
+            group=CRD_GROUP,
+            version=CRD_VERSION,
+            plural=CRD_PLURAL_DEVSERVERBACKUP,
+            namespace=namespace,
+            name=backup_name,
+            body={
+                "status": {
+                    "phase": BACKUP_PHASE_FAILED,
+                    "message": f"Cancelled because DevServer '{name}' was deleted.",
+                }
+            },
+        )
+        logger.info(f"Cancelled DevServerBackup '{backup_name}'.")
+
+
+async def release_ssh_service(name: str, namespace: str, logger: logging.Logger) -> None:
+    """Delete the SSH Service, releasing its load balancer and DNS records."""
+    if await _delete_ignoring_missing(
+        client.CoreV1Api().delete_namespaced_service, name=f"{name}-ssh", namespace=namespace
+    ):
+        logger.info(f"Deleted SSH Service '{name}-ssh'.")
+
+
+async def _stop_devserver(name: str, namespace: str) -> None:
+    """Scale the StatefulSet down, so that the home volume is no longer written to."""
+    try:
+        await asyncio.to_thread(
+            client.AppsV1Api().patch_namespaced_stateful_set,
+            name=name,
+            namespace=namespace,
+            body={"spec": {"replicas": 0}},
+        )
+    except client.ApiException as e:
+        if e.status != 404:
+            raise
+
+
+async def _final_snapshot_phase(
+    name: str, namespace: str, uid: str, logger: logging.Logger
+) -> str:
+    """Take the DevServer's final snapshot, if it is not taken yet, and return its phase."""
+    custom_objects_api = client.CustomObjectsApi()
+    snapshot_name = final_snapshot_name(name, uid)
+    api_kwargs: Dict[str, Any] = {
+        "group": CRD_GROUP,
+        "version": CRD_VERSION,
+        "plural": CRD_PLURAL_DEVSERVERSNAPSHOT,
+        "namespace": namespace,
+    }
+    try:
+        snapshot = await asyncio.to_thread(
+            custom_objects_api.get_namespaced_custom_object, name=snapshot_name, **api_kwargs
+        )
+    except client.ApiException as e:
+        if e.status != 404:
+            raise
+        # Not owned by the DevServer, so that it outlives it
+        snapshot = await asyncio.to_thread(
+            custom_objects_api.create_namespaced_custom_object,
+            body={
+                "apiVersion": f"{CRD_GROUP}/{CRD_VERSION}",
+                "kind": "DevServerSnapshot",
+                "metadata": {"name": snapshot_name, "namespace": namespace},
+                "spec": {"devServerName": name},
+            },
+            **api_kwargs,
+        )
+        logger.info(f"Taking final DevServerSnapshot '{snapshot_name}'.")
+    return snapshot.get("status", {}).get("phase", "")
+
+
+async def release_home_volume(
+    name: str,
+    namespace: str,
+    spec: Mapping[str, Any],
+    meta: Mapping[str, Any],
+    logger: logging.Logger,
+    recorder: EventRecorder,
+    reference: Dict[str, Any],
+) -> None:
+    """
+    Apply the home PVC's deletion policy.
+
+    Raises:
+        kopf.TemporaryError: While the final snapshot is being taken.
+    """
+    persistent_home = spec.get("persistentHome", {})
+    policy = persistent_home.get("deletionPolicy", DELETION_POLICY_RETAIN)
+    if not persistent_home.get("enabled", False) or policy == DELETION_POLICY_RETAIN:
+        logger.warning(f"PersistentVolumeClaim '{home_pvc_name(name)}' is retained.")
+        return
+
+    if policy == DELETION_POLICY_SNAPSHOT:
+        await _stop_devserver(name, namespace)
+        phase = await _final_snapshot_phase(name, namespace, meta["uid"], logger)
+        if phase == SNAPSHOT_PHASE_FAILED:
+            # The data is not deleted without a snapshot of it
+            message = (
+                f"The final snapshot failed, so PersistentVolumeClaim "
+                f"'{home_pvc_name(name)}' is retained."
+            )
+            logger.error(message)
+            await recorder.warning(reference, "FinalSnapshotFailed", message)
+            return
+        if phase != SNAPSHOT_PHASE_READY:
+            raise kopf.TemporaryError(
+                "Waiting for the final snapshot of the home volume.",
+                delay=FINAL_SNAPSHOT_CHECK_INTERVAL,
+            )
+
+    if await _delete_ignoring_missing(
+        client.CoreV1Api().delete_namespaced_persistent_volume_claim,
+        name=home_pvc_name(name),
+        namespace=namespace,
+    ):
+        logger.info(f"Deleted PersistentVolumeClaim '{home_pvc_name(name)}'.")
@@ -0,0 +1,168 @@
+"""
+Creating DevServers from a DevServerTemplate with `spec.template`.
+
+When a DevServer with `spec.template` is created, the fields of the
+template's DevServer spec that it does not set itself are copied onto it,
+once, like with `spec.cloneFrom`, and so is the template's default flavor.
+
+The template's `spec.lifecycle` is policy rather than a default the
+DevServer may override: its `timeToLive` and `idleTimeout` are the
+DevServer's defaults and the longest it may ask for, and `maxExtensions`
+bounds how often its TTL may be extended afterwards. The limits are
+recorded in `status.lifecycleLimits` on creation, so that later changes to
+the template only apply to DevServers created after them.
+"""
+import asyncio
+import logging
+from datetime import timedelta
+from typing import Any, Dict, Mapping, Optional
+
+import kopf
+from kubernetes import client
+
+from ..events import EventRecorder
+from ...crds.const import CRD_GROUP, CRD_PLURAL_DEVSERVERTEMPLATE, CRD_VERSION
+from ...utils.time import parse_duration
+
+# Fields that belong to the DevServer itself, or that the template's own
+# lifecycle governs
+TEMPLATE_EXCLUDED_FIELDS = frozenset(
+    ["owner", "ssh", "lifecycle", "template", "cloneFrom", "homeSource", "hibernated"]
+)
+
+
+def template_fields(spec: Mapping[str, Any], template: Mapping[str, Any]) -> Dict[str, Any]:
+    """
+    The fields of the template to copy onto the DevServer, which wins on
+    conflicts, including the lifecycle defaults it does not set.
+    """
+    template_spec = template.get("spec", {})
+    fields = {
+        field: value
+        for field, value in template_spec.get("spec", {}).items()
+        if field not in TEMPLATE_EXCLUDED_FIELDS and field not in spec
+    }
+    if "flavor" not in spec and template_spec.get("defaultFlavor"):
+        fields["flavor"] = template_spec["defaultFlavor"]
+
+    lifecycle = spec.get("lifecycle", {})
+    defaults = {
+        field: value
+        for field, value in template_spec.get("lifecycle", {}).items()
+        if field in ("timeToLive", "idleTimeout") and field not in lifecycle
+    }
+    if defaults:
+        fields["lifecycle"] = {**lifecycle, **defaults}
+    return fields
+
+
+def lifecycle_limits(template: Mapping[str, Any]) -> Dict[str, Any]:
+    """The `status.lifecycleLimits` of a DevServer created from the template."""
+    lifecycle = template.get("spec", {}).get("lifecycle", {})
+    limits: Dict[str, Any] = {"template": template["metadata"]["name"]}
+    if lifecycle.get("idleTimeout"):
+        limits["idleTimeout"] = lifecycle["idleTimeout"]
+    if lifecycle.get("maxExtensions") is not None:
+        limits["extensionsRemaining"] = lifecycle["maxExtensions"]
+    return limits
+
+
+def validate_user_template_lifecycle(
+    spec: Mapping[str, Any], template: Mapping[str, Any]
+) -> None:
+    """
+    Check a new DevServer's lifecycle against its template's.
+
+    Raises:
+        ValueError: If the DevServer asks for a longer TTL or idle timeout
+            than the template, or for none when the template has one.
+    """
+    name = template["metadata"]["name"]
+    lifecycle = spec.get("lifecycle", {})
+    for field in ("timeToLive", "idleTimeout"):
+        limit = template.get("spec", {}).get("lifecycle", {}).get(field)
+        if not limit:
+            continue
+        if not lifecycle.get(field):
+            raise ValueError(f"DevServers of template '{name}' must set {field}.")
+        if parse_duration(lifecycle[field]) > parse_duration(limit):
+            raise ValueError(f"{field} cannot exceed the {limit} of template '{name}'.")
+
+
+def validate_user_lifecycle_limits(
+    spec: Mapping[str, Any], status: Mapping[str, Any]
+) -> None:
+    """
+    Check the DevServer's idle timeout, which must stay within the limit of
+    the template it was created from, if any.
+
+    Raises:
+        ValueError: If the idle timeout is not a positive duration, or was
+            removed or made longer than the template allows.
+    """
+    idle_timeout = spec.get("lifecycle", {}).get("idleTimeout")
+    if idle_timeout and parse_duration(idle_timeout) <= timedelta(0):
+        raise ValueError("idleTimeout must be a positive duration.")
+    limits = status.get("lifecycleLimits", {})
+    if not limits.get("idleTimeout"):
+        return
+    if not idle_timeout or parse_duration(idle_timeout) > parse_duration(limits["idleTimeout"]):
+        raise ValueError(
+            f"idleTimeout cannot exceed the {limits['idleTimeout']} "
+            f"of template '{limits.get('template')}'."
+        )
+
+
+def extension_limits(
+    old_ttl: Optional[str], new_ttl: Optional[str], status: Mapping[str, Any]
+) -> Optional[Dict[str, Any]]:
+    """
+    The `status.lifecycleLimits` after the TTL changed from `old_ttl` to
+    `new_ttl`, counting the change if it is an extension.
+
+    Returns:
+        None if the change does not count against any limit.
+
+    Raises:
+        ValueError: If the DevServer has no extensions left.
+    """
+    limits = status.get("lifecycleLimits", {})
+    remaining = limits.get("extensionsRemaining")
+    if remaining is None or not old_ttl or not new_ttl:
+        return None
+    if parse_duration(new_ttl) <= parse_duration(old_ttl):
+        return None
+    if remaining <= 0:
+        raise ValueError(
+            f"DevServers of template '{limits.get('template')}' cannot be extended any further."
+        )
+    return {**limits, "extensionsRemaining": remaining - 1}
+
+
+async def get_template(
+    name: str,
+    logger: logging.Logger,
+    recorder: EventRecorder,
+    reference: Dict[str, Any],
+) -> Dict[str, Any]:
+    """
+    Read the DevServerTemplate of `spec.template`.
+
+    Raises:
+        kopf.PermanentError: If the template does not exist.
+    """
+    try:
+        return await asyncio.to_thread(
+            client.CustomObjectsApi().get_cluster_custom_object,
+            group=CRD_GROUP,
+            version=CRD_VERSION,
+            plural=CRD_PLURAL_DEVSERVERTEMPLATE,
+            name=name,
+        )
+    except client.ApiException as e:
+        if e.status == 404:
+            message = f"DevServerTemplate '{name}' not found."
+            logger.error(message)
+            await recorder.warning(reference, "TemplateNotFound", message)
+            raise kopf.PermanentError(message)
+        raise
@@ -0,0 +1,164 @@
+"""
+Working hours of a DevServer with `spec.lifecycle.uptimeSchedule`.
+
+The DevServer is hibernated each time its `stop` cron schedule fires and
+resumed each time its `start` schedule fires, in its `timeZone`. The
+schedules are edge-triggered, so a DevServer resumed by hand after hours
+stays up until the next `stop`.
+
+A schedule can reference a cluster-scoped DevServerCalendar of company
+holidays, e.g. maintained once per office. `start` does not fire on its
+holidays, so a fleet stopped the evening before stays hibernated until the
+first working day after.
+"""
+import asyncio
+import logging
+from datetime import date, datetime, timezone
+from typing import Any, Dict, List, Mapping, Optional, Tuple
+from zoneinfo import ZoneInfo, ZoneInfoNotFoundError
+
+from kubernetes import client
+
+from devservers.utils.cron import CronSchedule, parse_cron
+from ...crds.const import CRD_GROUP, CRD_PLURAL_DEVSERVERCALENDAR, CRD_VERSION
+
+TIMESTAMP_FORMAT = "%Y-%m-%dT%H:%M:%SZ"
+
+# Consecutive holidays a start is pushed back over at most
+MAX_HOLIDAYS_SKIPPED = 366
+
+
+def _parse_timestamp(timestamp: str) -> datetime:
+    return datetime.strptime(timestamp, TIMESTAMP_FORMAT).replace(tzinfo=timezone.utc)
+
+
+def _time_zone(name: Optional[str]) -> Any:
+    if not name or name == "UTC":
+        return timezone.utc
+    try:
+        return ZoneInfo(name)
+    except (ZoneInfoNotFoundError, ValueError):
+        raise ValueError(f"Unknown timeZone '{name}'.")
+
+
+def validate_user_uptime_schedule(spec: Mapping[str, Any]) -> None:
+    """
+    Check the uptime schedule's cron expressions and time zone.
+
+    Raises:
+        ValueError: If they are invalid, or neither start nor stop is set.
+    """
+    schedule = spec.get("lifecycle", {}).get("uptimeSchedule")
+    if not schedule:
+        return
+    if not schedule.get("start") and not schedule.get("stop"):
+        raise ValueError("uptimeSchedule needs a start or a stop schedule.")
+    for field in ("start", "stop"):
+        if schedule.get(field):
+            parse_cron(schedule[field])
+    _time_zone(schedule.get("timeZone"))
+
+
+def holiday_name(calendar: Optional[Mapping[str, Any]], day: date) -> Optional[str]:
+    """
+    The name of the calendar's holiday on a day, "holiday" if it is unnamed.
+    Holidays are either dated, "2026-12-24", or yearly, "12-25".
+
+    Returns:
+        None if the day is no holiday.
+    """
+    if not calendar:
+        return None
+    for holiday in calendar.get("spec", {}).get("holidays", []):
+        if holiday["date"] in (day.isoformat(), day.strftime("%m-%d")):
+            return holiday.get("name") or "holiday"
+    return None
+
+
+def _next_start(
+    start: CronSchedule, after: datetime, calendar: Optional[Mapping[str, Any]]
+) -> Optional[datetime]:
+    """The first time `start` fires after `after` that is not on a holiday."""
+    due = start.next_after(after)
+    for _ in range(MAX_HOLIDAYS_SKIPPED):
+        if due is None or holiday_name(calendar, due.date()) is None:
+            return due
+        due = start.next_after(due)
+    return None
+
+
+def _format(dt: Optional[datetime]) -> Optional[str]:
+    return dt.astimezone(timezone.utc).strftime(TIMESTAMP_FORMAT) if dt else None
+
+
+def run_uptime_schedule(
+    spec: Mapping[str, Any],
+    meta: Mapping[str, Any],
+    status: Mapping[str, Any],
+    calendar: Optional[Mapping[str, Any]],
+    now: Optional[datetime] = None,
+) -> Tuple[Optional[bool], Optional[str], Dict[str, Any]]:
+    """
+    Work out whether the schedule fired since the last check. Like
+    scheduled backups, runs missed while the operator was down are not
+    caught up: only the first time each schedule fired counts.
+
+    Returns:
+        The `spec.hibernated` the DevServer should have, or None to leave
+        it, the holiday `start` was skipped on, if any, and the DevServer's
+        `status.uptimeSchedule`.
+    """
+    schedule = spec["lifecycle"]["uptimeSchedule"]
+    tz = _time_zone(schedule.get("timeZone"))
+    now = (now or datetime.now(timezone.utc)).astimezone(tz)
+    uptime_status = dict(status.get("uptimeSchedule") or {})
+    last = _parse_timestamp(
+        uptime_status.get("lastCheckTime") or meta["creationTimestamp"]
+    ).astimezone(tz)
+
+    # The latest of the schedules that fired since the last check wins
+    fired: List[Tuple[datetime, bool]] = []
+    skipped = None
+    start = parse_cron(schedule["start"]) if schedule.get("start") else None
+    stop = parse_cron(schedule["stop"]) if schedule.get("stop") else None
+    if start is not None:
+        due = start.next_after(last)
+        if due is not None and due <= now:
+            holiday = holiday_name(calendar, due.date())
+            if holiday is None:
+                fired.append((due, False))
+            else:
+                skipped = holiday
+    if stop is not None:
+        due = stop.next_after(last)
+        if due is not None and due <= now:
+            fired.append((due, True))
+    hibernated = max(fired)[1] if fired else None
+
+    uptime_status["lastCheckTime"] = _format(now)
+    uptime_status["nextStart"] = _format(_next_start(start, now, calendar)) if start else None
+    uptime_status["nextStop"] = _format(stop.next_after(now)) if stop else None
+    return hibernated, skipped, uptime_status
+
+
+async def get_calendar(name: str, logger: logging.Logger) -> Optional[Dict[str, Any]]:
+    """
+    Read the DevServerCalendar of the uptime schedule.
+
+    Returns:
+        None if the calendar does not exist, in which case the schedule
+        runs without holidays.
+    """
+    try:
+        return await asyncio.to_thread(
+            client.CustomObjectsApi().get_cluster_custom_object,
+            group=CRD_GROUP,
+            version=CRD_VERSION,
+            plural=CRD_PLURAL_DEVSERVERCALENDAR,
+            name=name,
+        )
+    except client.ApiException as e:
+        if e.status == 404:
+            logger.warning(f"DevServerCalendar '{name}' not found; ignoring holidays.")
+            return None
+        raise
@@ -1,12 +1,45 @@
 """
 Validation and normalization for DevServer resources.
 """
+import fnmatch
 import logging
 from datetime import timedelta
+from typing import Any, List, Mapping, Optional
 
 import kopf
+from kubernetes.utils import parse_quantity
 
+from devservers.crds.const import MAX_TIME_TO_LIVE
+from devservers.utils.cron import parse_cron
+from devservers.utils.flavors import get_flavor_node_selector
 from devservers.utils.time import parse_duration
+from .collaborators import validate_user_collaborators
+from .naming import NamingPolicy, validate_user_name
+from .owner_identity import OWNER_IDENTITY_ENABLED
+from .service_account import validate_user_role_template
+from .template import validate_user_lifecycle_limits, validate_user_template_lifecycle
+from .uptime import validate_user_uptime_schedule
+from .spot import validate_user_capacity_type
+from .resources.configmap import get_managed_sshd_overrides
+from .resources.affinity import validate_user_affinity
+from .resources.credentials import validate_user_credential_bundles
+from .resources.dotfiles import validate_user_dotfiles_repo
+from .resources.encryption import validate_user_home_encryption
+from .resources.node_pools import validate_user_team_node_pool
+from .resources.prewarm import validate_user_prewarm
+from .resources.pod_security import validate_user_security_profiles
+from .resources.services import MAX_MOSH_PORTS, get_mosh_ports
+from .resources.scratch import MEDIUM_MEMORY
+from .resources.statefulset import (
+    DEFAULT_DEVSERVER_IMAGE,
+    validate_user_containers,
+    validate_user_env,
+    validate_user_home_mount_path,
+    validate_user_host_access,
+    validate_user_priority_class,
+    validate_user_restricted_pod_security,
+    validate_user_volumes,
+)
 
 
 def validate_and_normalize_ttl(
@@ -24,9 +57,516 @@ def validate_and_normalize_ttl(
         duration = parse_duration(ttl_str)
         if duration <= timedelta(minutes=0):
             raise ValueError("TTL must be a positive duration.")
-        if duration > timedelta(days=7):
+        if duration > MAX_TIME_TO_LIVE:
             raise ValueError("TTL cannot exceed 7 days.")
 
     except ValueError as e:
         logger.error(f"Invalid timeToLive value '{ttl_str}': {e}")
         raise kopf.PermanentError(f"Invalid timeToLive: {e}")
+
+
+def validate_sshd_config_overrides(
+    spec: Mapping[str, Any],
+    logger: logging.Logger,
+) -> None:
+    """
+    Reject sshd_config overrides of directives the operator manages.
+    Raises a PermanentError if any are present.
+    """
+    managed = get_managed_sshd_overrides(spec)
+    if managed:
+        logger.error(f"spec.ssh.sshdConfig overrides operator-managed directives: {managed}")
+        raise kopf.PermanentError(
+            f"spec.ssh.sshdConfig cannot override {', '.join(managed)}; "
+            "use spec.ssh.port to change the port."
+        )
+
+
+def validate_mosh(
+    spec: Mapping[str, Any],
+    logger: logging.Logger,
+) -> None:
+    """
+    Validate the mosh port range.
+    Raises a PermanentError if it is invalid.
+    """
+    if not spec.get("mosh", {}).get("enabled", False):
+        return
+
+    try:
+        if not spec.get("enableSSH", False):
+            raise ValueError("mosh requires enableSSH.")
+        ports = get_mosh_ports(spec)
+        if not ports:
+            raise ValueError("portRange.end must not be lower than portRange.start.")
+        if len(ports) > MAX_MOSH_PORTS:
+            raise ValueError(f"portRange cannot span more than {MAX_MOSH_PORTS} ports.")
+
+    except ValueError as e:
+        logger.error(f"Invalid mosh configuration: {e}")
+        raise kopf.PermanentError(f"Invalid mosh configuration: {e}")
+
+
+def validate_backup(
+    spec: Mapping[str, Any],
+    logger: logging.Logger,
+) -> None:
+    """
+    Validate the backup schedule and retention policy.
+    Raises a PermanentError if they are invalid.
+    """
+    backup = spec.get("backup")
+    if not backup:
+        return
+
+    try:
+        if not spec.get("persistentHome", {}).get("enabled", False):
+            raise ValueError("backups require persistentHome.enabled.")
+        parse_cron(backup["schedule"])
+        max_age = backup.get("retention", {}).get("maxAge")
+        if max_age:
+            parse_duration(max_age)
+
+    except ValueError as e:
+        logger.error(f"Invalid backup configuration: {e}")
+        raise kopf.PermanentError(f"Invalid backup configuration: {e}")
+
+
+def validate_home_mount_path(
+    spec: Mapping[str, Any],
+    logger: logging.Logger,
+) -> None:
+    """
+    Validate the path the home directory is mounted at.
+    Raises a PermanentError if it is invalid.
+    """
+    try:
+        validate_user_home_mount_path(dict(spec))
+
+    except ValueError as e:
+        logger.error(f"Invalid homeMountPath: {e}")
+        raise kopf.PermanentError(f"Invalid homeMountPath: {e}")
+
+
+def validate_volumes(
+    spec: Mapping[str, Any],
+    logger: logging.Logger,
+) -> None:
+    """
+    Validate the user's additional volumes and mounts.
+    Raises a PermanentError if they are invalid.
+    """
+    try:
+        validate_user_volumes(dict(spec))
+
+    except ValueError as e:
+        logger.error(f"Invalid volumes: {e}")
+        raise kopf.PermanentError(f"Invalid volumes: {e}")
+
+
+def validate_credential_bundles(
+    spec: Mapping[str, Any],
+    logger: logging.Logger,
+) -> None:
+    """
+    Validate the credential bundles mounted into the DevServer.
+    Raises a PermanentError if they are invalid.
+    """
+    try:
+        validate_user_credential_bundles(spec)
+
+    except ValueError as e:
+        logger.error(f"Invalid credentialBundles: {e}")
+        raise kopf.PermanentError(f"Invalid credentialBundles: {e}")
+
+
+def validate_scratch(
+    spec: Mapping[str, Any],
+    logger: logging.Logger,
+) -> None:
+    """
+    Validate the scratch volume's size and medium.
+    Raises a PermanentError if they are invalid.
+    """
+    scratch = spec.get("scratch")
+    if not scratch:
+        return
+
+    try:
+        if parse_quantity(scratch["size"]) <= 0:
+            raise ValueError("size must be positive.")
+        if scratch.get("medium") == MEDIUM_MEMORY and scratch.get("storageClassName"):
+            raise ValueError("a Memory scratch volume cannot have a storageClassName.")
+
+    except ValueError as e:
+        logger.error(f"Invalid scratch volume: {e}")
+        raise kopf.PermanentError(f"Invalid scratch volume: {e}")
+
+
+def validate_env(
+    spec: Mapping[str, Any],
+    logger: logging.Logger,
+) -> None:
+    """
+    Validate the user's environment variables.
+    Raises a PermanentError if they are invalid.
+    """
+    try:
+        validate_user_env(dict(spec))
+
+    except ValueError as e:
+        logger.error(f"Invalid env: {e}")
+        raise kopf.PermanentError(f"Invalid env: {e}")
+
+
+def validate_containers(
+    spec: Mapping[str, Any],
+    logger: logging.Logger,
+) -> None:
+    """
+    Validate the names of the user's sidecars and init containers.
+    Raises a PermanentError if they are invalid.
+    """
+    try:
+        validate_user_containers(dict(spec))
+
+    except ValueError as e:
+        logger.error(f"Invalid containers: {e}")
+        raise kopf.PermanentError(f"Invalid containers: {e}")
+
+
+def validate_dotfiles_repo(
+    spec: Mapping[str, Any],
+    logger: logging.Logger,
+) -> None:
+    """
+    Validate the dotfiles repository the home directory is bootstrapped from.
+    Raises a PermanentError if it is invalid.
+    """
+    try:
+        validate_user_dotfiles_repo(spec)
+
+    except ValueError as e:
+        logger.error(f"Invalid dotfilesRepo: {e}")
+        raise kopf.PermanentError(f"Invalid dotfilesRepo: {e}")
+
+
+def validate_prewarm(
+    spec: Mapping[str, Any],
+    logger: logging.Logger,
+) -> None:
+    """
+    Validate the packages the DevServer's environment is prewarmed with.
+    Raises a PermanentError if they are invalid.
+    """
+    try:
+        validate_user_prewarm(spec)
+
+    except ValueError as e:
+        logger.error(f"Invalid prewarm: {e}")
+        raise kopf.PermanentError(f"Invalid prewarm: {e}")
+
+
+def validate_name(
+    name: str,
+    spec: Mapping[str, Any],
+    policy: NamingPolicy,
+    logger: logging.Logger,
+) -> None:
+    """
+    Validate the name of a new DevServer against its children's limits and
+    the operator's naming policy.
+    Raises a PermanentError if it is invalid.
+    """
+    try:
+        validate_user_name(name, spec, policy)
+
+    except ValueError as e:
+        logger.error(f"Invalid name: {e}")
+        raise kopf.PermanentError(f"Invalid name: {e}")
+
+
+def validate_collaborators(
+    spec: Mapping[str, Any],
+    logger: logging.Logger,
+) -> None:
+    """
+    Validate the people the DevServer is shared with.
+    Raises a PermanentError if any is invalid.
+    """
+    try:
+        validate_user_collaborators(spec)
+
+    except ValueError as e:
+        logger.error(f"Invalid collaborators: {e}")
+        raise kopf.PermanentError(f"Invalid collaborators: {e}")
+
+
+def validate_service_account(
+    spec: Mapping[str, Any],
+    logger: logging.Logger,
+) -> None:
+    """
+    Validate the role template the DevServer's ServiceAccount is bound to.
+    Raises a PermanentError if it is not allowed.
+    """
+    try:
+        validate_user_role_template(spec)
+
+    except ValueError as e:
+        logger.error(f"Invalid serviceAccount: {e}")
+        raise kopf.PermanentError(f"Invalid serviceAccount: {e}")
+
+
+def validate_template_lifecycle(
+    spec: Mapping[str, Any],
+    template: Mapping[str, Any],
+    logger: logging.Logger,
+) -> None:
+    """
+    Validate a new DevServer's lifecycle against its template's limits.
+    Raises a PermanentError if it exceeds them.
+    """
+    try:
+        validate_user_template_lifecycle(spec, template)
+
+    except ValueError as e:
+        logger.error(f"Invalid lifecycle: {e}")
+        raise kopf.PermanentError(f"Invalid lifecycle: {e}")
+
+
+def validate_lifecycle_limits(
+    spec: Mapping[str, Any],
+    status: Mapping[str, Any],
+    logger: logging.Logger,
+) -> None:
+    """
+    Validate the idle timeout against the limits recorded from its template.
+    Raises a PermanentError if it is invalid or exceeds them.
+    """
+    try:
+        validate_user_lifecycle_limits(spec, status)
+
+    except ValueError as e:
+        logger.error(f"Invalid lifecycle: {e}")
+        raise kopf.PermanentError(f"Invalid lifecycle: {e}")
+
+
+def validate_uptime_schedule(
+    spec: Mapping[str, Any],
+    logger: logging.Logger,
+) -> None:
+    """
+    Validate the uptime schedule's cron expressions and time zone.
+    Raises a PermanentError if they are invalid.
+    """
+    try:
+        validate_user_uptime_schedule(spec)
+
+    except ValueError as e:
+        logger.error(f"Invalid uptimeSchedule: {e}")
+        raise kopf.PermanentError(f"Invalid uptimeSchedule: {e}")
+
+
+def validate_host_access(
+    spec: Mapping[str, Any],
+    flavor: Mapping[str, Any],
+    logger: logging.Logger,
+) -> None:
+    """
+    Validate the requested host namespaces and privileged containers against
+    the flavor's policy.
+    Raises a PermanentError if they are not allowed.
+    """
+    try:
+        validate_user_host_access(dict(spec), dict(flavor), owner_identity=OWNER_IDENTITY_ENABLED)
+
+    except ValueError as e:
+        logger.error(f"Invalid host access: {e}")
+        raise kopf.PermanentError(f"Invalid host access: {e}")
+
+
+def validate_priority_class(
+    spec: Mapping[str, Any],
+    flavor: Mapping[str, Any],
+    logger: logging.Logger,
+) -> None:
+    """
+    Validate the DevServer's PriorityClass against the flavor's permitted ones.
+    Raises a PermanentError if it is not allowed.
+    """
+    try:
+        validate_user_priority_class(dict(spec), dict(flavor))
+
+    except ValueError as e:
+        logger.error(f"Invalid priority class: {e}")
+        raise kopf.PermanentError(f"Invalid priority class: {e}")
+
+
+def validate_pod_security(
+    spec: Mapping[str, Any],
+    restricted: bool,
+    logger: logging.Logger,
+) -> None:
+    """
+    Validate that the DevServer can run under the restricted Pod Security
+    Standard, when the operator generates restricted pods.
+    Raises a PermanentError if it cannot.
+    """
+    if not restricted:
+        return
+
+    try:
+        validate_user_restricted_pod_security(dict(spec))
+
+    except ValueError as e:
+        logger.error(f"Invalid pod security: {e}")
+        raise kopf.PermanentError(f"Invalid pod security: {e}")
+
+
+def validate_security_profiles(
+    flavor: Mapping[str, Any],
+    restricted: bool,
+    logger: logging.Logger,
+) -> None:
+    """
+    Validate the flavor's seccomp and AppArmor profiles.
+    Raises a PermanentError if they are invalid.
+    """
+    try:
+        validate_user_security_profiles(flavor, restricted)
+
+    except ValueError as e:
+        logger.error(f"Invalid security profiles: {e}")
+        raise kopf.PermanentError(f"Invalid security profiles: {e}")
+
+
+def validate_home_encryption(
+    spec: Mapping[str, Any],
+    flavor: Mapping[str, Any],
+    logger: logging.Logger,
+) -> None:
+    """
+    Validate that the home volume can be encrypted as the DevServer or its
+    flavor asks. Raises a PermanentError if it cannot.
+    """
+    try:
+        validate_user_home_encryption(spec, flavor)
+
+    except ValueError as e:
+        logger.error(f"Invalid home encryption: {e}")
+        raise kopf.PermanentError(f"Invalid home encryption: {e}")
+
+
+def validate_affinity(
+    spec: Mapping[str, Any],
+    flavor: Mapping[str, Any],
+    logger: logging.Logger,
+) -> None:
+    """
+    Validate that the DevServer's affinity can be satisfied together with the
+    flavor's node selector. Raises a PermanentError if it cannot.
+    """
+    try:
+        validate_user_affinity(spec, get_flavor_node_selector(dict(flavor)))
+
+    except ValueError as e:
+        logger.error(f"Invalid affinity: {e}")
+        raise kopf.PermanentError(f"Invalid affinity: {e}")
+
+
+def validate_capacity_type(
+    spec: Mapping[str, Any],
+    flavor: Mapping[str, Any],
+    logger: logging.Logger,
+) -> None:
+    """
+    Validate that the DevServer can pick spot or on-demand capacity with its
+    flavor. Raises a PermanentError if it cannot.
+    """
+    try:
+        validate_user_capacity_type(spec, get_flavor_node_selector(dict(flavor)))
+
+    except ValueError as e:
+        logger.error(f"Invalid capacity type: {e}")
+        raise kopf.PermanentError(f"Invalid capacity type: {e}")
+
+
+def validate_team_node_pool(
+    namespace: str,
+    spec: Mapping[str, Any],
+    flavor: Mapping[str, Any],
+    logger: logging.Logger,
+) -> None:
+    """
+    Validate that the DevServer can run on its team's node pool of the
+    flavor. Raises a PermanentError if it cannot.
+    """
+    try:
+        validate_user_team_node_pool(namespace, spec, dict(flavor))
+
+    except ValueError as e:
+        logger.error(f"Invalid team node pool: {e}")
+        raise kopf.PermanentError(f"Invalid team node pool: {e}")
+
+
+def validate_flavor_deprecation(
+    spec: Mapping[str, Any],
+    old_spec: Optional[Mapping[str, Any]],
+    flavor: Mapping[str, Any],
+    logger: logging.Logger,
+) -> None:
+    """
+    Validate that a new DevServer, or one switching flavors, does not use a
+    deprecated flavor. DevServers already using it keep running.
+    Raises a PermanentError if the flavor is deprecated.
+    """
+    flavor_spec = flavor.get("spec", {})
+    if not flavor_spec.get("deprecated", False):
+        return
+    if old_spec and old_spec.get("flavor") == spec["flavor"]:
+        logger.warning(f"DevServerFlavor '{spec['flavor']}' is deprecated.")
+        return
+
+    message = f"DevServerFlavor '{spec['flavor']}' is deprecated"
+    if flavor_spec.get("replacement"):
+        message += f", use '{flavor_spec['replacement']}' instead"
+    logger.error(f"Invalid flavor: {message}.")
+    raise kopf.PermanentError(f"Invalid flavor: {message}.")
+
+
+def _image_allowed(image: str, allowed_images: List[str]) -> bool:
+    for pattern in allowed_images:
+        # A bare digest allows the image it pins under any name
+        if pattern.startswith("sha256:") and image.endswith(f"@{pattern}"):
+            return True
+        # Unlike path globs, * also matches "/", i.e. any repository path below
+        if fnmatch.fnmatchcase(image, pattern):
+            return True
+    return False
+
+
+def validate_allowed_images(
+    spec: Mapping[str, Any],
+    flavor: Mapping[str, Any],
+    logger: logging.Logger,
+) -> None:
+    """
+    Validate the DevServer's images, including those of its sidecars and init
+    containers, against the flavor's `allowedImages`.
+    Raises a PermanentError if an image is not allowed.
+    """
+    allowed_images = flavor.get("spec", {}).get("allowedImages")
+    if not allowed_images:
+        return
+
+    images = [spec.get("image", DEFAULT_DEVSERVER_IMAGE)] + [
+        container["image"]
+        for container in [*spec.get("sidecars", []), *spec.get("initContainers", [])]
+        if "image" in container
+    ]
+    for image in images:
+        if not _image_allowed(image, allowed_images):
+            message = f"image '{image}' is not allowed by flavor '{spec['flavor']}'"
+            logger.error(f"Invalid image: {message}.")
+            raise kopf.PermanentError(f"Invalid image: {message}.")
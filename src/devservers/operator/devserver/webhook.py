@@ -0,0 +1,22 @@
+"""
+The operator's admission webhook server for DevServers.
+
+It serves the validating webhooks that check DevServers' PriorityClasses
+against their flavors, and their names against the naming policy, when
+`DEVSERVER_WEBHOOK_ENABLED` is true, and the mutating owner identity webhook
+when `DEVSERVER_OWNER_IDENTITY_ENABLED` is.
+"""
+import os
+
+from .owner_identity import OWNER_IDENTITY_ENABLED
+
+WEBHOOK_ENABLED = (
+    os.environ.get("DEVSERVER_WEBHOOK_ENABLED", "false").lower() == "true"
+    or OWNER_IDENTITY_ENABLED
+)
+# Where the API server reaches the webhook, e.g. the operator's Service
+WEBHOOK_HOST = os.environ.get("DEVSERVER_WEBHOOK_HOST")
+WEBHOOK_PORT = int(os.environ.get("DEVSERVER_WEBHOOK_PORT", 9443))
+# A self-signed certificate is generated if these are not set
+WEBHOOK_CERT_FILE = os.environ.get("DEVSERVER_WEBHOOK_CERT_FILE")
+WEBHOOK_KEY_FILE = os.environ.get("DEVSERVER_WEBHOOK_KEY_FILE")
@@ -0,0 +1,96 @@
+import logging
+import os
+from typing import Any, Dict
+
+import kopf
+
+from .reconciler import (
+    PHASE_COMPLETED,
+    PHASE_FAILED,
+    PHASE_PENDING,
+    PHASE_RUNNING,
+    backup_job_name,
+    create_backup_job,
+    observe_backup_status,
+    read_backup_job,
+)
+from ..backoff import with_backoff
+from ..events import EventRecorder, object_reference
+from ...crds.const import CRD_GROUP, CRD_VERSION, CRD_PLURAL_DEVSERVERBACKUP
+
+# How often a running backup's Job is checked
+BACKUP_STATUS_CHECK_INTERVAL = int(os.environ.get("DEVSERVER_BACKUP_STATUS_CHECK_INTERVAL", 10))
+
+
+def _is_in_progress(status: Dict[str, Any], **_: Any) -> bool:
+    # Completed and Failed backups never change again
+    return status.get("phase") in (PHASE_PENDING, PHASE_RUNNING)
+
+
+@kopf.on.create(CRD_GROUP, CRD_VERSION, CRD_PLURAL_DEVSERVERBACKUP)
+@with_backoff
+async def create_devserver_backup(
+    spec: Dict[str, Any],
+    name: str,
+    namespace: str,
+    body: Dict[str, Any],
+    patch: Dict[str, Any],
+    logger: logging.Logger,
+    **kwargs: Any,
+) -> None:
+    """Start a Job uploading the DevServer's home directory to object storage."""
+    recorder = EventRecorder(logger)
+    reference = object_reference(body)
+    try:
+        url = await create_backup_job(name, namespace, spec, logger)
+    except kopf.PermanentError as e:
+        logger.error(str(e))
+        await recorder.warning(reference, "BackupFailed", str(e))
+        patch["status"] = {"phase": PHASE_FAILED, "message": str(e)}
+        return
+
+    await recorder.normal(
+        reference,
+        "BackupStarted",
+        f"Backing up the home directory of DevServer '{spec['devServerName']}' to {url}.",
+    )
+    patch["status"] = {
+        "phase": PHASE_PENDING,
+        "message": "Waiting for the backup Job to start.",
+        "jobName": backup_job_name(name),
+        "url": url,
+    }
+
+
+@kopf.timer(
+    CRD_GROUP,
+    CRD_VERSION,
+    CRD_PLURAL_DEVSERVERBACKUP,
+    interval=BACKUP_STATUS_CHECK_INTERVAL,
+    when=_is_in_progress,
+)
+async def refresh_devserver_backup_status(
+    name: str,
+    namespace: str,
+    body: Dict[str, Any],
+    status: Dict[str, Any],
+    patch: Dict[str, Any],
+    logger: logging.Logger,
+    **kwargs: Any,
+) -> None:
+    """Fold the backup Job's progress into the DevServerBackup status."""
+    observed = observe_backup_status(*await read_backup_job(name, namespace))
+    changes = {key: value for key, value in observed.items() if status.get(key) != value}
+    if not changes:
+        return
+    patch["status"] = changes
+
+    recorder = EventRecorder(logger)
+    if observed["phase"] == PHASE_COMPLETED:
+        logger.info(f"DevServerBackup '{name}' completed.")
+        await recorder.normal(
+            object_reference(body), "BackupCompleted", f"Uploaded to {status.get('url')}."
+        )
+    elif observed["phase"] == PHASE_FAILED:
+        logger.error(f"DevServerBackup '{name}' failed: {observed['message']}")
+        await recorder.warning(object_reference(body), "BackupFailed", observed["message"])
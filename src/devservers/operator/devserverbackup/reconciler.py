@@ -0,0 +1,235 @@
+"""
+Backup Jobs for DevServerBackup resources.
+
+A Job mounts the DevServer's home PVC read-only and streams a tarball of it
+to S3 or GCS with rclone.
+"""
+import asyncio
+import json
+import logging
+from typing import Any, Dict, List, Optional, Tuple
+
+import kopf
+from kubernetes import client
+
+from ..devserver.home_volume import home_pvc_name
+from ..devserver.resources.object_storage import (
+    RCLONE_IMAGE,
+    RCLONE_REMOTE,
+    backup_object_path,
+    backup_object_url,
+    rclone_config,
+    rclone_volume_mounts,
+)
+from ...crds.const import CRD_GROUP, CRD_VERSION, CRD_PLURAL_DEVSERVER
+
+PHASE_PENDING = "Pending"
+PHASE_RUNNING = "Running"
+PHASE_COMPLETED = "Completed"
+PHASE_FAILED = "Failed"
+
+BACKUP_JOB_BACKOFF_LIMIT = 2
+
+# The archive's size is reported through the termination message
+BACKUP_SCRIPT = f"""
+set -euo pipefail
+tar -czf - -C /home/dev . | rclone rcat "{RCLONE_REMOTE}:$BACKUP_OBJECT_PATH"
+rclone size --json "{RCLONE_REMOTE}:$BACKUP_OBJECT_PATH" > /dev/termination-log
+"""
+
+
+def backup_job_name(name: str) -> str:
+    return f"{name}-backup"
+
+
+def build_backup_job(
+    name: str, namespace: str, spec: Dict[str, Any], node_name: Optional[str] = None
+) -> Dict[str, Any]:
+    """
+    Builds the Job uploading the DevServer's home directory.
+
+    Args:
+        node_name: The node the DevServer's pod runs on. Its ReadWriteOnce
+            home PVC can only be mounted by another pod on the same node.
+    """
+    destination = spec["destination"]
+    env, env_from, volumes = rclone_config(destination, spec.get("credentialsSecretRef"))
+    env.append({
+        "name": "BACKUP_OBJECT_PATH",
+        "value": backup_object_path(destination, namespace, spec["devServerName"], name),
+    })
+    pod_spec: Dict[str, Any] = {
+        "restartPolicy": "Never",
+        "containers": [
+            {
+                "name": "backup",
+                "image": RCLONE_IMAGE,
+                "command": ["/bin/sh", "-c"],
+                "args": [BACKUP_SCRIPT],
+                "env": env,
+                "envFrom": env_from,
+                # Files in the home directory belong to the dev user and root
+                "securityContext": {"runAsUser": 0},
+                "volumeMounts": [
+                    {"name": "home", "mountPath": "/home/dev", "readOnly": True},
+                    *rclone_volume_mounts(volumes),
+                ],
+            }
+        ],
+        "volumes": [
+            {
+                "name": "home",
+                "persistentVolumeClaim": {
+                    "claimName": home_pvc_name(spec["devServerName"]),
+                    "readOnly": True,
+                },
+            },
+            *volumes,
+        ],
+    }
+    if node_name:
+        pod_spec["nodeName"] = node_name
+    return {
+        "apiVersion": "batch/v1",
+        "kind": "Job",
+        "metadata": {"name": backup_job_name(name), "namespace": namespace},
+        "spec": {
+            "backoffLimit": BACKUP_JOB_BACKOFF_LIMIT,
+            "template": {
+                "metadata": {"labels": {"devserver.io/backup": name}},
+                "spec": pod_spec,
+            },
+        },
+    }
+
+
+async def create_backup_job(
+    name: str,
+    namespace: str,
+    spec: Dict[str, Any],
+    logger: logging.Logger,
+) -> str:
+    """
+    Create the DevServerBackup's Job, owned by it.
+
+    Returns:
+        The URL of the archive.
+
+    Raises:
+        kopf.PermanentError: If the DevServer does not exist or has no
+            persistent home to back up.
+    """
+    devserver_name = spec["devServerName"]
+    try:
+        devserver = await asyncio.to_thread(
+            client.CustomObjectsApi().get_namespaced_custom_object,
+            group=CRD_GROUP,
+            version=CRD_VERSION,
+            plural=CRD_PLURAL_DEVSERVER,
+            namespace=namespace,
+            name=devserver_name,
+        )
+    except client.ApiException as e:
+        if e.status == 404:
+            raise kopf.PermanentError(f"DevServer '{devserver_name}' not found.")
+        raise
+    if not devserver["spec"].get("persistentHome", {}).get("enabled", False):
+        raise kopf.PermanentError(
+            f"DevServer '{devserver_name}' has no persistent home volume to back up."
+        )
+
+    # A hibernated DevServer has no pod, so the Job can run anywhere
+    node_name = None
+    try:
+        pod = await asyncio.to_thread(
+            client.CoreV1Api().read_namespaced_pod, name=f"{devserver_name}-0", namespace=namespace
+        )
+        node_name = pod.spec.node_name
+    except client.ApiException as e:
+        if e.status != 404:
+            raise
+
+    job = build_backup_job(name, namespace, spec, node_name)
+    kopf.adopt(job)
+    try:
+        await asyncio.to_thread(
+            client.BatchV1Api().create_namespaced_job, namespace=namespace, body=job
+        )
+        logger.info(f"Backup Job '{job['metadata']['name']}' created.")
+    except client.ApiException as e:
+        # Created by a previous, interrupted attempt
+        if e.status != 409:
+            raise
+    path = backup_object_path(spec["destination"], namespace, devserver_name, name)
+    return backup_object_url(spec["destination"], path)
+
+
+def _archive_size(pods: List[client.V1Pod]) -> Optional[int]:
+    """The archive size reported in the termination message of the succeeded pod."""
+    for pod in pods:
+        for container_status in pod.status.container_statuses or []:
+            terminated = container_status.state.terminated
+            if terminated and terminated.exit_code == 0 and terminated.message:
+                try:
+                    return int(json.loads(terminated.message)["bytes"])
+                except (ValueError, KeyError, TypeError):
+                    return None
+    return None
+
+
+def observe_backup_status(
+    job: Optional[client.V1Job], pods: List[client.V1Pod]
+) -> Dict[str, Any]:
+    """Compute the DevServerBackup status from its Job and the Job's pods."""
+    if job is None:
+        return {"phase": PHASE_FAILED, "message": "The backup Job no longer exists."}
+
+    status = job.status
+    observed: Dict[str, Any] = {}
+    if status.start_time:
+        observed["startTime"] = status.start_time.strftime("%Y-%m-%dT%H:%M:%SZ")
+
+    failed = any(c.type == "Failed" and c.status == "True" for c in status.conditions or [])
+    if status.succeeded:
+        observed["phase"] = PHASE_COMPLETED
+        observed["message"] = "The home directory was uploaded."
+        if status.completion_time:
+            observed["completionTime"] = status.completion_time.strftime("%Y-%m-%dT%H:%M:%SZ")
+        size = _archive_size(pods)
+        if size is not None:
+            observed["sizeBytes"] = size
+    elif failed:
+        observed["phase"] = PHASE_FAILED
+        observed["message"] = (
+            f"The backup Job failed {status.failed or 0} time(s); see its pods' logs."
+        )
+    elif status.active:
+        observed["phase"] = PHASE_RUNNING
+        observed["message"] = "Uploading the home directory."
+        if status.failed:
+            observed["message"] += f" Retrying after {status.failed} failed attempt(s)."
+    else:
+        observed["phase"] = PHASE_PENDING
+        observed["message"] = "Waiting for the backup Job to start."
+    return observed
+
+
+async def read_backup_job(
+    name: str, namespace: str
+) -> Tuple[Optional[client.V1Job], List[client.V1Pod]]:
+    """Return the DevServerBackup's Job, or None if it is gone, and its pods."""
+    job_name = backup_job_name(name)
+    try:
+        job = await asyncio.to_thread(
+            client.BatchV1Api().read_namespaced_job, name=job_name, namespace=namespace
+        )
+    except client.ApiException as e:
+        if e.status == 404:
+            return None, []
+        raise
+    pods = await asyncio.to_thread(
+        client.CoreV1Api().list_namespaced_pod,
+        namespace=namespace,
+        label_selector=f"job-name={job_name}",
+    )
+    return job, pods.items
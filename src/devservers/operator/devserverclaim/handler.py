@@ -0,0 +1,141 @@
+import logging
+import os
+from datetime import datetime, timezone
+from typing import Any, Dict
+
+import kopf
+
+from .reconciler import (
+    PHASE_BOUND,
+    PHASE_FAILED,
+    PHASE_PENDING,
+    PHASE_RELEASED,
+    bind_claim,
+    format_timestamp,
+    lease_expires_at,
+    read_claimed_devserver,
+    release_claim,
+    release_reason,
+    validate_user_claim,
+)
+from ..backoff import with_backoff
+from ..events import EventRecorder, object_reference
+from ...crds.const import CRD_GROUP, CRD_VERSION, CRD_PLURAL_DEVSERVERCLAIM
+
+# How often pending claims are bound and bound ones checked for the end of their lease
+CLAIM_CHECK_INTERVAL = int(os.environ.get("DEVSERVER_CLAIM_CHECK_INTERVAL", 30))
+
+
+def _is_active(status: Dict[str, Any], **_: Any) -> bool:
+    # Released and Failed claims never change again
+    return status.get("phase") in (PHASE_PENDING, PHASE_BOUND)
+
+
+def _now() -> datetime:
+    return datetime.now(timezone.utc)
+
+
+async def _bind(
+    name: str,
+    namespace: str,
+    spec: Dict[str, Any],
+    body: Dict[str, Any],
+    logger: logging.Logger,
+) -> Dict[str, Any]:
+    """Try to bind the claim, returning its new status."""
+    recorder = EventRecorder(logger)
+    reference = object_reference(body)
+    try:
+        validate_user_claim(spec)
+        devserver_name = await bind_claim(name, namespace, spec, logger)
+    except (ValueError, kopf.PermanentError) as e:
+        logger.error(f"Invalid DevServerClaim: {e}")
+        await recorder.warning(reference, "ClaimFailed", str(e))
+        return {"phase": PHASE_FAILED, "message": str(e)}
+
+    if devserver_name is None:
+        message = f"No DevServer of pool '{spec['poolName']}' is available yet."
+        if body.get("status", {}).get("phase") != PHASE_PENDING:
+            await recorder.warning(reference, "WaitingForDevServer", message)
+        return {"phase": PHASE_PENDING, "message": message}
+
+    status = {
+        "phase": PHASE_BOUND,
+        "message": f"Bound to DevServer '{devserver_name}'.",
+        "devServerName": devserver_name,
+        "boundAt": format_timestamp(_now()),
+    }
+    status["leaseExpiresAt"] = lease_expires_at(spec, status)
+    await recorder.normal(reference, "Bound", status["message"])
+    return status
+
+
+@kopf.on.create(CRD_GROUP, CRD_VERSION, CRD_PLURAL_DEVSERVERCLAIM)
+@with_backoff
+async def create_devserver_claim(
+    spec: Dict[str, Any],
+    name: str,
+    namespace: str,
+    body: Dict[str, Any],
+    patch: Dict[str, Any],
+    logger: logging.Logger,
+    **kwargs: Any,
+) -> None:
+    """Bind a DevServer of the pool to the claim, if one is available."""
+    patch["status"] = await _bind(name, namespace, spec, body, logger)
+
+
+@kopf.timer(
+    CRD_GROUP,
+    CRD_VERSION,
+    CRD_PLURAL_DEVSERVERCLAIM,
+    interval=CLAIM_CHECK_INTERVAL,
+    when=_is_active,
+)
+async def check_devserver_claim(
+    spec: Dict[str, Any],
+    name: str,
+    namespace: str,
+    body: Dict[str, Any],
+    status: Dict[str, Any],
+    patch: Dict[str, Any],
+    logger: logging.Logger,
+    **kwargs: Any,
+) -> None:
+    """Bind a pending claim, and return the DevServer of a bound one once its lease ends."""
+    if status.get("phase") == PHASE_PENDING:
+        bound = await _bind(name, namespace, spec, body, logger)
+        if bound != {key: status.get(key) for key in bound}:
+            patch["status"] = bound
+        return
+
+    devserver_name = status["devServerName"]
+    devserver = await read_claimed_devserver(namespace, devserver_name)
+    reason = release_reason(spec, status, devserver, _now())
+    if reason is None:
+        # Follows changes to the lease duration, e.g. to extend it
+        expires_at = lease_expires_at(spec, status)
+        if status.get("leaseExpiresAt") != expires_at:
+            patch["status"] = {"leaseExpiresAt": expires_at}
+        return
+
+    await release_claim(namespace, devserver_name, logger)
+    await EventRecorder(logger).normal(object_reference(body), "Released", reason)
+    patch["status"] = {
+        "phase": PHASE_RELEASED,
+        "message": reason,
+        "releasedAt": format_timestamp(_now()),
+    }
+
+
+@kopf.on.delete(CRD_GROUP, CRD_VERSION, CRD_PLURAL_DEVSERVERCLAIM)
+@with_backoff
+async def delete_devserver_claim(
+    namespace: str,
+    status: Dict[str, Any],
+    logger: logging.Logger,
+    **kwargs: Any,
+) -> None:
+    """Return the DevServer of a claim deleted before its lease ended."""
+    if status.get("phase") == PHASE_BOUND:
+        await release_claim(namespace, status["devServerName"], logger)
@@ -0,0 +1,205 @@
+"""
+Binding DevServerClaims to pooled DevServers, and returning them.
+
+A claim names a DevServerSet with a pool. One of the pool's unclaimed
+DevServers, Running ones first, is bound to the claim: it is labelled with the
+claim, which takes it out of the pool, and gets the claim's owner and SSH
+public key, which restarts its pod as them. The binding patch carries the
+DevServer's resourceVersion, so that two claims never bind the same one.
+
+A claim holds its DevServer for `spec.leaseDuration` from binding, or until
+the DevServer agent has reported no activity for `spec.idleTimeout`, or until
+the claim is deleted. The DevServer is then deleted rather than handed on, and
+the set refills its pool with a fresh one.
+"""
+import asyncio
+import logging
+from datetime import datetime, timedelta
+from typing import Any, Dict, Mapping, Optional
+
+import kopf
+from kubernetes import client
+
+from ..devserver.status import PHASE_RUNNING
+from ..devserverset.reconciler import (
+    CLAIM_LABEL,
+    DEVSERVER_API_KWARGS,
+    POOL_LABEL,
+    list_set_devservers,
+)
+from ...crds.const import CRD_GROUP, CRD_PLURAL_DEVSERVERSET, CRD_VERSION
+from ...utils.time import parse_duration
+
+PHASE_PENDING = "Pending"
+PHASE_BOUND = "Bound"
+PHASE_RELEASED = "Released"
+PHASE_FAILED = "Failed"
+
+
+def format_timestamp(time: datetime) -> str:
+    """Format a time like the API server does."""
+    return time.strftime("%Y-%m-%dT%H:%M:%SZ")
+
+
+def lease_expires_at(spec: Mapping[str, Any], status: Mapping[str, Any]) -> str:
+    """When the lease of a bound claim expires, which follows changes to its duration."""
+    bound_at = datetime.fromisoformat(status["boundAt"])
+    return format_timestamp(bound_at + parse_duration(spec["leaseDuration"]))
+
+
+def validate_user_claim(spec: Mapping[str, Any]) -> None:
+    """
+    Check the claim's durations.
+
+    Raises:
+        ValueError: If the lease duration or idle timeout is not a positive
+            duration.
+    """
+    for field in ("leaseDuration", "idleTimeout"):
+        if field in spec and parse_duration(spec[field]) <= timedelta(0):
+            raise ValueError(f"{field} must be a positive duration.")
+
+
+async def _get_pool(
+    namespace: str, pool_name: str, custom_objects_api: client.CustomObjectsApi
+) -> Dict[str, Any]:
+    try:
+        devserver_set = await asyncio.to_thread(
+            custom_objects_api.get_namespaced_custom_object,
+            group=CRD_GROUP,
+            version=CRD_VERSION,
+            plural=CRD_PLURAL_DEVSERVERSET,
+            namespace=namespace,
+            name=pool_name,
+        )
+    except client.ApiException as e:
+        if e.status == 404:
+            raise kopf.PermanentError(f"DevServerSet '{pool_name}' not found.")
+        raise
+    if not devserver_set["spec"].get("pool", {}).get("size"):
+        raise kopf.PermanentError(f"DevServerSet '{pool_name}' has no pool.")
+    return devserver_set
+
+
+async def bind_claim(
+    name: str,
+    namespace: str,
+    spec: Mapping[str, Any],
+    logger: logging.Logger,
+    custom_objects_api: Optional[client.CustomObjectsApi] = None,
+) -> Optional[str]:
+    """
+    Bind an unclaimed DevServer of the pool to the claim, returning its name,
+    or None if the pool has none left.
+
+    Raises:
+        kopf.PermanentError: If the DevServerSet does not exist or has no pool.
+    """
+    custom_objects_api = custom_objects_api or client.CustomObjectsApi()
+    pool_name = spec["poolName"]
+    await _get_pool(namespace, pool_name, custom_objects_api)
+    candidates = [
+        ds
+        for ds in await list_set_devservers(
+            pool_name, namespace, custom_objects_api, label_selector=f"{POOL_LABEL},!{CLAIM_LABEL}"
+        )
+        if not ds["metadata"].get("deletionTimestamp")
+    ]
+    candidates.sort(
+        key=lambda ds: (
+            ds.get("status", {}).get("phase") != PHASE_RUNNING,
+            ds["metadata"]["name"],
+        )
+    )
+
+    for devserver in candidates:
+        devserver_name = devserver["metadata"]["name"]
+        body: Dict[str, Any] = {
+            "metadata": {
+                # Fails the patch if another claim bound it first
+                "resourceVersion": devserver["metadata"]["resourceVersion"],
+                "labels": {CLAIM_LABEL: name},
+            },
+            "spec": {"owner": spec["owner"]},
+        }
+        if spec.get("publicKey"):
+            body["spec"]["ssh"] = {"publicKey": spec["publicKey"]}
+        try:
+            await asyncio.to_thread(
+                custom_objects_api.patch_namespaced_custom_object,
+                namespace=namespace,
+                name=devserver_name,
+                body=body,
+                **DEVSERVER_API_KWARGS,
+            )
+        except client.ApiException as e:
+            if e.status not in (404, 409):
+                raise
+            continue
+        logger.info(f"Bound DevServer '{devserver_name}' to {spec['owner']}.")
+        return devserver_name
+    return None
+
+
+def release_reason(
+    spec: Mapping[str, Any],
+    status: Mapping[str, Any],
+    devserver: Optional[Mapping[str, Any]],
+    now: datetime,
+) -> Optional[str]:
+    """Why the bound claim's DevServer must be returned now, if it must."""
+    if devserver is None:
+        return f"DevServer '{status['devServerName']}' was deleted."
+    if now >= datetime.fromisoformat(lease_expires_at(spec, status)):
+        return f"The lease of {spec['leaseDuration']} expired."
+    idle_timeout = spec.get("idleTimeout")
+    if idle_timeout:
+        # Activity before the claim was bound is not the claimant's
+        last_activity = datetime.fromisoformat(status["boundAt"])
+        reported = devserver.get("status", {}).get("activity", {}).get("lastActivity")
+        if reported:
+            last_activity = max(last_activity, datetime.fromisoformat(reported))
+        if now - last_activity >= parse_duration(idle_timeout):
+            return f"DevServer '{status['devServerName']}' was idle for {idle_timeout}."
+    return None
+
+
+async def read_claimed_devserver(
+    namespace: str,
+    devserver_name: str,
+    custom_objects_api: Optional[client.CustomObjectsApi] = None,
+) -> Optional[Dict[str, Any]]:
+    """The claim's DevServer, or None if it is gone."""
+    custom_objects_api = custom_objects_api or client.CustomObjectsApi()
+    try:
+        return await asyncio.to_thread(
+            custom_objects_api.get_namespaced_custom_object,
+            namespace=namespace,
+            name=devserver_name,
+            **DEVSERVER_API_KWARGS,
+        )
+    except client.ApiException as e:
+        if e.status == 404:
+            return None
+        raise
+
+
+async def release_claim(
+    namespace: str,
+    devserver_name: str,
+    logger: logging.Logger,
+    custom_objects_api: Optional[client.CustomObjectsApi] = None,
+) -> None:
+    """Delete the claim's DevServer, whose pool replaces it with a fresh one."""
+    custom_objects_api = custom_objects_api or client.CustomObjectsApi()
+    try:
+        await asyncio.to_thread(
+            custom_objects_api.delete_namespaced_custom_object,
+            namespace=namespace,
+            name=devserver_name,
+            **DEVSERVER_API_KWARGS,
+        )
+    except client.ApiException as e:
+        if e.status != 404:
+            raise
+    logger.info(f"Returned DevServer '{devserver_name}'.")
@@ -3,13 +3,18 @@ from typing import Any, Dict
 
 import kopf
 
+from ..backoff import with_backoff
 from ...crds.const import CRD_GROUP, CRD_VERSION, CRD_PLURAL_DEVSERVERFLAVOR
-from ...utils.flavors import get_default_flavor
+from ...utils.flavors import get_default_flavor, resolve_base_flavors, validate_flavor_gpu
+from ..devserver.resources.pod_security import validate_user_security_profiles
+from .prepull import reconcile_prepull
 from .reconciler import DevServerFlavorReconciler
 
 
 @kopf.on.create(CRD_GROUP, CRD_VERSION, CRD_PLURAL_DEVSERVERFLAVOR)
-@kopf.on.update(CRD_GROUP, CRD_VERSION, CRD_PLURAL_DEVSERVERFLAVOR)
+# Only spec changes affect the flavor's status, which the handler updates itself
+@kopf.on.update(CRD_GROUP, CRD_VERSION, CRD_PLURAL_DEVSERVERFLAVOR, field="spec")
+@with_backoff
 async def reconcile_devserver_flavor(
     body: Dict[str, Any],
     spec: Dict[str, Any],
@@ -22,7 +27,9 @@ async def reconcile_devserver_flavor(
 
     This handler is responsible for:
     1. Ensuring there is only one default flavor.
-    2. Updating the schedulability status.
+    2. Resolving the fields it inherits from its base flavor, and validating them.
+    3. Updating the schedulability status.
+    4. Prepulling its images onto its nodes.
     """
     # 1. Ensure there is only one default flavor
     if spec.get("default", False):
@@ -35,6 +42,18 @@ async def reconcile_devserver_flavor(
             )
         logger.info(f"DevServerFlavor '{name}' is the only default flavor.")
 
-    # 2. Reconcile schedulability status
+    # 2. Resolve the base flavor, which the status is computed from, and check
+    # the security profiles and GPUs its DevServers get
+    try:
+        flavor = await resolve_base_flavors(body)
+        validate_user_security_profiles(flavor)
+        validate_flavor_gpu(flavor)
+    except ValueError as e:
+        raise kopf.PermanentError(f"Invalid DevServerFlavor '{name}': {e}")
+
+    # 3. Reconcile schedulability status
     reconciler = DevServerFlavorReconciler(logger)
-    await reconciler.reconcile_flavor(flavor=body)
+    await reconciler.reconcile_flavor(flavor=flavor)
+
+    # 4. Reconcile the DaemonSet prepulling its images
+    await reconcile_prepull(flavor, logger)
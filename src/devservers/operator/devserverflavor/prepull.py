@@ -0,0 +1,150 @@
+"""
+Prepulling of flavors' images onto their nodes.
+
+DevServer images are often several GB, which a DevServer otherwise pulls when
+its pod lands on a node. When `DEVSERVER_PREPULL_NAMESPACE` is set, every
+flavor with `spec.prepullImages` gets a `devserver-prepull-<flavor>` DaemonSet
+in that namespace, on the nodes matching the flavor's node selector and
+tolerations. Each image is an init container that exits right away, so the
+kubelet keeps it in its cache, and a pause container keeps the pod, and so the
+image, around. The DaemonSet is owned by the flavor and goes away with it.
+"""
+import asyncio
+import logging
+import os
+from dataclasses import dataclass, field
+from typing import Any, Dict, List, Mapping, Optional
+
+from kubernetes import client
+
+from ..devserver.apply import server_side_apply
+from ...crds.const import CRD_GROUP, CRD_VERSION
+from ...utils.flavors import get_flavor_node_selector
+
+DEFAULT_PAUSE_IMAGE = "registry.k8s.io/pause:3.10"
+PREPULL_LABEL = f"{CRD_GROUP}/prepull-flavor"
+
+
+def _split(value: str) -> List[str]:
+    return [item.strip() for item in value.split(",") if item.strip()]
+
+
+@dataclass(frozen=True)
+class PrepullConfig:
+    # Namespace of the DaemonSets; prepulling is disabled without one
+    namespace: Optional[str] = None
+    pause_image: str = DEFAULT_PAUSE_IMAGE
+    image_pull_secrets: List[str] = field(default_factory=list)
+
+
+def load_prepull_config(environ: Mapping[str, str] = os.environ) -> PrepullConfig:
+    """Read the prepull configuration from the environment."""
+    return PrepullConfig(
+        namespace=environ.get("DEVSERVER_PREPULL_NAMESPACE") or None,
+        pause_image=environ.get("DEVSERVER_PREPULL_PAUSE_IMAGE", DEFAULT_PAUSE_IMAGE),
+        image_pull_secrets=_split(environ.get("DEVSERVER_PREPULL_IMAGE_PULL_SECRETS", "")),
+    )
+
+
+PREPULL_CONFIG = load_prepull_config()
+
+
+def prepull_name(flavor_name: str) -> str:
+    """The name of a flavor's prepull DaemonSet."""
+    return f"devserver-prepull-{flavor_name}"
+
+
+def get_prepull_images(flavor: Mapping[str, Any]) -> List[str]:
+    """The flavor's images to prepull, without duplicates."""
+    return list(dict.fromkeys(flavor.get("spec", {}).get("prepullImages") or []))
+
+
+def build_prepull_daemonset(
+    flavor: Mapping[str, Any], config: PrepullConfig
+) -> Optional[Dict[str, Any]]:
+    """
+    Builds the DaemonSet prepulling a resolved flavor's images, or returns
+    None without any images or a namespace to put it in.
+    """
+    images = get_prepull_images(flavor)
+    if not images or not config.namespace:
+        return None
+    flavor_name = flavor["metadata"]["name"]
+    labels = {PREPULL_LABEL: flavor_name}
+    # Requests as small as possible, so that the pods fit next to DevServers
+    resources = {"requests": {"cpu": "1m", "memory": "8Mi"}}
+    pod_spec: Dict[str, Any] = {
+        "initContainers": [
+            {
+                "name": f"prepull-{i}",
+                "image": image,
+                "imagePullPolicy": "IfNotPresent",
+                "command": ["/bin/sh", "-c", "exit 0"],
+                "resources": resources,
+            }
+            for i, image in enumerate(images)
+        ],
+        "containers": [
+            {"name": "pause", "image": config.pause_image, "resources": resources}
+        ],
+        "nodeSelector": get_flavor_node_selector(dict(flavor)),
+        "tolerations": list(flavor.get("spec", {}).get("tolerations") or []),
+        "terminationGracePeriodSeconds": 0,
+    }
+    if config.image_pull_secrets:
+        pod_spec["imagePullSecrets"] = [{"name": name} for name in config.image_pull_secrets]
+    return {
+        "apiVersion": "apps/v1",
+        "kind": "DaemonSet",
+        "metadata": {
+            "name": prepull_name(flavor_name),
+            "namespace": config.namespace,
+            "labels": labels,
+            "ownerReferences": [
+                {
+                    "apiVersion": f"{CRD_GROUP}/{CRD_VERSION}",
+                    "kind": "DevServerFlavor",
+                    "name": flavor_name,
+                    "uid": flavor["metadata"]["uid"],
+                }
+            ],
+        },
+        "spec": {
+            "selector": {"matchLabels": labels},
+            # Pulls images onto a few nodes at a time, not the whole pool at once
+            "updateStrategy": {"rollingUpdate": {"maxUnavailable": "10%"}},
+            "template": {"metadata": {"labels": labels}, "spec": pod_spec},
+        },
+    }
+
+
+async def reconcile_prepull(
+    flavor: Dict[str, Any],
+    logger: logging.Logger,
+    config: PrepullConfig = PREPULL_CONFIG,
+) -> None:
+    """Apply the prepull DaemonSet of a resolved flavor, or delete it once it has no images."""
+    if not config.namespace:
+        return
+    apps_v1 = client.AppsV1Api()
+    flavor_name = flavor["metadata"]["name"]
+    daemonset = build_prepull_daemonset(flavor, config)
+    if daemonset is None:
+        try:
+            await asyncio.to_thread(
+                apps_v1.delete_namespaced_daemon_set,
+                name=prepull_name(flavor_name),
+                namespace=config.namespace,
+            )
+            logger.info(f"Removed the prepull DaemonSet of DevServerFlavor '{flavor_name}'.")
+        except client.ApiException as e:
+            if e.status != 404:
+                raise
+        return
+    await server_side_apply(
+        apps_v1.patch_namespaced_daemon_set, daemonset, namespace=config.namespace
+    )
+    logger.info(
+        f"Prepulling {len(get_prepull_images(flavor))} image(s) of DevServerFlavor "
+        f"'{flavor_name}' in namespace '{config.namespace}'."
+    )
@@ -0,0 +1,75 @@
+"""
+Right-sizing recommendations for DevServerFlavors.
+
+The operator samples the usage of each flavor's DevServers from the metrics
+API whenever flavors are reconciled, and recommends smaller requests for
+flavors whose DevServers stay well below them for the whole window.
+"""
+import os
+from collections import defaultdict, deque
+from datetime import datetime, timedelta
+from typing import Deque, Dict, Mapping, Optional, Tuple
+
+# How long usage is observed before a flavor's requests are judged
+RECOMMENDATION_WINDOW = int(os.environ.get("DEVSERVER_FLAVOR_RECOMMENDATION_WINDOW", 7 * 24 * 3600))
+# Room left above the peak usage in recommended requests
+RECOMMENDATION_HEADROOM = 1.25
+# Requests are only recommended when the peak, with headroom, is below this share of them
+OVERSIZED_THRESHOLD = 0.5
+RECOMMENDED_RESOURCES = ("cpu", "memory")
+
+
+class UsageHistory:
+    """
+    The peak usage of each flavor's DevServers over the recommendation window.
+    Samples are kept in memory, so the window starts over when the operator
+    restarts.
+    """
+
+    def __init__(self, window_seconds: int = RECOMMENDATION_WINDOW) -> None:
+        self.window = timedelta(seconds=window_seconds)
+        self._samples: Dict[str, Deque[Tuple[datetime, Dict[str, float]]]] = defaultdict(deque)
+        self._first_sample_time: Dict[str, datetime] = {}
+
+    def record(self, flavor_name: str, usage: Mapping[str, float], now: datetime) -> None:
+        """Record the peak usage of the flavor's DevServers at one point in time."""
+        samples = self._samples[flavor_name]
+        samples.append((now, dict(usage)))
+        self._first_sample_time.setdefault(flavor_name, now)
+        while samples[0][0] < now - self.window:
+            samples.popleft()
+
+    def peak(self, flavor_name: str, now: datetime) -> Optional[Dict[str, float]]:
+        """The peak usage over the window, or None until samples span all of it."""
+        first_sample_time = self._first_sample_time.get(flavor_name)
+        if first_sample_time is None or first_sample_time > now - self.window:
+            return None
+        peak: Dict[str, float] = {}
+        for sample_time, usage in self._samples[flavor_name]:
+            if sample_time < now - self.window:
+                continue
+            for resource, value in usage.items():
+                peak[resource] = max(peak.get(resource, 0.0), value)
+        return peak
+
+
+def recommend_requests(
+    requests: Mapping[str, float], peak: Mapping[str, float]
+) -> Dict[str, float]:
+    """
+    Recommend smaller requests for the resources whose peak usage, with
+    headroom, stays below OVERSIZED_THRESHOLD of the flavor's requests.
+    """
+    recommended = {}
+    for resource in RECOMMENDED_RESOURCES:
+        if resource not in requests or resource not in peak:
+            continue
+        target = peak[resource] * RECOMMENDATION_HEADROOM
+        if target < requests[resource] * OVERSIZED_THRESHOLD:
+            recommended[resource] = target
+    return recommended
+
+
+# Shared by all reconcilers, so that flavors reconciled by their handlers and
+# the periodic reconciliation add to the same history
+USAGE_HISTORY = UsageHistory()
@@ -1,10 +1,24 @@
 from __future__ import annotations
 import logging
-from typing import Any, Dict, List
+import math
+from datetime import datetime, timezone
+from typing import Any, Dict, List, Tuple
 from collections import defaultdict
 from kubernetes import client
 from kubernetes.client import V1Pod
-from ...crds.const import CRD_GROUP, CRD_VERSION, CRD_PLURAL_DEVSERVERFLAVOR
+from ..alerting import ALERTER
+from ...crds.const import CRD_GROUP, CRD_VERSION, CRD_PLURAL_DEVSERVER, CRD_PLURAL_DEVSERVERFLAVOR
+from ...utils.flavors import (
+    current_flavor_name,
+    get_flavor_gpu_resource,
+    get_flavor_node_selector,
+    get_flavor_resources,
+    resolve_flavor,
+)
+from .recommendations import USAGE_HISTORY, UsageHistory, recommend_requests
+
+# Pod metrics are keyed by the namespace and name of the pod
+PodMetrics = Dict[Tuple[str, str], Dict[str, str]]
 
 
 class DevServerFlavorReconciler:
@@ -13,10 +27,11 @@ class DevServerFlavorReconciler:
     This is not a Kopf handler, but a class that is called by the handlers.
     """
 
-    def __init__(self, logger: logging.Logger, custom_objects_api: client.CustomObjectsApi | None = None, core_v1_api: client.CoreV1Api | None = None) -> None:
+    def __init__(self, logger: logging.Logger, custom_objects_api: client.CustomObjectsApi | None = None, core_v1_api: client.CoreV1Api | None = None, usage_history: UsageHistory | None = None) -> None:
         self.logger = logger
         self.custom_objects_api = custom_objects_api if custom_objects_api is not None else client.CustomObjectsApi()
         self.core_v1_api = core_v1_api if core_v1_api is not None else client.CoreV1Api()
+        self.usage_history = usage_history if usage_history is not None else USAGE_HISTORY
 
     async def reconcile_all_flavors(self) -> None:
         """
@@ -34,16 +49,43 @@ class DevServerFlavorReconciler:
             nodepools = self._get_nodepools()
             nodes = self.core_v1_api.list_node().items
             pods = self.core_v1_api.list_pod_for_all_namespaces().items
+            devservers = self._get_devservers()
+            pod_metrics = self._get_pod_metrics()
 
+            flavors_by_name = {flavor["metadata"]["name"]: flavor for flavor in flavors.get("items", [])}
+            await self.alert_missing_flavors(flavors_by_name, devservers)
             for flavor in flavors.get("items", []):
-                await self.reconcile_flavor(flavor, nodepools, nodes, pods)
+                try:
+                    flavor = resolve_flavor(flavor, flavors_by_name)
+                except ValueError as e:
+                    self.logger.error(f"Skipping DevServerFlavor '{flavor['metadata']['name']}': {e}")
+                    continue
+                await self.reconcile_flavor(flavor, nodepools, nodes, pods, devservers, pod_metrics)
 
         except client.ApiException as e:
             self.logger.error(f"Error listing DevServerFlavors during full reconciliation: {e}")
 
-    async def reconcile_flavor(self, flavor: Dict[str, Any], nodepools: List[Dict[str, Any]] | None = None, nodes: List[client.V1Node] | None = None, pods: List[V1Pod] | None = None) -> None:
+    async def alert_missing_flavors(self, flavors_by_name: Dict[str, Any], devservers: List[Dict[str, Any]]) -> None:
+        """
+        Alert about DevServerFlavors that were deleted while DevServers still
+        use them. Only flavors DevServers were provisioned with count, so
+        that a DevServer asking for a misspelled flavor does not page anyone.
+        """
+        missing: Dict[str, List[str]] = defaultdict(list)
+        for devserver in devservers:
+            flavor_name = devserver.get("status", {}).get("flavor")
+            if flavor_name and flavor_name not in flavors_by_name:
+                metadata = devserver["metadata"]
+                missing[flavor_name].append(f"{metadata.get('namespace')}/{metadata['name']}")
+        for flavor_name, names in missing.items():
+            self.logger.error(f"DevServerFlavor '{flavor_name}' is used by {len(names)} DevServer(s) but no longer exists.")
+            await ALERTER.flavor_missing(flavor_name, names)
+
+    async def reconcile_flavor(self, flavor: Dict[str, Any], nodepools: List[Dict[str, Any]] | None = None, nodes: List[client.V1Node] | None = None, pods: List[V1Pod] | None = None, devservers: List[Dict[str, Any]] | None = None, pod_metrics: PodMetrics | None = None) -> None:
         """
-        Reconciles a single DevServerFlavor to update its schedulability status.
+        Reconciles a single DevServerFlavor to update its schedulability,
+        usage, capacity and right-sizing status. The flavor's base flavors
+        must be resolved.
         """
         flavor_name = flavor["metadata"]["name"]
         self.logger.info(f"Reconciling DevServerFlavor: {flavor_name}")
@@ -54,10 +96,32 @@ class DevServerFlavorReconciler:
             nodes = self.core_v1_api.list_node().items
         if pods is None:
             pods = self.core_v1_api.list_pod_for_all_namespaces().items
+        if devservers is None:
+            devservers = self._get_devservers()
+        if pod_metrics is None:
+            pod_metrics = self._get_pod_metrics()
 
         schedulability = self._get_flavor_schedulability(flavor, nodepools, nodes, pods)
-
-        status_patch = {"status": {"schedulable": schedulability}}
+        flavor_devservers = [
+            ds
+            for ds in devservers
+            if current_flavor_name(ds.get("spec", {}), ds.get("status", {})) == flavor_name
+        ]
+        in_use = len(flavor_devservers)
+
+        status_patch: Dict[str, Any] = {"status": {"schedulable": schedulability, "inUse": in_use}}
+        requested = self._get_flavor_requested(flavor, flavor_devservers)
+        if requested is not None:
+            status_patch["status"]["requested"] = requested
+        capacity = self._get_flavor_capacity(flavor, nodes, pods)
+        if capacity is not None:
+            status_patch["status"]["capacity"] = capacity
+        gpu_advertised = self._get_flavor_gpu_advertised(flavor, nodes)
+        if gpu_advertised is not None:
+            status_patch["status"]["gpuAdvertised"] = gpu_advertised
+        recommendation = self._get_flavor_recommendation(flavor, flavor_devservers, pod_metrics)
+        if recommendation is not None:
+            status_patch["status"]["recommendation"] = recommendation
 
         try:
             self.custom_objects_api.patch_cluster_custom_object_status(
@@ -74,6 +138,80 @@ class DevServerFlavorReconciler:
             else:
                 self.logger.error(f"Error patching DevServerFlavor '{flavor_name}': {e}")
 
+    def get_flavor_schedulability(self, flavor: Dict[str, Any]) -> str:
+        """
+        Determine if a flavor is schedulable right now, from freshly listed
+        nodes, pods and nodepools. The flavor's base flavors must be resolved.
+        """
+        nodes = self.core_v1_api.list_node().items
+        pods = self.core_v1_api.list_pod_for_all_namespaces().items
+        return self._get_flavor_schedulability(flavor, self._get_nodepools(), nodes, pods)
+
+    def _get_devservers(self) -> List[Dict[str, Any]]:
+        try:
+            return self.custom_objects_api.list_cluster_custom_object(
+                group=CRD_GROUP,
+                version=CRD_VERSION,
+                plural=CRD_PLURAL_DEVSERVER,
+            ).get("items", [])
+        except client.ApiException as e:
+            self.logger.error(f"Error listing DevServers to count flavor usage: {e}")
+            return []
+
+    def _get_pod_metrics(self) -> PodMetrics:
+        """Get the current usage of the DevServer containers of all pods."""
+        try:
+            pod_metrics = self.custom_objects_api.list_cluster_custom_object(
+                group="metrics.k8s.io",
+                version="v1beta1",
+                plural="pods",
+            )
+        except client.ApiException:
+            self.logger.info("Metrics API not found, skipping flavor right-sizing.")
+            return {}
+        usage_by_pod: PodMetrics = {}
+        for item in pod_metrics.get("items", []):
+            for container in item.get("containers", []):
+                if container.get("name") == "devserver":
+                    key = (item["metadata"]["namespace"], item["metadata"]["name"])
+                    usage_by_pod[key] = container.get("usage", {})
+        return usage_by_pod
+
+    def _get_flavor_recommendation(
+        self, flavor: Dict[str, Any], devservers: List[Dict[str, Any]], pod_metrics: PodMetrics
+    ) -> Dict[str, Any] | None:
+        """
+        Record the peak usage of the flavor's DevServers and, once it has been
+        observed for the whole window, recommend requests for the flavor.
+        Returns None until then.
+        """
+        flavor_name = flavor["metadata"]["name"]
+        now = datetime.now(timezone.utc)
+        usage: Dict[str, float] = {}
+        for ds in devservers if pod_metrics else []:
+            # A DevServer's pod is the only replica of its StatefulSet
+            pod_usage = pod_metrics.get((ds["metadata"]["namespace"], f"{ds['metadata']['name']}-0"))
+            for res_key, res_val in (pod_usage or {}).items():
+                usage[res_key] = max(usage.get(res_key, 0.0), self._parse_resource(res_val))
+        if usage:
+            self.usage_history.record(flavor_name, usage, now)
+
+        peak = self.usage_history.peak(flavor_name, now)
+        if not peak:
+            return None
+        flavor_requests = {
+            res_key: self._parse_resource(res_val)
+            for res_key, res_val in (get_flavor_resources(flavor).get("requests") or {}).items()
+        }
+        recommended = recommend_requests(flavor_requests, peak)
+        return {
+            "peakUsage": {res_key: self._format_usage(res_key, res_val) for res_key, res_val in peak.items()},
+            # Cleared once the flavor is no longer oversized
+            "requests": {
+                res_key: self._format_usage(res_key, res_val) for res_key, res_val in recommended.items()
+            } or None,
+        }
+
     def _get_nodepools(self) -> List[Dict[str, Any]]:
         try:
             return self.custom_objects_api.list_cluster_custom_object(
@@ -89,17 +227,10 @@ class DevServerFlavorReconciler:
         self, flavor: Dict[str, Any], nodepools: List[Dict[str, Any]], nodes: List[client.V1Node], pods: List[V1Pod]
     ) -> str:
         """Determine if a flavor is schedulable."""
-        node_selector = flavor.get("spec", {}).get("nodeSelector", {})
+        node_selector = get_flavor_node_selector(flavor)
 
         # Pre-calculate used resources for all nodes
-        used_resources_by_node: Dict[str, Dict[str, float]] = defaultdict(lambda: defaultdict(float))
-        for pod in pods:
-            if pod.spec.node_name and pod.status.phase in ["Running", "Pending"]:
-                for container in pod.spec.containers:
-                    if container.resources and container.resources.requests:
-                        for res_key, res_val in container.resources.requests.items():
-                            parsed_val = self._parse_resource(res_val)
-                            used_resources_by_node[pod.spec.node_name][res_key] += parsed_val
+        used_resources_by_node = self._get_used_resources_by_node(pods)
 
         # Check against Karpenter NodePools first
         for pool in nodepools:
@@ -114,7 +245,7 @@ class DevServerFlavorReconciler:
                         return "AUTOSCALED"
 
         # Check against existing nodes if no autoscaling pool matches
-        flavor_requests = flavor.get("spec", {}).get("resources", {}).get("requests", {})
+        flavor_requests = get_flavor_resources(flavor).get("requests") or {}
         if not flavor_requests:
             # If no resources are requested, it's schedulable on any node that matches selector.
             for node in nodes:
@@ -152,6 +283,88 @@ class DevServerFlavorReconciler:
 
         return "No"
 
+    def _get_used_resources_by_node(self, pods: List[V1Pod]) -> Dict[str, Dict[str, float]]:
+        """Sum the resource requests of the running and pending pods on each node."""
+        used_resources_by_node: Dict[str, Dict[str, float]] = defaultdict(lambda: defaultdict(float))
+        for pod in pods:
+            if pod.spec.node_name and pod.status.phase in ["Running", "Pending"]:
+                for container in pod.spec.containers:
+                    if container.resources and container.resources.requests:
+                        for res_key, res_val in container.resources.requests.items():
+                            parsed_val = self._parse_resource(res_val)
+                            used_resources_by_node[pod.spec.node_name][res_key] += parsed_val
+        return used_resources_by_node
+
+    def _get_flavor_capacity(
+        self, flavor: Dict[str, Any], nodes: List[client.V1Node], pods: List[V1Pod]
+    ) -> int | None:
+        """
+        Count how many more DevServers of a flavor fit on the existing nodes,
+        ignoring autoscaling. Returns None for flavors without resource requests.
+        """
+        flavor_requests = get_flavor_resources(flavor).get("requests") or {}
+        parsed_flavor_requests = {k: self._parse_resource(v) for k, v in flavor_requests.items()}
+        parsed_flavor_requests = {k: v for k, v in parsed_flavor_requests.items() if v > 0}
+        if not parsed_flavor_requests:
+            return None
+
+        node_selector = get_flavor_node_selector(flavor)
+        tolerations = flavor.get("spec", {}).get("tolerations", [])
+        used_resources_by_node = self._get_used_resources_by_node(pods)
+
+        capacity = 0
+        for node in nodes:
+            if not self._node_selector_matches(node_selector, node.metadata.labels):
+                continue
+            if not self._tolerates_all_taints(tolerations, node.spec.taints or []):
+                continue
+            allocatable = {k: self._parse_resource(v) for k, v in node.status.allocatable.items()}
+            used_resources = used_resources_by_node.get(node.metadata.name, {})
+            capacity += max(0, min(
+                int((allocatable.get(res_key, 0.0) - used_resources.get(res_key, 0.0)) // res_val)
+                for res_key, res_val in parsed_flavor_requests.items()
+            ))
+        return capacity
+
+    def _get_flavor_gpu_advertised(
+        self, flavor: Dict[str, Any], nodes: List[client.V1Node]
+    ) -> bool | None:
+        """
+        Whether any existing node matching the flavor's node selector
+        advertises the GPU resource of its `spec.gpu`, e.g. a MIG profile the
+        nodes are not partitioned into. Returns None for flavors without it.
+        """
+        resource = get_flavor_gpu_resource(flavor)
+        if resource is None:
+            return None
+        node_selector = get_flavor_node_selector(flavor)
+        for node in nodes:
+            if not self._node_selector_matches(node_selector, node.metadata.labels):
+                continue
+            if self._parse_resource((node.status.allocatable or {}).get(resource, "0")) > 0:
+                return True
+        self.logger.warning(
+            f"No node matching DevServerFlavor '{flavor['metadata']['name']}' advertises "
+            f"{resource}; its DevServers stay pending until such a node joins."
+        )
+        return False
+
+    def _get_flavor_requested(
+        self, flavor: Dict[str, Any], devservers: List[Dict[str, Any]]
+    ) -> Dict[str, str] | None:
+        """
+        Sum the resource requests of the flavor's DevServers that are not
+        hibernated. Returns None for flavors without resource requests.
+        """
+        flavor_requests = get_flavor_resources(flavor).get("requests") or {}
+        if not flavor_requests:
+            return None
+        running = sum(1 for ds in devservers if not ds.get("spec", {}).get("hibernated", False))
+        return {
+            res_key: self._format_resource(self._parse_resource(res_val) * running)
+            for res_key, res_val in flavor_requests.items()
+        }
+
     def _node_selector_matches(self, selector: Dict[str, str], labels: Dict[str, str] | None) -> bool:
         """Check if a node's labels match a node selector."""
         if not selector:
@@ -167,6 +380,12 @@ class DevServerFlavorReconciler:
 
         resource_str = str(resource_str)
 
+        # Handle CPU nanocores and microcores, as reported by the metrics API
+        if resource_str.endswith("n"):
+            return float(resource_str[:-1]) / 10**9
+        if resource_str.endswith("u"):
+            return float(resource_str[:-1]) / 10**6
+
         # Handle CPU millicores
         if resource_str.endswith("m"):
             return float(resource_str[:-1]) / 1000.0
@@ -189,6 +408,21 @@ class DevServerFlavorReconciler:
             self.logger.warning(f"Could not parse resource string: {resource_str}")
             return 0.0
 
+    def _format_resource(self, value: float) -> str:
+        """Format a numerical resource value like '1500m', '3', '12Gi'."""
+        for suffix, multiplier in (("Ti", 1024**4), ("Gi", 1024**3), ("Mi", 1024**2)):
+            if value >= multiplier and value % multiplier == 0:
+                return f"{int(value // multiplier)}{suffix}"
+        if value == int(value):
+            return str(int(value))
+        return f"{round(value * 1000)}m"
+
+    def _format_usage(self, res_key: str, value: float) -> str:
+        """Format a usage value rounded up to 10m of CPU or 1Mi of other resources."""
+        if res_key == "cpu":
+            return self._format_resource(math.ceil(value * 100) / 100)
+        return self._format_resource(math.ceil(value / 1024**2) * 1024**2)
+
     def _tolerates_all_taints(self, tolerations: List[Dict[str, str]], taints: List[client.V1Taint]) -> bool:
         """Checks if the given tolerations can tolerate all taints with NoSchedule effect."""
         if not taints:
@@ -0,0 +1,82 @@
+import logging
+import os
+from typing import Any, Dict
+
+import kopf
+
+from .reconciler import (
+    list_set_devservers,
+    observe_set_status,
+    reconcile_pool,
+    reconcile_roster,
+    validate_user_roster,
+)
+from ..backoff import with_backoff
+from ..events import EventRecorder, object_reference
+from ...crds.const import CRD_GROUP, CRD_VERSION, CRD_PLURAL_DEVSERVERSET
+
+# How often the set's pool is refilled and its status refreshed from its DevServers
+SET_STATUS_CHECK_INTERVAL = int(os.environ.get("DEVSERVER_SET_STATUS_CHECK_INTERVAL", 30))
+
+
+@kopf.on.create(CRD_GROUP, CRD_VERSION, CRD_PLURAL_DEVSERVERSET)
+@kopf.on.update(CRD_GROUP, CRD_VERSION, CRD_PLURAL_DEVSERVERSET, field="spec")
+@with_backoff
+async def reconcile_devserver_set(
+    name: str,
+    namespace: str,
+    body: Dict[str, Any],
+    patch: Dict[str, Any],
+    logger: logging.Logger,
+    **kwargs: Any,
+) -> None:
+    """Create and delete the set's DevServers to match its roster and pool."""
+    recorder = EventRecorder(logger)
+    reference = object_reference(body)
+    try:
+        validate_user_roster(body)
+    except ValueError as e:
+        logger.error(f"Invalid roster: {e}")
+        await recorder.warning(reference, "InvalidRoster", str(e))
+        raise kopf.PermanentError(f"Invalid roster: {e}")
+
+    changes = await reconcile_roster(body, logger)
+    if changes.created:
+        await recorder.normal(
+            reference,
+            "DevServersCreated",
+            f"Created {len(changes.created)} DevServer(s): {', '.join(changes.created)}.",
+        )
+    if changes.deleted:
+        await recorder.normal(
+            reference,
+            "DevServersDeleted",
+            f"Deleted {len(changes.deleted)} DevServer(s): {', '.join(changes.deleted)}.",
+        )
+    if changes.conflicts:
+        await recorder.warning(
+            reference,
+            "NameConflict",
+            f"DevServer(s) {', '.join(changes.conflicts)} already exist outside of the set.",
+        )
+    await reconcile_pool(body, logger)
+    patch["status"] = observe_set_status(body, await list_set_devservers(name, namespace))
+
+
+@kopf.timer(CRD_GROUP, CRD_VERSION, CRD_PLURAL_DEVSERVERSET, interval=SET_STATUS_CHECK_INTERVAL)
+async def refresh_devserver_set(
+    name: str,
+    namespace: str,
+    body: Dict[str, Any],
+    status: Dict[str, Any],
+    patch: Dict[str, Any],
+    logger: logging.Logger,
+    **kwargs: Any,
+) -> None:
+    """Refill the set's pool, and fold the phases of its DevServers into its status."""
+    # Claims take DevServers out of the pool at any time
+    await reconcile_pool(body, logger)
+    observed = observe_set_status(body, await list_set_devservers(name, namespace))
+    changes = {key: value for key, value in observed.items() if status.get(key) != value}
+    if changes:
+        patch["status"] = changes
@@ -0,0 +1,265 @@
+"""
+Stamping out the DevServers of a DevServerSet's roster.
+
+Each roster entry gets a `<set>-<entry>` DevServer built from the set's
+template, with the entry's owner and, if given, SSH public key. The
+DevServers are labelled with, and owned by, the set, so that deleting the set
+deletes them all. Template changes only apply to DevServers created after
+them, as some of a DevServer's fields cannot change once it exists, while
+entries removed from the roster have their DevServer deleted.
+
+With `spec.pool.size`, the set also keeps that many unowned DevServers from
+its template ready to be claimed by DevServerClaims. Claimed DevServers leave
+the pool, and are deleted rather than returned to it when their claim ends, so
+that every claim starts from a fresh home; the pool is refilled meanwhile.
+"""
+import asyncio
+import copy
+import logging
+from dataclasses import dataclass, field
+from typing import Any, Dict, Iterable, List, Mapping, Optional
+
+from kubernetes import client
+
+from ..devserver.naming import NAMING_POLICY, NamingPolicy, validate_user_name
+from ..devserver.status import PHASE_PENDING, PHASE_RUNNING
+from ...crds.const import CRD_GROUP, CRD_PLURAL_DEVSERVER, CRD_VERSION
+
+# Selects the DevServers of a DevServerSet
+SET_LABEL = f"{CRD_GROUP}/devserverset"
+# Selects the pooled DevServers of a DevServerSet, and the claimed ones
+POOL_LABEL = f"{CRD_GROUP}/pool"
+CLAIM_LABEL = f"{CRD_GROUP}/claim"
+
+DEVSERVER_API_KWARGS = {
+    "group": CRD_GROUP,
+    "version": CRD_VERSION,
+    "plural": CRD_PLURAL_DEVSERVER,
+}
+
+
+@dataclass
+class RosterChanges:
+    created: List[str] = field(default_factory=list)
+    deleted: List[str] = field(default_factory=list)
+    # Names taken by DevServers outside of the set
+    conflicts: List[str] = field(default_factory=list)
+
+
+def devserver_name(set_name: str, entry: Mapping[str, Any]) -> str:
+    """The name of a roster entry's DevServer."""
+    return f"{set_name}-{entry['name']}"
+
+
+def build_devserver(body: Mapping[str, Any], entry: Mapping[str, Any]) -> Dict[str, Any]:
+    """Builds the DevServer of a roster entry from the set's template."""
+    meta = body["metadata"]
+    template = body["spec"].get("template", {})
+    template_meta = template.get("metadata", {})
+    spec = copy.deepcopy(template.get("spec", {}))
+    spec["owner"] = entry["owner"]
+    if entry.get("publicKey"):
+        spec["ssh"] = {**spec.get("ssh", {}), "publicKey": entry["publicKey"]}
+    metadata: Dict[str, Any] = {
+        "name": devserver_name(meta["name"], entry),
+        "namespace": meta["namespace"],
+        "labels": {**template_meta.get("labels", {}), SET_LABEL: meta["name"]},
+        "ownerReferences": [
+            {
+                "apiVersion": f"{CRD_GROUP}/{CRD_VERSION}",
+                "kind": "DevServerSet",
+                "name": meta["name"],
+                "uid": meta["uid"],
+                "controller": True,
+                "blockOwnerDeletion": True,
+            }
+        ],
+    }
+    if template_meta.get("annotations"):
+        metadata["annotations"] = dict(template_meta["annotations"])
+    return {
+        "apiVersion": f"{CRD_GROUP}/{CRD_VERSION}",
+        "kind": "DevServer",
+        "metadata": metadata,
+        "spec": spec,
+    }
+
+
+def build_pool_devserver(body: Mapping[str, Any]) -> Dict[str, Any]:
+    """Builds an unowned DevServer of the set's pool, named `<set>-pool-<random>`."""
+    devserver = build_devserver(body, {"name": "pool", "owner": None})
+    devserver["spec"].pop("owner")
+    metadata = devserver["metadata"]
+    metadata["generateName"] = f"{metadata.pop('name')}-"
+    metadata["labels"][POOL_LABEL] = body["metadata"]["name"]
+    return devserver
+
+
+def validate_user_roster(
+    body: Mapping[str, Any], policy: NamingPolicy = NAMING_POLICY
+) -> None:
+    """
+    Check that every roster entry is listed once, and that the names of
+    their DevServers are valid.
+
+    Raises:
+        ValueError: If an entry is listed twice, or its DevServer's name
+            breaks the DevServer naming rules or policy.
+    """
+    seen = set()
+    for entry in body["spec"].get("roster", []):
+        if entry["name"] in seen:
+            raise ValueError(f"entry '{entry['name']}' is listed more than once.")
+        seen.add(entry["name"])
+        devserver = build_devserver(body, entry)
+        validate_user_name(devserver["metadata"]["name"], devserver["spec"], policy)
+
+
+async def list_set_devservers(
+    name: str,
+    namespace: str,
+    custom_objects_api: Optional[client.CustomObjectsApi] = None,
+    label_selector: str = "",
+) -> List[Dict[str, Any]]:
+    """The DevServers of the DevServerSet, narrowed down by the label selector."""
+    custom_objects_api = custom_objects_api or client.CustomObjectsApi()
+    devservers = await asyncio.to_thread(
+        custom_objects_api.list_namespaced_custom_object,
+        namespace=namespace,
+        label_selector=",".join(filter(None, [f"{SET_LABEL}={name}", label_selector])),
+        **DEVSERVER_API_KWARGS,
+    )
+    return devservers["items"]
+
+
+async def reconcile_roster(
+    body: Mapping[str, Any],
+    logger: logging.Logger,
+    custom_objects_api: Optional[client.CustomObjectsApi] = None,
+) -> RosterChanges:
+    """Create the DevServers of new roster entries, and delete those of removed ones."""
+    custom_objects_api = custom_objects_api or client.CustomObjectsApi()
+    name = body["metadata"]["name"]
+    namespace = body["metadata"]["namespace"]
+    desired = {
+        devserver_name(name, entry): entry for entry in body["spec"].get("roster", [])
+    }
+    existing = {
+        ds["metadata"]["name"]
+        for ds in await list_set_devservers(
+            name, namespace, custom_objects_api, label_selector=f"!{POOL_LABEL}"
+        )
+    }
+
+    changes = RosterChanges()
+    for devserver, entry in desired.items():
+        if devserver in existing:
+            continue
+        try:
+            await asyncio.to_thread(
+                custom_objects_api.create_namespaced_custom_object,
+                namespace=namespace,
+                body=build_devserver(body, entry),
+                **DEVSERVER_API_KWARGS,
+            )
+        except client.ApiException as e:
+            if e.status != 409:
+                raise
+            logger.error(f"DevServer '{devserver}' already exists outside of the set.")
+            changes.conflicts.append(devserver)
+            continue
+        logger.info(f"Created DevServer '{devserver}' for {entry['owner']}.")
+        changes.created.append(devserver)
+
+    for devserver in sorted(existing - desired.keys()):
+        try:
+            await asyncio.to_thread(
+                custom_objects_api.delete_namespaced_custom_object,
+                namespace=namespace,
+                name=devserver,
+                **DEVSERVER_API_KWARGS,
+            )
+        except client.ApiException as e:
+            if e.status != 404:
+                raise
+        logger.info(f"Deleted DevServer '{devserver}', which left the roster.")
+        changes.deleted.append(devserver)
+    return changes
+
+
+async def reconcile_pool(
+    body: Mapping[str, Any],
+    logger: logging.Logger,
+    custom_objects_api: Optional[client.CustomObjectsApi] = None,
+) -> List[str]:
+    """
+    Refill the set's pool up to its size, or shrink it down to it, returning
+    the names of the DevServers created.
+    """
+    if "pool" not in body["spec"]:
+        return []
+    size = body["spec"]["pool"].get("size", 0)
+    custom_objects_api = custom_objects_api or client.CustomObjectsApi()
+    name = body["metadata"]["name"]
+    namespace = body["metadata"]["namespace"]
+    available = [
+        ds
+        for ds in await list_set_devservers(
+            name, namespace, custom_objects_api, label_selector=f"{POOL_LABEL},!{CLAIM_LABEL}"
+        )
+        if not ds["metadata"].get("deletionTimestamp")
+    ]
+
+    for devserver in sorted(ds["metadata"]["name"] for ds in available)[size:]:
+        try:
+            await asyncio.to_thread(
+                custom_objects_api.delete_namespaced_custom_object,
+                namespace=namespace,
+                name=devserver,
+                **DEVSERVER_API_KWARGS,
+            )
+        except client.ApiException as e:
+            if e.status != 404:
+                raise
+        logger.info(f"Deleted DevServer '{devserver}', which the pool no longer needs.")
+
+    created = []
+    for _ in range(size - len(available)):
+        devserver = await asyncio.to_thread(
+            custom_objects_api.create_namespaced_custom_object,
+            namespace=namespace,
+            body=build_pool_devserver(body),
+            **DEVSERVER_API_KWARGS,
+        )
+        created.append(devserver["metadata"]["name"])
+    if created:
+        logger.info(f"Refilled the pool of DevServerSet '{name}' with {', '.join(created)}.")
+    return created
+
+
+def observe_set_status(
+    body: Mapping[str, Any], devservers: Iterable[Mapping[str, Any]]
+) -> Dict[str, Any]:
+    """The aggregate status of the set's DevServers."""
+    members = []
+    available = 0
+    for ds in sorted(devservers, key=lambda ds: ds["metadata"]["name"]):
+        labels = ds["metadata"].get("labels", {})
+        if POOL_LABEL in labels and CLAIM_LABEL not in labels:
+            available += 1
+        phase = ds.get("status", {}).get("phase", PHASE_PENDING)
+        members.append(
+            {
+                "name": ds["metadata"]["name"],
+                "owner": ds.get("spec", {}).get("owner", ""),
+                "phase": phase,
+            }
+        )
+    pool_size = body["spec"].get("pool", {}).get("size", 0)
+    return {
+        "desired": len(body["spec"].get("roster", [])) + pool_size,
+        "current": len(members),
+        "ready": sum(1 for member in members if member["phase"] == PHASE_RUNNING),
+        "available": available,
+        "devServers": members,
+    }
@@ -0,0 +1,91 @@
+import logging
+import os
+from typing import Any, Dict
+
+import kopf
+
+from .reconciler import (
+    PHASE_FAILED,
+    PHASE_PENDING,
+    PHASE_READY,
+    create_volume_snapshot,
+    observe_snapshot_status,
+    read_volume_snapshot,
+)
+from ..backoff import with_backoff
+from ..events import EventRecorder, object_reference
+from ...crds.const import CRD_GROUP, CRD_VERSION, CRD_PLURAL_DEVSERVERSNAPSHOT
+
+# How often a pending snapshot's VolumeSnapshot is checked for readiness
+SNAPSHOT_STATUS_CHECK_INTERVAL = int(os.environ.get("DEVSERVER_SNAPSHOT_STATUS_CHECK_INTERVAL", 10))
+
+
+def _is_pending(status: Dict[str, Any], **_: Any) -> bool:
+    # Ready and Failed snapshots never change again
+    return status.get("phase") == PHASE_PENDING
+
+
+@kopf.on.create(CRD_GROUP, CRD_VERSION, CRD_PLURAL_DEVSERVERSNAPSHOT)
+@with_backoff
+async def create_devserver_snapshot(
+    spec: Dict[str, Any],
+    name: str,
+    namespace: str,
+    body: Dict[str, Any],
+    patch: Dict[str, Any],
+    logger: logging.Logger,
+    **kwargs: Any,
+) -> None:
+    """Snapshot the DevServer's home volume with a CSI VolumeSnapshot."""
+    recorder = EventRecorder(logger)
+    reference = object_reference(body)
+    try:
+        await create_volume_snapshot(name, namespace, spec, logger)
+    except kopf.PermanentError as e:
+        logger.error(str(e))
+        await recorder.warning(reference, "SnapshotFailed", str(e))
+        patch["status"] = {"phase": PHASE_FAILED, "message": str(e)}
+        return
+
+    await recorder.normal(
+        reference,
+        "SnapshotCreated",
+        f"Snapshotting the home volume of DevServer '{spec['devServerName']}'.",
+    )
+    patch["status"] = {
+        "phase": PHASE_PENDING,
+        "message": "Waiting for the storage system to take the snapshot.",
+        "volumeSnapshotName": name,
+    }
+
+
+@kopf.timer(
+    CRD_GROUP,
+    CRD_VERSION,
+    CRD_PLURAL_DEVSERVERSNAPSHOT,
+    interval=SNAPSHOT_STATUS_CHECK_INTERVAL,
+    when=_is_pending,
+)
+async def refresh_devserver_snapshot_status(
+    name: str,
+    namespace: str,
+    body: Dict[str, Any],
+    status: Dict[str, Any],
+    patch: Dict[str, Any],
+    logger: logging.Logger,
+    **kwargs: Any,
+) -> None:
+    """Fold the VolumeSnapshot's readiness into the DevServerSnapshot status."""
+    observed = observe_snapshot_status(name, await read_volume_snapshot(name, namespace))
+    changes = {key: value for key, value in observed.items() if status.get(key) != value}
+    if not changes:
+        return
+    patch["status"] = changes
+
+    recorder = EventRecorder(logger)
+    if observed["phase"] == PHASE_READY:
+        logger.info(f"DevServerSnapshot '{name}' is ready.")
+        await recorder.normal(object_reference(body), "SnapshotReady", observed["message"])
+    elif observed["phase"] == PHASE_FAILED:
+        logger.error(f"DevServerSnapshot '{name}' failed: {observed['message']}")
+        await recorder.warning(object_reference(body), "SnapshotFailed", observed["message"])
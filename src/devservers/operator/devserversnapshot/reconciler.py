@@ -0,0 +1,130 @@
+"""
+CSI VolumeSnapshot management for DevServerSnapshot resources.
+"""
+import asyncio
+import logging
+from typing import Any, Dict, Optional
+
+import kopf
+from kubernetes import client
+
+from ..devserver.home_volume import home_pvc_name
+from ..devserver.resources.volume_snapshot import (
+    VOLUME_SNAPSHOT_API_GROUP,
+    VOLUME_SNAPSHOT_API_VERSION,
+    VOLUME_SNAPSHOT_PLURAL,
+    build_volume_snapshot,
+)
+from ...crds.const import CRD_GROUP, CRD_VERSION, CRD_PLURAL_DEVSERVER
+
+PHASE_PENDING = "Pending"
+PHASE_READY = "Ready"
+PHASE_FAILED = "Failed"
+
+VOLUME_SNAPSHOT_API_KWARGS = {
+    "group": VOLUME_SNAPSHOT_API_GROUP,
+    "version": VOLUME_SNAPSHOT_API_VERSION,
+    "plural": VOLUME_SNAPSHOT_PLURAL,
+}
+
+
+async def create_volume_snapshot(
+    name: str,
+    namespace: str,
+    spec: Dict[str, Any],
+    logger: logging.Logger,
+    custom_objects_api: Optional[client.CustomObjectsApi] = None,
+) -> Dict[str, Any]:
+    """
+    Create the VolumeSnapshot of the DevServer's home PVC, owned by the
+    DevServerSnapshot so that deleting it deletes the snapshot.
+
+    Raises:
+        kopf.PermanentError: If the DevServer does not exist or has no
+            persistent home to snapshot.
+    """
+    custom_objects_api = custom_objects_api or client.CustomObjectsApi()
+    devserver_name = spec["devServerName"]
+    try:
+        devserver = await asyncio.to_thread(
+            custom_objects_api.get_namespaced_custom_object,
+            group=CRD_GROUP,
+            version=CRD_VERSION,
+            plural=CRD_PLURAL_DEVSERVER,
+            namespace=namespace,
+            name=devserver_name,
+        )
+    except client.ApiException as e:
+        if e.status == 404:
+            raise kopf.PermanentError(f"DevServer '{devserver_name}' not found.")
+        raise
+    if not devserver["spec"].get("persistentHome", {}).get("enabled", False):
+        raise kopf.PermanentError(
+            f"DevServer '{devserver_name}' has no persistent home volume to snapshot."
+        )
+
+    volume_snapshot = build_volume_snapshot(
+        name, namespace, home_pvc_name(devserver_name), spec.get("volumeSnapshotClassName")
+    )
+    kopf.adopt(volume_snapshot)
+    try:
+        await asyncio.to_thread(
+            custom_objects_api.create_namespaced_custom_object,
+            namespace=namespace,
+            body=volume_snapshot,
+            **VOLUME_SNAPSHOT_API_KWARGS,
+        )
+        logger.info(f"VolumeSnapshot '{name}' of PVC '{home_pvc_name(devserver_name)}' created.")
+    except client.ApiException as e:
+        # Created by a previous, interrupted attempt
+        if e.status != 409:
+            raise
+    return volume_snapshot
+
+
+async def read_volume_snapshot(
+    name: str, namespace: str, custom_objects_api: Optional[client.CustomObjectsApi] = None
+) -> Optional[Dict[str, Any]]:
+    """Return the DevServerSnapshot's VolumeSnapshot, or None if it is gone."""
+    custom_objects_api = custom_objects_api or client.CustomObjectsApi()
+    try:
+        return await asyncio.to_thread(
+            custom_objects_api.get_namespaced_custom_object,
+            namespace=namespace,
+            name=name,
+            **VOLUME_SNAPSHOT_API_KWARGS,
+        )
+    except client.ApiException as e:
+        if e.status == 404:
+            return None
+        raise
+
+
+def observe_snapshot_status(
+    name: str, volume_snapshot: Optional[Dict[str, Any]]
+) -> Dict[str, Any]:
+    """Compute the DevServerSnapshot status from its VolumeSnapshot."""
+    if volume_snapshot is None:
+        return {
+            "phase": PHASE_FAILED,
+            "message": f"VolumeSnapshot '{name}' no longer exists.",
+        }
+
+    status = volume_snapshot.get("status") or {}
+    observed: Dict[str, Any] = {"volumeSnapshotName": name}
+    if status.get("restoreSize"):
+        observed["restoreSize"] = status["restoreSize"]
+    if status.get("creationTime"):
+        observed["snapshotTime"] = status["creationTime"]
+
+    error = status.get("error")
+    if error:
+        observed["phase"] = PHASE_FAILED
+        observed["message"] = error.get("message") or "The VolumeSnapshot failed."
+    elif status.get("readyToUse"):
+        observed["phase"] = PHASE_READY
+        observed["message"] = "The snapshot is ready to be restored."
+    else:
+        observed["phase"] = PHASE_PENDING
+        observed["message"] = "Waiting for the storage system to take the snapshot."
+    return observed
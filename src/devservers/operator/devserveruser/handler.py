@@ -4,6 +4,7 @@ from typing import Any, Dict
 import kopf
 
 from .reconciler import DevServerUserReconciler
+from ..backoff import with_backoff
 from ...crds.const import (
     CRD_GROUP,
     CRD_VERSION,
@@ -12,7 +13,10 @@ from ...crds.const import (
 
 
 @kopf.on.create(CRD_GROUP, CRD_VERSION, CRD_PLURAL_DEVSERVERUSER)
-@kopf.on.update(CRD_GROUP, CRD_VERSION, CRD_PLURAL_DEVSERVERUSER)
+@kopf.on.update(CRD_GROUP, CRD_VERSION, CRD_PLURAL_DEVSERVERUSER, field="spec")
+# Brings the Roles of existing users up to date with the operator's rules
+@kopf.on.resume(CRD_GROUP, CRD_VERSION, CRD_PLURAL_DEVSERVERUSER)
+@with_backoff
 async def reconcile_devserver_user(
     spec: Dict[str, Any],
     meta: Dict[str, Any],
@@ -35,6 +39,7 @@ async def reconcile_devserver_user(
 
 
 @kopf.on.delete(CRD_GROUP, CRD_VERSION, CRD_PLURAL_DEVSERVERUSER)
+@with_backoff
 async def delete_devserver_user(
     spec: Dict[str, Any],
     meta: Dict[str, Any],
@@ -10,7 +10,8 @@ DEFAULT_ROLE_RULES = [
     {
         "apiGroups": [CRD_GROUP],
         "resources": [CRD_PLURAL_DEVSERVER],
-        "verbs": ["get", "list", "watch", "create", "delete"],
+        # 'patch' for devctl extend, hibernate and resume
+        "verbs": ["get", "list", "watch", "create", "patch", "delete"],
     },
     # Allow viewing and debugging of core workload resources
     {
@@ -0,0 +1,99 @@
+"""
+Kubernetes Event recording for operator-managed resources.
+
+Kopf's automatic log-to-event posting is disabled in `on_startup` to keep API
+load down, so the handlers record the few events users actually care about
+(`kubectl describe devserver ...`) explicitly through an `EventRecorder`.
+"""
+import asyncio
+import logging
+import socket
+from datetime import datetime, timezone
+from typing import Any, Dict, Mapping, Optional
+
+from kubernetes import client
+
+EVENT_TYPE_NORMAL = "Normal"
+EVENT_TYPE_WARNING = "Warning"
+
+COMPONENT = "devserver-operator"
+
+# The API server rejects event messages longer than this.
+MAX_MESSAGE_LENGTH = 1024
+
+
+def object_reference(obj: Mapping[str, Any]) -> Dict[str, Any]:
+    """Build an `involvedObject` reference from a Kubernetes object."""
+    metadata = obj["metadata"]
+    return {
+        "apiVersion": obj.get("apiVersion"),
+        "kind": obj.get("kind"),
+        "name": metadata["name"],
+        "namespace": metadata.get("namespace"),
+        "uid": metadata.get("uid"),
+    }
+
+
+class EventRecorder:
+    """
+    Records core/v1 Events against a Kubernetes object.
+
+    Failing to record an event is never fatal: errors are logged and
+    swallowed so that reconciliation is not affected.
+    """
+
+    def __init__(
+        self,
+        logger: logging.Logger,
+        core_v1_api: Optional[client.CoreV1Api] = None,
+    ):
+        self.logger = logger
+        self.core_v1 = core_v1_api or client.CoreV1Api()
+
+    async def normal(self, reference: Mapping[str, Any], reason: str, message: str) -> None:
+        await self.event(reference, EVENT_TYPE_NORMAL, reason, message)
+
+    async def warning(self, reference: Mapping[str, Any], reason: str, message: str) -> None:
+        await self.event(reference, EVENT_TYPE_WARNING, reason, message)
+
+    async def event(
+        self, reference: Mapping[str, Any], event_type: str, reason: str, message: str
+    ) -> None:
+        """
+        Record an event.
+
+        Args:
+            reference: The involved object, as built by `object_reference`
+            event_type: Either "Normal" or "Warning"
+            reason: Short, CamelCase reason for the event
+            message: Human-readable description of the event
+        """
+        namespace = reference.get("namespace") or "default"
+        now = datetime.now(timezone.utc).strftime("%Y-%m-%dT%H:%M:%SZ")
+        body = {
+            "apiVersion": "v1",
+            "kind": "Event",
+            "metadata": {
+                "generateName": f"{reference['name']}.",
+                "namespace": namespace,
+            },
+            "involvedObject": dict(reference),
+            "type": event_type,
+            "reason": reason,
+            "message": message[:MAX_MESSAGE_LENGTH],
+            "source": {"component": COMPONENT},
+            "reportingComponent": COMPONENT,
+            "reportingInstance": socket.gethostname(),
+            "firstTimestamp": now,
+            "lastTimestamp": now,
+            "count": 1,
+        }
+        try:
+            await asyncio.to_thread(
+                self.core_v1.create_namespaced_event, namespace=namespace, body=body
+            )
+        except Exception as e:
+            self.logger.warning(
+                f"Failed to record {event_type} event '{reason}' for "
+                f"{reference.get('kind')} '{reference['name']}': {e}"
+            )
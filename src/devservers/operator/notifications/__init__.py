@@ -0,0 +1,167 @@
+"""
+Notifications to DevServer owners about their DevServers' lifecycle.
+
+Some of the events the operator records are also sent to the DevServer's
+owner through each configured provider: when it becomes ready, is about to
+expire, is hibernated for being idle, or fails. Providers are configured
+through the operator's environment, e.g. `DEVSERVER_SLACK_BOT_TOKEN`, and
+fallback providers such as email only reach owners no other provider did. A
+namespace can choose the events its owners are notified about with the
+`devserver.io/notifications` annotation, e.g. "Ready,ExpiringSoon", or
+"none" to turn them off.
+
+Owners are reached by email address: `spec.owner` if it is one, else the
+email of the owner's DevServerUser, else the owner at
+`DEVSERVER_NOTIFICATIONS_EMAIL_DOMAIN`. Failing to notify is never fatal.
+
+Events are also sent as webhooks to the endpoints the operator is configured
+with, see `webhook`, including the creation and deletion of DevServers,
+which owners are not notified of.
+"""
+import asyncio
+import logging
+import os
+from typing import Any, Dict, FrozenSet, Iterable, List, Mapping, NamedTuple, Optional, Protocol
+
+from kubernetes import client
+
+from .webhook import publish
+from ..devserver.owner_ids import find_owner_user
+from ...crds.const import CRD_GROUP, CRD_PLURAL_DEVSERVERUSER, CRD_VERSION
+
+# The events owners can be notified about, named like the Kubernetes events
+EVENT_READY = "Ready"
+EVENT_EXPIRING_SOON = "ExpiringSoon"
+EVENT_IDLE_HIBERNATED = "IdleHibernated"
+EVENT_FAILED = "ProvisioningFailed"
+EVENTS = frozenset([EVENT_READY, EVENT_EXPIRING_SOON, EVENT_IDLE_HIBERNATED, EVENT_FAILED])
+# Events only sent as webhooks
+EVENT_CREATED = "Created"
+EVENT_HIBERNATED = "Hibernated"
+EVENT_DELETED = "Deleting"
+
+# Namespace annotation with the comma-separated events to notify about
+NOTIFICATIONS_ANNOTATION = f"{CRD_GROUP}/notifications"
+
+# Domain of the email addresses of owners that are neither an email address
+# nor have a DevServerUser with one
+EMAIL_DOMAIN = os.environ.get("DEVSERVER_NOTIFICATIONS_EMAIL_DOMAIN", "")
+
+
+class Notification(NamedTuple):
+    """A lifecycle event of a DevServer, addressed to its owner."""
+
+    name: str
+    namespace: str
+    owner: str
+    email: Optional[str]
+    event: str
+    message: str
+
+
+class Provider(Protocol):
+    """Delivers notifications, e.g. as Slack messages."""
+
+    name: str
+    # Whether to only notify owners that no other provider reached
+    fallback: bool
+
+    async def send(self, notification: Notification, logger: logging.Logger) -> bool:
+        """Deliver a notification, returning whether its owner was reached."""
+        ...
+
+
+def _configured_providers() -> List[Provider]:
+    from .email import SMTP_HOST, EmailProvider
+    from .slack import SLACK_BOT_TOKEN, SlackProvider
+
+    providers: List[Provider] = []
+    if SLACK_BOT_TOKEN:
+        providers.append(SlackProvider(SLACK_BOT_TOKEN))
+    if SMTP_HOST:
+        providers.append(EmailProvider(SMTP_HOST))
+    return providers
+
+
+PROVIDERS: List[Provider] = _configured_providers()
+
+
+def parse_events(annotation: Optional[str]) -> FrozenSet[str]:
+    """The events a namespace's annotation selects; all of them if it has none."""
+    if annotation is None:
+        return EVENTS
+    return frozenset(
+        event.strip() for event in annotation.split(",") if event.strip() in EVENTS
+    )
+
+
+def owner_email(owner: str, users: Iterable[Dict[str, Any]]) -> Optional[str]:
+    """The email address an owner is notified at, if one is known."""
+    if "@" in owner:
+        return owner
+    user = find_owner_user(owner, users)
+    email = (user or {}).get("spec", {}).get("email")
+    if email:
+        return email
+    return f"{owner}@{EMAIL_DOMAIN}" if EMAIL_DOMAIN else None
+
+
+def _namespace_events(namespace: str) -> FrozenSet[str]:
+    try:
+        namespace_object = client.CoreV1Api().read_namespace(name=namespace)
+    except client.ApiException:
+        return EVENTS
+    annotations = namespace_object.metadata.annotations or {}
+    return parse_events(annotations.get(NOTIFICATIONS_ANNOTATION))
+
+
+async def notify(
+    devserver: Mapping[str, Any],
+    event: str,
+    message: str,
+    logger: logging.Logger,
+    providers: Optional[List[Provider]] = None,
+) -> None:
+    """
+    Send the webhooks of a DevServer's event, and notify its owner about it
+    if its namespace wants them to be.
+    """
+    try:
+        await publish(devserver, event, message, logger)
+    except Exception as e:
+        logger.warning(f"Failed to send the webhooks of {event}: {e}")
+    providers = PROVIDERS if providers is None else providers
+    owner = devserver.get("spec", {}).get("owner")
+    if event not in EVENTS or not providers or not owner:
+        return
+    metadata = devserver["metadata"]
+    try:
+        if event not in await asyncio.to_thread(_namespace_events, metadata["namespace"]):
+            return
+        users = await asyncio.to_thread(
+            client.CustomObjectsApi().list_cluster_custom_object,
+            group=CRD_GROUP,
+            version=CRD_VERSION,
+            plural=CRD_PLURAL_DEVSERVERUSER,
+        )
+    except client.ApiException as e:
+        logger.warning(f"Failed to notify the owner of DevServer '{metadata['name']}': {e}")
+        return
+    notification = Notification(
+        name=metadata["name"],
+        namespace=metadata["namespace"],
+        owner=owner,
+        email=owner_email(owner, users["items"]),
+        event=event,
+        message=message,
+    )
+    reached = False
+    for provider in sorted(providers, key=lambda provider: provider.fallback):
+        if reached and provider.fallback:
+            continue
+        try:
+            reached = await provider.send(notification, logger) or reached
+        except Exception as e:
+            logger.warning(
+                f"Failed to notify '{owner}' of {event} through {provider.name}: {e}"
+            )
@@ -0,0 +1,161 @@
+"""
+Email notifications, sent through an SMTP server.
+
+Amazon SES is used through its SMTP interface, e.g.
+`email-smtp.us-east-1.amazonaws.com` with SES SMTP credentials. Email is a
+fallback: owners already reached through another provider, e.g. Slack, are
+not emailed as well.
+
+Emails are rendered from `string.Template`s per event, with `$name`,
+`$namespace`, `$owner`, `$event`, `$message` and `$connect` (the `devctl`
+command to connect with). A template in `DEVSERVER_EMAIL_TEMPLATES_DIR`
+named after the event, e.g. `Ready.txt`, replaces the built-in one: its
+first line is the subject, the rest after a blank line is the body. Each
+recipient gets at most `DEVSERVER_EMAIL_RATE_LIMIT` emails per hour.
+"""
+import asyncio
+import collections
+import logging
+import os
+import smtplib
+import string
+import time
+from email.message import EmailMessage
+from pathlib import Path
+from typing import Callable, Deque, Dict, Tuple
+
+from . import Notification
+
+SMTP_HOST = os.environ.get("DEVSERVER_SMTP_HOST", "")
+SMTP_PORT = int(os.environ.get("DEVSERVER_SMTP_PORT", "587"))
+SMTP_USERNAME = os.environ.get("DEVSERVER_SMTP_USERNAME", "")
+SMTP_PASSWORD = os.environ.get("DEVSERVER_SMTP_PASSWORD", "")
+SMTP_STARTTLS = os.environ.get("DEVSERVER_SMTP_STARTTLS", "true").lower() == "true"
+EMAIL_FROM = os.environ.get("DEVSERVER_EMAIL_FROM", "devserver@localhost")
+EMAIL_TEMPLATES_DIR = os.environ.get("DEVSERVER_EMAIL_TEMPLATES_DIR", "")
+EMAIL_RATE_LIMIT = int(os.environ.get("DEVSERVER_EMAIL_RATE_LIMIT", "10"))
+
+RATE_LIMIT_WINDOW_SECONDS = 3600
+
+TEMPLATES: Dict[str, Tuple[str, str]] = {
+    "Ready": (
+        "Your DevServer $name is ready",
+        "Your DevServer $name in namespace $namespace is ready.\n\n"
+        "Connect with:\n\n    $connect\n",
+    ),
+    "ExpiringSoon": (
+        "Your DevServer $name is expiring soon",
+        "$message\n\nIt will be deleted then. To keep it longer, extend its time to live:\n\n"
+        "    devctl extend $name <duration> -n $namespace\n",
+    ),
+    "IdleHibernated": (
+        "Your DevServer $name was hibernated",
+        "$message\n\nIts home directory is kept. Resume it with:\n\n"
+        "    devctl resume $name -n $namespace\n",
+    ),
+    "ProvisioningFailed": (
+        "Your DevServer $name failed",
+        "Your DevServer $name in namespace $namespace failed:\n\n    $message\n\n"
+        "See `devctl status $name -n $namespace` for details.\n",
+    ),
+}
+
+
+def load_template(event: str, templates_dir: str = EMAIL_TEMPLATES_DIR) -> Tuple[str, str]:
+    """The subject and body templates of an event's emails."""
+    path = Path(templates_dir, f"{event}.txt") if templates_dir else None
+    if path is not None and path.is_file():
+        subject, _, body = path.read_text().partition("\n")
+        return subject.strip(), body.lstrip("\n")
+    return TEMPLATES.get(event, ("DevServer $name: $event", "$message\n"))
+
+
+def render(notification: Notification, templates_dir: str = EMAIL_TEMPLATES_DIR) -> EmailMessage:
+    """The email of a notification, without its sender."""
+    subject, body = load_template(notification.event, templates_dir)
+    values = {
+        **notification._asdict(),
+        "connect": f"devctl ssh {notification.name} -n {notification.namespace}",
+    }
+    message = EmailMessage()
+    message["To"] = notification.email
+    message["Subject"] = string.Template(subject).safe_substitute(values)
+    message.set_content(string.Template(body).safe_substitute(values))
+    return message
+
+
+class RateLimiter:
+    """Allows each key at most `limit` times within a sliding window."""
+
+    def __init__(
+        self,
+        limit: int,
+        window_seconds: float = RATE_LIMIT_WINDOW_SECONDS,
+        clock: Callable[[], float] = time.monotonic,
+    ) -> None:
+        self.limit = limit
+        self.window_seconds = window_seconds
+        self._clock = clock
+        self._sent: Dict[str, Deque[float]] = collections.defaultdict(collections.deque)
+
+    def allow(self, key: str) -> bool:
+        now = self._clock()
+        sent = self._sent[key]
+        while sent and sent[0] <= now - self.window_seconds:
+            sent.popleft()
+        if len(sent) >= self.limit:
+            return False
+        sent.append(now)
+        return True
+
+
+class EmailProvider:
+    """Emails notifications to their owners through an SMTP server."""
+
+    name = "email"
+    fallback = True
+
+    def __init__(
+        self,
+        host: str,
+        port: int = SMTP_PORT,
+        username: str = SMTP_USERNAME,
+        password: str = SMTP_PASSWORD,
+        starttls: bool = SMTP_STARTTLS,
+        sender: str = EMAIL_FROM,
+        templates_dir: str = EMAIL_TEMPLATES_DIR,
+        rate_limit: int = EMAIL_RATE_LIMIT,
+    ):
+        self.host = host
+        self.port = port
+        self.username = username
+        self.password = password
+        self.starttls = starttls
+        self.sender = sender
+        self.templates_dir = templates_dir
+        self.rate_limiter = RateLimiter(rate_limit)
+
+    def _send_message(self, message: EmailMessage) -> None:
+        with smtplib.SMTP(self.host, self.port, timeout=30) as smtp:
+            if self.starttls:
+                smtp.starttls()
+            if self.username:
+                smtp.login(self.username, self.password)
+            smtp.send_message(message)
+
+    async def send(self, notification: Notification, logger: logging.Logger) -> bool:
+        if not notification.email:
+            logger.debug(f"No email address known for '{notification.owner}', not emailing them.")
+            return False
+        if not self.rate_limiter.allow(notification.email):
+            logger.warning(
+                f"Not emailing '{notification.email}' about {notification.event} of "
+                f"DevServer '{notification.name}': already sent {self.rate_limiter.limit} "
+                "emails in the last hour."
+            )
+            return False
+        message = render(notification, self.templates_dir)
+        message["From"] = self.sender
+        await asyncio.to_thread(self._send_message, message)
+        return True
+
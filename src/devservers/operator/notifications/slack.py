@@ -0,0 +1,80 @@
+"""
+Slack notifications, as direct messages from a bot.
+
+The bot's token (`DEVSERVER_SLACK_BOT_TOKEN`) needs the `chat:write` and
+`users:read.email` scopes: owners are looked up by their email address,
+and owners without one or without a Slack account are not notified.
+"""
+import logging
+import os
+from typing import Any, Dict, Optional
+
+import aiohttp
+
+from . import Notification
+
+SLACK_BOT_TOKEN = os.environ.get("DEVSERVER_SLACK_BOT_TOKEN", "")
+SLACK_API_URL = os.environ.get("DEVSERVER_SLACK_API_URL", "https://slack.com/api")
+
+EMOJI = {
+    "Ready": ":white_check_mark:",
+    "ExpiringSoon": ":hourglass_flowing_sand:",
+    "IdleHibernated": ":zzz:",
+    "ProvisioningFailed": ":x:",
+}
+
+
+class SlackError(Exception):
+    """The Slack API rejected a request."""
+
+
+class SlackProvider:
+    """Sends notifications as Slack direct messages to their owners."""
+
+    name = "Slack"
+    fallback = False
+
+    def __init__(self, token: str, api_url: str = SLACK_API_URL):
+        self.token = token
+        self.api_url = api_url.rstrip("/")
+        self._user_ids: Dict[str, Optional[str]] = {}
+
+    async def _call(self, method: str, **params: Any) -> Dict[str, Any]:
+        headers = {"Authorization": f"Bearer {self.token}"}
+        async with aiohttp.ClientSession(raise_for_status=True, headers=headers) as session:
+            async with session.post(f"{self.api_url}/{method}", data=params) as response:
+                result = await response.json()
+        if not result.get("ok"):
+            raise SlackError(f"{method}: {result.get('error', 'unknown error')}")
+        return result
+
+    async def _user_id(self, email: str) -> Optional[str]:
+        """The Slack ID of the user with an email address, if any."""
+        if email not in self._user_ids:
+            try:
+                result = await self._call("users.lookupByEmail", email=email)
+                self._user_ids[email] = result["user"]["id"]
+            except SlackError as e:
+                if "users_not_found" not in str(e):
+                    raise
+                self._user_ids[email] = None
+        return self._user_ids[email]
+
+    async def send(self, notification: Notification, logger: logging.Logger) -> bool:
+        if not notification.email:
+            logger.debug(f"No email address known for '{notification.owner}', not messaging them.")
+            return False
+        user_id = await self._user_id(notification.email)
+        if not user_id:
+            logger.debug(f"No Slack user with email '{notification.email}', not messaging them.")
+            return False
+        emoji = EMOJI.get(notification.event, ":information_source:")
+        await self._call(
+            "chat.postMessage",
+            channel=user_id,
+            text=(
+                f"{emoji} DevServer *{notification.name}* "
+                f"(namespace `{notification.namespace}`): {notification.message}"
+            ),
+        )
+        return True
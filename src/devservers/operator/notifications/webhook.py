@@ -0,0 +1,133 @@
+"""
+Signed JSON webhooks of DevServer lifecycle events.
+
+Every endpoint in `DEVSERVER_EVENT_WEBHOOK_URLS` gets a POST for each
+DevServer that is created, becomes ready, is about to expire, is
+hibernated, is deleted or fails, so that portals and chat-ops bots can
+react to them without watching the cluster. Unlike the owners'
+notifications, they are sent for all DevServers, regardless of their
+namespace's annotation.
+
+The body is signed with HMAC-SHA256 of `<timestamp>.<body>` with
+`DEVSERVER_EVENT_WEBHOOK_SECRET`, sent as `X-DevServer-Signature:
+sha256=<hex>` along with the `X-DevServer-Timestamp`, so that receivers can
+reject forged and replayed deliveries. Failed deliveries are retried a few
+times, and are otherwise dropped.
+"""
+import asyncio
+import hashlib
+import hmac
+import json
+import logging
+import os
+import time
+import uuid
+from datetime import datetime, timezone
+from typing import Any, Dict, List, Mapping, Optional
+
+import aiohttp
+
+EVENT_WEBHOOK_URLS = [
+    url.strip()
+    for url in os.environ.get("DEVSERVER_EVENT_WEBHOOK_URLS", "").split(",")
+    if url.strip()
+]
+EVENT_WEBHOOK_SECRET = os.environ.get("DEVSERVER_EVENT_WEBHOOK_SECRET", "")
+# Comma-separated types to send, e.g. "ready,failed"; all of them if unset
+EVENT_WEBHOOK_TYPES = os.environ.get("DEVSERVER_EVENT_WEBHOOK_TYPES", "")
+
+DELIVERY_ATTEMPTS = 3
+DELIVERY_TIMEOUT_SECONDS = 10
+
+# The webhook type of each event reason that is sent
+TYPES = {
+    "Created": "devserver.created",
+    "Ready": "devserver.ready",
+    "ExpiringSoon": "devserver.expiring",
+    "Hibernated": "devserver.hibernated",
+    "Deleting": "devserver.deleted",
+    "ProvisioningFailed": "devserver.failed",
+}
+
+
+def signature(secret: str, timestamp: str, body: bytes) -> str:
+    """The `X-DevServer-Signature` of a delivery."""
+    digest = hmac.new(secret.encode(), timestamp.encode() + b"." + body, hashlib.sha256)
+    return f"sha256={digest.hexdigest()}"
+
+
+def payload(devserver: Mapping[str, Any], event: str, message: str) -> Dict[str, Any]:
+    """The JSON body of the webhook of a DevServer's event."""
+    metadata = devserver.get("metadata", {})
+    spec = devserver.get("spec", {})
+    return {
+        "id": str(uuid.uuid4()),
+        "type": TYPES[event],
+        "reason": event,
+        "time": datetime.now(timezone.utc).strftime("%Y-%m-%dT%H:%M:%SZ"),
+        "message": message,
+        "devserver": {
+            "name": metadata.get("name"),
+            "namespace": metadata.get("namespace"),
+            "uid": metadata.get("uid"),
+            "owner": spec.get("owner"),
+            "flavor": spec.get("flavor"),
+            "phase": devserver.get("status", {}).get("phase"),
+        },
+    }
+
+
+def _enabled_types(types: str = EVENT_WEBHOOK_TYPES) -> List[str]:
+    if not types:
+        return list(TYPES.values())
+    return [f"devserver.{name.strip()}" for name in types.split(",") if name.strip()]
+
+
+async def _deliver(
+    session: aiohttp.ClientSession,
+    url: str,
+    body: bytes,
+    headers: Dict[str, str],
+    logger: logging.Logger,
+) -> None:
+    for attempt in range(DELIVERY_ATTEMPTS):
+        try:
+            async with session.post(url, data=body, headers=headers) as response:
+                response.raise_for_status()
+            return
+        except (aiohttp.ClientError, asyncio.TimeoutError) as e:
+            if attempt + 1 == DELIVERY_ATTEMPTS:
+                logger.warning(
+                    f"Failed to deliver webhook {headers['X-DevServer-Event']} to {url}: {e}"
+                )
+                return
+            await asyncio.sleep(2**attempt)
+
+
+async def publish(
+    devserver: Mapping[str, Any],
+    event: str,
+    message: str,
+    logger: logging.Logger,
+    urls: Optional[List[str]] = None,
+    secret: Optional[str] = None,
+) -> None:
+    """Send the webhook of a DevServer's event to every endpoint, if it is sent at all."""
+    urls = EVENT_WEBHOOK_URLS if urls is None else urls
+    secret = EVENT_WEBHOOK_SECRET if secret is None else secret
+    if not urls or event not in TYPES or TYPES[event] not in _enabled_types():
+        return
+    data = payload(devserver, event, message)
+    body = json.dumps(data).encode()
+    timestamp = str(int(time.time()))
+    headers = {
+        "Content-Type": "application/json",
+        "X-DevServer-Event": data["type"],
+        "X-DevServer-Delivery": data["id"],
+        "X-DevServer-Timestamp": timestamp,
+    }
+    if secret:
+        headers["X-DevServer-Signature"] = signature(secret, timestamp, body)
+    timeout = aiohttp.ClientTimeout(total=DELIVERY_TIMEOUT_SECONDS)
+    async with aiohttp.ClientSession(timeout=timeout) as session:
+        await asyncio.gather(*(_deliver(session, url, body, headers, logger) for url in urls))
@@ -16,7 +16,20 @@ from typing import Any
 import kopf
 from kubernetes import client, config
 
+from .backoff import configure_backoff
+from .devserver.cost import track_devserver_costs_periodically
 from .devserver.lifecycle import cleanup_expired_devservers
+from .devserver.webhook import (
+    WEBHOOK_CERT_FILE,
+    WEBHOOK_ENABLED,
+    WEBHOOK_HOST,
+    WEBHOOK_KEY_FILE,
+    WEBHOOK_PORT,
+)
+from .events import EventRecorder
+from .metrics import start_metrics_server
+from .ratelimit import limit_client_rate
+from .tracing import configure_tracing
 from .devserverflavor.lifecycle import reconcile_flavors_periodically
 # NOTE: This is what registers our operator's function with kopf so that
 #       `kopf.run -m devservers.operator` can work. If you add more functions
@@ -25,6 +38,10 @@ from .devserverflavor.lifecycle import reconcile_flavors_periodically
 from . import devserver
 from . import devserveruser
 from . import devserverflavor
+from . import devserversnapshot
+from . import devserverbackup
+from . import devserverset
+from . import devserverclaim
 from ..crds.const import CRD_GROUP
 
 
@@ -37,7 +54,30 @@ FINALIZER = f"finalizer.{CRD_GROUP}"
 
 # Operator settings
 EXPIRATION_INTERVAL = int(os.environ.get("DEVSERVER_EXPIRATION_INTERVAL", 60))
+EXPIRY_WARNING_WINDOW = int(os.environ.get("DEVSERVER_EXPIRY_WARNING_WINDOW", 900))
 FLAVOR_RECONCILIATION_INTERVAL = int(os.environ.get("DEVSERVER_FLAVOR_RECONCILIATION_INTERVAL", 60))
+COST_INTERVAL = int(os.environ.get("DEVSERVER_COST_INTERVAL", 300))
+# Port to serve Prometheus metrics on. Disabled (0) by default so that
+# several operators (e.g. in parallel test runs) can share a host.
+METRICS_PORT = int(os.environ.get("DEVSERVER_METRICS_PORT", 0))
+# Number of resources reconciled concurrently. The default worker limit of
+# kopf is unbounded, which can EASILY flood the API server on restart; 1-5 are
+# the generally accepted common sense defaults, and larger clusters can raise it.
+WORKER_LIMIT = int(os.environ.get("DEVSERVER_WORKER_LIMIT", 1))
+# Requests per second, and bursts, of the operator's Kubernetes API client.
+# Disabled (0) by default.
+CLIENT_QPS = float(os.environ.get("DEVSERVER_CLIENT_QPS", 0))
+CLIENT_BURST = int(os.environ.get("DEVSERVER_CLIENT_BURST", 10))
+# Seconds after which watches are restarted, which re-lists all resources.
+# Unset by default, leaving it to the API server.
+WATCH_RESYNC_PERIOD = os.environ.get("DEVSERVER_WATCH_RESYNC_PERIOD")
+# Seconds before the first retry of a failed reconcile, doubled on every
+# further retry up to the maximum.
+RETRY_BASE_DELAY = float(os.environ.get("DEVSERVER_RETRY_BASE_DELAY", 5))
+RETRY_MAX_DELAY = float(os.environ.get("DEVSERVER_RETRY_MAX_DELAY", 300))
+# Reconciles per second, and bursts, across all resources. Disabled (0) by default.
+RECONCILE_QPS = float(os.environ.get("DEVSERVER_RECONCILE_QPS", 0))
+RECONCILE_BURST = int(os.environ.get("DEVSERVER_RECONCILE_BURST", 100))
 
 
 @kopf.on.startup()
@@ -61,16 +101,31 @@ async def on_startup(
             raise kopf.PermanentError("Could not configure Kubernetes client.")
 
     logger.info("Operator started.")
+    configure_tracing(logger)
 
-    # The default worker limit is unbounded which means you can EASILY flood
-    # your API server on restart unless you limit it. 1-5 are the generally
-    # accepted common sense defaults. This is intentionally conservative and
-    # can be tuned based on your cluster's capabilities.
-    # TODO: Make this configurable via environment variable
-    settings.batching.worker_limit = 1
+    settings.batching.worker_limit = WORKER_LIMIT
+    if WATCH_RESYNC_PERIOD:
+        settings.watching.server_timeout = int(WATCH_RESYNC_PERIOD)
+    if CLIENT_QPS > 0:
+        limit_client_rate(CLIENT_QPS, CLIENT_BURST)
+        logger.info(f"Limiting API requests to {CLIENT_QPS}/s, with bursts of {CLIENT_BURST}.")
+    configure_backoff(RETRY_BASE_DELAY, RETRY_MAX_DELAY, RECONCILE_QPS, RECONCILE_BURST)
+
+    # Serves the webhooks that check DevServers, e.g. their owners, and keeps
+    # the webhook configurations pointing to them
+    if WEBHOOK_ENABLED:
+        settings.admission.server = kopf.WebhookServer(
+            host=WEBHOOK_HOST,
+            port=WEBHOOK_PORT,
+            certfile=WEBHOOK_CERT_FILE,
+            pkeyfile=WEBHOOK_KEY_FILE,
+        )
+        settings.admission.managed = CRD_GROUP
+        logger.info(f"Serving the DevServer webhooks on port {WEBHOOK_PORT}.")
 
     # All logs by default go to the k8s event api making api server flooding
-    # even more likely. Disable event posting to reduce API load.
+    # even more likely. Disable event posting to reduce API load; the events
+    # users care about are recorded explicitly via EventRecorder.
     settings.posting.enabled = False
 
     # Start the background cleanup task for TTL expiration
@@ -81,6 +136,8 @@ async def on_startup(
             custom_objects_api=custom_objects_api,
             logger=logger,
             interval_seconds=EXPIRATION_INTERVAL,
+            recorder=EventRecorder(logger),
+            expiry_warning_seconds=EXPIRY_WARNING_WINDOW,
         )
     )
 
@@ -91,3 +148,15 @@ async def on_startup(
             interval_seconds=FLAVOR_RECONCILIATION_INTERVAL,
         )
     )
+
+    # Start the background task for cost tracking
+    loop.create_task(
+        track_devserver_costs_periodically(
+            custom_objects_api=custom_objects_api,
+            logger=logger,
+            interval_seconds=COST_INTERVAL,
+        )
+    )
+
+    if METRICS_PORT:
+        await start_metrics_server(METRICS_PORT, logger)
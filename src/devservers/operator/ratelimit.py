@@ -0,0 +1,65 @@
+"""
+Client-side rate limiting of the operator's Kubernetes API requests.
+
+Like client-go's QPS and Burst, requests may be made in bursts of up to
+`DEVSERVER_CLIENT_BURST` and are otherwise spread out to at most
+`DEVSERVER_CLIENT_QPS` per second, so that reconciling thousands of
+DevServers after a restart does not overwhelm the API server. Only requests
+made with the kubernetes client are limited; kopf's own watches are not.
+"""
+import functools
+import threading
+import time
+from typing import Any, Callable
+
+from kubernetes.client import rest
+
+
+class TokenBucket:
+    """A thread-safe token bucket that makes callers wait for their turn."""
+
+    def __init__(
+        self,
+        rate: float,
+        capacity: int,
+        clock: Callable[[], float] = time.monotonic,
+        sleep: Callable[[float], None] = time.sleep,
+    ) -> None:
+        self.rate = rate
+        self.capacity = capacity
+        self._clock = clock
+        self._sleep = sleep
+        self._tokens = float(capacity)
+        self._updated = clock()
+        self._lock = threading.Lock()
+
+    def reserve(self) -> float:
+        """Take a token, returning the seconds to wait until it is available."""
+        with self._lock:
+            now = self._clock()
+            self._tokens = min(self.capacity, self._tokens + (now - self._updated) * self.rate)
+            self._updated = now
+            # Reserving the token before waiting keeps waiting callers in order
+            self._tokens -= 1
+            return -self._tokens / self.rate if self._tokens < 0 else 0.0
+
+    def acquire(self) -> None:
+        """Take a token, waiting until it is available."""
+        wait = self.reserve()
+        if wait > 0:
+            self._sleep(wait)
+
+
+def limit_client_rate(qps: float, burst: int) -> None:
+    """Rate limit all requests of the kubernetes client to `qps`, with bursts of `burst`."""
+    request = rest.RESTClientObject.request
+    # Replaces an earlier limit, e.g. of a previous startup in the same process
+    request = getattr(request, "__wrapped__", request)
+    bucket = TokenBucket(qps, max(burst, 1))
+
+    @functools.wraps(request)
+    def limited_request(self: rest.RESTClientObject, *args: Any, **kwargs: Any) -> Any:
+        bucket.acquire()
+        return request(self, *args, **kwargs)
+
+    rest.RESTClientObject.request = limited_request
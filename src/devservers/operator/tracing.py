@@ -0,0 +1,114 @@
+"""
+OpenTelemetry tracing for reconcile operations.
+
+Tracing is optional: the OpenTelemetry SDK and OTLP exporter come with the
+`tracing` extra, which the operator image installs. When they are installed
+and an OTLP endpoint is
+configured through the standard `OTEL_EXPORTER_OTLP_ENDPOINT` (or
+`OTEL_EXPORTER_OTLP_TRACES_ENDPOINT`) environment variable, spans are exported
+via OTLP/HTTP. Otherwise `span()` is a no-op.
+"""
+import functools
+import logging
+import os
+from contextlib import contextmanager
+from typing import Any, Awaitable, Callable, Dict, Iterator, Optional, TypeVar
+
+try:
+    from opentelemetry import trace
+except ImportError:  # pragma: no cover - exercised when OTel is not installed
+    trace = None  # type: ignore[assignment]
+
+SERVICE_NAME = "devserver-operator"
+
+F = TypeVar("F", bound=Callable[..., Awaitable[Any]])
+
+_tracer: Optional[Any] = None
+
+
+def configure_tracing(logger: logging.Logger) -> bool:
+    """
+    Set up the global tracer provider if tracing is enabled.
+
+    Returns:
+        True if spans will be exported, False otherwise.
+    """
+    global _tracer
+
+    endpoint = os.environ.get("OTEL_EXPORTER_OTLP_TRACES_ENDPOINT") or os.environ.get(
+        "OTEL_EXPORTER_OTLP_ENDPOINT"
+    )
+    if not endpoint:
+        return False
+
+    if trace is None:
+        logger.warning(
+            "An OTLP endpoint is configured but OpenTelemetry is not installed; "
+            "install the 'tracing' extra, e.g. pip install 'devservers[tracing]', "
+            "to enable tracing."
+        )
+        return False
+
+    try:
+        from opentelemetry.exporter.otlp.proto.http.trace_exporter import OTLPSpanExporter
+        from opentelemetry.sdk.resources import Resource
+        from opentelemetry.sdk.trace import TracerProvider
+        from opentelemetry.sdk.trace.export import BatchSpanProcessor
+    except ImportError as e:
+        logger.warning(
+            f"OpenTelemetry SDK or OTLP exporter is missing, tracing disabled: {e}. "
+            "Install the 'tracing' extra to enable it."
+        )
+        return False
+
+    # OTEL_SERVICE_NAME / OTEL_RESOURCE_ATTRIBUTES still take precedence.
+    resource = Resource.create({"service.name": os.environ.get("OTEL_SERVICE_NAME", SERVICE_NAME)})
+    provider = TracerProvider(resource=resource)
+    provider.add_span_processor(BatchSpanProcessor(OTLPSpanExporter()))
+    trace.set_tracer_provider(provider)
+    _tracer = trace.get_tracer(__name__)
+    logger.info(f"Exporting reconcile traces to {endpoint}.")
+    return True
+
+
+@contextmanager
+def span(name: str, attributes: Optional[Dict[str, Any]] = None) -> Iterator[None]:
+    """
+    Trace the enclosed block as a span, if tracing is configured.
+
+    Attributes with a None value are dropped. Exceptions raised inside the
+    block are recorded on the span and re-raised.
+    """
+    if _tracer is None:
+        yield
+        return
+
+    attributes = {key: value for key, value in (attributes or {}).items() if value is not None}
+    with _tracer.start_as_current_span(name, attributes=attributes):
+        yield
+
+
+def devserver_attributes(name: Optional[str], namespace: Optional[str]) -> Dict[str, Any]:
+    """Common span attributes identifying a DevServer."""
+    return {"devserver.name": name, "k8s.namespace.name": namespace}
+
+
+def traced(name: str) -> Callable[[F], F]:
+    """
+    Trace an async kopf handler as a root span.
+
+    The DevServer name and namespace are taken from the handler's keyword
+    arguments. `functools.wraps` keeps the handler id kopf derives from the
+    function unchanged.
+    """
+
+    def decorator(fn: F) -> F:
+        @functools.wraps(fn)
+        async def wrapper(*args: Any, **kwargs: Any) -> Any:
+            attributes = devserver_attributes(kwargs.get("name"), kwargs.get("namespace"))
+            with span(name, attributes):
+                return await fn(*args, **kwargs)
+
+        return wrapper  # type: ignore[return-value]
+
+    return decorator
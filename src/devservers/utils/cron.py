@@ -0,0 +1,121 @@
+from dataclasses import dataclass
+from datetime import datetime, timedelta
+from typing import FrozenSet, Optional
+
+MACROS = {
+    "@hourly": "0 * * * *",
+    "@daily": "0 0 * * *",
+    "@midnight": "0 0 * * *",
+    "@weekly": "0 0 * * 0",
+    "@monthly": "0 0 1 * *",
+    "@yearly": "0 0 1 1 *",
+    "@annually": "0 0 1 1 *",
+}
+
+# (name, minimum, maximum) of each of the five fields
+FIELDS = [
+    ("minute", 0, 59),
+    ("hour", 0, 23),
+    ("day of month", 1, 31),
+    ("month", 1, 12),
+    ("day of week", 0, 7),
+]
+
+# Nothing fires further ahead than this, e.g. "0 0 30 2 *"
+MAX_LOOKAHEAD = timedelta(days=366 * 5)
+
+
+def _parse_field(value: str, name: str, minimum: int, maximum: int) -> FrozenSet[int]:
+    values = set()
+    for part in value.split(","):
+        range_part, slash, step_part = part.partition("/")
+        step = int(step_part) if slash and step_part.isdigit() else 1
+        if slash and (not step_part.isdigit() or step == 0):
+            raise ValueError(f"Invalid step '{step_part}' in {name} field '{value}'.")
+        if range_part == "*":
+            start, end = minimum, maximum
+        else:
+            start_part, dash, end_part = range_part.partition("-")
+            if not start_part.isdigit() or (dash and not end_part.isdigit()):
+                raise ValueError(f"Invalid {name} field '{value}'.")
+            start = int(start_part)
+            # "5/15" means every 15 starting at 5, like "5-59/15"
+            end = int(end_part) if dash else (maximum if slash else start)
+        if start < minimum or end > maximum or start > end:
+            raise ValueError(
+                f"Invalid {name} field '{value}': values must be within {minimum}-{maximum}."
+            )
+        values.update(range(start, end + 1, step))
+    return frozenset(values)
+
+
+@dataclass(frozen=True)
+class CronSchedule:
+    """A parsed five-field cron expression, evaluated in the timezone of the datetimes given."""
+
+    minutes: FrozenSet[int]
+    hours: FrozenSet[int]
+    days_of_month: FrozenSet[int]
+    months: FrozenSet[int]
+    days_of_week: FrozenSet[int]
+    # Like cron, a day matches either day field when both are restricted
+    day_of_month_restricted: bool
+    day_of_week_restricted: bool
+
+    def _matches_day(self, dt: datetime) -> bool:
+        day_of_month = dt.day in self.days_of_month
+        # Python's Monday is 0, cron's Sunday is 0 (or 7)
+        day_of_week = (dt.weekday() + 1) % 7 in self.days_of_week
+        if self.day_of_month_restricted and self.day_of_week_restricted:
+            return day_of_month or day_of_week
+        return day_of_month and day_of_week
+
+    def next_after(self, after: datetime) -> Optional[datetime]:
+        """
+        The first time strictly after `after` that the schedule fires, or
+        None if it never does (e.g. on February 30th).
+        """
+        dt = after.replace(second=0, microsecond=0) + timedelta(minutes=1)
+        limit = after + MAX_LOOKAHEAD
+        while dt <= limit:
+            if dt.month not in self.months or not self._matches_day(dt):
+                dt = (dt + timedelta(days=1)).replace(hour=0, minute=0)
+            elif dt.hour not in self.hours:
+                dt = (dt + timedelta(hours=1)).replace(minute=0)
+            elif dt.minute not in self.minutes:
+                dt += timedelta(minutes=1)
+            else:
+                return dt
+        return None
+
+
+def parse_cron(expression: str) -> CronSchedule:
+    """
+    Parses a standard five-field cron expression like '0 3 * * *', or a
+    macro like '@daily'. Fields support '*', lists, ranges and steps.
+
+    Raises:
+        ValueError: If the expression is invalid.
+    """
+    expression = MACROS.get(expression.strip(), expression)
+    fields = expression.split()
+    if len(fields) != len(FIELDS):
+        raise ValueError(
+            f"Invalid cron expression '{expression}': expected 5 fields "
+            "(minute hour day-of-month month day-of-week)."
+        )
+    minutes, hours, days_of_month, months, days_of_week = (
+        _parse_field(value, name, minimum, maximum)
+        for value, (name, minimum, maximum) in zip(fields, FIELDS)
+    )
+    if 7 in days_of_week:
+        days_of_week = (days_of_week - {7}) | {0}
+    return CronSchedule(
+        minutes=minutes,
+        hours=hours,
+        days_of_month=days_of_month,
+        months=months,
+        days_of_week=days_of_week,
+        day_of_month_restricted=not fields[2].startswith("*"),
+        day_of_week_restricted=not fields[4].startswith("*"),
+    )
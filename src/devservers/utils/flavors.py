@@ -1,13 +1,72 @@
 import asyncio
-from typing import Any, Dict
+import copy
+from typing import Any, Dict, List, Mapping, Optional, Tuple
 
 from kubernetes import client
 
 from ..crds.const import CRD_GROUP, CRD_VERSION, CRD_PLURAL_DEVSERVERFLAVOR
 
+# Node labels naming the GPU model of a node, as set by NVIDIA GPU feature
+# discovery, GKE and Karpenter on EKS
+GPU_TYPE_NODE_LABELS = (
+    "nvidia.com/gpu.product",
+    "cloud.google.com/gke-accelerator",
+    "karpenter.k8s.aws/instance-gpu-name",
+)
 
-async def get_default_flavor() -> Dict[str, Any] | None:
+# Extended resources and node label of NVIDIA's device plugin and GPU
+# feature discovery, which spec.gpu is translated into
+GPU_RESOURCE_NAME = "nvidia.com/gpu"
+MIG_RESOURCE_PREFIX = "nvidia.com/mig-"
+# Time-sliced GPUs, as advertised by the device plugin with renameByDefault
+SHARED_GPU_RESOURCE_NAME = "nvidia.com/gpu.shared"
+GPU_SHARING_TIME_SLICING = "TimeSlicing"
+GPU_PRODUCT_NODE_LABEL = "nvidia.com/gpu.product"
+
+# Namespace annotation naming the default flavor of DevServers created in it
+DEFAULT_FLAVOR_ANNOTATION = f"{CRD_GROUP}/default-flavor"
+
+# Flavor annotation with its hourly cost, when spec.costPerHour is not set
+HOURLY_COST_ANNOTATION = f"{CRD_GROUP}/hourly-cost"
+
+# Fields describing a flavor itself rather than its DevServers, which
+# flavors do not inherit from their base flavor
+NON_INHERITED_FIELDS = frozenset(["baseFlavor", "default", "deprecated", "replacement"])
+
+
+def _namespace_default_flavor_name(namespace: str) -> Optional[str]:
+    try:
+        namespace_object = client.CoreV1Api().read_namespace(name=namespace)
+    except client.ApiException:
+        # Users may not be allowed to read namespaces; the cluster default applies then
+        return None
+    annotations = namespace_object.metadata.annotations or {}
+    return annotations.get(DEFAULT_FLAVOR_ANNOTATION)
+
+
+async def get_default_flavor(namespace: Optional[str] = None) -> Dict[str, Any] | None:
+    """
+    Return the default flavor of a namespace, named by its
+    `devserver.io/default-flavor` annotation, falling back to the flavor with
+    `spec.default` set. Returns None if there is none, or if the annotation
+    names a flavor that does not exist.
+    """
     custom_objects_api = client.CustomObjectsApi()
+    if namespace:
+        flavor_name = await asyncio.to_thread(_namespace_default_flavor_name, namespace)
+        if flavor_name:
+            try:
+                return await asyncio.to_thread(
+                    custom_objects_api.get_cluster_custom_object,
+                    group=CRD_GROUP,
+                    version=CRD_VERSION,
+                    plural=CRD_PLURAL_DEVSERVERFLAVOR,
+                    name=flavor_name,
+                )
+            except client.ApiException as e:
+                if e.status == 404:
+                    return None
+                raise
     flavors = await asyncio.to_thread(
         custom_objects_api.list_cluster_custom_object,
         group=CRD_GROUP,
@@ -19,3 +78,190 @@ async def get_default_flavor() -> Dict[str, Any] | None:
         if flavor.get("spec", {}).get("default", False):
             return flavor
     return None
+
+
+def _merge_spec(base: Mapping[str, Any], override: Mapping[str, Any]) -> Dict[str, Any]:
+    merged = copy.deepcopy(dict(base))
+    for key, value in override.items():
+        if isinstance(value, dict) and isinstance(merged.get(key), dict):
+            merged[key] = _merge_spec(merged[key], value)
+        else:
+            merged[key] = copy.deepcopy(value)
+    return merged
+
+
+def resolve_flavor(flavor: Dict[str, Any], flavors: Mapping[str, Dict[str, Any]]) -> Dict[str, Any]:
+    """
+    Return a flavor with the fields it inherits from its `spec.baseFlavor`
+    chain. Maps such as resources and nodeSelector are merged key by key,
+    while lists such as tolerations and other values are replaced.
+
+    Raises:
+        ValueError: If a base flavor does not exist or the chain is a cycle.
+    """
+    name = flavor["metadata"]["name"]
+    spec = {key: value for key, value in flavor.get("spec", {}).items() if key != "baseFlavor"}
+    base_name = flavor.get("spec", {}).get("baseFlavor")
+    chain: List[str] = [name]
+    while base_name:
+        if base_name in chain:
+            raise ValueError(
+                f"base flavors of '{name}' form a cycle: {' -> '.join([*chain, base_name])}."
+            )
+        if base_name not in flavors:
+            raise ValueError(f"base flavor '{base_name}' of '{name}' does not exist.")
+        chain.append(base_name)
+        base_spec = flavors[base_name].get("spec", {})
+        inherited = {k: v for k, v in base_spec.items() if k not in NON_INHERITED_FIELDS}
+        spec = _merge_spec(inherited, spec)
+        base_name = base_spec.get("baseFlavor")
+    return {**flavor, "spec": spec}
+
+
+async def resolve_base_flavors(flavor: Dict[str, Any]) -> Dict[str, Any]:
+    """Resolve a flavor's `spec.baseFlavor`, listing the flavors only when it has one."""
+    if not flavor.get("spec", {}).get("baseFlavor"):
+        return flavor
+    flavors = await asyncio.to_thread(
+        client.CustomObjectsApi().list_cluster_custom_object,
+        group=CRD_GROUP,
+        version=CRD_VERSION,
+        plural=CRD_PLURAL_DEVSERVERFLAVOR,
+    )
+    return resolve_flavor(flavor, {f["metadata"]["name"]: f for f in flavors.get("items", [])})
+
+
+def current_flavor_name(spec: Mapping[str, Any], status: Mapping[str, Any]) -> Optional[str]:
+    """
+    The flavor a DevServer runs with. A fallback flavor it was provisioned
+    with stays in use while it remains in `spec.flavorFallbacks`, so that
+    later reconciles do not move the DevServer back to its primary flavor.
+    """
+    used = status.get("flavor")
+    if used and used in spec.get("flavorFallbacks", []):
+        return used
+    return spec.get("flavor")
+
+
+def get_hourly_cost(flavor: Mapping[str, Any]) -> Optional[float]:
+    """
+    Return the hourly cost of a flavor, or None if it has none.
+
+    `spec.costPerHour` takes precedence over the annotation.
+    """
+    value = flavor.get("spec", {}).get("costPerHour")
+    if value is None:
+        value = flavor.get("metadata", {}).get("annotations", {}).get(HOURLY_COST_ANNOTATION)
+    if value is None:
+        return None
+    try:
+        cost = float(value)
+    except (TypeError, ValueError):
+        return None
+    return cost if cost >= 0 else None
+
+
+async def get_flavor(name: str) -> Dict[str, Any]:
+    """
+    Get a flavor with its base flavors resolved.
+
+    Raises:
+        client.ApiException: If the flavor cannot be read, e.g. it does not exist.
+        ValueError: If its base flavors cannot be resolved.
+    """
+    flavor = await asyncio.to_thread(
+        client.CustomObjectsApi().get_cluster_custom_object,
+        group=CRD_GROUP,
+        version=CRD_VERSION,
+        plural=CRD_PLURAL_DEVSERVERFLAVOR,
+        name=name,
+    )
+    return await resolve_base_flavors(flavor)
+
+
+def get_flavor_gpu_resource(flavor: Dict[str, Any]) -> Optional[str]:
+    """
+    The extended resource a flavor's `spec.gpu` is requested as:
+    `nvidia.com/gpu`, `nvidia.com/mig-<profile>` for a MIG profile, or
+    `nvidia.com/gpu.shared` for time-sliced GPUs. None without `spec.gpu`.
+    """
+    gpu = flavor.get("spec", {}).get("gpu")
+    if not gpu:
+        return None
+    if gpu.get("migProfile"):
+        return f"{MIG_RESOURCE_PREFIX}{gpu['migProfile']}"
+    if gpu.get("sharing") == GPU_SHARING_TIME_SLICING:
+        return SHARED_GPU_RESOURCE_NAME
+    return GPU_RESOURCE_NAME
+
+
+def validate_flavor_gpu(flavor: Dict[str, Any]) -> None:
+    """
+    Check that a flavor's `spec.gpu` asks for one kind of GPU sharing.
+
+    Raises:
+        ValueError: If it sets both a MIG profile and time-slicing.
+    """
+    gpu = flavor.get("spec", {}).get("gpu") or {}
+    if gpu.get("migProfile") and gpu.get("sharing") == GPU_SHARING_TIME_SLICING:
+        raise ValueError(
+            f"gpu.migProfile and gpu.sharing {GPU_SHARING_TIME_SLICING} are mutually exclusive."
+        )
+
+
+def get_flavor_resources(flavor: Dict[str, Any]) -> Dict[str, Any]:
+    """
+    Return a flavor's resources, with its `spec.gpu` requested and limited as
+    the resource `get_flavor_gpu_resource` names.
+    """
+    resources = copy.deepcopy(flavor.get("spec", {}).get("resources") or {})
+    resource = get_flavor_gpu_resource(flavor)
+    if resource:
+        count = str(flavor["spec"]["gpu"]["count"])
+        for kind in ("requests", "limits"):
+            resources[kind] = {**(resources.get(kind) or {}), resource: count}
+    return resources
+
+
+def get_flavor_node_selector(flavor: Dict[str, Any]) -> Dict[str, str]:
+    """
+    Return a flavor's node selector, pinning the GPU product of its
+    `spec.gpu` if set.
+    """
+    node_selector = dict(flavor.get("spec", {}).get("nodeSelector") or {})
+    product = flavor.get("spec", {}).get("gpu", {}).get("product")
+    if product:
+        node_selector[GPU_PRODUCT_NODE_LABEL] = product
+    return node_selector
+
+
+def get_flavor_gpus(flavor: Dict[str, Any]) -> Tuple[int, Optional[str]]:
+    """
+    Return the number of GPUs or MIG devices a flavor requests (e.g.
+    `nvidia.com/gpu`), and their type when the flavor pins it.
+    """
+    resources = get_flavor_resources(flavor)
+    gpus = 0
+    for quantities in (resources.get("limits") or {}, resources.get("requests") or {}):
+        gpus = max(
+            [gpus]
+            + [
+                int(value)
+                for key, value in quantities.items()
+                if key.endswith("/gpu")
+                or key == SHARED_GPU_RESOURCE_NAME
+                or key.startswith(MIG_RESOURCE_PREFIX)
+            ]
+        )
+
+    node_selector = get_flavor_node_selector(flavor)
+    gpu_type = next(
+        (node_selector[label] for label in GPU_TYPE_NODE_LABELS if label in node_selector), None
+    )
+    gpu = flavor.get("spec", {}).get("gpu", {})
+    sharing = gpu.get("migProfile") or (
+        "time-sliced" if gpu.get("sharing") == GPU_SHARING_TIME_SLICING else None
+    )
+    if sharing:
+        gpu_type = f"{gpu_type} {sharing}" if gpu_type else sharing
+    return gpus, gpu_type
@@ -36,6 +36,15 @@ def _forward_sockets(
                 break
 
 
+def is_tcp_reachable(host: str, port: int, timeout: float = 3.0) -> bool:
+    """Returns whether a TCP connection to host:port can be opened."""
+    try:
+        with socket.create_connection((host, port), timeout=timeout):
+            return True
+    except OSError:
+        return False
+
+
 @contextlib.contextmanager
 def kubernetes_port_forward(
     pod_name: str, namespace: str, pod_port: int, silent: bool = False
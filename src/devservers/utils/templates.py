@@ -0,0 +1,62 @@
+"""
+Discovery of DevServerTemplates, the environments the platform team offers.
+
+Both the CLI and the HTTP API list templates as summaries: the template's
+description, its default flavor, the image of its DevServer spec and the
+hourly cost of its default flavor, when that flavor has one.
+"""
+from typing import Any, Dict, List, Mapping, Optional
+
+from kubernetes import client
+
+from .flavors import get_hourly_cost, resolve_flavor
+from ..crds.const import (
+    CRD_GROUP,
+    CRD_VERSION,
+    CRD_PLURAL_DEVSERVERFLAVOR,
+    CRD_PLURAL_DEVSERVERTEMPLATE,
+)
+
+
+def summarize_template(
+    template: Mapping[str, Any], flavors: Mapping[str, Dict[str, Any]]
+) -> Dict[str, Any]:
+    """Summarize a template, taking its cost hint from its default flavor in `flavors`."""
+    spec = template.get("spec", {})
+    flavor_name = spec.get("defaultFlavor")
+    hourly_cost: Optional[float] = None
+    if flavor_name in flavors:
+        try:
+            hourly_cost = get_hourly_cost(resolve_flavor(flavors[flavor_name], flavors))
+        except ValueError:
+            # A broken base flavor chain; the operator reports it on the flavor
+            pass
+    return {
+        "name": template["metadata"]["name"],
+        "description": spec.get("description", ""),
+        "defaultFlavor": flavor_name,
+        "image": spec.get("spec", {}).get("image"),
+        "hourlyCost": hourly_cost,
+    }
+
+
+def list_templates(
+    custom_objects_api: Optional[client.CustomObjectsApi] = None,
+) -> List[Dict[str, Any]]:
+    """Summaries of all DevServerTemplates in the cluster, sorted by name."""
+    custom_objects_api = custom_objects_api or client.CustomObjectsApi()
+    templates = custom_objects_api.list_cluster_custom_object(
+        group=CRD_GROUP, version=CRD_VERSION, plural=CRD_PLURAL_DEVSERVERTEMPLATE
+    )["items"]
+    if not templates:
+        return []
+    flavors = {
+        flavor["metadata"]["name"]: flavor
+        for flavor in custom_objects_api.list_cluster_custom_object(
+            group=CRD_GROUP, version=CRD_VERSION, plural=CRD_PLURAL_DEVSERVERFLAVOR
+        )["items"]
+    }
+    return sorted(
+        (summarize_template(template, flavors) for template in templates),
+        key=lambda summary: summary["name"],
+    )
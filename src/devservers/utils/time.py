@@ -3,18 +3,20 @@ from datetime import timedelta
 
 
 def parse_duration(duration_str: str) -> timedelta:
-    """Parses a duration string like '1h30m' into a timedelta object."""
+    """Parses a duration string like '2d' or '1h30m' into a timedelta object."""
     if not duration_str:
         return timedelta()
 
-    parts = re.findall(r"(\d+)([hms])", duration_str)
+    parts = re.findall(r"(\d+)([dhms])", duration_str)
     if not parts or "".join([p[0] + p[1] for p in parts]) != duration_str:
         raise ValueError(f"Invalid duration format: {duration_str}")
 
     duration_dict = {}
     for value, unit in parts:
         value = int(value)
-        if unit == "h":
+        if unit == "d":
+            duration_dict["days"] = duration_dict.get("days", 0) + value
+        elif unit == "h":
             duration_dict["hours"] = duration_dict.get("hours", 0) + value
         elif unit == "m":
             duration_dict["minutes"] = duration_dict.get("minutes", 0) + value
@@ -22,3 +24,25 @@ def parse_duration(duration_str: str) -> timedelta:
             duration_dict["seconds"] = duration_dict.get("seconds", 0) + value
 
     return timedelta(**duration_dict)
+
+
+def format_duration(delta: timedelta, max_units: int = 2) -> str:
+    """
+    Formats a timedelta as a compact duration string like '2d3h' or '45m'.
+
+    Only the `max_units` most significant units are shown; pass 4 for an
+    exact string that `parse_duration` round-trips.
+    """
+    total_seconds = int(delta.total_seconds())
+    if total_seconds <= 0:
+        return "0s"
+
+    days, remainder = divmod(total_seconds, 86400)
+    hours, remainder = divmod(remainder, 3600)
+    minutes, seconds = divmod(remainder, 60)
+
+    # By default only show the two most significant units, like kubectl does for ages.
+    units = [(days, "d"), (hours, "h"), (minutes, "m"), (seconds, "s")]
+    while units and units[0][0] == 0:
+        units.pop(0)
+    return "".join(f"{value}{unit}" for value, unit in units[:max_units] if value)
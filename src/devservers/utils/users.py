@@ -2,6 +2,7 @@
 
 from __future__ import annotations
 
+import re
 from typing import Final
 
 USERNAME_REGEX: Final[str] = r"^[a-z0-9]([-a-z0-9]*[a-z0-9])?$"
@@ -12,3 +13,18 @@ def compute_user_namespace(username: str, cluster_prefix: str = "dev") -> str:
 
     safe_username = username.lower()
     return f"{cluster_prefix}-{safe_username}"
+
+
+def owner_to_dns_label(owner: str) -> str:
+    """Return a DNS-1123 label derived from an owner such as 'alice@example.com'."""
+
+    label = re.sub(r"[^a-z0-9-]+", "-", owner.lower())
+    label = re.sub(r"-{2,}", "-", label).strip("-")
+    return label[:63].rstrip("-")
+
+
+def owner_ssh_keys_secret_name(owner: str) -> str:
+    """Return the name of the conventional Secret holding an owner's SSH public keys."""
+
+    # Leave room for the suffix within the 63 character Secret name budget
+    return f"{owner_to_dns_label(owner)[:54].rstrip('-')}-ssh-keys"
@@ -20,6 +20,8 @@ from devservers.crds.const import (
     CRD_PLURAL_DEVSERVER,
     CRD_PLURAL_DEVSERVERFLAVOR,
     CRD_PLURAL_DEVSERVERUSER,
+    CRD_PLURAL_DEVSERVERSNAPSHOT,
+    CRD_PLURAL_DEVSERVERBACKUP,
     CRD_VERSION,
 )
 from unittest.mock import MagicMock
@@ -139,6 +141,8 @@ def apply_crds():
         f"{CRD_PLURAL_DEVSERVER}.{CRD_GROUP}",
         f"{CRD_PLURAL_DEVSERVERFLAVOR}.{CRD_GROUP}",
         f"{CRD_PLURAL_DEVSERVERUSER}.{CRD_GROUP}",
+        f"{CRD_PLURAL_DEVSERVERSNAPSHOT}.{CRD_GROUP}",
+        f"{CRD_PLURAL_DEVSERVERBACKUP}.{CRD_GROUP}",
     ]
 
     for crd_name in crd_names:
@@ -186,6 +190,27 @@ def apply_crds():
         utils.create_from_yaml(
             k8s_client, "crds/devserver.io_devserverusers.yaml", apply=True
         )
+        utils.create_from_yaml(
+            k8s_client, "crds/devserver.io_devserversnapshots.yaml", apply=True
+        )
+        utils.create_from_yaml(
+            k8s_client, "crds/devserver.io_devserverbackups.yaml", apply=True
+        )
+        utils.create_from_yaml(
+            k8s_client, "crds/devserver.io_devserversets.yaml", apply=True
+        )
+        utils.create_from_yaml(
+            k8s_client, "crds/devserver.io_devserverclaims.yaml", apply=True
+        )
+        utils.create_from_yaml(
+            k8s_client, "crds/devserver.io_devservertemplates.yaml", apply=True
+        )
+        utils.create_from_yaml(
+            k8s_client, "crds/devserver.io_devserverprofiles.yaml", apply=True
+        )
+        utils.create_from_yaml(
+            k8s_client, "crds/devserver.io_devservercalendars.yaml", apply=True
+        )
         print("✅ CRDs applied successfully")
     except Exception as e:
         print(f"⚠️ CRD application failed: {e}")
@@ -0,0 +1,184 @@
+from datetime import datetime, timezone
+from unittest.mock import MagicMock, patch
+
+import pytest
+from kubernetes.client.rest import ApiException
+
+from devservers.operator.devserver import agent
+from devservers.operator.devserver.agent import (
+    activity_status,
+    extend_time_to_live,
+    idle_timeout_elapsed,
+    pending_extend_request,
+)
+from devservers.operator.devserver.reconciler import DevServerReconciler
+from devservers.operator.devserver.resources.statefulset import build_statefulset
+
+FLAVOR = {"spec": {"resources": {}}}
+META = {"creationTimestamp": "2024-01-01T12:00:00Z"}
+SPEC = {"lifecycle": {"timeToLive": "4h"}}
+
+
+def test_activity_status_never_moves_last_activity_backwards():
+    assert activity_status({}) is None
+
+    report = {
+        "sshSessions": "2",
+        "ttys": "1",
+        "gpuUtilization": "",
+        "lastActivity": "1704110400",
+        "reportedAt": "1704110460",
+    }
+    activity = activity_status(report)
+    assert activity == {
+        "sshSessions": 2,
+        "ttys": 1,
+        "gpuUtilization": None,
+        "lastActivity": "2024-01-01T12:00:00Z",
+    }
+
+    # The terminal it was last seen on was closed
+    report = {**report, "ttys": "0", "lastActivity": "", "gpuUtilization": "87"}
+    assert activity_status(report, activity) == {
+        "sshSessions": 2,
+        "ttys": 0,
+        "gpuUtilization": 87,
+        "lastActivity": "2024-01-01T12:00:00Z",
+    }
+
+
+def test_pending_extend_request_skips_answered_requests():
+    assert pending_extend_request({}) is None
+    assert pending_extend_request({"extendRequest": "17-3 12h"}) == ("17-3", "12h")
+    assert pending_extend_request(
+        {"extendRequest": "17-3 12h", "extendResult": "17-3 ok extended"}
+    ) is None
+    assert pending_extend_request(
+        {"extendRequest": "18-3 1h", "extendResult": "17-3 ok extended"}
+    ) == ("18-3", "1h")
+
+
+def test_extend_time_to_live_checks_the_max_time_to_live():
+    new_ttl, message = extend_time_to_live("my-dev", SPEC, META, "12h")
+
+    assert new_ttl == "16h"
+    assert message == (
+        "DevServer 'my-dev' extended by 12h, it now expires at 2024-01-02T04:00:00Z."
+    )
+    with pytest.raises(ValueError):
+        extend_time_to_live("my-dev", SPEC, META, "30d")
+    with pytest.raises(ValueError):
+        extend_time_to_live("my-dev", SPEC, META, "12 hours")
+    with pytest.raises(ValueError):
+        extend_time_to_live("my-dev", {}, META, "12h")
+
+
+def test_extend_time_to_live_checks_the_extensions_left():
+    status = {"lifecycleLimits": {"template": "pytorch-gpu", "extensionsRemaining": 0}}
+
+    with pytest.raises(ValueError, match="pytorch-gpu"):
+        extend_time_to_live("my-dev", SPEC, META, "1h", status)
+
+
+def test_idle_timeout_elapsed_counts_from_the_last_activity_or_readiness():
+    spec = {"lifecycle": {"timeToLive": "1d", "idleTimeout": "2h"}}
+    status = {
+        "phase": "Running",
+        "conditions": [{"type": "Ready", "lastTransitionTime": "2024-01-01T13:00:00Z"}],
+        "activity": {"lastActivity": "2024-01-01T12:30:00Z"},
+    }
+
+    def elapsed(spec, status, hour, minute=0):
+        return idle_timeout_elapsed(
+            spec, META, status, datetime(2024, 1, 1, hour, minute, tzinfo=timezone.utc)
+        )
+
+    assert not elapsed(spec, status, 14, 59)
+    assert elapsed(spec, status, 15)
+    active = {**status, "activity": {"lastActivity": "2024-01-01T14:00:00Z"}}
+    assert not elapsed(spec, active, 15)
+    assert not elapsed(spec, {**status, "phase": "Hibernated"}, 15)
+    assert not elapsed(SPEC, status, 23)
+
+
+@pytest.mark.asyncio
+async def test_sync_agent_applies_and_answers_extend_requests():
+    core_v1 = MagicMock()
+    core_v1.read_namespaced_config_map.return_value.data = {
+        "reportedAt": "1704110460",
+        "lastActivity": "1704110400",
+        "extendRequest": "17-3 12h",
+    }
+    custom_objects_api = MagicMock()
+
+    with patch.object(agent.client, "CoreV1Api", return_value=core_v1), patch.object(
+        agent.client, "CustomObjectsApi", return_value=custom_objects_api
+    ):
+        activity = await agent.sync_agent("my-dev", "dev-alice", SPEC, META, {}, MagicMock())
+
+    assert activity["lastActivity"] == "2024-01-01T12:00:00Z"
+    body = custom_objects_api.patch_namespaced_custom_object.call_args.kwargs["body"]
+    assert body == {"spec": {"lifecycle": {"timeToLive": "16h"}}}
+    result = core_v1.patch_namespaced_config_map.call_args.kwargs
+    assert result["name"] == "my-dev-agent"
+    assert result["body"]["data"]["extendResult"].startswith("17-3 ok DevServer 'my-dev'")
+
+
+@pytest.mark.asyncio
+async def test_sync_agent_rejects_extensions_past_the_max_time_to_live():
+    core_v1 = MagicMock()
+    core_v1.read_namespaced_config_map.return_value.data = {"extendRequest": "17-3 30d"}
+    custom_objects_api = MagicMock()
+    status = {"activity": {"lastActivity": None}}
+
+    with patch.object(agent.client, "CoreV1Api", return_value=core_v1), patch.object(
+        agent.client, "CustomObjectsApi", return_value=custom_objects_api
+    ):
+        activity = await agent.sync_agent("my-dev", "dev-alice", SPEC, META, status, MagicMock())
+
+    assert activity is None
+    custom_objects_api.patch_namespaced_custom_object.assert_not_called()
+    result = core_v1.patch_namespaced_config_map.call_args.kwargs["body"]["data"]["extendResult"]
+    assert result.startswith("17-3 error DevServers cannot live longer than")
+    assert '"' not in result
+
+    core_v1.read_namespaced_config_map.side_effect = ApiException(status=404)
+    with patch.object(agent.client, "CoreV1Api", return_value=core_v1):
+        assert await agent.sync_agent("my-dev", "dev-alice", SPEC, META, {}, MagicMock()) is None
+
+
+def test_build_statefulset_mounts_the_agent():
+    statefulset = build_statefulset("my-dev", "dev-alice", {}, FLAVOR, agent=True)
+
+    pod_spec = statefulset["spec"]["template"]["spec"]
+    volumes = {v["name"]: v for v in pod_spec["volumes"]}
+    assert volumes["agent"]["configMap"]["name"] == "my-dev-agent-script"
+    token_sources = volumes["agent-token"]["projected"]["sources"]
+    assert token_sources[0]["serviceAccountToken"]["path"] == "token"
+    container = pod_spec["containers"][0]
+    assert {
+        "name": "DEVSERVER_AGENT", "value": "/devserver-agent/devserver-agent"
+    } in container["env"]
+    mounts = {m["name"]: m["mountPath"] for m in container["volumeMounts"]}
+    assert mounts["agent-token"] == "/var/run/secrets/devserver-agent"
+
+    statefulset = build_statefulset("my-dev", "dev-alice", {}, FLAVOR)
+    volumes = statefulset["spec"]["template"]["spec"]["volumes"]
+    assert "agent" not in [v["name"] for v in volumes]
+
+
+def test_build_resources_limits_the_agent_to_its_report_configmap():
+    reconciler = DevServerReconciler("my-dev", "dev-alice", {}, FLAVOR)
+    assert "agent_role" not in reconciler.build_resources()
+
+    reconciler.agent = True
+    resources = reconciler.build_resources()
+
+    assert "devserver-agent" in resources["agent_script_configmap"]["data"]
+    assert "data" not in resources["agent_report_configmap"]
+    [rule] = resources["agent_role"]["rules"]
+    assert rule["resourceNames"] == ["my-dev-agent"]
+    assert rule["verbs"] == ["get", "patch"]
+    assert resources["agent_role_binding"]["subjects"][0]["name"] == (
+        resources["service_account"]["metadata"]["name"]
+    )
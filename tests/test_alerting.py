@@ -0,0 +1,100 @@
+from unittest.mock import AsyncMock, MagicMock, patch
+
+import pytest
+
+from devservers.operator import alerting
+from devservers.operator.alerting import Alerter
+from devservers.operator.devserverflavor.reconciler import DevServerFlavorReconciler
+
+
+def _alerter(sink, now, **kwargs):
+    return Alerter([sink], window_seconds=600, cooldown_seconds=3600, clock=lambda: now[0], **kwargs)
+
+
+@pytest.mark.asyncio
+async def test_reconcile_errors_alert_once_they_spike():
+    sink = AsyncMock(__name__="sink")
+    now = [0.0]
+    alerter = _alerter(sink, now, reconcile_errors=3)
+
+    for _ in range(3):
+        await alerter.reconcile_failed("create_or_update_devserver", RuntimeError("boom"))
+    sink.assert_not_awaited()
+
+    await alerter.reconcile_failed("create_or_update_devserver", RuntimeError("boom"))
+    alert = sink.await_args.args[1]
+    assert alert.name == "ReconcileErrors"
+    assert alert.severity == alerting.SEVERITY_CRITICAL
+    assert alert.details["count"] == 4
+
+    # Errors spread out over more than the window do not add up
+    now[0] = 10000
+    for _ in range(3):
+        now[0] += 300
+        await alerter.reconcile_failed("create_or_update_devserver", RuntimeError("boom"))
+    sink.assert_awaited_once()
+
+
+@pytest.mark.asyncio
+async def test_alerts_are_not_repeated_within_the_cooldown():
+    sink = AsyncMock(__name__="sink")
+    now = [0.0]
+    alerter = _alerter(sink, now, capacity_failures=0)
+
+    await alerter.capacity_check_failed("gpu-a100", "No node fits.")
+    await alerter.capacity_check_failed("gpu-a100", "No node fits.")
+    await alerter.capacity_check_failed("gpu-h100", "No node fits.")
+    assert sink.await_count == 2
+
+    now[0] = 3600
+    await alerter.capacity_check_failed("gpu-a100", "No node fits.")
+    assert sink.await_count == 3
+
+
+@pytest.mark.asyncio
+async def test_failing_sinks_do_not_fail_the_alert():
+    failing = AsyncMock(__name__="failing", side_effect=RuntimeError("unreachable"))
+    sink = AsyncMock(__name__="sink")
+    alerter = Alerter([failing, sink])
+
+    assert await alerter.fire(
+        alerting.Alert("FlavorMissing", "key", "summary", alerting.SEVERITY_ERROR, {})
+    )
+    sink.assert_awaited_once()
+
+
+@pytest.mark.asyncio
+async def test_pagerduty_event():
+    response = MagicMock()
+    session = MagicMock()
+    session.post.return_value.__aenter__ = AsyncMock(return_value=response)
+    session.post.return_value.__aexit__ = AsyncMock(return_value=False)
+    alert = alerting.Alert(
+        "FlavorMissing", "devserver-operator/FlavorMissing/gpu", "gone", "error", {"flavor": "gpu"}
+    )
+
+    with patch.object(alerting, "ALERT_PAGERDUTY_ROUTING_KEY", "routing-key"):
+        await alerting.send_to_pagerduty(session, alert)
+
+    event = session.post.call_args.kwargs["json"]
+    assert event["routing_key"] == "routing-key"
+    assert event["event_action"] == "trigger"
+    assert event["dedup_key"] == "devserver-operator/FlavorMissing/gpu"
+    assert event["payload"]["severity"] == "error"
+    response.raise_for_status.assert_called_once()
+
+
+@pytest.mark.asyncio
+async def test_flavor_reconciler_alerts_about_flavors_in_use_that_were_deleted():
+    reconciler = DevServerFlavorReconciler(MagicMock(), MagicMock(), MagicMock())
+    devservers = [
+        {"metadata": {"name": "a", "namespace": "ml"}, "status": {"flavor": "gpu-a100"}},
+        {"metadata": {"name": "b", "namespace": "ml"}, "status": {"flavor": "cpu-small"}},
+        # Never provisioned, e.g. with a misspelled flavor
+        {"metadata": {"name": "c", "namespace": "ml"}, "spec": {"flavor": "gpu-a10O"}},
+    ]
+
+    with patch.object(alerting.ALERTER, "flavor_missing", AsyncMock()) as flavor_missing:
+        await reconciler.alert_missing_flavors({"cpu-small": {}}, devservers)
+
+    flavor_missing.assert_awaited_once_with("gpu-a100", ["ml/a"])
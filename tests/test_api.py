@@ -0,0 +1,413 @@
+import base64
+import json
+import time
+from unittest.mock import MagicMock
+
+import aiohttp
+import pytest
+import pytest_asyncio
+import rsa
+from aiohttp import web
+
+from devservers.api import server
+from devservers.api.auth import AuthenticationError, OIDCVerifier
+
+ISSUER = "https://login.example.com"
+AUDIENCE = "devservers"
+
+PUBLIC_KEY, PRIVATE_KEY = rsa.newkeys(1024)
+
+
+def _b64url(data: bytes) -> str:
+    return base64.urlsafe_b64encode(data).rstrip(b"=").decode()
+
+
+def _jwk(public_key: rsa.PublicKey) -> dict:
+    def encode(value: int) -> str:
+        return _b64url(value.to_bytes((value.bit_length() + 7) // 8, "big"))
+
+    return {"kty": "RSA", "kid": "key-1", "n": encode(public_key.n), "e": encode(public_key.e)}
+
+
+def _sign(header, claims) -> str:
+    encoded_header = _b64url(json.dumps(header).encode())
+    payload = _b64url(json.dumps(claims).encode())
+    signature = rsa.sign(f"{encoded_header}.{payload}".encode(), PRIVATE_KEY, "SHA-256")
+    return f"{encoded_header}.{payload}.{_b64url(signature)}"
+
+
+def _token(**overrides) -> str:
+    claims = {
+        "iss": ISSUER,
+        "aud": AUDIENCE,
+        "exp": time.time() + 300,
+        "preferred_username": "alice",
+        **overrides,
+    }
+    return _sign({"alg": "RS256", "kid": "key-1"}, claims)
+
+
+@pytest.fixture
+def verifier(monkeypatch) -> OIDCVerifier:
+    verifier = OIDCVerifier(ISSUER, AUDIENCE)
+
+    async def fetch_keys():
+        return [_jwk(PUBLIC_KEY)]
+
+    monkeypatch.setattr(verifier, "_fetch_keys", fetch_keys)
+    return verifier
+
+
+@pytest.mark.asyncio
+async def test_verify_accepts_valid_token(verifier):
+    claims = await verifier.verify(_token())
+    assert verifier.username(claims) == "alice"
+
+
+@pytest.mark.asyncio
+@pytest.mark.parametrize(
+    "overrides",
+    [{"aud": "other"}, {"iss": "https://evil.example.com"}, {"exp": time.time() - 3600}],
+)
+async def test_verify_rejects_untrusted_claims(verifier, overrides):
+    with pytest.raises(AuthenticationError):
+        await verifier.verify(_token(**overrides))
+
+
+@pytest.mark.asyncio
+@pytest.mark.parametrize(
+    "header, claims",
+    [
+        ([], {"iss": ISSUER}),
+        ({"alg": "RS256", "kid": "key-1"}, []),
+        ({"alg": "RS256", "kid": ["key-1"]}, {"iss": ISSUER}),
+        ({"alg": "RS256", "kid": "key-1"}, {"iss": 1, "aud": AUDIENCE}),
+        ({"alg": "RS256", "kid": "key-1"}, {"iss": ISSUER, "aud": 1}),
+        ({"alg": "RS256", "kid": "key-1"}, {"iss": ISSUER, "aud": AUDIENCE, "exp": "never"}),
+        (
+            {"alg": "RS256", "kid": "key-1"},
+            {"iss": ISSUER, "aud": AUDIENCE, "exp": time.time() + 300, "nbf": "now"},
+        ),
+    ],
+)
+async def test_verify_rejects_malformed_claims(verifier, header, claims):
+    with pytest.raises(AuthenticationError):
+        await verifier.verify(_sign(header, claims))
+
+
+@pytest.mark.asyncio
+async def test_verify_rejects_tampered_token(verifier):
+    header, _, signature = _token().split(".")
+    payload = _b64url(json.dumps({"iss": ISSUER, "aud": AUDIENCE, "exp": 2**40}).encode())
+    with pytest.raises(AuthenticationError):
+        await verifier.verify(f"{header}.{payload}.{signature}")
+
+
+@pytest.mark.asyncio
+async def test_verify_rejects_signatures_with_other_hashes(verifier):
+    header = _b64url(json.dumps({"alg": "RS256", "kid": "key-1"}).encode())
+    payload = _b64url(
+        json.dumps({"iss": ISSUER, "aud": AUDIENCE, "exp": time.time() + 300}).encode()
+    )
+    signature = rsa.sign(f"{header}.{payload}".encode(), PRIVATE_KEY, "SHA-1")
+    with pytest.raises(AuthenticationError):
+        await verifier.verify(f"{header}.{payload}.{_b64url(signature)}")
+
+
+@pytest.mark.asyncio
+async def test_unknown_keys_do_not_refetch_the_keys_every_time(verifier, monkeypatch):
+    fetches = []
+
+    async def fetch_keys():
+        fetches.append(1)
+        return [_jwk(PUBLIC_KEY)]
+
+    monkeypatch.setattr(verifier, "_fetch_keys", fetch_keys)
+    for kid in ["unknown-1", "unknown-2", "unknown-3"]:
+        with pytest.raises(AuthenticationError):
+            await verifier._get_key(kid)
+    assert len(fetches) == 1
+
+
+def test_user_namespace():
+    assert server.user_namespace("alice") == "dev-alice"
+    assert server.user_namespace("Alice@example.com") == "dev-alice-example-com"
+
+
+def _devserver(name="my-dev", ttl="4h"):
+    return {
+        "metadata": {
+            "name": name,
+            "namespace": "dev-alice",
+            "creationTimestamp": "2024-01-01T00:00:00Z",
+        },
+        "spec": {"owner": "alice", "flavor": "cpu-small", "lifecycle": {"timeToLive": ttl}},
+        "status": {"phase": "Running", "ready": True, "sshEndpoint": "10.0.0.5:31022"},
+    }
+
+
+@pytest_asyncio.fixture
+async def api(verifier, monkeypatch):
+    custom_objects_api = MagicMock()
+    monkeypatch.setattr(server.client, "CustomObjectsApi", lambda *args: custom_objects_api)
+
+    runner = web.AppRunner(server.create_app(verifier))
+    await runner.setup()
+    site = web.TCPSite(runner, "127.0.0.1", 0)
+    await site.start()
+    port = runner.addresses[0][1]
+    try:
+        async with aiohttp.ClientSession(
+            base_url=f"http://127.0.0.1:{port}",
+            headers={"Authorization": f"Bearer {_token()}"},
+        ) as session:
+            yield session, custom_objects_api
+    finally:
+        await runner.cleanup()
+
+
+@pytest.mark.asyncio
+async def test_requests_without_token_are_unauthorized(api):
+    session, _ = api
+    async with session.get("/api/v1/devservers", headers={"Authorization": ""}) as response:
+        assert response.status == 401
+
+
+@pytest.mark.asyncio
+async def test_list_only_returns_the_users_namespace(api):
+    session, custom_objects_api = api
+    custom_objects_api.list_namespaced_custom_object.return_value = {"items": [_devserver()]}
+
+    async with session.get("/api/v1/devservers") as response:
+        assert response.status == 200
+        body = await response.json()
+
+    assert [item["name"] for item in body["items"]] == ["my-dev"]
+    kwargs = custom_objects_api.list_namespaced_custom_object.call_args.kwargs
+    assert kwargs["namespace"] == "dev-alice"
+
+
+@pytest.mark.asyncio
+async def test_devservers_of_other_owners_in_the_namespace_are_hidden(api):
+    session, custom_objects_api = api
+    # "alice.smith" also maps to a namespace shared with "alice-smith"
+    other = _devserver(name="their-dev")
+    other["spec"]["owner"] = "alice.smith"
+    custom_objects_api.list_namespaced_custom_object.return_value = {
+        "items": [_devserver(), other]
+    }
+    custom_objects_api.get_namespaced_custom_object.return_value = other
+
+    async with session.get("/api/v1/devservers") as response:
+        body = await response.json()
+    async with session.delete("/api/v1/devservers/their-dev") as response:
+        assert response.status == 404
+
+    assert [item["name"] for item in body["items"]] == ["my-dev"]
+    custom_objects_api.delete_namespaced_custom_object.assert_not_called()
+
+
+@pytest.mark.asyncio
+async def test_list_templates(api):
+    session, custom_objects_api = api
+    custom_objects_api.list_cluster_custom_object.side_effect = [
+        {
+            "items": [
+                {
+                    "metadata": {"name": "pytorch"},
+                    "spec": {"defaultFlavor": "gpu-small", "spec": {"image": "pytorch"}},
+                }
+            ]
+        },
+        {"items": [{"metadata": {"name": "gpu-small"}, "spec": {"costPerHour": "1.5"}}]},
+    ]
+
+    async with session.get("/api/v1/templates") as response:
+        assert response.status == 200
+        body = await response.json()
+
+    assert body["items"] == [
+        {
+            "name": "pytorch",
+            "description": "",
+            "defaultFlavor": "gpu-small",
+            "image": "pytorch",
+            "hourlyCost": 1.5,
+        }
+    ]
+
+
+@pytest.mark.asyncio
+async def test_create_sets_owner(api):
+    session, custom_objects_api = api
+    custom_objects_api.create_namespaced_custom_object.side_effect = lambda **kwargs: {
+        **kwargs["body"],
+        "status": {},
+    }
+
+    async with session.post(
+        "/api/v1/devservers",
+        json={"name": "my-dev", "flavor": "cpu-small", "sshPublicKey": "ssh-ed25519 AAAA"},
+    ) as response:
+        assert response.status == 201
+
+    body = custom_objects_api.create_namespaced_custom_object.call_args.kwargs["body"]
+    assert body["metadata"] == {"name": "my-dev", "namespace": "dev-alice"}
+    assert body["spec"]["owner"] == "alice"
+    assert body["spec"]["lifecycle"]["timeToLive"] == "4h"
+
+
+@pytest.mark.asyncio
+async def test_create_impersonates_the_user(api, monkeypatch):
+    session, custom_objects_api = api
+    api_clients = []
+    monkeypatch.setattr(
+        server.client,
+        "CustomObjectsApi",
+        lambda *args: api_clients.extend(args) or custom_objects_api,
+    )
+    custom_objects_api.create_namespaced_custom_object.side_effect = lambda **kwargs: {
+        **kwargs["body"],
+        "status": {},
+    }
+
+    async with session.post(
+        "/api/v1/devservers",
+        json={"name": "my-dev", "flavor": "cpu-small", "sshPublicKey": "ssh-ed25519 AAAA"},
+    ) as response:
+        assert response.status == 201
+
+    assert [api_client.default_headers["Impersonate-User"] for api_client in api_clients] == [
+        "alice"
+    ]
+
+
+@pytest.mark.asyncio
+async def test_create_with_generate_name(api):
+    session, custom_objects_api = api
+    custom_objects_api.create_namespaced_custom_object.side_effect = lambda **kwargs: {
+        **kwargs["body"],
+        "metadata": {**kwargs["body"]["metadata"], "name": "alice-x7k2p"},
+        "status": {},
+    }
+
+    async with session.post(
+        "/api/v1/devservers",
+        json={"generateName": "alice-", "flavor": "cpu-small", "sshPublicKey": "ssh-ed25519 AAAA"},
+    ) as response:
+        assert response.status == 201
+        assert (await response.json())["name"] == "alice-x7k2p"
+
+    body = custom_objects_api.create_namespaced_custom_object.call_args.kwargs["body"]
+    assert body["metadata"] == {"generateName": "alice-", "namespace": "dev-alice"}
+
+    async with session.post(
+        "/api/v1/devservers",
+        json={"generateName": "a" * 48, "sshPublicKey": "ssh-ed25519 AAAA"},
+    ) as response:
+        assert response.status == 400
+
+
+@pytest.mark.asyncio
+async def test_create_from_template(api):
+    session, custom_objects_api = api
+    custom_objects_api.create_namespaced_custom_object.side_effect = lambda **kwargs: {
+        **kwargs["body"],
+        "status": {},
+    }
+
+    async with session.post(
+        "/api/v1/devservers",
+        json={"name": "my-dev", "template": "pytorch-gpu", "sshPublicKey": "ssh-ed25519 AAAA"},
+    ) as response:
+        assert response.status == 201
+
+    spec = custom_objects_api.create_namespaced_custom_object.call_args.kwargs["body"]["spec"]
+    assert spec["template"] == "pytorch-gpu"
+    # The operator takes both from the template
+    assert "flavor" not in spec and "lifecycle" not in spec
+
+
+@pytest.mark.asyncio
+async def test_ssh_info(api):
+    session, custom_objects_api = api
+    custom_objects_api.get_namespaced_custom_object.return_value = _devserver()
+
+    async with session.get("/api/v1/devservers/my-dev/ssh") as response:
+        body = await response.json()
+
+    assert body["sshEndpoint"] == "10.0.0.5:31022"
+    assert body["user"] == "dev"
+
+
+@pytest.mark.asyncio
+async def test_extend(api):
+    session, custom_objects_api = api
+    custom_objects_api.get_namespaced_custom_object.return_value = _devserver(ttl="4h")
+    custom_objects_api.patch_namespaced_custom_object.return_value = _devserver(ttl="1d4h")
+
+    async with session.post("/api/v1/devservers/my-dev/extend", json={"duration": "1d"}) as response:
+        assert response.status == 200
+        body = await response.json()
+
+    assert body["expiresAt"] == "2024-01-02T04:00:00Z"
+    patch_body = custom_objects_api.patch_namespaced_custom_object.call_args.kwargs["body"]
+    assert patch_body == {"spec": {"lifecycle": {"timeToLive": "1d4h"}}}
+
+
+@pytest.mark.asyncio
+async def test_extend_past_the_maximum_ttl_is_rejected(api):
+    session, custom_objects_api = api
+    custom_objects_api.get_namespaced_custom_object.return_value = _devserver(ttl="6d")
+
+    async with session.post("/api/v1/devservers/my-dev/extend", json={"duration": "2d"}) as response:
+        assert response.status == 422
+        assert "at most 1d" in (await response.json())["error"]
+    custom_objects_api.patch_namespaced_custom_object.assert_not_called()
+
+
+@pytest.mark.asyncio
+async def test_extend_without_extensions_left_is_rejected(api):
+    session, custom_objects_api = api
+    custom_objects_api.get_namespaced_custom_object.return_value = {
+        **_devserver(ttl="4h"),
+        "status": {"lifecycleLimits": {"template": "pytorch-gpu", "extensionsRemaining": 0}},
+    }
+
+    async with session.post("/api/v1/devservers/my-dev/extend", json={"duration": "1h"}) as response:
+        assert response.status == 422
+        assert "pytorch-gpu" in (await response.json())["error"]
+    custom_objects_api.patch_namespaced_custom_object.assert_not_called()
+
+
+@pytest.mark.asyncio
+async def test_hibernate_and_resume(api):
+    session, custom_objects_api = api
+    custom_objects_api.get_namespaced_custom_object.return_value = _devserver()
+    custom_objects_api.patch_namespaced_custom_object.return_value = _devserver()
+
+    for action, hibernated in [("hibernate", True), ("resume", False)]:
+        async with session.post(f"/api/v1/devservers/my-dev/{action}") as response:
+            assert response.status == 200
+        patch_body = custom_objects_api.patch_namespaced_custom_object.call_args.kwargs["body"]
+        assert patch_body == {"spec": {"hibernated": hibernated}}
+
+
+@pytest.mark.asyncio
+async def test_delete(api):
+    session, custom_objects_api = api
+    custom_objects_api.get_namespaced_custom_object.return_value = _devserver()
+
+    async with session.delete("/api/v1/devservers/my-dev") as response:
+        assert response.status == 204
+
+    kwargs = custom_objects_api.delete_namespaced_custom_object.call_args.kwargs
+    assert (kwargs["namespace"], kwargs["name"]) == ("dev-alice", "my-dev")
+
+
+@pytest.mark.asyncio
+async def test_dashboard_is_served(api):
+    session, _ = api
+    async with session.get("/", headers={"Authorization": ""}) as response:
+        assert response.status == 200
+        assert "<title>DevServers</title>" in await response.text()
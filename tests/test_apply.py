@@ -0,0 +1,56 @@
+import pytest
+
+from devservers.operator.devserver.apply import (
+    APPLIED_HASH_ANNOTATION,
+    is_up_to_date,
+    semantically_contains,
+    with_applied_hash,
+)
+
+CONFIGMAP = {
+    "apiVersion": "v1",
+    "kind": "ConfigMap",
+    "metadata": {"name": "dev-sshd-config", "labels": {"team": "ml"}},
+    "data": {"sshd_config": "Port 22\n"},
+}
+
+
+@pytest.mark.parametrize(
+    "actual, desired, expected",
+    [
+        # Fields defaulted by the API server or set by others are ignored
+        ({"a": 1, "b": 2}, {"a": 1}, True),
+        ({"a": 1}, {"a": 2}, False),
+        ({"a": 1}, {"a": 1, "b": 2}, False),
+        # Empty and unset fields are dropped by the API server
+        ({}, {"a": {}, "b": [], "c": None}, True),
+        ({"resources": {"cpu": "1"}}, {"resources": {"cpu": "1000m"}}, True),
+        ({"memory": "1Gi"}, {"memory": "1073741824"}, True),
+        ([{"name": "a", "x": 1}], [{"name": "a"}], True),
+        ([{"name": "a"}], [{"name": "a"}, {"name": "b"}], False),
+        ({"image": "a"}, {"image": "b"}, False),
+    ],
+)
+def test_semantically_contains(actual, desired, expected):
+    assert semantically_contains(actual, desired) is expected
+
+
+def test_is_up_to_date():
+    body = with_applied_hash(CONFIGMAP)
+    live = {**body, "metadata": {**body["metadata"], "resourceVersion": "7"}}
+
+    assert is_up_to_date(live, body)
+    assert not is_up_to_date(None, body)
+    # Changed behind the operator's back
+    assert not is_up_to_date({**live, "data": {"sshd_config": "Port 2222\n"}}, body)
+    # A field the operator stopped setting is still on the live object
+    without_labels = with_applied_hash({**CONFIGMAP, "metadata": {"name": "dev-sshd-config"}})
+    assert not is_up_to_date(live, without_labels)
+
+
+def test_with_applied_hash_does_not_modify_the_body():
+    body = with_applied_hash(CONFIGMAP)
+
+    assert APPLIED_HASH_ANNOTATION in body["metadata"]["annotations"]
+    assert "annotations" not in CONFIGMAP["metadata"]
+    assert with_applied_hash(CONFIGMAP) == body
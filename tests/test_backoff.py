@@ -0,0 +1,54 @@
+from unittest.mock import MagicMock
+
+import kopf
+import pytest
+from kubernetes.client.rest import ApiException
+
+from devservers.operator import backoff
+
+
+@pytest.fixture(autouse=True)
+def default_backoff():
+    backoff.configure_backoff(5, 300)
+    yield
+    backoff.configure_backoff(backoff.DEFAULT_RETRY_BASE_DELAY, backoff.DEFAULT_RETRY_MAX_DELAY)
+
+
+@pytest.mark.parametrize("retry, delay", [(0, 5), (1, 10), (3, 40), (6, 300), (20, 300)])
+def test_retry_delay_grows_exponentially_up_to_the_maximum(retry, delay):
+    assert backoff.retry_delay(retry) == delay
+
+
+def test_retry_delay_honours_retry_after_of_throttled_requests():
+    error = ApiException(status=429)
+    error.headers = {"Retry-After": "30"}
+
+    assert backoff.retry_delay(0, error) == 30
+    assert backoff.retry_delay(6, error) == 300
+
+
+@pytest.mark.asyncio
+async def test_unexpected_errors_are_retried_with_backoff():
+    @backoff.with_backoff
+    async def handler(**kwargs):
+        raise ApiException(status=500)
+
+    with pytest.raises(kopf.TemporaryError) as exc_info:
+        await handler(retry=2, logger=MagicMock())
+
+    assert exc_info.value.delay == 20
+
+
+@pytest.mark.asyncio
+@pytest.mark.parametrize(
+    "error", [kopf.PermanentError("invalid"), kopf.TemporaryError("waiting", delay=1)]
+)
+async def test_kopf_errors_are_not_changed(error):
+    @backoff.with_backoff
+    async def handler(**kwargs):
+        raise error
+
+    with pytest.raises(type(error)) as exc_info:
+        await handler(retry=2)
+
+    assert exc_info.value is error
@@ -0,0 +1,80 @@
+from datetime import datetime, timezone
+from unittest.mock import AsyncMock, MagicMock
+
+import pytest
+
+from devservers.operator.devserver.backup import (
+    SCHEDULED_SNAPSHOT_LABEL,
+    run_scheduled_backup,
+    snapshots_to_prune,
+)
+
+NOW = datetime(2024, 1, 10, 3, 0, 30, tzinfo=timezone.utc)
+SPEC = {
+    "persistentHome": {"enabled": True},
+    "backup": {"schedule": "0 3 * * *", "retention": {"keepLast": 2}},
+}
+META = {"creationTimestamp": "2024-01-01T12:00:00Z"}
+
+
+def _snapshot(name, created):
+    return {"metadata": {"name": name, "creationTimestamp": created}}
+
+
+def _recorder():
+    return MagicMock(normal=AsyncMock(), warning=AsyncMock())
+
+
+@pytest.mark.asyncio
+async def test_scheduled_backup_takes_snapshot_and_prunes():
+    custom_objects_api = MagicMock()
+    custom_objects_api.list_namespaced_custom_object.return_value = {
+        "items": [
+            _snapshot("my-dev-20240108-0300", "2024-01-08T03:00:00Z"),
+            _snapshot("my-dev-20240109-0300", "2024-01-09T03:00:00Z"),
+            _snapshot("my-dev-20240110-0300", "2024-01-10T03:00:30Z"),
+        ]
+    }
+    status = {"backup": {"lastScheduleTime": "2024-01-09T03:00:10Z"}}
+
+    backup_status = await run_scheduled_backup(
+        "my-dev", "test-ns", SPEC, META, status, MagicMock(), _recorder(), {},
+        now=NOW, custom_objects_api=custom_objects_api,
+    )
+
+    body = custom_objects_api.create_namespaced_custom_object.call_args.kwargs["body"]
+    assert body["metadata"]["name"] == "my-dev-20240110-0300"
+    assert body["metadata"]["labels"] == {SCHEDULED_SNAPSHOT_LABEL: "my-dev"}
+    assert body["spec"] == {"devServerName": "my-dev"}
+    deleted = custom_objects_api.delete_namespaced_custom_object.call_args.kwargs["name"]
+    assert deleted == "my-dev-20240108-0300"
+    assert backup_status == {
+        "lastScheduleTime": "2024-01-10T03:00:30Z",
+        "lastSnapshotName": "my-dev-20240110-0300",
+        "nextScheduleTime": "2024-01-11T03:00:00Z",
+    }
+
+
+@pytest.mark.asyncio
+async def test_scheduled_backup_waits_for_schedule():
+    custom_objects_api = MagicMock()
+    status = {"backup": {"lastScheduleTime": "2024-01-10T03:00:00Z"}}
+
+    backup_status = await run_scheduled_backup(
+        "my-dev", "test-ns", SPEC, META, status, MagicMock(), _recorder(), {},
+        now=NOW, custom_objects_api=custom_objects_api,
+    )
+
+    custom_objects_api.create_namespaced_custom_object.assert_not_called()
+    assert backup_status["nextScheduleTime"] == "2024-01-11T03:00:00Z"
+
+
+def test_snapshots_to_prune_by_max_age():
+    snapshots = [
+        _snapshot("old", "2023-12-01T03:00:00Z"),
+        _snapshot("new", "2024-01-09T03:00:00Z"),
+    ]
+
+    pruned = snapshots_to_prune(snapshots, {"keepLast": 5, "maxAge": "14d"}, NOW)
+
+    assert [s["metadata"]["name"] for s in pruned] == ["old"]
@@ -0,0 +1,53 @@
+from devservers.operator.devserver.resources.bastion import (
+    build_bastion_configmap,
+    render_authorized_keys,
+)
+
+
+def _devserver(name, public_key="", port=None, host_keys=None):
+    ssh = {"publicKey": public_key}
+    if port:
+        ssh["port"] = port
+    return {
+        "metadata": {"name": name, "namespace": "dev-alice"},
+        "spec": {"ssh": ssh},
+        "status": {"sshHostKeys": host_keys or []},
+    }
+
+
+def test_keys_may_only_open_their_own_devservers():
+    authorized_keys = render_authorized_keys(
+        [
+            _devserver("one", "ssh-ed25519 AAAAalice alice@laptop"),
+            _devserver("two", "ssh-ed25519 AAAAalice alice@laptop", port=2222),
+            _devserver("three", "ssh-ed25519 AAAAbob"),
+            _devserver("no-key"),
+        ]
+    )
+
+    assert authorized_keys.splitlines() == [
+        'restrict,port-forwarding,permitopen="one-0.one-headless.dev-alice.svc.cluster.local:22",'
+        'permitopen="two-0.two-headless.dev-alice.svc.cluster.local:2222" '
+        "ssh-ed25519 AAAAalice alice@laptop",
+        'restrict,port-forwarding,permitopen="three-0.three-headless.dev-alice.svc.cluster.local:22" '
+        "ssh-ed25519 AAAAbob",
+    ]
+
+
+def test_client_config_and_known_hosts():
+    configmap = build_bastion_configmap(
+        "dev-alice",
+        [_devserver("one", "ssh-ed25519 AAAAalice", port=2222, host_keys=["ssh-ed25519 HOSTKEY"])],
+        bastion_endpoint="bastion.example.com:22",
+        bastion_host_keys=["ssh-ed25519 BASTIONKEY"],
+    )
+
+    ssh_config = configmap["data"]["ssh_config"]
+    assert "Host devserver-bastion-dev-alice\n" in ssh_config
+    assert "    HostName bastion.example.com\n" in ssh_config
+    assert "    ProxyJump devserver-bastion-dev-alice\n" in ssh_config
+
+    assert configmap["data"]["known_hosts"].splitlines() == [
+        "bastion.example.com ssh-ed25519 BASTIONKEY",
+        "[one-0.one-headless.dev-alice.svc.cluster.local]:2222 ssh-ed25519 HOSTKEY",
+    ]
@@ -0,0 +1,98 @@
+from datetime import datetime, timedelta, timezone
+from unittest.mock import MagicMock, patch
+
+import pytest
+from kubernetes.client.rest import ApiException
+
+from devservers.operator.devserver import capacity
+
+FLAVOR = {"metadata": {"name": "gpu-a100"}, "spec": {"resources": {"requests": {"cpu": "8"}}}}
+
+
+def _patch_cluster(statefulset_exists, schedulability):
+    apps_v1 = MagicMock()
+    if not statefulset_exists:
+        apps_v1.read_namespaced_stateful_set.side_effect = ApiException(status=404)
+    reconciler = MagicMock()
+    reconciler.get_flavor_schedulability.return_value = schedulability
+    return (
+        patch.object(capacity.client, "AppsV1Api", return_value=apps_v1),
+        patch.object(capacity, "DevServerFlavorReconciler", return_value=reconciler),
+    )
+
+
+@pytest.mark.asyncio
+async def test_new_devserver_waits_when_no_node_has_room():
+    apps_v1_patch, reconciler_patch = _patch_cluster(False, "No")
+    with apps_v1_patch, reconciler_patch:
+        shortage = await capacity.find_capacity_shortage("dev", "ns", FLAVOR, MagicMock())
+
+    assert shortage is not None
+    assert "gpu-a100" in shortage
+
+
+@pytest.mark.asyncio
+@pytest.mark.parametrize(
+    "statefulset_exists, schedulability",
+    [(False, "Yes"), (False, "AUTOSCALED"), (True, "No")],
+)
+async def test_devserver_is_not_held_back(statefulset_exists, schedulability):
+    apps_v1_patch, reconciler_patch = _patch_cluster(statefulset_exists, schedulability)
+    with apps_v1_patch, reconciler_patch:
+        assert await capacity.find_capacity_shortage("dev", "ns", FLAVOR, MagicMock()) is None
+
+
+FALLBACK = {"metadata": {"name": "gpu-l4"}, "spec": {"resources": {"requests": {"cpu": "4"}}}}
+CREATED = datetime(2026, 1, 1, tzinfo=timezone.utc)
+META = {"creationTimestamp": CREATED.isoformat()}
+SPEC = {"flavor": "gpu-a100", "flavorFallbacks": ["gpu-l4"], "flavorFallbackTimeout": "10m"}
+
+
+async def _choose_flavor(fallback, waited, schedulability):
+    async def shortage(name, namespace, flavor, logger):
+        if schedulability[flavor["metadata"]["name"]] == "No":
+            return "No room."
+        return None
+
+    with patch.object(capacity, "find_capacity_shortage", side_effect=shortage), patch.object(
+        capacity, "get_flavor", return_value=fallback
+    ):
+        return await capacity.choose_flavor(
+            "dev", "ns", SPEC, META, FLAVOR, MagicMock(), now=CREATED + waited
+        )
+
+
+@pytest.mark.asyncio
+async def test_fallback_flavor_is_used_after_the_timeout():
+    flavor, shortage = await _choose_flavor(
+        FALLBACK, timedelta(minutes=11), {"gpu-a100": "No", "gpu-l4": "Yes"}
+    )
+
+    assert flavor is FALLBACK
+    assert shortage is None
+
+
+@pytest.mark.asyncio
+async def test_fallback_flavor_is_not_used_before_the_timeout():
+    flavor, shortage = await _choose_flavor(
+        FALLBACK, timedelta(minutes=5), {"gpu-a100": "No", "gpu-l4": "Yes"}
+    )
+
+    assert flavor is FLAVOR
+    assert "5m" in shortage
+
+
+@pytest.mark.asyncio
+@pytest.mark.parametrize(
+    "fallback, schedulability",
+    [
+        (FALLBACK, {"gpu-a100": "No", "gpu-l4": "No"}),
+        ({**FALLBACK, "spec": {"deprecated": True}}, {"gpu-a100": "No", "gpu-l4": "Yes"}),
+        ({**FALLBACK, "spec": {"allowedImages": ["ghcr.io/*"]}}, {"gpu-a100": "No", "gpu-l4": "Yes"}),
+    ],
+)
+async def test_unusable_fallback_flavor_is_skipped(fallback, schedulability):
+    flavor, shortage = await _choose_flavor(fallback, timedelta(minutes=11), schedulability)
+
+    assert flavor is FLAVOR
+    assert "fallback" in shortage
@@ -1,6 +1,7 @@
 import asyncio
 import pytest
-from unittest.mock import patch
+from datetime import datetime, timezone
+from unittest.mock import MagicMock, patch
 import io
 import sys
 import yaml
@@ -21,6 +22,11 @@ from tests.helpers import (
     wait_for_devserveruser_status,
 )
 from devservers.cli.config import Configuration
+from devservers.cli.utils import get_current_context
+from devservers.crds.base import KUBE_CONTEXT_ENV_VAR, ObjectMeta
+from devservers.crds.devserver import DevServer
+from devservers.cli.handlers.status import get_recent_events
+from devservers.utils.flavors import get_flavor_gpus
 from devservers.crds.const import (
     CRD_GROUP,
     CRD_VERSION,
@@ -318,7 +324,7 @@ class TestCliParser:
             "spec": {"default": True},
         }
 
-        async def mock_get_default_flavor():
+        async def mock_get_default_flavor(namespace=None):
             return default_flavor_obj
 
         # We need to mock the k8s object creation and the get_default_flavor function.
@@ -347,7 +353,7 @@ class TestCliParser:
         """Tests that 'create' command fails if no flavor is provided and no default exists."""
         runner = CliRunner()
 
-        async def mock_get_default_flavor_none():
+        async def mock_get_default_flavor_none(namespace=None):
             return None
 
         # Mock get_default_flavor to return None
@@ -376,6 +382,180 @@ class TestCliParser:
             # Verify the handler was called
             mock_list.assert_called_once()
 
+    def test_list_command_owner_and_all_namespaces(self) -> None:
+        """Tests that 'list' passes its owner and namespace filters through."""
+        runner = CliRunner()
+
+        with patch("devservers.cli.handlers.list_devservers") as mock_list:
+            result = runner.invoke(cli_main.main, ["list", "--owner", "me", "-A"])
+
+            assert result.exit_code == 0
+            call_kwargs = mock_list.call_args.kwargs
+            assert call_kwargs["owner"] == "me"
+            assert call_kwargs["all_namespaces"] is True
+
+    def test_create_command_positional_name_and_ttl(self) -> None:
+        """Tests that 'create' accepts the name positionally and a TTL in days."""
+        runner = CliRunner()
+
+        with patch("devservers.cli.handlers.create_devserver") as mock_create:
+            result = runner.invoke(
+                cli_main.main, ["create", "my-server", "--flavor", "gpu-small", "--ttl", "2d"]
+            )
+
+            assert result.exit_code == 0
+            call_kwargs = mock_create.call_args.kwargs
+            assert call_kwargs["name"] == "my-server"
+            assert call_kwargs["time_to_live"] == "2d"
+
+    def test_create_command_from_snapshot(self) -> None:
+        """Tests that 'create --from-snapshot' is passed to the handler."""
+        runner = CliRunner()
+
+        with patch("devservers.cli.handlers.create_devserver") as mock_create:
+            result = runner.invoke(
+                cli_main.main, ["create", "my-server", "--from-snapshot", "before-upgrade"]
+            )
+
+            assert result.exit_code == 0
+            assert mock_create.call_args.kwargs["from_snapshot"] == "before-upgrade"
+
+    @pytest.mark.parametrize(
+        "args, home_source",
+        [
+            (["--from-backup", "nightly"], {"backupRef": {"name": "nightly"}}),
+            (
+                ["--from-backup", "s3://backups/default/old/nightly.tar.gz"],
+                {"backupRef": {"url": "s3://backups/default/old/nightly.tar.gz"}},
+            ),
+            (["--from-devserver", "old"], {"fromDevServer": {"name": "old"}}),
+        ],
+    )
+    def test_create_command_home_source(
+        self, test_config: Configuration, args: list, home_source: dict
+    ) -> None:
+        """Tests that 'create --from-backup/--from-devserver' set spec.homeSource."""
+        runner = CliRunner()
+
+        with patch(
+            "kubernetes.client.CustomObjectsApi.create_namespaced_custom_object"
+        ) as mock_create_k8s:
+            result = runner.invoke(
+                cli_main.main, ["create", "my-server", "--flavor", "cpu-small", *args]
+            )
+
+            assert result.exit_code == 0, result.output
+            _, kwargs = mock_create_k8s.call_args
+            assert kwargs["body"]["spec"]["homeSource"] == home_source
+
+    def test_create_command_clone(self, test_config: Configuration) -> None:
+        """Tests that 'create --clone' sets spec.cloneFrom and leaves the rest to the operator."""
+        runner = CliRunner()
+
+        with patch(
+            "kubernetes.client.CustomObjectsApi.create_namespaced_custom_object"
+        ) as mock_create_k8s:
+            result = runner.invoke(cli_main.main, ["create", "my-debug", "--clone", "alice-dev"])
+
+            assert result.exit_code == 0, result.output
+            spec = mock_create_k8s.call_args.kwargs["body"]["spec"]
+            assert spec["cloneFrom"] == {"name": "alice-dev"}
+            assert "flavor" not in spec and "persistentHome" not in spec
+
+    def test_create_command_template(self, test_config: Configuration) -> None:
+        """Tests that 'create --template' leaves the flavor and TTL to the template."""
+        runner = CliRunner()
+
+        with patch(
+            "kubernetes.client.CustomObjectsApi.create_namespaced_custom_object"
+        ) as mock_create_k8s:
+            result = runner.invoke(
+                cli_main.main, ["create", "my-dev", "--template", "pytorch-gpu"]
+            )
+
+            assert result.exit_code == 0, result.output
+            spec = mock_create_k8s.call_args.kwargs["body"]["spec"]
+            assert spec["template"] == "pytorch-gpu"
+            assert "flavor" not in spec and "lifecycle" not in spec
+
+    def test_create_command_profile(self, test_config: Configuration) -> None:
+        """Tests that 'create --profile' sets spec.profile and leaves the flavor to it."""
+        runner = CliRunner()
+
+        with patch(
+            "kubernetes.client.CustomObjectsApi.create_namespaced_custom_object"
+        ) as mock_create_k8s:
+            result = runner.invoke(
+                cli_main.main, ["create", "my-dev", "--profile", "pytorch-nightly-a100"]
+            )
+
+            assert result.exit_code == 0, result.output
+            spec = mock_create_k8s.call_args.kwargs["body"]["spec"]
+            assert spec["profile"] == "pytorch-nightly-a100"
+            assert "flavor" not in spec
+
+    def test_delete_command_positional_name(self) -> None:
+        """Tests that 'delete' accepts the name positionally."""
+        runner = CliRunner()
+
+        with patch("devservers.cli.handlers.delete_devserver") as mock_delete:
+            result = runner.invoke(cli_main.main, ["delete", "my-server", "-n", "team"])
+
+            assert result.exit_code == 0
+            call_kwargs = mock_delete.call_args.kwargs
+            assert call_kwargs["name"] == "my-server"
+            assert call_kwargs["namespace"] == "team"
+
+    def test_extend_command_parsing(self) -> None:
+        """Tests that 'extend' takes the name and duration positionally."""
+        runner = CliRunner()
+
+        with patch("devservers.cli.handlers.extend_devserver") as mock_extend:
+            result = runner.invoke(cli_main.main, ["extend", "mydev", "24h"])
+
+            assert result.exit_code == 0
+            mock_extend.assert_called_once_with(name="mydev", duration="24h", namespace=None)
+
+    def test_hibernate_and_resume_command_parsing(self) -> None:
+        """Tests that 'hibernate' and 'resume' pass the name and wait flag through."""
+        runner = CliRunner()
+
+        with patch("devservers.cli.handlers.hibernate_devserver") as mock_hibernate:
+            result = runner.invoke(cli_main.main, ["hibernate", "mydev"])
+            assert result.exit_code == 0
+            mock_hibernate.assert_called_once_with(name="mydev", namespace=None)
+
+        with patch("devservers.cli.handlers.resume_devserver") as mock_resume:
+            result = runner.invoke(cli_main.main, ["resume", "mydev", "--wait"])
+            assert result.exit_code == 0
+            mock_resume.assert_called_once_with(name="mydev", namespace=None, wait=True)
+
+    def test_cp_and_sync_command_parsing(self) -> None:
+        """Tests that 'cp' and 'sync' take the source and destination positionally."""
+        runner = CliRunner()
+
+        with patch("devservers.cli.handlers.copy_files") as mock_copy:
+            result = runner.invoke(cli_main.main, ["cp", "./data", "mydev:work"])
+            assert result.exit_code == 0
+            call_kwargs = mock_copy.call_args.kwargs
+            assert (call_kwargs["source"], call_kwargs["destination"]) == ("./data", "mydev:work")
+
+        with patch("devservers.cli.handlers.sync_files") as mock_sync:
+            result = runner.invoke(cli_main.main, ["sync", "mydev:project", ".", "--delete"])
+            assert result.exit_code == 0
+            call_kwargs = mock_sync.call_args.kwargs
+            assert call_kwargs["source"] == "mydev:project"
+            assert call_kwargs["delete"] is True
+
+    def test_status_command_parsing(self) -> None:
+        """Tests that 'status' takes the name positionally and an events limit."""
+        runner = CliRunner()
+
+        with patch("devservers.cli.handlers.status_devserver") as mock_status:
+            result = runner.invoke(cli_main.main, ["status", "mydev", "--events", "5"])
+            assert result.exit_code == 0
+            mock_status.assert_called_once_with(name="mydev", namespace=None, events=5)
+
     def test_flavors_command_parsing(self) -> None:
         """Tests that 'flavors' command is recognized."""
         runner = CliRunner()
@@ -450,6 +630,18 @@ class TestCliParser:
             assert call_kwargs["no_proxy"] is True
             assert call_kwargs["remote_command"] == ("remote", "command")
 
+    def test_ssh_command_positional_name(self) -> None:
+        """Tests that 'ssh' takes the DevServer name from its first argument."""
+        runner = CliRunner()
+
+        with patch("devservers.cli.handlers.ssh_devserver") as mock_ssh:
+            result = runner.invoke(cli_main.main, ["ssh", "my-server", "--", "ls", "-la"])
+
+            assert result.exit_code == 0
+            call_kwargs = mock_ssh.call_args.kwargs
+            assert call_kwargs["name"] == "my-server"
+            assert call_kwargs["remote_command"] == ("ls", "-la")
+
     def test_ssh_proxy_command_parsing(self) -> None:
         """Tests that 'ssh-proxy' command arguments are parsed correctly."""
         runner = CliRunner()
@@ -642,14 +834,15 @@ async def test_create_and_list_with_operator(
             configuration=test_config,
             name=devserver_name,
             flavor="cli-test-flavor",
-            image="alpine:latest",
+            image="ubuntu:22.04",
             namespace=NAMESPACE,
             ssh_public_key_file=test_ssh_public_key,
         )
 
-        # Give the operator time to process and set the status to Running
+        # Running is only reported once the pod is ready, which includes
+        # pulling the image, so allow some extra time here.
         await wait_for_devserver_status(
-            custom_objects_api, name=devserver_name, namespace=NAMESPACE
+            custom_objects_api, name=devserver_name, namespace=NAMESPACE, timeout=180
         )
 
         # Verify it appears in the list command
@@ -687,3 +880,92 @@ async def test_create_and_list_with_operator(
         except client.ApiException as e:
             if e.status != 404:
                 raise
+
+
+def _devserver_with_ttl(ttl: str) -> DevServer:
+    devserver = DevServer(
+        metadata=ObjectMeta(name="mydev", namespace="ns"),
+        spec={"lifecycle": {"timeToLive": ttl}},
+        status={"expiresAt": "2024-01-01T04:00:00Z"},
+        api=MagicMock(),
+    )
+    devserver.patch = MagicMock()
+    return devserver
+
+
+def test_extend_devserver_bumps_ttl() -> None:
+    devserver = _devserver_with_ttl("4h")
+    with patch("devservers.cli.handlers.extend.get_current_context", return_value=("u", "ns")), \
+            patch("devservers.cli.handlers.extend.DevServer.get", return_value=devserver):
+        handlers.extend_devserver(name="mydev", duration="1d")
+
+    devserver.patch.assert_called_once_with({"spec": {"lifecycle": {"timeToLive": "1d4h"}}})
+
+
+def test_extend_devserver_enforces_max_ttl() -> None:
+    devserver = _devserver_with_ttl("6d")
+    with patch("devservers.cli.handlers.extend.get_current_context", return_value=("u", "ns")), \
+            patch("devservers.cli.handlers.extend.DevServer.get", return_value=devserver):
+        with pytest.raises(SystemExit):
+            handlers.extend_devserver(name="mydev", duration="2d")
+
+    devserver.patch.assert_not_called()
+
+
+def test_get_current_context_honors_context_override(monkeypatch) -> None:
+    contexts = [
+        {"name": "prod", "context": {"user": "alice", "namespace": "dev-alice"}},
+        {"name": "staging", "context": {"user": "alice-staging", "namespace": "team"}},
+    ]
+    monkeypatch.setattr(
+        "devservers.cli.utils.config.list_kube_config_contexts",
+        lambda config_file=None: (contexts, contexts[0]),
+    )
+
+    assert get_current_context() == ("alice", "dev-alice")
+    monkeypatch.setenv(KUBE_CONTEXT_ENV_VAR, "staging")
+    assert get_current_context() == ("alice-staging", "team")
+
+
+def test_get_flavor_gpus() -> None:
+    flavor = {
+        "spec": {
+            "resources": {"limits": {"cpu": "8", "nvidia.com/gpu": "2"}},
+            "nodeSelector": {"nvidia.com/gpu.product": "NVIDIA-A100-SXM4-80GB"},
+        }
+    }
+    assert get_flavor_gpus(flavor) == (2, "NVIDIA-A100-SXM4-80GB")
+    assert get_flavor_gpus({"spec": {"resources": {"requests": {"cpu": "1"}}}}) == (0, None)
+    assert get_flavor_gpus({"spec": {"gpu": {"count": 4, "product": "NVIDIA-H100"}}}) == (
+        4,
+        "NVIDIA-H100",
+    )
+    assert get_flavor_gpus({"spec": {"gpu": {"count": 2, "migProfile": "1g.10gb"}}}) == (
+        2,
+        "1g.10gb",
+    )
+    assert get_flavor_gpus(
+        {"spec": {"gpu": {"count": 1, "product": "NVIDIA-A100", "sharing": "TimeSlicing"}}}
+    ) == (1, "NVIDIA-A100 time-sliced")
+
+
+def _event(name: str, reason: str, minute: int) -> MagicMock:
+    event = MagicMock()
+    event.involved_object.name = name
+    event.reason = reason
+    event.last_timestamp = datetime(2024, 1, 1, 0, minute, tzinfo=timezone.utc)
+    return event
+
+
+def test_get_recent_events_filters_and_orders_events() -> None:
+    core_v1_api = MagicMock()
+    core_v1_api.list_namespaced_event.return_value.items = [
+        _event("mydev-0", "BackOff", 5),
+        _event("other-0", "Pulled", 4),
+        _event("mydev", "Created", 1),
+        _event("home-mydev-0", "ProvisioningSucceeded", 2),
+    ]
+
+    events = get_recent_events(core_v1_api, "ns", ["mydev", "mydev-0", "home-mydev-0"], limit=2)
+
+    assert [event.reason for event in events] == ["ProvisioningSucceeded", "BackOff"]
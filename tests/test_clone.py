@@ -0,0 +1,106 @@
+from unittest.mock import AsyncMock, MagicMock, patch
+
+import kopf
+import pytest
+from kubernetes.client.rest import ApiException
+
+from devservers.operator.devserver import clone
+from devservers.operator.devserver.clone import cloned_fields, with_cloned_home_source
+
+SOURCE = {
+    "metadata": {"name": "alice-dev"},
+    "spec": {
+        "owner": "alice",
+        "flavor": "gpu-small",
+        "image": "pytorch:latest",
+        "env": [{"name": "DEBUG", "value": "1"}],
+        "persistentHome": {"enabled": True, "size": "50Gi"},
+        "ssh": {"publicKey": "ssh-ed25519 alice"},
+        "lifecycle": {"timeToLive": "7d"},
+        "backup": {"schedule": "@daily"},
+        "hibernated": True,
+    },
+}
+SPEC = {
+    "owner": "bob",
+    "cloneFrom": {"name": "alice-dev"},
+    "image": "pytorch:debug",
+    "ssh": {"publicKey": "ssh-ed25519 bob"},
+    "lifecycle": {"timeToLive": "4h"},
+}
+
+
+def _recorder():
+    return MagicMock(normal=AsyncMock(), warning=AsyncMock())
+
+
+def test_cloned_fields_keep_the_clones_own_fields():
+    assert cloned_fields(SPEC, SOURCE["spec"]) == {
+        "flavor": "gpu-small",
+        "env": [{"name": "DEBUG", "value": "1"}],
+        "persistentHome": {"enabled": True, "size": "50Gi"},
+    }
+
+
+def test_with_cloned_home_source_clones_the_persistent_home():
+    assert with_cloned_home_source(SPEC) == SPEC
+
+    spec = {**SPEC, "persistentHome": {"enabled": True}}
+    assert with_cloned_home_source(spec)["homeSource"] == {
+        "fromDevServer": {"name": "alice-dev"}
+    }
+
+    spec["homeSource"] = {"snapshotRef": {"name": "before-upgrade"}}
+    assert with_cloned_home_source(spec) == spec
+
+
+@pytest.mark.asyncio
+async def test_resolve_clone_copies_the_source_spec():
+    custom_objects_api = MagicMock()
+    custom_objects_api.get_namespaced_custom_object.return_value = SOURCE
+
+    with patch.object(clone.client, "CustomObjectsApi", return_value=custom_objects_api):
+        fields = await clone.resolve_clone(
+            "bob-debug", "team-a", SPEC, MagicMock(), _recorder(), {}
+        )
+
+    assert fields == cloned_fields(SPEC, SOURCE["spec"])
+    assert custom_objects_api.get_namespaced_custom_object.call_args.kwargs["name"] == "alice-dev"
+
+
+@pytest.mark.asyncio
+async def test_resolve_clone_rejects_missing_and_own_sources():
+    custom_objects_api = MagicMock()
+    custom_objects_api.get_namespaced_custom_object.side_effect = ApiException(status=404)
+    recorder = _recorder()
+
+    with patch.object(clone.client, "CustomObjectsApi", return_value=custom_objects_api):
+        with pytest.raises(kopf.PermanentError):
+            await clone.resolve_clone("bob-debug", "team-a", SPEC, MagicMock(), recorder, {})
+        with pytest.raises(kopf.PermanentError):
+            await clone.resolve_clone("alice-dev", "team-a", SPEC, MagicMock(), recorder, {})
+
+    assert recorder.warning.call_args.args[1] == "CloneSourceNotFound"
+
+
+@pytest.mark.asyncio
+async def test_resolve_clone_needs_the_other_owners_consent_with_owner_rbac():
+    custom_objects_api = MagicMock()
+    custom_objects_api.get_namespaced_custom_object.return_value = SOURCE
+
+    with patch.object(clone, "OWNER_RBAC_ENABLED", True), patch.object(
+        clone.client, "CustomObjectsApi", return_value=custom_objects_api
+    ):
+        with pytest.raises(kopf.PermanentError):
+            await clone.resolve_clone("bob-debug", "team-a", SPEC, MagicMock(), _recorder(), {})
+
+        allowed = {
+            **SOURCE,
+            "metadata": {"name": "alice-dev", "annotations": {"devserver.io/allow-clone": "true"}},
+        }
+        custom_objects_api.get_namespaced_custom_object.return_value = allowed
+        fields = await clone.resolve_clone(
+            "bob-debug", "team-a", SPEC, MagicMock(), _recorder(), {}
+        )
+
+    assert fields["flavor"] == "gpu-small"
@@ -0,0 +1,36 @@
+from unittest.mock import MagicMock
+
+import kopf
+import pytest
+
+from devservers.operator.devserver.collaborators import (
+    collaborator_changes,
+    validate_user_collaborators,
+)
+from devservers.operator.devserver.validation import validate_collaborators
+
+
+def test_collaborator_changes():
+    old = {"collaborators": ["bob", "carol"]}
+
+    assert collaborator_changes(None, {"collaborators": ["bob"]}) == (["bob"], [])
+    assert collaborator_changes(old, {"collaborators": ["carol", "dave"]}) == (["dave"], ["bob"])
+    assert collaborator_changes(old, old) == ([], [])
+
+
+@pytest.mark.parametrize(
+    "collaborators",
+    [[" "], ["Alice@example.com"], ["bob", "Bob"]],
+)
+def test_validate_user_collaborators_rejects(collaborators):
+    spec = {"owner": "alice@example.com", "collaborators": collaborators}
+
+    with pytest.raises(ValueError):
+        validate_user_collaborators(spec)
+    with pytest.raises(kopf.PermanentError):
+        validate_collaborators(spec, MagicMock())
+
+
+def test_validate_user_collaborators_accepts():
+    validate_user_collaborators({"owner": "alice", "collaborators": ["bob", "carol"]})
+    validate_user_collaborators({"owner": "alice"})
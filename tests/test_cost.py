@@ -0,0 +1,86 @@
+from datetime import datetime, timezone
+
+from devservers.operator.devserver.cost import (
+    HOURLY_COST_ANNOTATION,
+    compute_cost_status,
+    get_hourly_cost,
+)
+from devservers.operator.metrics import Counter, Gauge, Registry
+
+
+def test_hourly_cost_from_spec():
+    flavor = {"metadata": {"name": "gpu"}, "spec": {"costPerHour": 3.06}}
+    assert get_hourly_cost(flavor) == 3.06
+
+
+def test_hourly_cost_from_annotation():
+    flavor = {
+        "metadata": {"name": "gpu", "annotations": {HOURLY_COST_ANNOTATION: "1.5"}},
+        "spec": {},
+    }
+    assert get_hourly_cost(flavor) == 1.5
+
+
+def test_hourly_cost_spec_takes_precedence():
+    flavor = {
+        "metadata": {"name": "gpu", "annotations": {HOURLY_COST_ANNOTATION: "1.5"}},
+        "spec": {"costPerHour": 2},
+    }
+    assert get_hourly_cost(flavor) == 2.0
+
+
+def test_hourly_cost_missing_or_invalid():
+    assert get_hourly_cost({"metadata": {"name": "cpu"}, "spec": {}}) is None
+    flavor = {
+        "metadata": {"name": "cpu", "annotations": {HOURLY_COST_ANNOTATION: "free"}},
+        "spec": {},
+    }
+    assert get_hourly_cost(flavor) is None
+
+
+def test_compute_cost_status():
+    devserver = {
+        "metadata": {"name": "my-dev", "creationTimestamp": "2024-01-01T00:00:00+00:00"},
+        "spec": {"lifecycle": {"timeToLive": "8h"}},
+    }
+    now = datetime(2024, 1, 1, 3, 0, 0, tzinfo=timezone.utc)
+    assert compute_cost_status(devserver, 3.06, now=now) == {
+        "hourlyRate": "3.06",
+        "accumulated": "9.18",
+        "projected": "24.48",
+    }
+
+
+def test_compute_cost_status_without_ttl():
+    devserver = {
+        "metadata": {"name": "my-dev", "creationTimestamp": "2024-01-01T00:00:00+00:00"},
+        "spec": {},
+    }
+    now = datetime(2024, 1, 1, 0, 30, 0, tzinfo=timezone.utc)
+    cost = compute_cost_status(devserver, 1.0, now=now)
+    assert cost["accumulated"] == "0.50"
+    assert "projected" not in cost
+
+
+def test_metrics_render():
+    registry = Registry()
+    requests = Counter("test_requests_total", "Requests.", ["code"])
+    temperature = Gauge("test_temperature", "Temperature.")
+    registry.register(requests)
+    registry.register(temperature)
+
+    requests.inc(code="200")
+    requests.inc(2, code="200")
+    temperature.set(21.5)
+
+    output = registry.render()
+    assert "# TYPE test_requests_total counter" in output
+    assert 'test_requests_total{code="200"} 3.0' in output
+    assert "test_temperature 21.5" in output
+
+
+def test_gauge_remove():
+    gauge = Gauge("test_gauge", "Gauge.", ["name"])
+    gauge.set(1, name="a")
+    gauge.remove(name="a")
+    assert gauge.get(name="a") is None
@@ -1,6 +1,17 @@
 import yaml
 import pathlib
-from devservers.crds.const import CRD_GROUP, CRD_PLURAL_DEVSERVER, CRD_PLURAL_DEVSERVERFLAVOR
+from devservers.crds.const import (
+    CRD_GROUP,
+    CRD_PLURAL_DEVSERVER,
+    CRD_PLURAL_DEVSERVERFLAVOR,
+    CRD_PLURAL_DEVSERVERBACKUP,
+    CRD_PLURAL_DEVSERVERSNAPSHOT,
+    CRD_PLURAL_DEVSERVERSET,
+    CRD_PLURAL_DEVSERVERCLAIM,
+    CRD_PLURAL_DEVSERVERTEMPLATE,
+    CRD_PLURAL_DEVSERVERPROFILE,
+    CRD_PLURAL_DEVSERVERCALENDAR,
+)
 
 
 # Path to the CRD directory
@@ -38,3 +49,108 @@ def test_devserverflavor_crd_loads():
     assert crd["metadata"]["name"] == f"{CRD_PLURAL_DEVSERVERFLAVOR}.{CRD_GROUP}"
     assert "DevServerFlavor" in crd["spec"]["names"]["kind"]
     assert "Cluster" in crd["spec"]["scope"]
+
+
+def test_devserversnapshot_crd_loads():
+    """
+    Tests that the DevServerSnapshot CRD file can be loaded and parsed as YAML.
+    """
+    crd_file = CRD_DIR / "devserver.io_devserversnapshots.yaml"
+    assert crd_file.exists(), "DevServerSnapshot CRD file not found."
+
+    with open(crd_file, "r") as f:
+        crd = yaml.safe_load(f)
+
+    assert crd["metadata"]["name"] == f"{CRD_PLURAL_DEVSERVERSNAPSHOT}.{CRD_GROUP}"
+    assert crd["spec"]["names"]["kind"] == "DevServerSnapshot"
+    assert crd["spec"]["scope"] == "Namespaced"
+
+
+def test_devserverbackup_crd_loads():
+    """
+    Tests that the DevServerBackup CRD file can be loaded and parsed as YAML.
+    """
+    crd_file = CRD_DIR / "devserver.io_devserverbackups.yaml"
+    assert crd_file.exists(), "DevServerBackup CRD file not found."
+
+    with open(crd_file, "r") as f:
+        crd = yaml.safe_load(f)
+
+    assert crd["metadata"]["name"] == f"{CRD_PLURAL_DEVSERVERBACKUP}.{CRD_GROUP}"
+    assert crd["spec"]["names"]["kind"] == "DevServerBackup"
+    assert crd["spec"]["scope"] == "Namespaced"
+
+
+def test_devserverset_crd_loads():
+    """
+    Tests that the DevServerSet CRD file can be loaded and parsed as YAML.
+    """
+    crd_file = CRD_DIR / "devserver.io_devserversets.yaml"
+    assert crd_file.exists(), "DevServerSet CRD file not found."
+
+    with open(crd_file, "r") as f:
+        crd = yaml.safe_load(f)
+
+    assert crd["metadata"]["name"] == f"{CRD_PLURAL_DEVSERVERSET}.{CRD_GROUP}"
+    assert crd["spec"]["names"]["kind"] == "DevServerSet"
+    assert crd["spec"]["scope"] == "Namespaced"
+
+
+def test_devserverclaim_crd_loads():
+    """
+    Tests that the DevServerClaim CRD file can be loaded and parsed as YAML.
+    """
+    crd_file = CRD_DIR / "devserver.io_devserverclaims.yaml"
+    assert crd_file.exists(), "DevServerClaim CRD file not found."
+
+    with open(crd_file, "r") as f:
+        crd = yaml.safe_load(f)
+
+    assert crd["metadata"]["name"] == f"{CRD_PLURAL_DEVSERVERCLAIM}.{CRD_GROUP}"
+    assert crd["spec"]["names"]["kind"] == "DevServerClaim"
+    assert crd["spec"]["scope"] == "Namespaced"
+
+
+def test_devservertemplate_crd_loads():
+    """
+    Tests that the DevServerTemplate CRD file can be loaded and parsed as YAML.
+    """
+    crd_file = CRD_DIR / "devserver.io_devservertemplates.yaml"
+    assert crd_file.exists(), "DevServerTemplate CRD file not found."
+
+    with open(crd_file, "r") as f:
+        crd = yaml.safe_load(f)
+
+    assert crd["metadata"]["name"] == f"{CRD_PLURAL_DEVSERVERTEMPLATE}.{CRD_GROUP}"
+    assert crd["spec"]["names"]["kind"] == "DevServerTemplate"
+    assert crd["spec"]["scope"] == "Cluster"
+
+
+def test_devserverprofile_crd_loads():
+    """
+    Tests that the DevServerProfile CRD file can be loaded and parsed as YAML.
+    """
+    crd_file = CRD_DIR / "devserver.io_devserverprofiles.yaml"
+    assert crd_file.exists(), "DevServerProfile CRD file not found."
+
+    with open(crd_file, "r") as f:
+        crd = yaml.safe_load(f)
+
+    assert crd["metadata"]["name"] == f"{CRD_PLURAL_DEVSERVERPROFILE}.{CRD_GROUP}"
+    assert crd["spec"]["names"]["kind"] == "DevServerProfile"
+    assert crd["spec"]["scope"] == "Cluster"
+
+
+def test_devservercalendar_crd_loads():
+    """
+    Tests that the DevServerCalendar CRD file can be loaded and parsed as YAML.
+    """
+    crd_file = CRD_DIR / "devserver.io_devservercalendars.yaml"
+    assert crd_file.exists(), "DevServerCalendar CRD file not found."
+
+    with open(crd_file, "r") as f:
+        crd = yaml.safe_load(f)
+
+    assert crd["metadata"]["name"] == f"{CRD_PLURAL_DEVSERVERCALENDAR}.{CRD_GROUP}"
+    assert crd["spec"]["names"]["kind"] == "DevServerCalendar"
+    assert crd["spec"]["scope"] == "Cluster"
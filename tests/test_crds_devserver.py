@@ -2,6 +2,7 @@ import unittest.mock
 from unittest.mock import patch
 
 import pytest
+from kubernetes.client.rest import ApiException
 from kubernetes.config import ConfigException
 
 from devservers.crds.base import ObjectMeta, _get_k8s_api
@@ -177,6 +178,27 @@ def test_devserver_refresh(mock_k8s_api):
     assert devserver.status["phase"] == "Refreshed"
     mock_k8s_api.get_namespaced_custom_object.assert_called_once()
 
+
+@pytest.mark.parametrize(
+    "ssh_spec, endpoint, expected",
+    [
+        ({}, "10.0.0.5:31022", ("10.0.0.5", 31022)),
+        ({"serviceType": "LoadBalancer"}, "lb.example.com:22", ("lb.example.com", 22)),
+        ({"serviceType": "ClusterIP"}, "test-devserver-ssh.test-namespace.svc:22", None),
+        ({}, None, None),
+    ],
+)
+def test_devserver_external_ssh_endpoint(mock_k8s_api, ssh_spec, endpoint, expected):
+    """Only NodePort and LoadBalancer endpoints are reachable from outside the cluster."""
+    devserver = DevServer(
+        metadata=ObjectMeta(name=DEVSERVER_NAME, namespace=NAMESPACE),
+        spec={"ssh": ssh_spec},
+        status={"sshEndpoint": endpoint},
+        api=mock_k8s_api,
+    )
+
+    assert devserver.external_ssh_endpoint == expected
+
 def test_get_k8s_api_raises_runtime_error_on_config_exception():
     """
     Test that our helper function provides a user-friendly error when
@@ -189,3 +211,51 @@ def test_get_k8s_api_raises_runtime_error_on_config_exception():
             _get_k8s_api()
 
         assert "Kubernetes configuration not found" in str(excinfo.value)
+
+
+def test_persistent_home_round_trips_storage_class():
+    devserver = DevServer(
+        metadata=ObjectMeta(name=DEVSERVER_NAME, namespace=NAMESPACE),
+        spec={"persistentHome": {"enabled": True, "size": "20Gi", "storageClassName": "io2"}},
+        api=unittest.mock.MagicMock(),
+    )
+
+    persistent_home = devserver.persistent_home
+    assert persistent_home is not None
+    assert persistent_home.storage_class_name == "io2"
+
+    persistent_home.storage_class_name = None
+    devserver.persistent_home = persistent_home
+    assert devserver.spec["persistentHome"] == {"enabled": True, "size": "20Gi"}
+
+
+def test_devserver_list_by_owner(mock_k8s_api):
+    """Test that DevServers are listed by owner with a field selector."""
+    mock_k8s_api.list_namespaced_custom_object.return_value = {
+        "items": [
+            {"metadata": {"name": "devserver-1", "namespace": NAMESPACE}, "spec": {"owner": "a=b@x.com"}}
+        ]
+    }
+
+    devservers = DevServer.list_by_owner("a=b@x.com", namespace=NAMESPACE, api=mock_k8s_api)
+
+    assert [d.metadata.name for d in devservers] == ["devserver-1"]
+    call_args = mock_k8s_api.list_namespaced_custom_object.call_args
+    assert call_args.kwargs["field_selector"] == "spec.owner=a\\=b@x.com"
+
+
+def test_devserver_list_by_owner_without_selectable_fields(mock_k8s_api):
+    """Test that DevServers are filtered client-side if the API server rejects the field selector."""
+    items = [
+        {"metadata": {"name": "mine", "namespace": NAMESPACE}, "spec": {"owner": "me@x.com"}},
+        {"metadata": {"name": "theirs", "namespace": NAMESPACE}, "spec": {"owner": "you@x.com"}},
+    ]
+    mock_k8s_api.list_namespaced_custom_object.side_effect = [
+        ApiException(status=400),
+        {"items": items},
+    ]
+
+    devservers = DevServer.list_by_owner("me@x.com", namespace=NAMESPACE, api=mock_k8s_api)
+
+    assert [d.metadata.name for d in devservers] == ["mine"]
+    assert "field_selector" not in mock_k8s_api.list_namespaced_custom_object.call_args.kwargs
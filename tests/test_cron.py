@@ -0,0 +1,46 @@
+from datetime import datetime, timezone
+
+import pytest
+
+from devservers.utils.cron import parse_cron
+
+
+def _utc(*args: int) -> datetime:
+    return datetime(*args, tzinfo=timezone.utc)
+
+
+@pytest.mark.parametrize(
+    "expression, after, expected",
+    [
+        ("0 3 * * *", _utc(2024, 1, 1, 2, 59), _utc(2024, 1, 1, 3, 0)),
+        ("0 3 * * *", _utc(2024, 1, 1, 3, 0), _utc(2024, 1, 2, 3, 0)),
+        ("*/15 * * * *", _utc(2024, 1, 1, 10, 7, 30), _utc(2024, 1, 1, 10, 15)),
+        ("30 9 * * 1-5", _utc(2024, 1, 5, 10, 0), _utc(2024, 1, 8, 9, 30)),
+        ("0 0 * * 7", _utc(2024, 1, 1, 0, 0), _utc(2024, 1, 7, 0, 0)),
+        ("@monthly", _utc(2024, 1, 15, 12, 0), _utc(2024, 2, 1, 0, 0)),
+        ("0 12 1,15 * *", _utc(2024, 1, 2, 0, 0), _utc(2024, 1, 15, 12, 0)),
+        ("5/20 * * * *", _utc(2024, 1, 1, 0, 30), _utc(2024, 1, 1, 0, 45)),
+    ],
+)
+def test_next_after(expression, after, expected):
+    assert parse_cron(expression).next_after(after) == expected
+
+
+def test_day_fields_match_either_when_both_are_restricted():
+    # The 13th, or any Friday
+    schedule = parse_cron("0 0 13 * 5")
+    assert schedule.next_after(_utc(2024, 1, 1)) == _utc(2024, 1, 5)
+    assert schedule.next_after(_utc(2024, 1, 12, 1)) == _utc(2024, 1, 13)
+
+
+def test_never_firing_schedule():
+    assert parse_cron("0 0 30 2 *").next_after(_utc(2024, 1, 1)) is None
+
+
+@pytest.mark.parametrize(
+    "expression",
+    ["", "* * * *", "60 * * * *", "* 24 * * *", "*/0 * * * *", "a * * * *", "5-1 * * * *"],
+)
+def test_invalid_expressions(expression):
+    with pytest.raises(ValueError):
+        parse_cron(expression)
@@ -0,0 +1,344 @@
+from datetime import datetime, timezone
+from unittest.mock import MagicMock
+
+import pytest
+from kubernetes import client
+
+from devservers.operator.devserver.status import (
+    PHASE_FAILED,
+    PHASE_HIBERNATED,
+    PHASE_PENDING,
+    PHASE_RUNNING,
+    PHASE_WAITING_FOR_CAPACITY,
+    REASON_QUEUED,
+    REASON_QUOTA_EXCEEDED,
+    REASON_UNSCHEDULABLE,
+    compute_devserver_status,
+    compute_expiration_status,
+    compute_ssh_config,
+    compute_ssh_endpoint,
+    set_condition_transition_times,
+    _get_create_failure,
+)
+
+NAME = "test-server"
+
+
+def _statefulset(available_replicas=0, replicas=1):
+    return client.V1StatefulSet(
+        spec=client.V1StatefulSetSpec(
+            replicas=replicas,
+            selector=client.V1LabelSelector(match_labels={"app": NAME}),
+            service_name=f"{NAME}-headless",
+            template=client.V1PodTemplateSpec(),
+        ),
+        status=client.V1StatefulSetStatus(replicas=1, available_replicas=available_replicas),
+    )
+
+
+def _pod(conditions=None, container_statuses=None):
+    return client.V1Pod(
+        status=client.V1PodStatus(
+            conditions=conditions or [],
+            container_statuses=container_statuses or [],
+        )
+    )
+
+
+def _waiting_container(reason, message=None):
+    return client.V1ContainerStatus(
+        name="devserver",
+        image="ubuntu:22.04",
+        image_id="",
+        ready=False,
+        restart_count=0,
+        state=client.V1ContainerState(
+            waiting=client.V1ContainerStateWaiting(reason=reason, message=message)
+        ),
+    )
+
+
+def test_status_pending_without_statefulset():
+    status = compute_devserver_status(NAME, None, None)
+    assert status["phase"] == PHASE_PENDING
+    assert status["ready"] is False
+
+
+def test_status_waiting_for_capacity_without_statefulset():
+    status = compute_devserver_status(NAME, None, None, capacity_shortage="No node has room.")
+    assert status["phase"] == PHASE_WAITING_FOR_CAPACITY
+    assert status["message"] == "No node has room."
+    assert status["conditions"][0]["reason"] == "WaitingForCapacity"
+
+
+def test_status_pending_without_pod():
+    status = compute_devserver_status(NAME, _statefulset(), None)
+    assert status["phase"] == PHASE_PENDING
+    assert f"{NAME}-0" in status["message"]
+
+
+def test_status_running_when_pod_ready_and_available():
+    pod = _pod(conditions=[client.V1PodCondition(type="Ready", status="True")])
+    status = compute_devserver_status(NAME, _statefulset(available_replicas=1), pod)
+    assert status["phase"] == PHASE_RUNNING
+    assert status["ready"] is True
+
+
+def test_status_hibernated_when_scaled_to_zero():
+    status = compute_devserver_status(NAME, _statefulset(replicas=0), None)
+    assert status["phase"] == PHASE_HIBERNATED
+    assert status["ready"] is False
+
+    # The pod may still be terminating right after hibernation
+    status = compute_devserver_status(NAME, _statefulset(replicas=0), _pod())
+    assert status["phase"] == PHASE_HIBERNATED
+    assert "shutting down" in status["message"]
+
+
+def test_status_pending_when_pod_not_yet_available():
+    pod = _pod(conditions=[client.V1PodCondition(type="Ready", status="True")])
+    status = compute_devserver_status(NAME, _statefulset(available_replicas=0), pod)
+    assert status["phase"] == PHASE_PENDING
+    assert status["ready"] is False
+
+
+def test_status_reflects_unschedulable_pod():
+    pod = _pod(
+        conditions=[
+            client.V1PodCondition(
+                type="PodScheduled",
+                status="False",
+                reason="Unschedulable",
+                message="0/3 nodes are available: 3 Insufficient nvidia.com/gpu.",
+            )
+        ]
+    )
+    status = compute_devserver_status(NAME, _statefulset(), pod)
+    assert status["phase"] == PHASE_PENDING
+    assert "unschedulable" in status["message"]
+    assert "Insufficient nvidia.com/gpu" in status["message"]
+
+
+def test_status_reflects_image_pull_backoff():
+    pod = _pod(
+        container_statuses=[
+            _waiting_container("ImagePullBackOff", 'Back-off pulling image "nope:latest"')
+        ]
+    )
+    status = compute_devserver_status(NAME, _statefulset(), pod)
+    assert status["phase"] == PHASE_FAILED
+    assert status["ready"] is False
+    assert "ImagePullBackOff" in status["message"]
+
+
+def test_status_pending_while_container_creating():
+    pod = _pod(container_statuses=[_waiting_container("ContainerCreating")])
+    status = compute_devserver_status(NAME, _statefulset(), pod)
+    assert status["phase"] == PHASE_PENDING
+
+
+def _condition(status, condition_type):
+    return next(c for c in status["conditions"] if c["type"] == condition_type)
+
+
+def test_ready_condition_reason_for_image_pull_backoff():
+    pod = _pod(container_statuses=[_waiting_container("ErrImagePull", "not found")])
+    status = compute_devserver_status(NAME, _statefulset(), pod)
+    ready = _condition(status, "Ready")
+    assert ready["status"] == "False"
+    assert ready["reason"] == "ErrImagePull"
+
+
+def test_conditions_for_unschedulable_pod():
+    pod = _pod(
+        conditions=[
+            client.V1PodCondition(
+                type="PodScheduled",
+                status="False",
+                reason="Unschedulable",
+                message="0/3 nodes are available: 3 Insufficient cpu.",
+            )
+        ]
+    )
+    status = compute_devserver_status(NAME, _statefulset(), pod)
+    assert _condition(status, "Scheduled")["status"] == "False"
+    assert _condition(status, "Ready")["reason"] == REASON_UNSCHEDULABLE
+
+
+def test_status_reflects_queue_position_until_admitted():
+    pod = _pod(
+        conditions=[
+            client.V1PodCondition(type="PodScheduled", status="False", reason="SchedulingGated")
+        ]
+    )
+    queue = {"queueName": "gpu-dev", "admitted": False, "positionInLocalQueue": 2}
+
+    status = compute_devserver_status(NAME, _statefulset(), pod, queue=queue)
+
+    assert status["phase"] == PHASE_PENDING
+    assert _condition(status, "Ready")["reason"] == REASON_QUEUED
+    assert status["message"] == f"Pod '{NAME}-0' is queued in LocalQueue 'gpu-dev' at position 3."
+    admitted = compute_devserver_status(
+        NAME, _statefulset(), pod, queue={**queue, "admitted": True}
+    )
+    assert _condition(admitted, "Ready")["reason"] != REASON_QUEUED
+
+
+def test_quota_exceeded_when_pod_cannot_be_created():
+    failure = (
+        'pods "test-server-0" is forbidden: exceeded quota: compute, '
+        "requested: requests.cpu=4, used: requests.cpu=8, limited: requests.cpu=10"
+    )
+    status = compute_devserver_status(NAME, _statefulset(), None, create_failure=failure)
+    assert status["phase"] == PHASE_PENDING
+    ready = _condition(status, "Ready")
+    assert ready["reason"] == REASON_QUOTA_EXCEEDED
+    assert "exceeded quota" in ready["message"]
+
+
+def test_other_create_failures_are_failed():
+    failure = 'pods "test-server-0" is forbidden: violates PodSecurity "restricted:latest"'
+    status = compute_devserver_status(NAME, _statefulset(), None, create_failure=failure)
+    assert status["phase"] == PHASE_FAILED
+    assert _condition(status, "Ready")["reason"] == "FailedCreate"
+
+
+@pytest.mark.asyncio
+async def test_create_failures_predating_the_statefulset_are_ignored():
+    def event(message, day):
+        return client.CoreV1Event(
+            metadata=client.V1ObjectMeta(),
+            involved_object=client.V1ObjectReference(),
+            message=message,
+            last_timestamp=datetime(2024, 1, day, tzinfo=timezone.utc),
+        )
+
+    core_v1 = MagicMock()
+    core_v1.list_namespaced_event.return_value.items = [event("old", 1), event("new", 3)]
+    statefulset = _statefulset()
+    statefulset.metadata = client.V1ObjectMeta(
+        generation=2, creation_timestamp=datetime(2024, 1, 2, tzinfo=timezone.utc)
+    )
+    statefulset.status.observed_generation = 2
+    assert await _get_create_failure(core_v1, NAME, "default", statefulset) == "new"
+
+    # Of a deleted StatefulSet of the same name
+    core_v1.list_namespaced_event.return_value.items = [event("old", 1)]
+    assert await _get_create_failure(core_v1, NAME, "default", statefulset) is None
+
+    # Before the controller acted on the current spec
+    core_v1.list_namespaced_event.return_value.items = [event("new", 3)]
+    statefulset.metadata.generation = 3
+    assert await _get_create_failure(core_v1, NAME, "default", statefulset) is None
+
+
+def test_transient_waiting_reason_is_surfaced():
+    pod = _pod(container_statuses=[_waiting_container("ContainerCreating")])
+    status = compute_devserver_status(NAME, _statefulset(), pod)
+    assert _condition(status, "Ready")["reason"] == "ContainerCreating"
+
+
+def test_condition_transition_time_kept_when_status_unchanged():
+    previous = [
+        {"type": "Ready", "status": "False", "reason": "ContainerCreating",
+         "lastTransitionTime": "2024-01-01T00:00:00Z"},
+    ]
+    conditions = [{"type": "Ready", "status": "False", "reason": "PodInitializing"}]
+    set_condition_transition_times(previous, conditions)
+    assert conditions[0]["lastTransitionTime"] == "2024-01-01T00:00:00Z"
+
+
+def test_condition_transition_time_updated_when_status_flips():
+    previous = [
+        {"type": "Ready", "status": "False", "lastTransitionTime": "2024-01-01T00:00:00Z"},
+    ]
+    conditions = [{"type": "Ready", "status": "True", "reason": "PodReady"}]
+    now = datetime(2024, 1, 1, 1, 0, 0, tzinfo=timezone.utc)
+    set_condition_transition_times(previous, conditions, now=now)
+    assert conditions[0]["lastTransitionTime"] == "2024-01-01T01:00:00Z"
+
+
+def _ssh_service(service_type, node_port=None, ingress=None):
+    return client.V1Service(
+        metadata=client.V1ObjectMeta(name=f"{NAME}-ssh", namespace="test-ns"),
+        spec=client.V1ServiceSpec(
+            type=service_type,
+            ports=[client.V1ServicePort(port=22, node_port=node_port)],
+        ),
+        status=client.V1ServiceStatus(
+            load_balancer=client.V1LoadBalancerStatus(ingress=ingress)
+        ),
+    )
+
+
+def test_ssh_endpoint_for_node_port_uses_pod_host_ip():
+    pod = client.V1Pod(status=client.V1PodStatus(host_ip="10.0.0.5"))
+    endpoint = compute_ssh_endpoint(_ssh_service("NodePort", node_port=30022), pod)
+    assert endpoint == "10.0.0.5:30022"
+
+
+def test_ssh_endpoint_for_load_balancer():
+    ingress = [client.V1LoadBalancerIngress(hostname="lb.example.com")]
+    endpoint = compute_ssh_endpoint(_ssh_service("LoadBalancer", ingress=ingress), None)
+    assert endpoint == "lb.example.com:22"
+
+
+def test_ssh_endpoint_for_pending_load_balancer():
+    assert compute_ssh_endpoint(_ssh_service("LoadBalancer"), None) is None
+
+
+def test_ssh_endpoint_for_cluster_ip():
+    endpoint = compute_ssh_endpoint(_ssh_service("ClusterIP"), None)
+    assert endpoint == f"{NAME}-ssh.test-ns.svc:22"
+
+
+def test_ssh_endpoint_without_service():
+    assert compute_ssh_endpoint(None, None) is None
+
+
+def test_ssh_config_for_load_balancer_connects_directly():
+    devserver = {"metadata": {"name": NAME, "namespace": "test-ns"}}
+    ingress = [client.V1LoadBalancerIngress(hostname="lb.example.com")]
+
+    snippet = compute_ssh_config(
+        devserver, _ssh_service("LoadBalancer", ingress=ingress), "lb.example.com:22"
+    )
+
+    assert snippet.startswith(f"Host devserver-test-ns-{NAME}\n")
+    assert "    HostName lb.example.com\n" in snippet
+    assert "    Port 22\n" in snippet
+    assert "ProxyCommand" not in snippet
+
+
+def test_ssh_config_without_external_endpoint_uses_port_forward():
+    devserver = {"metadata": {"name": NAME, "namespace": "test-ns"}}
+
+    snippet = compute_ssh_config(devserver, _ssh_service("ClusterIP"), f"{NAME}-ssh.test-ns.svc:22")
+
+    assert f"    ProxyCommand devctl ssh-proxy --name {NAME} --namespace test-ns\n" in snippet
+    assert "HostName" not in snippet
+
+
+def _devserver_with_ttl(ttl):
+    return {
+        "metadata": {"name": NAME, "creationTimestamp": "2024-01-01T00:00:00+00:00"},
+        "spec": {"lifecycle": {"timeToLive": ttl}},
+    }
+
+
+def test_expiration_status_counts_down():
+    now = datetime(2024, 1, 1, 0, 47, 30, tzinfo=timezone.utc)
+    status = compute_expiration_status(_devserver_with_ttl("4h"), now=now)
+    assert status["expiresAt"] == "2024-01-01T04:00:00Z"
+    assert status["expiresIn"] == "3h12m"
+
+
+def test_expiration_status_when_expired():
+    now = datetime(2024, 1, 1, 5, 0, 0, tzinfo=timezone.utc)
+    status = compute_expiration_status(_devserver_with_ttl("4h"), now=now)
+    assert status["expiresIn"] == "expired"
+
+
+def test_expiration_status_without_ttl():
+    devserver = {"metadata": {"name": NAME}, "spec": {}}
+    assert compute_expiration_status(devserver) == {"expiresAt": None, "expiresIn": None}
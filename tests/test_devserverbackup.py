@@ -0,0 +1,111 @@
+from datetime import datetime, timezone
+
+import pytest
+from kubernetes import client
+
+from devservers.operator.devserverbackup.reconciler import (
+    PHASE_COMPLETED,
+    PHASE_FAILED,
+    PHASE_PENDING,
+    PHASE_RUNNING,
+    build_backup_job,
+    observe_backup_status,
+)
+
+NAMESPACE = "test-ns"
+
+
+def _env(container):
+    return {e["name"]: e["value"] for e in container["env"]}
+
+
+def test_build_backup_job_for_s3():
+    spec = {
+        "devServerName": "my-dev",
+        "destination": {"s3": {"bucket": "backups", "prefix": "/devservers/", "region": "us-west-2"}},
+        "credentialsSecretRef": {"name": "s3-credentials"},
+    }
+
+    job = build_backup_job("nightly", NAMESPACE, spec, node_name="node-1")
+
+    pod_spec = job["spec"]["template"]["spec"]
+    container = pod_spec["containers"][0]
+    env = _env(container)
+    assert job["metadata"]["name"] == "nightly-backup"
+    assert pod_spec["nodeName"] == "node-1"
+    assert env["BACKUP_OBJECT_PATH"] == "backups/devservers/test-ns/my-dev/nightly.tar.gz"
+    assert env["RCLONE_CONFIG_BACKUP_TYPE"] == "s3"
+    assert env["RCLONE_CONFIG_BACKUP_REGION"] == "us-west-2"
+    assert container["envFrom"] == [{"secretRef": {"name": "s3-credentials"}}]
+    assert pod_spec["volumes"][0]["persistentVolumeClaim"] == {
+        "claimName": "home-my-dev-0",
+        "readOnly": True,
+    }
+
+
+def test_build_backup_job_for_gcs_mounts_credentials():
+    spec = {
+        "devServerName": "my-dev",
+        "destination": {"gcs": {"bucket": "backups"}},
+        "credentialsSecretRef": {"name": "gcs-key"},
+    }
+
+    job = build_backup_job("nightly", NAMESPACE, spec)
+
+    pod_spec = job["spec"]["template"]["spec"]
+    env = _env(pod_spec["containers"][0])
+    assert "nodeName" not in pod_spec
+    assert env["RCLONE_CONFIG_BACKUP_TYPE"] == "google cloud storage"
+    assert env["RCLONE_CONFIG_BACKUP_SERVICE_ACCOUNT_FILE"].endswith("/credentials.json")
+    assert pod_spec["volumes"][1]["secret"]["secretName"] == "gcs-key"
+    assert pod_spec["containers"][0]["volumeMounts"][1]["name"] == "gcs-credentials"
+
+
+def _job(**status):
+    return client.V1Job(status=client.V1JobStatus(**status))
+
+
+def _succeeded_pod(message):
+    return client.V1Pod(
+        status=client.V1PodStatus(
+            container_statuses=[
+                client.V1ContainerStatus(
+                    name="backup",
+                    image="rclone",
+                    image_id="",
+                    ready=False,
+                    restart_count=0,
+                    state=client.V1ContainerState(
+                        terminated=client.V1ContainerStateTerminated(exit_code=0, message=message)
+                    ),
+                )
+            ]
+        )
+    )
+
+
+@pytest.mark.parametrize(
+    "job, phase",
+    [
+        (_job(), PHASE_PENDING),
+        (_job(active=1), PHASE_RUNNING),
+        (
+            _job(failed=3, conditions=[client.V1JobCondition(type="Failed", status="True")]),
+            PHASE_FAILED,
+        ),
+        (None, PHASE_FAILED),
+    ],
+)
+def test_observe_backup_status(job, phase):
+    assert observe_backup_status(job, [])["phase"] == phase
+
+
+def test_observe_completed_backup_reports_size():
+    completed = datetime(2024, 1, 1, 3, 5, tzinfo=timezone.utc)
+    job = _job(succeeded=1, completion_time=completed)
+
+    observed = observe_backup_status(job, [_succeeded_pod('{"count":1,"bytes":1048576}')])
+
+    assert observed["phase"] == PHASE_COMPLETED
+    assert observed["completionTime"] == "2024-01-01T03:05:00Z"
+    assert observed["sizeBytes"] == 1048576
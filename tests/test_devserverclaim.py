@@ -0,0 +1,125 @@
+from datetime import datetime, timezone
+from unittest.mock import MagicMock
+
+import kopf
+import pytest
+from kubernetes.client.rest import ApiException
+
+from devservers.operator.devserverclaim.reconciler import (
+    bind_claim,
+    lease_expires_at,
+    release_reason,
+    validate_user_claim,
+)
+
+POOL = {"metadata": {"name": "workshop"}, "spec": {"pool": {"size": 2}}}
+SPEC = {
+    "poolName": "workshop",
+    "owner": "alice@example.com",
+    "publicKey": "ssh-ed25519 alice",
+    "leaseDuration": "4h",
+    "idleTimeout": "1h",
+}
+STATUS = {
+    "phase": "Bound",
+    "devServerName": "workshop-pool-x7k2p",
+    "boundAt": "2024-05-01T10:00:00Z",
+}
+
+
+def _pooled(name, phase, resource_version="1"):
+    return {
+        "metadata": {"name": name, "resourceVersion": resource_version},
+        "status": {"phase": phase},
+    }
+
+
+def _api(pooled):
+    custom_objects_api = MagicMock()
+    custom_objects_api.get_namespaced_custom_object.return_value = POOL
+    custom_objects_api.list_namespaced_custom_object.return_value = {"items": pooled}
+    return custom_objects_api
+
+
+def test_validate_user_claim():
+    validate_user_claim(SPEC)
+    with pytest.raises(ValueError):
+        validate_user_claim({**SPEC, "leaseDuration": "0h"})
+    with pytest.raises(ValueError):
+        validate_user_claim({**SPEC, "idleTimeout": "soon"})
+
+
+@pytest.mark.asyncio
+async def test_bind_claim_prefers_running_devservers():
+    custom_objects_api = _api(
+        [_pooled("workshop-pool-aaaaa", "Pending"), _pooled("workshop-pool-bbbbb", "Running", "7")]
+    )
+
+    bound = await bind_claim("alice", "training", SPEC, MagicMock(), custom_objects_api)
+
+    assert bound == "workshop-pool-bbbbb"
+    kwargs = custom_objects_api.patch_namespaced_custom_object.call_args.kwargs
+    assert kwargs["body"] == {
+        "metadata": {"resourceVersion": "7", "labels": {"devserver.io/claim": "alice"}},
+        "spec": {"owner": "alice@example.com", "ssh": {"publicKey": "ssh-ed25519 alice"}},
+    }
+    assert custom_objects_api.list_namespaced_custom_object.call_args.kwargs[
+        "label_selector"
+    ] == "devserver.io/devserverset=workshop,devserver.io/pool,!devserver.io/claim"
+
+
+@pytest.mark.asyncio
+async def test_bind_claim_skips_devservers_bound_by_another_claim():
+    custom_objects_api = _api(
+        [_pooled("workshop-pool-aaaaa", "Running"), _pooled("workshop-pool-bbbbb", "Running")]
+    )
+    custom_objects_api.patch_namespaced_custom_object.side_effect = [ApiException(status=409), {}]
+
+    bound = await bind_claim("alice", "training", SPEC, MagicMock(), custom_objects_api)
+
+    assert bound == "workshop-pool-bbbbb"
+
+    custom_objects_api = _api([])
+    assert await bind_claim("alice", "training", SPEC, MagicMock(), custom_objects_api) is None
+
+
+@pytest.mark.asyncio
+async def test_bind_claim_needs_a_pool():
+    custom_objects_api = _api([])
+    custom_objects_api.get_namespaced_custom_object.return_value = {"spec": {"roster": []}}
+
+    with pytest.raises(kopf.PermanentError):
+        await bind_claim("alice", "training", SPEC, MagicMock(), custom_objects_api)
+
+
+def test_lease_expires_at_follows_the_lease_duration():
+    assert lease_expires_at(SPEC, STATUS) == "2024-05-01T14:00:00Z"
+    assert lease_expires_at({**SPEC, "leaseDuration": "8h"}, STATUS) == "2024-05-01T18:00:00Z"
+
+
+@pytest.mark.parametrize(
+    "now, last_activity, expected",
+    [
+        (datetime(2024, 5, 1, 10, 30, tzinfo=timezone.utc), None, None),
+        # Idle since it was bound
+        (datetime(2024, 5, 1, 11, 0, tzinfo=timezone.utc), None, "idle for 1h"),
+        (datetime(2024, 5, 1, 11, 0, tzinfo=timezone.utc), "2024-05-01T10:30:00Z", None),
+        # Activity before it was bound does not count
+        (datetime(2024, 5, 1, 11, 0, tzinfo=timezone.utc), "2024-05-01T09:00:00Z", "idle"),
+        (datetime(2024, 5, 1, 14, 0, tzinfo=timezone.utc), "2024-05-01T13:59:00Z", "expired"),
+    ],
+)
+def test_release_reason(now, last_activity, expected):
+    devserver = {"status": {"activity": {"lastActivity": last_activity}}}
+
+    reason = release_reason(SPEC, STATUS, devserver, now)
+
+    if expected is None:
+        assert reason is None
+    else:
+        assert expected in reason
+
+
+def test_release_reason_when_the_devserver_is_gone():
+    now = datetime(2024, 5, 1, 10, 30, tzinfo=timezone.utc)
+    assert "was deleted" in release_reason(SPEC, STATUS, None, now)
@@ -96,3 +96,21 @@ async def test_reconcile_devserver_flavor_not_default(monkeypatch, reconciler_mo
 
     get_default_flavor_mock.assert_not_called()
     reconciler_mock.reconcile_flavor.assert_called_once()
+
+
+@pytest.mark.asyncio
+async def test_reconcile_devserver_flavor_rejects_mig_and_time_slicing(reconciler_mock):
+    """
+    Tests that a flavor cannot request both MIG slices and time-sliced GPUs.
+    """
+    body = {
+        "metadata": {"name": "gpu"},
+        "spec": {"gpu": {"count": 1, "migProfile": "1g.10gb", "sharing": "TimeSlicing"}},
+    }
+
+    with pytest.raises(kopf.PermanentError, match="mutually exclusive"):
+        await reconcile_devserver_flavor(
+            body=body, spec=body["spec"], name="gpu", logger=MagicMock()
+        )
+
+    reconciler_mock.reconcile_flavor.assert_not_called()
@@ -0,0 +1,174 @@
+from unittest.mock import MagicMock
+
+import pytest
+from kubernetes.client.rest import ApiException
+
+from devservers.operator.devserver.naming import NamingPolicy
+from devservers.operator.devserverset.reconciler import (
+    build_devserver,
+    build_pool_devserver,
+    observe_set_status,
+    reconcile_pool,
+    reconcile_roster,
+    validate_user_roster,
+)
+
+SET = {
+    "metadata": {"name": "workshop", "namespace": "training", "uid": "set-uid"},
+    "spec": {
+        "template": {
+            "metadata": {"labels": {"event": "kubecon"}},
+            "spec": {
+                "flavor": "cpu-small",
+                "ssh": {"publicKey": "ssh-ed25519 instructor"},
+                "lifecycle": {"timeToLive": "8h"},
+            },
+        },
+        "roster": [
+            {"name": "alice", "owner": "alice@example.com", "publicKey": "ssh-ed25519 alice"},
+            {"name": "bob", "owner": "bob@example.com"},
+        ],
+    },
+}
+
+
+def _devserver(name, phase=None, owner="alice@example.com"):
+    devserver = {"metadata": {"name": name}, "spec": {"owner": owner}}
+    if phase:
+        devserver["status"] = {"phase": phase}
+    return devserver
+
+
+def test_build_devserver_from_the_template():
+    devserver = build_devserver(SET, SET["spec"]["roster"][0])
+
+    assert devserver["metadata"]["name"] == "workshop-alice"
+    assert devserver["metadata"]["labels"] == {
+        "event": "kubecon",
+        "devserver.io/devserverset": "workshop",
+    }
+    assert devserver["metadata"]["ownerReferences"][0]["uid"] == "set-uid"
+    assert devserver["spec"]["owner"] == "alice@example.com"
+    assert devserver["spec"]["ssh"] == {"publicKey": "ssh-ed25519 alice"}
+    # The template itself is left alone
+    assert SET["spec"]["template"]["spec"]["ssh"] == {"publicKey": "ssh-ed25519 instructor"}
+
+    bob = build_devserver(SET, SET["spec"]["roster"][1])
+    assert bob["spec"]["ssh"] == {"publicKey": "ssh-ed25519 instructor"}
+
+
+def test_validate_user_roster():
+    validate_user_roster(SET, NamingPolicy())
+
+    duplicated = {
+        **SET,
+        "spec": {**SET["spec"], "roster": SET["spec"]["roster"] + [SET["spec"]["roster"][0]]},
+    }
+    with pytest.raises(ValueError, match="more than once"):
+        validate_user_roster(duplicated, NamingPolicy())
+    with pytest.raises(ValueError, match="must start with"):
+        validate_user_roster(SET, NamingPolicy(owner_prefix=True))
+
+
+@pytest.mark.asyncio
+async def test_reconcile_roster_creates_and_deletes_devservers():
+    custom_objects_api = MagicMock()
+    custom_objects_api.list_namespaced_custom_object.return_value = {
+        "items": [_devserver("workshop-alice"), _devserver("workshop-carol")]
+    }
+
+    changes = await reconcile_roster(SET, MagicMock(), custom_objects_api)
+
+    assert changes.created == ["workshop-bob"]
+    assert changes.deleted == ["workshop-carol"]
+    created = custom_objects_api.create_namespaced_custom_object.call_args.kwargs["body"]
+    assert created["spec"]["owner"] == "bob@example.com"
+    assert custom_objects_api.delete_namespaced_custom_object.call_args.kwargs["name"] == (
+        "workshop-carol"
+    )
+    assert custom_objects_api.list_namespaced_custom_object.call_args.kwargs[
+        "label_selector"
+    ] == "devserver.io/devserverset=workshop,!devserver.io/pool"
+
+
+@pytest.mark.asyncio
+async def test_reconcile_roster_reports_names_taken_outside_of_the_set():
+    custom_objects_api = MagicMock()
+    custom_objects_api.list_namespaced_custom_object.return_value = {"items": []}
+    custom_objects_api.create_namespaced_custom_object.side_effect = [
+        ApiException(status=409),
+        {},
+    ]
+
+    changes = await reconcile_roster(SET, MagicMock(), custom_objects_api)
+
+    assert changes.conflicts == ["workshop-alice"]
+    assert changes.created == ["workshop-bob"]
+
+
+def test_observe_set_status():
+    status = observe_set_status(
+        SET,
+        [
+            _devserver("workshop-bob", "Pending", owner="bob@example.com"),
+            _devserver("workshop-alice", "Running"),
+        ],
+    )
+
+    assert status == {
+        "desired": 2,
+        "current": 2,
+        "ready": 1,
+        "available": 0,
+        "devServers": [
+            {"name": "workshop-alice", "owner": "alice@example.com", "phase": "Running"},
+            {"name": "workshop-bob", "owner": "bob@example.com", "phase": "Pending"},
+        ],
+    }
+
+
+POOL_SET = {**SET, "spec": {**SET["spec"], "roster": [], "pool": {"size": 2}}}
+
+
+def test_build_pool_devserver():
+    devserver = build_pool_devserver(POOL_SET)
+
+    assert devserver["metadata"]["generateName"] == "workshop-pool-"
+    assert "name" not in devserver["metadata"]
+    assert devserver["metadata"]["labels"]["devserver.io/pool"] == "workshop"
+    assert "owner" not in devserver["spec"]
+
+
+@pytest.mark.asyncio
+async def test_reconcile_pool_refills_and_shrinks_the_pool():
+    custom_objects_api = MagicMock()
+    custom_objects_api.list_namespaced_custom_object.return_value = {
+        "items": [_devserver("workshop-pool-aaaaa")]
+    }
+    custom_objects_api.create_namespaced_custom_object.return_value = {
+        "metadata": {"name": "workshop-pool-bbbbb"}
+    }
+
+    assert await reconcile_pool(POOL_SET, MagicMock(), custom_objects_api) == [
+        "workshop-pool-bbbbb"
+    ]
+    assert custom_objects_api.list_namespaced_custom_object.call_args.kwargs[
+        "label_selector"
+    ] == "devserver.io/devserverset=workshop,devserver.io/pool,!devserver.io/claim"
+
+    no_pool = {**POOL_SET, "spec": {**POOL_SET["spec"], "pool": {"size": 0}}}
+    assert await reconcile_pool(no_pool, MagicMock(), custom_objects_api) == []
+    assert custom_objects_api.delete_namespaced_custom_object.call_args.kwargs["name"] == (
+        "workshop-pool-aaaaa"
+    )
+
+
+def test_observe_set_status_counts_the_available_devservers():
+    pooled = _devserver("workshop-pool-aaaaa", "Running")
+    pooled["metadata"]["labels"] = {"devserver.io/pool": "workshop"}
+    claimed = _devserver("workshop-pool-bbbbb", "Running")
+    claimed["metadata"]["labels"] = {"devserver.io/pool": "workshop", "devserver.io/claim": "c"}
+
+    status = observe_set_status(POOL_SET, [pooled, claimed])
+
+    assert (status["desired"], status["current"], status["available"]) == (2, 2, 1)
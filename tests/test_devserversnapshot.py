@@ -0,0 +1,87 @@
+from unittest.mock import MagicMock, patch
+
+import kopf
+import pytest
+from kubernetes.client.rest import ApiException
+
+from devservers.operator.devserversnapshot.reconciler import (
+    PHASE_FAILED,
+    PHASE_PENDING,
+    PHASE_READY,
+    create_volume_snapshot,
+    observe_snapshot_status,
+)
+
+NAMESPACE = "test-ns"
+
+
+def _devserver(persistent_home=True):
+    return {"spec": {"persistentHome": {"enabled": persistent_home}}}
+
+
+@pytest.mark.asyncio
+async def test_create_volume_snapshot_of_home_pvc():
+    custom_objects_api = MagicMock()
+    custom_objects_api.get_namespaced_custom_object.return_value = _devserver()
+
+    with patch("kopf.adopt"):
+        await create_volume_snapshot(
+            "snap",
+            NAMESPACE,
+            {"devServerName": "my-dev", "volumeSnapshotClassName": "csi-snapclass"},
+            MagicMock(),
+            custom_objects_api,
+        )
+
+    kwargs = custom_objects_api.create_namespaced_custom_object.call_args.kwargs
+    assert kwargs["plural"] == "volumesnapshots"
+    assert kwargs["body"]["metadata"] == {"name": "snap", "namespace": NAMESPACE}
+    assert kwargs["body"]["spec"] == {
+        "source": {"persistentVolumeClaimName": "home-my-dev-0"},
+        "volumeSnapshotClassName": "csi-snapclass",
+    }
+
+
+@pytest.mark.asyncio
+async def test_create_volume_snapshot_requires_persistent_home():
+    custom_objects_api = MagicMock()
+    custom_objects_api.get_namespaced_custom_object.return_value = _devserver(False)
+
+    with pytest.raises(kopf.PermanentError):
+        await create_volume_snapshot(
+            "snap", NAMESPACE, {"devServerName": "my-dev"}, MagicMock(), custom_objects_api
+        )
+    custom_objects_api.create_namespaced_custom_object.assert_not_called()
+
+
+@pytest.mark.asyncio
+async def test_create_volume_snapshot_of_missing_devserver():
+    custom_objects_api = MagicMock()
+    custom_objects_api.get_namespaced_custom_object.side_effect = ApiException(status=404)
+
+    with pytest.raises(kopf.PermanentError):
+        await create_volume_snapshot(
+            "snap", NAMESPACE, {"devServerName": "my-dev"}, MagicMock(), custom_objects_api
+        )
+
+
+@pytest.mark.parametrize(
+    "volume_snapshot_status, phase",
+    [
+        ({}, PHASE_PENDING),
+        ({"readyToUse": False}, PHASE_PENDING),
+        ({"readyToUse": True, "restoreSize": "50Gi"}, PHASE_READY),
+        ({"readyToUse": False, "error": {"message": "quota exceeded"}}, PHASE_FAILED),
+    ],
+)
+def test_observe_snapshot_status(volume_snapshot_status, phase):
+    observed = observe_snapshot_status("snap", {"status": volume_snapshot_status})
+
+    assert observed["phase"] == phase
+    assert observed["volumeSnapshotName"] == "snap"
+    if "restoreSize" in volume_snapshot_status:
+        assert observed["restoreSize"] == "50Gi"
+
+
+def test_observe_snapshot_status_of_deleted_volume_snapshot():
+    assert observe_snapshot_status("snap", None)["phase"] == PHASE_FAILED
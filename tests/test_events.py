@@ -0,0 +1,104 @@
+import logging
+from datetime import datetime, timedelta, timezone
+from unittest.mock import AsyncMock, MagicMock, patch
+
+import pytest
+from kubernetes.client.rest import ApiException
+
+from devservers.operator.devserver import lifecycle
+from devservers.operator.events import EventRecorder, object_reference
+
+DEVSERVER = {
+    "apiVersion": "devserver.io/v1",
+    "kind": "DevServer",
+    "metadata": {"name": "my-dev", "namespace": "dev-alice", "uid": "1234"},
+}
+
+
+async def _to_thread_mock(func, *args, **kwargs):
+    return func(*args, **kwargs)
+
+
+def test_object_reference():
+    assert object_reference(DEVSERVER) == {
+        "apiVersion": "devserver.io/v1",
+        "kind": "DevServer",
+        "name": "my-dev",
+        "namespace": "dev-alice",
+        "uid": "1234",
+    }
+
+
+@pytest.mark.asyncio
+async def test_recorder_creates_event():
+    core_v1 = MagicMock()
+    recorder = EventRecorder(logging.getLogger(__name__), core_v1_api=core_v1)
+
+    with patch("asyncio.to_thread", _to_thread_mock):
+        await recorder.warning(object_reference(DEVSERVER), "FlavorNotFound", "not found")
+
+    core_v1.create_namespaced_event.assert_called_once()
+    kwargs = core_v1.create_namespaced_event.call_args.kwargs
+    assert kwargs["namespace"] == "dev-alice"
+    body = kwargs["body"]
+    assert body["type"] == "Warning"
+    assert body["reason"] == "FlavorNotFound"
+    assert body["involvedObject"]["uid"] == "1234"
+    assert body["metadata"]["generateName"] == "my-dev."
+
+
+@pytest.mark.asyncio
+async def test_recorder_swallows_api_errors():
+    core_v1 = MagicMock()
+    core_v1.create_namespaced_event.side_effect = ApiException(status=403, reason="Forbidden")
+    recorder = EventRecorder(logging.getLogger(__name__), core_v1_api=core_v1)
+
+    with patch("asyncio.to_thread", _to_thread_mock):
+        # Must not raise
+        await recorder.normal(object_reference(DEVSERVER), "Created", "created")
+
+
+@pytest.mark.asyncio
+async def test_expiring_soon_event_recorded_once_in_window():
+    now = datetime.now(timezone.utc)
+    custom_objects_api = MagicMock()
+    custom_objects_api.list_cluster_custom_object.return_value = {
+        "items": [
+            # Expires in ~14m30s: inside the 15m window, within one interval of it
+            {
+                "metadata": {
+                    "name": "expiring-server",
+                    "namespace": "default",
+                    "creationTimestamp": (now - timedelta(minutes=45, seconds=30)).isoformat(),
+                },
+                "spec": {"lifecycle": {"timeToLive": "1h"}},
+            },
+            # Expires in ~10m: already warned on an earlier pass
+            {
+                "metadata": {
+                    "name": "already-warned-server",
+                    "namespace": "default",
+                    "creationTimestamp": (now - timedelta(minutes=50)).isoformat(),
+                },
+                "spec": {"lifecycle": {"timeToLive": "1h"}},
+            },
+        ]
+    }
+    recorder = MagicMock()
+    recorder.warning = AsyncMock()
+
+    with patch("asyncio.to_thread", _to_thread_mock):
+        deleted = await lifecycle.check_and_expire_devservers(
+            custom_objects_api,
+            logging.getLogger(__name__),
+            recorder=recorder,
+            expiry_warning_seconds=900,
+            interval_seconds=60,
+        )
+
+    assert deleted == 0
+    recorder.warning.assert_awaited_once()
+    reference, reason, _ = recorder.warning.await_args.args
+    assert reference["name"] == "expiring-server"
+    assert reference["kind"] == "DevServer"
+    assert reason == "ExpiringSoon"
@@ -1,6 +1,14 @@
 import pytest
-from unittest.mock import MagicMock
+from datetime import datetime, timedelta, timezone
+from unittest.mock import MagicMock, patch
 from devservers.operator.devserverflavor.reconciler import DevServerFlavorReconciler
+from devservers.operator.devserverflavor.recommendations import UsageHistory
+from devservers.utils.flavors import (
+    DEFAULT_FLAVOR_ANNOTATION,
+    current_flavor_name,
+    get_default_flavor,
+    resolve_flavor,
+)
 
 # --- Mocks and Test Data ---
 
@@ -105,7 +113,9 @@ async def test_flavor_is_schedulable_on_generic_node():
 
     custom_objects_api.list_cluster_custom_object.side_effect = [
         {"items": [CPU_SMALL_FLAVOR]},  # Flavors
-        {"items": []}  # NodePools
+        {"items": []},  # NodePools
+        {"items": []},  # DevServers
+        {"items": []},  # Pod metrics
     ]
     core_v1_api.list_node.return_value = MagicMock(items=[GENERIC_NODE])
     core_v1_api.list_pod_for_all_namespaces.return_value = MagicMock(items=[])
@@ -126,7 +136,9 @@ async def test_flavor_is_autoscaled_with_matching_nodepool():
 
     custom_objects_api.list_cluster_custom_object.side_effect = [
         {"items": [AMD64_FLAVOR]},
-        {"items": [READY_NODEPOOL]}
+        {"items": [READY_NODEPOOL]},
+        {"items": []},
+        {"items": []},
     ]
     core_v1_api.list_node.return_value = MagicMock(items=[])
     core_v1_api.list_pod_for_all_namespaces.return_value = MagicMock(items=[])
@@ -147,7 +159,9 @@ async def test_flavor_is_unschedulable_with_no_matching_nodes():
 
     custom_objects_api.list_cluster_custom_object.side_effect = [
         {"items": [UNSCHEDULABLE_FLAVOR]},
-        {"items": []}
+        {"items": []},
+        {"items": []},
+        {"items": []},
     ]
     core_v1_api.list_node.return_value = MagicMock(items=[GENERIC_NODE])
     core_v1_api.list_pod_for_all_namespaces.return_value = MagicMock(items=[])
@@ -168,7 +182,9 @@ async def test_flavor_is_unschedulable_with_not_ready_nodepool():
 
     custom_objects_api.list_cluster_custom_object.side_effect = [
         {"items": [AMD64_FLAVOR]},
-        {"items": [NOT_READY_NODEPOOL]}
+        {"items": [NOT_READY_NODEPOOL]},
+        {"items": []},
+        {"items": []},
     ]
     core_v1_api.list_node.return_value = MagicMock(items=[])
     core_v1_api.list_pod_for_all_namespaces.return_value = MagicMock(items=[])
@@ -190,7 +206,9 @@ async def test_flavor_is_unschedulable_with_insufficient_resources():
 
     custom_objects_api.list_cluster_custom_object.side_effect = [
         {"items": [GPU_FLAVOR]},
-        {"items": []}
+        {"items": []},
+        {"items": []},
+        {"items": []},
     ]
     core_v1_api.list_node.return_value = MagicMock(items=[GPU_NODE])
     core_v1_api.list_pod_for_all_namespaces.return_value = MagicMock(items=[POD_WITH_GPU])
@@ -215,7 +233,9 @@ async def test_flavor_is_unschedulable_with_unmatched_taint():
 
     custom_objects_api.list_cluster_custom_object.side_effect = [
         {"items": [generic_flavor]},
-        {"items": []}
+        {"items": []},
+        {"items": []},
+        {"items": []},
     ]
     # The only available node has a taint
     core_v1_api.list_node.return_value = MagicMock(items=[GPU_NODE])
@@ -227,3 +247,303 @@ async def test_flavor_is_unschedulable_with_unmatched_taint():
     custom_objects_api.patch_cluster_custom_object_status.assert_called_once()
     patched_body = custom_objects_api.patch_cluster_custom_object_status.call_args[1]['body']
     assert patched_body["status"]["schedulable"] == "No"
+
+
+@pytest.mark.asyncio
+async def test_flavor_status_reports_usage_and_capacity():
+    """ Tests that DevServers using a flavor and the free capacity for it are counted. """
+    logger = MagicMock()
+    custom_objects_api = MagicMock()
+    core_v1_api = MagicMock()
+
+    devservers = [
+        {"spec": {"flavor": "cpu-small"}},
+        {"spec": {"flavor": "cpu-small"}},
+        {"spec": {"flavor": "gpu-flavor"}},
+    ]
+    custom_objects_api.list_cluster_custom_object.side_effect = [
+        {"items": [CPU_SMALL_FLAVOR]},
+        {"items": []},
+        {"items": devservers},
+        {"items": []},
+    ]
+    # 2 CPUs and 4Gi fit 4 DevServers of 500m and 1Gi
+    core_v1_api.list_node.return_value = MagicMock(items=[GENERIC_NODE])
+    core_v1_api.list_pod_for_all_namespaces.return_value = MagicMock(items=[])
+
+    reconciler = DevServerFlavorReconciler(logger, custom_objects_api=custom_objects_api, core_v1_api=core_v1_api)
+    await reconciler.reconcile_all_flavors()
+
+    patched_body = custom_objects_api.patch_cluster_custom_object_status.call_args[1]['body']
+    assert patched_body["status"]["inUse"] == 2
+    assert patched_body["status"]["capacity"] == 4
+
+
+@pytest.mark.asyncio
+async def test_flavor_capacity_accounts_for_used_resources():
+    """ Tests that a fully used GPU node leaves no capacity for a GPU flavor. """
+    logger = MagicMock()
+    custom_objects_api = MagicMock()
+    core_v1_api = MagicMock()
+
+    custom_objects_api.list_cluster_custom_object.side_effect = [
+        {"items": [GPU_FLAVOR]},
+        {"items": []},
+        {"items": []},
+        {"items": []},
+    ]
+    core_v1_api.list_node.return_value = MagicMock(items=[GPU_NODE])
+    core_v1_api.list_pod_for_all_namespaces.return_value = MagicMock(items=[POD_WITH_GPU])
+
+    reconciler = DevServerFlavorReconciler(logger, custom_objects_api=custom_objects_api, core_v1_api=core_v1_api)
+    await reconciler.reconcile_all_flavors()
+
+    patched_body = custom_objects_api.patch_cluster_custom_object_status.call_args[1]['body']
+    assert patched_body["status"]["capacity"] == 0
+
+
+@pytest.mark.asyncio
+async def test_flavor_capacity_counts_gpu_fields():
+    """ Tests that spec.gpu is requested and pins the node's GPU product. """
+    logger = MagicMock()
+    custom_objects_api = MagicMock()
+    core_v1_api = MagicMock()
+
+    flavor = {
+        "metadata": {"name": "a100"},
+        "spec": {
+            "gpu": {"count": 2, "product": "NVIDIA-A100"},
+            "tolerations": [{"key": "nvidia.com/gpu", "operator": "Exists", "effect": "NoSchedule"}],
+        },
+    }
+    a100_node = MagicMock()
+    a100_node.metadata.name = "a100-node"
+    a100_node.metadata.labels = {"nvidia.com/gpu.product": "NVIDIA-A100"}
+    a100_node.spec.taints = [MagicMock(key="nvidia.com/gpu", effect="NoSchedule")]
+    a100_node.status.allocatable = {"cpu": "96", "memory": "1Ti", "nvidia.com/gpu": "8"}
+    custom_objects_api.list_cluster_custom_object.side_effect = [
+        {"items": [flavor]},
+        {"items": []},
+        {"items": []},
+        {"items": []},
+    ]
+    # The unlabeled GPU node has a GPU of an unknown product
+    core_v1_api.list_node.return_value = MagicMock(items=[a100_node, GPU_NODE])
+    core_v1_api.list_pod_for_all_namespaces.return_value = MagicMock(items=[])
+
+    reconciler = DevServerFlavorReconciler(logger, custom_objects_api=custom_objects_api, core_v1_api=core_v1_api)
+    await reconciler.reconcile_all_flavors()
+
+    patched_body = custom_objects_api.patch_cluster_custom_object_status.call_args[1]['body']
+    assert patched_body["status"]["capacity"] == 4
+
+
+
+@pytest.mark.asyncio
+async def test_flavor_status_reports_whether_gpu_resource_is_advertised():
+    """ Tests that flavors report whether nodes advertise their MIG profile. """
+    logger = MagicMock()
+    custom_objects_api = MagicMock()
+    core_v1_api = MagicMock()
+
+    mig_node = MagicMock()
+    mig_node.metadata.name = "mig-node"
+    mig_node.metadata.labels = {"nvidia.com/gpu.product": "NVIDIA-A100"}
+    mig_node.spec.taints = []
+    mig_node.status.allocatable = {"cpu": "96", "nvidia.com/mig-1g.10gb": "7"}
+    core_v1_api.list_node.return_value = MagicMock(items=[mig_node, GENERIC_NODE])
+    core_v1_api.list_pod_for_all_namespaces.return_value = MagicMock(items=[])
+    custom_objects_api.list_cluster_custom_object.return_value = {"items": []}
+    reconciler = DevServerFlavorReconciler(logger, custom_objects_api=custom_objects_api, core_v1_api=core_v1_api)
+
+    advertised = {}
+    for name, gpu in [
+        ("mig", {"count": 1, "migProfile": "1g.10gb"}),
+        ("shared", {"count": 1, "product": "NVIDIA-A100", "sharing": "TimeSlicing"}),
+    ]:
+        await reconciler.reconcile_flavor({"metadata": {"name": name}, "spec": {"gpu": gpu}})
+        patched_body = custom_objects_api.patch_cluster_custom_object_status.call_args[1]['body']
+        advertised[name] = patched_body["status"]["gpuAdvertised"]
+    await reconciler.reconcile_flavor(CPU_SMALL_FLAVOR)
+    patched_body = custom_objects_api.patch_cluster_custom_object_status.call_args[1]['body']
+
+    assert advertised == {"mig": True, "shared": False}
+    assert "gpuAdvertised" not in patched_body["status"]
+    logger.warning.assert_called_once()
+
+
+@pytest.mark.asyncio
+async def test_flavor_status_reports_requested_resources():
+    """ Tests that the requests of the flavor's running DevServers are summed. """
+    logger = MagicMock()
+    custom_objects_api = MagicMock()
+    core_v1_api = MagicMock()
+
+    devservers = [
+        {"spec": {"flavor": "cpu-small"}},
+        {"spec": {"flavor": "cpu-small"}},
+        {"spec": {"flavor": "cpu-small"}},
+        {"spec": {"flavor": "cpu-small", "hibernated": True}},
+    ]
+    custom_objects_api.list_cluster_custom_object.side_effect = [
+        {"items": [CPU_SMALL_FLAVOR]},
+        {"items": []},
+        {"items": devservers},
+        {"items": []},
+    ]
+    core_v1_api.list_node.return_value = MagicMock(items=[GENERIC_NODE])
+    core_v1_api.list_pod_for_all_namespaces.return_value = MagicMock(items=[])
+
+    reconciler = DevServerFlavorReconciler(logger, custom_objects_api=custom_objects_api, core_v1_api=core_v1_api)
+    await reconciler.reconcile_all_flavors()
+
+    patched_body = custom_objects_api.patch_cluster_custom_object_status.call_args[1]['body']
+    assert patched_body["status"]["inUse"] == 4
+    assert patched_body["status"]["requested"] == {"cpu": "1500m", "memory": "3Gi"}
+
+
+@pytest.mark.asyncio
+async def test_flavor_status_recommends_smaller_requests():
+    """ Tests that a flavor whose DevServers used far less than requested over the window gets a recommendation. """
+    logger = MagicMock()
+    custom_objects_api = MagicMock()
+    core_v1_api = MagicMock()
+
+    flavor = {
+        "metadata": {"name": "cpu-large"},
+        "spec": {"resources": {"requests": {"cpu": "8", "memory": "32Gi"}}},
+    }
+    devservers = [
+        {"metadata": {"name": "dev-a", "namespace": "team"}, "spec": {"flavor": "cpu-large"}},
+        {"metadata": {"name": "dev-b", "namespace": "team"}, "spec": {"flavor": "cpu-large"}},
+    ]
+
+    def pod_metrics(cpu_a, cpu_b):
+        return {
+            "items": [
+                {
+                    "metadata": {"name": "dev-a-0", "namespace": "team"},
+                    "containers": [{"name": "devserver", "usage": {"cpu": cpu_a, "memory": "4Gi"}}],
+                },
+                {
+                    "metadata": {"name": "dev-b-0", "namespace": "team"},
+                    "containers": [{"name": "devserver", "usage": {"cpu": cpu_b, "memory": "20Gi"}}],
+                },
+            ]
+        }
+
+    core_v1_api.list_node.return_value = MagicMock(items=[GENERIC_NODE])
+    core_v1_api.list_pod_for_all_namespaces.return_value = MagicMock(items=[])
+    reconciler = DevServerFlavorReconciler(
+        logger,
+        custom_objects_api=custom_objects_api,
+        core_v1_api=core_v1_api,
+        usage_history=UsageHistory(window_seconds=0),
+    )
+
+    for cpu_a, cpu_b in [("1200000000n", "500m"), ("250m", "2")]:
+        custom_objects_api.list_cluster_custom_object.side_effect = [
+            {"items": [flavor]},
+            {"items": []},
+            {"items": devservers},
+            pod_metrics(cpu_a, cpu_b),
+        ]
+        await reconciler.reconcile_all_flavors()
+
+    patched_body = custom_objects_api.patch_cluster_custom_object_status.call_args[1]['body']
+    # Only CPU peaks well below its request, at 2 CPUs with 25% headroom
+    assert patched_body["status"]["recommendation"] == {
+        "peakUsage": {"cpu": "2", "memory": "20Gi"},
+        "requests": {"cpu": "2500m"},
+    }
+
+
+def test_usage_history_waits_for_a_full_window():
+    history = UsageHistory(window_seconds=3600)
+    start = datetime(2026, 1, 1, tzinfo=timezone.utc)
+
+    history.record("cpu-small", {"cpu": 2.0}, start)
+    history.record("cpu-small", {"cpu": 1.0}, start + timedelta(minutes=30))
+    assert history.peak("cpu-small", start + timedelta(minutes=59)) is None
+
+    history.record("cpu-small", {"cpu": 0.5}, start + timedelta(minutes=90))
+    # The first sample has left the window
+    assert history.peak("cpu-small", start + timedelta(minutes=90)) == {"cpu": 1.0}
+
+
+def test_resolve_flavor_merges_base_flavors():
+    flavors = {
+        "gpu": {
+            "metadata": {"name": "gpu"},
+            "spec": {
+                "default": True,
+                "resources": {"requests": {"cpu": "8", "memory": "64Gi"}},
+                "nodeSelector": {"pool": "gpu"},
+                "tolerations": [{"key": "nvidia.com/gpu", "operator": "Exists"}],
+            },
+        },
+        "gpu-a100": {
+            "metadata": {"name": "gpu-a100"},
+            "spec": {"baseFlavor": "gpu", "nodeSelector": {"nvidia.com/gpu.product": "A100"}},
+        },
+    }
+    flavor = {
+        "metadata": {"name": "gpu-a100-large"},
+        "spec": {"baseFlavor": "gpu-a100", "resources": {"requests": {"memory": "256Gi"}}},
+    }
+
+    assert resolve_flavor(flavor, flavors)["spec"] == {
+        "resources": {"requests": {"cpu": "8", "memory": "256Gi"}},
+        "nodeSelector": {"pool": "gpu", "nvidia.com/gpu.product": "A100"},
+        "tolerations": [{"key": "nvidia.com/gpu", "operator": "Exists"}],
+    }
+    assert flavors["gpu"]["spec"]["resources"]["requests"]["memory"] == "64Gi"
+
+
+@pytest.mark.parametrize(
+    "flavors",
+    [
+        {},
+        {"b": {"metadata": {"name": "b"}, "spec": {"baseFlavor": "a"}}},
+    ],
+)
+def test_resolve_flavor_rejects_missing_and_cyclic_bases(flavors):
+    flavor = {"metadata": {"name": "a"}, "spec": {"baseFlavor": "b"}}
+    with pytest.raises(ValueError):
+        resolve_flavor(flavor, flavors)
+
+
+@pytest.mark.parametrize(
+    "status, expected",
+    [({}, "gpu-h100"), ({"flavor": "gpu-l4"}, "gpu-l4"), ({"flavor": "gpu-t4"}, "gpu-h100")],
+)
+def test_current_flavor_name_keeps_fallback_flavors(status, expected):
+    spec = {"flavor": "gpu-h100", "flavorFallbacks": ["gpu-a100", "gpu-l4"]}
+    assert current_flavor_name(spec, status) == expected
+
+
+@pytest.mark.asyncio
+async def test_get_default_flavor_prefers_namespace_annotation():
+    namespace = MagicMock()
+    namespace.metadata.annotations = {DEFAULT_FLAVOR_ANNOTATION: "gpu-flavor"}
+    with patch("devservers.utils.flavors.client") as mock_client:
+        mock_client.CoreV1Api.return_value.read_namespace.return_value = namespace
+        mock_client.CustomObjectsApi.return_value.get_cluster_custom_object.return_value = GPU_FLAVOR
+
+        assert await get_default_flavor("team-research") == GPU_FLAVOR
+
+    mock_client.CustomObjectsApi.return_value.list_cluster_custom_object.assert_not_called()
+
+
+@pytest.mark.asyncio
+async def test_get_default_flavor_falls_back_to_cluster_default():
+    namespace = MagicMock()
+    namespace.metadata.annotations = None
+    default_flavor = {"metadata": {"name": "cpu-small"}, "spec": {"default": True}}
+    with patch("devservers.utils.flavors.client") as mock_client:
+        mock_client.CoreV1Api.return_value.read_namespace.return_value = namespace
+        mock_client.CustomObjectsApi.return_value.list_cluster_custom_object.return_value = {
+            "items": [GPU_FLAVOR, default_flavor]
+        }
+
+        assert await get_default_flavor("team-research") == default_flavor
@@ -0,0 +1,55 @@
+import pytest
+
+from devservers.operator.devserver.gateway import load_ssh_gateway_config
+from devservers.operator.devserver.resources.gateway import ROUTE_KIND_TLS
+
+
+def test_no_gateway_configured():
+    assert load_ssh_gateway_config({}) is None
+
+
+def test_tcp_route_attaches_to_listener():
+    config = load_ssh_gateway_config(
+        {"DEVSERVER_SSH_GATEWAY": "gateways/shared", "DEVSERVER_SSH_GATEWAY_LISTENER": "ssh"}
+    )
+
+    route = config.build_route("my-dev", "dev-alice")
+
+    assert route["kind"] == "TCPRoute"
+    assert route["metadata"] == {"name": "my-dev-ssh", "namespace": "dev-alice"}
+    assert route["spec"]["parentRefs"] == [
+        {"name": "shared", "namespace": "gateways", "sectionName": "ssh"}
+    ]
+    assert route["spec"]["rules"] == [{"backendRefs": [{"name": "my-dev-ssh", "port": 22}]}]
+    assert "hostnames" not in route["spec"]
+    assert config.hostname("my-dev", "dev-alice") is None
+
+
+def test_tls_route_matches_on_sni_hostname():
+    config = load_ssh_gateway_config(
+        {
+            "DEVSERVER_SSH_GATEWAY": "gateways/shared",
+            "DEVSERVER_SSH_GATEWAY_ROUTE_KIND": ROUTE_KIND_TLS,
+            "DEVSERVER_SSH_GATEWAY_DOMAIN": "ssh.example.com",
+        }
+    )
+
+    route = config.build_route("my-dev", "dev-alice")
+
+    assert route["kind"] == "TLSRoute"
+    assert config.route_plural == "tlsroutes"
+    assert route["spec"]["hostnames"] == ["my-dev.dev-alice.ssh.example.com"]
+    assert config.hostname("my-dev", "dev-alice") == "my-dev.dev-alice.ssh.example.com"
+
+
+@pytest.mark.parametrize(
+    "environ",
+    [
+        {"DEVSERVER_SSH_GATEWAY": "shared"},
+        {"DEVSERVER_SSH_GATEWAY": "gateways/shared", "DEVSERVER_SSH_GATEWAY_ROUTE_KIND": "UDPRoute"},
+        {"DEVSERVER_SSH_GATEWAY": "gateways/shared", "DEVSERVER_SSH_GATEWAY_ROUTE_KIND": "TLSRoute"},
+    ],
+)
+def test_invalid_gateway_config(environ):
+    with pytest.raises(ValueError):
+        load_ssh_gateway_config(environ)
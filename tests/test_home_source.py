@@ -0,0 +1,160 @@
+import base64
+from unittest.mock import AsyncMock, MagicMock, patch
+
+import kopf
+import pytest
+from kubernetes.client.rest import ApiException
+
+from devservers.operator.devserver import home_source
+from devservers.operator.devserver.home_source import prepare_home_source, validate_home_source
+from devservers.operator.devserver.resources.statefulset import build_statefulset
+
+NAMESPACE = "test-ns"
+
+
+def _recorder():
+    return MagicMock(normal=AsyncMock(), warning=AsyncMock())
+
+
+@pytest.mark.parametrize(
+    "spec",
+    [
+        {"homeSource": {"snapshotRef": {"name": "snap"}}},
+        {
+            "persistentHome": {"enabled": True},
+            "homeSource": {"snapshotRef": {"name": "snap"}, "fromDevServer": {"name": "old"}},
+        },
+        {"persistentHome": {"enabled": True}, "homeSource": {"backupRef": {}}},
+        {
+            "persistentHome": {"enabled": True},
+            "homeSource": {"backupRef": {"url": "https://example.com/backup.tar.gz"}},
+        },
+    ],
+)
+def test_validate_home_source_rejects_invalid_sources(spec):
+    with pytest.raises(kopf.PermanentError):
+        validate_home_source(spec, MagicMock())
+
+
+def test_build_statefulset_clones_home_from_devserver():
+    spec = {"persistentHome": {"enabled": True}, "homeSource": {"fromDevServer": {"name": "old"}}}
+
+    statefulset = build_statefulset("new", NAMESPACE, spec, {"spec": {"resources": {}}})
+
+    vct = statefulset["spec"]["volumeClaimTemplates"][0]
+    assert vct["spec"]["dataSource"] == {"kind": "PersistentVolumeClaim", "name": "home-old-0"}
+
+
+def test_build_statefulset_restores_home_from_backup():
+    spec = {"persistentHome": {"enabled": True}, "homeSource": {"backupRef": {"name": "nightly"}}}
+
+    statefulset = build_statefulset("new", NAMESPACE, spec, {"spec": {"resources": {}}})
+
+    init_containers = statefulset["spec"]["template"]["spec"]["initContainers"]
+    assert [c["name"] for c in init_containers] == ["restore-home", "install-sshd", "init-home"]
+    assert init_containers[0]["envFrom"] == [
+        {"secretRef": {"name": "new-home-source", "optional": True}}
+    ]
+    assert "dataSource" not in statefulset["spec"]["volumeClaimTemplates"][0]["spec"]
+
+
+@pytest.mark.asyncio
+async def test_prepare_home_source_configures_restore_of_backup():
+    spec = {
+        "persistentHome": {"enabled": True},
+        "homeSource": {"backupRef": {"name": "nightly"}},
+    }
+    core_v1 = MagicMock()
+    core_v1.read_namespaced_persistent_volume_claim.side_effect = ApiException(status=404)
+    core_v1.read_namespaced_secret.return_value = MagicMock(
+        data={
+            "AWS_ACCESS_KEY_ID": base64.b64encode(b"key-id").decode(),
+            "AWS_SECRET_ACCESS_KEY": base64.b64encode(b"secret").decode(),
+        }
+    )
+    custom_objects_api = MagicMock()
+    custom_objects_api.get_namespaced_custom_object.return_value = {
+        "spec": {
+            "destination": {"s3": {"bucket": "backups", "region": "us-west-2"}},
+            "credentialsSecretRef": {"name": "s3-credentials"},
+        },
+        "status": {"phase": "Completed", "url": "s3://backups/test-ns/old/nightly.tar.gz"},
+    }
+
+    with patch.object(home_source.client, "CoreV1Api", return_value=core_v1), \
+            patch.object(home_source.client, "CustomObjectsApi", return_value=custom_objects_api), \
+            patch("kopf.adopt"):
+        await prepare_home_source("new", NAMESPACE, spec, MagicMock(), _recorder(), {})
+
+    secret = core_v1.create_namespaced_secret.call_args.kwargs["body"]
+    assert secret["metadata"]["name"] == "new-home-source"
+    assert secret["stringData"]["BACKUP_OBJECT_PATH"] == "backups/test-ns/old/nightly.tar.gz"
+    assert secret["stringData"]["RCLONE_CONFIG_BACKUP_REGION"] == "us-west-2"
+    assert secret["stringData"]["AWS_SECRET_ACCESS_KEY"] == "secret"
+
+
+@pytest.mark.asyncio
+async def test_prepare_home_source_waits_for_source_devserver_home():
+    spec = {
+        "persistentHome": {"enabled": True},
+        "homeSource": {"fromDevServer": {"name": "old"}},
+    }
+    core_v1 = MagicMock()
+    core_v1.read_namespaced_persistent_volume_claim.side_effect = ApiException(status=404)
+    custom_objects_api = MagicMock()
+    custom_objects_api.get_namespaced_custom_object.return_value = {
+        "spec": {"persistentHome": {"enabled": True}}
+    }
+
+    with patch.object(home_source.client, "CoreV1Api", return_value=core_v1), \
+            patch.object(home_source.client, "CustomObjectsApi", return_value=custom_objects_api):
+        with pytest.raises(kopf.TemporaryError):
+            await prepare_home_source("new", NAMESPACE, spec, MagicMock(), _recorder(), {})
+
+
+@pytest.mark.asyncio
+@pytest.mark.parametrize(
+    "snapshot, error",
+    [
+        ({"status": {"phase": "Ready"}}, None),
+        ({"status": {"phase": "Pending"}}, kopf.TemporaryError),
+        ({"status": {"phase": "Failed"}}, kopf.PermanentError),
+        (ApiException(status=404), kopf.PermanentError),
+    ],
+)
+async def test_prepare_home_source(snapshot, error):
+    spec = {
+        "persistentHome": {"enabled": True},
+        "homeSource": {"snapshotRef": {"name": "snap"}},
+    }
+    core_v1 = MagicMock()
+    core_v1.read_namespaced_persistent_volume_claim.side_effect = ApiException(status=404)
+    custom_objects_api = MagicMock()
+    if isinstance(snapshot, Exception):
+        custom_objects_api.get_namespaced_custom_object.side_effect = snapshot
+    else:
+        custom_objects_api.get_namespaced_custom_object.return_value = snapshot
+    recorder = _recorder()
+
+    with patch.object(home_source.client, "CoreV1Api", return_value=core_v1), \
+            patch.object(home_source.client, "CustomObjectsApi", return_value=custom_objects_api):
+        if error is None:
+            await prepare_home_source("my-dev", NAMESPACE, spec, MagicMock(), recorder, {})
+        else:
+            with pytest.raises(error):
+                await prepare_home_source("my-dev", NAMESPACE, spec, MagicMock(), recorder, {})
+
+
+@pytest.mark.asyncio
+async def test_prepare_home_source_ignores_existing_home():
+    spec = {
+        "persistentHome": {"enabled": True},
+        "homeSource": {"snapshotRef": {"name": "deleted-snap"}},
+    }
+    custom_objects_api = MagicMock()
+
+    with patch.object(home_source.client, "CoreV1Api", return_value=MagicMock()), \
+            patch.object(home_source.client, "CustomObjectsApi", return_value=custom_objects_api):
+        await prepare_home_source("my-dev", NAMESPACE, spec, MagicMock(), MagicMock(), {})
+
+    custom_objects_api.get_namespaced_custom_object.assert_not_called()
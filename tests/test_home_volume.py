@@ -0,0 +1,124 @@
+from unittest.mock import AsyncMock, MagicMock, patch
+
+import pytest
+from kubernetes import client
+
+from devservers.operator.devserver import home_volume
+from devservers.operator.devserver.home_volume import (
+    CONDITION_HOME_VOLUME_RESIZED,
+    REASON_EXPANSION_NOT_SUPPORTED,
+    REASON_FILE_SYSTEM_RESIZE_PENDING,
+    REASON_RESIZED,
+    REASON_RESIZING,
+    REASON_SHRINK_NOT_SUPPORTED,
+    compute_home_volume_condition,
+    expand_home_volume,
+)
+
+NAME = "test-server"
+NAMESPACE = "test-ns"
+
+
+def _spec(size="20Gi"):
+    return {"persistentHome": {"enabled": True, "size": size}}
+
+
+def _pvc(request="10Gi", capacity="10Gi", conditions=None, storage_class_name="gp3"):
+    return client.V1PersistentVolumeClaim(
+        spec=client.V1PersistentVolumeClaimSpec(
+            resources=client.V1VolumeResourceRequirements(requests={"storage": request}),
+            storage_class_name=storage_class_name,
+        ),
+        status=client.V1PersistentVolumeClaimStatus(
+            capacity={"storage": capacity}, conditions=conditions
+        ),
+    )
+
+
+def test_no_condition_without_resize():
+    assert compute_home_volume_condition(_spec("10Gi"), _pvc()) is None
+
+
+def test_condition_when_storage_class_cannot_expand():
+    condition = compute_home_volume_condition(_spec("20Gi"), _pvc())
+    assert condition["type"] == CONDITION_HOME_VOLUME_RESIZED
+    assert condition["status"] == "False"
+    assert condition["reason"] == REASON_EXPANSION_NOT_SUPPORTED
+
+
+def test_condition_while_resizing():
+    condition = compute_home_volume_condition(_spec("20Gi"), _pvc(request="20Gi"))
+    assert condition["reason"] == REASON_RESIZING
+
+
+def test_condition_while_file_system_resize_is_pending():
+    pvc = _pvc(
+        request="20Gi",
+        conditions=[
+            client.V1PersistentVolumeClaimCondition(
+                type="FileSystemResizePending", status="True"
+            )
+        ],
+    )
+    condition = compute_home_volume_condition(_spec("20Gi"), pvc)
+    assert condition["reason"] == REASON_FILE_SYSTEM_RESIZE_PENDING
+
+
+def test_condition_once_resized():
+    previous = [{"type": CONDITION_HOME_VOLUME_RESIZED, "status": "False"}]
+    condition = compute_home_volume_condition(
+        _spec("20Gi"), _pvc(request="20Gi", capacity="20Gi"), previous
+    )
+    assert condition["status"] == "True"
+    assert condition["reason"] == REASON_RESIZED
+
+
+def test_condition_when_shrinking():
+    condition = compute_home_volume_condition(_spec("5Gi"), _pvc())
+    assert condition["reason"] == REASON_SHRINK_NOT_SUPPORTED
+
+
+def _expand(pvc, allow_volume_expansion=True):
+    core_v1 = MagicMock()
+    core_v1.read_namespaced_persistent_volume_claim.return_value = pvc
+    storage_v1 = MagicMock()
+    storage_v1.read_storage_class.return_value = client.V1StorageClass(
+        provisioner="ebs.csi.aws.com", allow_volume_expansion=allow_volume_expansion
+    )
+    recorder = MagicMock(normal=AsyncMock(), warning=AsyncMock())
+    return core_v1, storage_v1, recorder
+
+
+@pytest.mark.asyncio
+async def test_expand_home_volume_patches_pvc():
+    core_v1, storage_v1, recorder = _expand(_pvc())
+    with patch.object(home_volume.client, "CoreV1Api", return_value=core_v1), \
+            patch.object(home_volume.client, "StorageV1Api", return_value=storage_v1):
+        await expand_home_volume(NAME, NAMESPACE, _spec("20Gi"), MagicMock(), recorder, {})
+
+    kwargs = core_v1.patch_namespaced_persistent_volume_claim.call_args.kwargs
+    assert kwargs["name"] == f"home-{NAME}-0"
+    assert kwargs["body"] == {"spec": {"resources": {"requests": {"storage": "20Gi"}}}}
+    recorder.normal.assert_awaited_once()
+
+
+@pytest.mark.asyncio
+async def test_expand_home_volume_requires_expandable_storage_class():
+    core_v1, storage_v1, recorder = _expand(_pvc(), allow_volume_expansion=False)
+    with patch.object(home_volume.client, "CoreV1Api", return_value=core_v1), \
+            patch.object(home_volume.client, "StorageV1Api", return_value=storage_v1):
+        await expand_home_volume(NAME, NAMESPACE, _spec("20Gi"), MagicMock(), recorder, {})
+
+    core_v1.patch_namespaced_persistent_volume_claim.assert_not_called()
+    assert recorder.warning.await_args.args[1] == REASON_EXPANSION_NOT_SUPPORTED
+
+
+@pytest.mark.asyncio
+async def test_expand_home_volume_ignores_unchanged_size():
+    core_v1, storage_v1, recorder = _expand(_pvc(request="20Gi", capacity="20Gi"))
+    with patch.object(home_volume.client, "CoreV1Api", return_value=core_v1), \
+            patch.object(home_volume.client, "StorageV1Api", return_value=storage_v1):
+        await expand_home_volume(NAME, NAMESPACE, _spec("20Gi"), MagicMock(), recorder, {})
+
+    core_v1.patch_namespaced_persistent_volume_claim.assert_not_called()
+    recorder.warning.assert_not_awaited()
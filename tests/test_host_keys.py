@@ -0,0 +1,85 @@
+import base64
+import logging
+from unittest.mock import MagicMock, patch
+
+import pytest
+
+from devservers.operator.devserver import host_keys
+
+
+def _b64(value: str) -> str:
+    return base64.b64encode(value.encode()).decode()
+
+
+async def _to_thread_mock(func, *args, **kwargs):
+    return func(*args, **kwargs)
+
+
+def test_public_host_keys_strips_comments():
+    data = {
+        "ssh_host_ed25519_key": _b64("private"),
+        "ssh_host_ed25519_key.pub": _b64("ssh-ed25519 AAAAC3Nza root@builder\n"),
+        "ssh_host_rsa_key.pub": _b64("ssh-rsa AAAAB3Nza\n"),
+    }
+
+    assert host_keys.public_host_keys(data) == [
+        "ssh-rsa AAAAB3Nza",
+        "ssh-ed25519 AAAAC3Nza",
+    ]
+
+
+@pytest.mark.asyncio
+async def test_existing_host_keys_are_not_regenerated():
+    data = {}
+    for key_type in host_keys.HOST_KEY_TYPES:
+        data[f"ssh_host_{key_type}_key"] = _b64("private")
+        data[f"ssh_host_{key_type}_key.pub"] = _b64(f"ssh-{key_type} KEY{key_type}")
+
+    core_v1 = MagicMock()
+    core_v1.read_namespaced_secret.return_value = MagicMock(data=data)
+
+    with patch.object(host_keys.client, "CoreV1Api", return_value=core_v1), patch(
+        "asyncio.to_thread", _to_thread_mock
+    ), patch.object(host_keys, "generate_host_keys") as generate:
+        keys = await host_keys.ensure_host_keys_secret(
+            "my-dev", "dev-alice", {}, logging.getLogger(__name__)
+        )
+
+    generate.assert_not_called()
+    core_v1.create_namespaced_secret.assert_not_called()
+    core_v1.patch_namespaced_secret.assert_not_called()
+    assert keys == ["ssh-rsa KEYrsa", "ssh-ecdsa KEYecdsa", "ssh-ed25519 KEYed25519"]
+
+
+@pytest.mark.asyncio
+async def test_orphaned_host_keys_secret_is_adopted_with_its_keys():
+    data = {}
+    for key_type in host_keys.HOST_KEY_TYPES:
+        data[f"ssh_host_{key_type}_key"] = _b64("private")
+        data[f"ssh_host_{key_type}_key.pub"] = _b64(f"ssh-{key_type} KEY{key_type}")
+    owner_meta = {
+        "apiVersion": "devserver.io/v1",
+        "kind": "DevServer",
+        "name": "my-dev",
+        "uid": "u1",
+    }
+
+    core_v1 = MagicMock()
+    core_v1.read_namespaced_secret.return_value = MagicMock(data=data)
+    core_v1.api_client.sanitize_for_serialization.return_value = {
+        "metadata": {"name": "my-dev-host-keys"}
+    }
+    core_v1.patch_namespaced_secret.return_value = MagicMock(data=data)
+
+    with patch.object(host_keys.client, "CoreV1Api", return_value=core_v1), patch(
+        "asyncio.to_thread", _to_thread_mock
+    ), patch.object(host_keys, "generate_host_keys") as generate:
+        keys = await host_keys.ensure_host_keys_secret(
+            "my-dev", "dev-alice", owner_meta, logging.getLogger(__name__)
+        )
+
+    generate.assert_not_called()
+    body = core_v1.patch_namespaced_secret.call_args.kwargs["body"]
+    owner_reference = {**owner_meta, "controller": True, "blockOwnerDeletion": True}
+    assert body == {"metadata": {"ownerReferences": [owner_reference]}}
+    assert keys == ["ssh-rsa KEYrsa", "ssh-ecdsa KEYecdsa", "ssh-ed25519 KEYed25519"]
@@ -0,0 +1,138 @@
+from unittest.mock import MagicMock, patch
+
+import pytest
+from kubernetes.client.rest import ApiException
+
+from devservers.operator.devserver import ide
+from devservers.operator.devserver.ide import IDEConfig, load_ide_config
+from devservers.operator.devserver.owner_ids import PosixIds
+from devservers.operator.devserver.reconciler import DevServerReconciler
+from devservers.operator.devserver.resources.network_policy import build_network_policy
+from devservers.operator.devserver.resources.statefulset import (
+    build_statefulset,
+    validate_user_containers,
+)
+
+FLAVOR = {"spec": {"resources": {}}}
+
+
+def test_load_ide_config():
+    assert load_ide_config({}) == IDEConfig()
+
+    config = load_ide_config(
+        {
+            "DEVSERVER_IDE_DOMAIN": "ide.example.com",
+            "DEVSERVER_IDE_INGRESS_CLASS": "nginx",
+            "DEVSERVER_IDE_INGRESS_ANNOTATIONS": '{"nginx.ingress.kubernetes.io/auth-url": "x"}',
+            "DEVSERVER_IDE_TLS_SECRET": "ide-wildcard",
+            "DEVSERVER_IDE_INGRESS_NAMESPACES": "ingress-nginx, ",
+        }
+    )
+
+    assert config.ingress_annotations == {"nginx.ingress.kubernetes.io/auth-url": "x"}
+    assert config.ingress_namespaces == ["ingress-nginx"]
+    assert config.url("my-dev", "dev-alice") == "https://my-dev.dev-alice.ide.example.com/"
+    with pytest.raises(ValueError):
+        load_ide_config({"DEVSERVER_IDE_INGRESS_ANNOTATIONS": '["auth"]'})
+
+
+def test_ide_ingress_routes_the_hostname_to_the_ide_service():
+    config = IDEConfig(domain="ide.example.com", ingress_class_name="nginx")
+
+    ingress = config.build_ingress("my-dev", "dev-alice")
+
+    assert ingress["metadata"] == {"name": "my-dev-ide", "namespace": "dev-alice"}
+    assert ingress["spec"]["ingressClassName"] == "nginx"
+    [rule] = ingress["spec"]["rules"]
+    assert rule["host"] == "my-dev.dev-alice.ide.example.com"
+    assert rule["http"]["paths"][0]["backend"] == {
+        "service": {"name": "my-dev-ide", "port": {"name": "http"}}
+    }
+    assert "tls" not in ingress["spec"]
+    assert config.url("my-dev", "dev-alice") == "http://my-dev.dev-alice.ide.example.com/"
+    assert IDEConfig().build_ingress("my-dev", "dev-alice") is None
+
+
+def test_build_statefulset_adds_code_server_as_the_owner():
+    spec = {"ide": "code-server", "homeMountPath": "/home/jovyan"}
+
+    statefulset = build_statefulset(
+        "my-dev", "dev-alice", spec, FLAVOR, owner_ids=PosixIds(2001, 2002),
+        ide=IDEConfig(code_server_image="code-server:test"),
+    )
+
+    containers = statefulset["spec"]["template"]["spec"]["containers"]
+    assert [c["name"] for c in containers] == ["devserver", "code-server"]
+    code_server = containers[1]
+    assert code_server["image"] == "code-server:test"
+    assert code_server["args"][-1] == "/home/jovyan"
+    assert code_server["securityContext"] == {"runAsUser": 2001, "runAsGroup": 2002}
+    assert code_server["volumeMounts"] == [{"name": "home", "mountPath": "/home/jovyan"}]
+    assert {
+        "name": "PASSWORD",
+        "valueFrom": {"secretKeyRef": {"name": "my-dev-ide", "key": "password"}},
+    } in code_server["env"]
+    with pytest.raises(ValueError):
+        validate_user_containers({"sidecars": [{"name": "code-server", "image": "b"}]})
+
+
+def test_build_resources_exposes_the_ide():
+    reconciler = DevServerReconciler("my-dev", "dev-alice", {"ide": "code-server"}, FLAVOR)
+    reconciler.ide = IDEConfig(domain="ide.example.com")
+
+    resources = reconciler.build_resources()
+
+    assert resources["ide_service"]["metadata"]["name"] == "my-dev-ide"
+    assert resources["ide_service"]["spec"]["ports"][0]["targetPort"] == "code-server"
+    assert resources["ide_ingress"]["kind"] == "Ingress"
+
+    reconciler.spec = {}
+    resources = reconciler.build_resources()
+    assert "ide_service" not in resources and "ide_ingress" not in resources
+
+
+@pytest.mark.asyncio
+async def test_ide_service_and_ingress_are_deleted_without_an_ide():
+    reconciler = DevServerReconciler("my-dev", "dev-alice", {}, FLAVOR)
+    reconciler.core_v1 = MagicMock()
+    reconciler.networking_v1 = MagicMock()
+
+    await reconciler._reconcile_ide(None, None, MagicMock())
+
+    reconciler.core_v1.delete_namespaced_service.assert_called_once_with(
+        name="my-dev-ide", namespace="dev-alice"
+    )
+    reconciler.networking_v1.delete_namespaced_ingress.assert_called_once_with(
+        name="my-dev-ide", namespace="dev-alice"
+    )
+
+
+def test_network_policy_lets_the_ingress_controller_reach_the_ide():
+    policy = build_network_policy(
+        "my-dev", "dev-alice", 22, [], [], [], [], ide_port=8080, ide_namespaces=["ingress-nginx"]
+    )
+
+    peer = {"namespaceSelector": {"matchLabels": {"kubernetes.io/metadata.name": "ingress-nginx"}}}
+    assert policy["spec"]["ingress"] == [
+        {"from": [peer], "ports": [{"protocol": "TCP", "port": 8080}]}
+    ]
+
+
+@pytest.mark.asyncio
+async def test_ensure_ide_password_secret_generates_a_password_once():
+    core_v1 = MagicMock()
+    core_v1.read_namespaced_secret.side_effect = ApiException(status=404)
+    owner_meta = {
+        "apiVersion": "devserver.io/v1", "kind": "DevServer", "name": "my-dev", "uid": "u"
+    }
+
+    with patch.object(ide.client, "CoreV1Api", return_value=core_v1):
+        await ide.ensure_ide_password_secret("my-dev", "dev-alice", owner_meta, MagicMock())
+        body = core_v1.create_namespaced_secret.call_args[1]["body"]
+        assert body["metadata"]["name"] == "my-dev-ide"
+        assert len(body["stringData"]["password"]) >= 24
+
+        core_v1.reset_mock()
+        core_v1.read_namespaced_secret.side_effect = None
+        await ide.ensure_ide_password_secret("my-dev", "dev-alice", owner_meta, MagicMock())
+        core_v1.create_namespaced_secret.assert_not_called()
@@ -0,0 +1,77 @@
+from unittest.mock import MagicMock, patch
+
+import pytest
+
+from devservers.operator.devserver import kueue
+from devservers.operator.devserver.resources.statefulset import build_statefulset
+
+
+def _workload(name, owner_kind, owner_name, admitted=False):
+    return {
+        "metadata": {
+            "name": name,
+            "ownerReferences": [{"kind": owner_kind, "name": owner_name}],
+        },
+        "status": {"conditions": [{"type": "Admitted", "status": str(admitted)}]},
+    }
+
+
+def test_build_statefulset_labels_the_flavors_queue():
+    flavor = {"spec": {"resources": {}, "kueue": {"queueName": "gpu-dev"}}}
+
+    statefulset = build_statefulset("test-server", "test-ns", {}, flavor)
+
+    assert statefulset["metadata"]["labels"] == {"kueue.x-k8s.io/queue-name": "gpu-dev"}
+    assert "labels" not in build_statefulset(
+        "test-server", "test-ns", {}, {"spec": {"resources": {}}}
+    )["metadata"]
+
+
+async def _observe(workloads, pending):
+    custom_objects_api = MagicMock()
+    custom_objects_api.list_namespaced_custom_object.return_value = {"items": workloads}
+    custom_objects_api.api_client.call_api.return_value = {"items": pending}
+    with patch.object(kueue.client, "CustomObjectsApi", return_value=custom_objects_api):
+        return await kueue.observe_queue("test-server", "test-ns", "gpu-dev")
+
+
+@pytest.mark.asyncio
+async def test_observe_queue_reports_the_position_of_a_pending_workload():
+    queue = await _observe(
+        [
+            _workload("other", "Pod", "other-0"),
+            _workload("statefulset-test-server", "Pod", "test-server-0"),
+        ],
+        [
+            {"metadata": {"name": "other"}, "positionInLocalQueue": 0},
+            {
+                "metadata": {"name": "statefulset-test-server"},
+                "positionInLocalQueue": 1,
+                "positionInClusterQueue": 4,
+            },
+        ],
+    )
+
+    assert queue == {
+        "queueName": "gpu-dev",
+        "workload": "statefulset-test-server",
+        "admitted": False,
+        "positionInLocalQueue": 1,
+        "positionInClusterQueue": 4,
+    }
+
+
+@pytest.mark.asyncio
+async def test_observe_queue_of_an_admitted_workload():
+    queue = await _observe([_workload("wl", "StatefulSet", "test-server", admitted=True)], [])
+
+    assert queue["admitted"] is True
+    assert queue["positionInLocalQueue"] is None
+
+
+@pytest.mark.asyncio
+async def test_observe_queue_without_a_workload():
+    queue = await _observe([], [])
+
+    assert queue["workload"] is None
+    assert queue["admitted"] is False
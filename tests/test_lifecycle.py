@@ -81,6 +81,7 @@ async def test_devserver_creates_statefulset(test_flavor, operator_running, k8s_
             name=TEST_DEVSERVER_NAME,
             namespace=NAMESPACE,
             expected_status="Running",
+            timeout=180,
         )
 
     finally:
@@ -382,3 +383,13 @@ async def test_cleanup_expired_devservers_unit():
         namespace="default",
         body=client.V1DeleteOptions(),
     )
+
+
+def test_get_expiration_time_supports_days():
+    created = datetime(2024, 1, 1, tzinfo=timezone.utc)
+    devserver = {
+        "metadata": {"creationTimestamp": created.isoformat()},
+        "spec": {"lifecycle": {"timeToLive": "2d12h"}},
+    }
+
+    assert lifecycle.get_expiration_time(devserver) == created + timedelta(days=2, hours=12)
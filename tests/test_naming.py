@@ -0,0 +1,63 @@
+from unittest.mock import MagicMock, patch
+
+import kopf
+import pytest
+
+from devservers.operator.devserver import handler
+from devservers.operator.devserver.naming import (
+    NamingPolicy,
+    load_naming_policy,
+    owner_short_name,
+    validate_user_name,
+)
+from devservers.operator.devserver.validation import validate_name
+
+
+def test_load_naming_policy():
+    assert load_naming_policy({}) == NamingPolicy(max_length=52, owner_prefix=False)
+    assert load_naming_policy(
+        {"DEVSERVER_NAME_MAX_LENGTH": "30", "DEVSERVER_NAME_OWNER_PREFIX": "true"}
+    ) == NamingPolicy(max_length=30, owner_prefix=True)
+    with pytest.raises(ValueError):
+        load_naming_policy({"DEVSERVER_NAME_MAX_LENGTH": "60"})
+
+
+def test_owner_short_name():
+    assert owner_short_name("Alice.Smith@example.com") == "alice-smith"
+    assert owner_short_name("bob") == "bob"
+
+
+@pytest.mark.parametrize(
+    "name",
+    ["my.dev", "1dev", "dev-", "My-Dev", "a" * 53],
+)
+def test_validate_user_name_rejects_names_children_cannot_use(name):
+    with pytest.raises(ValueError):
+        validate_user_name(name, {}, NamingPolicy())
+
+
+def test_validate_user_name_applies_the_policy():
+    policy = NamingPolicy(max_length=20, owner_prefix=True)
+    spec = {"owner": "alice@example.com"}
+
+    validate_user_name("alice-x7k2p", spec, policy)
+    # Without an owner there is nothing to prefix
+    validate_user_name("scratch", {}, policy)
+    with pytest.raises(ValueError, match="must start with 'alice-'"):
+        validate_user_name("bob-dev", spec, policy)
+    with pytest.raises(ValueError, match="at most 20"):
+        validate_user_name("alice-a-very-long-name", spec, policy)
+    with pytest.raises(kopf.PermanentError):
+        validate_name("bob-dev", spec, policy, MagicMock())
+
+
+@pytest.mark.asyncio
+async def test_admit_devserver_name_checks_new_devservers_only():
+    policy = NamingPolicy(owner_prefix=True)
+    spec = {"owner": "alice"}
+
+    with patch.object(handler, "NAMING_POLICY", policy):
+        await handler.admit_devserver_name("alice-x7k2p", spec, "CREATE", MagicMock())
+        await handler.admit_devserver_name("bob-dev", spec, "UPDATE", MagicMock())
+        with pytest.raises(kopf.AdmissionError):
+            await handler.admit_devserver_name("bob-dev", spec, "CREATE", MagicMock())
@@ -0,0 +1,230 @@
+import hashlib
+import hmac
+from unittest.mock import AsyncMock, MagicMock, patch
+
+import pytest
+from kubernetes.client.rest import ApiException
+
+from devservers.operator import notifications
+from devservers.operator.notifications import Notification, owner_email, parse_events
+from devservers.operator.notifications import email, webhook
+from devservers.operator.notifications.email import EmailProvider, RateLimiter, render
+from devservers.operator.notifications.slack import SlackError, SlackProvider
+
+DEVSERVER = {
+    "metadata": {"name": "my-dev", "namespace": "ml-team"},
+    "spec": {"owner": "alice"},
+}
+USERS = [{"spec": {"username": "alice", "email": "alice@example.com"}}]
+
+
+def _namespace(annotations=None):
+    return MagicMock(metadata=MagicMock(annotations=annotations))
+
+
+def test_parse_events_defaults_to_all_events():
+    assert parse_events(None) == notifications.EVENTS
+    assert parse_events("Ready, ExpiringSoon,Unknown") == {"Ready", "ExpiringSoon"}
+    assert parse_events("none") == frozenset()
+
+
+def test_owner_email_resolution():
+    assert owner_email("bob@example.com", USERS) == "bob@example.com"
+    assert owner_email("alice", USERS) == "alice@example.com"
+    assert owner_email("carol", USERS) is None
+    with patch.object(notifications, "EMAIL_DOMAIN", "corp.example.com"):
+        assert owner_email("carol", USERS) == "carol@corp.example.com"
+
+
+@pytest.mark.asyncio
+async def test_notify_sends_to_each_provider():
+    provider = MagicMock(send=AsyncMock())
+    core_v1_api = MagicMock()
+    core_v1_api.read_namespace.return_value = _namespace()
+    custom_objects_api = MagicMock()
+    custom_objects_api.list_cluster_custom_object.return_value = {"items": USERS}
+
+    with patch.object(notifications.client, "CoreV1Api", return_value=core_v1_api), \
+         patch.object(notifications.client, "CustomObjectsApi", return_value=custom_objects_api):
+        await notifications.notify(
+            DEVSERVER, notifications.EVENT_READY, "Pod is ready.", MagicMock(), [provider]
+        )
+
+    notification = provider.send.call_args[0][0]
+    assert notification == Notification(
+        name="my-dev",
+        namespace="ml-team",
+        owner="alice",
+        email="alice@example.com",
+        event="Ready",
+        message="Pod is ready.",
+    )
+
+
+@pytest.mark.asyncio
+async def test_notify_respects_the_namespace_annotation():
+    provider = MagicMock(send=AsyncMock())
+    core_v1_api = MagicMock()
+    core_v1_api.read_namespace.return_value = _namespace(
+        {notifications.NOTIFICATIONS_ANNOTATION: "ExpiringSoon"}
+    )
+
+    with patch.object(notifications.client, "CoreV1Api", return_value=core_v1_api):
+        await notifications.notify(
+            DEVSERVER, notifications.EVENT_READY, "Pod is ready.", MagicMock(), [provider]
+        )
+
+    provider.send.assert_not_called()
+
+
+@pytest.mark.asyncio
+async def test_notify_never_fails():
+    provider = MagicMock(send=AsyncMock(side_effect=SlackError("chat.postMessage: ratelimited")))
+    core_v1_api = MagicMock()
+    core_v1_api.read_namespace.side_effect = ApiException(status=403)
+    custom_objects_api = MagicMock()
+    custom_objects_api.list_cluster_custom_object.return_value = {"items": USERS}
+    logger = MagicMock()
+
+    with patch.object(notifications.client, "CoreV1Api", return_value=core_v1_api), \
+         patch.object(notifications.client, "CustomObjectsApi", return_value=custom_objects_api):
+        await notifications.notify(
+            DEVSERVER, notifications.EVENT_FAILED, "ImagePullBackOff", logger, [provider]
+        )
+
+    provider.send.assert_awaited_once()
+    logger.warning.assert_called_once()
+
+
+@pytest.mark.asyncio
+async def test_slack_provider_messages_the_owner():
+    provider = SlackProvider("xoxb-token")
+    provider._call = AsyncMock(
+        side_effect=[{"ok": True, "user": {"id": "U123"}}, {"ok": True}, {"ok": True}]
+    )
+    notification = Notification(
+        "my-dev", "ml-team", "alice", "alice@example.com", "ExpiringSoon", "Expires in 15m."
+    )
+
+    await provider.send(notification, MagicMock())
+    await provider.send(notification, MagicMock())
+
+    assert provider._call.await_count == 3  # the user ID is looked up once
+    method, params = provider._call.await_args.args[0], provider._call.await_args.kwargs
+    assert method == "chat.postMessage"
+    assert params["channel"] == "U123"
+    assert "my-dev" in params["text"] and "Expires in 15m." in params["text"]
+
+
+@pytest.mark.asyncio
+async def test_slack_provider_skips_owners_without_slack():
+    provider = SlackProvider("xoxb-token")
+    provider._call = AsyncMock(side_effect=SlackError("users.lookupByEmail: users_not_found"))
+    notification = Notification("my-dev", "ml-team", "alice", "alice@example.com", "Ready", "")
+
+    await provider.send(notification, MagicMock())
+    await provider.send(notification._replace(email=None), MagicMock())
+
+    provider._call.assert_awaited_once()
+
+
+@pytest.mark.asyncio
+async def test_notify_only_falls_back_to_providers_when_the_owner_was_not_reached():
+    slack = MagicMock(fallback=False, send=AsyncMock(return_value=False))
+    email = MagicMock(fallback=True, send=AsyncMock(return_value=True))
+    core_v1_api = MagicMock()
+    core_v1_api.read_namespace.return_value = _namespace()
+    custom_objects_api = MagicMock()
+    custom_objects_api.list_cluster_custom_object.return_value = {"items": USERS}
+
+    with patch.object(notifications.client, "CoreV1Api", return_value=core_v1_api), \
+         patch.object(notifications.client, "CustomObjectsApi", return_value=custom_objects_api):
+        await notifications.notify(DEVSERVER, "Ready", "", MagicMock(), [email, slack])
+        slack.send.return_value = True
+        await notifications.notify(DEVSERVER, "Ready", "", MagicMock(), [email, slack])
+
+    assert slack.send.await_count == 2
+    email.send.assert_awaited_once()
+
+
+def test_email_render_uses_the_event_template(tmp_path):
+    notification = Notification("my-dev", "ml-team", "alice", "alice@example.com", "Ready", "")
+
+    message = render(notification)
+
+    assert message["To"] == "alice@example.com"
+    assert message["Subject"] == "Your DevServer my-dev is ready"
+    assert "devctl ssh my-dev -n ml-team" in message.get_content()
+
+    (tmp_path / "Ready.txt").write_text("$name is up\n\nHi $owner, run: $connect\n")
+    message = render(notification, str(tmp_path))
+
+    assert message["Subject"] == "my-dev is up"
+    assert message.get_content() == "Hi alice, run: devctl ssh my-dev -n ml-team\n"
+
+
+def test_email_rate_limiter():
+    now = [0.0]
+    limiter = RateLimiter(2, window_seconds=3600, clock=lambda: now[0])
+
+    assert limiter.allow("alice@example.com")
+    assert limiter.allow("alice@example.com")
+    assert not limiter.allow("alice@example.com")
+    assert limiter.allow("bob@example.com")
+    now[0] = 3600
+    assert limiter.allow("alice@example.com")
+
+
+@pytest.mark.asyncio
+async def test_email_provider_sends_through_smtp():
+    provider = EmailProvider("smtp.example.com", sender="devservers@example.com", rate_limit=1)
+    notification = Notification(
+        "my-dev", "ml-team", "alice", "alice@example.com", "ExpiringSoon", "Expires in 15m."
+    )
+
+    with patch.object(email.smtplib, "SMTP") as smtp:
+        assert await provider.send(notification, MagicMock())
+        assert not await provider.send(notification, MagicMock())
+
+    message = smtp.return_value.__enter__.return_value.send_message.call_args[0][0]
+    assert message["From"] == "devservers@example.com"
+    assert "devctl extend my-dev <duration> -n ml-team" in message.get_content()
+
+
+def test_webhook_payload_and_signature():
+    devserver = {**DEVSERVER, "metadata": {**DEVSERVER["metadata"], "uid": "1234"}}
+
+    data = webhook.payload(devserver, "ExpiringSoon", "Expires in 15m.")
+
+    assert data["type"] == "devserver.expiring"
+    assert data["devserver"]["uid"] == "1234"
+    assert data["devserver"]["owner"] == "alice"
+    assert webhook.signature("s3cret", "1700000000", b"{}") == (
+        "sha256=" + hmac.new(b"s3cret", b"1700000000.{}", hashlib.sha256).hexdigest()
+    )
+
+
+@pytest.mark.asyncio
+async def test_webhook_publish_signs_deliveries():
+    session = MagicMock()
+    response = MagicMock()
+    session.post.return_value.__aenter__ = AsyncMock(return_value=response)
+    session.post.return_value.__aexit__ = AsyncMock(return_value=False)
+    client_session = MagicMock()
+    client_session.return_value.__aenter__ = AsyncMock(return_value=session)
+    client_session.return_value.__aexit__ = AsyncMock(return_value=False)
+
+    with patch.object(webhook.aiohttp, "ClientSession", client_session):
+        await webhook.publish(
+            DEVSERVER, "Deleting", "", MagicMock(), ["https://portal.example.com/hook"], "s3cret"
+        )
+        await webhook.publish(DEVSERVER, "IdleHibernated", "", MagicMock(), ["https://x"], "")
+
+    session.post.assert_called_once()
+    url, headers = session.post.call_args.args[0], session.post.call_args.kwargs["headers"]
+    body = session.post.call_args.kwargs["data"]
+    assert url == "https://portal.example.com/hook"
+    assert headers["X-DevServer-Event"] == "devserver.deleted"
+    assert headers["X-DevServer-Signature"] == webhook.signature(
+        "s3cret", headers["X-DevServer-Timestamp"], body
+    )
@@ -1,7 +1,44 @@
+import kopf
 import pytest
-from devservers.operator.devserver.resources.statefulset import build_statefulset
+from devservers.operator.devserver.resources.configmap import (
+    get_managed_sshd_overrides,
+    render_sshd_config,
+)
+from devservers.operator.devserver.resources.services import build_ssh_service
+from devservers.operator.devserver.resources.statefulset import (
+    DEFAULT_DEVSERVER_IMAGE,
+    SSHD_CONFIG_CHECKSUM_ANNOTATION,
+    build_probes,
+    build_statefulset,
+    get_main_command,
+    validate_user_containers,
+    validate_user_env,
+    validate_user_home_mount_path,
+    validate_user_host_access,
+    validate_user_priority_class,
+    validate_user_restricted_pod_security,
+    validate_user_volumes,
+)
+from devservers.utils.users import owner_ssh_keys_secret_name
 from devservers.operator.devserveruser.reconciler import DevServerUserReconciler
-from unittest.mock import MagicMock
+from devservers.operator.devserver.reconciler import DevServerReconciler
+from devservers.operator.devserver.owner_ids import PosixIds
+from devservers.operator.devserver.resources.pod_security import validate_user_security_profiles
+from devservers.operator.devserver.resources.affinity import validate_user_affinity
+from devservers.operator.devserver.resources.credentials import validate_user_credential_bundles
+from devservers.operator.devserver.resources.dotfiles import validate_user_dotfiles_repo
+from devservers.operator.devserver.resources.encryption import (
+    build_encrypted_storage_class,
+    validate_user_home_encryption,
+)
+from devservers.operator.devserver.packing import PackingDefaults
+from devservers.operator.devserver.resources.prewarm import validate_user_prewarm
+from devservers.operator.devserver.resources.node_pools import validate_user_team_node_pool
+from devservers.operator.devserver.network_policy import (
+    NetworkPolicyDefaults,
+    build_devserver_network_policy,
+)
+from unittest.mock import AsyncMock, MagicMock
 from kubernetes.client.rest import ApiException
 
 def test_build_statefulset_with_node_selector():
@@ -55,6 +92,72 @@ def test_build_statefulset_without_node_selector():
     assert "nodeSelector" not in statefulset["spec"]["template"]["spec"]
 
 
+
+def _zone_affinity(operator, *zones):
+    expression = {"key": "topology.kubernetes.io/zone", "operator": operator}
+    if zones:
+        expression["values"] = list(zones)
+    return {
+        "nodeAffinity": {
+            "requiredDuringSchedulingIgnoredDuringExecution": {
+                "nodeSelectorTerms": [{"matchExpressions": [expression]}]
+            }
+        }
+    }
+
+
+def test_build_statefulset_with_affinity():
+    spec = {"affinity": _zone_affinity("In", "us-east-1a")}
+    flavor = {
+        "spec": {
+            "resources": {},
+            "nodeSelector": {"pool": "gpu"},
+            "tolerations": [{"key": "nvidia.com/gpu", "operator": "Exists"}],
+        }
+    }
+
+    pod_spec = build_statefulset("test-server", "test-ns", spec, flavor)["spec"]["template"]["spec"]
+
+    assert pod_spec["affinity"] == _zone_affinity("In", "us-east-1a")
+    assert pod_spec["nodeSelector"] == {"pool": "gpu"}
+    assert pod_spec["tolerations"] == [{"key": "nvidia.com/gpu", "operator": "Exists"}]
+
+
+def test_build_statefulset_without_affinity():
+    statefulset = build_statefulset("test-server", "test-ns", {}, {"spec": {"resources": {}}})
+
+    assert "affinity" not in statefulset["spec"]["template"]["spec"]
+
+
+@pytest.mark.parametrize(
+    "affinity",
+    [
+        _zone_affinity("In", "us-east-1a", "us-east-1b"),
+        _zone_affinity("NotIn", "us-east-1b"),
+        _zone_affinity("Exists"),
+        {"podAntiAffinity": {"requiredDuringSchedulingIgnoredDuringExecution": []}},
+    ],
+)
+def test_validate_user_affinity_within_the_flavors_nodes(affinity):
+    validate_user_affinity(
+        {"affinity": affinity}, {"topology.kubernetes.io/zone": "us-east-1a"}
+    )
+
+
+@pytest.mark.parametrize(
+    "affinity",
+    [
+        _zone_affinity("In", "us-east-1b"),
+        _zone_affinity("NotIn", "us-east-1a"),
+        _zone_affinity("DoesNotExist"),
+    ],
+)
+def test_validate_user_affinity_rejects_excluding_the_flavors_nodes(affinity):
+    with pytest.raises(ValueError, match="excludes the flavor's nodes"):
+        validate_user_affinity(
+            {"affinity": affinity}, {"topology.kubernetes.io/zone": "us-east-1a"}
+        )
+
 def test_build_statefulset_with_persistent_home_enabled():
     name = "test-server"
     namespace = "test-ns"
@@ -85,6 +188,146 @@ def test_build_statefulset_with_persistent_home_enabled_and_size():
     assert vct["spec"]["resources"]["requests"]["storage"] == "20Gi"
 
 
+@pytest.mark.parametrize(
+    "persistent_home, flavor_spec, expected",
+    [
+        ({"enabled": True}, {"resources": {}}, None),
+        ({"enabled": True}, {"resources": {}, "storageClassName": "local-nvme"}, "local-nvme"),
+        (
+            {"enabled": True, "storageClassName": "io2"},
+            {"resources": {}, "storageClassName": "local-nvme"},
+            "io2",
+        ),
+    ],
+)
+def test_build_statefulset_home_storage_class(persistent_home, flavor_spec, expected):
+    spec = {"persistentHome": persistent_home}
+
+    statefulset = build_statefulset("test-server", "test-ns", spec, {"spec": flavor_spec})
+
+    vct = statefulset["spec"]["volumeClaimTemplates"][0]
+    assert vct["spec"].get("storageClassName") == expected
+
+
+ENCRYPTED_FLAVOR = {
+    "metadata": {"name": "secure"},
+    "spec": {
+        "resources": {},
+        "storageClassName": "local-nvme",
+        "homeEncryption": {"storageClassName": "gp3-encrypted"},
+    },
+}
+KMS_KEY = "arn:aws:kms:us-west-2:111122223333:key/alice"
+
+
+def _home_storage_class(persistent_home, flavor=ENCRYPTED_FLAVOR):
+    spec = {"persistentHome": {"enabled": True, **persistent_home}}
+    statefulset = build_statefulset("test-server", "test-ns", spec, flavor)
+    return statefulset["spec"]["volumeClaimTemplates"][0]["spec"]["storageClassName"]
+
+
+def test_build_statefulset_encrypted_home_storage_class():
+    assert _home_storage_class({}) == "local-nvme"
+    assert _home_storage_class({"encryption": {"enabled": True}}) == "gp3-encrypted"
+    encrypted_with_key = _home_storage_class({"encryption": {"enabled": True, "kmsKeyID": KMS_KEY}})
+    assert encrypted_with_key.startswith("gp3-encrypted-")
+
+    required = {
+        **ENCRYPTED_FLAVOR,
+        "spec": {
+            **ENCRYPTED_FLAVOR["spec"],
+            "homeEncryption": {"storageClassName": "gp3-encrypted", "required": True},
+        },
+    }
+    assert _home_storage_class({}, required) == "gp3-encrypted"
+
+
+@pytest.mark.parametrize(
+    "spec, flavor",
+    [
+        ({"persistentHome": {"encryption": {"enabled": True}}}, ENCRYPTED_FLAVOR),
+        (
+            {"persistentHome": {"enabled": True, "encryption": {"enabled": True}}},
+            {"spec": {"resources": {}}},
+        ),
+        (
+            {
+                "persistentHome": {
+                    "enabled": True,
+                    "storageClassName": "io2",
+                    "encryption": {"enabled": True},
+                }
+            },
+            ENCRYPTED_FLAVOR,
+        ),
+        (
+            {
+                "persistentHome": {"enabled": True, "encryption": {"enabled": True}},
+                "homeSource": {"fromDevServer": {"name": "other"}},
+            },
+            ENCRYPTED_FLAVOR,
+        ),
+        (
+            {"persistentHome": {"enabled": True, "encryption": {"kmsKeyID": KMS_KEY}}},
+            ENCRYPTED_FLAVOR,
+        ),
+    ],
+)
+def test_validate_user_home_encryption_rejects(spec, flavor):
+    with pytest.raises(ValueError):
+        validate_user_home_encryption(spec, flavor)
+
+
+def test_build_encrypted_storage_class_sets_the_kms_key():
+    base = {
+        "metadata": {"name": "gp3-encrypted", "resourceVersion": "1"},
+        "provisioner": "ebs.csi.aws.com",
+        "parameters": {"type": "gp3", "encrypted": "true"},
+        "reclaimPolicy": "Delete",
+        "volumeBindingMode": "WaitForFirstConsumer",
+        "allowVolumeExpansion": True,
+    }
+
+    storage_class = build_encrypted_storage_class(base, KMS_KEY)
+
+    assert storage_class["metadata"]["name"] == _home_storage_class(
+        {"encryption": {"enabled": True, "kmsKeyID": KMS_KEY}}
+    )
+    assert storage_class["parameters"] == {"type": "gp3", "encrypted": "true", "kmsKeyId": KMS_KEY}
+    assert storage_class["volumeBindingMode"] == "WaitForFirstConsumer"
+    assert storage_class["allowVolumeExpansion"] is True
+
+    with pytest.raises(ValueError):
+        build_encrypted_storage_class({**base, "provisioner": "example.com/nfs"}, KMS_KEY)
+
+
+@pytest.mark.asyncio
+async def test_encrypted_storage_class_is_created_once():
+    encryption = {"enabled": True, "kmsKeyID": KMS_KEY}
+    spec = {"persistentHome": {"enabled": True, "encryption": encryption}}
+    reconciler = DevServerReconciler("test-server", "test-ns", spec, ENCRYPTED_FLAVOR)
+    reconciler.storage_v1 = MagicMock()
+    reconciler.storage_v1.read_storage_class.side_effect = [
+        ApiException(status=404),
+        MagicMock(),
+    ]
+    reconciler.storage_v1.api_client.sanitize_for_serialization.return_value = {
+        "metadata": {"name": "gp3-encrypted"},
+        "provisioner": "pd.csi.storage.gke.io",
+        "parameters": {"type": "pd-ssd"},
+    }
+
+    await reconciler._reconcile_encrypted_storage_class(MagicMock())
+
+    body = reconciler.storage_v1.patch_storage_class.call_args.kwargs["body"]
+    assert body["parameters"] == {"type": "pd-ssd", "disk-encryption-kms-key": KMS_KEY}
+
+    reconciler.storage_v1.read_storage_class.side_effect = None
+    reconciler.storage_v1.patch_storage_class.reset_mock()
+    await reconciler._reconcile_encrypted_storage_class(MagicMock())
+    reconciler.storage_v1.patch_storage_class.assert_not_called()
+
+
 def test_build_statefulset_with_persistent_home_disabled():
     name = "test-server"
     namespace = "test-ns"
@@ -111,6 +354,938 @@ def test_build_statefulset_with_persistent_home_unspecified():
     assert any(v.get("name") == "home" and "emptyDir" in v for v in volumes)
 
 
+def test_build_statefulset_hibernated_scales_to_zero():
+    flavor = {"spec": {"resources": {}}}
+
+    assert build_statefulset("test-server", "test-ns", {}, flavor)["spec"]["replicas"] == 1
+    hibernated = build_statefulset("test-server", "test-ns", {"hibernated": True}, flavor)
+    assert hibernated["spec"]["replicas"] == 0
+
+
+def _authorized_keys_volume(statefulset):
+    volumes = statefulset["spec"]["template"]["spec"]["volumes"]
+    return next((v for v in volumes if v["name"] == "authorized-keys"), None)
+
+
+def test_build_statefulset_with_authorized_keys_secret_ref():
+    spec = {"ssh": {"authorizedKeysSecretRef": {"name": "my-keys", "key": "keys"}}}
+    flavor = {"spec": {"resources": {}}}
+
+    statefulset = build_statefulset("test-server", "test-ns", spec, flavor)
+
+    volume = _authorized_keys_volume(statefulset)
+    assert volume["secret"]["secretName"] == "my-keys"
+    assert volume["secret"]["items"] == [{"key": "keys", "path": "authorized_keys"}]
+    assert volume["secret"]["optional"] is False
+
+    mounts = statefulset["spec"]["template"]["spec"]["containers"][0]["volumeMounts"]
+    assert any(m["name"] == "authorized-keys" for m in mounts)
+
+
+def test_build_statefulset_mounts_owner_ssh_keys_by_convention():
+    spec = {"owner": "Alice.Smith@example.com"}
+    flavor = {"spec": {"resources": {}}}
+
+    statefulset = build_statefulset("test-server", "test-ns", spec, flavor)
+
+    volume = _authorized_keys_volume(statefulset)
+    assert volume["secret"]["secretName"] == "alice-smith-example-com-ssh-keys"
+    assert volume["secret"]["optional"] is True
+
+
+def test_build_statefulset_projects_collaborators_ssh_keys():
+    spec = {"owner": "alice", "collaborators": ["Bob@example.com"]}
+    flavor = {"spec": {"resources": {}}}
+
+    statefulset = build_statefulset("test-server", "test-ns", spec, flavor)
+
+    sources = _authorized_keys_volume(statefulset)["projected"]["sources"]
+    assert sources == [
+        {
+            "secret": {
+                "name": "alice-ssh-keys",
+                "items": [{"key": "authorized_keys", "path": "authorized_keys"}],
+                "optional": True,
+            }
+        },
+        {
+            "secret": {
+                "name": "bob-example-com-ssh-keys",
+                "items": [
+                    {"key": "authorized_keys", "path": "collaborators/bob-example-com"}
+                ],
+                "optional": True,
+            }
+        },
+    ]
+
+
+def test_build_statefulset_without_authorized_keys_source():
+    statefulset = build_statefulset("test-server", "test-ns", {}, {"spec": {"resources": {}}})
+    assert _authorized_keys_volume(statefulset) is None
+
+
+def test_build_statefulset_with_user_volumes():
+    spec = {
+        "volumes": [
+            {"name": "datasets", "persistentVolumeClaim": {"claimName": "imagenet"}},
+            {"name": "hf-token", "secret": {"secretName": "hf-token"}},
+        ],
+        "volumeMounts": [
+            {"name": "datasets", "mountPath": "/data", "readOnly": True},
+            {"name": "hf-token", "mountPath": "/home/dev/.cache/huggingface"},
+        ],
+    }
+
+    statefulset = build_statefulset("test-server", "test-ns", spec, {"spec": {"resources": {}}})
+
+    pod_spec = statefulset["spec"]["template"]["spec"]
+    assert spec["volumes"][0] in pod_spec["volumes"]
+    assert spec["volumes"][1] in pod_spec["volumes"]
+    mounts = pod_spec["containers"][0]["volumeMounts"]
+    assert {"name": "datasets", "mountPath": "/data", "readOnly": True} in mounts
+    assert {"name": "home", "mountPath": "/home/dev"} in mounts
+
+
+@pytest.mark.parametrize(
+    "spec",
+    [
+        {"volumes": [{"name": "home", "emptyDir": {}}]},
+        {"volumes": [{"name": "root", "hostPath": {"path": "/"}}]},
+        {"volumes": [{"name": "data", "nfs": {"server": "10.0.0.1", "path": "/"}}]},
+        {"volumes": [{"name": "data", "emptyDir": {}, "secret": {"secretName": "s"}}]},
+        {"volumeMounts": [{"name": "data", "mountPath": "/data"}]},
+        {
+            "volumes": [{"name": "data", "emptyDir": {}}],
+            "volumeMounts": [{"name": "data", "mountPath": "/home/dev"}],
+        },
+        {
+            "volumes": [{"name": "data", "emptyDir": {}}],
+            "volumeMounts": [{"name": "data", "mountPath": "/opt"}],
+        },
+        {
+            "volumes": [{"name": "data", "emptyDir": {}}],
+            "volumeMounts": [{"name": "data", "mountPath": "/opt/ssh/keys"}],
+        },
+    ],
+)
+def test_validate_user_volumes_rejects_conflicts(spec):
+    with pytest.raises(ValueError):
+        validate_user_volumes(spec)
+
+
+def test_build_statefulset_with_shared_volumes():
+    spec = {
+        "sharedVolumeClaimName": "legacy-efs",
+        "sharedVolumes": [
+            {"claimName": "imagenet", "mountPath": "/datasets/imagenet", "readOnly": True},
+            {"claimName": "team-scratch", "mountPath": "/team"},
+        ],
+    }
+
+    statefulset = build_statefulset("test-server", "test-ns", spec, {"spec": {"resources": {}}})
+
+    pod_spec = statefulset["spec"]["template"]["spec"]
+    volumes = pod_spec["volumes"]
+    assert {"name": "shared", "persistentVolumeClaim": {"claimName": "legacy-efs"}} in volumes
+    assert {
+        "name": "shared-0",
+        "persistentVolumeClaim": {"claimName": "imagenet", "readOnly": True},
+    } in volumes
+    assert {"name": "shared-1", "persistentVolumeClaim": {"claimName": "team-scratch"}} in volumes
+    mounts = pod_spec["containers"][0]["volumeMounts"]
+    assert {"name": "shared", "mountPath": "/shared"} in mounts
+    assert {"name": "shared-0", "mountPath": "/datasets/imagenet", "readOnly": True} in mounts
+    assert {"name": "shared-1", "mountPath": "/team"} in mounts
+
+
+@pytest.mark.parametrize(
+    "spec",
+    [
+        {"sharedVolumes": [{"claimName": "data", "mountPath": "/home/dev"}]},
+        {
+            "sharedVolumeClaimName": "legacy-efs",
+            "sharedVolumes": [{"claimName": "data", "mountPath": "/shared/"}],
+        },
+        {
+            "sharedVolumes": [{"claimName": "data", "mountPath": "/data"}],
+            "volumes": [{"name": "cache", "emptyDir": {}}],
+            "volumeMounts": [{"name": "cache", "mountPath": "/data"}],
+        },
+        {"volumes": [{"name": "shared-0", "emptyDir": {}}]},
+    ],
+)
+def test_validate_user_volumes_rejects_shared_volume_conflicts(spec):
+    with pytest.raises(ValueError):
+        validate_user_volumes(spec)
+
+
+def test_validate_user_volumes_allows_mounts_inside_home():
+    validate_user_volumes(
+        {
+            "volumes": [{"name": "data", "emptyDir": {}}],
+            "volumeMounts": [{"name": "data", "mountPath": "/home/dev/data/"}],
+        }
+    )
+
+
+def test_build_statefulset_with_credential_bundles():
+    spec = {
+        "credentialBundles": [
+            {"name": "git", "secret": {"secretName": "github-token"}},
+            {"name": "pypi", "csi": {"secretProviderClass": "pypi-credentials"}},
+        ]
+    }
+
+    statefulset = build_statefulset("test-server", "test-ns", spec, {"spec": {"resources": {}}})
+
+    pod_spec = statefulset["spec"]["template"]["spec"]
+    volumes = {v["name"]: v for v in pod_spec["volumes"]}
+    assert volumes["credentials-git"]["secret"] == {"secretName": "github-token"}
+    assert volumes["credentials-pypi"]["csi"] == {
+        "driver": "secrets-store.csi.k8s.io",
+        "readOnly": True,
+        "volumeAttributes": {"secretProviderClass": "pypi-credentials"},
+    }
+    container = pod_spec["containers"][0]
+    assert {
+        "name": "credentials-git",
+        "mountPath": "/etc/devserver/credentials/git",
+        "readOnly": True,
+    } in container["volumeMounts"]
+    env = {e["name"]: e.get("value") for e in container["env"]}
+    assert env["DEVSERVER_CREDENTIALS_DIR"] == "/etc/devserver/credentials"
+
+
+@pytest.mark.parametrize(
+    "bundles",
+    [
+        [{"name": "git"}],
+        [{"name": "git", "secret": {"secretName": "a"}, "csi": {"secretProviderClass": "b"}}],
+        [
+            {"name": "git", "secret": {"secretName": "a"}},
+            {"name": "git", "secret": {"secretName": "b"}},
+        ],
+        [{"name": "Git", "secret": {"secretName": "a"}}],
+    ],
+)
+def test_validate_user_credential_bundles_rejects(bundles):
+    with pytest.raises(ValueError):
+        validate_user_credential_bundles({"credentialBundles": bundles})
+
+
+def test_user_volumes_cannot_use_the_credentials_path():
+    spec = {
+        "volumes": [{"name": "creds", "emptyDir": {}}],
+        "volumeMounts": [{"name": "creds", "mountPath": "/etc/devserver/credentials/git"}],
+    }
+    with pytest.raises(ValueError):
+        validate_user_volumes(spec)
+
+
+def test_build_statefulset_with_home_mount_path():
+    spec = {"homeMountPath": "/home/jovyan/", "persistentHome": {"enabled": True}}
+
+    statefulset = build_statefulset("test-server", "test-ns", spec, {"spec": {"resources": {}}})
+
+    container = statefulset["spec"]["template"]["spec"]["containers"][0]
+    assert {"name": "home", "mountPath": "/home/jovyan"} in container["volumeMounts"]
+    assert {"name": "DEVSERVER_HOME", "value": "/home/jovyan"} in container["env"]
+    assert "AuthorizedKeysFile /home/jovyan/.ssh/authorized_keys" in render_sshd_config(spec)
+
+
+@pytest.mark.parametrize("home", ["/", "/opt", "/opt/bin/home", "/devserver", "/scratch"])
+def test_validate_user_home_mount_path_rejects_operator_paths(home):
+    with pytest.raises(ValueError):
+        validate_user_home_mount_path({"homeMountPath": home})
+
+
+def test_validate_user_volumes_rejects_custom_home():
+    with pytest.raises(ValueError):
+        validate_user_volumes(
+            {
+                "homeMountPath": "/home/ubuntu",
+                "sharedVolumes": [{"claimName": "data", "mountPath": "/home"}],
+            }
+        )
+
+
+@pytest.mark.parametrize(
+    "spec, flavor_spec, expected",
+    [
+        ({}, {}, []),
+        ({"command": ["jupyter", "lab"]}, {}, ["jupyter", "lab"]),
+        (
+            {"args": ["--port=8888"]},
+            {"command": ["jupyter"], "args": ["lab"]},
+            ["jupyter", "--port=8888"],
+        ),
+        ({}, {"command": ["jupyter"], "args": ["lab"]}, ["jupyter", "lab"]),
+        ({"command": ["code-server"]}, {"command": ["jupyter"], "args": ["lab"]}, ["code-server"]),
+    ],
+)
+def test_get_main_command(spec, flavor_spec, expected):
+    assert get_main_command(spec, {"spec": flavor_spec}) == expected
+
+
+def test_build_statefulset_runs_custom_command_after_startup():
+    spec = {"command": ["jupyter", "lab"], "args": ["--allow-root"]}
+
+    statefulset = build_statefulset("test-server", "test-ns", spec, {"spec": {"resources": {}}})
+
+    container = statefulset["spec"]["template"]["spec"]["containers"][0]
+    assert container["command"] == ["/bin/sh", "-c"]
+    assert container["args"] == [
+        'exec /devserver/startup.sh "$@"',
+        "startup.sh",
+        "jupyter",
+        "lab",
+        "--allow-root",
+    ]
+
+
+def test_build_statefulset_with_user_env():
+    spec = {
+        "env": [{"name": "HTTPS_PROXY", "value": "http://proxy:3128"}],
+        "envFrom": [{"secretRef": {"name": "my-tokens"}}],
+    }
+
+    statefulset = build_statefulset("test-server", "test-ns", spec, {"spec": {"resources": {}}})
+
+    container = statefulset["spec"]["template"]["spec"]["containers"][0]
+    assert container["env"][0]["name"] == "SSH_PUBLIC_KEY"
+    assert container["env"][-1] == {"name": "HTTPS_PROXY", "value": "http://proxy:3128"}
+    assert container["envFrom"] == [{"secretRef": {"name": "my-tokens"}}]
+
+
+@pytest.mark.parametrize("name", ["SSH_PUBLIC_KEY", "DEVSERVER_MOSH_ENABLED"])
+def test_validate_user_env_rejects_reserved_names(name):
+    with pytest.raises(ValueError):
+        validate_user_env({"env": [{"name": name, "value": "x"}]})
+
+
+def test_build_statefulset_with_sidecars():
+    sidecar = {"name": "node-exporter", "image": "prom/node-exporter:v1.8.1"}
+
+    statefulset = build_statefulset(
+        "test-server", "test-ns", {"sidecars": [sidecar]}, {"spec": {"resources": {}}}
+    )
+
+    containers = statefulset["spec"]["template"]["spec"]["containers"]
+    assert [c["name"] for c in containers] == ["devserver", "node-exporter"]
+    assert containers[1] == sidecar
+
+
+@pytest.mark.parametrize(
+    "sidecars",
+    [
+        [{"name": "devserver", "image": "busybox"}],
+        [{"name": "exporter", "image": "a"}, {"name": "exporter", "image": "b"}],
+    ],
+)
+def test_validate_user_containers_rejects_conflicts(sidecars):
+    with pytest.raises(ValueError):
+        validate_user_containers({"sidecars": sidecars})
+
+
+def test_validate_user_containers_rejects_init_container_conflicts():
+    with pytest.raises(ValueError):
+        validate_user_containers(
+            {
+                "sidecars": [{"name": "setup", "image": "a"}],
+                "initContainers": [{"name": "setup", "image": "b"}],
+            }
+        )
+    with pytest.raises(ValueError):
+        validate_user_containers({"initContainers": [{"name": "init-home", "image": "b"}]})
+    with pytest.raises(ValueError):
+        validate_user_containers({"initContainers": [{"name": "init-dotfiles", "image": "b"}]})
+
+
+def test_build_statefulset_initializes_home_before_user_init_containers():
+    spec = {
+        "image": "ubuntu:24.04",
+        "initContainers": [{"name": "fetch-model", "image": "curlimages/curl"}],
+    }
+
+    statefulset = build_statefulset("test-server", "test-ns", spec, {"spec": {"resources": {}}})
+
+    init_containers = statefulset["spec"]["template"]["spec"]["initContainers"]
+    assert [c["name"] for c in init_containers] == ["install-sshd", "init-home", "fetch-model"]
+    init_home = init_containers[1]
+    assert init_home["image"] == "ubuntu:24.04"
+    assert {"name": "DEV_UID", "value": "1000"} in init_home["env"]
+    assert init_home["volumeMounts"] == [{"name": "home", "mountPath": "/home/dev"}]
+
+
+def test_build_statefulset_mounts_bootstrap_script():
+    spec = {"bootstrap": {"configMapRef": {"name": "team-bootstrap", "key": "setup.sh"}}}
+
+    statefulset = build_statefulset("test-server", "test-ns", spec, {"spec": {"resources": {}}})
+
+    pod_spec = statefulset["spec"]["template"]["spec"]
+    assert {
+        "name": "bootstrap-script",
+        "configMap": {
+            "name": "team-bootstrap",
+            "items": [{"key": "setup.sh", "path": "bootstrap.sh"}],
+            "defaultMode": 0o755,
+        },
+    } in pod_spec["volumes"]
+    container = pod_spec["containers"][0]
+    assert {
+        "name": "bootstrap-script", "mountPath": "/devserver-bootstrap", "readOnly": True
+    } in container["volumeMounts"]
+    assert {
+        "name": "DEVSERVER_BOOTSTRAP_SCRIPT", "value": "/devserver-bootstrap/bootstrap.sh"
+    } in container["env"]
+    with pytest.raises(ValueError):
+        validate_user_home_mount_path({"homeMountPath": "/devserver-bootstrap/home"})
+
+def test_build_statefulset_bootstraps_dotfiles_as_the_owner():
+    spec = {
+        "initContainers": [{"name": "fetch-model", "image": "curlimages/curl"}],
+        "dotfilesRepo": {"url": "https://github.com/alice/dotfiles.git", "ref": "main"},
+    }
+
+    statefulset = build_statefulset(
+        "test-server", "test-ns", spec, {"spec": {"resources": {}}}, owner_ids=PosixIds(2001, 2002)
+    )
+
+    init_containers = statefulset["spec"]["template"]["spec"]["initContainers"]
+    assert [c["name"] for c in init_containers] == [
+        "install-sshd", "init-home", "init-dotfiles", "fetch-model"
+    ]
+    dotfiles = init_containers[2]
+    assert dotfiles["image"] == DEFAULT_DEVSERVER_IMAGE
+    assert dotfiles["securityContext"] == {"runAsUser": 2001, "runAsGroup": 2002}
+    assert {"name": "DOTFILES_REF", "value": "main"} in dotfiles["env"]
+    assert {"name": "DOTFILES_INSTALL_SCRIPT", "value": ""} in dotfiles["env"]
+
+
+@pytest.mark.parametrize(
+    "repo",
+    [
+        {"url": "git@github.com:alice/dotfiles.git"},
+        {"url": "https://github.com/alice/dotfiles.git", "ref": "--upload-pack=touch"},
+        {"url": "https://github.com/alice/dotfiles.git", "installScript": "/etc/install.sh"},
+        {"url": "https://github.com/alice/dotfiles.git", "installScript": "../install.sh"},
+    ],
+)
+def test_validate_user_dotfiles_repo_rejects_invalid_repos(repo):
+    with pytest.raises(ValueError):
+        validate_user_dotfiles_repo({"dotfilesRepo": repo})
+
+
+def test_validate_user_dotfiles_repo_allows_scripts_within_the_repo():
+    repo = {"url": "https://github.com/alice/dotfiles.git", "installScript": "bin/install"}
+
+    validate_user_dotfiles_repo({"dotfilesRepo": repo})
+    validate_user_dotfiles_repo({})
+
+
+def test_build_statefulset_prewarms_packages_before_the_dotfiles():
+    spec = {
+        "dotfilesRepo": {"url": "https://github.com/alice/dotfiles.git"},
+        "prewarm": {"pip": ["torch==2.4.0", "numpy"], "apt": ["libopenmpi-dev"]},
+    }
+
+    statefulset = build_statefulset(
+        "test-server", "test-ns", spec, {"spec": {"resources": {}}}, owner_ids=PosixIds(2001, 2002)
+    )
+
+    pod_spec = statefulset["spec"]["template"]["spec"]
+    assert [c["name"] for c in pod_spec["initContainers"]] == [
+        "install-sshd", "init-home", "prewarm", "init-dotfiles"
+    ]
+    prewarm = pod_spec["initContainers"][2]
+    assert prewarm["securityContext"] == {"runAsUser": 0}
+    assert {"name": "PREWARM_PIP", "value": "torch==2.4.0 numpy"} in prewarm["env"]
+    assert {"name": "PREWARM_CONDA", "value": ""} in prewarm["env"]
+    assert {"name": "DEV_UID", "value": "2001"} in prewarm["env"]
+    assert {
+        "name": "DEVSERVER_PREWARM_DIR", "value": "/home/dev/.cache/devserver-prewarm"
+    } in pod_spec["containers"][0]["env"]
+    with pytest.raises(ValueError):
+        validate_user_containers({"initContainers": [{"name": "prewarm", "image": "b"}]})
+
+
+def test_build_statefulset_skips_prewarm_without_packages():
+    statefulset = build_statefulset(
+        "test-server", "test-ns", {"prewarm": {"pip": []}}, {"spec": {"resources": {}}}
+    )
+
+    pod_spec = statefulset["spec"]["template"]["spec"]
+    assert "prewarm" not in [c["name"] for c in pod_spec["initContainers"]]
+    assert "DEVSERVER_PREWARM_DIR" not in [e["name"] for e in pod_spec["containers"][0]["env"]]
+
+
+@pytest.mark.parametrize(
+    "prewarm", [{"pip": ["--index-url=https://evil"]}, {"apt": ["vim git"]}, {"conda": [""]}]
+)
+def test_validate_user_prewarm_rejects_invalid_packages(prewarm):
+    with pytest.raises(ValueError):
+        validate_user_prewarm({"prewarm": prewarm})
+
+def test_build_resources_propagates_metadata():
+    spec = {
+        "persistentHome": {"enabled": True},
+        "podLabels": {"app": "ignored", "sidecar": "off"},
+        "metadataPropagation": {"labels": ["team"], "annotations": ["*"]},
+    }
+    meta = {
+        "labels": {"team": "ml-infra", "internal": "yes"},
+        "annotations": {
+            "owner-slack": "#ml",
+            "kubectl.kubernetes.io/last-applied-configuration": "{}",
+        },
+    }
+    reconciler = DevServerReconciler(
+        "test-server", "test-ns", spec, {"spec": {"resources": {}}}, meta=meta
+    )
+
+    resources = reconciler.build_resources()
+
+    statefulset = resources["statefulset"]
+    assert statefulset["metadata"]["labels"] == {"team": "ml-infra"}
+    assert statefulset["metadata"]["annotations"] == {"owner-slack": "#ml"}
+    pod_metadata = statefulset["spec"]["template"]["metadata"]
+    assert pod_metadata["labels"] == {"app": "test-server", "sidecar": "off", "team": "ml-infra"}
+    assert SSHD_CONFIG_CHECKSUM_ANNOTATION in pod_metadata["annotations"]
+    claim_metadata = statefulset["spec"]["volumeClaimTemplates"][0]["metadata"]
+    assert claim_metadata["labels"] == {"team": "ml-infra"}
+    assert resources["ssh_service"]["metadata"]["labels"] == {"team": "ml-infra"}
+
+
+def test_build_resources_includes_the_login_banner():
+    spec = {"flavor": "cpu-small", "owner": "alice@example.com", "lifecycle": {"timeToLive": "4h"}}
+    meta = {"creationTimestamp": "2024-05-01T10:00:00Z"}
+    flavor = {"metadata": {"name": "cpu-small"}, "spec": {"resources": {}}}
+    reconciler = DevServerReconciler("test-server", "test-ns", spec, flavor, meta=meta)
+
+    resources = reconciler.build_resources()
+
+    motd = resources["motd_configmap"]
+    assert motd["metadata"]["name"] == "test-server-motd"
+    assert motd["data"]["flavor"] == "cpu-small"
+    assert motd["data"]["owner"] == "alice@example.com"
+    assert motd["data"]["expiresAt"] == "2024-05-01T14:00:00Z"
+    assert motd["data"]["expiresAtEpoch"] == "1714572000"
+    assert motd["data"]["extendCommand"] == "devctl extend test-server <duration> -n test-ns"
+    pod_spec = resources["statefulset"]["spec"]["template"]["spec"]
+    assert {
+        "name": "motd", "configMap": {"name": "test-server-motd", "optional": True}
+    } in pod_spec["volumes"]
+    assert {
+        "name": "motd", "mountPath": "/devserver-motd", "readOnly": True
+    } in pod_spec["containers"][0]["volumeMounts"]
+
+    reconciler.spec = {"flavor": "cpu-small"}
+    motd = reconciler.build_resources()["motd_configmap"]
+    assert motd["data"]["expiresAt"] == "" and motd["data"]["owner"] == ""
+
+    reconciler.spec = {"flavor": "cpu-small", "collaborators": ["bob", "carol"]}
+    assert reconciler.build_resources()["motd_configmap"]["data"]["collaborators"] == "bob, carol"
+
+
+@pytest.mark.parametrize(
+    "spec, flavor_spec, expected",
+    [
+        ({}, {}, {}),
+        (
+            {"enableSSH": True, "ssh": {"port": 2222}},
+            {},
+            {
+                "readinessProbe": {
+                    "tcpSocket": {"port": 2222},
+                    "periodSeconds": 5,
+                    "failureThreshold": 3,
+                }
+            },
+        ),
+        (
+            {"enableSSH": True, "readinessProbe": {}},
+            {"livenessProbe": {"exec": {"command": ["true"]}}},
+            {"livenessProbe": {"exec": {"command": ["true"]}}},
+        ),
+        (
+            {"readinessProbe": {"httpGet": {"port": 8888}}},
+            {"readinessProbe": {"tcpSocket": {"port": 22}}},
+            {"readinessProbe": {"httpGet": {"port": 8888}}},
+        ),
+    ],
+)
+def test_build_probes(spec, flavor_spec, expected):
+    assert build_probes(spec, {"spec": flavor_spec}) == expected
+
+
+def test_build_statefulset_with_flavor_gpu():
+    flavor = {
+        "spec": {
+            "resources": {"limits": {"cpu": "8"}},
+            "gpu": {"count": 2, "product": "NVIDIA-A100-SXM4-80GB", "migProfile": "3g.40gb"},
+        }
+    }
+
+    statefulset = build_statefulset("test-server", "test-ns", {}, flavor)
+
+    pod_spec = statefulset["spec"]["template"]["spec"]
+    assert pod_spec["nodeSelector"] == {"nvidia.com/gpu.product": "NVIDIA-A100-SXM4-80GB"}
+    assert pod_spec["containers"][0]["resources"] == {
+        "requests": {"nvidia.com/mig-3g.40gb": "2"},
+        "limits": {"cpu": "8", "nvidia.com/mig-3g.40gb": "2"},
+    }
+    assert flavor["spec"]["resources"] == {"limits": {"cpu": "8"}}
+
+
+def test_build_statefulset_with_time_sliced_gpu():
+    flavor = {"spec": {"gpu": {"count": 1, "sharing": "TimeSlicing"}}}
+
+    statefulset = build_statefulset("test-server", "test-ns", {}, flavor)
+
+    assert statefulset["spec"]["template"]["spec"]["containers"][0]["resources"] == {
+        "requests": {"nvidia.com/gpu.shared": "1"},
+        "limits": {"nvidia.com/gpu.shared": "1"},
+    }
+
+
+def test_build_statefulset_with_host_namespaces():
+    spec = {"hostNetwork": True, "hostIPC": True}
+
+    statefulset = build_statefulset("test-server", "test-ns", spec, {"spec": {"resources": {}}})
+
+    pod_spec = statefulset["spec"]["template"]["spec"]
+    assert pod_spec["hostNetwork"] is True
+    assert pod_spec["hostIPC"] is True
+    assert pod_spec["dnsPolicy"] == "ClusterFirstWithHostNet"
+
+
+@pytest.mark.parametrize(
+    "spec, policy, allowed",
+    [
+        ({}, {}, True),
+        ({"hostNetwork": True}, {}, False),
+        ({"hostNetwork": True}, {"hostNetwork": True}, True),
+        ({"hostNetwork": True, "hostIPC": True}, {"hostNetwork": True}, False),
+        ({"owner": "Alice", "hostIPC": True}, {"hostIPC": True, "allowedOwners": ["alice"]}, True),
+        ({"owner": "bob", "hostIPC": True}, {"hostIPC": True, "allowedOwners": ["alice"]}, False),
+        ({"owner": "bob"}, {"hostIPC": True, "allowedOwners": ["alice"]}, True),
+        ({"sidecars": [{"name": "a", "securityContext": {"privileged": True}}]}, {}, False),
+        ({"sidecars": [{"name": "a", "securityContext": {"runAsNonRoot": True}}]}, {}, True),
+        (
+            {"initContainers": [{"name": "a", "securityContext": {"runAsUser": 0}}]},
+            {"hostNetwork": True},
+            False,
+        ),
+        (
+            {"sidecars": [{"name": "a", "securityContext": {"capabilities": {"add": ["NET_ADMIN"]}}}]},
+            {"privilegedContainers": True},
+            True,
+        ),
+        ({"sidecars": [{"name": "a", "ports": [{"hostPort": 80}]}]}, {}, False),
+        (
+            {"owner": "bob", "sidecars": [{"name": "a", "securityContext": {"privileged": True}}]},
+            {"privilegedContainers": True, "allowedOwners": ["alice"]},
+            False,
+        ),
+    ],
+)
+def test_validate_user_host_access(spec, policy, allowed):
+    flavor = {"spec": {"hostAccess": policy}}
+    if allowed:
+        validate_user_host_access({"flavor": "gpu", **spec}, flavor, owner_identity=True)
+    else:
+        with pytest.raises(ValueError):
+            validate_user_host_access({"flavor": "gpu", **spec}, flavor, owner_identity=True)
+
+
+def test_validate_user_host_access_only_trusts_requesters_or_verified_owners():
+    flavor = {"spec": {"hostAccess": {"hostIPC": True, "allowedOwners": ["alice"]}}}
+    spec = {"flavor": "gpu", "owner": "alice", "hostIPC": True}
+
+    # Without owner identity, anyone could have set spec.owner
+    with pytest.raises(ValueError, match="DEVSERVER_OWNER_IDENTITY_ENABLED"):
+        validate_user_host_access(spec, flavor)
+    validate_user_host_access(spec, flavor, requesters=["alice", "alice@example.com"])
+    with pytest.raises(ValueError):
+        validate_user_host_access(spec, flavor, requesters=["bob"])
+
+
+def test_build_statefulset_restricted_pod_security():
+    spec = {
+        "ssh": {"port": 2222},
+        "initContainers": [{"name": "setup", "image": "busybox"}],
+        "sidecars": [{"name": "logs", "image": "fluent-bit"}],
+    }
+
+    statefulset = build_statefulset(
+        "test-server",
+        "test-ns",
+        spec,
+        {"spec": {"resources": {}}},
+        PosixIds(uid=1234, gid=5678),
+        restricted=True,
+    )
+
+    pod_spec = statefulset["spec"]["template"]["spec"]
+    assert pod_spec["securityContext"] == {
+        "runAsNonRoot": True,
+        "runAsUser": 1234,
+        "runAsGroup": 5678,
+        "fsGroup": 5678,
+        "fsGroupChangePolicy": "OnRootMismatch",
+        "seccompProfile": {"type": "RuntimeDefault"},
+    }
+    containers = [*pod_spec["initContainers"], *pod_spec["containers"]]
+    assert {"init-home", "setup", "logs"} <= {c["name"] for c in containers}
+    for container in containers:
+        security_context = container["securityContext"]
+        assert security_context["allowPrivilegeEscalation"] is False
+        assert security_context["capabilities"] == {"drop": ["ALL"]}
+        assert security_context.get("runAsUser") != 0
+    env = {e["name"]: e.get("value") for e in pod_spec["containers"][0]["env"]}
+    assert env["DEVSERVER_SSHD_DIR"] == "/tmp/devserver-ssh"
+
+
+def test_build_statefulset_restricted_pod_security_flavor_exceptions():
+    flavor = {
+        "spec": {
+            "resources": {"limits": {"nvidia.com/gpu": 1}},
+            "podSecurityExceptions": {
+                "supplementalGroups": [44, 109],
+                "capabilities": ["IPC_LOCK"],
+            },
+        }
+    }
+
+    statefulset = build_statefulset(
+        "test-server", "test-ns", {"ssh": {"port": 2222}}, flavor, restricted=True
+    )
+
+    pod_spec = statefulset["spec"]["template"]["spec"]
+    assert pod_spec["securityContext"]["runAsUser"] == 1000
+    assert pod_spec["securityContext"]["supplementalGroups"] == [44, 109]
+    assert pod_spec["containers"][0]["securityContext"]["capabilities"] == {
+        "drop": ["ALL"],
+        "add": ["IPC_LOCK"],
+    }
+
+
+def test_render_sshd_config_restricted_keeps_files_writable():
+    lines = render_sshd_config({"ssh": {"port": 2222}}, restricted=True).splitlines()
+
+    assert "HostKey /tmp/devserver-ssh/ssh_host_ed25519_key" in lines
+    assert "PidFile /tmp/devserver-ssh/sshd.pid" in lines
+
+    default_lines = render_sshd_config({}).splitlines()
+    assert "HostKey /etc/ssh/ssh_host_ed25519_key" in default_lines
+    assert not any(line.startswith("PidFile") for line in default_lines)
+
+
+def test_build_statefulset_enforces_flavor_security_profiles():
+    flavor = {
+        "spec": {
+            "resources": {},
+            "securityProfiles": {
+                "seccomp": {"type": "Localhost", "localhostProfile": "profiles/devserver.json"},
+                "appArmor": {"type": "RuntimeDefault"},
+            },
+        }
+    }
+    spec = {
+        "sidecars": [
+            {
+                "name": "logs",
+                "image": "fluent-bit",
+                "securityContext": {"seccompProfile": {"type": "Unconfined"}, "runAsUser": 1000},
+            }
+        ]
+    }
+
+    statefulset = build_statefulset("test-server", "test-ns", spec, flavor, restricted=True)
+
+    pod_spec = statefulset["spec"]["template"]["spec"]
+    assert pod_spec["securityContext"]["seccompProfile"] == {
+        "type": "Localhost",
+        "localhostProfile": "profiles/devserver.json",
+    }
+    assert pod_spec["securityContext"]["appArmorProfile"] == {"type": "RuntimeDefault"}
+    sidecar = next(c for c in pod_spec["containers"] if c["name"] == "logs")
+    assert "seccompProfile" not in sidecar["securityContext"]
+    assert sidecar["securityContext"]["runAsUser"] == 1000
+
+
+def test_build_statefulset_without_security_profiles():
+    statefulset = build_statefulset("test-server", "test-ns", {}, {"spec": {"resources": {}}})
+
+    assert "securityContext" not in statefulset["spec"]["template"]["spec"]
+
+
+@pytest.mark.parametrize(
+    "profiles, restricted, valid",
+    [
+        ({"seccomp": {"type": "RuntimeDefault"}}, True, True),
+        ({"seccomp": {"type": "Localhost", "localhostProfile": "p.json"}}, True, True),
+        ({"seccomp": {"type": "Localhost"}}, False, False),
+        ({"appArmor": {"type": "RuntimeDefault", "localhostProfile": "p"}}, False, False),
+        ({"appArmor": {"type": "Unconfined"}}, False, True),
+        ({"appArmor": {"type": "Unconfined"}}, True, False),
+    ],
+)
+def test_validate_user_security_profiles(profiles, restricted, valid):
+    flavor = {"spec": {"securityProfiles": profiles}}
+    if valid:
+        validate_user_security_profiles(flavor, restricted)
+    else:
+        with pytest.raises(ValueError):
+            validate_user_security_profiles(flavor, restricted)
+
+
+@pytest.mark.parametrize(
+    "spec, allowed",
+    [
+        ({"ssh": {"port": 2222}}, True),
+        ({}, False),
+        ({"ssh": {"port": 1023}}, False),
+        ({"ssh": {"port": 2222}, "hostNetwork": True}, False),
+    ],
+)
+def test_validate_user_restricted_pod_security(spec, allowed):
+    if allowed:
+        validate_user_restricted_pod_security(spec)
+    else:
+        with pytest.raises(ValueError):
+            validate_user_restricted_pod_security(spec)
+
+
+def _scratch_statefulset(scratch, resources):
+    flavor = {"spec": {"resources": resources}}
+    statefulset = build_statefulset("test-server", "test-ns", {"scratch": scratch}, flavor)
+    pod_spec = statefulset["spec"]["template"]["spec"]
+    volume = next(v for v in pod_spec["volumes"] if v["name"] == "scratch")
+    return volume, pod_spec["containers"][0]
+
+
+def test_build_statefulset_with_disk_scratch():
+    resources = {
+        "requests": {"cpu": "4", "ephemeral-storage": "10Gi"},
+        "limits": {"ephemeral-storage": "20Gi"},
+    }
+
+    volume, container = _scratch_statefulset({"size": "100Gi"}, resources)
+
+    assert volume["emptyDir"] == {"sizeLimit": "100Gi"}
+    assert {"name": "scratch", "mountPath": "/scratch"} in container["volumeMounts"]
+    assert container["resources"]["requests"]["ephemeral-storage"] == str(110 * 2**30)
+    assert container["resources"]["limits"]["ephemeral-storage"] == str(120 * 2**30)
+    # The flavor is left untouched
+    assert resources["requests"]["ephemeral-storage"] == "10Gi"
+
+
+def test_build_statefulset_with_memory_scratch():
+    volume, container = _scratch_statefulset(
+        {"size": "8Gi", "medium": "Memory"}, {"requests": {"memory": "16Gi"}}
+    )
+
+    assert volume["emptyDir"] == {"sizeLimit": "8Gi", "medium": "Memory"}
+    assert container["resources"]["requests"]["memory"] == str(24 * 2**30)
+    assert "limits" not in container["resources"]
+
+
+def test_build_statefulset_with_ephemeral_volume_scratch():
+    volume, container = _scratch_statefulset(
+        {"size": "500Gi", "storageClassName": "nvme"}, {"requests": {"cpu": "4"}}
+    )
+
+    claim_spec = volume["ephemeral"]["volumeClaimTemplate"]["spec"]
+    assert claim_spec["storageClassName"] == "nvme"
+    assert claim_spec["resources"] == {"requests": {"storage": "500Gi"}}
+    assert container["resources"] == {"requests": {"cpu": "4"}}
+
+
+def test_render_sshd_config_applies_port_and_overrides():
+    spec = {
+        "ssh": {
+            "port": 2222,
+            "sshdConfig": {"allowagentforwarding": "no", "ClientAliveInterval": "60"},
+        }
+    }
+
+    lines = render_sshd_config(spec).splitlines()
+
+    assert "Port 2222" in lines
+    assert "AllowAgentForwarding yes" not in lines
+    assert "allowagentforwarding no" in lines
+    assert "ClientAliveInterval 60" in lines
+    assert "ForceCommand /devserver-login/user_login.sh" in lines
+
+
+def test_managed_sshd_directives_cannot_be_overridden():
+    spec = {"ssh": {"sshdConfig": {"port": "2222", "HostKey": "/tmp/key", "MaxSessions": "4"}}}
+    assert get_managed_sshd_overrides(spec) == ["HostKey", "port"]
+
+
+def test_build_statefulset_uses_ssh_port_and_rolls_on_sshd_config_change():
+    flavor = {"spec": {"resources": {}}}
+
+    default = build_statefulset("test-server", "test-ns", {}, flavor)
+    custom = build_statefulset("test-server", "test-ns", {"ssh": {"port": 2222}}, flavor)
+
+    container = custom["spec"]["template"]["spec"]["containers"][0]
+    assert container["ports"] == [{"name": "ssh", "containerPort": 2222, "protocol": "TCP"}]
+
+    def checksum(statefulset):
+        return statefulset["spec"]["template"]["metadata"]["annotations"][
+            SSHD_CONFIG_CHECKSUM_ANNOTATION
+        ]
+
+    assert checksum(default) != checksum(custom)
+
+
+def test_build_ssh_service_defaults_to_node_port():
+    service = build_ssh_service("test-server", "test-ns")
+    assert service["spec"]["type"] == "NodePort"
+    assert "annotations" not in service["metadata"]
+
+
+def test_build_ssh_service_with_load_balancer_and_annotations():
+    annotations = {"service.beta.kubernetes.io/aws-load-balancer-scheme": "internal"}
+    spec = {"ssh": {"serviceType": "LoadBalancer", "serviceAnnotations": annotations}}
+
+    service = build_ssh_service("test-server", "test-ns", spec)
+
+    assert service["spec"]["type"] == "LoadBalancer"
+    assert service["metadata"]["annotations"] == annotations
+    assert service["spec"]["ports"][0]["targetPort"] == "ssh"
+
+
+def test_mosh_exposes_udp_port_range():
+    spec = {"enableSSH": True, "mosh": {"enabled": True, "portRange": {"start": 30100, "end": 30102}}}
+
+    service = build_ssh_service("test-server", "test-ns", spec)
+    statefulset = build_statefulset("test-server", "test-ns", spec, {"spec": {"resources": {}}})
+
+    mosh_ports = [p for p in service["spec"]["ports"] if p["protocol"] == "UDP"]
+    assert [p["port"] for p in mosh_ports] == [30100, 30101, 30102]
+    # NodePort services pin the node port to the port mosh listens on
+    assert all(p["nodePort"] == p["port"] for p in mosh_ports)
+
+    container = statefulset["spec"]["template"]["spec"]["containers"][0]
+    assert {"name": "mosh-30100", "containerPort": 30100, "protocol": "UDP"} in container["ports"]
+    assert {"name": "DEVSERVER_MOSH_ENABLED", "value": "true"} in container["env"]
+
+
+def test_mosh_disabled_by_default():
+    service = build_ssh_service("test-server", "test-ns", {"enableSSH": True})
+    assert [p["protocol"] for p in service["spec"]["ports"]] == ["TCP"]
+
+
+def test_owner_ssh_keys_secret_name_is_dns_safe():
+    name = owner_ssh_keys_secret_name("x" * 80 + "@example.com")
+    assert len(name) <= 63
+    assert name.endswith("-ssh-keys")
+
+
 def test_compute_user_namespace_default():
     spec = {"username": "alice"}
     reconciler = DevServerUserReconciler(spec=spec, metadata={})
@@ -166,3 +1341,437 @@ async def test_devserver_user_reconciler_creates_namespace(monkeypatch):
         "name": "bob-sa",
         "namespace": "dev-bob",
     } in subjects
+
+
+def test_build_statefulset_restores_home_from_snapshot():
+    spec = {
+        "persistentHome": {"enabled": True, "size": "50Gi"},
+        "homeSource": {"snapshotRef": {"name": "before-upgrade"}},
+    }
+
+    statefulset = build_statefulset("test-server", "test-ns", spec, {"spec": {"resources": {}}})
+
+    vct = statefulset["spec"]["volumeClaimTemplates"][0]
+    assert vct["spec"]["dataSource"] == {
+        "apiGroup": "snapshot.storage.k8s.io",
+        "kind": "VolumeSnapshot",
+        "name": "before-upgrade",
+    }
+
+
+def test_build_statefulset_with_flavor_priority():
+    flavor = {
+        "spec": {
+            "resources": {},
+            "priorityClassName": "interactive",
+            "preemptionPolicy": "Never",
+        }
+    }
+
+    pod_spec = build_statefulset("test-server", "test-ns", {}, flavor)["spec"]["template"]["spec"]
+
+    assert pod_spec["priorityClassName"] == "interactive"
+    assert pod_spec["preemptionPolicy"] == "Never"
+    assert "priorityClassName" not in build_statefulset(
+        "test-server", "test-ns", {}, {"spec": {"resources": {}}}
+    )["spec"]["template"]["spec"]
+
+
+
+TEAM_FLAVOR = {
+    "metadata": {"name": "gpu"},
+    "spec": {
+        "resources": {},
+        "nodeSelector": {"pool": "gpu"},
+        "teamNodePools": [
+            {
+                "name": "ml",
+                "namespaces": ["ml"],
+                "owners": ["Alice@example.com"],
+                "nodeSelector": {"team": "ml"},
+                "tolerations": [{"key": "team", "value": "ml", "effect": "NoSchedule"}],
+            }
+        ],
+    },
+}
+
+
+@pytest.mark.parametrize(
+    "namespace, owner, on_team_pool",
+    [("ml", "bob", True), ("shared", "alice@example.com", True), ("shared", "bob", False)],
+)
+def test_build_statefulset_on_team_node_pool(namespace, owner, on_team_pool):
+    pod_spec = build_statefulset("test-server", namespace, {"owner": owner}, TEAM_FLAVOR)[
+        "spec"
+    ]["template"]["spec"]
+
+    if on_team_pool:
+        assert pod_spec["nodeSelector"] == {"pool": "gpu", "team": "ml"}
+        assert pod_spec["tolerations"] == [{"key": "team", "value": "ml", "effect": "NoSchedule"}]
+    else:
+        assert pod_spec["nodeSelector"] == {"pool": "gpu"}
+        assert "tolerations" not in pod_spec
+
+
+def test_validate_user_team_node_pool():
+    validate_user_team_node_pool("shared", {"owner": "bob"}, TEAM_FLAVOR)
+    required = {"metadata": TEAM_FLAVOR["metadata"], "spec": {**TEAM_FLAVOR["spec"]}}
+    required["spec"]["teamNodePoolRequired"] = True
+    validate_user_team_node_pool("ml", {"owner": "bob"}, required)
+    with pytest.raises(ValueError, match="no team node pool of flavor 'gpu'"):
+        validate_user_team_node_pool("shared", {"owner": "bob"}, required)
+
+    conflicting = {**TEAM_FLAVOR["spec"], "nodeSelector": {"team": "infra"}}
+    with pytest.raises(ValueError, match="contradicts the flavor's on team"):
+        validate_user_team_node_pool("ml", {}, {"spec": conflicting})
+
+
+def test_build_statefulset_with_flavor_topology_spread():
+    zone_spread = {
+        "maxSkew": 1,
+        "topologyKey": "topology.kubernetes.io/zone",
+        "whenUnsatisfiable": "ScheduleAnyway",
+    }
+    team_spread = {
+        "maxSkew": 2,
+        "topologyKey": "kubernetes.io/hostname",
+        "whenUnsatisfiable": "DoNotSchedule",
+        "labelSelector": {"matchLabels": {"team": "ml"}},
+    }
+    flavor = {
+        "metadata": {"name": "gpu"},
+        "spec": {
+            "resources": {},
+            "topologySpreadConstraints": [zone_spread, team_spread],
+            "schedulerName": "bin-packing",
+        },
+    }
+
+    template = build_statefulset("test-server", "test-ns", {}, flavor)["spec"]["template"]
+
+    assert template["metadata"]["labels"]["devserver.io/flavor"] == "gpu"
+    assert template["spec"]["topologySpreadConstraints"] == [
+        {"labelSelector": {"matchLabels": {"devserver.io/flavor": "gpu"}}, **zone_spread},
+        team_spread,
+    ]
+    assert template["spec"]["schedulerName"] == "bin-packing"
+    pod_template = build_statefulset(
+        "test-server", "test-ns", {}, {"spec": {"resources": {}}}
+    )["spec"]["template"]
+    assert "devserver.io/flavor" not in pod_template["metadata"]["labels"]
+    assert "topologySpreadConstraints" not in pod_template["spec"]
+
+
+@pytest.mark.parametrize(
+    "flavor_spec, packed",
+    [
+        ({}, True),
+        ({"resources": {"limits": {"nvidia.com/gpu": "1"}}}, False),
+        ({"resources": {"limits": {"nvidia.com/gpu": "1"}}, "packing": True}, True),
+        ({"packing": False}, False),
+    ],
+)
+def test_build_statefulset_with_packing(flavor_spec, packed):
+    own_term = {"weight": 10, "podAffinityTerm": {"topologyKey": "zone"}}
+    spec = {
+        "affinity": {
+            "podAffinity": {"preferredDuringSchedulingIgnoredDuringExecution": [own_term]}
+        }
+    }
+    flavor = {"spec": {"resources": {}, **flavor_spec}}
+
+    template = build_statefulset(
+        "test-server", "test-ns", spec, flavor, packing=PackingDefaults(enabled=True, weight=50)
+    )["spec"]["template"]
+
+    preferred = template["spec"]["affinity"]["podAffinity"][
+        "preferredDuringSchedulingIgnoredDuringExecution"
+    ]
+    assert preferred[0] == own_term
+    if packed:
+        assert template["metadata"]["labels"]["devserver.io/packed"] == "true"
+        assert preferred[1] == {
+            "weight": 50,
+            "podAffinityTerm": {
+                "labelSelector": {"matchLabels": {"devserver.io/packed": "true"}},
+                "namespaceSelector": {},
+                "topologyKey": "kubernetes.io/hostname",
+            },
+        }
+    else:
+        assert "devserver.io/packed" not in template["metadata"]["labels"]
+        assert len(preferred) == 1
+    # The DevServer's own affinity is left as it is
+    assert spec["affinity"]["podAffinity"] == {
+        "preferredDuringSchedulingIgnoredDuringExecution": [own_term]
+    }
+
+@pytest.mark.parametrize(
+    "priority_class, preemption_policy",
+    [("interactive", "Never"), ("on-call", None)],
+)
+def test_build_statefulset_with_devserver_priority(priority_class, preemption_policy):
+    flavor = {
+        "spec": {
+            "resources": {},
+            "priorityClassName": "interactive",
+            "preemptionPolicy": "Never",
+            "allowedPriorityClasses": ["on-call"],
+        }
+    }
+    spec = {"priorityClassName": priority_class}
+
+    pod_spec = build_statefulset("test-server", "test-ns", spec, flavor)["spec"]["template"]["spec"]
+
+    assert pod_spec["priorityClassName"] == priority_class
+    assert pod_spec.get("preemptionPolicy") == preemption_policy
+
+
+@pytest.mark.parametrize(
+    "priority_class, allowed",
+    [(None, True), ("interactive", True), ("on-call", True), ("critical", False)],
+)
+def test_validate_user_priority_class(priority_class, allowed):
+    spec = {"flavor": "gpu", "priorityClassName": priority_class}
+    flavor = {
+        "spec": {"priorityClassName": "interactive", "allowedPriorityClasses": ["on-call"]}
+    }
+
+    if allowed:
+        validate_user_priority_class(spec, flavor)
+    else:
+        with pytest.raises(ValueError, match="does not allow priorityClassName 'critical'"):
+            validate_user_priority_class(spec, flavor)
+
+
+@pytest.mark.asyncio
+async def test_statefulset_is_server_side_applied_with_its_existing_claim_templates():
+    spec = {"persistentHome": {"enabled": True, "storageClassName": "fast"}}
+    reconciler = DevServerReconciler("test-server", "test-ns", spec, {"spec": {"resources": {}}})
+    statefulset = reconciler.build_resources()["statefulset"]
+    existing_templates = [{"metadata": {"name": "home"}, "spec": {"storageClassName": "slow"}}]
+    reconciler.apps_v1 = MagicMock()
+    reconciler.apps_v1.api_client.sanitize_for_serialization.return_value = {
+        "metadata": {"name": "test-server"},
+        "spec": {"volumeClaimTemplates": existing_templates},
+    }
+
+    await reconciler._reconcile_statefulset(statefulset, MagicMock())
+
+    kwargs = reconciler.apps_v1.patch_namespaced_stateful_set.call_args.kwargs
+    assert kwargs["field_manager"] == "devserver-operator"
+    assert kwargs["force"] is True
+    assert kwargs["_content_type"] == "application/apply-patch+yaml"
+    # Immutable, so the existing templates are kept rather than the changed storage class
+    assert kwargs["body"]["spec"]["volumeClaimTemplates"] == existing_templates
+    assert kwargs["body"]["spec"]["template"] == statefulset["spec"]["template"]
+
+
+@pytest.mark.asyncio
+async def test_unchanged_statefulset_is_not_applied_again():
+    reconciler = DevServerReconciler("test-server", "test-ns", {}, {"spec": {"resources": {}}})
+    statefulset = reconciler.build_resources()["statefulset"]
+    reconciler.apps_v1 = MagicMock()
+    reconciler.apps_v1.api_client.sanitize_for_serialization.return_value = {
+        "metadata": {"name": "test-server", "resourceVersion": "42"},
+        "spec": {},
+        "status": {"replicas": 1},
+    }
+
+    await reconciler._reconcile_statefulset(statefulset, MagicMock())
+    applied = reconciler.apps_v1.patch_namespaced_stateful_set.call_args.kwargs["body"]
+    reconciler.apps_v1.api_client.sanitize_for_serialization.return_value = {
+        **applied,
+        "status": {"replicas": 1},
+    }
+    reconciler.apps_v1.patch_namespaced_stateful_set.reset_mock()
+
+    await reconciler._reconcile_statefulset(statefulset, MagicMock())
+
+    reconciler.apps_v1.patch_namespaced_stateful_set.assert_not_called()
+
+
+OWNER_REFERENCE = {
+    "apiVersion": "devserver.io/v1",
+    "kind": "DevServer",
+    "name": "test-server",
+    "uid": "devserver-uid",
+    "controller": True,
+}
+
+
+@pytest.mark.asyncio
+async def test_orphaned_service_is_adopted():
+    recorder = MagicMock(normal=AsyncMock())
+    reconciler = DevServerReconciler(
+        "test-server", "test-ns", {}, {"spec": {"resources": {}}}, recorder, {}
+    )
+    service = reconciler.build_resources()["headless_service"]
+    service["metadata"]["ownerReferences"] = [OWNER_REFERENCE]
+    reconciler.core_v1 = MagicMock()
+    reconciler.core_v1.api_client.sanitize_for_serialization.return_value = {
+        "metadata": {"name": "test-server-headless"},
+        "spec": {},
+    }
+
+    await reconciler._reconcile_service(service, MagicMock())
+
+    assert recorder.normal.call_args.args[1] == "Adopted"
+    body = reconciler.core_v1.patch_namespaced_service.call_args.kwargs["body"]
+    assert body["metadata"]["ownerReferences"] == [OWNER_REFERENCE]
+
+
+@pytest.mark.asyncio
+async def test_statefulset_controlled_by_something_else_is_not_taken_over():
+    reconciler = DevServerReconciler("test-server", "test-ns", {}, {"spec": {"resources": {}}})
+    statefulset = reconciler.build_resources()["statefulset"]
+    statefulset["metadata"]["ownerReferences"] = [OWNER_REFERENCE]
+    reconciler.apps_v1 = MagicMock()
+    reconciler.apps_v1.api_client.sanitize_for_serialization.return_value = {
+        "metadata": {
+            "name": "test-server",
+            "ownerReferences": [
+                {"kind": "Deployment", "name": "other", "uid": "other-uid", "controller": True}
+            ],
+        },
+        "spec": {},
+    }
+
+    with pytest.raises(kopf.TemporaryError):
+        await reconciler._reconcile_statefulset(statefulset, MagicMock())
+
+    reconciler.apps_v1.patch_namespaced_stateful_set.assert_not_called()
+
+
+def test_network_policy_is_only_built_when_enabled():
+    defaults = NetworkPolicyDefaults(enabled=False, ssh_cidrs=["10.1.0.0/16"])
+
+    assert build_devserver_network_policy("dev", "ns", {}, defaults) is None
+    assert build_devserver_network_policy(
+        "dev", "ns", {"networkPolicy": {"enabled": True}}, defaults
+    ) is not None
+
+
+def test_network_policy_allows_ssh_from_approved_sources_only():
+    defaults = NetworkPolicyDefaults(
+        enabled=True, ssh_cidrs=["10.1.0.0/16"], egress_namespaces=["datasets"]
+    )
+    spec = {
+        "ssh": {"port": 2222},
+        "mosh": {"enabled": True, "portRange": {"start": 60000, "end": 60001}},
+        "networkPolicy": {"sshNamespaces": ["ssh-gateway"]},
+    }
+
+    policy = build_devserver_network_policy("dev", "ns", spec, defaults)
+
+    assert policy["metadata"]["name"] == "dev-isolation"
+    assert policy["spec"]["podSelector"] == {"matchLabels": {"app": "dev"}}
+    assert policy["spec"]["policyTypes"] == ["Ingress", "Egress"]
+    [ingress] = policy["spec"]["ingress"]
+    assert ingress["from"] == [
+        {"ipBlock": {"cidr": "10.1.0.0/16"}},
+        {"namespaceSelector": {"matchLabels": {"kubernetes.io/metadata.name": "ssh-gateway"}}},
+    ]
+    assert ingress["ports"] == [
+        {"protocol": "TCP", "port": 2222},
+        {"protocol": "UDP", "port": 60000},
+        {"protocol": "UDP", "port": 60001},
+    ]
+    assert policy["spec"]["egress"][-1] == {
+        "to": [{"namespaceSelector": {"matchLabels": {"kubernetes.io/metadata.name": "datasets"}}}]
+    }
+
+
+def test_network_policy_without_sources_denies_all_ingress():
+    policy = build_devserver_network_policy(
+        "dev", "ns", {}, NetworkPolicyDefaults(enabled=True)
+    )
+
+    assert policy["spec"]["ingress"] == []
+
+
+@pytest.mark.asyncio
+async def test_network_policy_is_deleted_when_isolation_is_turned_off():
+    reconciler = DevServerReconciler("test-server", "test-ns", {}, {"spec": {"resources": {}}})
+    reconciler.networking_v1 = MagicMock()
+
+    await reconciler._reconcile_network_policy(None, MagicMock())
+
+    reconciler.networking_v1.delete_namespaced_network_policy.assert_called_once_with(
+        name="test-server-isolation", namespace="test-ns"
+    )
+
+
+def test_devserver_runs_as_its_own_service_account():
+    pod_spec = build_statefulset("test-server", "test-ns", {}, {"spec": {"resources": {}}})[
+        "spec"
+    ]["template"]["spec"]
+
+    assert pod_spec["serviceAccountName"] == "test-server-devserver"
+    assert pod_spec["automountServiceAccountToken"] is False
+
+    spec = {"serviceAccount": {"automountToken": True}}
+    pod_spec = build_statefulset("test-server", "test-ns", spec, {"spec": {"resources": {}}})[
+        "spec"
+    ]["template"]["spec"]
+    assert pod_spec["automountServiceAccountToken"] is True
+
+
+def test_role_binding_is_only_built_with_a_role_template():
+    reconciler = DevServerReconciler("test-server", "test-ns", {}, {"spec": {"resources": {}}})
+    assert "role_binding" not in reconciler.build_resources()
+
+    spec = {"serviceAccount": {"roleTemplate": "devserver-view"}}
+    reconciler = DevServerReconciler("test-server", "test-ns", spec, {"spec": {"resources": {}}})
+    role_binding = reconciler.build_resources()["role_binding"]
+
+    assert role_binding["roleRef"]["kind"] == "ClusterRole"
+    assert role_binding["roleRef"]["name"] == "devserver-view"
+    assert role_binding["subjects"] == [
+        {"kind": "ServiceAccount", "name": "test-server-devserver", "namespace": "test-ns"}
+    ]
+
+
+@pytest.mark.asyncio
+async def test_role_binding_to_another_role_template_is_replaced():
+    spec = {"serviceAccount": {"roleTemplate": "devserver-edit"}}
+    reconciler = DevServerReconciler("test-server", "test-ns", spec, {"spec": {"resources": {}}})
+    role_binding = reconciler.build_resources()["role_binding"]
+    reconciler.rbac_v1 = MagicMock()
+    reconciler.rbac_v1.api_client.sanitize_for_serialization.return_value = {
+        "metadata": {"name": "test-server-devserver"},
+        "roleRef": {**role_binding["roleRef"], "name": "devserver-view"},
+    }
+
+    await reconciler._reconcile_role_binding(role_binding, MagicMock())
+
+    reconciler.rbac_v1.delete_namespaced_role_binding.assert_called_once_with(
+        name="test-server-devserver", namespace="test-ns"
+    )
+    body = reconciler.rbac_v1.patch_namespaced_role_binding.call_args.kwargs["body"]
+    assert body["roleRef"]["name"] == "devserver-edit"
+
+
+def test_service_account_carries_workload_identity_annotations():
+    flavor = {
+        "spec": {
+            "resources": {},
+            "serviceAccountAnnotations": {
+                "eks.amazonaws.com/role-arn": "arn:aws:iam::111122223333:role/devserver",
+                "eks.amazonaws.com/sts-regional-endpoints": "true",
+            },
+        }
+    }
+    spec = {
+        "serviceAccount": {
+            "annotations": {"eks.amazonaws.com/role-arn": "arn:aws:iam::111122223333:role/alice"}
+        }
+    }
+    reconciler = DevServerReconciler("test-server", "test-ns", spec, flavor)
+
+    service_account = reconciler.build_resources()["service_account"]
+
+    assert service_account["metadata"]["annotations"] == {
+        "eks.amazonaws.com/role-arn": "arn:aws:iam::111122223333:role/alice",
+        "eks.amazonaws.com/sts-regional-endpoints": "true",
+    }
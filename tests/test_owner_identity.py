@@ -0,0 +1,97 @@
+import base64
+import json
+
+import pytest
+
+from devservers.operator.devserver.owner_identity import (
+    operator_service_account,
+    requester_owners,
+    resolve_owner,
+)
+
+OPERATOR = "system:serviceaccount:devservers:operator"
+
+USERS = [
+    {
+        "spec": {
+            "username": "alice",
+            "email": "alice@example.com",
+            "rbac": {"subjects": [{"kind": "User", "name": "oidc:a.smith"}]},
+        }
+    },
+    {"spec": {"username": "bob", "email": "bob@example.com"}},
+]
+
+
+@pytest.mark.parametrize(
+    "username, expected",
+    [
+        ("oidc:a.smith", ["alice", "alice@example.com", "a.smith"]),
+        ("oidc:bob@example.com", ["bob", "bob@example.com"]),
+        ("oidc:carol", ["carol"]),
+        ("system:serviceaccount:ci:deployer", ["system:serviceaccount:ci:deployer"]),
+    ],
+)
+def test_requester_owners(username, expected):
+    userinfo = {"username": username}
+    assert requester_owners(userinfo, USERS, username_prefix="oidc:") == expected
+
+
+@pytest.mark.parametrize(
+    "owner, expected",
+    [(None, "bob"), ("", "bob"), ("bob", None), ("BOB@example.com", None)],
+)
+def test_resolve_owner_fills_in_the_creator(owner, expected):
+    userinfo = {"username": "oidc:bob@example.com", "groups": ["developers"]}
+    assert resolve_owner(owner, userinfo, USERS, username_prefix="oidc:") == expected
+
+
+def test_resolve_owner_rejects_other_owners():
+    userinfo = {"username": "oidc:bob@example.com"}
+    with pytest.raises(ValueError):
+        resolve_owner("alice", userinfo, USERS, username_prefix="oidc:")
+
+
+def test_resolve_owner_lets_admins_set_any_owner():
+    userinfo = {"username": "oidc:root", "groups": ["platform-admins"]}
+    owner = resolve_owner(
+        "alice", userinfo, USERS, admin_groups=["platform-admins"], username_prefix="oidc:"
+    )
+    assert owner is None
+
+
+@pytest.mark.parametrize(
+    "old_owner, owner, allowed",
+    [("alice", "alice", True), (None, None, True), ("alice", "bob", False), (None, "bob", False)],
+)
+def test_resolve_owner_on_update(old_owner, owner, allowed):
+    # E.g. the owner hibernating their DevServer
+    userinfo = {"username": "oidc:alice@example.com"}
+    if allowed:
+        assert resolve_owner(owner, userinfo, USERS, old_owner=old_owner, updating=True) is None
+    else:
+        with pytest.raises(ValueError):
+            resolve_owner(owner, userinfo, USERS, old_owner=old_owner, updating=True)
+
+
+def test_resolve_owner_lets_the_operator_set_any_owner():
+    # E.g. binding a claim, or creating the DevServers of a set
+    userinfo = {"username": OPERATOR, "groups": ["system:serviceaccounts"]}
+    assert resolve_owner("bob", userinfo, USERS, trusted_requesters=[OPERATOR]) is None
+    assert (
+        resolve_owner(
+            "bob", userinfo, USERS, old_owner=None, updating=True, trusted_requesters=[OPERATOR]
+        )
+        is None
+    )
+    with pytest.raises(ValueError):
+        resolve_owner("bob", userinfo, USERS, trusted_requesters=[])
+
+
+def test_operator_service_account(tmp_path):
+    payload = base64.urlsafe_b64encode(json.dumps({"sub": OPERATOR}).encode()).rstrip(b"=")
+    token_file = tmp_path / "token"
+    token_file.write_text(f"header.{payload.decode()}.signature")
+
+    assert operator_service_account(str(token_file)) == OPERATOR
+    assert operator_service_account(str(tmp_path / "missing")) is None
@@ -0,0 +1,50 @@
+import pytest
+
+from devservers.operator.devserver.owner_ids import PosixIds, find_owner_ids
+from devservers.operator.devserver.resources.statefulset import build_statefulset
+
+USERS = [
+    {"spec": {"username": "bob"}},
+    {"spec": {"username": "alice", "email": "Alice@example.com", "posix": {"uid": 5001}}},
+    {"spec": {"username": "carol", "posix": {"uid": 5002, "gid": 6000}}},
+]
+
+
+@pytest.mark.parametrize(
+    "owner, expected",
+    [
+        ("alice", PosixIds(uid=5001, gid=5001)),
+        ("alice@example.com", PosixIds(uid=5001, gid=5001)),
+        ("carol", PosixIds(uid=5002, gid=6000)),
+        ("bob", None),
+        ("dave@example.com", None),
+    ],
+)
+def test_find_owner_ids(owner, expected):
+    assert find_owner_ids(owner, USERS) == expected
+
+
+def test_build_statefulset_runs_as_owner_ids():
+    spec = {"sidecars": [{"name": "exporter", "image": "prom/node-exporter"}]}
+
+    statefulset = build_statefulset(
+        "test-server", "test-ns", spec, {"spec": {"resources": {}}}, PosixIds(5001, 6000)
+    )
+
+    pod_spec = statefulset["spec"]["template"]["spec"]
+    assert pod_spec["securityContext"]["runAsUser"] == 5001
+    assert pod_spec["securityContext"]["fsGroup"] == 6000
+    devserver, exporter = pod_spec["containers"]
+    assert devserver["securityContext"] == {"runAsUser": 0, "runAsGroup": 0}
+    assert {"name": "DEV_UID", "value": "5001"} in devserver["env"]
+    assert "securityContext" not in exporter
+    for init_container in pod_spec["initContainers"]:
+        assert init_container["securityContext"]["runAsUser"] == 0
+
+
+def test_build_statefulset_without_owner_ids_keeps_image_users():
+    statefulset = build_statefulset("test-server", "test-ns", {}, {"spec": {"resources": {}}})
+
+    pod_spec = statefulset["spec"]["template"]["spec"]
+    assert "securityContext" not in pod_spec
+    assert {"name": "DEV_UID", "value": "1000"} in pod_spec["containers"][0]["env"]
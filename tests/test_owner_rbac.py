@@ -0,0 +1,149 @@
+from unittest.mock import MagicMock, patch
+
+import pytest
+
+from devservers.operator.devserver import owner_rbac
+from devservers.operator.devserver.resources.owner_rbac import build_owner_role, owner_role_name
+
+USERS = [
+    {
+        "spec": {
+            "username": "alice",
+            "email": "alice@example.com",
+            "rbac": {"subjects": [{"kind": "User", "name": "oidc:alice@example.com"}]},
+        }
+    },
+    {"spec": {"username": "bob"}},
+]
+
+
+def _devserver(name, owner, deleting=False, collaborators=None):
+    metadata = {"name": name}
+    if deleting:
+        metadata["deletionTimestamp"] = "2024-01-01T00:00:00Z"
+    spec = {"owner": owner}
+    if collaborators:
+        spec["collaborators"] = collaborators
+    return {"metadata": metadata, "spec": spec}
+
+
+def test_owner_role_only_grants_the_owners_devservers():
+    role = build_owner_role("shared", "alice@example.com", ["two", "one"])
+
+    assert role["metadata"]["name"] == "devserver-owner-alice-example-com"
+    assert role["metadata"]["annotations"]["devserver.io/owner"] == "alice@example.com"
+    resource_names = {rule["resources"][0]: rule["resourceNames"] for rule in role["rules"]}
+    assert resource_names == {
+        "devservers": ["one", "two"],
+        "pods": ["one-0", "two-0"],
+        "pods/portforward": ["one-0", "two-0"],
+        "pods/exec": ["one-0", "two-0"],
+        "services": ["one-ssh", "two-ssh"],
+    }
+
+
+def test_owner_role_only_lets_collaborators_connect():
+    role = build_owner_role("shared", "bob", ["mine"], ["theirs"])
+
+    devserver_rules = [rule for rule in role["rules"] if rule["resources"] == ["devservers"]]
+    assert devserver_rules == [
+        {
+            "apiGroups": ["devserver.io"],
+            "resources": ["devservers"],
+            "resourceNames": ["mine"],
+            "verbs": ["get", "update", "patch", "delete"],
+        },
+        {
+            "apiGroups": ["devserver.io"],
+            "resources": ["devservers"],
+            "resourceNames": ["theirs"],
+            "verbs": ["get"],
+        },
+    ]
+    exec_rule = next(rule for rule in role["rules"] if rule["resources"] == ["pods/exec"])
+    assert exec_rule["resourceNames"] == ["mine-0", "theirs-0"]
+
+
+@pytest.mark.parametrize(
+    "owner, expected",
+    [
+        (
+            "Alice@example.com",
+            [
+                {
+                    "kind": "User",
+                    "apiGroup": "rbac.authorization.k8s.io",
+                    "name": "oidc:alice@example.com",
+                },
+                {"kind": "ServiceAccount", "name": "alice-sa", "namespace": "dev-alice"},
+            ],
+        ),
+        (
+            "bob",
+            [
+                {"kind": "User", "apiGroup": "rbac.authorization.k8s.io", "name": "sso:bob"},
+                {"kind": "ServiceAccount", "name": "bob-sa", "namespace": "dev-bob"},
+            ],
+        ),
+        ("carol", [{"kind": "User", "apiGroup": "rbac.authorization.k8s.io", "name": "sso:carol"}]),
+    ],
+)
+def test_owner_subjects(owner, expected):
+    assert owner_rbac.owner_subjects(owner, USERS, user_prefix="sso:") == expected
+
+
+async def _reconcile(devservers, owner):
+    custom_objects_api = MagicMock()
+    custom_objects_api.list_namespaced_custom_object.return_value = {"items": devservers}
+    custom_objects_api.list_cluster_custom_object.return_value = {"items": USERS}
+    rbac_v1 = MagicMock()
+    with patch.object(
+        owner_rbac.client, "CustomObjectsApi", return_value=custom_objects_api
+    ), patch.object(owner_rbac.client, "RbacAuthorizationV1Api", return_value=rbac_v1):
+        await owner_rbac.reconcile_owner_rbac("shared", owner, MagicMock())
+    return rbac_v1
+
+
+@pytest.mark.asyncio
+async def test_reconcile_owner_rbac_applies_the_role_for_live_devservers():
+    rbac_v1 = await _reconcile(
+        [
+            _devserver("one", "alice"),
+            _devserver("two", "bob"),
+            _devserver("three", "alice", deleting=True),
+        ],
+        "alice",
+    )
+
+    role = rbac_v1.patch_namespaced_role.call_args.kwargs["body"]
+    assert role["rules"][0]["resourceNames"] == ["one"]
+    role_binding = rbac_v1.patch_namespaced_role_binding.call_args.kwargs["body"]
+    assert role_binding["roleRef"]["name"] == owner_role_name("alice")
+    assert role_binding["subjects"][0]["name"] == "oidc:alice@example.com"
+    rbac_v1.delete_namespaced_role.assert_not_called()
+
+
+@pytest.mark.asyncio
+async def test_reconcile_owner_rbac_removes_the_role_with_the_last_devserver():
+    rbac_v1 = await _reconcile([_devserver("one", "alice", deleting=True)], "alice")
+
+    rbac_v1.patch_namespaced_role.assert_not_called()
+    rbac_v1.delete_namespaced_role.assert_called_once_with(
+        name="devserver-owner-alice", namespace="shared"
+    )
+    rbac_v1.delete_namespaced_role_binding.assert_called_once_with(
+        name="devserver-owner-alice", namespace="shared"
+    )
+
+
+@pytest.mark.asyncio
+async def test_reconcile_owner_rbac_keeps_the_role_of_collaborators():
+    rbac_v1 = await _reconcile(
+        [_devserver("one", "alice", collaborators=["Bob"]), _devserver("two", "carol")],
+        "bob",
+    )
+
+    role = rbac_v1.patch_namespaced_role.call_args.kwargs["body"]
+    assert role["rules"][0]["resourceNames"] == ["one"]
+    assert role["rules"][0]["verbs"] == ["get"]
+    rbac_v1.delete_namespaced_role.assert_not_called()
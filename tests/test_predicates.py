@@ -0,0 +1,32 @@
+import pytest
+
+from devservers.operator.devserver.predicates import devserver_changed
+
+SPEC = {"flavor": "cpu-small", "metadataPropagation": {"labels": ["team"]}}
+
+
+@pytest.mark.parametrize(
+    "old, new, expected",
+    [
+        ({"spec": SPEC}, {"spec": {**SPEC, "image": "ubuntu:24.04"}}, True),
+        # A propagated label changed
+        (
+            {"spec": SPEC, "metadata": {"labels": {"team": "infra"}}},
+            {"spec": SPEC, "metadata": {"labels": {"team": "ml"}}},
+            True,
+        ),
+        # Labels and annotations that are not propagated
+        (
+            {"spec": SPEC, "metadata": {"labels": {"team": "ml"}}},
+            {
+                "spec": SPEC,
+                "metadata": {"labels": {"team": "ml", "argocd": "x"}, "annotations": {"a": "b"}},
+            },
+            False,
+        ),
+        ({"spec": SPEC}, {"spec": SPEC}, False),
+        (None, {"spec": SPEC}, True),
+    ],
+)
+def test_devserver_changed(old, new, expected):
+    assert devserver_changed(old=old, new=new) is expected
@@ -0,0 +1,72 @@
+from unittest.mock import MagicMock, patch
+
+import pytest
+from kubernetes.client.rest import ApiException
+
+from devservers.operator.devserverflavor import prepull
+from devservers.operator.devserverflavor.prepull import (
+    PrepullConfig,
+    build_prepull_daemonset,
+    load_prepull_config,
+)
+
+CONFIG = PrepullConfig(namespace="devserver-system", image_pull_secrets=["ghcr"])
+
+
+def _flavor(**spec):
+    return {"metadata": {"name": "gpu", "uid": "flavor-uid"}, "spec": spec}
+
+
+def test_load_prepull_config():
+    assert load_prepull_config({}) == PrepullConfig()
+    config = load_prepull_config(
+        {
+            "DEVSERVER_PREPULL_NAMESPACE": "devserver-system",
+            "DEVSERVER_PREPULL_IMAGE_PULL_SECRETS": "a, b",
+        }
+    )
+    assert config == PrepullConfig(namespace="devserver-system", image_pull_secrets=["a", "b"])
+
+
+def test_prepull_daemonset_runs_on_the_flavors_nodes():
+    toleration = {"key": "nvidia.com/gpu", "operator": "Exists", "effect": "NoSchedule"}
+    flavor = _flavor(
+        prepullImages=["ghcr.io/acme/cuda-dev:12.4", "ghcr.io/acme/cuda-dev:12.4", "python:3.12"],
+        nodeSelector={"node.kubernetes.io/instance-type": "p4d.24xlarge"},
+        tolerations=[toleration],
+    )
+
+    daemonset = build_prepull_daemonset(flavor, CONFIG)
+
+    assert daemonset["metadata"]["name"] == "devserver-prepull-gpu"
+    assert daemonset["metadata"]["namespace"] == "devserver-system"
+    assert daemonset["metadata"]["ownerReferences"][0]["uid"] == "flavor-uid"
+    pod_spec = daemonset["spec"]["template"]["spec"]
+    assert [c["image"] for c in pod_spec["initContainers"]] == [
+        "ghcr.io/acme/cuda-dev:12.4", "python:3.12"
+    ]
+    assert pod_spec["initContainers"][0]["imagePullPolicy"] == "IfNotPresent"
+    assert pod_spec["nodeSelector"] == {"node.kubernetes.io/instance-type": "p4d.24xlarge"}
+    assert pod_spec["tolerations"] == [toleration]
+    assert pod_spec["imagePullSecrets"] == [{"name": "ghcr"}]
+
+
+def test_no_prepull_daemonset_without_images_or_namespace():
+    assert build_prepull_daemonset(_flavor(), CONFIG) is None
+    assert build_prepull_daemonset(_flavor(prepullImages=["python:3.12"]), PrepullConfig()) is None
+
+
+@pytest.mark.asyncio
+async def test_reconcile_prepull_deletes_the_daemonset_without_images():
+    apps_v1 = MagicMock()
+    apps_v1.delete_namespaced_daemon_set.side_effect = ApiException(status=404)
+
+    with patch.object(prepull.client, "AppsV1Api", return_value=apps_v1):
+        await prepull.reconcile_prepull(_flavor(), MagicMock(), CONFIG)
+        apps_v1.delete_namespaced_daemon_set.assert_called_once_with(
+            name="devserver-prepull-gpu", namespace="devserver-system"
+        )
+
+        await prepull.reconcile_prepull(_flavor(prepullImages=["python:3.12"]), MagicMock(), CONFIG)
+        body = apps_v1.patch_namespaced_daemon_set.call_args[1]["body"]
+        assert body["kind"] == "DaemonSet"
@@ -0,0 +1,63 @@
+from unittest.mock import AsyncMock, MagicMock, patch
+
+import kopf
+import pytest
+from kubernetes.client.rest import ApiException
+
+from devservers.operator.devserver import profile
+from devservers.operator.devserver.profile import with_profile
+
+PROFILE = {
+    "metadata": {"name": "pytorch-nightly-a100"},
+    "spec": {
+        "flavor": "gpu-a100",
+        "image": "pytorch/pytorch-nightly:latest",
+        "sharedVolumes": [
+            {"claimName": "datasets", "mountPath": "/datasets", "readOnly": True},
+            {"claimName": "models", "mountPath": "/models"},
+        ],
+        "env": [
+            {"name": "TORCH_CUDA_ARCH_LIST", "value": "8.0"},
+            {"name": "HF_HOME", "value": "/models/hf"},
+        ],
+    },
+}
+
+
+def test_with_profile_fills_in_what_the_devserver_does_not_set():
+    spec = {"profile": "pytorch-nightly-a100", "owner": "alice"}
+
+    assert with_profile(spec, PROFILE) == {**spec, **PROFILE["spec"]}
+    assert with_profile(spec, {"spec": {}}) == spec
+
+
+def test_with_profile_lets_the_devserver_override_it():
+    spec = {
+        "profile": "pytorch-nightly-a100",
+        "flavor": "gpu-h100",
+        "env": [{"name": "HF_HOME", "value": "/home/dev/hf"}],
+        "sharedVolumes": [{"claimName": "my-models", "mountPath": "/models/"}],
+    }
+
+    resolved = with_profile(spec, PROFILE)
+
+    assert resolved["flavor"] == "gpu-h100"
+    assert resolved["image"] == "pytorch/pytorch-nightly:latest"
+    assert resolved["env"] == [
+        {"name": "TORCH_CUDA_ARCH_LIST", "value": "8.0"},
+        {"name": "HF_HOME", "value": "/home/dev/hf"},
+    ]
+    assert [v["claimName"] for v in resolved["sharedVolumes"]] == ["datasets", "my-models"]
+
+
+@pytest.mark.asyncio
+async def test_get_profile_rejects_missing_profiles():
+    custom_objects_api = MagicMock()
+    custom_objects_api.get_cluster_custom_object.side_effect = ApiException(status=404)
+    recorder = MagicMock(warning=AsyncMock())
+
+    with patch.object(profile.client, "CustomObjectsApi", return_value=custom_objects_api):
+        with pytest.raises(kopf.PermanentError):
+            await profile.get_profile("missing", MagicMock(), recorder, {})
+
+    assert recorder.warning.call_args.args[1] == "ProfileNotFound"
@@ -0,0 +1,227 @@
+import contextlib
+import json
+from unittest.mock import AsyncMock, MagicMock, patch
+
+import aiohttp
+import pytest
+from aiohttp import web
+from kubernetes.client.rest import ApiException
+
+from devservers.api import pull_requests, server
+
+SECRET = "webhook-secret"
+USERS = {
+    "items": [
+        {
+            "spec": {
+                "username": "alice",
+                "forgeLogins": {"github": "Alice-GH"},
+                "posix": {"uid": 5001},
+            }
+        },
+        {"spec": {"username": "bob"}},
+    ]
+}
+
+
+def _github_payload(action, label="devserver", labels=()):
+    return {
+        "action": action,
+        "label": {"name": label},
+        "pull_request": {
+            "number": 42,
+            "html_url": "https://github.com/acme/Web-App/pull/42",
+            "head": {
+                "ref": "feature/login",
+                "repo": {"clone_url": "https://github.com/bob/web-app.git"},
+            },
+            "labels": [{"name": name} for name in labels],
+        },
+        "repository": {
+            "id": 1234,
+            "full_name": "acme/Web-App",
+            "clone_url": "https://github.com/acme/web-app.git",
+        },
+        "sender": {"login": "alice-gh"},
+    }
+
+
+@contextlib.asynccontextmanager
+async def _serve(custom_objects_api):
+    with patch.object(
+        server.client, "CustomObjectsApi", lambda *args: custom_objects_api
+    ), patch.object(pull_requests.client, "CustomObjectsApi", lambda *args: custom_objects_api):
+        runner = web.AppRunner(server.create_app(MagicMock()))
+        await runner.setup()
+        site = web.TCPSite(runner, "127.0.0.1", 0)
+        await site.start()
+        port = runner.addresses[0][1]
+        try:
+            async with aiohttp.ClientSession(base_url=f"http://127.0.0.1:{port}") as session:
+                yield session
+        finally:
+            await runner.cleanup()
+
+
+async def _post_github(session, payload, signature=None):
+    body = json.dumps(payload).encode()
+    headers = {
+        "X-GitHub-Event": "pull_request",
+        "X-Hub-Signature-256": signature or pull_requests.github_signature(SECRET, body),
+        "Content-Type": "application/json",
+    }
+    async with session.post("/webhooks/github", data=body, headers=headers) as response:
+        return response.status, await response.json(content_type=None)
+
+
+def test_devserver_name():
+    pr = pull_requests.parse_github(_github_payload("labeled"))
+    assert pull_requests.devserver_name(pr) == "pr-web-app-42"
+    assert pull_requests.pull_request_label(pr) == "github-1234-42"
+
+    long_name = pr._replace(repository="acme/" + "x" * 80, number=123456)
+    assert len(pull_requests.devserver_name(long_name)) <= 52
+    assert pull_requests.devserver_name(long_name).endswith("-123456")
+
+
+@pytest.mark.asyncio
+async def test_labeling_a_pull_request_creates_a_devserver_and_comments():
+    custom_objects_api = MagicMock()
+    custom_objects_api.list_cluster_custom_object.return_value = USERS
+    custom_objects_api.create_namespaced_custom_object.side_effect = lambda **kwargs: kwargs["body"]
+
+    with patch.object(pull_requests, "GITHUB_WEBHOOK_SECRET", SECRET), patch.object(
+        pull_requests, "_post_comment", AsyncMock()
+    ) as post_comment:
+        async with _serve(custom_objects_api) as session:
+            status, result = await _post_github(session, _github_payload("labeled"))
+
+    assert status == 201 and result == {"devserver": "pr-web-app-42", "created": True}
+    body = custom_objects_api.create_namespaced_custom_object.call_args.kwargs["body"]
+    assert body["metadata"]["namespace"] == "dev-alice"
+    assert body["metadata"]["labels"] == {"devserver.io/pull-request": "github-1234-42"}
+    assert body["spec"]["owner"] == "alice"
+    clone = body["spec"]["initContainers"][0]
+    assert {var["name"]: var["value"] for var in clone["env"]} == {
+        "URL": "https://github.com/bob/web-app.git",
+        "BRANCH": "feature/login",
+        "DEST": "/home/dev/web-app",
+    }
+    # The branch is never part of the script itself
+    assert "feature/login" not in clone["command"][2]
+    # As alice's dev user, who owns her home directory
+    assert clone["securityContext"] == {"runAsUser": 5001, "runAsGroup": 5001}
+    assert "devctl ssh pr-web-app-42 -n dev-alice" in post_comment.await_args.args[1]
+
+
+@pytest.mark.asyncio
+async def test_pull_requests_labeled_by_unknown_users_are_ignored():
+    custom_objects_api = MagicMock()
+    custom_objects_api.list_cluster_custom_object.return_value = USERS
+    payload = _github_payload("labeled")
+    payload["sender"]["login"] = "bob"
+
+    with patch.object(pull_requests, "GITHUB_WEBHOOK_SECRET", SECRET):
+        async with _serve(custom_objects_api) as session:
+            status, result = await _post_github(session, payload)
+
+    assert status == 200 and result["ignored"]
+    custom_objects_api.create_namespaced_custom_object.assert_not_called()
+
+
+def test_logins_claimed_by_several_users_have_no_owner():
+    pr = pull_requests.parse_github(_github_payload("labeled"))
+    impostor = {"spec": {"username": "mallory", "forgeLogins": {"github": "alice-gh"}}}
+
+    assert pull_requests.find_owner(pr, USERS["items"])["spec"]["username"] == "alice"
+    with pytest.raises(ValueError, match="claimed by alice, mallory"):
+        pull_requests.find_owner(pr, [*USERS["items"], impostor])
+
+
+@pytest.mark.asyncio
+async def test_devservers_of_other_pull_requests_are_not_taken_for_their_own():
+    custom_objects_api = MagicMock()
+    custom_objects_api.list_cluster_custom_object.return_value = USERS
+    custom_objects_api.create_namespaced_custom_object.side_effect = ApiException(status=409)
+    # Of a pull request to another repository named web-app
+    custom_objects_api.get_namespaced_custom_object.return_value = {
+        "metadata": {"labels": {"devserver.io/pull-request": "github-5678-42"}},
+        "spec": {"owner": "alice"},
+    }
+
+    with patch.object(pull_requests, "GITHUB_WEBHOOK_SECRET", SECRET):
+        async with _serve(custom_objects_api) as session:
+            status, _ = await _post_github(session, _github_payload("labeled"))
+            custom_objects_api.get_namespaced_custom_object.return_value["metadata"]["labels"][
+                "devserver.io/pull-request"
+            ] = "github-1234-42"
+            status_again, result = await _post_github(session, _github_payload("labeled"))
+
+    assert status == 409
+    assert status_again == 200 and result == {"devserver": "pr-web-app-42", "created": False}
+
+
+@pytest.mark.asyncio
+async def test_closing_a_pull_request_deletes_its_devserver():
+    custom_objects_api = MagicMock()
+    custom_objects_api.list_cluster_custom_object.return_value = {
+        "items": [{"metadata": {"name": "pr-web-app-42", "namespace": "dev-alice"}}]
+    }
+
+    with patch.object(pull_requests, "GITHUB_WEBHOOK_SECRET", SECRET):
+        async with _serve(custom_objects_api) as session:
+            status, result = await _post_github(session, _github_payload("closed", label=None))
+
+    assert status == 200 and result == {"deleted": ["pr-web-app-42"]}
+    list_kwargs = custom_objects_api.list_cluster_custom_object.call_args.kwargs
+    assert list_kwargs["label_selector"] == "devserver.io/pull-request=github-1234-42"
+    delete_kwargs = custom_objects_api.delete_namespaced_custom_object.call_args.kwargs
+    assert (delete_kwargs["namespace"], delete_kwargs["name"]) == ("dev-alice", "pr-web-app-42")
+
+
+@pytest.mark.asyncio
+async def test_webhooks_with_invalid_signatures_are_rejected():
+    custom_objects_api = MagicMock()
+
+    with patch.object(pull_requests, "GITHUB_WEBHOOK_SECRET", SECRET):
+        async with _serve(custom_objects_api) as session:
+            status, _ = await _post_github(
+                session, _github_payload("labeled"), signature="sha256=forged"
+            )
+
+    assert status == 401
+    custom_objects_api.create_namespaced_custom_object.assert_not_called()
+
+
+def test_github_actions():
+    assert pull_requests.github_action(_github_payload("labeled")) == pull_requests.OPEN
+    assert pull_requests.github_action(_github_payload("labeled", label="bug")) is None
+    assert (
+        pull_requests.github_action(_github_payload("reopened", labels=["devserver"]))
+        == pull_requests.OPEN
+    )
+    assert pull_requests.github_action(_github_payload("unlabeled")) == pull_requests.CLOSE
+    assert pull_requests.github_action(_github_payload("synchronize")) is None
+
+
+def test_gitlab_actions():
+    def payload(action, previous=None, current=None, labels=()):
+        changes = {}
+        if previous is not None:
+            changes["labels"] = {
+                "previous": [{"title": title} for title in previous],
+                "current": [{"title": title} for title in current],
+            }
+        return {
+            "object_attributes": {"action": action},
+            "labels": [{"title": title} for title in labels],
+            "changes": changes,
+        }
+
+    assert pull_requests.gitlab_action(payload("update", [], ["devserver"])) == pull_requests.OPEN
+    assert pull_requests.gitlab_action(payload("update", ["devserver"], [])) == pull_requests.CLOSE
+    assert (
+        pull_requests.gitlab_action(payload("merge", labels=["devserver"])) == pull_requests.CLOSE
+    )
+    assert pull_requests.gitlab_action(payload("open", labels=["devserver"])) == pull_requests.OPEN
+    assert pull_requests.gitlab_action(payload("update", ["bug"], ["bug", "ui"])) is None
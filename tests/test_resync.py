@@ -0,0 +1,147 @@
+import contextlib
+from unittest.mock import AsyncMock, MagicMock, patch
+
+import kopf
+import pytest
+
+from devservers.operator.devserver import handler, resync
+from devservers.operator.devserver.naming import NamingPolicy
+
+
+def _meta(requeue_after):
+    return {"annotations": {resync.REQUEUE_AFTER_ANNOTATION: requeue_after}}
+
+
+def test_devservers_resync_every_interval_by_default():
+    assert resync.requeue_after({}, MagicMock()) == resync.RESYNC_INTERVAL
+
+
+def test_annotation_overrides_the_interval():
+    assert resync.requeue_after(_meta("5m"), MagicMock()) == 300
+
+
+@pytest.mark.parametrize(
+    "requeue_after, seconds",
+    [("1s", resync.MIN_REQUEUE_AFTER), ("30d", resync.MAX_REQUEUE_AFTER)],
+)
+def test_annotation_is_bounded(requeue_after, seconds):
+    logger = MagicMock()
+
+    assert resync.requeue_after(_meta(requeue_after), logger) == seconds
+    logger.warning.assert_called_once()
+
+
+def test_invalid_annotation_is_ignored():
+    logger = MagicMock()
+
+    assert resync.requeue_after(_meta("soon"), logger) == resync.RESYNC_INTERVAL
+    logger.warning.assert_called_once()
+
+
+
+async def _resync(body):
+    """Resync a DevServer, returning its patch and the mocks of the handler's steps."""
+    flavor = {"metadata": {"name": body["spec"]["flavor"]}, "spec": {}}
+    custom_objects_api = MagicMock()
+    custom_objects_api.get_cluster_custom_object.return_value = flavor
+    mocks = {
+        "get_template": AsyncMock(),
+        "resolve_clone": AsyncMock(),
+        "EventRecorder": MagicMock(return_value=AsyncMock()),
+        "ensure_host_keys_secret": AsyncMock(return_value=[]),
+        "choose_flavor": AsyncMock(return_value=(flavor, None)),
+        "prepare_home_source": AsyncMock(),
+        "resolve_owner_ids": AsyncMock(return_value=None),
+        "reconcile_devserver": AsyncMock(return_value="Reconciled."),
+        "expand_home_volume": AsyncMock(),
+        "observe_devserver_status": AsyncMock(return_value={"phase": "Running"}),
+    }
+    patch_ = {}
+    with contextlib.ExitStack() as stack:
+        for target, mock in mocks.items():
+            stack.enter_context(patch.object(handler, target, mock))
+        stack.enter_context(
+            patch.object(handler.client, "CustomObjectsApi", lambda: custom_objects_api)
+        )
+        mocks["notify"] = stack.enter_context(
+            patch.object(handler.notifications, "notify", AsyncMock())
+        )
+        await handler.resync_devserver(
+            name=body["metadata"]["name"],
+            namespace=body["metadata"]["namespace"],
+            spec=body["spec"],
+            meta=body["metadata"],
+            body=body,
+            status=body["status"],
+            patch=patch_,
+            memo=kopf.Memo(last_resync=-float("inf")),
+            logger=MagicMock(),
+        )
+    return patch_, mocks
+
+
+@pytest.mark.asyncio
+async def test_resync_keeps_the_template_defaults_and_limits_of_a_devserver():
+    body = {
+        "metadata": {"name": "dev", "namespace": "ml", "uid": "uid-1"},
+        "spec": {
+            "owner": "alice",
+            "template": "pytorch",
+            "flavor": "cpu-small",
+            "lifecycle": {"timeToLive": "12h"},
+        },
+        "status": {
+            "flavor": "cpu-small",
+            "lifecycleLimits": {"template": "pytorch", "extensionsRemaining": 0},
+        },
+    }
+
+    patch_, mocks = await _resync(body)
+
+    mocks["get_template"].assert_not_awaited()
+    assert "spec" not in patch_
+    assert "lifecycleLimits" not in patch_["status"]
+    # Nor is it announced as created again
+    mocks["notify"].assert_not_awaited()
+
+
+@pytest.mark.asyncio
+async def test_resync_does_not_copy_the_clone_source_again():
+    body = {
+        "metadata": {"name": "dev-copy", "namespace": "ml", "uid": "uid-2"},
+        "spec": {
+            "owner": "alice",
+            "cloneFrom": {"name": "dev"},
+            "flavor": "cpu-small",
+            "image": "edited-by-the-owner",
+            "lifecycle": {"timeToLive": "4h"},
+        },
+        "status": {"flavor": "cpu-small", "clonedFrom": "dev"},
+    }
+
+    patch_, mocks = await _resync(body)
+
+    mocks["resolve_clone"].assert_not_awaited()
+    assert "spec" not in patch_
+
+
+@pytest.mark.asyncio
+async def test_resync_checks_neither_the_name_nor_collaborator_changes():
+    body = {
+        "metadata": {"name": "shared", "namespace": "ml", "uid": "uid-3"},
+        "spec": {
+            "owner": "alice",
+            "flavor": "cpu-small",
+            "collaborators": ["bob"],
+            "lifecycle": {"timeToLive": "4h"},
+        },
+        "status": {"flavor": "cpu-small"},
+    }
+
+    # A naming policy introduced after the DevServer was created
+    with patch.object(handler, "NAMING_POLICY", NamingPolicy(owner_prefix=True)):
+        _, mocks = await _resync(body)
+
+    recorder = mocks["EventRecorder"].return_value
+    reasons = [call.args[1] for call in recorder.normal.await_args_list]
+    assert "CollaboratorsChanged" not in reasons
@@ -0,0 +1,108 @@
+import pytest
+from kubernetes import client
+
+from devservers.operator.devserver import spot
+from devservers.operator.devserver.resources.statefulset import build_statefulset
+
+
+def _pod(conditions=None, reason=None):
+    return client.V1Pod(status=client.V1PodStatus(conditions=conditions or [], reason=reason))
+
+
+def test_parse_spot_settings():
+    assert spot._parse_node_selector("a=b, c=d,") == {"a": "b", "c": "d"}
+    assert spot._parse_tolerations("cloud.google.com/gke-spot=true:NoSchedule,spot") == [
+        {
+            "key": "cloud.google.com/gke-spot",
+            "operator": "Equal",
+            "value": "true",
+            "effect": "NoSchedule",
+        },
+        {"key": "spot", "operator": "Exists"},
+    ]
+
+
+@pytest.mark.parametrize(
+    "spec, status, expected",
+    [
+        ({}, {}, None),
+        ({"capacityType": "on-demand"}, {"capacityType": "spot"}, "on-demand"),
+        ({"capacityType": "spot-with-fallback"}, {}, "spot"),
+        ({"capacityType": "spot-with-fallback"}, {"capacityType": "on-demand"}, "on-demand"),
+        (
+            {"capacityType": "spot-with-fallback", "hibernated": True},
+            {"capacityType": "on-demand"},
+            "spot",
+        ),
+    ],
+)
+def test_current_capacity_type(spec, status, expected):
+    assert spot.current_capacity_type(spec, status) == expected
+
+
+def test_build_statefulset_merges_capacity_scheduling_with_the_flavors():
+    flavor = {
+        "spec": {
+            "resources": {},
+            "nodeSelector": {"pool": "gpu"},
+            "tolerations": [{"key": "nvidia.com/gpu", "operator": "Exists"}],
+        }
+    }
+    scheduling = {
+        "nodeSelector": {"karpenter.sh/capacity-type": "spot"},
+        "tolerations": [{"key": "spot", "operator": "Exists"}],
+    }
+
+    statefulset = build_statefulset(
+        "test-server", "test-ns", {}, flavor, capacity_scheduling=scheduling
+    )
+
+    pod_spec = statefulset["spec"]["template"]["spec"]
+    assert pod_spec["nodeSelector"] == {"pool": "gpu", "karpenter.sh/capacity-type": "spot"}
+    assert pod_spec["tolerations"] == [
+        {"key": "nvidia.com/gpu", "operator": "Exists"},
+        {"key": "spot", "operator": "Exists"},
+    ]
+
+
+def test_validate_user_capacity_type_rejects_flavors_pinning_the_capacity_type():
+    spot.validate_user_capacity_type({"capacityType": "spot"}, {"pool": "gpu"})
+    spot.validate_user_capacity_type({}, {"karpenter.sh/capacity-type": "on-demand"})
+    with pytest.raises(ValueError, match="karpenter.sh/capacity-type"):
+        spot.validate_user_capacity_type(
+            {"capacityType": "spot"}, {"karpenter.sh/capacity-type": "on-demand"}
+        )
+
+
+@pytest.mark.parametrize(
+    "pod, reclaimed",
+    [
+        (
+            _pod(
+                [
+                    client.V1PodCondition(
+                        type="DisruptionTarget",
+                        status="True",
+                        reason="DeletionByTaintManager",
+                        message="Taint manager: deleting due to NoExecute taint",
+                    )
+                ]
+            ),
+            True,
+        ),
+        (_pod(reason="Terminated"), True),
+        (
+            _pod(
+                [
+                    client.V1PodCondition(
+                        type="DisruptionTarget", status="True", reason="EvictionByEvictionAPI"
+                    )
+                ]
+            ),
+            False,
+        ),
+        (_pod([client.V1PodCondition(type="Ready", status="True")]), False),
+    ],
+)
+def test_spot_reclamation(pod, reclaimed):
+    assert (spot.spot_reclamation(pod) is not None) == reclaimed
@@ -17,6 +17,7 @@ from devservers.cli.config import (
     _discover_default_ssh_keys,
     create_default_config,
 )
+from devservers.cli.ssh_config import create_ssh_config_for_devserver
 from devservers.cli.utils import get_current_context
 
 
@@ -454,3 +455,76 @@ def test_create_default_config_writes_discovered_key_pair(
     config_data = yaml.safe_load(config_path.read_text())
     assert config_data["ssh"]["private_key_file"] == str(ssh_dir / "id_ed25519")
     assert config_data["ssh"]["public_key_file"] == str(ssh_dir / "id_ed25519.pub")
+
+
+def test_ssh_config_pins_published_host_keys(monkeypatch, tmp_path: Path) -> None:
+    """
+    Host keys from the DevServer status are pinned in a known_hosts file.
+    """
+    monkeypatch.setattr(Path, "home", lambda: tmp_path / "home")
+    config_dir = tmp_path / "ssh_config"
+    config_dir.mkdir()
+
+    config_path, _, hostname = create_ssh_config_for_devserver(
+        config_dir,
+        "my-dev",
+        "~/.ssh/id_ed25519",
+        assume_yes=True,
+        host_keys=["ssh-ed25519 AAAAC3Nza"],
+    )
+
+    known_hosts = config_path.with_suffix(".known_hosts")
+    assert known_hosts.read_text() == f"{hostname} ssh-ed25519 AAAAC3Nza\n"
+    content = config_path.read_text()
+    assert "StrictHostKeyChecking yes" in content
+    assert f"UserKnownHostsFile {known_hosts}" in content
+
+    # Without published keys we fall back to not checking host keys
+    config_path, _, _ = create_ssh_config_for_devserver(
+        config_dir, "my-dev", "~/.ssh/id_ed25519", assume_yes=True
+    )
+    assert "StrictHostKeyChecking no" in config_path.read_text()
+    assert not known_hosts.exists()
+
+
+def test_ssh_config_connects_directly_to_external_endpoint(monkeypatch, tmp_path: Path) -> None:
+    """
+    A reachable external endpoint replaces the port-forward ProxyCommand.
+    """
+    monkeypatch.setattr(Path, "home", lambda: tmp_path / "home")
+    config_dir = tmp_path / "ssh_config"
+    config_dir.mkdir()
+
+    config_path, _, hostname = create_ssh_config_for_devserver(
+        config_dir,
+        "my-dev",
+        "~/.ssh/id_ed25519",
+        assume_yes=True,
+        ssh_endpoint=("10.0.0.5", 31022),
+    )
+
+    content = config_path.read_text()
+    assert "HostName 10.0.0.5" in content
+    assert "Port 31022" in content
+    assert f"HostKeyAlias {hostname}" in content
+    assert "ProxyCommand" not in content
+
+
+def test_ssh_config_proxy_command_keeps_kube_context(monkeypatch, tmp_path: Path) -> None:
+    """
+    The ProxyCommand uses the kubeconfig context devctl was run with.
+    """
+    monkeypatch.setattr(Path, "home", lambda: tmp_path / "home")
+    config_dir = tmp_path / "ssh_config"
+    config_dir.mkdir()
+
+    config_path, _, _ = create_ssh_config_for_devserver(
+        config_dir,
+        "my-dev",
+        "~/.ssh/id_ed25519",
+        namespace="team",
+        kube_context="staging",
+        assume_yes=True,
+    )
+
+    assert "ssh-proxy --name my-dev --namespace team --kube-context staging" in config_path.read_text()
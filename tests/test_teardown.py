@@ -0,0 +1,131 @@
+from unittest.mock import AsyncMock, MagicMock, patch
+
+import kopf
+import pytest
+from kubernetes.client.rest import ApiException
+
+from devservers.operator.devserver import teardown
+
+META = {"name": "dev", "uid": "0123456789abcdef"}
+SPEC = {"persistentHome": {"enabled": True, "deletionPolicy": "Snapshot"}}
+
+
+def _backup(name, devserver_name, phase):
+    return {
+        "metadata": {"name": name},
+        "spec": {"devServerName": devserver_name},
+        "status": {"phase": phase},
+    }
+
+
+@pytest.mark.asyncio
+async def test_cancel_backups_only_cancels_the_devservers_running_backups():
+    custom_objects_api = MagicMock()
+    custom_objects_api.list_namespaced_custom_object.return_value = {
+        "items": [
+            _backup("running", "dev", "Running"),
+            _backup("completed", "dev", "Completed"),
+            _backup("other", "other-dev", "Pending"),
+        ]
+    }
+    batch_v1 = MagicMock()
+    with patch.object(
+        teardown.client, "CustomObjectsApi", return_value=custom_objects_api
+    ), patch.object(teardown.client, "BatchV1Api", return_value=batch_v1):
+        await teardown.cancel_backups("dev", "ns", MagicMock())
+
+    batch_v1.delete_namespaced_job.assert_called_once()
+    assert batch_v1.delete_namespaced_job.call_args.kwargs["name"] == "running-backup"
+    status_patch = custom_objects_api.patch_namespaced_custom_object_status
+    status_patch.assert_called_once()
+    assert status_patch.call_args.kwargs["name"] == "running"
+    assert status_patch.call_args.kwargs["body"]["status"]["phase"] == "Failed"
+
+
+@pytest.mark.asyncio
+async def test_release_ssh_service_ignores_a_missing_service():
+    core_v1 = MagicMock()
+    core_v1.delete_namespaced_service.side_effect = ApiException(status=404)
+    with patch.object(teardown.client, "CoreV1Api", return_value=core_v1):
+        await teardown.release_ssh_service("dev", "ns", MagicMock())
+
+    core_v1.delete_namespaced_service.assert_called_once_with(name="dev-ssh", namespace="ns")
+
+
+async def _release_home_volume(spec, snapshot=None):
+    core_v1 = MagicMock()
+    custom_objects_api = MagicMock()
+    if snapshot is None:
+        custom_objects_api.get_namespaced_custom_object.side_effect = ApiException(status=404)
+        custom_objects_api.create_namespaced_custom_object.return_value = {}
+    else:
+        custom_objects_api.get_namespaced_custom_object.return_value = snapshot
+    recorder = MagicMock(warning=AsyncMock())
+    with patch.object(teardown.client, "CoreV1Api", return_value=core_v1), patch.object(
+        teardown.client, "CustomObjectsApi", return_value=custom_objects_api
+    ), patch.object(teardown.client, "AppsV1Api"):
+        await teardown.release_home_volume(
+            "dev", "ns", spec, META, MagicMock(), recorder, {}
+        )
+    return core_v1, custom_objects_api, recorder
+
+
+@pytest.mark.asyncio
+@pytest.mark.parametrize(
+    "spec",
+    [
+        {"persistentHome": {"enabled": True}},
+        {"persistentHome": {"enabled": True, "deletionPolicy": "Retain"}},
+        {"persistentHome": {"enabled": False, "deletionPolicy": "Delete"}},
+    ],
+)
+async def test_home_volume_is_retained(spec):
+    core_v1, _, _ = await _release_home_volume(spec)
+
+    core_v1.delete_namespaced_persistent_volume_claim.assert_not_called()
+
+
+@pytest.mark.asyncio
+async def test_home_volume_is_deleted():
+    spec = {"persistentHome": {"enabled": True, "deletionPolicy": "Delete"}}
+    core_v1, custom_objects_api, _ = await _release_home_volume(spec)
+
+    core_v1.delete_namespaced_persistent_volume_claim.assert_called_once_with(
+        name="home-dev-0", namespace="ns"
+    )
+    custom_objects_api.create_namespaced_custom_object.assert_not_called()
+
+
+@pytest.mark.asyncio
+async def test_teardown_waits_for_the_final_snapshot():
+    custom_objects_api = MagicMock()
+    custom_objects_api.get_namespaced_custom_object.side_effect = ApiException(status=404)
+    custom_objects_api.create_namespaced_custom_object.return_value = {}
+    with patch.object(
+        teardown.client, "CustomObjectsApi", return_value=custom_objects_api
+    ), patch.object(teardown.client, "AppsV1Api"), pytest.raises(kopf.TemporaryError):
+        await teardown.release_home_volume("dev", "ns", SPEC, META, MagicMock(), MagicMock(), {})
+
+    body = custom_objects_api.create_namespaced_custom_object.call_args.kwargs["body"]
+    assert body["metadata"] == {"name": "dev-final-01234567", "namespace": "ns"}
+    assert body["spec"] == {"devServerName": "dev"}
+
+
+@pytest.mark.asyncio
+async def test_home_volume_is_deleted_once_the_final_snapshot_is_ready():
+    core_v1, custom_objects_api, _ = await _release_home_volume(
+        SPEC, snapshot={"status": {"phase": "Ready"}}
+    )
+
+    custom_objects_api.create_namespaced_custom_object.assert_not_called()
+    core_v1.delete_namespaced_persistent_volume_claim.assert_called_once()
+
+
+@pytest.mark.asyncio
+async def test_home_volume_is_retained_when_the_final_snapshot_fails():
+    core_v1, _, recorder = await _release_home_volume(
+        SPEC, snapshot={"status": {"phase": "Failed"}}
+    )
+
+    core_v1.delete_namespaced_persistent_volume_claim.assert_not_called()
+    assert recorder.warning.call_args.args[1] == "FinalSnapshotFailed"
@@ -0,0 +1,125 @@
+from unittest.mock import MagicMock
+
+import pytest
+
+from devservers.operator.devserver.template import (
+    extension_limits,
+    lifecycle_limits,
+    template_fields,
+    validate_user_lifecycle_limits,
+    validate_user_template_lifecycle,
+)
+from devservers.utils.templates import list_templates, summarize_template
+
+FLAVORS = {
+    "gpu-base": {"metadata": {"name": "gpu-base"}, "spec": {"costPerHour": "2.5"}},
+    "gpu-small": {"metadata": {"name": "gpu-small"}, "spec": {"baseFlavor": "gpu-base"}},
+    "cpu-small": {"metadata": {"name": "cpu-small"}, "spec": {}},
+}
+
+
+def _template(name, **spec):
+    return {"metadata": {"name": name}, "spec": spec}
+
+
+def test_summarize_template_takes_the_cost_of_its_default_flavor():
+    template = _template(
+        "pytorch",
+        description="PyTorch with CUDA.",
+        defaultFlavor="gpu-small",
+        spec={"image": "pytorch/pytorch"},
+    )
+
+    assert summarize_template(template, FLAVORS) == {
+        "name": "pytorch",
+        "description": "PyTorch with CUDA.",
+        "defaultFlavor": "gpu-small",
+        "image": "pytorch/pytorch",
+        "hourlyCost": 2.5,
+    }
+
+
+def test_summarize_template_without_a_cost():
+    assert summarize_template(_template("bare"), FLAVORS)["hourlyCost"] is None
+    assert summarize_template(_template("cpu", defaultFlavor="cpu-small"), FLAVORS)[
+        "hourlyCost"
+    ] is None
+    assert summarize_template(_template("gone", defaultFlavor="missing"), FLAVORS)[
+        "hourlyCost"
+    ] is None
+
+
+def test_list_templates_sorted_by_name():
+    custom_objects_api = MagicMock()
+    custom_objects_api.list_cluster_custom_object.side_effect = [
+        {"items": [_template("web"), _template("data")]},
+        {"items": list(FLAVORS.values())},
+    ]
+
+    assert [t["name"] for t in list_templates(custom_objects_api)] == ["data", "web"]
+
+    custom_objects_api.list_cluster_custom_object.side_effect = [{"items": []}]
+    assert list_templates(custom_objects_api) == []
+
+
+TEMPLATE = _template(
+    "pytorch-gpu",
+    defaultFlavor="gpu-small",
+    lifecycle={"timeToLive": "8h", "idleTimeout": "2h", "maxExtensions": 1},
+    spec={"image": "pytorch/pytorch", "owner": "platform", "lifecycle": {"timeToLive": "7d"}},
+)
+
+
+def test_template_fields_fill_in_what_the_devserver_does_not_set():
+    assert template_fields({"owner": "alice"}, TEMPLATE) == {
+        "image": "pytorch/pytorch",
+        "flavor": "gpu-small",
+        "lifecycle": {"timeToLive": "8h", "idleTimeout": "2h"},
+    }
+
+    spec = {"flavor": "gpu-large", "lifecycle": {"timeToLive": "1h"}}
+    assert template_fields(spec, TEMPLATE) == {
+        "image": "pytorch/pytorch",
+        "lifecycle": {"timeToLive": "1h", "idleTimeout": "2h"},
+    }
+
+
+def test_validate_user_template_lifecycle_rejects_exceeding_the_template():
+    validate_user_template_lifecycle(
+        {"lifecycle": {"timeToLive": "4h", "idleTimeout": "30m"}}, TEMPLATE
+    )
+    with pytest.raises(ValueError, match="timeToLive cannot exceed the 8h"):
+        validate_user_template_lifecycle(
+            {"lifecycle": {"timeToLive": "1d", "idleTimeout": "2h"}}, TEMPLATE
+        )
+    with pytest.raises(ValueError, match="must set idleTimeout"):
+        validate_user_template_lifecycle({"lifecycle": {"timeToLive": "8h"}}, TEMPLATE)
+
+
+def test_validate_user_lifecycle_limits_keeps_the_idle_timeout():
+    status = {"lifecycleLimits": lifecycle_limits(TEMPLATE)}
+    assert status["lifecycleLimits"] == {
+        "template": "pytorch-gpu",
+        "idleTimeout": "2h",
+        "extensionsRemaining": 1,
+    }
+
+    validate_user_lifecycle_limits({"lifecycle": {"idleTimeout": "1h"}}, status)
+    validate_user_lifecycle_limits({"lifecycle": {}}, {})
+    for lifecycle in ({}, {"idleTimeout": "3h"}):
+        with pytest.raises(ValueError):
+            validate_user_lifecycle_limits({"lifecycle": lifecycle}, status)
+    with pytest.raises(ValueError):
+        validate_user_lifecycle_limits({"lifecycle": {"idleTimeout": "0s"}}, {})
+
+
+def test_extension_limits_count_extensions():
+    status = {"lifecycleLimits": lifecycle_limits(TEMPLATE)}
+
+    limits = extension_limits("8h", "12h", status)
+    assert limits["extensionsRemaining"] == 0
+    # Shortening the TTL is not an extension
+    assert extension_limits("12h", "10h", {"lifecycleLimits": limits}) is None
+    assert extension_limits("8h", "12h", {}) is None
+    with pytest.raises(ValueError, match="cannot be extended any further"):
+        extension_limits("12h", "1d", {"lifecycleLimits": limits})
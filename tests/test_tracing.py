@@ -0,0 +1,44 @@
+import logging
+
+import pytest
+
+from devservers.operator import tracing
+
+
+def test_tracing_disabled_without_endpoint(monkeypatch):
+    monkeypatch.delenv("OTEL_EXPORTER_OTLP_ENDPOINT", raising=False)
+    monkeypatch.delenv("OTEL_EXPORTER_OTLP_TRACES_ENDPOINT", raising=False)
+    assert tracing.configure_tracing(logging.getLogger(__name__)) is False
+
+
+def test_tracing_warns_when_configured_without_opentelemetry(monkeypatch, caplog):
+    monkeypatch.setenv("OTEL_EXPORTER_OTLP_ENDPOINT", "http://collector:4318")
+    monkeypatch.setattr(tracing, "trace", None)
+    with caplog.at_level(logging.WARNING):
+        assert tracing.configure_tracing(logging.getLogger(__name__)) is False
+    assert "tracing" in caplog.text
+
+
+def test_span_is_noop_when_disabled(monkeypatch):
+    monkeypatch.setattr(tracing, "_tracer", None)
+    with tracing.span("test", {"key": "value"}):
+        pass
+
+
+def test_span_propagates_exceptions(monkeypatch):
+    monkeypatch.setattr(tracing, "_tracer", None)
+    with pytest.raises(ValueError):
+        with tracing.span("test"):
+            raise ValueError("boom")
+
+
+@pytest.mark.asyncio
+async def test_traced_preserves_handler_identity(monkeypatch):
+    monkeypatch.setattr(tracing, "_tracer", None)
+
+    async def handler(name: str, namespace: str, **kwargs):
+        return f"{namespace}/{name}"
+
+    wrapped = tracing.traced("reconcile DevServer")(handler)
+    assert wrapped.__qualname__ == handler.__qualname__
+    assert await wrapped(name="my-dev", namespace="default") == "default/my-dev"
@@ -0,0 +1,113 @@
+import io
+import tarfile
+from pathlib import Path
+from unittest.mock import MagicMock, patch
+
+import pytest
+
+from devservers.cli.config import Configuration
+from devservers.cli.handlers import transfer
+from devservers.crds.base import ObjectMeta
+from devservers.crds.devserver import DevServer
+
+
+@pytest.mark.parametrize(
+    "path, expected",
+    [
+        ("mydev:work", ("mydev", "work")),
+        ("mydev:", ("mydev", "")),
+        ("./data", (None, "./data")),
+        ("./a:b", (None, "./a:b")),
+        (":work", (None, ":work")),
+    ],
+)
+def test_parse_remote_path(path, expected) -> None:
+    assert transfer.parse_remote_path(path) == expected
+
+
+@pytest.mark.parametrize(
+    "path, expected",
+    [
+        ("", "/home/dev"),
+        ("~", "/home/dev"),
+        ("~/work", "/home/dev/work"),
+        ("work/../out", "/home/dev/out"),
+        ("/tmp/x", "/tmp/x"),
+    ],
+)
+def test_resolve_remote_path(path, expected) -> None:
+    assert transfer.resolve_remote_path(path) == expected
+
+
+def test_resolve_remote_path_custom_home() -> None:
+    assert transfer.resolve_remote_path("~/work", "/home/jovyan") == "/home/jovyan/work"
+
+
+def _running_devserver() -> DevServer:
+    return DevServer(
+        metadata=ObjectMeta(name="mydev", namespace="ns"),
+        spec={},
+        status={"phase": "Running"},
+        api=MagicMock(),
+    )
+
+
+@pytest.fixture
+def key_file(tmp_path: Path) -> Path:
+    key = tmp_path / "id_ed25519"
+    key.write_text("key")
+    return key
+
+
+def _patch_devserver():
+    return patch.multiple(
+        "devservers.cli.handlers.transfer",
+        get_current_context=MagicMock(return_value=("u", "ns")),
+        DevServer=MagicMock(get=MagicMock(return_value=_running_devserver())),
+    )
+
+
+def test_sync_uses_rsync_when_available(tmp_path: Path, key_file: Path) -> None:
+    configuration = Configuration({"ssh": {"private_key_file": str(key_file)}})
+    with _patch_devserver(), \
+            patch("devservers.cli.handlers.transfer.shutil.which", return_value="/usr/bin/rsync"), \
+            patch("devservers.cli.handlers.transfer._remote_has_rsync", return_value=True), \
+            patch("devservers.cli.handlers.transfer._reachable_ssh_endpoint", return_value=("1.2.3.4", 30022)), \
+            patch("devservers.cli.handlers.transfer.subprocess.run") as mock_run:
+        mock_run.return_value.returncode = 0
+        transfer.sync_files(configuration, str(tmp_path), "mydev:project", delete=True)
+
+    command = mock_run.call_args.args[0]
+    assert command[0] == "rsync"
+    assert "--delete" in command
+    assert command[-2:] == [f"{tmp_path}/", "dev@1.2.3.4:/home/dev/project/"]
+    assert "-p 30022" in command[command.index("-e") + 1]
+
+
+def test_cp_falls_back_to_tar_over_exec(tmp_path: Path, key_file: Path) -> None:
+    source = tmp_path / "data"
+    source.mkdir()
+    (source / "file.txt").write_text("hello")
+    configuration = Configuration({"ssh": {"private_key_file": str(key_file)}})
+
+    uploaded = {}
+
+    def fake_exec(namespace, pod_name, command, stdin=None):
+        uploaded["command"] = command
+        uploaded["archive"] = stdin.read()
+        return ""
+
+    with _patch_devserver(), \
+            patch("devservers.cli.handlers.transfer.shutil.which", return_value=None), \
+            patch("devservers.cli.handlers.transfer._exec", side_effect=fake_exec):
+        transfer.copy_files(configuration, str(source), "mydev:work")
+
+    assert uploaded["command"][-2:] == ["/home/dev/work", str(len(uploaded["archive"]))]
+    with tarfile.open(fileobj=io.BytesIO(uploaded["archive"])) as tar:
+        member = tar.getmember("data/file.txt")
+        assert (member.uid, member.gid) == (transfer.DEV_UID, transfer.DEV_GID)
+
+
+def test_transfer_requires_exactly_one_devserver_path(tmp_path: Path) -> None:
+    with pytest.raises(SystemExit):
+        transfer.copy_files(Configuration({}), str(tmp_path), str(tmp_path / "other"))
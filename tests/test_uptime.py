@@ -0,0 +1,97 @@
+from datetime import datetime, timezone
+
+import pytest
+
+from devservers.operator.devserver.uptime import (
+    holiday_name,
+    run_uptime_schedule,
+    validate_user_uptime_schedule,
+)
+
+META = {"creationTimestamp": "2026-12-21T12:00:00Z"}
+CALENDAR = {
+    "metadata": {"name": "london"},
+    "spec": {
+        "holidays": [
+            {"date": "12-25", "name": "Christmas Day"},
+            {"date": "2026-12-28", "name": "Boxing Day (substitute)"},
+        ]
+    },
+}
+
+
+def _spec(**schedule):
+    return {
+        "lifecycle": {
+            "uptimeSchedule": {"start": "0 8 * * 1-5", "stop": "0 19 * * 1-5", **schedule}
+        }
+    }
+
+
+def _status(last_check):
+    return {"uptimeSchedule": {"lastCheckTime": last_check}}
+
+
+def _utc(*args):
+    return datetime(*args, tzinfo=timezone.utc)
+
+
+def test_validate_user_uptime_schedule():
+    validate_user_uptime_schedule(_spec(timeZone="Europe/London"))
+    validate_user_uptime_schedule({"lifecycle": {"uptimeSchedule": {"stop": "@daily"}}})
+    with pytest.raises(ValueError, match="start or a stop"):
+        validate_user_uptime_schedule({"lifecycle": {"uptimeSchedule": {"calendar": "london"}}})
+    with pytest.raises(ValueError, match="cron"):
+        validate_user_uptime_schedule(_spec(start="0 8 * *"))
+    with pytest.raises(ValueError, match="timeZone"):
+        validate_user_uptime_schedule(_spec(timeZone="Mars/Olympus_Mons"))
+
+
+def test_holiday_name_matches_dated_and_yearly_holidays():
+    assert holiday_name(CALENDAR, datetime(2027, 12, 25).date()) == "Christmas Day"
+    assert holiday_name(CALENDAR, datetime(2026, 12, 28).date()) == "Boxing Day (substitute)"
+    assert holiday_name(CALENDAR, datetime(2027, 12, 28).date()) is None
+    assert holiday_name(None, datetime(2027, 12, 25).date()) is None
+
+
+def test_stop_and_start_fire_once():
+    status = _status("2026-12-22T18:59:30Z")
+
+    hibernated, holiday, uptime_status = run_uptime_schedule(
+        _spec(), META, status, None, _utc(2026, 12, 22, 19, 0, 30)
+    )
+    assert hibernated is True and holiday is None
+    assert uptime_status["nextStart"] == "2026-12-23T08:00:00Z"
+    assert uptime_status["nextStop"] == "2026-12-23T19:00:00Z"
+
+    hibernated, _, _ = run_uptime_schedule(
+        _spec(), META, _status(uptime_status["lastCheckTime"]), None, _utc(2026, 12, 22, 19, 1, 30)
+    )
+    assert hibernated is None
+
+    hibernated, _, _ = run_uptime_schedule(
+        _spec(), META, _status("2026-12-23T07:59:30Z"), None, _utc(2026, 12, 23, 8, 0, 30)
+    )
+    assert hibernated is False
+
+
+def test_start_does_not_fire_on_holidays():
+    # Friday the 25th is Christmas, and Monday the 28th a substitute holiday
+    hibernated, holiday, uptime_status = run_uptime_schedule(
+        _spec(), META, _status("2026-12-25T07:59:30Z"), CALENDAR, _utc(2026, 12, 25, 8, 0, 30)
+    )
+
+    assert hibernated is None
+    assert holiday == "Christmas Day"
+    assert uptime_status["nextStart"] == "2026-12-29T08:00:00Z"
+
+
+def test_schedule_runs_in_its_time_zone():
+    spec = _spec(timeZone="America/New_York")
+
+    hibernated, _, uptime_status = run_uptime_schedule(
+        spec, META, _status("2026-12-22T12:59:30Z"), None, _utc(2026, 12, 22, 13, 0, 30)
+    )
+
+    assert hibernated is False
+    assert uptime_status["nextStop"] == "2026-12-23T00:00:00Z"
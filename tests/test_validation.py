@@ -0,0 +1,141 @@
+from unittest.mock import AsyncMock, MagicMock, patch
+
+import kopf
+import pytest
+
+from devservers.operator.devserver import handler
+from devservers.operator.devserver.service_account import validate_user_role_template
+from devservers.operator.devserver.validation import (
+    validate_allowed_images,
+    validate_flavor_deprecation,
+    validate_service_account,
+)
+
+DEPRECATED_FLAVOR = {"spec": {"deprecated": True, "replacement": "gpu-h100"}}
+
+
+@pytest.mark.parametrize("old_spec", [None, {"flavor": "gpu-a100"}])
+def test_validate_flavor_deprecation_blocks_new_devservers(old_spec):
+    with pytest.raises(kopf.PermanentError, match="use 'gpu-h100' instead"):
+        validate_flavor_deprecation(
+            {"flavor": "gpu-v100"}, old_spec, DEPRECATED_FLAVOR, MagicMock()
+        )
+
+
+@pytest.mark.asyncio
+async def test_admit_devserver_flavor_rejects_deprecated_flavors_for_new_devservers():
+    flavor = {"metadata": {"name": "gpu-v100"}, **DEPRECATED_FLAVOR}
+    spec = {"flavor": "gpu-v100"}
+
+    with patch.object(handler, "get_flavor", AsyncMock(return_value=flavor)):
+        with pytest.raises(kopf.AdmissionError, match="use 'gpu-h100' instead"):
+            await handler.admit_devserver_flavor(spec, "dev", "CREATE", MagicMock())
+        with pytest.raises(kopf.AdmissionError):
+            await handler.admit_devserver_flavor(
+                spec, "dev", "UPDATE", MagicMock(), old={"spec": {"flavor": "gpu-a100"}}
+            )
+        await handler.admit_devserver_flavor(spec, "dev", "UPDATE", MagicMock(), old={"spec": spec})
+
+
+def test_validate_flavor_deprecation_keeps_existing_devservers():
+    validate_flavor_deprecation(
+        {"flavor": "gpu-v100"}, {"flavor": "gpu-v100"}, DEPRECATED_FLAVOR, MagicMock()
+    )
+    validate_flavor_deprecation({"flavor": "cpu-small"}, None, {"spec": {}}, MagicMock())
+
+
+CUDA_FLAVOR = {"spec": {"allowedImages": ["ghcr.io/acme/cuda-*:*", "sha256:abc123"]}}
+
+
+@pytest.mark.parametrize(
+    "spec",
+    [
+        {"image": "ghcr.io/acme/cuda-12:latest"},
+        {"image": "registry.example.com/anything@sha256:abc123"},
+        {
+            "image": "ghcr.io/acme/cuda-12:latest",
+            "sidecars": [{"name": "logs", "image": "ghcr.io/acme/cuda-logs:1"}],
+        },
+    ],
+)
+def test_validate_allowed_images_allows_matching_images(spec):
+    validate_allowed_images({"flavor": "gpu", **spec}, CUDA_FLAVOR, MagicMock())
+
+
+@pytest.mark.parametrize(
+    "spec",
+    [
+        {},
+        {"image": "docker.io/library/ubuntu:22.04"},
+        {
+            "image": "ghcr.io/acme/cuda-12:latest",
+            "initContainers": [{"name": "fetch", "image": "curlimages/curl"}],
+        },
+    ],
+)
+def test_validate_allowed_images_rejects_other_images(spec):
+    with pytest.raises(kopf.PermanentError):
+        validate_allowed_images({"flavor": "gpu", **spec}, CUDA_FLAVOR, MagicMock())
+
+
+@pytest.mark.asyncio
+async def test_admit_devserver_images_rejects_images_the_flavor_does_not_allow():
+    flavor = {"metadata": {"name": "gpu"}, **CUDA_FLAVOR}
+
+    with patch.object(handler, "get_flavor", AsyncMock(return_value=flavor)):
+        await handler.admit_devserver_images(
+            {"flavor": "gpu", "image": "ghcr.io/acme/cuda-12:latest"}, "dev", "CREATE", MagicMock()
+        )
+        with pytest.raises(kopf.AdmissionError, match="not allowed by flavor 'gpu'"):
+            await handler.admit_devserver_images(
+                {"flavor": "gpu", "image": "ubuntu:22.04"}, "dev", "CREATE", MagicMock()
+            )
+
+
+def test_validate_user_role_template_allows_listed_templates():
+    spec = {"serviceAccount": {"roleTemplate": "devserver-view"}}
+
+    validate_user_role_template(spec, ["devserver-view", "devserver-edit"])
+    validate_user_role_template({}, [])
+
+
+@pytest.mark.parametrize("role_templates", [[], ["devserver-view"]])
+def test_validate_user_role_template_rejects_other_templates(role_templates):
+    spec = {"serviceAccount": {"roleTemplate": "cluster-admin"}}
+
+    with pytest.raises(ValueError):
+        validate_user_role_template(spec, role_templates)
+
+
+def test_validate_service_account_rejects_templates_the_operator_does_not_allow():
+    spec = {"serviceAccount": {"roleTemplate": "cluster-admin"}}
+
+    with pytest.raises(kopf.PermanentError, match="Invalid serviceAccount"):
+        validate_service_account(spec, MagicMock())
+
+
+@pytest.mark.asyncio
+async def test_admit_devserver_host_access_checks_the_requesting_user():
+    flavor = {
+        "metadata": {"name": "gpu-rdma"},
+        "spec": {"hostAccess": {"hostNetwork": True, "allowedOwners": ["alice"]}},
+    }
+    custom_objects_api = MagicMock()
+    custom_objects_api.list_cluster_custom_object.return_value = {"items": []}
+    # bob names alice as the owner, hoping to get her access
+    spec = {"flavor": "gpu-rdma", "owner": "alice", "hostNetwork": True}
+
+    with patch.object(handler, "get_flavor", AsyncMock(return_value=flavor)), patch.object(
+        handler.client, "CustomObjectsApi", lambda: custom_objects_api
+    ):
+        await handler.admit_devserver_host_access(
+            spec, "dev", {"username": "alice"}, "CREATE", MagicMock()
+        )
+        with pytest.raises(kopf.AdmissionError):
+            await handler.admit_devserver_host_access(
+                spec, "dev", {"username": "bob"}, "CREATE", MagicMock()
+            )
+        # E.g. the operator updating the DevServer
+        await handler.admit_devserver_host_access(
+            spec, "dev", {"username": "operator"}, "UPDATE", MagicMock(), old={"spec": spec}
+        )
@@ -0,0 +1,84 @@
+import asyncio
+
+import aiohttp
+import pytest
+from aiohttp import web
+
+from devservers.cli.ssh_config import devserver_tunnel_url
+from devservers.gateway import server
+
+
+def test_parse_subdomain():
+    assert server.parse_subdomain("my-dev.dev-alice.ssh.example.com", "ssh.example.com") == (
+        "dev-alice",
+        "my-dev",
+    )
+    assert server.parse_subdomain("My-Dev.dev-alice.ssh.example.com:443", "ssh.example.com") == (
+        "dev-alice",
+        "my-dev",
+    )
+    assert server.parse_subdomain("dev-alice.ssh.example.com", "ssh.example.com") is None
+    assert server.parse_subdomain("my-dev.dev-alice.example.org", "ssh.example.com") is None
+
+
+def test_devserver_tunnel_url():
+    assert (
+        devserver_tunnel_url("wss://gw.example.com/", "my-dev", "dev-alice")
+        == "wss://gw.example.com/ssh/dev-alice/my-dev"
+    )
+
+
+@pytest.mark.asyncio
+async def test_tunnel_relays_bytes(monkeypatch):
+    async def echo(reader, writer):
+        while data := await reader.read(1024):
+            writer.write(data)
+            await writer.drain()
+        writer.close()
+
+    echo_server = await asyncio.start_server(echo, "127.0.0.1", 0)
+    echo_port = echo_server.sockets[0].getsockname()[1]
+
+    async def resolve_target(namespace, name):
+        assert (namespace, name) == ("dev-alice", "my-dev")
+        return "127.0.0.1", echo_port
+
+    monkeypatch.setattr(server, "resolve_target", resolve_target)
+
+    runner = web.AppRunner(server.create_app())
+    await runner.setup()
+    site = web.TCPSite(runner, "127.0.0.1", 0)
+    await site.start()
+    port = runner.addresses[0][1]
+
+    try:
+        async with aiohttp.ClientSession() as session:
+            async with session.ws_connect(f"http://127.0.0.1:{port}/ssh/dev-alice/my-dev") as ws:
+                await ws.send_bytes(b"SSH-2.0-test\r\n")
+                msg = await asyncio.wait_for(ws.receive(), timeout=5)
+                assert msg.data == b"SSH-2.0-test\r\n"
+    finally:
+        await runner.cleanup()
+        echo_server.close()
+        await echo_server.wait_closed()
+
+
+@pytest.mark.asyncio
+async def test_tunnel_to_missing_devserver_is_not_found(monkeypatch):
+    async def resolve_target(namespace, name):
+        raise server.TargetNotFound("not found")
+
+    monkeypatch.setattr(server, "resolve_target", resolve_target)
+
+    runner = web.AppRunner(server.create_app())
+    await runner.setup()
+    site = web.TCPSite(runner, "127.0.0.1", 0)
+    await site.start()
+    port = runner.addresses[0][1]
+
+    try:
+        async with aiohttp.ClientSession() as session:
+            async with session.get(f"http://127.0.0.1:{port}/ssh/dev-alice/missing") as response:
+                assert response.status == 404
+    finally:
+        await runner.cleanup()